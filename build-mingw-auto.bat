@@ -45,10 +45,13 @@ if "%GIT_COMMIT%"=="" set "GIT_COMMIT=unknown"
 
 set "LDFLAGS=-X whep/internal/version.BuildNumber=%BUILD_NO% -X whep/internal/version.GitCommit=%GIT_COMMIT%"
 
-rem Set up paths
-set "NDI_SDK_DIR=C:\Program Files\NDI\NDI 6 SDK"
+rem Set up paths. NDI_SDK_DIR from the environment wins; otherwise the
+rem default NDI 6 and then NDI 5 install locations are tried
+if not defined NDI_SDK_DIR if exist "C:\Program Files\NDI\NDI 6 SDK\Include" set "NDI_SDK_DIR=C:\Program Files\NDI\NDI 6 SDK"
+if not defined NDI_SDK_DIR if exist "C:\Program Files\NDI\NDI 5 SDK\Include" set "NDI_SDK_DIR=C:\Program Files\NDI\NDI 5 SDK"
+if not defined NDI_SDK_DIR set "NDI_SDK_DIR=C:\Program Files\NDI\NDI 6 SDK"
 set "NDI_INCLUDE=%NDI_SDK_DIR%\Include"
-set "NDI_LIB64=%NDI_SDK_DIR%\Lib\x64"
+set "NDI_BIN64=%NDI_SDK_DIR%\Bin\x64"
 set "LIBVPX_PATH=%CD%\3pp\libvpx"
 set "LIBYUV_PATH=%CD%\3pp\libyuv"
 
@@ -65,13 +68,26 @@ if not exist "%NDI_INCLUDE%\Processing.NDI.Lib.h" (
     set "NDI_AVAILABLE=1"
 )
 
-if not exist "%NDI_LIB64%\Processing.NDI.Lib.x64.lib" (
-    echo [WARN] NDI library not found at "%NDI_LIB64%\Processing.NDI.Lib.x64.lib"
-    echo       Please install NDI SDK from https://ndi.video/
-    set "NDI_AVAILABLE=0"
+rem The SDK's major version, from its runtime folder variable
+rem (#define NDILIB_REDIST_FOLDER "NDI_RUNTIME_DIR_V6"); the build refuses
+rem majors it doesn't support with a clear error
+set "NDI_REDIST="
+set "NDI_SDK_MAJOR="
+if exist "%NDI_INCLUDE%\Processing.NDI.Lib.h" (
+    for /f "tokens=3" %%v in ('findstr /c:"define NDILIB_REDIST_FOLDER" "%NDI_INCLUDE%\Processing.NDI.Lib.h"') do set "NDI_REDIST=%%~v"
+)
+if defined NDI_REDIST set "NDI_SDK_MAJOR=%NDI_REDIST:*_V=%"
+if defined NDI_SDK_MAJOR echo [✓] NDI SDK major version %NDI_SDK_MAJOR% at "%NDI_SDK_DIR%"
+
+rem Only the headers are built against: whep.exe loads
+rem Processing.NDI.Lib.x64.dll when it starts, from NDI_RUNTIME_DIR, the NDI
+rem Runtime's folder, its own folder or the SDK's Bin\x64 (passed below)
+if not exist "%NDI_BIN64%\Processing.NDI.Lib.x64.dll" (
+    echo [INFO] No NDI runtime DLL at "%NDI_BIN64%"; whep.exe needs the NDI Runtime to receive NDI
 ) else (
-    echo [✓] NDI library found
+    echo [✓] NDI runtime DLL found
 )
+set "LDFLAGS=%LDFLAGS% -X 'whep/internal/ndi.sdkLibDir=%NDI_BIN64%'"
 
 rem Check libvpx
 set "VPX_AVAILABLE=0"
@@ -149,7 +165,6 @@ echo Attempting to resolve path issues...
 
 rem Try to convert to 8.3 format to avoid spaces
 for %%i in ("%NDI_INCLUDE%") do set "NDI_INCLUDE_SAFE=%%~si"
-for %%i in ("%NDI_LIB64%") do set "NDI_LIB64_SAFE=%%~si"
 for %%i in ("%LIBVPX_PATH%") do set "LIBVPX_PATH_SAFE=%%~si"
 
 rem Determine libvpx include dir (include or src) based on earlier detection
@@ -161,7 +176,6 @@ for %%i in ("%LIBYUV_PATH%\lib") do set "LIBYUV_LIB_SAFE=%%~si"
 
 rem Fallback to original if 8.3 conversion fails
 if "%NDI_INCLUDE_SAFE%"=="" set "NDI_INCLUDE_SAFE=%NDI_INCLUDE%"
-if "%NDI_LIB64_SAFE%"=="" set "NDI_LIB64_SAFE=%NDI_LIB64%"
 if "%LIBVPX_PATH_SAFE%"=="" set "LIBVPX_PATH_SAFE=%LIBVPX_PATH%"
 if "%LIBYUV_PATH_SAFE%"=="" set "LIBYUV_PATH_SAFE=%LIBYUV_PATH%"
 if "%LIBYUV_INCLUDE_SAFE%"=="" set "LIBYUV_INCLUDE_SAFE=%LIBYUV_PATH%\src\include"
@@ -170,13 +184,14 @@ if "%LIBYUV_LIB_SAFE%"=="" set "LIBYUV_LIB_SAFE=%LIBYUV_PATH%\lib"
 rem CGO flags using safe paths and proper Windows library linking
 rem Add flags for Windows mingw runtime and libvpx
 set "CGO_CFLAGS=-I%NDI_INCLUDE_SAFE% -I%LIBVPX_INCLUDE_SAFE% -I%LIBYUV_INCLUDE_SAFE%"
+if defined NDI_SDK_MAJOR set "CGO_CFLAGS=%CGO_CFLAGS% -DWHEP_NDI_SDK_MAJOR=%NDI_SDK_MAJOR%"
 rem Prefer static libvpx if available to avoid ABI mismatches with import libs
 if exist "%LIBVPX_PATH_SAFE%\lib\libvpx.a" (
   set "VPX_LINK=-l:libvpx.a"
 ) else (
   set "VPX_LINK=-lvpx"
 )
-set "CGO_LDFLAGS=-L%LIBVPX_PATH_SAFE%\lib -L%LIBYUV_LIB_SAFE% -L%NDI_LIB64_SAFE% -l:Processing.NDI.Lib.x64.lib -L%LIBVPX_PATH_SAFE%\lib %VPX_LINK% -L%LIBYUV_LIB_SAFE% -lyuv -lmingwex -lmingw32 -lwinmm -lmsvcrt -luser32 -luuid"
+set "CGO_LDFLAGS=-L%LIBVPX_PATH_SAFE%\lib -L%LIBYUV_LIB_SAFE% -L%LIBVPX_PATH_SAFE%\lib %VPX_LINK% -L%LIBYUV_LIB_SAFE% -lyuv -lmingwex -lmingw32 -lwinmm -lmsvcrt -luser32 -luuid"
 
 rem Allow all CGO flags (MinGW is more permissive than MSVC)
 set "CGO_CFLAGS_ALLOW=.*"
@@ -184,7 +199,7 @@ set "CGO_LDFLAGS_ALLOW=.*"
 set "CGO_CXXFLAGS_ALLOW=.*"
 
 rem Add runtime library paths
-set "PATH=%CD%;%NDI_LIB64%;%LIBVPX_PATH%\lib;%LIBYUV_PATH%\lib;%PATH%"
+set "PATH=%CD%;%LIBVPX_PATH%\lib;%LIBYUV_PATH%\lib;%PATH%"
 
 rem Display configuration
 echo.
@@ -193,7 +208,6 @@ echo   CGO_ENABLED=%CGO_ENABLED%
 echo   CC=%CC%
 echo   CXX=%CXX%
 echo   NDI_INCLUDE_SAFE=%NDI_INCLUDE_SAFE%
-echo   NDI_LIB64_SAFE=%NDI_LIB64_SAFE%
 echo   LIBVPX_PATH_SAFE=%LIBVPX_PATH_SAFE%
 echo   LIBVPX_INCLUDE_SAFE=%LIBVPX_INCLUDE_SAFE%
 echo   CGO_CFLAGS=%CGO_CFLAGS%
@@ -0,0 +1,92 @@
+#!/usr/bin/env bash
+# WHEP build script for Linux and macOS. Builds ./whep with NDI when the SDK
+# is found, plus libvpx and libyuv when pkg-config knows them. Set
+# NDI_SDK_DIR to an unpacked NDI SDK (the folder holding include/ and lib/);
+# without it the default locations are used: /usr and /usr/local on Linux,
+# /Library/NDI SDK for Apple on macOS. Only the SDK's headers are built
+# against: libndi is loaded when the server starts, from NDI_RUNTIME_DIR,
+# the SDK's library folder found here, or the system's library path.
+# Extra tags go in TAGS, e.g. TAGS="opus" ./build-unix.sh
+set -euo pipefail
+cd "$(dirname "$0")"
+
+if ! command -v go >/dev/null 2>&1; then
+    echo "[ERROR] Go not found in PATH; install it from https://go.dev/dl/" >&2
+    exit 1
+fi
+if ! command -v cc >/dev/null 2>&1; then
+    echo "[ERROR] No C compiler (cc) in PATH; cgo needs one (build-essential, or Xcode's command line tools)" >&2
+    exit 1
+fi
+
+# Build number and commit, as build-mingw-auto.bat sets them
+BUILD_FILE=build-number.txt
+BUILD_NO=$(cat "$BUILD_FILE" 2>/dev/null || echo 0)
+BUILD_NO=$((BUILD_NO + 1))
+echo "$BUILD_NO" > "$BUILD_FILE"
+GIT_COMMIT=$(git rev-parse --short HEAD 2>/dev/null || echo unknown)
+LDFLAGS="-X whep/internal/version.BuildNumber=$BUILD_NO -X whep/internal/version.GitCommit=$GIT_COMMIT"
+
+TAGS="${TAGS:-}"
+CFLAGS="${CGO_CFLAGS:-}"
+LDLIBS="${CGO_LDFLAGS:-}"
+
+# NDI SDK: headers under include/, libndi under a per-platform lib folder
+OS=$(uname -s)
+if [ -z "${NDI_SDK_DIR:-}" ] && [ "$OS" = Darwin ] && [ -d "/Library/NDI SDK for Apple" ]; then
+    NDI_SDK_DIR="/Library/NDI SDK for Apple"
+fi
+NDI_HEADER=""
+if [ -n "${NDI_SDK_DIR:-}" ]; then
+    NDI_HEADER="$NDI_SDK_DIR/include/Processing.NDI.Lib.h"
+    if [ "$OS" = Darwin ]; then
+        NDI_LIB="$NDI_SDK_DIR/lib/macOS"
+    else
+        NDI_LIB="$NDI_SDK_DIR/lib/$(uname -m)-linux-gnu"
+        if [ ! -d "$NDI_LIB" ]; then
+            lib=$(find "$NDI_SDK_DIR/lib" -name 'libndi.so*' 2>/dev/null | head -n1)
+            [ -n "$lib" ] && NDI_LIB=$(dirname "$lib")
+        fi
+    fi
+    # Quoted: the macOS SDK's folder has spaces
+    CFLAGS="$CFLAGS '-I$NDI_SDK_DIR/include'"
+    # Searched at run time, after NDI_RUNTIME_DIR
+    LDFLAGS="$LDFLAGS -X 'whep/internal/ndi.sdkLibDir=$NDI_LIB'"
+else
+    for d in /usr/include /usr/local/include; do
+        [ -f "$d/Processing.NDI.Lib.h" ] && NDI_HEADER="$d/Processing.NDI.Lib.h" && break
+    done
+fi
+if [ -n "$NDI_HEADER" ] && [ -f "$NDI_HEADER" ]; then
+    # The SDK's major version, from its runtime folder variable
+    # (#define NDILIB_REDIST_FOLDER "NDI_RUNTIME_DIR_V6"); the build refuses
+    # majors it doesn't support with a clear error
+    NDI_SDK_MAJOR=$(sed -n 's/.*define NDILIB_REDIST_FOLDER.*_V\([0-9]*\)".*/\1/p' "$NDI_HEADER" | head -n1)
+    echo "[✓] NDI SDK ${NDI_SDK_MAJOR:+major version $NDI_SDK_MAJOR }at $(dirname "$(dirname "$NDI_HEADER")")"
+    [ -n "$NDI_SDK_MAJOR" ] && CFLAGS="$CFLAGS -DWHEP_NDI_SDK_MAJOR=$NDI_SDK_MAJOR"
+    TAGS="$TAGS ndi"
+else
+    echo "[WARN] NDI SDK not found; set NDI_SDK_DIR to receive NDI sources (https://ndi.video/for-developers/ndi-sdk/)"
+fi
+
+# libvpx and libyuv from the system
+if command -v pkg-config >/dev/null 2>&1 && pkg-config --exists vpx; then
+    echo "[✓] libvpx $(pkg-config --modversion vpx)"
+    CFLAGS="$CFLAGS $(pkg-config --cflags vpx)"
+    LDLIBS="$LDLIBS $(pkg-config --libs vpx)"
+    TAGS="$TAGS vpx"
+else
+    echo "[WARN] libvpx not found by pkg-config; VP8/VP9 encoding will not be available"
+fi
+if command -v pkg-config >/dev/null 2>&1 && pkg-config --exists libyuv; then
+    echo "[✓] libyuv"
+    CFLAGS="$CFLAGS $(pkg-config --cflags libyuv)"
+    LDLIBS="$LDLIBS $(pkg-config --libs libyuv)"
+    TAGS="$TAGS yuv"
+fi
+
+export CGO_ENABLED=1 CGO_CFLAGS="$CFLAGS" CGO_LDFLAGS="$LDLIBS"
+TAGS=$(echo $TAGS)
+echo "Building whep (tags: ${TAGS:-none})"
+go build -ldflags "$LDFLAGS" -tags "$TAGS" -o whep ./cmd/whep
+echo "[✓] Built ./whep"
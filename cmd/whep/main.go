@@ -6,13 +6,18 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
 
+	"whep/internal/logging"
+	"whep/internal/ndi"
 	"whep/internal/server"
+	"whep/internal/stream"
     "whep/internal/version"
 )
 
@@ -20,16 +25,73 @@ func main() {
     showVersion := flag.Bool("version", false, "print version and exit")
 	host := flag.String("host", getEnv("HOST", "0.0.0.0"), "bind host")
 	port := flag.Int("port", getEnvInt("PORT", 8000), "bind port")
-	fps := flag.Int("fps", getEnvInt("FPS", 30), "synthetic fps if used")
+	fps := flag.String("fps", getEnv("FPS", "source"), "frame rate: 30, 29.97 or 30000/1001; source encodes NDI sources at their own rate (30 without one)")
 	width := flag.Int("width", getEnvInt("VIDEO_WIDTH", 1280), "synthetic width")
 	height := flag.Int("height", getEnvInt("VIDEO_HEIGHT", 720), "synthetic height")
     bitrate := flag.Int("bitrate", getEnvInt("VIDEO_BITRATE_KBPS", 6000), "target video bitrate (kbps) for VP8/VP9")
     codec := flag.String("codec", getEnv("VIDEO_CODEC", "vp8"), "video codec: vp8, vp9, or av1")
-    hwaccel := flag.String("hwaccel", getEnv("VIDEO_HWACCEL", "none"), "hardware encoder: none, nvenc, qsv, amf")
+    hwaccel := flag.String("hwaccel", getEnv("VIDEO_HWACCEL", "none"), "hardware encoder: none or qsv (H.264 via Intel QuickSync)")
+    rotate := flag.Int("rotate", getEnvInt("VIDEO_ROTATE", 0), "turn the shared /whep picture clockwise: 0, 90, 180 or 270 (width/height are the turned size)")
+    keyint := flag.Int("keyint", getEnvInt("VIDEO_KEYINT", 0), "most frames between keyframes (0 = auto: 4 s for VP8/H.264, the encoder's default for VP9/AV1)")
+    rc := flag.String("rc", getEnv("VIDEO_RC", "cbr"), "rate control: cbr holds -bitrate; cq holds quality -crf with -bitrate as a cap (VP8/VP9/AV1)")
+    crf := flag.Int("crf", getEnvInt("VIDEO_CRF", stream.DefaultCRF), "quality in cq mode, 1 (best) to 63")
     vp8speed := flag.Int("vp8speed", getEnvInt("VIDEO_VP8_SPEED", 8), "VP8 cpu_used speed (0=best, 8=fastest)")
+    vp9speed := flag.Int("vp9speed", getEnvInt("VIDEO_VP9_SPEED", 8), "VP9 cpu-used speed (0=best, 9=fastest)")
+    vp9rowmt := flag.String("vp9rowmt", getEnv("VIDEO_VP9_ROW_MT", "on"), "VP9 row-based multithreading: on or off")
+    vp9tiles := flag.String("vp9tiles", getEnv("VIDEO_VP9_TILE_COLUMNS", "auto"), "VP9 tile columns as log2 (0..6), or auto to pick by width")
+    vp9aq := flag.Int("vp9aq", getEnvInt("VIDEO_VP9_AQ_MODE", 3), "VP9 adaptive quantization: 0 off, 1 variance, 2 complexity, 3 cyclic refresh")
+    av1preset := flag.String("av1preset", getEnv("VIDEO_AV1_PRESET", "auto"), "AV1 speed preset: SVT-AV1 0..13 or libaom cpu-used 0..9 (higher is faster), or auto for 8 and 6")
+    av1tiles := flag.String("av1tiles", getEnv("VIDEO_AV1_TILE_COLUMNS", "auto"), "AV1 tile columns as log2 (0..6), or auto to pick by width")
+    av1tilerows := flag.Int("av1tilerows", getEnvInt("VIDEO_AV1_TILE_ROWS", 0), "AV1 tile rows as log2 (0..6)")
+    av1lowlatency := flag.String("av1lowlatency", getEnv("VIDEO_AV1_LOW_LATENCY", "on"), "AV1 low latency (one frame out per frame in, no scene-cut keyframes): on or off")
+    benchVP9 := flag.Bool("bench-vp9", false, "benchmark VP9 at 1080p30 with libvpx's defaults and with the -vp9* tuning, print both and exit")
     vp8drop := flag.Int("vp8dropframe", getEnvInt("VIDEO_VP8_DROPFRAME", 25), "VP8 drop-frame threshold (0=off, higher drops more)")
-    color := flag.String("color", getEnv("NDI_RECV_COLOR", ""), "NDI receive color: bgra or uyvy (overrides NDI_RECV_COLOR)")
+    color := flag.String("color", getEnv("NDI_RECV_COLOR", ""), "NDI receive color: bgra, uyvy or fastest (overrides NDI_RECV_COLOR)")
     scaleFilter := flag.String("scaleFilter", getEnv("YUV_SCALE_FILTER", ""), "Scaling filter: NONE, LINEAR, BILINEAR, BOX (overrides YUV_SCALE_FILTER)")
+    yuvMatrix := flag.String("yuvMatrix", getEnv("YUV_MATRIX", ""), "RGB/YUV color matrix: bt601, bt709 or auto (bt709 from 720 lines up; overrides YUV_MATRIX)")
+    yuvRange := flag.String("yuvRange", getEnv("YUV_RANGE", ""), "YUV sample range: limited or full (overrides YUV_RANGE)")
+    skipUnchanged := flag.String("skip-unchanged", getEnv("VIDEO_SKIP_UNCHANGED", "on"), "encode a still picture once, then again each second: on or off for a constant frame cadence")
+    ndiBandwidth := flag.String("ndi-bandwidth", getEnv("NDI_RECV_BANDWIDTH", "highest"), "NDI receive bandwidth: highest for the full stream or lowest for the sender's proxy; mounts may pick their own with bandwidth=")
+    stallMs := flag.Int("source-stall-ms", getEnvInt("SOURCE_STALL_MS", 3000), "ms without an NDI frame before the source shows a signal-lost slate (0 = keep the last frame)")
+    audio := flag.String("audio", getEnv("WHEP_AUDIO", "on"), "send NDI audio as Opus: on or off")
+    bwe := flag.String("bwe", getEnv("WHEP_BWE", "on"), "adapt video bitrate to viewers' TWCC/REMB estimates: on or off")
+    minBitrate := flag.Int("min-bitrate", getEnvInt("VIDEO_MIN_BITRATE_KBPS", 500), "floor (kbps) for adaptive bitrate on shared encoders")
+    sloWindows := flag.String("slo-windows", getEnv("WHEP_SLO_WINDOWS", "1h,24h"), "rolling windows for availability reporting, e.g. 1h,24h")
+    logLevel := flag.String("log-level", getEnv("WHEP_LOG_LEVEL", "info"), "log level: debug, info, warn or error")
+    stateDir := flag.String("state-dir", getEnv("WHEP_STATE_DIR", "state"), "directory for SIGUSR2 CPU profiles and encoder benchmark baselines")
+    benchOnStart := flag.String("bench-on-start", getEnv("WHEP_BENCH_ON_START", "off"), "benchmark the encoder at startup and warn if it is slower than its baseline: on or off")
+    benchRegress := flag.Int("bench-regress-pct", getEnvInt("WHEP_BENCH_REGRESS_PCT", 25), "percent slower than its baseline an encoder benchmark may be before it is flagged")
+    sharedLinger := flag.String("shared-linger", getEnv("SHARED_PIPELINE_LINGER", "30s"), "keep the shared /whep pipeline running this long after its last session leaves, so a quick rejoin is instant (0 = stop at once)")
+    offerDedupe := flag.String("offer-dedupe", getEnv("WHEP_OFFER_DEDUPE", "30s"), "answer a repeated offer (same ICE ufrag and fingerprint) within this window from its first session instead of a new one (off = never)")
+    frameTTL := flag.String("frame-receiver-ttl", getEnv("WHEP_FRAME_RECEIVER_TTL", "10s"), "keep a /frame NDI receiver open this long between polls (negative = close after each request)")
+    sourceProbe := flag.String("ndi-source-probe", getEnv("NDI_SOURCE_PROBE", "off"), "briefly receive each discovered NDI source nothing else receives, to list its size and frame rate in /ndi/sources: on or off")
+    discoveryInterval := flag.String("ndi-discovery-interval", getEnv("NDI_DISCOVERY_INTERVAL", "2s"), "time between background NDI discovery passes")
+    discoveryIdle := flag.String("ndi-discovery-idle", getEnv("NDI_DISCOVERY_IDLE", "0"), "pause background NDI discovery after this long without sessions or API calls, until the next call (0 = never)")
+    tempRx := flag.Int("ndi-temp-receivers", getEnvInt("WHEP_NDI_TEMP_RECEIVERS", 4), "concurrent temporary NDI receivers (probes, /frame grabs); more wait their turn")
+    tempRate := flag.Float64("ndi-temp-rate", getEnvFloat("WHEP_NDI_TEMP_RATE", 2), "temporary NDI receivers and discovery passes started per second")
+    publicURL := flag.String("public-url", getEnv("WHEP_PUBLIC_URL", ""), "externally visible base URL for Location headers, e.g. https://example.com/live (default: from X-Forwarded-* or the request)")
+    adminToken := flag.String("admin-token", getEnv("WHEP_ADMIN_TOKEN", ""), "bearer token /admin/* requires (default: they only answer loopback clients)")
+    maxSockets := flag.Int("max-sockets", getEnvInt("WHEP_MAX_SOCKETS", 0), "soft cap on open sockets; new sessions get 503 past it (0 = unlimited)")
+    maxSessions := flag.Int("max-sessions", getEnvInt("WHEP_MAX_SESSIONS", 0), "max concurrent sessions; new ones get 503 past it (0 = unlimited)")
+    maxPerMount := flag.Int("max-sessions-per-mount", getEnvInt("WHEP_MAX_SESSIONS_PER_MOUNT", 0), "max concurrent sessions per source key, or on /whep (0 = unlimited)")
+    encCap := flag.Int("encoder-capacity", getEnvInt("WHEP_ENCODER_CAPACITY", 0), "video encoders that count as full load in /loadz (0 = one per two CPUs)")
+    memBudget := flag.Int("memory-budget-mb", getEnvInt("WHEP_MEMORY_BUDGET_MB", 0), "process memory budget in MB for /loadz (0 = use system memory in use)")
+    loadWeights := flag.String("load-weights", getEnv("WHEP_LOAD_WEIGHTS", "encoders=0.4,cpu=0.3,memory=0.15,sockets=0.15"), "load score weights: encoders, cpu, memory, sockets")
+    answerMode := flag.String("answer-mode", getEnv("WHEP_ANSWER_MODE", server.AnswerComplete), "when WHEP answers are sent: complete (after ICE gathering) or early (once host candidates are in)")
+    downgradeLoad := flag.Int("downgrade-load", getEnvInt("WHEP_DOWNGRADE_LOAD", 0), "load score (1-100) from which a request that would start a new mount steps down the ladder (0 = off)")
+    loadHdr := flag.String("load-header", getEnv("WHEP_LOAD_HEADER", "off"), "add the load score to WHEP 201 responses as X-Server-Load: on or off")
+    recordDir := flag.String("record-dir", getEnv("WHEP_RECORD_DIR", ""), "directory for mount recordings (default <state-dir>/recordings)")
+    recordMax := flag.Int("record-max-mb", getEnvInt("WHEP_RECORD_MAX_MB", 1024), "start a new recording file at the next keyframe past this size in MB (0 = never)")
+    ffmpeg := flag.String("ffmpeg", getEnv("WHEP_FFMPEG", ""), "ffmpeg RTSP cameras are read with, ffprobe beside it (default ffmpeg from PATH)")
+    stdinSource := flag.String("stdin-source", getEnv("WHEP_STDIN_SOURCE", ""), "read raw video frames in as the pipe source: WxH@FPS:bgra or WxH@FPS:i420")
+    pipeInput := flag.String("pipe-input", getEnv("WHEP_PIPE_INPUT", "-"), "where -stdin-source frames come from: - (stdin), a named pipe path, or tcp://host:port to listen on")
+    slate := flag.String("slate", getEnv("WHEP_SLATE", ""), "PNG or JPEG served as the slate source, and put on air by failover when every candidate is missing")
+    minFree := flag.Int("min-free-mb", getEnvInt("WHEP_MIN_FREE_MB", 1024), "refuse new recordings/dumps below this free disk space in MB (0 = off)")
+    stopFree := flag.Int("stop-free-mb", getEnvInt("WHEP_STOP_FREE_MB", 256), "stop active recordings/dumps below this free disk space in MB (0 = off)")
+    bcQueue := flag.Int("broadcast-queue", getEnvInt("BROADCAST_QUEUE", 4), "samples queued per viewer and per encoder writer")
+    bcPolicy := flag.String("broadcast-drop-policy", getEnv("BROADCAST_DROP_POLICY", "drop-newest"), "what a full sample queue drops: drop-newest or drop-oldest")
+    sourceOrder := flag.String("source-order", getEnv("WHEP_SOURCE_ORDER", "selection,url,name,first"), "order the shared pipeline tries sources: selection, url (NDI_SOURCE_URL), name (NDI_SOURCE), first (first discovered)")
+    configFile := flag.String("config", getEnv("WHEP_CONFIG", ""), "path to JSON config file (ladder, ...); reloaded on change")
     flag.Parse()
 
     if showVersion != nil && *showVersion {
@@ -37,6 +99,132 @@ func main() {
         return
     }
 
+    if err := stream.CheckEncoder(*codec, *hwaccel); err != nil {
+        log.Fatalf("-codec/-hwaccel: %v", err)
+    }
+
+    // Unset (source) leaves the rate to each pipeline's source
+    var rate stream.Rate
+    if v := strings.TrimSpace(*fps); v != "" && !strings.EqualFold(v, "source") {
+        r, err := stream.ParseRate(v)
+        if err != nil {
+            log.Fatalf("-fps: %v", err)
+        }
+        rate = r
+    }
+    weights, err := server.ParseLoadWeights(*loadWeights)
+    if err != nil {
+        log.Fatalf("-load-weights: %v", err)
+    }
+    if *downgradeLoad < 0 || *downgradeLoad > 100 {
+        log.Fatalf("-downgrade-load: want 0 to 100, got %d", *downgradeLoad)
+    }
+    answerModeV, err := server.ParseAnswerMode(*answerMode)
+    if err != nil {
+        log.Fatalf("-answer-mode: %v", err)
+    }
+    order, err := server.ParseSourceOrder(*sourceOrder)
+    if err != nil {
+        log.Fatalf("-source-order: %v", err)
+    }
+
+    lvl, err := logging.ParseLevel(*logLevel)
+    if err != nil {
+        log.Fatalf("-log-level: %v", err)
+    }
+    logging.Setup(lvl)
+
+    frameReceiverTTL, err := time.ParseDuration(*frameTTL)
+    if err != nil {
+        log.Fatalf("-frame-receiver-ttl: %v", err)
+    }
+    offerDedupeWindow := time.Duration(-1)
+    if !strings.EqualFold(*offerDedupe, "off") {
+        if offerDedupeWindow, err = time.ParseDuration(*offerDedupe); err != nil || offerDedupeWindow <= 0 {
+            log.Fatalf("-offer-dedupe: want a positive duration or off, got %q", *offerDedupe)
+        }
+    }
+    if frameReceiverTTL == 0 {
+        frameReceiverTTL = -1 // 0 in Config means the default
+    }
+    sharedLingerDur, err := time.ParseDuration(*sharedLinger)
+    if err != nil || sharedLingerDur < 0 {
+        log.Fatalf("-shared-linger: want a duration of 0 or more, got %q", *sharedLinger)
+    }
+    if sharedLingerDur == 0 {
+        sharedLingerDur = -1 // 0 in Config means the default
+    }
+    discoveryIntervalDur, err := time.ParseDuration(*discoveryInterval)
+    if err != nil || discoveryIntervalDur < 500*time.Millisecond {
+        log.Fatalf("-ndi-discovery-interval: want a duration of 500ms or more, got %q", *discoveryInterval)
+    }
+    discoveryIdleDur, err := time.ParseDuration(*discoveryIdle)
+    if err != nil || discoveryIdleDur < 0 {
+        log.Fatalf("-ndi-discovery-idle: want a duration of 0 or more, got %q", *discoveryIdle)
+    }
+
+    if *publicURL != "" {
+        u, err := url.Parse(*publicURL)
+        if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+            log.Fatalf("-public-url: want an absolute http(s) URL, got %q", *publicURL)
+        }
+    }
+
+    if *bcQueue < 1 {
+        log.Fatalf("-broadcast-queue: want at least 1, got %d", *bcQueue)
+    }
+    dropPolicy, err := stream.ParseDropPolicy(*bcPolicy)
+    if err != nil {
+        log.Fatalf("-broadcast-drop-policy: %v", err)
+    }
+
+    if *keyint < 0 {
+        log.Fatalf("-keyint must be >= 0 (got %d)", *keyint)
+    }
+    vp9, err := stream.ParseVP9Tuning(stream.DefaultVP9Tuning(), strconv.Itoa(*vp9speed), *vp9rowmt, *vp9tiles, strconv.Itoa(*vp9aq))
+    if err != nil {
+        log.Fatalf("-vp9*: %v", err)
+    }
+    av1, err := stream.ParseAV1Tuning(stream.DefaultAV1Tuning(), *av1preset, *av1tiles, strconv.Itoa(*av1tilerows), *av1lowlatency)
+    if err != nil {
+        log.Fatalf("-av1*: %v", err)
+    }
+    if *benchVP9 {
+        untuned, tuned, err := stream.CompareVP9Tuning(stream.PipelineConfig{Width: 1920, Height: 1080, FPS: stream.IntRate(30), BitrateKbps: *bitrate, VP9: &vp9}, 60)
+        if err != nil {
+            log.Fatalf("-bench-vp9: %v", err)
+        }
+        fmt.Printf("%s: %.2f ms/frame\n%s: %.2f ms/frame\n", untuned.Key(), untuned.MsPerFrame, tuned.Key(), tuned.MsPerFrame)
+        if tuned.MsPerFrame > 0 {
+            fmt.Printf("tuned is %.1fx the speed of libvpx's defaults\n", untuned.MsPerFrame/tuned.MsPerFrame)
+        }
+        return
+    }
+    rcMode, err := stream.ParseRateControl(*rc)
+    if err != nil {
+        log.Fatalf("-rc: %v", err)
+    }
+    if _, err := stream.ParseCRF(strconv.Itoa(*crf)); err != nil {
+        log.Fatalf("-crf: %v", err)
+    }
+    if _, err := stream.ParseOrientation(strconv.Itoa(*rotate), ""); err != nil {
+        log.Fatalf("-rotate: %v", err)
+    }
+
+    var pipeFormat *stream.PipeFormat
+    if *stdinSource != "" {
+        f, err := stream.ParsePipeFormat(*stdinSource)
+        if err != nil {
+            log.Fatalf("-stdin-source: %v", err)
+        }
+        pipeFormat = &f
+    }
+
+    windows, err := server.ParseSLOWindows(*sloWindows)
+    if err != nil {
+        log.Fatalf("-slo-windows: %v", err)
+    }
+
     // Apply -color override for NDI receive color format if provided
     if color != nil && *color != "" {
         c := *color
@@ -64,11 +252,27 @@ func main() {
         }
         _ = os.Setenv("YUV_SCALE_FILTER", v)
     }
+    if yuvMatrix != nil && *yuvMatrix != "" {
+        m, ok := stream.ParseColorMatrix(*yuvMatrix)
+        if !ok { log.Fatalf("-yuvMatrix: unknown matrix %q (bt601, bt709 or auto)", *yuvMatrix) }
+        _ = os.Setenv("YUV_MATRIX", m.String())
+    }
+    _ = os.Setenv("VIDEO_SKIP_UNCHANGED", *skipUnchanged)
+    if *stallMs < 0 { log.Fatalf("-source-stall-ms must be 0 or more") }
+    _ = os.Setenv("SOURCE_STALL_MS", strconv.Itoa(*stallMs))
+    bw, err := ndi.ParseBandwidth(*ndiBandwidth)
+    if err != nil { log.Fatalf("-ndi-bandwidth: %v", err) }
+    _ = os.Setenv("NDI_RECV_BANDWIDTH", bw)
+    if yuvRange != nil && *yuvRange != "" {
+        r, ok := stream.ParseColorRange(*yuvRange)
+        if !ok { log.Fatalf("-yuvRange: unknown range %q (limited or full)", *yuvRange) }
+        _ = os.Setenv("YUV_RANGE", r.String())
+    }
 
 	cfg := server.Config{
 		Host:        *host,
 		Port:        *port,
-		FPS:         *fps,
+		FPS:         rate,
 		Width:       *width,
 		Height:      *height,
         BitrateKbps: *bitrate,
@@ -76,6 +280,49 @@ func main() {
         HWAccel:     *hwaccel,
         VP8Speed:    *vp8speed,
         VP8Dropframe:*vp8drop,
+        Keyint:      *keyint,
+        RateControl: rcMode,
+        CRF:         *crf,
+        VP9:         &vp9,
+        AV1:         &av1,
+        Rotate:      *rotate,
+        ConfigFile:  *configFile,
+        SourceOrder: order,
+        Audio:       !strings.EqualFold(*audio, "off"),
+        BWE:         !strings.EqualFold(*bwe, "off"),
+        MinBitrateKbps: *minBitrate,
+        SLOWindows:  windows,
+        StateDir:    *stateDir,
+        BenchOnStart: strings.EqualFold(*benchOnStart, "on"),
+        BenchRegressPct: *benchRegress,
+        FrameReceiverTTL: frameReceiverTTL,
+        SharedLinger: sharedLingerDur,
+        OfferDedupeWindow: offerDedupeWindow,
+        PublicURL:   *publicURL,
+        AdminToken:  *adminToken,
+        Queue:       stream.QueueConfig{Depth: *bcQueue, Policy: dropPolicy},
+        TempReceivers:    *tempRx,
+        TempReceiverRate: *tempRate,
+        MaxSockets:  *maxSockets,
+        MaxSessions: *maxSessions,
+        MaxSessionsPerMount: *maxPerMount,
+        EncoderCapacity: *encCap,
+        MemoryBudgetMB: *memBudget,
+        LoadWeights: weights,
+        LoadHeader:  strings.EqualFold(*loadHdr, "on"),
+        DowngradeLoad: *downgradeLoad,
+        AnswerMode:  answerModeV,
+        RecordDir:   *recordDir,
+        RecordMaxMB: *recordMax,
+        MinFreeMB:   *minFree,
+        StopFreeMB:  *stopFree,
+        FFmpeg:      *ffmpeg,
+        Pipe:        pipeFormat,
+        PipeInput:   *pipeInput,
+        Slate:       *slate,
+        SourceProbe: strings.EqualFold(*sourceProbe, "on"),
+        DiscoveryInterval: discoveryIntervalDur,
+        DiscoveryIdle: discoveryIdleDur,
     }
 
 	mux := http.NewServeMux()
@@ -99,8 +346,13 @@ func main() {
 	log.Printf("Waiting for interrupt (PID=%d)...", os.Getpid())
 	s := <-sig
 	log.Printf("Signal received: %v, shutting down", s)
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
+	// Close sessions and pipelines first, while /health can still report
+	// "draining", then stop the HTTP server
+	if err := whep.Close(ctx); err != nil {
+		log.Printf("WHEP shutdown incomplete: %v", err)
+	}
 	_ = srv.Shutdown(ctx)
 }
 
@@ -111,6 +363,15 @@ func getEnv(key, def string) string {
 	return def
 }
 
+func getEnvFloat(key string, def float64) float64 {
+	if v := os.Getenv(key); v != "" {
+		if x, err := strconv.ParseFloat(v, 64); err == nil {
+			return x
+		}
+	}
+	return def
+}
+
 func getEnvInt(key string, def int) int {
 	if v := os.Getenv(key); v != "" {
 		var x int
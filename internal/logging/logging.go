@@ -0,0 +1,103 @@
+// Package logging holds the process log level. Setup routes the standard
+// log package through a slog handler whose level can be changed at runtime;
+// hot paths check DebugEnabled, a single atomic load, before formatting.
+package logging
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	level   slog.LevelVar
+	debugOn atomic.Bool
+
+	mu       sync.Mutex
+	base     slog.Level  // level to revert to
+	revertAt time.Time   // zero when no override is pending
+	revert   *time.Timer // pending revert of an override
+)
+
+// ParseLevel accepts debug, info, warn(ing) and error.
+func ParseLevel(v string) (slog.Level, error) {
+	switch strings.ToLower(strings.TrimSpace(v)) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "", "info":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return 0, fmt.Errorf("unknown log level %q (want debug, info, warn or error)", v)
+}
+
+// Setup installs the leveled handler as the slog default at l.
+func Setup(l slog.Level) {
+	mu.Lock()
+	base = l
+	mu.Unlock()
+	set(l)
+	// SetDefault also redirects the log package, at info level
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: &level})))
+}
+
+func set(l slog.Level) {
+	level.Set(l)
+	debugOn.Store(l <= slog.LevelDebug)
+}
+
+// SetLevel changes the level now. With ttl > 0 it reverts to the configured
+// level after ttl; otherwise the change becomes the new configured level.
+func SetLevel(l slog.Level, ttl time.Duration) {
+	mu.Lock()
+	defer mu.Unlock()
+	if revert != nil {
+		revert.Stop()
+		revert, revertAt = nil, time.Time{}
+	}
+	set(l)
+	if ttl <= 0 {
+		base = l
+		return
+	}
+	revertAt = time.Now().Add(ttl)
+	var t *time.Timer
+	t = time.AfterFunc(ttl, func() {
+		mu.Lock()
+		defer mu.Unlock()
+		if revert != t {
+			return
+		}
+		set(base)
+		revert, revertAt = nil, time.Time{}
+		slog.Info("log level reverted", "level", base.String())
+	})
+	revert = t
+}
+
+// Status reports the current and configured levels, and when an override
+// reverts (zero if none is pending).
+func Status() (current, configured slog.Level, until time.Time) {
+	mu.Lock()
+	defer mu.Unlock()
+	return level.Level(), base, revertAt
+}
+
+// DebugEnabled reports whether debug output is on. It is cheap enough for
+// per-frame paths.
+func DebugEnabled() bool { return debugOn.Load() }
+
+// Debugf logs at debug level. Callers on hot paths should check
+// DebugEnabled first so arguments aren't evaluated when it's off.
+func Debugf(format string, args ...any) {
+	if debugOn.Load() {
+		slog.Debug(fmt.Sprintf(format, args...))
+	}
+}
@@ -0,0 +1,31 @@
+package ndi
+
+import (
+    "fmt"
+    "os"
+    "strings"
+)
+
+// Receive bandwidths: the sender's full stream, or its low-resolution proxy
+// stream (typically 640 wide), a fraction of the network and decode cost
+// for thumbnails and multiviews.
+const (
+    BandwidthHighest = "highest"
+    BandwidthLowest  = "lowest"
+)
+
+// ParseBandwidth reads a bandwidth setting, highest or lowest in any case;
+// "" stays "", for the default.
+func ParseBandwidth(s string) (string, error) {
+    switch b := strings.ToLower(strings.TrimSpace(s)); b {
+    case "", BandwidthHighest, BandwidthLowest:
+        return b, nil
+    }
+    return "", fmt.Errorf("bandwidth must be %s or %s", BandwidthHighest, BandwidthLowest)
+}
+
+// DefaultBandwidth is NDI_RECV_BANDWIDTH, highest when unset or unknown.
+func DefaultBandwidth() string {
+    if b, err := ParseBandwidth(os.Getenv("NDI_RECV_BANDWIDTH")); err == nil && b != "" { return b }
+    return BandwidthHighest
+}
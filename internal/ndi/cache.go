@@ -1,19 +1,57 @@
 package ndi
 
 import (
+    "context"
     "log"
     "sync"
     "time"
 )
 
+// DefaultDiscoveryInterval spaces background discovery passes.
+const DefaultDiscoveryInterval = 2 * time.Second
+
+// backgroundScanMs is how long a background pass lists sources for.
+const backgroundScanMs = 2000
+
 type cacheState struct {
     mu       sync.RWMutex
     sources  []SourceInfo
+    seen     map[string]time.Time // by URL: the last pass that listed it
     started  bool
     quit     chan struct{}
+    done     chan struct{} // closed when the discovery goroutine exits
+    gen      uint64        // moves each time the list changes
+    changed  chan struct{} // closed when gen next moves
+    interval time.Duration // between background passes; 0 for the default
+    paused   time.Time     // when PauseDiscovery stopped background passes; zero while running
+    wake     chan struct{} // ResumeDiscovery's request for a pass now
+    // The last pass, of either kind
+    scans     uint64
+    lastAt    time.Time
+    lastTook  time.Duration
+    lastCount int
+    lastKind  string
+    prevCount int
+    scanMu    sync.Mutex // held by a pass, so passes don't overlap
 }
 
-var cs cacheState
+var cs = cacheState{prevCount: -1, wake: make(chan struct{}, 1)}
+
+// SetDiscoveryInterval sets how often background discovery runs a pass;
+// <= 0 is DefaultDiscoveryInterval. It applies from the next start.
+func SetDiscoveryInterval(d time.Duration) {
+    cs.mu.Lock()
+    cs.interval = d
+    cs.mu.Unlock()
+}
+
+// DiscoveryInterval is the time between background discovery passes.
+func DiscoveryInterval() time.Duration {
+    cs.mu.RLock()
+    defer cs.mu.RUnlock()
+    if cs.interval <= 0 { return DefaultDiscoveryInterval }
+    return cs.interval
+}
 
 // StartBackgroundDiscovery launches a background goroutine that refreshes the
 // NDI source list periodically. Safe to call multiple times; only starts once.
@@ -25,45 +63,150 @@ func StartBackgroundDiscovery() {
     }
     cs.started = true
     cs.quit = make(chan struct{})
+    cs.done = make(chan struct{})
+    quit, done := cs.quit, cs.done
     cs.mu.Unlock()
 
     go func() {
-        ticker := time.NewTicker(2 * time.Second)
+        defer close(done)
+        ticker := time.NewTicker(DiscoveryInterval())
         defer ticker.Stop()
-        prevCount := -1
+        // A pass waits its turn with temporary receivers so they don't
+        // all hit the network at once
+        ctx, cancel := context.WithCancel(context.Background())
+        defer cancel()
+        go func() { <-quit; cancel() }()
         for {
             select {
-            case <-cs.quit:
+            case <-quit:
                 return
             case <-ticker.C:
-                // Perform a thorough discovery attempt (2s)
-                srcs := ListSources(2000)
-                if srcs != nil {
-                    // Log only when the count changes to avoid spam
-                    if prevCount != len(srcs) {
-                        prevCount = len(srcs)
-                        log.Printf("NDI discovery: found %d source(s)", prevCount)
-                    }
-                    cs.mu.Lock()
-                    // copy to avoid races with underlying slice
-                    out := make([]SourceInfo, len(srcs))
-                    copy(out, srcs)
-                    cs.sources = out
-                    cs.mu.Unlock()
-                }
+            case <-cs.wake:
             }
+            if DiscoveryPaused() { continue }
+            if waitDiscovery(ctx) != nil { return }
+            scanSources(backgroundScanMs, "background")
         }
     }()
 }
 
-// StopBackgroundDiscovery stops the background discovery loop.
+// RefreshSources runs a discovery pass of timeoutMs now, ahead of
+// temporary receivers, and stores what it finds as background passes do:
+// a pass that finds nothing leaves the list as it was. It returns the
+// sources the pass found, and fails only with ctx.Err().
+func RefreshSources(ctx context.Context, timeoutMs int) ([]SourceInfo, error) {
+    if err := waitDiscovery(ctx); err != nil { return nil, err }
+    return scanSources(timeoutMs, "refresh"), nil
+}
+
+// scanSources runs a pass, after any running one, and stores its sources.
+func scanSources(timeoutMs int, kind string) []SourceInfo {
+    cs.scanMu.Lock()
+    defer cs.scanMu.Unlock()
+    start := time.Now()
+    // Perform a thorough discovery attempt
+    srcs := ListSources(timeoutMs)
+    cs.mu.Lock()
+    defer cs.mu.Unlock()
+    cs.scans++
+    cs.lastAt, cs.lastTook, cs.lastCount, cs.lastKind = start, time.Since(start), len(srcs), kind
+    if srcs == nil { return nil }
+    // Log only when the count changes to avoid spam
+    if cs.prevCount != len(srcs) {
+        cs.prevCount = len(srcs)
+        log.Printf("NDI discovery: found %d source(s)", cs.prevCount)
+    }
+    // copy to avoid races with underlying slice
+    out := make([]SourceInfo, len(srcs))
+    copy(out, srcs)
+    now, seen := time.Now(), make(map[string]time.Time, len(out))
+    for _, si := range out { seen[si.URL] = now }
+    cs.seen = seen
+    if !sameSources(cs.sources, out) {
+        cs.gen++
+        if cs.changed != nil { close(cs.changed); cs.changed = nil }
+    }
+    cs.sources = out
+    return srcs
+}
+
+// PauseDiscovery stops background passes until ResumeDiscovery; the cached
+// list stays as it was. It reports whether discovery was running.
+func PauseDiscovery() bool {
+    cs.mu.Lock()
+    defer cs.mu.Unlock()
+    if !cs.started || !cs.paused.IsZero() { return false }
+    cs.paused = time.Now()
+    return true
+}
+
+// ResumeDiscovery restarts background passes, with one right away. It
+// reports whether discovery was paused.
+func ResumeDiscovery() bool {
+    cs.mu.Lock()
+    defer cs.mu.Unlock()
+    if cs.paused.IsZero() { return false }
+    cs.paused = time.Time{}
+    select {
+    case cs.wake <- struct{}{}:
+    default:
+    }
+    return true
+}
+
+// DiscoveryPaused reports whether PauseDiscovery holds background passes.
+func DiscoveryPaused() bool {
+    cs.mu.RLock()
+    defer cs.mu.RUnlock()
+    return !cs.paused.IsZero()
+}
+
+// DiscoveryStatus is background discovery's state and its last pass.
+type DiscoveryStatus struct {
+    Running     bool       `json:"running"`
+    Paused      bool       `json:"paused"`
+    PausedSince *time.Time `json:"pausedSince,omitempty"`
+    IntervalMs  int64      `json:"intervalMs"`
+    Scans       uint64     `json:"scans"`
+    LastScan    *time.Time `json:"lastScan,omitempty"`
+    LastScanMs  int64      `json:"lastScanMs"`
+    LastCount   int        `json:"lastCount"`
+    LastKind    string     `json:"lastKind,omitempty"` // "background" or "refresh"
+    Sources     int        `json:"sources"`             // in the cached list
+}
+
+// GetDiscoveryStatus returns background discovery's state and its last pass.
+func GetDiscoveryStatus() DiscoveryStatus {
+    iv := DiscoveryInterval()
+    cs.mu.RLock()
+    defer cs.mu.RUnlock()
+    st := DiscoveryStatus{Running: cs.started, Paused: !cs.paused.IsZero(), IntervalMs: iv.Milliseconds(), Scans: cs.scans, LastScanMs: cs.lastTook.Milliseconds(), LastCount: cs.lastCount, LastKind: cs.lastKind, Sources: len(cs.sources)}
+    if st.Paused { at := cs.paused.UTC(); st.PausedSince = &at }
+    if !cs.lastAt.IsZero() { at := cs.lastAt.UTC(); st.LastScan = &at }
+    return st
+}
+
+// StopBackgroundDiscovery stops the background discovery loop and waits for
+// an in-flight discovery (up to ~2s) to release its finder.
 func StopBackgroundDiscovery() {
     cs.mu.Lock()
+    var done chan struct{}
     if cs.started {
         close(cs.quit)
         cs.started = false
+        cs.paused = time.Time{}
+        done = cs.done
     }
     cs.mu.Unlock()
+    if done != nil {
+        <-done
+    }
+}
+
+func discoveryStarted() bool {
+    cs.mu.RLock()
+    defer cs.mu.RUnlock()
+    return cs.started
 }
 
 // GetCachedSources returns the most recently discovered sources.
@@ -75,3 +218,30 @@ func GetCachedSources() []SourceInfo {
     copy(out, cs.sources)
     return out
 }
+
+// LastSeen reports when discovery last listed the source at url; false if
+// the latest list doesn't have it.
+func LastSeen(url string) (time.Time, bool) {
+    cs.mu.RLock()
+    defer cs.mu.RUnlock()
+    t, ok := cs.seen[url]
+    return t, ok
+}
+
+// SourcesChanged returns the generation of the cached source list, which
+// moves each time discovery finds a different list, and a channel closed
+// when it next moves.
+func SourcesChanged() (gen uint64, ch <-chan struct{}) {
+    cs.mu.Lock()
+    defer cs.mu.Unlock()
+    if cs.changed == nil { cs.changed = make(chan struct{}) }
+    return cs.gen, cs.changed
+}
+
+func sameSources(a, b []SourceInfo) bool {
+    if len(a) != len(b) { return false }
+    for i := range a {
+        if a[i] != b[i] { return false }
+    }
+    return true
+}
@@ -0,0 +1,155 @@
+package ndi
+
+import (
+    "fmt"
+    "strconv"
+    "strings"
+    "sync"
+)
+
+// minRuntimeMajor is the oldest NDI runtime with the v3 receive API used here.
+const minRuntimeMajor = 5
+
+// SDKInfo describes the NDI runtime this process sees, for /health, /config
+// and startup logs.
+type SDKInfo struct {
+    // Compiled is false for builds without NDI support: without cgo, or on
+    // Linux and macOS without the ndi tag
+    Compiled bool `json:"compiled"`
+    // Initialized is the result of the last Initialize; Attempted is false
+    // until it has been called
+    Initialized bool `json:"initialized"`
+    Attempted   bool `json:"attempted"`
+    // Version is NDIlib_version(), e.g. "NDI SDK WIN64 ... 6.0.1.0"
+    Version string `json:"version,omitempty"`
+    // BuildMajor is the major version of the SDK this binary was compiled
+    // against; Mismatch is set when the runtime's differs
+    BuildMajor int  `json:"buildMajor,omitempty"`
+    Mismatch   bool `json:"sdkMismatch,omitempty"`
+    // RuntimePath is the file the runtime library was loaded from;
+    // Searched lists where it was looked for when no copy would load
+    RuntimePath  string   `json:"runtimePath,omitempty"`
+    Searched     []string `json:"searched,omitempty"`
+    CPUSupported bool     `json:"cpuSupported"`
+    // Message says what is wrong and how to fix it; empty when NDI is usable
+    Message string `json:"message,omitempty"`
+}
+
+// OK reports whether NDI is compiled in and initialized.
+func (i SDKInfo) OK() bool { return i.Compiled && i.Initialized }
+
+var initState struct {
+    mu        sync.Mutex
+    attempted bool
+    ok        bool
+}
+
+// initCall serializes Initialize, so callers arriving while a slow first
+// init runs wait for its result instead of starting another.
+var initCall sync.Mutex
+
+// initOnce runs init unless an earlier call succeeded.
+func initOnce(init func() bool) bool {
+    initCall.Lock()
+    defer initCall.Unlock()
+    initState.mu.Lock()
+    ok := initState.ok
+    initState.mu.Unlock()
+    if ok { return true }
+    ok = init()
+    recordInit(ok)
+    return ok
+}
+
+func recordInit(ok bool) {
+    initState.mu.Lock()
+    initState.attempted, initState.ok = true, ok
+    initState.mu.Unlock()
+}
+
+// initFailed reports whether Initialize ran and failed, in which case no SDK
+// call can succeed.
+func initFailed() bool {
+    initState.mu.Lock()
+    defer initState.mu.Unlock()
+    return initState.attempted && !initState.ok
+}
+
+// NDI readiness reported by State
+const (
+    StateInitializing = "initializing"
+    StateReady        = "ready"
+    StateUnavailable  = "unavailable"
+)
+
+// State reports whether NDI can be used yet: initializing until Initialize
+// has run and background discovery started, then ready, or unavailable if
+// Initialize failed.
+func State() string {
+    initState.mu.Lock()
+    attempted, ok := initState.attempted, initState.ok
+    initState.mu.Unlock()
+    switch {
+    case attempted && !ok:
+        return StateUnavailable
+    case !attempted || !discoveryStarted():
+        return StateInitializing
+    }
+    return StateReady
+}
+
+// SDK returns the runtime's version, load path and init state, with an
+// actionable message when NDI can't be used.
+func SDK() SDKInfo {
+    info := sdkInfo()
+    initState.mu.Lock()
+    info.Attempted, info.Initialized = initState.attempted, initState.ok
+    initState.mu.Unlock()
+    switch {
+    case !info.Compiled:
+        info.Message = "compiled without NDI support; build with cgo and the NDI 6 SDK (on Linux and macOS with -tags ndi) to receive NDI sources"
+    case len(info.Searched) > 0:
+        info.Message = "no usable NDI runtime library found (looked in " + strings.Join(info.Searched, ", ") + "); " + runtimeHint
+    case !info.Attempted:
+        // Not loaded yet: nothing to tell
+    case !info.CPUSupported:
+        info.Message = "this CPU is not supported by the NDI runtime (SSE4.2 required)"
+    case info.Attempted && !info.Initialized:
+        info.Message = "NDI runtime failed to initialize; " + runtimeHint
+    default:
+        major := versionMajor(info.Version)
+        info.Mismatch = major > 0 && info.BuildMajor > 0 && major != info.BuildMajor
+        switch {
+        case major > 0 && major < minRuntimeMajor:
+            info.Message = fmt.Sprintf("NDI runtime %d.x is too old (need %d or newer); update the NDI Runtime", major, minRuntimeMajor)
+        case info.Mismatch && major < info.BuildMajor:
+            info.Message = fmt.Sprintf("NDI runtime %d.x is older than the NDI %d SDK this binary was built against; install the NDI %d Runtime", major, info.BuildMajor, info.BuildMajor)
+        }
+    }
+    return info
+}
+
+// redistMajor extracts the SDK major from its runtime folder variable
+// ("NDI_RUNTIME_DIR_V6" -> 6), or 0.
+func redistMajor(folder string) int {
+    _, v, ok := strings.Cut(folder, "_V")
+    if !ok { return 0 }
+    n, err := strconv.Atoi(v)
+    if err != nil { return 0 }
+    return n
+}
+
+// versionMajor extracts the major number of the first dotted version in s
+// ("NDI SDK WIN64 11:32:06 Jan 10 2024 6.0.1.0" -> 6), or 0.
+func versionMajor(s string) int {
+    for _, f := range strings.Fields(s) {
+        head, _, dotted := strings.Cut(f, ".")
+        if !dotted {
+            continue
+        }
+        if n, err := strconv.Atoi(head); err == nil {
+            return n
+        }
+    }
+    return 0
+}
@@ -0,0 +1,58 @@
+package ndi
+
+// Video FourCCs a sender may deliver, as NDIlib_FourCC_video_type_e values
+// (the four characters little-endian).
+const (
+    FourCCUYVY = 'U' | 'Y'<<8 | 'V'<<16 | 'Y'<<24
+    FourCCUYVA = 'U' | 'Y'<<8 | 'V'<<16 | 'A'<<24 // UYVY, then an 8-bit alpha plane
+    FourCCP216 = 'P' | '2'<<8 | '1'<<16 | '6'<<24 // 16-bit 4:2:2: Y plane, then interleaved UV
+    FourCCPA16 = 'P' | 'A'<<8 | '1'<<16 | '6'<<24 // P216, then a 16-bit alpha plane
+    FourCCNV12 = 'N' | 'V'<<8 | '1'<<16 | '2'<<24
+    FourCCI420 = 'I' | '4'<<8 | '2'<<16 | '0'<<24
+    FourCCYV12 = 'Y' | 'V'<<8 | '1'<<16 | '2'<<24
+    FourCCBGRA = 'B' | 'G'<<8 | 'R'<<16 | 'A'<<24
+    FourCCBGRX = 'B' | 'G'<<8 | 'R'<<16 | 'X'<<24
+    FourCCRGBA = 'R' | 'G'<<8 | 'B'<<16 | 'A'<<24
+    FourCCRGBX = 'R' | 'G'<<8 | 'B'<<16 | 'X'<<24
+)
+
+// FourCCString renders a FourCC ('UYVY', 'BGRA', ...) as text.
+func FourCCString(v int) string {
+    b := []byte{byte(v), byte(v >> 8), byte(v >> 16), byte(v >> 24)}
+    n := len(b)
+    for n > 0 && (b[n-1] == 0 || b[n-1] == ' ') { n-- }
+    return string(b[:n])
+}
+
+// LineBytes is the tight line stride of the first plane of a w pixel wide
+// frame, or 0 for a FourCC not listed above.
+func LineBytes(fourcc, w int) int {
+    switch fourcc {
+    case FourCCUYVY, FourCCUYVA, FourCCP216, FourCCPA16:
+        return w * 2
+    case FourCCNV12, FourCCI420, FourCCYV12:
+        return w
+    case FourCCBGRA, FourCCBGRX, FourCCRGBA, FourCCRGBX:
+        return w * 4
+    }
+    return 0
+}
+
+// FrameBytes is the size of a w x h frame whose first plane has lines
+// stride bytes apart, with the planes after it laid out as the SDK
+// documents; 0 for an unknown FourCC.
+func FrameBytes(fourcc, stride, w, h int) int {
+    switch fourcc {
+    case FourCCUYVY, FourCCBGRA, FourCCBGRX, FourCCRGBA, FourCCRGBX:
+        return stride * h
+    case FourCCUYVA:
+        return stride*h + w*h
+    case FourCCNV12, FourCCI420, FourCCYV12:
+        return stride*h + stride*(h/2)
+    case FourCCP216:
+        return stride * h * 2
+    case FourCCPA16:
+        return stride * h * 3
+    }
+    return 0
+}
@@ -0,0 +1,590 @@
+//go:build cgo && (windows || ((linux || darwin) && ndi))
+
+package ndi
+
+// The receiver, the same on every platform with an NDI SDK. The SDK is only
+// needed for its headers: the runtime library is loaded when Initialize
+// first runs, from the places runtimeCandidates lists, so a binary starts
+// (and serves synthetic sources) on machines without it. What the library
+// is called, where it is looked for and how it is opened are per platform:
+// receiver_windows.go, receiver_linux.go, receiver_darwin.go and
+// receiver_unix.go.
+
+/*
+#cgo CFLAGS: -Wno-deprecated-declarations
+
+// The SDK's headers come from the platform's default location or
+// CGO_CFLAGS, which the build scripts derive from NDI_SDK_DIR
+#if !__has_include(<Processing.NDI.Lib.h>)
+#error "NDI SDK headers not found: set NDI_SDK_DIR for build-mingw-auto.bat or build-unix.sh, or CGO_CFLAGS=-I<NDI SDK>/include"
+#endif
+
+#include <stdlib.h>
+#include <Processing.NDI.Lib.h>
+
+#ifndef NDILIB_REDIST_FOLDER
+#error "Processing.NDI.Lib.h predates NDI 5: build against the NDI 5 or NDI 6 SDK"
+#endif
+// The build script reads the SDK's major version from its header
+#if defined(WHEP_NDI_SDK_MAJOR) && (WHEP_NDI_SDK_MAJOR < 5 || WHEP_NDI_SDK_MAJOR > 6)
+#error "unsupported NDI SDK major version: build against the NDI 5 or NDI 6 SDK"
+#endif
+
+// Runtime folder variable of the SDK compiled against, e.g. "NDI_RUNTIME_DIR_V6"
+static const char* go_NDI_build_folder(void) { return NDILIB_REDIST_FOLDER; }
+
+// The runtime's entry points used here, resolved by name once the library
+// is loaded; NULL until then, so the wrappers below fail instead of
+// crashing when it isn't
+#define GO_NDI_SYMBOLS(X) \
+    X(initialize) X(destroy) X(version) X(is_supported_CPU) \
+    X(find_create_v2) X(find_destroy) X(find_wait_for_sources) X(find_get_current_sources) \
+    X(recv_create_v3) X(recv_destroy) X(recv_capture_v3) X(recv_free_video_v2) \
+    X(recv_free_audio_v3) X(recv_free_metadata) X(recv_get_no_connections)
+#define GO_NDI_POINTER(n) static __typeof__(NDIlib_##n)* go_p_##n;
+GO_NDI_SYMBOLS(GO_NDI_POINTER)
+#define GO_NDI_NAME(n) "NDIlib_" #n,
+static const char* const go_NDI_names[] = { GO_NDI_SYMBOLS(GO_NDI_NAME) NULL };
+#define GO_NDI_SLOT(n) (void**)&go_p_##n,
+static void** const go_NDI_slots[] = { GO_NDI_SYMBOLS(GO_NDI_SLOT) };
+
+// Name of entry point i, or NULL past the last
+static const char* go_NDI_symbol(int i) { return go_NDI_names[i]; }
+static void go_NDI_bind(int i, void* fn) { *go_NDI_slots[i] = fn; }
+
+static bool go_NDIlib_initialize(void) { return go_p_initialize && go_p_initialize(); }
+static void go_NDIlib_destroy(void) { if (go_p_destroy) go_p_destroy(); }
+static const char* go_NDIlib_version(void) { return go_p_version ? go_p_version() : NULL; }
+static bool go_NDIlib_is_supported_CPU(void) { return go_p_is_supported_CPU && go_p_is_supported_CPU(); }
+static NDIlib_find_instance_t go_NDIlib_find_create_v2(const NDIlib_find_create_t* cfg) {
+    return go_p_find_create_v2 ? go_p_find_create_v2(cfg) : NULL;
+}
+static void go_NDIlib_find_destroy(NDIlib_find_instance_t f) { if (go_p_find_destroy) go_p_find_destroy(f); }
+static bool go_NDIlib_find_wait_for_sources(NDIlib_find_instance_t f, uint32_t timeout_ms) {
+    return go_p_find_wait_for_sources && go_p_find_wait_for_sources(f, timeout_ms);
+}
+static const NDIlib_source_t* go_NDIlib_find_get_current_sources(NDIlib_find_instance_t f, uint32_t* count) {
+    if (!go_p_find_get_current_sources) { *count = 0; return NULL; }
+    return go_p_find_get_current_sources(f, count);
+}
+static NDIlib_recv_instance_t go_NDIlib_recv_create_v3(const NDIlib_recv_create_v3_t* cfg) {
+    return go_p_recv_create_v3 ? go_p_recv_create_v3(cfg) : NULL;
+}
+static void go_NDIlib_recv_destroy(NDIlib_recv_instance_t r) { if (go_p_recv_destroy) go_p_recv_destroy(r); }
+static NDIlib_frame_type_e go_NDIlib_recv_capture_v3(NDIlib_recv_instance_t r, NDIlib_video_frame_v2_t* vf,
+        NDIlib_audio_frame_v3_t* af, NDIlib_metadata_frame_t* mf, uint32_t timeout_ms) {
+    return go_p_recv_capture_v3 ? go_p_recv_capture_v3(r, vf, af, mf, timeout_ms) : NDIlib_frame_type_error;
+}
+static void go_NDIlib_recv_free_video_v2(NDIlib_recv_instance_t r, const NDIlib_video_frame_v2_t* vf) {
+    if (go_p_recv_free_video_v2) go_p_recv_free_video_v2(r, vf);
+}
+static void go_NDIlib_recv_free_audio_v3(NDIlib_recv_instance_t r, const NDIlib_audio_frame_v3_t* af) {
+    if (go_p_recv_free_audio_v3) go_p_recv_free_audio_v3(r, af);
+}
+static void go_NDIlib_recv_free_metadata(NDIlib_recv_instance_t r, const NDIlib_metadata_frame_t* mf) {
+    if (go_p_recv_free_metadata) go_p_recv_free_metadata(r, mf);
+}
+static int go_NDIlib_recv_get_no_connections(NDIlib_recv_instance_t r) {
+    return go_p_recv_get_no_connections ? go_p_recv_get_no_connections(r) : 0;
+}
+
+// Helper to allocate receiver with specified color format (0=BGRA, 1=UYVY,
+// 2=fastest, i.e. whatever the sender has: NV12, P216, UYVA, RGBA, ...)
+// and bandwidth (0=highest, 1=lowest, i.e. the sender's preview stream)
+static NDIlib_recv_instance_t go_NDI_recv_create_with_color(NDIlib_source_t src, int color, int lowest) {
+    NDIlib_recv_create_v3_t cfg = {0};
+    cfg.source_to_connect_to = src;
+    cfg.bandwidth = lowest ? NDIlib_recv_bandwidth_lowest : NDIlib_recv_bandwidth_highest;
+    cfg.allow_video_fields = false;
+    cfg.p_ndi_recv_name = NULL;
+    if (color == 2) {
+        cfg.color_format = NDIlib_recv_color_format_fastest;
+    } else if (color == 1) {
+        cfg.color_format = NDIlib_recv_color_format_UYVY_BGRA;
+    } else {
+        cfg.color_format = NDIlib_recv_color_format_BGRX_BGRA;
+    }
+    return go_NDIlib_recv_create_v3(&cfg);
+}
+
+// Enumerate sources using finder (v2 API available in SDK)
+static const NDIlib_source_t* go_NDI_find_sources(uint32_t *count, uint32_t timeout_ms) {
+    NDIlib_find_create_t cfg = {0};
+    cfg.show_local_sources = true;
+    cfg.p_extra_ips = NULL;
+    NDIlib_find_instance_t f = go_NDIlib_find_create_v2(&cfg);
+    if (!f) { *count = 0; return NULL; }
+    go_NDIlib_find_wait_for_sources(f, timeout_ms);
+    const NDIlib_source_t* arr = go_NDIlib_find_get_current_sources(f, count);
+    // Intentionally not destroying finder yet so caller can read pointers before free
+    // Caller must call NDIlib_find_destroy on the returned instance
+    return arr;
+}
+
+// Helpers to set and get union fields in NDIlib_source_t for cgo compatibility
+static void go_set_source_url(NDIlib_source_t* src, const char* url) {
+    src->p_ndi_name = NULL;
+    src->p_url_address = url;
+}
+static void go_set_source_name(NDIlib_source_t* src, const char* name) {
+    src->p_ndi_name = name;
+    src->p_url_address = NULL;
+}
+static const char* go_get_source_url(const NDIlib_source_t* src) {
+    return src->p_url_address;
+}
+
+// Bytes between the starts of two lines of a video frame; 0 when the sender
+// left it unset. It shares a union with data_size_in_bytes, which cgo can't
+// reach by name.
+static int go_video_line_stride(const NDIlib_video_frame_v2_t* vf) {
+    return vf->line_stride_in_bytes;
+}
+
+// Copy planar float (FLTP) audio into an interleaved buffer of no_samples*no_channels
+static void go_audio_interleave(const NDIlib_audio_frame_v3_t* af, float* out) {
+    int ch = af->no_channels, n = af->no_samples, stride = af->channel_stride_in_bytes;
+    for (int c = 0; c < ch; c++) {
+        const float* p = (const float*)(af->p_data + (size_t)c * stride);
+        for (int i = 0; i < n; i++) out[i*ch + c] = p[i];
+    }
+}
+
+*/
+import "C"
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"unsafe"
+)
+
+type Receiver struct {
+	inst C.NDIlib_recv_instance_t
+}
+
+// sdkLibDir is the SDK's library folder at build time, which the build
+// scripts set with -X whep/internal/ndi.sdkLibDir=...; it is searched after
+// the runtime's own folders.
+var sdkLibDir string
+
+// ndiLib is the loaded runtime library.
+var ndiLib struct {
+	mu    sync.Mutex
+	lib   unsafe.Pointer
+	path  string
+	tried []string // where the last failed load looked
+}
+
+// Initialize loads the NDI runtime. It only calls into the SDK until that
+// succeeds once; concurrent callers wait for the call in flight.
+func Initialize() bool {
+	return initOnce(func() bool { return loadRuntime() && bool(C.go_NDIlib_initialize()) })
+}
+
+// Destroy releases the NDI runtime. Call it only once every receiver and
+// finder is gone; it is the last NDI call of the process. The library stays
+// loaded: its threads may outlive NDIlib_destroy.
+func Destroy() {
+	initState.mu.Lock()
+	ok := initState.ok
+	initState.ok = false
+	initState.mu.Unlock()
+	if ok {
+		C.go_NDIlib_destroy()
+	}
+}
+
+// loadRuntime loads the NDI library from the first of runtimeCandidates
+// that opens and has every entry point used here, unless one is loaded
+// already. It runs under initCall.
+func loadRuntime() bool {
+	ndiLib.mu.Lock()
+	loaded := ndiLib.lib != nil
+	ndiLib.mu.Unlock()
+	if loaded {
+		return true
+	}
+	var tried []string
+	note := func(s string) {
+		for _, t := range tried {
+			if t == s {
+				return
+			}
+		}
+		tried = append(tried, s)
+	}
+	for _, c := range runtimeCandidates() {
+		if filepath.IsAbs(c) {
+			if _, err := os.Stat(c); err != nil {
+				note(filepath.Dir(c))
+				continue
+			}
+		}
+		lib, err := openLibrary(c)
+		if err == nil {
+			if err = bindRuntime(lib); err != nil {
+				closeLibrary(lib)
+			}
+		}
+		if err != nil {
+			// A bare name failing is the system's search coming up empty
+			if filepath.IsAbs(c) {
+				c = fmt.Sprintf("%s (%v)", c, err)
+			}
+			note(c)
+			continue
+		}
+		path := libraryPath(lib)
+		if path == "" {
+			path = c
+		}
+		ndiLib.mu.Lock()
+		ndiLib.lib, ndiLib.path, ndiLib.tried = lib, path, nil
+		ndiLib.mu.Unlock()
+		return true
+	}
+	ndiLib.mu.Lock()
+	ndiLib.tried = tried
+	ndiLib.mu.Unlock()
+	return false
+}
+
+// bindRuntime resolves the runtime's entry points in lib, or none of them.
+func bindRuntime(lib unsafe.Pointer) error {
+	for i := 0; ; i++ {
+		name := C.go_NDI_symbol(C.int(i))
+		if name == nil {
+			return nil
+		}
+		fn := librarySymbol(lib, C.GoString(name))
+		if fn == nil {
+			for j := 0; j < i; j++ {
+				C.go_NDI_bind(C.int(j), nil)
+			}
+			return fmt.Errorf("no %s: not an NDI 5 or newer runtime", C.GoString(name))
+		}
+		C.go_NDI_bind(C.int(i), fn)
+	}
+}
+
+// runtimeCandidates lists where the NDI library is looked for, in order:
+// NDI_RUNTIME_DIR (a folder, or the library itself), the folder the runtime
+// installer records in NDI_RUNTIME_DIR_V6 (or _V5), the executable's
+// folder, the SDK's library folder at build time and the platform's default
+// folders, then the bare library names, for the system's own search
+// (PATH, LD_LIBRARY_PATH and the like).
+func runtimeCandidates() []string {
+	var out, dirs []string
+	if d := os.Getenv("NDI_RUNTIME_DIR"); d != "" {
+		if fi, err := os.Stat(d); err == nil && !fi.IsDir() {
+			out = append(out, d)
+		} else {
+			dirs = append(dirs, d)
+		}
+	}
+	for _, v := range []string{C.GoString(C.go_NDI_build_folder()), "NDI_RUNTIME_DIR_V6", "NDI_RUNTIME_DIR_V5"} {
+		if d := os.Getenv(v); d != "" {
+			dirs = append(dirs, d)
+		}
+	}
+	if exe, err := os.Executable(); err == nil {
+		dirs = append(dirs, filepath.Dir(exe))
+	}
+	if sdkLibDir != "" {
+		dirs = append(dirs, sdkLibDir)
+	}
+	dirs = append(dirs, runtimeDirs()...)
+	seen := map[string]bool{}
+	for _, d := range dirs {
+		if abs, err := filepath.Abs(d); err == nil {
+			d = abs
+		}
+		for _, n := range runtimeNames {
+			if p := filepath.Join(d, n); !seen[p] {
+				seen[p] = true
+				out = append(out, p)
+			}
+		}
+	}
+	return append(out, runtimeNames...)
+}
+
+func sdkInfo() SDKInfo {
+	info := SDKInfo{Compiled: true}
+	info.BuildMajor = redistMajor(C.GoString(C.go_NDI_build_folder()))
+	ndiLib.mu.Lock()
+	loaded, path, tried := ndiLib.lib != nil, ndiLib.path, ndiLib.tried
+	ndiLib.mu.Unlock()
+	if !loaded {
+		info.Searched = tried
+		return info
+	}
+	info.CPUSupported = bool(C.go_NDIlib_is_supported_CPU())
+	if v := C.go_NDIlib_version(); v != nil {
+		info.Version = C.GoString(v)
+	}
+	info.RuntimePath = path
+	return info
+}
+
+func FindFirst(timeoutMs int) (name, url string, ok bool) {
+	find, err := createFinder(nil)
+	if err != nil {
+		return "", "", false
+	}
+	defer C.go_NDIlib_find_destroy(find)
+	C.go_NDIlib_find_wait_for_sources(find, C.uint(timeoutMs))
+	var no C.uint
+	arr := C.go_NDIlib_find_get_current_sources(find, &no)
+	if arr == nil || no == 0 {
+		return "", "", false
+	}
+	s := (*[1 << 30]C.NDIlib_source_t)(unsafe.Pointer(arr))[:no:no]
+	n := s[0]
+	if n.p_ndi_name != nil {
+		name = C.GoString(n.p_ndi_name)
+	}
+	// Build fallback URL from name
+	if name != "" {
+		url = "ndi://" + name
+	}
+	return name, url, true
+}
+
+// NewReceiverByURL connects to url at bandwidth (BandwidthHighest or
+// BandwidthLowest, the sender's low-resolution proxy stream); "" is
+// DefaultBandwidth.
+func NewReceiverByURL(url, bandwidth string) (*Receiver, error) {
+	cstr := C.CString(url)
+	defer C.free(unsafe.Pointer(cstr))
+	var src C.NDIlib_source_t
+	// Heuristic: treat as URL if it has a scheme (e.g., ndi://) OR looks like host:port
+	if strings.Contains(url, "://") || strings.Contains(url, ":") {
+		C.go_set_source_url(&src, cstr)
+	} else {
+		C.go_set_source_name(&src, cstr)
+	}
+	// Choose color format via env NDI_RECV_COLOR: "UYVY", "BGRA" or
+	// "FASTEST" (default UYVY)
+	colorSel := 1
+	switch strings.ToUpper(os.Getenv("NDI_RECV_COLOR")) {
+	case "BGRA", "BGRX":
+		colorSel = 0
+	case "FASTEST", "NATIVE":
+		colorSel = 2
+	default:
+		colorSel = 1
+	}
+	low := 0
+	if bandwidth == "" {
+		bandwidth = DefaultBandwidth()
+	}
+	if bandwidth == BandwidthLowest {
+		low = 1
+	}
+	inst, err := retry("recv_create", func() (C.NDIlib_recv_instance_t, error) {
+		if initFailed() {
+			return nil, errNotInitialized
+		}
+		inst := C.go_NDI_recv_create_with_color(src, C.int(colorSel), C.int(low))
+		if inst == nil {
+			return nil, errors.New("NDIlib_recv_create_v3 failed")
+		}
+		return inst, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	activeReceivers.Add(1)
+	return &Receiver{inst: inst}, nil
+}
+
+// createFinder creates a finder, retrying transient failures.
+func createFinder(cfg *C.NDIlib_find_create_t) (C.NDIlib_find_instance_t, error) {
+	return retry("find_create", func() (C.NDIlib_find_instance_t, error) {
+		if initFailed() {
+			return nil, errNotInitialized
+		}
+		fi := C.go_NDIlib_find_create_v2(cfg)
+		if fi == nil {
+			return nil, errors.New("NDIlib_find_create_v2 failed")
+		}
+		return fi, nil
+	})
+}
+
+type SourceInfo struct{ Name, URL string }
+
+// ListSources polls discovery in short intervals up to timeoutMs and returns the latest set.
+// This mimics the working implementation that samples get_current_sources repeatedly.
+func ListSources(timeoutMs int) []SourceInfo {
+	if timeoutMs <= 0 {
+		timeoutMs = 2000 // default 2s
+	}
+
+	// Create finder with explicit config
+	var cfg C.NDIlib_find_create_t
+	cfg.show_local_sources = C.bool(true)
+	// Optional groups and extra IPs from env to match SDK examples/NDI Monitor behavior
+	var cGroups, cExtra *C.char
+	if g := os.Getenv("NDI_GROUPS"); g != "" {
+		cGroups = C.CString(g)
+		cfg.p_groups = cGroups
+	}
+	if ips := os.Getenv("NDI_EXTRA_IPS"); ips != "" {
+		cExtra = C.CString(ips)
+		cfg.p_extra_ips = cExtra
+	}
+	fi, err := createFinder(&cfg)
+	if err != nil {
+		if cGroups != nil { C.free(unsafe.Pointer(cGroups)) }
+		if cExtra != nil { C.free(unsafe.Pointer(cExtra)) }
+		return nil
+	}
+	defer func() {
+		C.go_NDIlib_find_destroy(fi)
+		if cGroups != nil { C.free(unsafe.Pointer(cGroups)) }
+		if cExtra != nil { C.free(unsafe.Pointer(cExtra)) }
+	}()
+
+	// Poll in ~200ms steps until timeout, keeping the latest non-empty list
+	remaining := timeoutMs
+	step := 200
+	var latest []SourceInfo
+	for remaining >= 0 {
+		var no C.uint
+		arr := C.go_NDIlib_find_get_current_sources(fi, &no)
+		if arr != nil && no > 0 {
+			tmp := make([]SourceInfo, 0, int(no))
+			s := (*[1 << 28]C.NDIlib_source_t)(unsafe.Pointer(arr))[:no:no]
+			for i := 0; i < int(no); i++ {
+				var name, url string
+				if s[i].p_ndi_name != nil { name = C.GoString(s[i].p_ndi_name) }
+				// Hard-filter out NDI Remote Connection helper sources
+				ln := strings.ToLower(name)
+				if strings.Contains(ln, "remote connection") {
+					continue
+				}
+				if p := C.go_get_source_url(&s[i]); p != nil { url = C.GoString(p) } else if name != "" { url = "ndi://" + name }
+				if name != "" || url != "" { tmp = append(tmp, SourceInfo{Name: name, URL: url}) }
+			}
+			latest = tmp
+		}
+		if remaining == 0 { break }
+		if remaining < step { step = remaining }
+		C.go_NDIlib_find_wait_for_sources(fi, C.uint(step))
+		remaining -= step
+	}
+	return latest
+}
+
+type VideoFrame struct {
+	W, H   int
+	Stride int // bytes from one line of the first plane to the next
+	// FourCC is one of the FourCCXxx constants; Data is nil for any other
+	FourCC int
+	// Sender's frame rate as a rational (e.g. 30000/1001)
+	FrameRateN, FrameRateD int
+	Data                   []byte // all planes; Stride*H for packed formats
+}
+
+// AudioFrame is interleaved float32 PCM as delivered by the sender.
+type AudioFrame struct {
+	SampleRate int
+	Channels   int
+	Samples    int       // per channel
+	Data       []float32 // length = Samples*Channels
+}
+
+// CaptureVideo waits for the next video frame; audio and metadata are discarded.
+func (r *Receiver) CaptureVideo(timeoutMs int) (*VideoFrame, bool, error) {
+	vf, _, err := r.Capture(timeoutMs)
+	return vf, vf != nil, err
+}
+
+// Capture waits up to timeoutMs for the next frame and returns it as either a
+// video or an audio frame (at most one is non-nil). Metadata is discarded.
+func (r *Receiver) Capture(timeoutMs int) (*VideoFrame, *AudioFrame, error) {
+	return r.CaptureInto(timeoutMs, nil)
+}
+
+// CaptureInto is Capture with the video pixels copied from the SDK straight
+// into alloc(size), so a caller with a buffer at hand (or one it keeps)
+// spares an allocation and a second copy. alloc is only called for video
+// frames; nil, or a buffer shorter than size, gets a new one.
+func (r *Receiver) CaptureInto(timeoutMs int, alloc func(size int) []byte) (*VideoFrame, *AudioFrame, error) {
+	var vf C.NDIlib_video_frame_v2_t
+	var af C.NDIlib_audio_frame_v3_t
+	var mf C.NDIlib_metadata_frame_t
+	ftype := C.go_NDIlib_recv_capture_v3(r.inst, &vf, &af, &mf, C.uint(timeoutMs))
+	switch ftype {
+	case C.NDIlib_frame_type_video:
+		w := int(vf.xres)
+		h := int(vf.yres)
+		fourcc := int(vf.FourCC)
+		row := LineBytes(fourcc, w)
+		if row == 0 {
+			// Unknown layout: pass the FourCC on without pixels
+			C.go_NDIlib_recv_free_video_v2(r.inst, &vf)
+			return &VideoFrame{W: w, H: h, FourCC: fourcc, FrameRateN: int(vf.frame_rate_N), FrameRateD: int(vf.frame_rate_D)}, nil, nil
+		}
+		// Senders may pad lines past the tight width; an unset stride means none
+		stride := int(C.go_video_line_stride(&vf))
+		if stride < row {
+			stride = row
+		}
+		size := FrameBytes(fourcc, stride, w, h)
+		var data []byte
+		if alloc != nil {
+			data = alloc(size)
+		}
+		if len(data) < size {
+			data = make([]byte, size)
+		}
+		data = data[:size]
+		copy(data, unsafe.Slice((*byte)(unsafe.Pointer(vf.p_data)), size))
+		out := &VideoFrame{W: w, H: h, Stride: stride, FourCC: fourcc, FrameRateN: int(vf.frame_rate_N), FrameRateD: int(vf.frame_rate_D), Data: data}
+		C.go_NDIlib_recv_free_video_v2(r.inst, &vf)
+		return out, nil, nil
+	case C.NDIlib_frame_type_audio:
+		var out *AudioFrame
+		ch, n := int(af.no_channels), int(af.no_samples)
+		if af.FourCC == C.NDIlib_FourCC_audio_type_FLTP && ch > 0 && n > 0 && af.p_data != nil {
+			out = &AudioFrame{SampleRate: int(af.sample_rate), Channels: ch, Samples: n, Data: make([]float32, ch*n)}
+			C.go_audio_interleave(&af, (*C.float)(unsafe.Pointer(&out.Data[0])))
+		}
+		C.go_NDIlib_recv_free_audio_v3(r.inst, &af)
+		return nil, out, nil
+	case C.NDIlib_frame_type_metadata:
+		C.go_NDIlib_recv_free_metadata(r.inst, &mf)
+		return nil, nil, nil
+	case C.NDIlib_frame_type_none, C.NDIlib_frame_type_status_change:
+		return nil, nil, nil
+	case C.NDIlib_frame_type_error:
+		return nil, nil, errors.New("NDI recv error")
+	default:
+		return nil, nil, nil
+	}
+}
+
+// Connections returns the number of senders this receiver is connected to;
+// 0 means the connection hasn't been established (yet).
+func (r *Receiver) Connections() int {
+	if r.inst == nil {
+		return 0
+	}
+	return int(C.go_NDIlib_recv_get_no_connections(r.inst))
+}
+
+func (r *Receiver) Close() {
+	if r.inst != nil {
+		C.go_NDIlib_recv_destroy(r.inst)
+		r.inst = nil
+		activeReceivers.Add(-1)
+	}
+}
@@ -0,0 +1,22 @@
+//go:build darwin && cgo && ndi
+
+package ndi
+
+/*
+// The NDI SDK for Apple installs to /Library/NDI SDK for Apple; build-unix.sh
+// points CGO_CFLAGS at another SDK through NDI_SDK_DIR. libndi is loaded at
+// run time
+#cgo CFLAGS: "-I/Library/NDI SDK for Apple/include"
+*/
+import "C"
+
+// runtimeHint is how to fix a runtime that can't be loaded or initialized.
+const runtimeHint = "install the NDI SDK for Apple or NDI Tools (https://ndi.video/tools), or set NDI_RUNTIME_DIR to the folder holding libndi.dylib"
+
+// runtimeNames are the file names of the NDI library.
+var runtimeNames = []string{"libndi.dylib"}
+
+// runtimeDirs are where the NDI SDK for Apple and NDI Tools install libndi.
+func runtimeDirs() []string {
+	return []string{"/Library/NDI SDK for Apple/lib/macOS", "/usr/local/lib"}
+}
@@ -0,0 +1,22 @@
+//go:build linux && cgo && ndi
+
+package ndi
+
+/*
+// The NDI SDK for Linux's headers are looked for in /usr/include and
+// /usr/local/include; build-unix.sh points CGO_CFLAGS at an unpacked SDK
+// through NDI_SDK_DIR instead. libndi is loaded at run time
+#cgo CFLAGS: -I/usr/local/include
+#cgo LDFLAGS: -ldl
+*/
+import "C"
+
+// runtimeHint is how to fix a runtime that can't be loaded or initialized.
+const runtimeHint = "install libndi.so.6 from the NDI SDK for Linux in /usr/local/lib, or set NDI_RUNTIME_DIR to its folder"
+
+// runtimeNames are the file names of the NDI library, newest first.
+var runtimeNames = []string{"libndi.so.6", "libndi.so.5", "libndi.so"}
+
+// runtimeDirs are the folders libndi is installed to by hand, which the
+// loader's cache may not know yet.
+func runtimeDirs() []string { return []string{"/usr/local/lib"} }
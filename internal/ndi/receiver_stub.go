@@ -1,14 +1,23 @@
-//go:build !windows || !cgo
+//go:build !cgo || !(windows || ((linux || darwin) && ndi))
 
 package ndi
 
 type Receiver struct{}
-type VideoFrame struct { W,H,Stride,FourCC int; Data []byte }
+type VideoFrame struct { W,H,Stride,FourCC int; FrameRateN, FrameRateD int; Data []byte }
+type AudioFrame struct { SampleRate, Channels, Samples int; Data []float32 }
 
-func Initialize() bool { return false }
+// runtimeHint is unused without NDI: SDK reports the build instead.
+const runtimeHint = ""
+
+func Initialize() bool { recordInit(false); return false }
+func sdkInfo() SDKInfo { return SDKInfo{} }
+func Destroy() {}
 func FindFirst(timeoutMs int) (string,string,bool) { return "","",false }
-func NewReceiverByURL(url string) (*Receiver, error) { return nil, nil }
+func NewReceiverByURL(url, bandwidth string) (*Receiver, error) { return nil, nil }
 func (r *Receiver) CaptureVideo(timeoutMs int) (*VideoFrame, bool, error) { return nil, false, nil }
+func (r *Receiver) Capture(timeoutMs int) (*VideoFrame, *AudioFrame, error) { return nil, nil, nil }
+func (r *Receiver) CaptureInto(timeoutMs int, alloc func(size int) []byte) (*VideoFrame, *AudioFrame, error) { return nil, nil, nil }
+func (r *Receiver) Connections() int { return 0 }
 func (r *Receiver) Close() {}
 type SourceInfo struct{ Name, URL string }
 func ListSources(timeoutMs int) []SourceInfo { return nil }
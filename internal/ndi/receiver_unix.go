@@ -0,0 +1,68 @@
+//go:build (linux || darwin) && cgo && ndi
+
+package ndi
+
+/*
+#define _GNU_SOURCE
+#include <dlfcn.h>
+#include <stdlib.h>
+#include <string.h>
+
+static void* go_NDI_open(const char* path) { return dlopen(path, RTLD_NOW | RTLD_LOCAL); }
+static const char* go_NDI_error(void) { return dlerror(); }
+static void* go_NDI_sym(void* lib, const char* name) { return dlsym(lib, name); }
+static void go_NDI_close(void* lib) { dlclose(lib); }
+
+// Full path of the library fn was loaded from, or 0 if it can't be told
+static int go_NDI_symbol_path(void* fn, char* out, int n) {
+    Dl_info info;
+    if (!dladdr(fn, &info) || !info.dli_fname) return 0;
+    int len = (int)strlen(info.dli_fname);
+    if (len >= n) len = n - 1;
+    memcpy(out, info.dli_fname, len);
+    return len;
+}
+*/
+import "C"
+
+import (
+	"errors"
+	"strings"
+	"unsafe"
+)
+
+func openLibrary(path string) (unsafe.Pointer, error) {
+	cpath := C.CString(path)
+	defer C.free(unsafe.Pointer(cpath))
+	lib := C.go_NDI_open(cpath)
+	if lib == nil {
+		// dlerror leads with the path, which the caller has
+		msg := "dlopen failed"
+		if e := C.go_NDI_error(); e != nil {
+			msg = strings.TrimPrefix(C.GoString(e), path+": ")
+		}
+		return nil, errors.New(msg)
+	}
+	return lib, nil
+}
+
+func librarySymbol(lib unsafe.Pointer, name string) unsafe.Pointer {
+	cname := C.CString(name)
+	defer C.free(unsafe.Pointer(cname))
+	return C.go_NDI_sym(lib, cname)
+}
+
+func closeLibrary(lib unsafe.Pointer) { C.go_NDI_close(lib) }
+
+// libraryPath is the file lib was loaded from.
+func libraryPath(lib unsafe.Pointer) string {
+	fn := librarySymbol(lib, "NDIlib_initialize")
+	if fn == nil {
+		return ""
+	}
+	var buf [1024]C.char
+	if n := C.go_NDI_symbol_path(fn, &buf[0], C.int(len(buf))); n > 0 {
+		return C.GoStringN(&buf[0], n)
+	}
+	return ""
+}
@@ -0,0 +1,93 @@
+package ndi
+
+import (
+    "errors"
+    "log"
+    "math/rand/v2"
+    "sync"
+    "time"
+)
+
+// Receiver and finder creation occasionally fail for a moment under load
+// (the SDK returns NULL) and succeed on the next try. retry gives those
+// calls a few jittered attempts so a one-off hiccup doesn't fail a mount,
+// while a persistent failure still surfaces within a few hundred ms.
+const (
+    retryAttempts = 3
+    retryBase     = 50 * time.Millisecond // backoff before the second attempt; doubles after
+    retryMaxDelay = 400 * time.Millisecond
+)
+
+// errNotInitialized is returned without retrying when the runtime failed to
+// initialize; no number of attempts will fix that.
+var errNotInitialized = permanent(errors.New("NDI runtime not initialized"))
+
+// permanentError marks a failure retry must not repeat.
+type permanentError struct{ err error }
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+func permanent(err error) error { return permanentError{err} }
+
+// RetryStat is one operation's counters, for /health.
+type RetryStat struct {
+    Calls    int64 `json:"calls"`    // operations started
+    Attempts int64 `json:"attempts"` // SDK calls made, including retries
+    Retried  int64 `json:"retried"`  // operations that needed more than one attempt
+    Failures int64 `json:"failures"` // operations that failed after their last attempt
+}
+
+var retryStats struct {
+    mu  sync.Mutex
+    ops map[string]*RetryStat
+}
+
+func noteRetry(op string, f func(*RetryStat)) {
+    retryStats.mu.Lock()
+    if retryStats.ops == nil { retryStats.ops = map[string]*RetryStat{} }
+    st := retryStats.ops[op]
+    if st == nil { st = &RetryStat{}; retryStats.ops[op] = st }
+    f(st)
+    retryStats.mu.Unlock()
+}
+
+// GetRetryStats returns the counters of every operation retried so far.
+func GetRetryStats() map[string]RetryStat {
+    retryStats.mu.Lock()
+    defer retryStats.mu.Unlock()
+    out := make(map[string]RetryStat, len(retryStats.ops))
+    for op, st := range retryStats.ops { out[op] = *st }
+    return out
+}
+
+// retryDelay is the jittered backoff before attempt n+1 (n from 1): half
+// the exponential step plus a random share of the other half, so callers
+// failing together don't retry in lockstep.
+func retryDelay(n int) time.Duration {
+    d := min(retryBase<<(n-1), retryMaxDelay)
+    return d/2 + rand.N(d/2+1)
+}
+
+// retry runs fn up to retryAttempts times with jittered exponential backoff.
+// Errors wrapped by permanent are returned at once.
+func retry[T any](op string, fn func() (T, error)) (T, error) {
+    noteRetry(op, func(st *RetryStat) { st.Calls++ })
+    var v T
+    var err error
+    for n := 1; ; n++ {
+        noteRetry(op, func(st *RetryStat) { st.Attempts++ })
+        if v, err = fn(); err == nil {
+            if n > 1 {
+                noteRetry(op, func(st *RetryStat) { st.Retried++ })
+                log.Printf("NDI %s: succeeded on attempt %d", op, n)
+            }
+            return v, nil
+        }
+        var perm permanentError
+        if errors.As(err, &perm) || n == retryAttempts { break }
+        time.Sleep(retryDelay(n))
+    }
+    noteRetry(op, func(st *RetryStat) { st.Failures++ })
+    return v, err
+}
@@ -0,0 +1,158 @@
+package ndi
+
+import (
+    "context"
+    "slices"
+    "sync"
+    "time"
+)
+
+// Temporary receivers (probes, /frame grabs) and discovery passes go through
+// one scheduler so a burst of requests, or a poller hitting many sources on
+// the same tick, doesn't connect to every sender at once. A token bucket
+// spaces the openings out and a cap bounds how many temporary receivers are
+// open together. Waiters are served priority first, then in arrival order.
+const (
+    DefaultTempReceivers = 4               // concurrent temporary receivers
+    DefaultTempRate      = 2.0             // receiver openings per second
+    interestWindow       = 5 * time.Minute // NoteInterest keeps a source prioritized this long
+)
+
+type ticket struct {
+    source   string
+    priority bool
+    slot     bool // holds a temporary-receiver slot until released
+}
+
+type scheduler struct {
+    mu        sync.Mutex
+    maxActive int
+    rate      float64 // tokens per second
+    tokens    float64
+    refilled  time.Time
+    active    int
+    queue     []*ticket
+    changed   chan struct{} // closed and replaced whenever a waiter may proceed
+    lastOpen  map[string]time.Time
+    interest  map[string]time.Time
+}
+
+var sched = scheduler{maxActive: DefaultTempReceivers, rate: DefaultTempRate, tokens: 1}
+
+// SetTempLimits sets how many temporary receivers may be open at once and
+// how many may be opened per second. Values <= 0 keep the current setting.
+func SetTempLimits(maxActive int, perSecond float64) {
+    sched.mu.Lock()
+    if maxActive > 0 { sched.maxActive = maxActive }
+    if perSecond > 0 { sched.rate = perSecond }
+    sched.notifyLocked()
+    sched.mu.Unlock()
+}
+
+// NoteInterest marks source (URL or name) as wanted by viewers, so its
+// temporary receivers jump the queue for a while.
+func NoteInterest(source string) {
+    if source == "" { return }
+    sched.mu.Lock()
+    if sched.interest == nil { sched.interest = map[string]time.Time{} }
+    sched.interest[source] = time.Now()
+    sched.mu.Unlock()
+}
+
+// AcquireTemp waits for a turn to open a temporary receiver for source (URL
+// or name). priority puts it ahead of other waiters, e.g. for a source with
+// a running mount; recent NoteInterest does the same. The returned release
+// must be called once the receiver is closed. It fails only with ctx.Err().
+func AcquireTemp(ctx context.Context, source string, priority bool) (release func(), err error) {
+    if err := sched.acquire(ctx, &ticket{source: source, priority: priority, slot: true}); err != nil { return nil, err }
+    var once sync.Once
+    return func() { once.Do(sched.release) }, nil
+}
+
+// waitDiscovery takes a token for a discovery pass, ahead of receivers.
+func waitDiscovery(ctx context.Context) error {
+    return sched.acquire(ctx, &ticket{priority: true})
+}
+
+func (s *scheduler) notifyLocked() {
+    if s.changed != nil { close(s.changed) }
+    s.changed = make(chan struct{})
+}
+
+// refillLocked adds the tokens accrued since the last refill; the bucket
+// holds at most one, so openings are spaced evenly.
+func (s *scheduler) refillLocked(now time.Time) {
+    if !s.refilled.IsZero() { s.tokens = min(1, s.tokens+now.Sub(s.refilled).Seconds()*s.rate) }
+    s.refilled = now
+}
+
+func (s *scheduler) acquire(ctx context.Context, t *ticket) error {
+    s.mu.Lock()
+    if at, ok := s.interest[t.source]; ok && time.Since(at) < interestWindow { t.priority = true }
+    // Behind the last waiter of the same or higher priority
+    i := len(s.queue)
+    if t.priority { i = slices.IndexFunc(s.queue, func(q *ticket) bool { return !q.priority }); if i < 0 { i = len(s.queue) } }
+    s.queue = slices.Insert(s.queue, i, t)
+    if s.changed == nil { s.changed = make(chan struct{}) }
+    for {
+        now := time.Now()
+        s.refillLocked(now)
+        var wait time.Duration
+        if s.queue[0] == t && (!t.slot || s.active < s.maxActive) {
+            if s.tokens >= 1 {
+                s.tokens--
+                s.queue = s.queue[1:]
+                if t.slot {
+                    s.active++
+                    if s.lastOpen == nil { s.lastOpen = map[string]time.Time{} }
+                    s.lastOpen[t.source] = now
+                }
+                s.notifyLocked()
+                s.mu.Unlock()
+                return nil
+            }
+            wait = time.Duration((1 - s.tokens) / s.rate * float64(time.Second))
+        }
+        changed := s.changed
+        s.mu.Unlock()
+        var timer <-chan time.Time
+        if wait > 0 { timer = time.After(wait) }
+        select {
+        case <-changed:
+        case <-timer:
+        case <-ctx.Done():
+            s.mu.Lock()
+            if i := slices.Index(s.queue, t); i >= 0 { s.queue = slices.Delete(s.queue, i, i+1) }
+            s.notifyLocked()
+            s.mu.Unlock()
+            return ctx.Err()
+        }
+        s.mu.Lock()
+    }
+}
+
+func (s *scheduler) release() {
+    s.mu.Lock()
+    if s.active > 0 { s.active-- }
+    s.notifyLocked()
+    s.mu.Unlock()
+}
+
+// TempStats is the scheduler's state for /health.
+type TempStats struct {
+    Queued    int                  `json:"queued"`
+    Active    int                  `json:"active"`
+    MaxActive int                  `json:"maxActive"`
+    Rate      float64              `json:"ratePerSec"`
+    LastOpen  map[string]time.Time `json:"lastOpen"` // per source, when its last temporary receiver was opened
+}
+
+// GetTempStats returns queue depth, open temporary receivers and the last
+// opening per source.
+func GetTempStats() TempStats {
+    sched.mu.Lock()
+    defer sched.mu.Unlock()
+    st := TempStats{Queued: len(sched.queue), Active: sched.active, MaxActive: sched.maxActive, Rate: sched.rate, LastOpen: make(map[string]time.Time, len(sched.lastOpen))}
+    for k, v := range sched.lastOpen { st.LastOpen[k] = v }
+    return st
+}
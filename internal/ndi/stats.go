@@ -0,0 +1,10 @@
+package ndi
+
+import "sync/atomic"
+
+// activeReceivers counts open receivers so the server can account for the
+// TCP connections each one holds.
+var activeReceivers atomic.Int64
+
+// ActiveReceivers returns the number of receivers created and not yet closed.
+func ActiveReceivers() int64 { return activeReceivers.Load() }
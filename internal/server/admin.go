@@ -0,0 +1,210 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime/pprof"
+	"strconv"
+	"strings"
+	"time"
+
+	"whep/internal/logging"
+)
+
+const (
+	defaultProfileSeconds = 30
+	maxProfileSeconds     = 300
+)
+
+// isAdminPath reports whether path is under /admin/.
+func isAdminPath(path string) bool { return strings.HasPrefix(path, "/admin/") }
+
+// requireAdmin guards an /admin endpoint. With -admin-token set a request
+// must carry it as "Authorization: Bearer <token>"; without one only
+// loopback clients get in, so nothing reachable from the network can
+// profile the server or change its log level.
+func (s *WhepServer) requireAdmin(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.cfg.AdminToken != "" {
+			got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(s.cfg.AdminToken)) != 1 {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+				writeError(w, r, http.StatusUnauthorized, errCodeUnauthorized, "admin endpoints need the admin token as a bearer token", nil)
+				return
+			}
+		} else if !loopbackClient(r) {
+			writeError(w, r, http.StatusUnauthorized, errCodeUnauthorized, "admin endpoints only answer loopback clients unless -admin-token is set", nil)
+			return
+		}
+		h(w, r)
+	}
+}
+
+// loopbackClient reports whether r came from this host. Behind a proxy on
+// the same host every request does, hence -admin-token.
+func loopbackClient(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// errProfileRunning is returned when a CPU profile is already being taken;
+// the runtime allows one at a time.
+var errProfileRunning = errors.New("a CPU profile is already running")
+
+// GET /admin/loglevel reports the log level; PATCH sets it, with
+// {"level": "debug", "ttlSeconds": 600} reverting after the TTL.
+func (s *WhepServer) handleLogLevel(w http.ResponseWriter, r *http.Request) {
+	allowCORS(w, r)
+	switch r.Method {
+	case http.MethodOptions:
+		w.WriteHeader(http.StatusNoContent)
+		return
+	case http.MethodGet:
+	case http.MethodPatch, http.MethodPost:
+		var req struct {
+			Level      string `json:"level"`
+			TTLSeconds int    `json:"ttlSeconds"`
+		}
+		if err := json.NewDecoder(io.LimitReader(r.Body, 4096)).Decode(&req); err != nil {
+			writeError(w, r, http.StatusBadRequest, errCodeBadRequest, "invalid JSON: "+err.Error(), nil)
+			return
+		}
+		lvl, err := logging.ParseLevel(req.Level)
+		if err != nil || req.Level == "" {
+			writeError(w, r, http.StatusBadRequest, errCodeBadRequest, "level must be debug, info, warn or error", nil)
+			return
+		}
+		if req.TTLSeconds < 0 {
+			writeError(w, r, http.StatusBadRequest, errCodeBadRequest, "ttlSeconds must be >= 0", nil)
+			return
+		}
+		ttl := time.Duration(req.TTLSeconds) * time.Second
+		logging.SetLevel(lvl, ttl)
+		if ttl > 0 {
+			log.Printf("Log level set to %s for %s", lvl, ttl)
+		} else {
+			log.Printf("Log level set to %s", lvl)
+		}
+	default:
+		writeError(w, r, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "method not allowed", nil)
+		return
+	}
+	cur, base, until := logging.Status()
+	out := map[string]any{"level": cur.String(), "configured": base.String()}
+	if !until.IsZero() {
+		out["revertAt"] = until.UTC()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(out)
+}
+
+// POST /admin/profile {"seconds": 30} streams a pprof CPU profile taken
+// over that many seconds (default 30, at most 300).
+func (s *WhepServer) handleProfile(w http.ResponseWriter, r *http.Request) {
+	allowCORS(w, r)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Method != http.MethodPost {
+		writeError(w, r, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "method not allowed", nil)
+		return
+	}
+	var req struct {
+		Seconds int `json:"seconds"`
+	}
+	if v := r.URL.Query().Get("seconds"); v != "" {
+		req.Seconds, _ = strconv.Atoi(v)
+	} else if err := json.NewDecoder(io.LimitReader(r.Body, 4096)).Decode(&req); err != nil && err != io.EOF {
+		writeError(w, r, http.StatusBadRequest, errCodeBadRequest, "invalid JSON: "+err.Error(), nil)
+		return
+	}
+	if req.Seconds == 0 {
+		req.Seconds = defaultProfileSeconds
+	}
+	if req.Seconds < 1 || req.Seconds > maxProfileSeconds {
+		writeError(w, r, http.StatusBadRequest, errCodeBadRequest, fmt.Sprintf("seconds must be 1..%d", maxProfileSeconds), nil)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="cpu-%s.pprof"`, time.Now().UTC().Format("20060102T150405Z")))
+	err := s.profileCPU(r.Context(), w, time.Duration(req.Seconds)*time.Second)
+	if errors.Is(err, errProfileRunning) {
+		w.Header().Del("Content-Disposition")
+		writeError(w, r, http.StatusConflict, errCodeConflict, err.Error(), nil)
+	}
+}
+
+// profileCPU writes a CPU profile of d to out, ending early if ctx is done
+// or the server closes.
+func (s *WhepServer) profileCPU(ctx context.Context, out io.Writer, d time.Duration) error {
+	if err := pprof.StartCPUProfile(out); err != nil {
+		return errProfileRunning
+	}
+	defer pprof.StopCPUProfile()
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+	case <-ctx.Done():
+	case <-s.done:
+	}
+	return nil
+}
+
+// profileToFile takes a CPU profile of d into the state directory and
+// returns its path. The disk guard can cut it short on low space.
+func (s *WhepServer) profileToFile(d time.Duration) (string, error) {
+	dir := s.stateDir()
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done, err := s.disk.start(dir, cancel)
+	if err != nil {
+		return "", err
+	}
+	defer done()
+	path := filepath.Join(dir, fmt.Sprintf("cpu-%s.pprof", time.Now().UTC().Format("20060102T150405Z")))
+	f, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	if err := s.profileCPU(ctx, f, d); err != nil {
+		f.Close()
+		os.Remove(path)
+		return "", err
+	}
+	return path, f.Close()
+}
+
+// stateDir is where server-written artifacts (profiles) go.
+func (s *WhepServer) stateDir() string {
+	if s.cfg.StateDir != "" {
+		return s.cfg.StateDir
+	}
+	return "state"
+}
+
+// logLevelSummary is the /config view of the log level.
+func logLevelSummary() string {
+	cur, base, until := logging.Status()
+	if until.IsZero() {
+		return cur.String()
+	}
+	return fmt.Sprintf("%s (reverts to %s at %s)", cur, base, until.Format(time.RFC3339))
+}
@@ -0,0 +1,90 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRequireAdmin(t *testing.T) {
+	ok := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) }
+	tests := []struct {
+		name, token, remote, auth string
+		want                      int
+	}{
+		{"loopback without token", "", "127.0.0.1:5000", "", http.StatusNoContent},
+		{"ipv6 loopback without token", "", "[::1]:5000", "", http.StatusNoContent},
+		{"remote without token", "", "10.0.0.5:5000", "", http.StatusUnauthorized},
+		{"remote with token", "s3cret", "10.0.0.5:5000", "Bearer s3cret", http.StatusNoContent},
+		{"wrong token", "s3cret", "10.0.0.5:5000", "Bearer guess", http.StatusUnauthorized},
+		{"loopback needs the token too", "s3cret", "127.0.0.1:5000", "", http.StatusUnauthorized},
+		{"not a bearer token", "s3cret", "10.0.0.5:5000", "Basic s3cret", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &WhepServer{cfg: Config{AdminToken: tt.token}}
+			r := httptest.NewRequest(http.MethodGet, "/admin/loglevel", nil)
+			r.RemoteAddr = tt.remote
+			r.Header.Set("Accept", "application/json")
+			if tt.auth != "" {
+				r.Header.Set("Authorization", tt.auth)
+			}
+			w := httptest.NewRecorder()
+			s.requireAdmin(ok)(w, r)
+			if w.Code != tt.want {
+				t.Fatalf("status %d, want %d", w.Code, tt.want)
+			}
+			if tt.want != http.StatusUnauthorized {
+				return
+			}
+			var body struct{ Error apiError }
+			if err := json.NewDecoder(w.Body).Decode(&body); err != nil || body.Error.Code != errCodeUnauthorized {
+				t.Fatalf("body %+v (%v), want code %q", body, err, errCodeUnauthorized)
+			}
+		})
+	}
+}
+
+func TestAdminSendsNoCORS(t *testing.T) {
+	_, ts := newTestServer(t)
+	get := func(path string) *http.Response {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodGet, ts.URL+path, nil)
+		req.Header.Set("Origin", "https://elsewhere.example")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+	resp := get("/admin/loglevel")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET /admin/loglevel from loopback: %d", resp.StatusCode)
+	}
+	if got := resp.Header.Get("Access-Control-Allow-Origin"); got != "" {
+		t.Fatalf("/admin/loglevel allows origin %q", got)
+	}
+	if got := get("/config/capabilities").Header.Get("Access-Control-Allow-Origin"); got != "https://elsewhere.example" {
+		t.Fatalf("public API allows origin %q", got)
+	}
+}
+
+func TestConfigJSONHidesAdminToken(t *testing.T) {
+	_, ts := newTestServer(t, func(c *Config) { c.AdminToken = "s3cret-token" })
+	resp, err := http.Get(ts.URL + "/config.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status %d", resp.StatusCode)
+	}
+	if strings.Contains(string(body), "s3cret-token") || strings.Contains(string(body), "AdminToken") {
+		t.Fatalf("/config.json leaks the admin token: %s", body)
+	}
+}
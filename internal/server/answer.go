@@ -0,0 +1,88 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// Answer modes: when a WHEP POST gets its SDP answer relative to ICE
+// candidate gathering.
+const (
+	// AnswerComplete answers once gathering is done, with every candidate
+	AnswerComplete = "complete"
+	// AnswerEarly answers as soon as the host candidates are in. Later
+	// candidates aren't sent (there is no server-to-client trickle in
+	// WHEP), so viewers must reach a host address directly; GET on the
+	// session resource returns the answer with all of them.
+	AnswerEarly = "early"
+)
+
+// answerSettle is how long early mode waits after the first host candidate
+// for the host's other interfaces.
+const answerSettle = 20 * time.Millisecond
+
+// ParseAnswerMode validates -answer-mode; "" means AnswerComplete.
+func ParseAnswerMode(v string) (string, error) {
+	switch m := strings.ToLower(strings.TrimSpace(v)); m {
+	case "", AnswerComplete:
+		return AnswerComplete, nil
+	case AnswerEarly:
+		return m, nil
+	}
+	return "", fmt.Errorf("want %s or %s, got %q", AnswerComplete, AnswerEarly, v)
+}
+
+func (s *WhepServer) answerMode() string {
+	if s.cfg.AnswerMode == AnswerEarly {
+		return AnswerEarly
+	}
+	return AnswerComplete
+}
+
+// setLocalAnswer applies answer to pc and waits for the candidates the
+// answer mode puts in the SDP.
+func (s *WhepServer) setLocalAnswer(pc *webrtc.PeerConnection, answer webrtc.SessionDescription) error {
+	gatherComplete := webrtc.GatheringCompletePromise(pc)
+	var hostIn chan struct{}
+	if s.answerMode() == AnswerEarly {
+		hostIn = make(chan struct{})
+		var once sync.Once
+		pc.OnICECandidate(func(c *webrtc.ICECandidate) {
+			if c == nil || c.Typ == webrtc.ICECandidateTypeHost {
+				once.Do(func() { close(hostIn) })
+			}
+		})
+	}
+	if err := pc.SetLocalDescription(answer); err != nil {
+		return err
+	}
+	if hostIn == nil {
+		<-gatherComplete
+		return nil
+	}
+	select {
+	case <-gatherComplete:
+	case <-hostIn:
+		select {
+		case <-gatherComplete:
+		case <-time.After(answerSettle):
+		}
+	}
+	return nil
+}
+
+// writeSDP sends an SDP body with an explicit Content-Length, so proxies
+// pass it on whole instead of chunked.
+func writeSDP(w http.ResponseWriter, status int, sdp string) {
+	w.Header().Set("Content-Type", "application/sdp")
+	w.Header().Set("Content-Length", strconv.Itoa(len(sdp)))
+	w.WriteHeader(status)
+	_, _ = io.WriteString(w, sdp)
+}
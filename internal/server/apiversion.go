@@ -0,0 +1,95 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// apiVersionHeader selects the API behaviour a client was written against.
+// It is echoed on every response with the version actually used.
+const apiVersionHeader = "X-WHEP-Api-Version"
+
+// API versions. Version 1 is assumed when the header is absent, so clients
+// that predate versioning see no change.
+const (
+	apiV1 = 1
+	apiV2 = 2
+
+	apiDefaultVersion = apiV1
+	apiLatestVersion  = apiV2
+)
+
+// apiChange is one behavioural difference a version introduces over the
+// previous one. IDs are stable; clients may branch on them.
+type apiChange struct {
+	ID          string `json:"id"`
+	Description string `json:"description"`
+}
+
+// apiVersionInfo is an entry of the changelog served in /config/capabilities.
+type apiVersionInfo struct {
+	Version int         `json:"version"`
+	Changes []apiChange `json:"changes"`
+}
+
+var apiVersions = []apiVersionInfo{
+	{Version: apiV1, Changes: []apiChange{}},
+	{Version: apiV2, Changes: []apiChange{
+		{ID: "strict-delete", Description: "DELETE of an unknown session returns 404 not_found instead of 204"},
+		{ID: "json-errors", Description: "errors always use the JSON envelope, whatever the Accept header"},
+	}},
+}
+
+type apiVersionKey struct{}
+
+// withAPIVersion resolves the requested API version before h runs and
+// rejects versions this server doesn't implement.
+func withAPIVersion(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		v := apiDefaultVersion
+		if raw := strings.TrimSpace(r.Header.Get(apiVersionHeader)); raw != "" {
+			n, err := strconv.Atoi(raw)
+			if err != nil || n < apiV1 || n > apiLatestVersion {
+				allowCORS(w, r)
+				w.Header().Set(apiVersionHeader, strconv.Itoa(apiDefaultVersion))
+				writeError(w, r, http.StatusBadRequest, errCodeBadRequest, "unsupported "+apiVersionHeader+" "+strconv.Quote(raw),
+					map[string]any{"supported": supportedAPIVersions()})
+				return
+			}
+			v = n
+		}
+		w.Header().Set(apiVersionHeader, strconv.Itoa(v))
+		h(w, r.WithContext(context.WithValue(r.Context(), apiVersionKey{}, v)))
+	}
+}
+
+// apiVersion is the version negotiated for r, or the default outside a
+// versioned handler.
+func apiVersion(r *http.Request) int {
+	if r != nil {
+		if v, ok := r.Context().Value(apiVersionKey{}).(int); ok {
+			return v
+		}
+	}
+	return apiDefaultVersion
+}
+
+func supportedAPIVersions() []int {
+	out := make([]int, 0, len(apiVersions))
+	for _, v := range apiVersions {
+		out = append(out, v.Version)
+	}
+	return out
+}
+
+// deleteSession closes session id. Version 1 replies 204 whether or not the
+// session existed; later versions report an unknown id as 404.
+func (s *WhepServer) deleteSession(w http.ResponseWriter, r *http.Request, id string) {
+	if !s.closeSession(id) && apiVersion(r) >= apiV2 {
+		writeError(w, r, http.StatusNotFound, errCodeNotFound, "session not found", nil)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,120 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"path"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+// versioned makes a request with version as its API version header, none
+// if empty, and returns the status, the version echoed and the body.
+func versioned(t *testing.T, method, url, version string) (int, string, string) {
+	t.Helper()
+	req, _ := http.NewRequest(method, url, nil)
+	req.Header.Set("Accept", "application/json")
+	if version != "" {
+		req.Header.Set(apiVersionHeader, version)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, resp.Header.Get(apiVersionHeader), string(b)
+}
+
+func TestAPIVersionNegotiation(t *testing.T) {
+	_, ts := newTestServer(t)
+	tests := []struct {
+		header string
+		echo   string // version the response is made under
+		ok     bool
+	}{
+		{"", "1", true},
+		{"1", "1", true},
+		{"2", "2", true},
+		{" 2 ", "2", true},
+		{"0", "1", false},
+		{"-1", "1", false},
+		{"3", "1", false},
+		{"99", "1", false},
+		{"two", "1", false},
+		{"2.0", "1", false},
+	}
+	for _, tt := range tests {
+		code, echo, body := versioned(t, http.MethodGet, ts.URL+"/config", tt.header)
+		if echo != tt.echo {
+			t.Errorf("%s %q: echoed %q, want %q", apiVersionHeader, tt.header, echo, tt.echo)
+		}
+		if tt.ok {
+			if code != http.StatusOK {
+				t.Errorf("%s %q: %d %s, want 200", apiVersionHeader, tt.header, code, body)
+			}
+			continue
+		}
+		if code != http.StatusBadRequest {
+			t.Errorf("%s %q: %d, want 400", apiVersionHeader, tt.header, code)
+			continue
+		}
+		var env struct {
+			Error struct {
+				Code    string `json:"code"`
+				Details struct {
+					Supported []int `json:"supported"`
+				} `json:"details"`
+			} `json:"error"`
+		}
+		if err := json.Unmarshal([]byte(body), &env); err != nil || env.Error.Code != errCodeBadRequest {
+			t.Errorf("%s %q: body %q, want a %s envelope", apiVersionHeader, tt.header, body, errCodeBadRequest)
+		}
+		if want := []int{apiV1, apiV2}; !reflect.DeepEqual(env.Error.Details.Supported, want) {
+			t.Errorf("%s %q: supported %v, want %v", apiVersionHeader, tt.header, env.Error.Details.Supported, want)
+		}
+	}
+}
+
+// Each route that deletes a session keeps version 1's 204 for an unknown
+// id and reports it as 404 from version 2; a known session is 204 in both.
+func TestDeleteSessionVersions(t *testing.T) {
+	fakePipelines(t)
+	s, ts := newTestServer(t)
+	routes := []string{"/whep/", "/whep/ndi/" + fakeKey + "/sessions/", "/sessions/"}
+	versions := []struct {
+		header  string
+		unknown int
+	}{
+		{"", http.StatusNoContent},
+		{"1", http.StatusNoContent},
+		{"2", http.StatusNotFound},
+	}
+	for _, route := range routes {
+		for _, v := range versions {
+			code, echo, body := versioned(t, http.MethodDelete, ts.URL+route+"no-such-session", v.header)
+			if code != v.unknown {
+				t.Errorf("DELETE %sno-such-session, version %q: %d %s, want %d", route, v.header, code, body, v.unknown)
+			}
+			if want := v.header; (want == "" && echo != strconv.Itoa(apiV1)) || (want != "" && echo != want) {
+				t.Errorf("DELETE %s, version %q: echoed %q", route, v.header, echo)
+			}
+
+			viewer := postOffer(t, ts, "/whep")
+			if viewer.code != http.StatusCreated {
+				t.Fatalf("POST /whep: %d %s", viewer.code, viewer.body)
+			}
+			id := path.Base(viewer.location)
+			if code, _, body := versioned(t, http.MethodDelete, ts.URL+route+id, v.header); code != http.StatusNoContent {
+				t.Errorf("DELETE %s%s, version %q: %d %s, want 204", route, id, v.header, code, body)
+			}
+			for _, left := range sessionIDs(s) {
+				if left == id {
+					t.Errorf("DELETE %s%s, version %q: session still open", route, id, v.header)
+				}
+			}
+		}
+	}
+}
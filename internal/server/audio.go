@@ -0,0 +1,120 @@
+package server
+
+import (
+	"log"
+	"sync"
+
+	"github.com/pion/webrtc/v3"
+
+	"whep/internal/stream"
+)
+
+// audioFeed is the Opus side of a shared pipeline: a second broadcaster that
+// outlives source switches, fed by whichever source is current.
+type audioFeed struct {
+	mu    sync.Mutex
+	bc    *stream.SampleBroadcaster
+	stop  func()
+	queue stream.QueueConfig
+}
+
+// newAudioFeed returns nil when audio is disabled or Opus isn't built in; all
+// audioFeed methods accept a nil receiver.
+func (s *WhepServer) newAudioFeed() *audioFeed {
+	if !s.cfg.Audio || !stream.OpusAvailable() {
+		return nil
+	}
+	return &audioFeed{bc: stream.NewSampleBroadcaster(s.cfg.Queue.Options()...), queue: s.cfg.Queue}
+}
+
+// setSource (re)starts the Opus pipeline for src. Sources without audio
+// (synthetic, Splash) leave the feed silent.
+func (a *audioFeed) setSource(src stream.Source) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.stop != nil {
+		a.stop()
+		a.stop = nil
+	}
+	as, ok := src.(stream.AudioSource)
+	if !ok || a.bc == nil {
+		return
+	}
+	p, err := stream.StartOpusPipeline(stream.AudioPipelineConfig{Source: as, Track: a.bc, Queue: a.queue})
+	if err != nil {
+		log.Printf("Audio pipeline start failed: %v", err)
+		return
+	}
+	a.stop = p.Stop
+}
+
+// relay feeds the feed from a published stream's Opus broadcaster in place
+// of a pipeline, until the next setSource, relay or Close. A publisher that
+// has ended leaves it silent.
+func (a *audioFeed) relay(from *stream.SampleBroadcaster) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.stop != nil {
+		a.stop()
+		a.stop = nil
+	}
+	if a.bc == nil {
+		return
+	}
+	if remove, err := from.Add(a.bc); err == nil {
+		a.stop = remove
+	}
+}
+
+// add attaches a session's audio track; the returned func detaches it. It
+// fails with stream.ErrBroadcasterClosed once the feed is closed.
+func (a *audioFeed) add(track *webrtc.TrackLocalStaticSample) (func(), error) {
+	if a == nil || track == nil {
+		return func() {}, nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.bc == nil {
+		return func() {}, stream.ErrBroadcasterClosed
+	}
+	return a.bc.Add(track)
+}
+
+// Close stops the pipeline and drops all attached tracks.
+func (a *audioFeed) Close() {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.stop != nil {
+		a.stop()
+		a.stop = nil
+	}
+	if a.bc != nil {
+		a.bc.Close()
+		a.bc = nil
+	}
+}
+
+// addAudioTrack adds an Opus track to pc, in the same stream as the video so
+// players keep them in sync. It returns nil when audio is disabled.
+func (s *WhepServer) addAudioTrack(pc *webrtc.PeerConnection) (*webrtc.TrackLocalStaticSample, error) {
+	if !s.cfg.Audio || !stream.OpusAvailable() {
+		return nil, nil
+	}
+	track, err := webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus, ClockRate: 48000, Channels: 2}, "audio", "pion")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := pc.AddTrack(track); err != nil {
+		return nil, err
+	}
+	return track, nil
+}
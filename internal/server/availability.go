@@ -0,0 +1,300 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	// Windows hosts have no zoneinfo database of their own
+	_ "time/tzdata"
+)
+
+// Scheduled availability. A rule in the config file's "availability"
+// section limits a mount (source key or failover alias) to weekly windows:
+// outside them POST /whep/ndi/{mount} is refused with 403 off_air, and at
+// the closing boundary its sessions are ended and its encoders torn down.
+const availabilityInterval = time.Second
+
+// AvailabilityRule is one entry of the config file's "availability" section.
+type AvailabilityRule struct {
+	Mount    string               `json:"mount"`
+	Timezone string               `json:"timezone,omitempty"` // IANA name; default local time
+	Windows  []AvailabilityWindow `json:"windows"`
+}
+
+// AvailabilityWindow opens a mount from Start to End ("HH:MM") on Days
+// ("mon".."sun"; empty means every day). An End at or before Start runs
+// past midnight into the next day.
+type AvailabilityWindow struct {
+	Days  []string `json:"days,omitempty"`
+	Start string   `json:"start"`
+	End   string   `json:"end"`
+}
+
+var weekdayNames = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// weeklyWindow is a parsed AvailabilityWindow; times are minutes past midnight.
+type weeklyWindow struct {
+	days       [7]bool
+	start, end int
+}
+
+type availabilitySchedule struct {
+	loc     *time.Location
+	windows []weeklyWindow
+}
+
+func parseClock(v string) (int, error) {
+	var h, m int
+	if n, err := fmt.Sscanf(v, "%d:%d", &h, &m); err != nil || n != 2 || h < 0 || h > 24 || m < 0 || m > 59 || (h == 24 && m != 0) {
+		return 0, fmt.Errorf("time %q: want HH:MM", v)
+	}
+	return h*60 + m, nil
+}
+
+func parseAvailabilityRule(i int, r AvailabilityRule) (*availabilitySchedule, error) {
+	if strings.TrimSpace(r.Mount) == "" {
+		return nil, fmt.Errorf("rule %d: missing mount", i)
+	}
+	if len(r.Windows) == 0 {
+		return nil, fmt.Errorf("rule %d: no windows", i)
+	}
+	sc := &availabilitySchedule{loc: time.Local}
+	if r.Timezone != "" {
+		loc, err := time.LoadLocation(r.Timezone)
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: %w", i, err)
+		}
+		sc.loc = loc
+	}
+	for _, w := range r.Windows {
+		var ww weeklyWindow
+		var err error
+		if ww.start, err = parseClock(w.Start); err != nil {
+			return nil, fmt.Errorf("rule %d: start %w", i, err)
+		}
+		if ww.end, err = parseClock(w.End); err != nil {
+			return nil, fmt.Errorf("rule %d: end %w", i, err)
+		}
+		if len(w.Days) == 0 {
+			ww.days = [7]bool{true, true, true, true, true, true, true}
+		}
+		for _, d := range w.Days {
+			wd, ok := weekdayNames[strings.ToLower(strings.TrimSpace(d))]
+			if !ok {
+				return nil, fmt.Errorf("rule %d: day %q (want mon..sun)", i, d)
+			}
+			ww.days[wd] = true
+		}
+		sc.windows = append(sc.windows, ww)
+	}
+	return sc, nil
+}
+
+// intervals returns the concrete windows starting from the day before now
+// through a week after it.
+func (sc *availabilitySchedule) intervals(now time.Time) [][2]time.Time {
+	local := now.In(sc.loc)
+	y, mo, d := local.Date()
+	var out [][2]time.Time
+	for off := -1; off <= 7; off++ {
+		day := time.Date(y, mo, d+off, 0, 0, 0, 0, sc.loc)
+		for _, w := range sc.windows {
+			if !w.days[day.Weekday()] {
+				continue
+			}
+			start := time.Date(day.Year(), day.Month(), day.Day(), 0, w.start, 0, 0, sc.loc)
+			end := time.Date(day.Year(), day.Month(), day.Day(), 0, w.end, 0, 0, sc.loc)
+			if w.end <= w.start {
+				end = time.Date(day.Year(), day.Month(), day.Day()+1, 0, w.end, 0, 0, sc.loc)
+			}
+			out = append(out, [2]time.Time{start, end})
+		}
+	}
+	return out
+}
+
+// at reports whether the schedule is open at now, and when that changes:
+// the end of the open stretch (windows that touch are joined) or the next
+// opening. next is zero if the schedule never changes within a week.
+func (sc *availabilitySchedule) at(now time.Time) (open bool, next time.Time) {
+	iv := sc.intervals(now)
+	for _, w := range iv {
+		if !now.Before(w[0]) && now.Before(w[1]) {
+			open, next = true, w[1]
+			break
+		}
+	}
+	if open {
+		for extended := true; extended; {
+			extended = false
+			for _, w := range iv {
+				if !w[0].After(next) && w[1].After(next) {
+					next, extended = w[1], true
+				}
+			}
+		}
+		if next.Sub(now) > 7*24*time.Hour {
+			next = time.Time{} // open around the clock
+		}
+		return true, next
+	}
+	for _, w := range iv {
+		if w[0].After(now) && (next.IsZero() || w[0].Before(next)) {
+			next = w[0]
+		}
+	}
+	return false, next
+}
+
+// availabilityStatus is a mount's schedule state in /ndi/sources.
+type availabilityStatus struct {
+	Open     bool       `json:"open"`
+	ClosesAt *time.Time `json:"closesAt,omitempty"`
+	OpensAt  *time.Time `json:"opensAt,omitempty"`
+}
+
+type availabilitySet struct {
+	mu    sync.Mutex
+	rules map[string]*availabilitySchedule
+	open  map[string]bool // state seen by the last check, for transition events
+}
+
+// setAvailabilityRules replaces the schedule rules after validating all of them.
+func (s *WhepServer) setAvailabilityRules(rules []AvailabilityRule) error {
+	parsed := make(map[string]*availabilitySchedule, len(rules))
+	for i, r := range rules {
+		sc, err := parseAvailabilityRule(i, r)
+		if err != nil {
+			return err
+		}
+		if parsed[r.Mount] != nil {
+			return fmt.Errorf("rule %d: more than one rule for mount %q", i, r.Mount)
+		}
+		parsed[r.Mount] = sc
+	}
+	a := &s.availability
+	a.mu.Lock()
+	a.rules = parsed
+	for key := range a.open {
+		if parsed[key] == nil {
+			delete(a.open, key)
+		}
+	}
+	a.mu.Unlock()
+	return nil
+}
+
+// mountAvailability reports whether key may be viewed at now; a mount
+// without a rule always may. st is nil for those.
+func (s *WhepServer) mountAvailability(key string, now time.Time) (open bool, st *availabilityStatus) {
+	a := &s.availability
+	a.mu.Lock()
+	sc := a.rules[key]
+	a.mu.Unlock()
+	if sc == nil {
+		return true, nil
+	}
+	open, next := sc.at(now)
+	st = &availabilityStatus{Open: open}
+	if !next.IsZero() {
+		if open {
+			st.ClosesAt = &next
+		} else {
+			st.OpensAt = &next
+		}
+	}
+	return open, st
+}
+
+// runAvailability enforces the schedule until the server closes.
+func (s *WhepServer) runAvailability() {
+	ticker := time.NewTicker(availabilityInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case now := <-ticker.C:
+			s.checkAvailability(now)
+		}
+	}
+}
+
+func (s *WhepServer) checkAvailability(now time.Time) {
+	a := &s.availability
+	a.mu.Lock()
+	keys := make([]string, 0, len(a.rules))
+	for key := range a.rules {
+		keys = append(keys, key)
+	}
+	a.mu.Unlock()
+	for _, key := range keys {
+		open, st := s.mountAvailability(key, now)
+		if st == nil {
+			continue // rule removed by a reload since the snapshot
+		}
+		a.mu.Lock()
+		if a.open == nil {
+			a.open = map[string]bool{}
+		}
+		was, seen := a.open[key]
+		a.open[key] = open
+		a.mu.Unlock()
+		if !seen || was != open {
+			ev := map[string]any{"mount": key, "open": open}
+			if st.ClosesAt != nil {
+				ev["closesAt"] = *st.ClosesAt
+			}
+			if st.OpensAt != nil {
+				ev["opensAt"] = *st.OpensAt
+			}
+			s.emitEvent("availability", ev)
+		}
+		if !open {
+			// Every tick, so a POST that passed its check just before the
+			// boundary is caught too
+			s.closeOffAir(key)
+		}
+	}
+}
+
+// closeOffAir ends every session on key's mounts and tears the mounts down.
+func (s *WhepServer) closeOffAir(key string) {
+	if n, m := s.closeMounts(key, "off air"); n > 0 || m > 0 {
+		log.Printf("Mount %s off air: closed %d session(s), %d mount(s)", key, n, m)
+	}
+}
+
+// closeMounts ends every session on source key's mounts, telling viewers
+// reason, and tears the mounts down. It returns how many of each it closed.
+func (s *WhepServer) closeMounts(key, reason string) (int, int) {
+	s.mu.Lock()
+	var ids []string
+	for id, ss := range s.sessions {
+		if base, _, _ := strings.Cut(ss.mountKey, "|"); ss.mountKey != "" && base == key {
+			ids = append(ids, id)
+		}
+	}
+	var mounts []*ndiMount
+	for k, m := range s.mounts {
+		if base, _, _ := strings.Cut(k, "|"); base == key {
+			mounts = append(mounts, m)
+			delete(s.mounts, k)
+			s.changes.bump()
+		}
+	}
+	s.mu.Unlock()
+	for _, id := range ids {
+		s.endSession(id, reason)
+	}
+	for _, m := range mounts {
+		m.teardown()
+	}
+	return len(ids), len(mounts)
+}
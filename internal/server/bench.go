@@ -0,0 +1,236 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"whep/internal/stream"
+	"whep/internal/version"
+)
+
+// Encoder performance baselines. A benchmark encodes benchFrames synthetic
+// 720p frames with the configured codec and settings, at startup with
+// -bench-on-start or on POST /admin/benchmark, and compares ms/frame with
+// the baseline recorded in the state directory for the same settings. A
+// result more than -bench-regress-pct slower is logged loudly and flagged
+// in /health. Baselines only change on POST /admin/benchmark/accept, so a
+// slow library upgrade can't quietly become the new normal.
+
+const (
+	benchFrames            = 30
+	benchWidth             = 1280
+	benchHeight            = 720
+	defaultBenchRegressPct = 25
+	benchBaselineFile      = "bench-baseline.json"
+)
+
+var (
+	// errBenchRunning is returned when a benchmark is already running
+	errBenchRunning = errors.New("a benchmark is already running")
+	// errEncoderBench wraps an encoder that couldn't be benchmarked
+	errEncoderBench = errors.New("encoder benchmark failed")
+	// errNoBenchmark is returned when accepting before any benchmark ran
+	errNoBenchmark = errors.New("no benchmark has run yet")
+)
+
+// benchRecord is a benchmark result with the build that produced it.
+type benchRecord struct {
+	stream.BenchResult
+	Build string `json:"build"`
+}
+
+// benchState is the latest benchmark and its verdict against the baseline.
+type benchState struct {
+	mu        sync.Mutex
+	running   bool
+	last      *benchRecord
+	baseline  *benchRecord // for last's settings, when there is one
+	regressed bool
+}
+
+func (s *WhepServer) benchRegressPct() int {
+	if s.cfg.BenchRegressPct > 0 {
+		return s.cfg.BenchRegressPct
+	}
+	return defaultBenchRegressPct
+}
+
+func (s *WhepServer) benchBaselinePath() string {
+	return filepath.Join(s.stateDir(), benchBaselineFile)
+}
+
+// loadBenchBaselines reads the baselines file, keyed by BenchResult.Key; a
+// missing file is no baselines.
+func (s *WhepServer) loadBenchBaselines() (map[string]benchRecord, error) {
+	out := map[string]benchRecord{}
+	b, err := os.ReadFile(s.benchBaselinePath())
+	if errors.Is(err, os.ErrNotExist) {
+		return out, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &out); err != nil {
+		return nil, fmt.Errorf("%s: %w", s.benchBaselinePath(), err)
+	}
+	return out, nil
+}
+
+// runBenchmark benchmarks the codec new pipelines would use and judges the
+// result against its baseline.
+func (s *WhepServer) runBenchmark() error {
+	s.bench.mu.Lock()
+	if s.bench.running {
+		s.bench.mu.Unlock()
+		return errBenchRunning
+	}
+	s.bench.running = true
+	s.bench.mu.Unlock()
+	defer func() {
+		s.bench.mu.Lock()
+		s.bench.running = false
+		s.bench.mu.Unlock()
+	}()
+
+	codec, ec := s.videoCodec(), s.encoderCfg()
+	res, err := stream.BenchmarkEncoder(codec, stream.PipelineConfig{Width: benchWidth, Height: benchHeight, FPS: ec.fps(), BitrateKbps: ec.BitrateKbps, VP8Speed: ec.VP8Speed, VP8Dropframe: ec.VP8Dropframe}, benchFrames)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errEncoderBench, err)
+	}
+	rec := &benchRecord{BenchResult: res, Build: version.String()}
+	baselines, err := s.loadBenchBaselines()
+	if err != nil {
+		log.Printf("Benchmark: baselines unreadable, comparing against none: %v", err)
+	}
+	var base *benchRecord
+	if b, ok := baselines[res.Key()]; ok {
+		base = &b
+	}
+	pct := s.benchRegressPct()
+	regressed := base != nil && base.MsPerFrame > 0 && res.MsPerFrame > base.MsPerFrame*(1+float64(pct)/100)
+	switch {
+	case base == nil:
+		log.Printf("Benchmark: %s: %.2f ms/frame; no baseline yet, POST /admin/benchmark/accept records this one", res.Key(), res.MsPerFrame)
+	case regressed:
+		log.Printf("WARNING: ENCODER PERFORMANCE REGRESSION: %s: %.2f ms/frame against a baseline of %.2f ms/frame (%s), %+.0f%% with %d%% allowed", res.Key(), res.MsPerFrame, base.MsPerFrame, base.Build, (res.MsPerFrame/base.MsPerFrame-1)*100, pct)
+	default:
+		log.Printf("Benchmark: %s: %.2f ms/frame, baseline %.2f ms/frame", res.Key(), res.MsPerFrame, base.MsPerFrame)
+	}
+	s.bench.mu.Lock()
+	s.bench.last, s.bench.baseline, s.bench.regressed = rec, base, regressed
+	s.bench.mu.Unlock()
+	return nil
+}
+
+// acceptBenchmark makes the latest result the baseline for its settings.
+func (s *WhepServer) acceptBenchmark() error {
+	s.bench.mu.Lock()
+	last := s.bench.last
+	s.bench.mu.Unlock()
+	if last == nil {
+		return errNoBenchmark
+	}
+	baselines, err := s.loadBenchBaselines()
+	if err != nil {
+		return err
+	}
+	baselines[last.Key()] = *last
+	dir := s.stateDir()
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	done, err := s.disk.start(dir, func() {})
+	if err != nil {
+		return err
+	}
+	defer done()
+	b, err := json.MarshalIndent(baselines, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := s.benchBaselinePath() + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, s.benchBaselinePath()); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	s.bench.mu.Lock()
+	if s.bench.last == last {
+		s.bench.baseline, s.bench.regressed = last, false
+	}
+	s.bench.mu.Unlock()
+	log.Printf("Benchmark: baseline for %s set to %.2f ms/frame", last.Key(), last.MsPerFrame)
+	return nil
+}
+
+// benchStatus is GET /admin/benchmark and /health's benchmark entry.
+func (s *WhepServer) benchStatus() map[string]any {
+	s.bench.mu.Lock()
+	defer s.bench.mu.Unlock()
+	out := map[string]any{"running": s.bench.running, "regressed": s.bench.regressed, "regress_pct": s.benchRegressPct()}
+	if s.bench.last != nil {
+		out["last"] = s.bench.last
+	}
+	if s.bench.baseline != nil {
+		out["baseline"] = s.bench.baseline
+	}
+	return out
+}
+
+// GET /admin/benchmark reports the latest benchmark; POST runs one (about
+// a second of encoding) and reports it; POST /admin/benchmark/accept makes
+// the latest result the baseline.
+func (s *WhepServer) handleBenchmark(w http.ResponseWriter, r *http.Request) {
+	allowCORS(w, r)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	path := strings.TrimSuffix(r.URL.Path, "/")
+	if path != "/admin/benchmark" && path != "/admin/benchmark/accept" {
+		writeError(w, r, http.StatusNotFound, errCodeNotFound, "not found", nil)
+		return
+	}
+	accept := path == "/admin/benchmark/accept"
+	switch {
+	case r.Method == http.MethodGet && !accept:
+	case r.Method == http.MethodPost && accept:
+		if err := s.acceptBenchmark(); err != nil {
+			switch {
+			case errors.Is(err, errNoBenchmark):
+				writeError(w, r, http.StatusConflict, errCodeConflict, err.Error(), nil)
+			case errors.Is(err, errDiskLow):
+				writeError(w, r, http.StatusInsufficientStorage, errCodeLimitExceeded, err.Error(), nil)
+			default:
+				writeError(w, r, http.StatusInternalServerError, errCodeInternal, err.Error(), nil)
+			}
+			return
+		}
+	case r.Method == http.MethodPost:
+		if err := s.runBenchmark(); err != nil {
+			switch {
+			case errors.Is(err, errBenchRunning):
+				writeError(w, r, http.StatusConflict, errCodeConflict, err.Error(), nil)
+			case errors.Is(err, errEncoderBench):
+				writeError(w, r, http.StatusUnprocessableEntity, errCodeEncoderUnavailable, err.Error(), map[string]any{"encoders": stream.Capabilities()})
+			default:
+				writeError(w, r, http.StatusInternalServerError, errCodeInternal, err.Error(), nil)
+			}
+			return
+		}
+	default:
+		writeError(w, r, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "method not allowed", nil)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(s.benchStatus())
+}
@@ -0,0 +1,159 @@
+package server
+
+import (
+	"sort"
+	"time"
+)
+
+const (
+	// bweInterval is how often congestion estimates are folded into
+	// encoder targets.
+	bweInterval = time.Second
+	// bweHeadroom is the share of an estimate given to video; the rest
+	// covers audio, RTCP and retransmissions.
+	bweHeadroom = 0.85
+	// bweMinChange skips retargets smaller than this fraction, so estimate
+	// jitter doesn't reconfigure the encoder every tick.
+	bweMinChange = 0.05
+	// audioBitrateKbps is the Opus encoder's default rate, counted in the
+	// estimator's ceiling alongside video.
+	audioBitrateKbps = 128
+)
+
+// estimateKbps is the viewer's available bandwidth: the lower of the TWCC
+// estimate and the last REMB, or 0 when neither is known.
+func (ss *session) estimateKbps() int {
+	est := 0
+	if ss.bwe != nil {
+		est = ss.bwe.GetTargetBitrate() / 1000
+	}
+	if remb := ss.rtcp.rembKbps(); remb > 0 && (est == 0 || remb < est) {
+		est = remb
+	}
+	return est
+}
+
+// network is the session's /health view of viewer network quality.
+func (ss *session) network() map[string]any {
+	out := ss.rtcp.snapshot()
+	out["estimate_kbps"] = ss.estimateKbps()
+	return out
+}
+
+// runBWE periodically retargets encoders to their viewers' estimates.
+func (s *WhepServer) runBWE() {
+	ticker := time.NewTicker(bweInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			s.adaptBitrates()
+		}
+	}
+}
+
+// bweTarget is one encoder and the worst estimate among its viewers.
+type bweTarget struct {
+	pipe       videoPipeline
+	configured int
+	minEst     int
+}
+
+// adaptBitrates sets each running encoder to the minimum estimate across
+// its attached sessions, clamped to [MinBitrateKbps, configured bitrate].
+// The floor keeps one bad connection from degrading a shared stream for
+// everyone; that viewer drops frames instead.
+func (s *WhepServer) adaptBitrates() {
+	targets := map[videoPipeline]*bweTarget{}
+	add := func(p videoPipeline, configured, est int) {
+		if p == nil || est <= 0 {
+			return
+		}
+		t := targets[p]
+		if t == nil {
+			t = &bweTarget{pipe: p, configured: configured, minEst: est}
+			targets[p] = t
+		} else if est < t.minEst {
+			t.minEst = est
+		}
+	}
+	s.mu.Lock()
+	for _, ss := range s.sessions {
+		est := ss.estimateKbps()
+		switch {
+		case ss.mountKey != "":
+			if m := s.mounts[ss.mountKey]; m != nil {
+				m.mu.Lock()
+				p, br := m.pipeFor(ss.detail), m.bitrateKbps
+				m.mu.Unlock()
+				if br <= 0 {
+					br = s.cfg.BitrateKbps
+				}
+				add(p, br, est)
+			}
+		default:
+			add(s.sharePipe, s.cfg.BitrateKbps, est)
+		}
+	}
+	floor := s.cfg.MinBitrateKbps
+	s.mu.Unlock()
+
+	for _, t := range targets {
+		kbps := int(float64(t.minEst) * bweHeadroom)
+		if kbps < floor {
+			kbps = floor
+		}
+		if kbps > t.configured {
+			kbps = t.configured
+		}
+		cur := t.pipe.Bitrate()
+		if cur > 0 && abs(kbps-cur) < int(float64(cur)*bweMinChange) {
+			continue
+		}
+		t.pipe.SetBitrate(kbps)
+	}
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// bitrateStats is the /health view of configured vs. current encoder targets.
+func (s *WhepServer) bitrateStats() map[string]any {
+	type row struct {
+		Mount      string `json:"mount"`
+		Configured int    `json:"configured_kbps"`
+		Target     int    `json:"target_kbps"`
+	}
+	s.mu.Lock()
+	out := map[string]any{
+		"adaptive":        s.cfg.BWE,
+		"configured_kbps": s.cfg.BitrateKbps,
+		"floor_kbps":      s.cfg.MinBitrateKbps,
+	}
+	if s.sharePipe != nil {
+		out["shared"] = row{Mount: sharedMetricsLabel, Configured: s.cfg.BitrateKbps, Target: s.sharePipe.Bitrate()}
+	}
+	rows := make([]row, 0, len(s.mounts))
+	for _, m := range s.mounts {
+		m.mu.Lock()
+		p, br := m.pipe, m.bitrateKbps
+		m.mu.Unlock()
+		if p == nil {
+			continue
+		}
+		if br <= 0 {
+			br = s.cfg.BitrateKbps
+		}
+		rows = append(rows, row{Mount: m.key, Configured: br, Target: p.Bitrate()})
+	}
+	s.mu.Unlock()
+	sort.Slice(rows, func(i, j int) bool { return rows[i].Mount < rows[j].Mount })
+	out["mounts"] = rows
+	return out
+}
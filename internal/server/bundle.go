@@ -0,0 +1,100 @@
+package server
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"runtime/pprof"
+	"time"
+)
+
+// GET /admin/support-bundle returns a zip of what a bug report needs:
+// the monitoring endpoints' replies, the socket counts, a snapshot and the
+// goroutine stacks. It holds nothing /config.json and /admin/snapshot
+// don't already show, so no tokens or guest links.
+
+// bundleEntry is one file in the support bundle: a handler's reply to a
+// GET of path, or write's output when write is set.
+type bundleEntry struct {
+	name    string
+	path    string
+	handler http.HandlerFunc
+	write   func(io.Writer) error
+}
+
+func (s *WhepServer) bundleEntries() []bundleEntry {
+	encode := func(v func() any) func(io.Writer) error {
+		return func(w io.Writer) error {
+			enc := json.NewEncoder(w)
+			enc.SetIndent("", "  ")
+			return enc.Encode(v())
+		}
+	}
+	return []bundleEntry{
+		{name: "version.json", path: "/version", handler: s.handleVersion},
+		{name: "health.json", path: "/health", handler: s.handleHealth},
+		{name: "sockets.json", write: encode(func() any { return s.socketStats() })},
+		{name: "config.json", path: "/config.json", handler: s.handleConfigJSON},
+		{name: "capabilities.json", path: "/config/capabilities", handler: s.handleCapabilities},
+		{name: "loadz.json", path: "/loadz", handler: s.handleLoadz},
+		{name: "sessions.json", path: "/sessions", handler: s.handleSessions},
+		{name: "discovery.json", path: "/ndi/discovery/status", handler: s.handleDiscoveryStatus},
+		{name: "snapshot.json", write: encode(func() any { return s.snapshot() })},
+		{name: "metrics.txt", path: "/metrics", handler: s.handleMetrics},
+		{name: "goroutines.txt", write: func(w io.Writer) error { return pprof.Lookup("goroutine").WriteTo(w, 1) }},
+	}
+}
+
+// entryWriter captures a handler's body into a zip entry. The status is
+// dropped: an entry that failed carries its error body instead.
+type entryWriter struct {
+	io.Writer
+	h http.Header
+}
+
+func (e *entryWriter) Header() http.Header { return e.h }
+func (e *entryWriter) WriteHeader(int)     {}
+
+func (s *WhepServer) handleSupportBundle(w http.ResponseWriter, r *http.Request) {
+	allowCORS(w, r)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Method != http.MethodGet {
+		writeError(w, r, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "method not allowed", nil)
+		return
+	}
+	now := time.Now().UTC()
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="whep-support-%s.zip"`, now.Format("20060102T150405Z")))
+	w.Header().Set("Cache-Control", "no-store")
+	zw := zip.NewWriter(w)
+	for _, e := range s.bundleEntries() {
+		f, err := zw.CreateHeader(&zip.FileHeader{Name: e.name, Method: zip.Deflate, Modified: now})
+		if err != nil {
+			log.Printf("support bundle: %s: %v", e.name, err)
+			return
+		}
+		if e.write != nil {
+			if err := e.write(f); err != nil {
+				log.Printf("support bundle: %s: %v", e.name, err)
+			}
+			continue
+		}
+		req, err := http.NewRequestWithContext(r.Context(), http.MethodGet, e.path, nil)
+		if err != nil {
+			log.Printf("support bundle: %s: %v", e.name, err)
+			continue
+		}
+		req.Header.Set("Accept", "application/json")
+		req.RemoteAddr = r.RemoteAddr
+		e.handler(&entryWriter{Writer: f, h: http.Header{}}, req)
+	}
+	if err := zw.Close(); err != nil {
+		log.Printf("support bundle: %v", err)
+	}
+}
@@ -0,0 +1,84 @@
+package server
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestSupportBundle(t *testing.T) {
+	_, ts := newTestServer(t, func(c *Config) { c.AdminToken = "s3cret-token"; c.MaxSockets = 500 })
+	get := func(auth string) *http.Response {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodGet, ts.URL+"/admin/support-bundle", nil)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+	resp := get("")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("without the token: status %d, want 401", resp.StatusCode)
+	}
+
+	resp = get("Bearer s3cret-token")
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status %d: %s", resp.StatusCode, body)
+	}
+	if cd := resp.Header.Get("Content-Disposition"); !strings.HasPrefix(cd, `attachment; filename="whep-support-`) {
+		t.Errorf("Content-Disposition %q", cd)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		t.Fatalf("not a zip: %v", err)
+	}
+	files := map[string][]byte{}
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[f.Name], _ = io.ReadAll(rc)
+		rc.Close()
+		if bytes.Contains(files[f.Name], []byte("s3cret-token")) {
+			t.Errorf("%s holds the admin token", f.Name)
+		}
+	}
+	for _, name := range []string{"version.json", "health.json", "sockets.json", "config.json", "capabilities.json", "loadz.json", "sessions.json", "discovery.json", "snapshot.json", "metrics.txt", "goroutines.txt"} {
+		if len(files[name]) == 0 {
+			t.Errorf("%s is missing or empty", name)
+		}
+	}
+	var sockets struct {
+		UDP   *int64 `json:"udp"`
+		TCP   *int64 `json:"tcp"`
+		Limit int    `json:"limit"`
+	}
+	if err := json.Unmarshal(files["sockets.json"], &sockets); err != nil || sockets.UDP == nil || sockets.TCP == nil {
+		t.Fatalf("sockets.json %q (%v), want the udp and tcp counts", files["sockets.json"], err)
+	}
+	if sockets.Limit != 500 {
+		t.Errorf("sockets.json limit %d, want 500", sockets.Limit)
+	}
+	var health struct {
+		Status  string         `json:"status"`
+		Sockets map[string]any `json:"sockets"`
+	}
+	if err := json.Unmarshal(files["health.json"], &health); err != nil || health.Status != "ok" || health.Sockets == nil {
+		t.Fatalf("health.json %q (%v)", files["health.json"], err)
+	}
+	if !bytes.Contains(files["goroutines.txt"], []byte("goroutine")) {
+		t.Errorf("goroutines.txt %q has no stacks", files["goroutines.txt"])
+	}
+}
@@ -0,0 +1,310 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Virtual channels. A channel ("pgm", "pvw", "clean") is a stable mount key
+// that points at a changeable source: players subscribe to /whep/ndi/pgm and
+// an operator retargets it with POST /admin/channels/pgm {"source": ...}.
+// The channel's running mounts hot-swap to the new source, so viewers follow
+// without reconnecting. Channels come from the config file's "channels"
+// section (channel -> source); a retarget lasts until the file changes that
+// channel's source or the server restarts.
+
+// channelState is one channel and the source it points at now. source is a
+// mount key, exact name or URL, matched like failover candidates.
+type channelState struct {
+	configured string // source in the config file
+	source     string
+	since      time.Time
+}
+
+// channelStatus is a channel's entry in GET /admin/channels and
+// /ndi/sources.
+type channelStatus struct {
+	Channel string    `json:"channel"`
+	Source  string    `json:"source"`
+	Name    string    `json:"name,omitempty"` // resolved target, if discovered
+	URL     string    `json:"url,omitempty"`
+	Live    bool      `json:"live"` // target present in discovery
+	Since   time.Time `json:"since"`
+}
+
+// channelSet holds the configured channels by lowercase name.
+type channelSet struct {
+	mu    sync.Mutex
+	chans map[string]*channelState
+}
+
+func validateChannels(chans map[string]string) error {
+	for name, src := range chans {
+		switch {
+		case name == "" || slugKey(name, "") != strings.ToLower(name):
+			return fmt.Errorf("channel %q: names may only use letters, digits and '-'", name)
+		case strings.TrimSpace(src) == "":
+			return fmt.Errorf("channel %q: missing source", name)
+		}
+	}
+	return nil
+}
+
+// setChannels installs the config file's channels. A channel whose
+// configured source is unchanged keeps an operator's retarget; one whose
+// source changed is retargeted to it.
+func (s *WhepServer) setChannels(chans map[string]string) error {
+	if err := validateChannels(chans); err != nil {
+		return err
+	}
+	s.failover.mu.Lock()
+	for _, st := range s.failover.rules {
+		for name := range chans {
+			if st.Mount != "" && strings.EqualFold(name, st.Mount) {
+				s.failover.mu.Unlock()
+				return fmt.Errorf("channel %q is also a failover mount", name)
+			}
+		}
+	}
+	s.failover.mu.Unlock()
+	cs := &s.channels
+	cs.mu.Lock()
+	next := make(map[string]*channelState, len(chans))
+	moved := map[string]string{} // channel -> previous source
+	now := time.Now()
+	for name, src := range chans {
+		name = strings.ToLower(name)
+		if st, ok := cs.chans[name]; ok {
+			if st.configured != src {
+				moved[name] = st.source
+				st.configured, st.source, st.since = src, src, now
+			}
+			next[name] = st
+			continue
+		}
+		next[name] = &channelState{configured: src, source: src, since: now}
+	}
+	cs.chans = next
+	cs.mu.Unlock()
+	for name, from := range moved {
+		go s.retargetChannelMounts(name, from, "config file")
+	}
+	return nil
+}
+
+// isChannel reports whether key names a channel.
+func (s *WhepServer) isChannel(key string) bool {
+	s.channels.mu.Lock()
+	defer s.channels.mu.Unlock()
+	_, ok := s.channels.chans[key]
+	return ok
+}
+
+// channelSource resolves a channel to its target. A target missing from
+// discovery resolves by name, so its mount opens it by name and shows the
+// synthetic until it appears.
+func (s *WhepServer) channelSource(key string) (struct{ Name, URL string }, bool) {
+	s.channels.mu.Lock()
+	st, ok := s.channels.chans[key]
+	var q string
+	if ok {
+		q = st.source
+	}
+	s.channels.mu.Unlock()
+	if !ok {
+		return struct{ Name, URL string }{}, false
+	}
+	if si, ok := s.findFailoverSource(q); ok {
+		return si, true
+	}
+	return struct{ Name, URL string }{Name: q}, true
+}
+
+// channelIndex adds every channel to a source index under its own key.
+func (s *WhepServer) channelIndex(out map[string]struct{ Name, URL string }) {
+	for _, name := range s.channelNames() {
+		if si, ok := s.channelSource(name); ok {
+			out[name] = si
+		}
+	}
+}
+
+func (s *WhepServer) channelStatus(name string) (channelStatus, bool) {
+	s.channels.mu.Lock()
+	st, ok := s.channels.chans[name]
+	var cs channelStatus
+	if ok {
+		cs = channelStatus{Channel: name, Source: st.source, Since: st.since}
+	}
+	s.channels.mu.Unlock()
+	if !ok {
+		return cs, false
+	}
+	if si, live := s.findFailoverSource(cs.Source); live {
+		cs.Name, cs.URL, cs.Live = si.Name, si.URL, true
+	}
+	return cs, true
+}
+
+func (s *WhepServer) channelStatuses() []channelStatus {
+	names := s.channelNames()
+	out := make([]channelStatus, 0, len(names))
+	for _, name := range names {
+		if cs, ok := s.channelStatus(name); ok {
+			out = append(out, cs)
+		}
+	}
+	return out
+}
+
+// retargetChannelMounts moves the channel's running mounts from source from
+// to its current target and emits channel_retarget.
+func (s *WhepServer) retargetChannelMounts(name, from, reason string) (string, error) {
+	si, _ := s.channelSource(name)
+	mode, err := s.retargetMounts(name, si.Name, si.URL)
+	data := map[string]any{"channel": name, "from": from, "name": si.Name, "url": si.URL, "mode": mode, "reason": reason}
+	typ := "channel_retarget"
+	if err != nil {
+		data["error"] = err.Error()
+		typ += "_failed"
+	}
+	s.emitEvent(typ, data)
+	return mode, err
+}
+
+// GET /admin/channels lists the channels with their targets; GET
+// /admin/channels/{name} shows one; POST /admin/channels/{name}
+// {"source": "..."} retargets it. The source is matched like /ndi/select
+// (key, exact name or URL, then name substring) and must be present now;
+// naming another channel takes over that channel's current target. With
+// ?dryRun=1 the POST reports the retarget without making it.
+func (s *WhepServer) handleChannels(w http.ResponseWriter, r *http.Request) {
+	allowCORS(w, r)
+	name := strings.ToLower(strings.Trim(strings.TrimPrefix(r.URL.Path, "/admin/channels"), "/"))
+	switch r.Method {
+	case http.MethodOptions:
+		w.WriteHeader(http.StatusNoContent)
+		return
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		if name == "" {
+			_ = json.NewEncoder(w).Encode(map[string]any{"channels": s.channelStatuses()})
+			return
+		}
+		cs, ok := s.channelStatus(name)
+		if !ok {
+			writeError(w, r, http.StatusNotFound, errCodeNotFound, "no channel "+name, nil)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(cs)
+		return
+	case http.MethodPost:
+	default:
+		writeError(w, r, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "method not allowed", nil)
+		return
+	}
+	if name == "" {
+		writeError(w, r, http.StatusBadRequest, errCodeBadRequest, "POST /admin/channels/{name}", nil)
+		return
+	}
+	var body struct {
+		Source string `json:"source"`
+	}
+	if err := json.NewDecoder(io.LimitReader(r.Body, 4096)).Decode(&body); err != nil || strings.TrimSpace(body.Source) == "" {
+		writeError(w, r, http.StatusBadRequest, errCodeBadRequest, "invalid JSON or missing 'source'", nil)
+		return
+	}
+	if !s.isChannel(name) {
+		writeError(w, r, http.StatusNotFound, errCodeNotFound, "no channel "+name, nil)
+		return
+	}
+	si, ok := s.resolveSource(body.Source)
+	if !ok {
+		writeError(w, r, http.StatusNotFound, errCodeSourceNotFound, "source not found: "+body.Source, map[string]string{"source": body.Source})
+		return
+	}
+	target := si.URL
+	if target == "" {
+		target = si.Name
+	}
+	if isDryRun(r) {
+		cur, ok := s.channelStatus(name)
+		if !ok {
+			writeError(w, r, http.StatusNotFound, errCodeNotFound, "no channel "+name, nil)
+			return
+		}
+		var warnings []string
+		if cur.Source == target {
+			warnings = append(warnings, "channel already points at "+target)
+		}
+		mode, n := s.retargetProjection(name)
+		if mode == "hot-swap" {
+			warnings = append(warnings, fmt.Sprintf("%d running mounts hot-swap; one whose new source differs in pixel format or frame delivery restarts instead", n))
+		}
+		out := channelStatus{Channel: name, Source: target, Name: si.Name, URL: si.URL, Live: true, Since: time.Now()}
+		writeDryRun(w, map[string]any{"ok": true, "mode": mode, "channel": out}, warnings)
+		return
+	}
+	cs := &s.channels
+	cs.mu.Lock()
+	st, ok := cs.chans[name]
+	var from string
+	if ok {
+		from = st.source
+		st.source, st.since = target, time.Now()
+	}
+	cs.mu.Unlock()
+	if !ok {
+		// Removed by a config reload meanwhile
+		writeError(w, r, http.StatusNotFound, errCodeNotFound, "no channel "+name, nil)
+		return
+	}
+	mode, err := s.retargetChannelMounts(name, from, "operator")
+	if err != nil {
+		writeError(w, r, http.StatusServiceUnavailable, errCodeSourceUnavailable, err.Error(), nil)
+		return
+	}
+	out, _ := s.channelStatus(name)
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{"ok": true, "mode": mode, "channel": out})
+}
+
+// retargetProjection is the mode retargetMounts would report for alias's
+// mounts, taking a hot swap to succeed, and how many mounts it would move.
+func (s *WhepServer) retargetProjection(alias string) (mode string, mounts int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	mode = "selected"
+	for _, m := range s.mounts {
+		if m.alias != alias {
+			continue
+		}
+		mounts++
+		m.mu.Lock()
+		sw := m.sw
+		m.mu.Unlock()
+		if sw == nil {
+			mode = "restart"
+		} else if mode == "selected" {
+			mode = "hot-swap"
+		}
+	}
+	return mode, mounts
+}
+
+func (s *WhepServer) channelNames() []string {
+	s.channels.mu.Lock()
+	defer s.channels.mu.Unlock()
+	names := make([]string, 0, len(s.channels.chans))
+	for name := range s.channels.chans {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
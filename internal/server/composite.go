@@ -0,0 +1,288 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"whep/internal/stream"
+)
+
+// Composite mounts. POST /mounts/composite lays several sources out on one
+// canvas (see stream.CompositeSource), e.g. a 2x2 monitoring wall that a
+// viewer watches over one PeerConnection instead of four. The composite is
+// listed as source composite-{id} and mounts at /whep/ndi/composite-{id}
+// like any other; each mount of it runs its own canvas. Cells read their
+// source's shared native-size receiver, the one crop mounts use, so a camera
+// on a wall and in crop mounts is still one NDI connection. Composites
+// live until DELETE /mounts/composite/{id} or a restart.
+
+const (
+	compositeScheme   = "composite"
+	maxCompositeCells = 16
+	maxCompositeSide  = 8192
+)
+
+var errCompositeNotFound = errors.New("no such composite")
+
+// compositeCellSpec is a cell of a layout: a source key and the rectangle
+// it is fitted into, in canvas pixels.
+type compositeCellSpec struct {
+	Source string `json:"source"`
+	X      int    `json:"x"`
+	Y      int    `json:"y"`
+	W      int    `json:"w"`
+	H      int    `json:"h"`
+}
+
+// compositeLayout is a composite as created.
+type compositeLayout struct {
+	ID      string              `json:"id"`
+	Key     string              `json:"key"`
+	WHEP    string              `json:"whep"`
+	Width   int                 `json:"w"`
+	Height  int                 `json:"h"`
+	Cells   []compositeCellSpec `json:"cells"`
+	Created time.Time           `json:"created"`
+}
+
+// composites holds the composites by id.
+type composites struct {
+	mu   sync.Mutex
+	byID map[string]*compositeLayout
+}
+
+func init() {
+	registerSourceFactory(&sourceFactory{
+		scheme: compositeScheme,
+		open: func(s *WhepServer, name, url string, w, h int, fps stream.Rate) (stream.Source, error) {
+			l, ok := s.composites.get(strings.TrimPrefix(url, compositeScheme+"://"))
+			if !ok {
+				return nil, errCompositeNotFound
+			}
+			cells := make([]stream.CompositeCell, len(l.Cells))
+			for i, c := range l.Cells {
+				key := c.Source
+				cells[i] = stream.CompositeCell{
+					Rect: stream.Region{X: c.X, Y: c.Y, W: c.W, H: c.H},
+					Open: func() (stream.Source, error) { return s.openCompositeCell(key, fps) },
+				}
+			}
+			src := stream.NewCompositeSource(l.Width, l.Height, fps, cells)
+			if w > 0 && h > 0 && (w != l.Width || h != l.Height) {
+				return stream.NewRegionSource(src, stream.Region{}, w, h, ""), nil
+			}
+			return src, nil
+		},
+	})
+}
+
+// compositeKey is the source key of composite id.
+func compositeKey(id string) string { return compositeScheme + "-" + id }
+
+// openCompositeCell opens a handle on the shared receiver of the source
+// key names now.
+func (s *WhepServer) openCompositeCell(key string, fps stream.Rate) (stream.Source, error) {
+	s.mu.Lock()
+	si, _, ok := s.mountSourceLocked(key)
+	s.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", errSourceNotFound, key)
+	}
+	return s.openCropSource(si.Name, si.URL, fps)
+}
+
+func (c *composites) get(id string) (compositeLayout, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	l, ok := c.byID[id]
+	if !ok {
+		return compositeLayout{}, false
+	}
+	return *l, true
+}
+
+// index adds the composites to a sourceIndex.
+func (c *composites) index(out map[string]struct{ Name, URL string }) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for id, l := range c.byID {
+		out[l.Key] = struct{ Name, URL string }{Name: "Composite " + id, URL: compositeScheme + "://" + id}
+	}
+}
+
+func (c *composites) list() []compositeLayout {
+	c.mu.Lock()
+	out := make([]compositeLayout, 0, len(c.byID))
+	for _, l := range c.byID {
+		out = append(out, *l)
+	}
+	c.mu.Unlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].Created.Before(out[j].Created) })
+	return out
+}
+
+// gridCells lays n cells out as an even grid on a w x h canvas: as many
+// columns as the square root rounds up to, rows as needed.
+func gridCells(n, w, h int) []stream.Region {
+	cols := int(math.Ceil(math.Sqrt(float64(n))))
+	rows := (n + cols - 1) / cols
+	cw, ch := (w/cols)&^1, (h/rows)&^1
+	out := make([]stream.Region, n)
+	for i := range out {
+		out[i] = stream.Region{X: (i % cols) * cw, Y: (i / cols) * ch, W: cw, H: ch}
+	}
+	return out
+}
+
+// checkComposite validates a layout and resolves its cells' sources to
+// mount keys. Cells without a rectangle are laid out as a grid, which
+// needs all of them to leave it out. It returns the HTTP status and error
+// code for a layout it turns down.
+func (s *WhepServer) checkComposite(l *compositeLayout) (int, string, error) {
+	if l.Width == 0 && l.Height == 0 {
+		l.Width, l.Height = 1920, 1080
+	}
+	if l.Width < 2 || l.Height < 2 || l.Width > maxCompositeSide || l.Height > maxCompositeSide {
+		return http.StatusBadRequest, errCodeBadRequest, fmt.Errorf("w and h must be 2 to %d", maxCompositeSide)
+	}
+	l.Width, l.Height = l.Width&^1, l.Height&^1
+	if len(l.Cells) == 0 || len(l.Cells) > maxCompositeCells {
+		return http.StatusBadRequest, errCodeBadRequest, fmt.Errorf("a composite has 1 to %d cells", maxCompositeCells)
+	}
+	grid := true
+	for _, c := range l.Cells {
+		if c.W != 0 || c.H != 0 {
+			grid = false
+		}
+	}
+	var rects []stream.Region
+	if grid {
+		rects = gridCells(len(l.Cells), l.Width, l.Height)
+	}
+	for i := range l.Cells {
+		c := &l.Cells[i]
+		if grid {
+			c.X, c.Y, c.W, c.H = rects[i].X, rects[i].Y, rects[i].W, rects[i].H
+		}
+		if c.X < 0 || c.Y < 0 || c.W < 2 || c.H < 2 || c.X+c.W > l.Width || c.Y+c.H > l.Height {
+			return http.StatusBadRequest, errCodeBadRequest, fmt.Errorf("cell %d: x, y, w and h must lie inside the %dx%d canvas", i, l.Width, l.Height)
+		}
+		c.X, c.Y, c.W, c.H = c.X&^1, c.Y&^1, c.W&^1, c.H&^1
+		src := strings.TrimSpace(c.Source)
+		if src == "" {
+			return http.StatusBadRequest, errCodeBadRequest, fmt.Errorf("cell %d: missing source", i)
+		}
+		key := s.canonicalSourceKey(src)
+		switch {
+		case strings.HasPrefix(key, compositeScheme+"-"):
+			return http.StatusBadRequest, errCodeBadRequest, fmt.Errorf("cell %d: a composite can't hold another", i)
+		case s.whip.get(key) != nil:
+			return http.StatusUnprocessableEntity, errCodeEncoderUnavailable, fmt.Errorf("cell %d: a WHIP source is relayed as published and can't be decoded", i)
+		}
+		s.mu.Lock()
+		_, _, ok := s.mountSourceLocked(key)
+		s.mu.Unlock()
+		if !ok {
+			return http.StatusNotFound, errCodeSourceNotFound, fmt.Errorf("cell %d: source not found: %s", i, src)
+		}
+		c.Source = key
+	}
+	return 0, "", nil
+}
+
+// handleComposites serves /mounts/composite: GET lists the composites (or
+// /mounts/composite/{id} one), POST creates one from a layout, DELETE
+// /mounts/composite/{id} removes one and closes its mounts.
+func (s *WhepServer) handleComposites(w http.ResponseWriter, r *http.Request) {
+	allowCORS(w, r)
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/mounts/composite"), "/")
+	cs := &s.composites
+	switch r.Method {
+	case http.MethodOptions:
+		w.WriteHeader(http.StatusNoContent)
+		return
+	case http.MethodGet:
+		if id == "" {
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(map[string]any{"composites": cs.list()})
+			return
+		}
+		l, ok := cs.get(id)
+		if !ok {
+			writeError(w, r, http.StatusNotFound, errCodeNotFound, "no composite "+id, nil)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(l)
+		return
+	case http.MethodDelete:
+		cs.mu.Lock()
+		l, ok := cs.byID[id]
+		delete(cs.byID, id)
+		cs.mu.Unlock()
+		if !ok {
+			writeError(w, r, http.StatusNotFound, errCodeNotFound, "no composite "+id, nil)
+			return
+		}
+		s.changes.bump()
+		n, m := s.closeMounts(l.Key, "composite removed")
+		log.Printf("Composite %s: removed, closed %d session(s), %d mount(s)", id, n, m)
+		s.emitEvent("composite_removed", map[string]any{"id": id, "key": l.Key})
+		w.WriteHeader(http.StatusNoContent)
+		return
+	case http.MethodPost:
+		if id != "" {
+			writeError(w, r, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "POST /mounts/composite", nil)
+			return
+		}
+	default:
+		writeError(w, r, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "method not allowed", nil)
+		return
+	}
+
+	var l compositeLayout
+	if err := json.NewDecoder(io.LimitReader(r.Body, 16<<10)).Decode(&l); err != nil {
+		writeError(w, r, http.StatusBadRequest, errCodeBadRequest, "invalid JSON", nil)
+		return
+	}
+	if l.ID == "" {
+		l.ID = uuid.New().String()[:8]
+	} else if slugKey(l.ID, "") != strings.ToLower(l.ID) {
+		writeError(w, r, http.StatusBadRequest, errCodeBadRequest, "id may only use letters, digits and '-'", nil)
+		return
+	}
+	l.ID = strings.ToLower(l.ID)
+	if status, code, err := s.checkComposite(&l); err != nil {
+		writeError(w, r, status, code, err.Error(), nil)
+		return
+	}
+	l.Key, l.WHEP, l.Created = compositeKey(l.ID), "/whep/ndi/"+compositeKey(l.ID), time.Now()
+	cs.mu.Lock()
+	if _, taken := cs.byID[l.ID]; taken {
+		cs.mu.Unlock()
+		writeError(w, r, http.StatusConflict, errCodeConflict, "composite "+l.ID+" exists", map[string]string{"id": l.ID})
+		return
+	}
+	if cs.byID == nil {
+		cs.byID = map[string]*compositeLayout{}
+	}
+	cs.byID[l.ID] = &l
+	cs.mu.Unlock()
+	s.changes.bump()
+	s.emitEvent("composite_created", map[string]any{"id": l.ID, "key": l.Key, "w": l.Width, "h": l.Height, "cells": len(l.Cells)})
+	w.Header().Set("Location", "/mounts/composite/"+l.ID)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(l)
+}
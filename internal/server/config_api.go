@@ -0,0 +1,262 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"runtime"
+	"sort"
+	"strings"
+
+	"whep/internal/ndi"
+	"whep/internal/stream"
+	"whep/internal/version"
+)
+
+// handleConfigJSON serves GET /config.json: the effective Config plus the
+// runtime state the HTML /config page shows.
+func (s *WhepServer) handleConfigJSON(w http.ResponseWriter, r *http.Request) {
+	allowCORS(w, r)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Method != http.MethodGet {
+		writeError(w, r, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "method not allowed", nil)
+		return
+	}
+	s.mu.Lock()
+	cfg := s.cfg
+	name, url := s.ndiName, s.ndiURL
+	s.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"config":           cfg,
+		"ndi":              map[string]any{"selected": name, "url": url, "state": s.ndiState(), "sdk": ndi.SDK()},
+		"color_conversion": stream.ColorConversionImpl(),
+		"scale_filter":     scaleFilter(),
+		"yuv_matrix":       stream.YUVMatrix().String(),
+		"yuv_range":        stream.YUVRange().String(),
+		"hwaccel":          stream.GetHWAccelStatus(),
+		"build":            buildInfo(),
+	})
+}
+
+// buildInfo describes the binary: build number and commit, Go version, and
+// the NDI SDK it was compiled against next to the runtime it loaded.
+func buildInfo() map[string]any {
+	info := ndi.SDK()
+	return map[string]any{
+		"version": version.String(),
+		"number":  version.BuildNumber,
+		"commit":  version.GitCommit,
+		"go":      runtime.Version(),
+		"ndi": map[string]any{
+			"buildMajor":     info.BuildMajor,
+			"runtimeVersion": info.Version,
+			"mismatch":       info.Mismatch,
+		},
+	}
+}
+
+// handleVersion serves GET /version: buildInfo as JSON.
+func (s *WhepServer) handleVersion(w http.ResponseWriter, r *http.Request) {
+	allowCORS(w, r)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Method != http.MethodGet {
+		writeError(w, r, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "method not allowed", nil)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(buildInfo())
+}
+
+// handleCapabilities serves GET /config/capabilities: every codec x backend
+// pair with whether it is built in and usable on this host, plus what new
+// pipelines use now.
+func (s *WhepServer) handleCapabilities(w http.ResponseWriter, r *http.Request) {
+	allowCORS(w, r)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Method != http.MethodGet {
+		writeError(w, r, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "method not allowed", nil)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"encoders": stream.Capabilities(),
+		"active":   map[string]any{"codec": s.videoCodec(), "hwaccel": stream.GetHWAccelStatus()},
+		// WHEP answers carry only host candidates; "early" ones may be
+		// sent before the host's last interface is gathered
+		"answer": map[string]any{"mode": s.answerMode(), "candidates": "host", "trickle": false},
+		"api": map[string]any{
+			"header":    apiVersionHeader,
+			"default":   apiDefaultVersion,
+			"supported": supportedAPIVersions(),
+			"versions":  apiVersions,
+		},
+	})
+}
+
+// configUpdate is the body of POST/PATCH /config; omitted fields are unchanged.
+type configUpdate struct {
+	FPS          *stream.Rate `json:"fps"`
+	BitrateKbps  *int         `json:"bitrateKbps"`
+	VP8Speed     *int         `json:"vp8speed"`
+	VP8Dropframe *int         `json:"vp8dropframe"`
+	ScaleFilter  *string      `json:"scaleFilter"`
+}
+
+// Runtime limits for configUpdate; values outside are clamped and reported.
+const (
+	minRuntimeFPS     = stream.MinFPS
+	maxRuntimeFPS     = stream.MaxFPS
+	minRuntimeBitrate = 100
+	maxRuntimeBitrate = 50000
+)
+
+// handleConfigUpdate applies a configUpdate. Encoder-affecting changes restart
+// the shared pipeline and every mount in place, so sessions stay connected.
+// A dry run reports the same applied and clamped values and what would
+// restart, without changing anything.
+func (s *WhepServer) handleConfigUpdate(w http.ResponseWriter, r *http.Request) {
+	var u configUpdate
+	if err := json.NewDecoder(r.Body).Decode(&u); err != nil {
+		writeError(w, r, http.StatusBadRequest, errCodeBadRequest, "invalid JSON: "+err.Error(), nil)
+		return
+	}
+	applied := map[string]any{}
+	clamped := map[string]string{}
+	clampInt := func(name string, v, lo, hi int) int {
+		c := v
+		if c < lo {
+			c = lo
+		} else if c > hi {
+			c = hi
+		}
+		if c != v {
+			clamped[name] = fmt.Sprintf("%d -> %d", v, c)
+		}
+		return c
+	}
+	var filter string
+	if u.ScaleFilter != nil {
+		filter = strings.ToUpper(strings.TrimSpace(*u.ScaleFilter))
+		switch filter {
+		case "NONE", "LINEAR", "BILINEAR", "BOX":
+		default:
+			writeError(w, r, http.StatusBadRequest, errCodeBadRequest, fmt.Sprintf("scaleFilter must be NONE, LINEAR, BILINEAR or BOX (got %q)", *u.ScaleFilter), nil)
+			return
+		}
+	}
+
+	dry := isDryRun(r)
+	restart := false
+	s.mu.Lock()
+	// Changes are made on a copy, and kept unless this is a dry run
+	c := s.cfg
+	cfg := &c
+	if u.FPS != nil {
+		fps := *u.FPS
+		if !fps.Valid() || fps.Float() < minRuntimeFPS {
+			fps = stream.IntRate(minRuntimeFPS)
+		} else if fps.Float() > maxRuntimeFPS {
+			fps = stream.IntRate(maxRuntimeFPS)
+		}
+		if fps != *u.FPS {
+			clamped["fps"] = fmt.Sprintf("%s -> %s", u.FPS, fps)
+		}
+		restart = restart || fps != cfg.FPS
+		cfg.FPS = fps
+		applied["fps"] = fps
+	}
+	if u.BitrateKbps != nil {
+		v := clampInt("bitrateKbps", *u.BitrateKbps, minRuntimeBitrate, maxRuntimeBitrate)
+		restart = restart || v != cfg.BitrateKbps
+		cfg.BitrateKbps = v
+		applied["bitrateKbps"] = v
+	}
+	if u.VP8Speed != nil {
+		v := clampInt("vp8speed", *u.VP8Speed, 0, 8)
+		restart = restart || v != cfg.VP8Speed
+		cfg.VP8Speed = v
+		applied["vp8speed"] = v
+	}
+	if u.VP8Dropframe != nil {
+		v := clampInt("vp8dropframe", *u.VP8Dropframe, 0, 100)
+		restart = restart || v != cfg.VP8Dropframe
+		cfg.VP8Dropframe = v
+		applied["vp8dropframe"] = v
+	}
+	if !dry {
+		s.cfgMu.Lock()
+		s.cfg.FPS, s.cfg.BitrateKbps, s.cfg.VP8Speed, s.cfg.VP8Dropframe = cfg.FPS, cfg.BitrateKbps, cfg.VP8Speed, cfg.VP8Dropframe
+		s.cfgMu.Unlock()
+	}
+	mounts := make([]*ndiMount, 0, len(s.mounts))
+	for _, m := range s.mounts {
+		mounts = append(mounts, m)
+	}
+	shared, sessions := s.shareBC != nil, len(s.sessions)
+	s.mu.Unlock()
+
+	// The scaler reads its filter per frame, so no restart is needed
+	if u.ScaleFilter != nil {
+		if !dry {
+			stream.SetDefaultScaleFilter(strings.ToLower(filter))
+		}
+		applied["scaleFilter"] = filter
+	}
+
+	if dry {
+		restarted := map[string]any{"shared": false, "mounts": 0}
+		var warnings []string
+		if restart {
+			restarted["shared"], restarted["mounts"] = shared, len(mounts)
+			if sessions > 0 {
+				warnings = append(warnings, fmt.Sprintf("restarts encoders serving %d sessions; viewers stay connected but wait for a keyframe", sessions))
+			}
+		}
+		for name, c := range clamped {
+			warnings = append(warnings, fmt.Sprintf("%s clamped: %s", name, c))
+		}
+		sort.Strings(warnings)
+		writeDryRun(w, map[string]any{"applied": applied, "clamped": clamped, "restarted": restarted}, warnings)
+		return
+	}
+
+	restarted := map[string]any{"shared": false, "mounts": 0}
+	if restart {
+		log.Printf("Config updated at runtime (%v); restarting pipelines", applied)
+		if err := s.restartSharedPipeline(); err != nil {
+			log.Printf("Shared pipeline restart failed: %v", err)
+		} else {
+			restarted["shared"] = shared
+		}
+		n := 0
+		for _, m := range mounts {
+			if err := s.restartMount(m); err != nil {
+				log.Printf("Mount %s restart failed: %v", m.key, err)
+				continue
+			}
+			n++
+		}
+		restarted["mounts"] = n
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false) // keep "a -> b" readable
+	_ = enc.Encode(map[string]any{"applied": applied, "clamped": clamped, "restarted": restarted})
+}
+
+// scaleFilter is the default scale filter currently in effect.
+func scaleFilter() string {
+	return strings.ToUpper(stream.DefaultScaleFilter())
+}
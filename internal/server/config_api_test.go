@@ -0,0 +1,72 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"testing"
+
+	"whep/internal/stream"
+)
+
+func TestCapabilities(t *testing.T) {
+	_, ts := newTestServer(t)
+	resp, err := http.Get(ts.URL + "/config/capabilities")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var caps struct {
+		Encoders []stream.Capability `json:"encoders"`
+		Active   struct {
+			Codec string `json:"codec"`
+		} `json:"active"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&caps); err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("status %d: %v", resp.StatusCode, err)
+	}
+	var pairs []string
+	for _, c := range caps.Encoders {
+		pairs = append(pairs, c.Codec+"/"+c.Backend)
+		if c.Available && !c.Built {
+			t.Errorf("%s/%s is available but not built", c.Codec, c.Backend)
+		}
+		if !c.Available && c.Reason == "" {
+			t.Errorf("%s/%s is unavailable without a reason", c.Codec, c.Backend)
+		}
+	}
+	slices.Sort(pairs)
+	if want := []string{"av1/none", "h264/qsv", "vp8/none", "vp9/none"}; !slices.Equal(pairs, want) {
+		t.Errorf("pairs %v, want %v", pairs, want)
+	}
+	if caps.Active.Codec == "" {
+		t.Error("no active codec")
+	}
+}
+
+// An encoder a mount can't have gets 422 with the same table in details.
+func TestEncoderUnavailableListsCapabilities(t *testing.T) {
+	fakePipelines(t)
+	_, ts := newTestServer(t)
+	_, offer := newOffer(t)
+	rep := doError(t, "POST", ts.URL+"/whep/ndi/"+fakeKey+"?codec=h264&backend=none", offer, http.Header{"Accept": {"application/json"}})
+	if rep.status != http.StatusUnprocessableEntity {
+		t.Fatalf("status %d, want 422; body %q", rep.status, rep.body)
+	}
+	if code := envelopeCode(t, rep); code != errCodeEncoderUnavailable {
+		t.Fatalf("code %q, want %q", code, errCodeEncoderUnavailable)
+	}
+	var env struct {
+		Error struct {
+			Details struct {
+				Encoders []stream.Capability `json:"encoders"`
+			} `json:"details"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal([]byte(rep.body), &env); err != nil {
+		t.Fatal(err)
+	}
+	if got := env.Error.Details.Encoders; !slices.Equal(got, stream.Capabilities()) {
+		t.Errorf("details.encoders %+v, want %+v", got, stream.Capabilities())
+	}
+}
@@ -0,0 +1,117 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"time"
+)
+
+// fileConfig is the on-disk JSON configuration loaded from Config.ConfigFile.
+// Sections are optional; a missing section leaves the related feature at its
+// flag/env defaults.
+type fileConfig struct {
+	Ladder   []LadderRung   `json:"ladder,omitempty"`
+	Failover []FailoverRule `json:"failover,omitempty"`
+	Webhooks []string       `json:"webhooks,omitempty"`
+	// Availability limits mounts to weekly viewing windows
+	Availability []AvailabilityRule `json:"availability,omitempty"`
+	// Channels maps virtual channel names to the source each points at
+	Channels map[string]string `json:"channels,omitempty"`
+	// RTSP maps camera names, their mount keys, to rtsp:// URLs
+	RTSP map[string]string `json:"rtsp,omitempty"`
+}
+
+// readConfigFile parses the JSON config file at path.
+func readConfigFile(path string) (*fileConfig, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var fc fileConfig
+	if err := json.Unmarshal(b, &fc); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	return &fc, nil
+}
+
+// applyConfigFile pushes the file's sections into the running server.
+func (s *WhepServer) applyConfigFile(fc *fileConfig) error {
+	// The ladder's default is empty, so a file without one clears it
+	if err := s.ladder.Set(fc.Ladder); err != nil {
+		return fmt.Errorf("ladder: %w", err)
+	}
+	if fc.Failover != nil {
+		if err := s.setFailoverRules(fc.Failover); err != nil {
+			return fmt.Errorf("failover: %w", err)
+		}
+	}
+	if fc.Webhooks != nil {
+		if err := s.hooks.Set(fc.Webhooks); err != nil {
+			return fmt.Errorf("webhooks: %w", err)
+		}
+	}
+	if fc.Availability != nil {
+		if err := s.setAvailabilityRules(fc.Availability); err != nil {
+			return fmt.Errorf("availability: %w", err)
+		}
+	}
+	if fc.Channels != nil {
+		if err := s.setChannels(fc.Channels); err != nil {
+			return fmt.Errorf("channels: %w", err)
+		}
+	}
+	if fc.RTSP != nil {
+		if err := s.setRTSPCameras(fc.RTSP); err != nil {
+			return fmt.Errorf("rtsp: %w", err)
+		}
+	}
+	return nil
+}
+
+// loadConfigFile reads the configured file once at startup and then polls its
+// mtime so edits are picked up without restarting the process.
+func (s *WhepServer) loadConfigFile() {
+	path := s.cfg.ConfigFile
+	if path == "" {
+		return
+	}
+	var lastMod time.Time
+	load := func() {
+		st, err := os.Stat(path)
+		if err != nil {
+			log.Printf("Config file %s: %v", path, err)
+			return
+		}
+		if !st.ModTime().After(lastMod) {
+			return
+		}
+		lastMod = st.ModTime()
+		fc, err := readConfigFile(path)
+		if err == nil {
+			err = s.applyConfigFile(fc)
+		}
+		if err != nil {
+			log.Printf("Config file %s: %v (keeping previous settings)", path, err)
+			return
+		}
+		log.Printf("Config file %s loaded", path)
+	}
+	load()
+	go s.pollConfigFile(load)
+}
+
+// pollConfigFile calls load every few seconds until the server closes.
+func (s *WhepServer) pollConfigFile(load func()) {
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			load()
+		}
+	}
+}
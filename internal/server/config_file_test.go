@@ -0,0 +1,52 @@
+package server
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestConfigFileWithoutLadderClearsIt(t *testing.T) {
+	s, _ := newTestServer(t)
+	path := filepath.Join(t.TempDir(), "whep.json")
+	write := func(body string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(body), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		fc, err := readConfigFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.applyConfigFile(fc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(`{"ladder": [{"w": 64, "h": 36}, {"w": 32, "h": 18}]}`)
+	if n := len(s.ladder.Rungs()); n != 2 {
+		t.Fatalf("%d rungs loaded, want 2", n)
+	}
+	write(`{"webhooks": []}`)
+	if rungs := s.ladder.Rungs(); len(rungs) != 0 {
+		t.Fatalf("ladder kept after the file dropped it: %+v", rungs)
+	}
+}
+
+func TestConfigPollStopsOnClose(t *testing.T) {
+	s, _ := newTestServer(t)
+	exited := make(chan struct{})
+	go func() {
+		s.pollConfigFile(func() {})
+		close(exited)
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_ = s.Close(ctx)
+	select {
+	case <-exited:
+	case <-time.After(time.Second):
+		t.Fatal("config poll still running after Close")
+	}
+}
@@ -0,0 +1,136 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"sync"
+
+	"whep/internal/ndi"
+	"whep/internal/stream"
+)
+
+// Region-of-interest mounts. A mount requested with cx/cy/cw/ch serves that
+// rectangle of the source (in source pixels), cropped before any w/h scale
+// or fit, e.g. one quadrant of a multiview feed. All crop mounts of one
+// source read a single native-size receiver, so four quadrants cost one
+// NDI connection, not four.
+
+// errCropOutside is returned when a crop doesn't fit the live source.
+var errCropOutside = errors.New("crop lies outside the source")
+
+// parseCrop reads cx, cy, cw and ch; none of them is no crop. cw and ch are
+// required, cx and cy default to 0, and all are rounded down to even so
+// chroma stays aligned.
+func parseCrop(q url.Values) (stream.Region, error) {
+	cx, cy, cw, ch := q.Get("cx"), q.Get("cy"), q.Get("cw"), q.Get("ch")
+	if cx == "" && cy == "" && cw == "" && ch == "" {
+		return stream.Region{}, nil
+	}
+	if cw == "" || ch == "" {
+		return stream.Region{}, fmt.Errorf("crop needs cw and ch")
+	}
+	if cx == "" {
+		cx = "0"
+	}
+	if cy == "" {
+		cy = "0"
+	}
+	r, err := stream.ParseRegion(cx + "," + cy + "," + cw + "," + ch)
+	if err != nil {
+		return stream.Region{}, fmt.Errorf("crop: %w", err)
+	}
+	return r, nil
+}
+
+// cropReceivers holds the native-size receiver each source's crop mounts
+// share, keyed by sourceID. An entry whose handles have all stopped is
+// replaced on the next open.
+type cropReceivers struct {
+	mu sync.Mutex
+	m  map[string]*stream.SharedSource
+}
+
+// openCropSource returns a handle on name/url's shared crop receiver,
+// opening it at native size if no crop mount holds it. Stopping the handle
+// releases it; the receiver closes with the last one. It receives the full
+// stream whatever NDI_RECV_BANDWIDTH says, as crops are in its pixels. The
+// receiver is opened without c.mu held, so c.mu may be taken under s.mu.
+func (s *WhepServer) openCropSource(name, url string, fps stream.Rate) (stream.Source, error) {
+	c, id := &s.crops, sourceID(name, url)
+	if sh, ok := c.share(id); ok {
+		return sh, nil
+	}
+	src, err := s.openSourceBandwidth(name, url, 0, 0, fps, ndi.BandwidthHighest)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// Another crop mount may have opened one meanwhile; use the first
+	if h, ok := c.m[id]; ok {
+		if sh, ok := h.Share(); ok {
+			src.Stop()
+			return sh, nil
+		}
+	}
+	if c.m == nil {
+		c.m = map[string]*stream.SharedSource{}
+	}
+	h := stream.NewSharedSource(src)
+	c.m[id] = h
+	return h, nil
+}
+
+// share returns a new handle on id's receiver, if one is open.
+func (c *cropReceivers) share(id string) (*stream.SharedSource, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if h, ok := c.m[id]; ok {
+		return h.Share()
+	}
+	return nil, false
+}
+
+// liveSourceSizeLocked is name/url's native frame size, if a crop receiver
+// or a mount opened at native size has had a frame. Caller holds s.mu.
+func (s *WhepServer) liveSourceSizeLocked(name, url string) (w, h int, ok bool) {
+	s.crops.mu.Lock()
+	rx := s.crops.m[sourceID(name, url)]
+	s.crops.mu.Unlock()
+	if rx != nil && rx.Live() {
+		if _, w, h, ok := rx.Last(); ok && w > 0 && h > 0 {
+			return w, h, true
+		}
+	}
+	for _, m := range s.mounts {
+		m.mu.Lock()
+		var src stream.Source
+		if ow, oh := m.openSize(); ow == 0 && oh == 0 && m.sw != nil && m.name == name && m.url == url && m.bandwidth != ndi.BandwidthLowest {
+			src = m.sw.Current()
+		}
+		m.mu.Unlock()
+		if l, ok := src.(interface {
+			Last() ([]byte, int, int, bool)
+		}); ok && !stream.IsSynthetic(src) {
+			if _, w, h, ok := l.Last(); ok && w > 0 && h > 0 {
+				return w, h, true
+			}
+		}
+	}
+	return 0, 0, false
+}
+
+// checkCropLocked refuses a crop outside name/url's live size. A source not
+// yet seen passes; the crop is clamped, and logged, if it turns out not to
+// fit. Caller holds s.mu.
+func (s *WhepServer) checkCropLocked(name, url string, r stream.Region) error {
+	if r.Empty() {
+		return nil
+	}
+	w, h, ok := s.liveSourceSizeLocked(name, url)
+	if !ok || (r.X+r.W <= w && r.Y+r.H <= h) {
+		return nil
+	}
+	return fmt.Errorf("%w: %s on a %dx%d source", errCropOutside, r, w, h)
+}
@@ -0,0 +1,172 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Duplicate offers. A client on a flaky link may resend its POST after a
+// timeout although the first one succeeded; answering both would create two
+// sessions streaming to one device. Offers are keyed by endpoint, ICE ufrag
+// and DTLS fingerprint, which a new offer never repeats. For the dedupe
+// window after the first POST, an identical one gets that session's answer
+// and Location back instead of a new session. A retry arriving while the
+// first POST is still being answered waits for it.
+
+// defaultOfferDedupeWindow is used when Config.OfferDedupeWindow is 0.
+const defaultOfferDedupeWindow = 30 * time.Second
+
+// offerEntry is the first POST of an offer.
+type offerEntry struct {
+	done     chan struct{} // closed when the first POST has returned
+	id       string        // the session it created; "" if it failed
+	location string
+	expires  time.Time // set at done
+}
+
+// offerCache holds recent offers by key.
+type offerCache struct {
+	mu      sync.Mutex
+	entries map[string]*offerEntry
+	deduped atomic.Uint64
+}
+
+func (s *WhepServer) offerDedupeWindow() time.Duration {
+	if s.cfg.OfferDedupeWindow != 0 {
+		return s.cfg.OfferDedupeWindow
+	}
+	return defaultOfferDedupeWindow
+}
+
+func offerDedupeValue(d time.Duration) string {
+	if d < 0 {
+		return "off"
+	}
+	return d.String()
+}
+
+// offerKey identifies an offer to scope (the endpoint it was posted to), or
+// returns "" when the offer lacks an ICE ufrag and can't be matched.
+func offerKey(scope, sdp string) string {
+	var ufrag, fingerprint string
+	for _, line := range strings.Split(sdp, "\n") {
+		line = strings.TrimSpace(line)
+		if v, ok := strings.CutPrefix(line, "a=ice-ufrag:"); ok && ufrag == "" {
+			ufrag = v
+		} else if v, ok := strings.CutPrefix(line, "a=fingerprint:"); ok && fingerprint == "" {
+			fingerprint = strings.ToLower(v)
+		}
+	}
+	if ufrag == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(scope + "\n" + ufrag + "\n" + fingerprint))
+	return hex.EncodeToString(sum[:])
+}
+
+// dedupeOffer answers a repeated offer from the session its first POST
+// created and reports true. Otherwise the caller creates the session and
+// must call finish with its id and Location ("" on failure) when done.
+func (s *WhepServer) dedupeOffer(w http.ResponseWriter, r *http.Request, scope string, offer []byte) (replayed bool, finish func(id, location string)) {
+	noop := func(string, string) {}
+	if s.offerDedupeWindow() < 0 {
+		return false, noop
+	}
+	key := offerKey(scope, string(offer))
+	if key == "" {
+		return false, noop
+	}
+	c := &s.offers
+	for {
+		now := time.Now()
+		c.mu.Lock()
+		if c.entries == nil {
+			c.entries = map[string]*offerEntry{}
+		}
+		for k, e := range c.entries {
+			if !e.expires.IsZero() && now.After(e.expires) {
+				delete(c.entries, k)
+			}
+		}
+		prev := c.entries[key]
+		if prev == nil {
+			e := &offerEntry{done: make(chan struct{})}
+			c.entries[key] = e
+			c.mu.Unlock()
+			return false, func(id, location string) {
+				c.mu.Lock()
+				e.id, e.location, e.expires = id, location, time.Now().Add(s.offerDedupeWindow())
+				if id == "" {
+					delete(c.entries, key)
+				}
+				c.mu.Unlock()
+				close(e.done)
+			}
+		}
+		c.mu.Unlock()
+		select {
+		case <-prev.done:
+		case <-r.Context().Done():
+			return true, noop
+		}
+		if s.replayOffer(w, r, prev) {
+			return true, noop
+		}
+		// The first POST failed or its session is gone: this one starts over
+		c.mu.Lock()
+		if c.entries[key] == prev {
+			delete(c.entries, key)
+		}
+		c.mu.Unlock()
+	}
+}
+
+// replayOffer writes the response of the first POST for e, if its session
+// is still open.
+func (s *WhepServer) replayOffer(w http.ResponseWriter, r *http.Request, e *offerEntry) bool {
+	s.mu.Lock()
+	sess := s.sessions[e.id]
+	var m *ndiMount
+	if sess != nil && sess.mountKey != "" {
+		m = s.mounts[sess.mountKey]
+	}
+	s.mu.Unlock()
+	if sess == nil {
+		return false
+	}
+	answer := sess.pc.LocalDescription()
+	if answer == nil {
+		return false
+	}
+	s.offers.deduped.Add(1)
+	log.Printf("WHEP session %s: duplicate offer, replaying answer", e.id)
+	allowCORS(w, r)
+	if m != nil {
+		writeMountHeaders(w, m)
+	}
+	w.Header().Set("Location", e.location)
+	s.setLoadHeader(w)
+	writeSDP(w, http.StatusCreated, answer.SDP)
+	return true
+}
+
+// writeMountHeaders reports the encoder settings a mount session gets.
+func writeMountHeaders(w http.ResponseWriter, m *ndiMount) {
+	m.mu.Lock()
+	actualW, actualH, actualFPS, actualBR, aspect, filter, orient := m.width, m.height, m.encFPS, m.bitrateKbps, m.aspect, m.filter, m.orient
+	m.mu.Unlock()
+	if actualW > 0 && actualH > 0 {
+		w.Header().Set("X-Resolution", resolutionValue(actualW, actualH, actualFPS, aspect, filter, orient))
+	}
+	if actualBR > 0 {
+		w.Header().Set("X-Bitrate-Kbps", fmt.Sprintf("%d", actualBR))
+	}
+	w.Header().Set(mountKeyHeader, m.key)
+}
@@ -0,0 +1,149 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"whep/internal/ndi"
+	"whep/internal/stream"
+)
+
+// Discovery control. POST /ndi/discovery/refresh runs a discovery pass now,
+// e.g. a longer one after plugging in a device; GET /ndi/discovery/status
+// shows the last pass. With -ndi-discovery-idle, background discovery
+// pauses while the server has no sessions and no API calls, and resumes
+// with the next call.
+const (
+	defaultRefreshTimeout = 5 * time.Second
+	maxRefreshTimeout     = 30 * time.Second
+	discoveryIdleCheck    = 5 * time.Second
+)
+
+// passiveRoutes don't count as activity that keeps discovery running:
+// monitoring polls, and the status that shows discovery paused.
+var passiveRoutes = map[string]bool{"/health": true, "/metrics": true, "/loadz": true, "/ndi/discovery/status": true}
+
+// noteActivity wraps a route's handler to record the call, resuming
+// discovery if it was paused for idleness.
+func (s *WhepServer) noteActivity(pattern string, h http.HandlerFunc) http.HandlerFunc {
+	if passiveRoutes[pattern] {
+		return h
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.lastActivity.Store(time.Now().UnixNano())
+		if ndi.ResumeDiscovery() {
+			log.Printf("NDI discovery: resumed by %s %s", r.Method, r.URL.Path)
+			s.emitEvent("discovery_resumed", map[string]any{"method": r.Method, "path": r.URL.Path})
+		}
+		h(w, r)
+	}
+}
+
+// runDiscoveryIdle pauses background discovery once the server has had no
+// sessions and no API calls for the idle time.
+func (s *WhepServer) runDiscoveryIdle(idle time.Duration) {
+	ticker := time.NewTicker(min(discoveryIdleCheck, idle))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case now := <-ticker.C:
+			last := time.Unix(0, s.lastActivity.Load())
+			if now.Sub(last) < idle || s.hasSessions() {
+				continue
+			}
+			if ndi.PauseDiscovery() {
+				log.Printf("NDI discovery: paused, no sessions or API calls for %s", now.Sub(last).Round(time.Second))
+				s.emitEvent("discovery_paused", map[string]any{"idle_ms": now.Sub(last).Milliseconds()})
+			}
+		}
+	}
+}
+
+// hasSessions reports whether any session, or a mount recording, is open.
+func (s *WhepServer) hasSessions() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.sessions) > 0 || s.sharePending > 0 {
+		return true
+	}
+	for _, m := range s.mounts {
+		m.mu.Lock()
+		n := len(m.sessions) + m.pending
+		m.mu.Unlock()
+		if n > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// GET /ndi/discovery/status -> ndi.DiscoveryStatus
+func (s *WhepServer) handleDiscoveryStatus(w http.ResponseWriter, r *http.Request) {
+	allowCORS(w, r)
+	switch r.Method {
+	case http.MethodOptions:
+		w.WriteHeader(http.StatusNoContent)
+		return
+	case http.MethodGet:
+	default:
+		writeError(w, r, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "method not allowed", nil)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(ndi.GetDiscoveryStatus())
+}
+
+// POST /ndi/discovery/refresh { "timeoutMs": 5000 } runs a discovery pass of
+// timeoutMs now and answers once the source list is updated.
+func (s *WhepServer) handleDiscoveryRefresh(w http.ResponseWriter, r *http.Request) {
+	allowCORS(w, r)
+	switch r.Method {
+	case http.MethodOptions:
+		w.WriteHeader(http.StatusNoContent)
+		return
+	case http.MethodPost:
+	default:
+		writeError(w, r, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "method not allowed", nil)
+		return
+	}
+	var body struct {
+		TimeoutMs int `json:"timeoutMs"`
+	}
+	if err := json.NewDecoder(io.LimitReader(r.Body, 4<<10)).Decode(&body); err != nil && !errors.Is(err, io.EOF) {
+		writeError(w, r, http.StatusBadRequest, errCodeBadRequest, "invalid JSON", nil)
+		return
+	}
+	timeout := defaultRefreshTimeout
+	if body.TimeoutMs > 0 {
+		timeout = min(time.Duration(body.TimeoutMs)*time.Millisecond, maxRefreshTimeout)
+	}
+	if !ndi.Initialize() {
+		writeError(w, r, http.StatusServiceUnavailable, errCodeSourceUnavailable, stream.ErrNDIUnavailable.Error(), ndi.SDK())
+		return
+	}
+	// The pass waits for its turn with temporary receivers
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	srcs, err := ndi.RefreshSources(ctx, int(timeout.Milliseconds()))
+	cancel()
+	if err != nil {
+		writeError(w, r, http.StatusServiceUnavailable, errCodeLimitExceeded, "discovery did not get a turn within timeoutMs", ndi.GetTempStats())
+		return
+	}
+	if srcs == nil {
+		srcs = []ndi.SourceInfo{}
+	}
+	found := make([]map[string]string, 0, len(srcs))
+	for _, si := range srcs {
+		found = append(found, map[string]string{"name": si.Name, "url": si.URL})
+	}
+	s.emitEvent("discovery_refreshed", map[string]any{"count": len(found), "timeout_ms": timeout.Milliseconds()})
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{"sources": found, "status": ndi.GetDiscoveryStatus()})
+}
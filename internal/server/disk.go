@@ -0,0 +1,159 @@
+package server
+
+import (
+	"errors"
+	"log"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// diskCheckInterval is how often watched directories are stat'ed; writers
+// consult the cached result instead of checking per sample.
+const diskCheckInterval = 5 * time.Second
+
+// errDiskLow is returned when a new write (recording, dump, bundle) would
+// start below the free-space threshold.
+var errDiskLow = errors.New("insufficient free disk space")
+
+// diskGuard tracks free space for the directories disk-writing features use.
+// New writes are refused below minFree; active writers are asked to stop
+// (and finalize) below stopFree so a full disk never affects live streams.
+type diskGuard struct {
+	minFree, stopFree uint64 // bytes; 0 disables the check
+
+	mu     sync.Mutex
+	dirs   map[string]*diskDir
+	nextID int
+}
+
+type diskDir struct {
+	free    uint64
+	err     error
+	checked time.Time
+	writers map[int]func() // stop callbacks of active writers
+}
+
+// newDiskGuard starts a guard that checks its directories until done is
+// closed.
+func newDiskGuard(minFreeMB, stopFreeMB int, done <-chan struct{}) *diskGuard {
+	g := &diskGuard{dirs: map[string]*diskDir{}}
+	if minFreeMB > 0 {
+		g.minFree = uint64(minFreeMB) << 20
+	}
+	if stopFreeMB > 0 {
+		g.stopFree = uint64(stopFreeMB) << 20
+	}
+	go g.run(done)
+	return g
+}
+
+// run checks free space every diskCheckInterval until done is closed.
+func (g *diskGuard) run(done <-chan struct{}) {
+	ticker := time.NewTicker(diskCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			g.check()
+		}
+	}
+}
+
+// dir returns the state for path, registering it for periodic checks.
+// Callers hold g.mu.
+func (g *diskGuard) dir(path string) *diskDir {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	d, ok := g.dirs[path]
+	if !ok {
+		d = &diskDir{writers: map[int]func(){}}
+		d.free, d.err = diskFree(path)
+		d.checked = time.Now()
+		g.dirs[path] = d
+	}
+	return d
+}
+
+// start admits a new writer into path. stop is called once if free space
+// drops below the stop threshold; the returned func unregisters the writer
+// when it finishes on its own.
+func (g *diskGuard) start(path string, stop func()) (func(), error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	d := g.dir(path)
+	if d.err == nil && g.minFree > 0 && d.free < g.minFree {
+		return nil, errDiskLow
+	}
+	g.nextID++
+	id := g.nextID
+	d.writers[id] = stop
+	return func() {
+		g.mu.Lock()
+		delete(d.writers, id)
+		g.mu.Unlock()
+	}, nil
+}
+
+// check refreshes free space and stops writers in directories that crossed
+// the stop threshold.
+func (g *diskGuard) check() {
+	var stops []func()
+	g.mu.Lock()
+	for path, d := range g.dirs {
+		d.free, d.err = diskFree(path)
+		d.checked = time.Now()
+		if d.err != nil || g.stopFree == 0 || d.free >= g.stopFree || len(d.writers) == 0 {
+			continue
+		}
+		log.Printf("Disk %s: %d MB free, below stop threshold; stopping %d writer(s)", path, d.free>>20, len(d.writers))
+		for id, fn := range d.writers {
+			stops = append(stops, fn)
+			delete(d.writers, id)
+		}
+	}
+	g.mu.Unlock()
+	// Writers finalize their files outside the lock
+	for _, fn := range stops {
+		fn()
+	}
+}
+
+// diskDirStat is one watched directory in /health and /metrics.
+type diskDirStat struct {
+	Dir       string `json:"dir"`
+	FreeBytes uint64 `json:"free_bytes"`
+	Low       bool   `json:"low"`
+	Writers   int    `json:"writers"`
+	Error     string `json:"error,omitempty"`
+}
+
+func (g *diskGuard) stats() []diskDirStat {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	out := make([]diskDirStat, 0, len(g.dirs))
+	for path, d := range g.dirs {
+		st := diskDirStat{Dir: path, FreeBytes: d.free, Writers: len(d.writers)}
+		if d.err != nil {
+			st.Error = d.err.Error()
+		} else {
+			st.Low = g.minFree > 0 && d.free < g.minFree
+		}
+		out = append(out, st)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Dir < out[j].Dir })
+	return out
+}
+
+// healthStats is the /health view of disk usage.
+func (g *diskGuard) healthStats() map[string]any {
+	return map[string]any{
+		"min_free_bytes":  g.minFree,
+		"stop_free_bytes": g.stopFree,
+		"dirs":            g.stats(),
+	}
+}
@@ -0,0 +1,40 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDiskGuardStopsOnDone(t *testing.T) {
+	done := make(chan struct{})
+	g := newDiskGuard(0, 0, done)
+	exited := make(chan struct{})
+	go func() {
+		g.run(done)
+		close(exited)
+	}()
+	close(done)
+	select {
+	case <-exited:
+	case <-time.After(time.Second):
+		t.Fatal("disk check loop still running after done closed")
+	}
+}
+
+func TestDiskGuardStopsWritersBelowThreshold(t *testing.T) {
+	done := make(chan struct{})
+	defer close(done)
+	// No disk has an exabyte free
+	g := newDiskGuard(0, 1<<40, done)
+	stopped := 0
+	finish, err := g.start(t.TempDir(), func() { stopped++ })
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer finish()
+	g.check()
+	g.check()
+	if stopped != 1 {
+		t.Fatalf("writer stopped %d times, want 1", stopped)
+	}
+}
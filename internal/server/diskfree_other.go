@@ -0,0 +1,9 @@
+//go:build !linux && !darwin && !freebsd && !windows
+
+package server
+
+import "errors"
+
+func diskFree(path string) (uint64, error) {
+	return 0, errors.New("free space not available on this platform")
+}
@@ -0,0 +1,150 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"whep/internal/stream"
+)
+
+// Dry runs. The mutating admin endpoints (PATCH /config, PATCH
+// /config/ladder, POST /admin/channels/{name} and mount creation with POST
+// /whep/ndi/{key}) take ?dryRun=1: the request goes through the same
+// validation and is answered with the state it would produce, but nothing
+// is changed, started or restarted. The JSON endpoints answer in the shape
+// of a real success with "dryRun": true and a "warnings" list added, so
+// tooling can diff a dry run against the real thing.
+
+// dryRunHeader is set to "1" on every dry-run answer.
+const dryRunHeader = "X-Dry-Run"
+
+// isDryRun reports whether r asks for a dry run (dryRun=1 or true).
+func isDryRun(r *http.Request) bool {
+	b, err := strconv.ParseBool(r.URL.Query().Get("dryRun"))
+	return err == nil && b
+}
+
+// writeDryRun answers a dry run with body, the would-be success response,
+// plus its warnings.
+func writeDryRun(w http.ResponseWriter, body map[string]any, warnings []string) {
+	if warnings == nil {
+		warnings = []string{}
+	}
+	body["dryRun"] = true
+	body["warnings"] = warnings
+	w.Header().Set(dryRunHeader, "1")
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	_ = enc.Encode(body)
+}
+
+// encoderBudgetWarning projects the video encoder count with more started
+// and warns when it goes past the load score's encoder capacity.
+func (s *WhepServer) encoderBudgetWarning(more int) string {
+	rs := stream.GetRuntimeStats()
+	active := int(rs["active_pipelines"] - rs["active_opus"])
+	capacity := s.encoderCapacity()
+	if more <= 0 || active+more <= capacity {
+		return ""
+	}
+	return fmt.Sprintf("would run %d video encoders, over the encoder capacity of %d", active+more, capacity)
+}
+
+// dryRunMount checks a POST /whep/ndi/{key} like the real one, through the
+// codec pick and the limits, and reports the mount it would join or start.
+// The answer is JSON instead of SDP, with the X-Mount-Key and X-Resolution
+// headers the real answer would carry. Without an offer the client is taken
+// to receive every codec.
+func (s *WhepServer) dryRunMount(w http.ResponseWriter, r *http.Request, key string, offer []byte) {
+	var warnings []string
+	offered := offerCodecs(string(offer))
+	if len(offer) == 0 {
+		offered = codecSet{"h264": true, "vp8": true, "vp9": true, "av1": true}
+		warnings = append(warnings, "no offer: the codec is picked as if the client took any")
+	}
+	if open, st := s.mountAvailability(key, time.Now()); !open {
+		details := map[string]any{"key": key}
+		if st.OpensAt != nil {
+			details["nextAvailable"] = st.OpensAt
+		}
+		writeError(w, r, http.StatusForbidden, errCodeOffAir, "mount "+key+" is off air", details)
+		return
+	}
+	if err := s.checkSocketBudget(); err != nil {
+		writeError(w, r, http.StatusServiceUnavailable, errCodeLimitExceeded, err.Error(), s.socketBudgetDetails())
+		return
+	}
+	variant, err := parseVariantQuery(r.URL.Query())
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, errCodeBadRequest, err.Error(), nil)
+		return
+	}
+	codec, ok := s.mountCodec(w, r, offered)
+	if !ok {
+		return
+	}
+	variant.Codec = codec
+
+	overloaded := s.overloaded()
+	s.mu.Lock()
+	refused := s.sessionLimitLocked(key)
+	v := s.canonicalVariantLocked(variant, offered)
+	mountKey := s.mountKeyLocked(key, v)
+	m := s.mounts[mountKey]
+	if overloaded && (m == nil || m.bc == nil) {
+		fw, fh := v.Width, v.Height
+		if v, mountKey = s.downgradeLocked(key, v, offered); v.Width != fw || v.Height != fh {
+			warnings = append(warnings, fmt.Sprintf("load is at -downgrade-load %d: %dx%d steps down to %dx%d", s.cfg.DowngradeLoad, fw, fh, v.Width, v.Height))
+		}
+		m = s.mounts[mountKey]
+	}
+	si, _, found := s.mountSourceLocked(key)
+	s.mu.Unlock()
+	if refused != nil {
+		refuseSessionLimit(w, r, refused)
+		return
+	}
+	if m == nil && !found {
+		writeError(w, r, http.StatusNotFound, errCodeSourceNotFound, fmt.Sprintf("%v: %s", errSourceNotFound, key), map[string]string{"key": key})
+		return
+	}
+
+	if m != nil {
+		// A running mount's codec may be a hardware fallback
+		m.mu.Lock()
+		v.Codec, si.Name, si.URL = m.codec, m.name, m.url
+		m.mu.Unlock()
+		if !offered[v.Codec] {
+			writeError(w, r, http.StatusNotAcceptable, errCodeCodecUnsupported, noCodecError(offered, []string{v.Codec}), noCodecDetails(offered, []string{v.Codec}))
+			return
+		}
+		writeMountHeaders(w, m)
+	} else {
+		if warn := s.encoderBudgetWarning(1); warn != "" {
+			warnings = append(warnings, warn)
+		}
+		if v.Width > 0 && v.Height > 0 && v.FPS.Valid() {
+			w.Header().Set("X-Resolution", resolutionValue(v.Width, v.Height, v.FPS, v.Aspect, v.Filter, v.Orient))
+		}
+		w.Header().Set("X-Bitrate-Kbps", strconv.Itoa(v.BitrateKbps))
+		w.Header().Set(mountKeyHeader, mountKey)
+	}
+	variantInfo := map[string]any{"w": v.Width, "h": v.Height, "fps": fpsValue(v.FPS), "bitrateKbps": v.BitrateKbps, "aspect": v.Aspect, "fit": fitName(v.Aspect), "filter": v.Filter, "keyint": keyintValue(v.Keyint), "rc": v.RC, "crf": v.CRF, "detail": regionValue(v.Detail), "crop": regionValue(v.Crop), "rotate": v.Orient.Rotate, "flip": v.Orient.Flip, "bandwidth": v.Bandwidth}
+	switch v.Codec {
+	case "vp9":
+		variantInfo["vp9"] = v.VP9.String()
+	case "av1":
+		variantInfo["av1"] = v.AV1.String()
+	}
+	writeDryRun(w, map[string]any{
+		"mount":   mountKey,
+		"exists":  m != nil,
+		"source":  map[string]string{"name": si.Name, "url": si.URL},
+		"codec":   v.Codec,
+		"variant": variantInfo,
+	}, warnings)
+}
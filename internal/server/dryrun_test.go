@@ -0,0 +1,255 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// observedState is what a client or operator can see change: the config,
+// ladder and channel endpoints, the mounts, and encoder starts.
+type observedState struct {
+	config, ladder, channels string
+	mounts                   []string
+	sessions                 int
+	started                  int32
+}
+
+func observe(t *testing.T, s *WhepServer, ts *httptest.Server, started *atomic.Int32) observedState {
+	t.Helper()
+	get := func(path string) string {
+		t.Helper()
+		resp, err := http.Get(ts.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("GET %s: %d %s", path, resp.StatusCode, b)
+		}
+		return string(b)
+	}
+	st := observedState{config: get("/config.json"), ladder: get("/config/ladder"), channels: get("/admin/channels"), started: started.Load()}
+	s.mu.Lock()
+	for key := range s.mounts {
+		st.mounts = append(st.mounts, key)
+	}
+	st.sessions = len(s.sessions)
+	s.mu.Unlock()
+	slices.Sort(st.mounts)
+	return st
+}
+
+// send makes a JSON request and returns the status, headers and decoded body.
+func send(t *testing.T, method, url, body string) (int, http.Header, map[string]any) {
+	t.Helper()
+	req, _ := http.NewRequest(method, url, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, _ := io.ReadAll(resp.Body)
+	var out map[string]any
+	if err := json.Unmarshal(b, &out); err != nil {
+		t.Fatalf("%s %s: %d %q: %v", method, url, resp.StatusCode, b, err)
+	}
+	return resp.StatusCode, resp.Header, out
+}
+
+// jsonShape reduces a decoded JSON value to its structure: objects keep
+// their keys, arrays their first element and everything else its type.
+func jsonShape(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		out := map[string]any{}
+		for k, e := range v {
+			out[k] = jsonShape(e)
+		}
+		return out
+	case []any:
+		if len(v) == 0 {
+			return []any{}
+		}
+		return []any{jsonShape(v[0])}
+	default:
+		return fmt.Sprintf("%T", v)
+	}
+}
+
+// checkDryRun checks a dry-run answer's markers and that, without them, it
+// has the shape of the real success.
+func checkDryRun(t *testing.T, h http.Header, dry, real map[string]any) {
+	t.Helper()
+	if h.Get(dryRunHeader) != "1" {
+		t.Errorf("%s header %q, want 1", dryRunHeader, h.Get(dryRunHeader))
+	}
+	if dry["dryRun"] != true {
+		t.Errorf("dryRun %v, want true", dry["dryRun"])
+	}
+	if _, ok := dry["warnings"].([]any); !ok {
+		t.Errorf("warnings %v, want a list", dry["warnings"])
+	}
+	delete(dry, "dryRun")
+	delete(dry, "warnings")
+	if got, want := jsonShape(dry), jsonShape(real); !reflect.DeepEqual(got, want) {
+		t.Errorf("dry run shape %v, success shape %v", got, want)
+	}
+}
+
+// dryRunServer is a server with a running mount on the fake source and
+// one on channel pgm, which points at it.
+func dryRunServer(t *testing.T) (*WhepServer, *httptest.Server, *atomic.Int32) {
+	t.Helper()
+	started, _ := fakePipelines(t)
+	s, ts := newTestServer(t)
+	if err := s.setChannels(map[string]string{"pgm": "Fake"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"/whep/ndi/" + fakeKey, "/whep/ndi/pgm"} {
+		if v := postOffer(t, ts, path); v.code != http.StatusCreated {
+			t.Fatalf("POST %s: %d %s", path, v.code, v.body)
+		}
+	}
+	return s, ts, started
+}
+
+func checkUnchanged(t *testing.T, before, after observedState) {
+	t.Helper()
+	if !reflect.DeepEqual(before, after) {
+		t.Errorf("dry run changed the server:\nbefore %+v\nafter  %+v", before, after)
+	}
+}
+
+func TestDryRunConfigUpdate(t *testing.T) {
+	s, ts, started := dryRunServer(t)
+	before := observe(t, s, ts, started)
+	// The filter is global to the process, so keep the one in effect
+	body := fmt.Sprintf(`{"fps": 25, "bitrateKbps": 99999, "vp8speed": 2, "scaleFilter": %q}`, scaleFilter())
+	code, h, dry := send(t, http.MethodPatch, ts.URL+"/config?dryRun=1", body)
+	if code != http.StatusOK {
+		t.Fatalf("dry run: %d %v", code, dry)
+	}
+	checkUnchanged(t, before, observe(t, s, ts, started))
+	if r, _ := dry["restarted"].(map[string]any); r["shared"] != false || r["mounts"] != float64(2) {
+		t.Errorf("dry run restarted %v, want the 2 mounts", dry["restarted"])
+	}
+
+	code, _, real := send(t, http.MethodPatch, ts.URL+"/config", body)
+	if code != http.StatusOK {
+		t.Fatalf("PATCH: %d %v", code, real)
+	}
+	if !reflect.DeepEqual(dry["applied"], real["applied"]) || !reflect.DeepEqual(dry["clamped"], real["clamped"]) {
+		t.Errorf("dry run applied %v clamped %v, PATCH %v %v", dry["applied"], dry["clamped"], real["applied"], real["clamped"])
+	}
+	checkDryRun(t, h, dry, real)
+	if n := started.Load() - before.started; n != 2 {
+		t.Errorf("PATCH started %d pipelines, want 2", n)
+	}
+}
+
+func TestDryRunLadder(t *testing.T) {
+	s, ts, started := dryRunServer(t)
+	before := observe(t, s, ts, started)
+	body := `{"rungs": [{"name": "sd", "w": 641, "h": 360, "bitrateKbps": 800}, {"name": "hd", "w": 1280, "h": 720}]}`
+	code, h, dry := send(t, http.MethodPatch, ts.URL+"/config/ladder?dryRun=1", body)
+	if code != http.StatusOK {
+		t.Fatalf("dry run: %d %v", code, dry)
+	}
+	checkUnchanged(t, before, observe(t, s, ts, started))
+
+	code, _, real := send(t, http.MethodPatch, ts.URL+"/config/ladder", body)
+	if code != http.StatusOK {
+		t.Fatalf("PATCH: %d %v", code, real)
+	}
+	if !reflect.DeepEqual(dry["rungs"], real["rungs"]) {
+		t.Errorf("dry run rungs %v, PATCH installed %v", dry["rungs"], real["rungs"])
+	}
+	checkDryRun(t, h, dry, real)
+}
+
+func TestDryRunChannelRetarget(t *testing.T) {
+	s, ts, started := dryRunServer(t)
+	before := observe(t, s, ts, started)
+	code, h, dry := send(t, http.MethodPost, ts.URL+"/admin/channels/pgm?dryRun=1", `{"source": "Splash"}`)
+	if code != http.StatusOK {
+		t.Fatalf("dry run: %d %v", code, dry)
+	}
+	checkUnchanged(t, before, observe(t, s, ts, started))
+
+	code, _, real := send(t, http.MethodPost, ts.URL+"/admin/channels/pgm", `{"source": "Splash"}`)
+	if code != http.StatusOK {
+		t.Fatalf("POST: %d %v", code, real)
+	}
+	dc, _ := dry["channel"].(map[string]any)
+	rc, _ := real["channel"].(map[string]any)
+	if dc["source"] != rc["source"] || dc["name"] != "Splash" {
+		t.Errorf("dry run channel %v, POST %v", dc, rc)
+	}
+	checkDryRun(t, h, dry, real)
+}
+
+func TestDryRunMount(t *testing.T) {
+	s, ts, started := dryRunServer(t)
+	_, offer := newOffer(t)
+	for _, tt := range []struct {
+		query  string
+		exists bool
+	}{
+		{"", true},
+		{"?w=32&h=18", false},
+	} {
+		before := observe(t, s, ts, started)
+		path := "/whep/ndi/" + fakeKey + tt.query
+		sep := "?"
+		if tt.query != "" {
+			sep = "&"
+		}
+		req, _ := http.NewRequest(http.MethodPost, ts.URL+path+sep+"dryRun=1", strings.NewReader(offer))
+		req.Header.Set("Content-Type", "application/sdp")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var dry map[string]any
+		err = json.NewDecoder(resp.Body).Decode(&dry)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || err != nil {
+			t.Fatalf("%s dry run: %d %v", path, resp.StatusCode, err)
+		}
+		checkUnchanged(t, before, observe(t, s, ts, started))
+		if resp.Header.Get(dryRunHeader) != "1" || dry["dryRun"] != true {
+			t.Errorf("%s: dry run not marked", path)
+		}
+		if dry["exists"] != tt.exists {
+			t.Errorf("%s: exists %v, want %v", path, dry["exists"], tt.exists)
+		}
+
+		// The real POST lands on the mount the dry run named, with the
+		// same headers
+		v := postOffer(t, ts, path)
+		if v.code != http.StatusCreated {
+			t.Fatalf("%s: POST %d %s", path, v.code, v.body)
+		}
+		s.mu.Lock()
+		var key string
+		for _, ss := range s.sessions {
+			if ss.pc != nil && v.location != "" && strings.HasSuffix(v.location, ss.id) {
+				key = ss.mountKey
+			}
+		}
+		s.mu.Unlock()
+		if want := resp.Header.Get(mountKeyHeader); key != want || dry["mount"] != want {
+			t.Errorf("%s: dry run named mount %v (header %q), POST joined %q", path, dry["mount"], want, key)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// Error codes of the JSON error envelope. They are a stable part of the API:
+// clients should branch on the code, never on the message text.
+const (
+	errCodeBadRequest         = "bad_request"         // malformed body or parameters
+	errCodeInvalidSDP         = "invalid_sdp"         // empty or unparseable offer
+	errCodeMethodNotAllowed   = "method_not_allowed"  // wrong HTTP method for the endpoint
+	errCodeNotFound           = "not_found"           // unknown session or resource
+	errCodeSourceNotFound     = "source_not_found"    // mount key doesn't match a known source
+	errCodeSourceUnavailable  = "source_unavailable"  // source exists but can't deliver frames
+	errCodeCodecUnsupported   = "codec_unsupported"   // offer has no codec the server can send
+	errCodeEncoderUnavailable = "encoder_unavailable" // requested codec/backend isn't built or detected
+	errCodeLimitExceeded      = "limit_exceeded"      // a configured capacity limit was hit
+	errCodeConflict           = "conflict"            // request clashes with pending state
+	errCodeDraining           = "draining"            // server is shutting down, no new sessions
+	errCodeOffAir             = "off_air"             // mount is outside its scheduled availability
+	errCodeUnauthorized       = "unauthorized"        // missing or invalid credentials
+	errCodeGone               = "gone"                // link expired or revoked
+	errCodeInternal           = "internal"            // unexpected server-side failure
+)
+
+// apiError is the body of a JSON error response: {"error": {...}}.
+type apiError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Details any    `json:"details,omitempty"`
+}
+
+// writeError replies with status and an error envelope when the client
+// accepts JSON, or with the plain-text message otherwise (the pre-envelope
+// behaviour existing clients rely on). API version 2 always gets JSON.
+func writeError(w http.ResponseWriter, r *http.Request, status int, code, msg string, details any) {
+	if apiVersion(r) < apiV2 && !acceptsJSON(r) {
+		http.Error(w, msg, status)
+		return
+	}
+	h := w.Header()
+	h.Del("Content-Length")
+	h.Set("Content-Type", "application/json")
+	h.Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]apiError{"error": {Code: code, Message: msg, Details: details}})
+}
+
+// acceptsJSON reports whether the Accept header names a JSON media type.
+// Wildcards don't count, so curl and older clients keep getting text.
+func acceptsJSON(r *http.Request) bool {
+	if r == nil {
+		return false
+	}
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mt, _, _ := strings.Cut(strings.TrimSpace(part), ";")
+		mt = strings.ToLower(strings.TrimSpace(mt))
+		if mt == "application/json" || strings.HasSuffix(mt, "+json") {
+			return true
+		}
+	}
+	return false
+}
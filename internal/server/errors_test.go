@@ -0,0 +1,105 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// errorReply is a response to a request expected to fail.
+type errorReply struct {
+	status      int
+	contentType string
+	body        string
+}
+
+func doError(t *testing.T, method, url, body string, header http.Header) errorReply {
+	t.Helper()
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, vs := range header {
+		req.Header[k] = vs
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, _ := io.ReadAll(resp.Body)
+	return errorReply{resp.StatusCode, resp.Header.Get("Content-Type"), string(b)}
+}
+
+// envelopeCode decodes a JSON error envelope and returns its code, failing
+// the test if the body isn't one or has no message.
+func envelopeCode(t *testing.T, rep errorReply) string {
+	t.Helper()
+	if rep.contentType != "application/json" {
+		t.Fatalf("Content-Type %q, want application/json; body %q", rep.contentType, rep.body)
+	}
+	var env struct {
+		Error *apiError `json:"error"`
+	}
+	if err := json.Unmarshal([]byte(rep.body), &env); err != nil || env.Error == nil {
+		t.Fatalf("not an error envelope: %q (%v)", rep.body, err)
+	}
+	if env.Error.Message == "" {
+		t.Errorf("envelope %q has no message", rep.body)
+	}
+	return env.Error.Code
+}
+
+func TestErrorEnvelope(t *testing.T) {
+	fakePipelines(t)
+	_, ts := newTestServer(t)
+	_, offer := newOffer(t)
+	cases := []struct {
+		name, method, path, body string
+		header                   http.Header
+		status                   int
+		code                     string
+	}{
+		{"empty offer", "POST", "/whep", "", nil, http.StatusBadRequest, errCodeInvalidSDP},
+		{"unknown source", "POST", "/whep/ndi/no-such-source", offer, nil, http.StatusNotFound, errCodeSourceNotFound},
+		{"unknown codec", "POST", "/whep/ndi/" + fakeKey + "?codec=nope", offer, nil, http.StatusUnprocessableEntity, errCodeEncoderUnavailable},
+		{"method", "PUT", "/whep", "", nil, http.StatusMethodNotAllowed, errCodeMethodNotAllowed},
+		{"unknown session", "DELETE", "/whep/no-such-session", "", http.Header{apiVersionHeader: {"2"}}, http.StatusNotFound, errCodeNotFound},
+		{"unsupported version", "GET", "/config", "", http.Header{apiVersionHeader: {"99"}}, http.StatusBadRequest, errCodeBadRequest},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			h := http.Header{"Accept": {"application/json"}}
+			for k, vs := range c.header {
+				h[k] = vs
+			}
+			rep := doError(t, c.method, ts.URL+c.path, c.body, h)
+			if rep.status != c.status {
+				t.Fatalf("status %d, want %d; body %q", rep.status, c.status, rep.body)
+			}
+			if code := envelopeCode(t, rep); code != c.code {
+				t.Errorf("code %q, want %q", code, c.code)
+			}
+		})
+	}
+}
+
+func TestErrorPlainTextWithoutJSONAccept(t *testing.T) {
+	_, ts := newTestServer(t)
+	for _, accept := range []string{"", "*/*", "text/plain"} {
+		rep := doError(t, "POST", ts.URL+"/whep", "", http.Header{"Accept": {accept}})
+		if rep.status != http.StatusBadRequest {
+			t.Fatalf("Accept %q: status %d, want 400", accept, rep.status)
+		}
+		if !strings.HasPrefix(rep.contentType, "text/plain") || strings.HasPrefix(rep.body, "{") {
+			t.Errorf("Accept %q: got %q %q, want the plain-text message", accept, rep.contentType, rep.body)
+		}
+	}
+	// Version 2 gets the envelope whatever it accepts
+	rep := doError(t, "POST", ts.URL+"/whep", "", http.Header{apiVersionHeader: {"2"}})
+	if code := envelopeCode(t, rep); code != errCodeInvalidSDP {
+		t.Errorf("v2: code %q, want %q", code, errCodeInvalidSDP)
+	}
+}
@@ -0,0 +1,74 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// webhookTimeout bounds one webhook delivery; events are best-effort.
+const webhookTimeout = 5 * time.Second
+
+// event is the JSON body POSTed to each webhook.
+type event struct {
+	Type string    `json:"type"`
+	Time time.Time `json:"time"`
+	Data any       `json:"data,omitempty"`
+}
+
+// webhooks holds the URLs from the config file's "webhooks" section.
+type webhooks struct {
+	mu   sync.Mutex
+	urls []string
+}
+
+// Set replaces the webhook list after checking every URL is absolute http(s).
+func (wh *webhooks) Set(urls []string) error {
+	for _, u := range urls {
+		p, err := url.Parse(u)
+		if err != nil || (p.Scheme != "http" && p.Scheme != "https") || p.Host == "" {
+			return fmt.Errorf("webhook %q: want an absolute http(s) URL", u)
+		}
+	}
+	wh.mu.Lock()
+	wh.urls = append([]string(nil), urls...)
+	wh.mu.Unlock()
+	return nil
+}
+
+func (wh *webhooks) list() []string {
+	wh.mu.Lock()
+	defer wh.mu.Unlock()
+	return wh.urls
+}
+
+// emitEvent logs an event and delivers it to every webhook in the
+// background. Delivery isn't retried.
+func (s *WhepServer) emitEvent(typ string, data any) {
+	ev := event{Type: typ, Time: time.Now().UTC(), Data: data}
+	body, err := json.Marshal(ev)
+	if err != nil {
+		log.Printf("Event %s: %v", typ, err)
+		return
+	}
+	log.Printf("Event %s: %v", typ, data)
+	for _, u := range s.hooks.list() {
+		go func(u string) {
+			client := http.Client{Timeout: webhookTimeout}
+			resp, err := client.Post(u, "application/json", bytes.NewReader(body))
+			if err != nil {
+				log.Printf("Webhook %s: %v", u, err)
+				return
+			}
+			resp.Body.Close()
+			if resp.StatusCode >= 300 {
+				log.Printf("Webhook %s: %s", u, resp.Status)
+			}
+		}(u)
+	}
+}
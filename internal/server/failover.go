@@ -0,0 +1,583 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"whep/internal/stream"
+)
+
+// Automatic failover. A rule watches discovery for the source its program is
+// on; once that source has been missing for LostAfterSec, the program moves
+// to the first listed candidate (primary, then backups in order) that is
+// present. The shared /whep program follows a rule without a Mount; a rule
+// with one serves /whep/ndi/{mount} as an alias that follows it. With a
+// -slate and no candidate present, the program goes to the slate until one
+// is back.
+const (
+	failoverInterval    = time.Second
+	defaultLostAfterSec = 5
+	defaultStableSec    = 10
+
+	failbackAuto   = "auto"   // return to the primary once it has been back StableSec
+	failbackManual = "manual" // stay on the backup until an operator fails back
+)
+
+// FailoverRule is one entry of the config file's "failover" section.
+// Sources are named by mount key, exact name or URL; unlike /ndi/select
+// there is no substring match, so "PGM" is not present just because
+// "PGM Backup" is.
+type FailoverRule struct {
+	Mount        string   `json:"mount,omitempty"`
+	Primary      string   `json:"primary"`
+	Backups      []string `json:"backups"`
+	LostAfterSec int      `json:"lostAfterSec,omitempty"` // default 5
+	Failback     string   `json:"failback,omitempty"`     // "auto" (default) or "manual"
+	StableSec    int      `json:"stableSec,omitempty"`    // default 10
+}
+
+func (r FailoverRule) candidates() []string { return append([]string{r.Primary}, r.Backups...) }
+
+func (r FailoverRule) lostAfter() time.Duration {
+	if r.LostAfterSec > 0 {
+		return time.Duration(r.LostAfterSec) * time.Second
+	}
+	return defaultLostAfterSec * time.Second
+}
+
+func (r FailoverRule) stable() time.Duration {
+	if r.StableSec > 0 {
+		return time.Duration(r.StableSec) * time.Second
+	}
+	return defaultStableSec * time.Second
+}
+
+func validateFailoverRules(rules []FailoverRule) error {
+	seen := map[string]bool{}
+	for i, r := range rules {
+		switch {
+		case strings.TrimSpace(r.Primary) == "":
+			return fmt.Errorf("rule %d: missing primary", i)
+		case len(r.Backups) == 0:
+			return fmt.Errorf("rule %d: no backups", i)
+		case slices.Contains(r.Backups, ""):
+			return fmt.Errorf("rule %d: empty backup", i)
+		case r.Failback != "" && r.Failback != failbackAuto && r.Failback != failbackManual:
+			return fmt.Errorf("rule %d: failback %q (want auto or manual)", i, r.Failback)
+		case r.LostAfterSec < 0 || r.StableSec < 0:
+			return fmt.Errorf("rule %d: negative lostAfterSec or stableSec", i)
+		case strings.ContainsAny(r.Mount, "/|"):
+			return fmt.Errorf("rule %d: mount %q may not contain '/' or '|'", i, r.Mount)
+		case seen[r.Mount]:
+			if r.Mount == "" {
+				return fmt.Errorf("rule %d: more than one rule for the shared program", i)
+			}
+			return fmt.Errorf("rule %d: duplicate mount %q", i, r.Mount)
+		}
+		seen[r.Mount] = true
+	}
+	return nil
+}
+
+// failoverState is a rule and where its program stands.
+type failoverState struct {
+	FailoverRule
+	active int    // candidate on air: 0 primary, n backup n
+	source string // name or URL of the source on air
+	// manual is set on the shared rule when an operator selects a source
+	// other than the primary; automation stays off until the primary is
+	// selected again
+	manual bool
+	// apply: the shared program had no selection when the rule was loaded,
+	// so the next check puts the active candidate on air
+	apply       bool
+	lostSince   time.Time // active candidate missing from discovery since
+	stableSince time.Time // primary back in discovery since (on a backup)
+	exhausted   bool      // reported that no candidate is present
+	onSlate     bool      // the slate is on air, as no candidate was present
+	since       time.Time // when active last changed
+}
+
+func (st *failoverState) state() string {
+	switch {
+	case st.manual:
+		return "manual"
+	case st.onSlate:
+		return "on-slate"
+	case st.active == 0:
+		return "on-primary"
+	}
+	return fmt.Sprintf("on-backup-%d", st.active)
+}
+
+// failoverStatus is a rule's entry in /health and GET /ndi/failover.
+type failoverStatus struct {
+	Mount    string    `json:"mount"` // "" for the shared program
+	State    string    `json:"state"` // on-primary, on-backup-N or manual
+	Source   string    `json:"source,omitempty"`
+	Since    time.Time `json:"since"`
+	Failback string    `json:"failback"`
+}
+
+// failoverSet holds the rules loaded from the config file.
+type failoverSet struct {
+	mu    sync.Mutex
+	rules []*failoverState
+	// switching serializes automated switches with operator selections, so
+	// an operator's choice always lands last
+	switching sync.Mutex
+}
+
+// sourceMatches reports whether the rule source q names name/url.
+func sourceMatches(q, name, url string) bool {
+	if q == "" || (name == "" && url == "") {
+		return false
+	}
+	return strings.EqualFold(q, url) || strings.EqualFold(q, name) || q == slugKey(name, url)
+}
+
+// findFailoverSource looks q up among the sources present now.
+func (s *WhepServer) findFailoverSource(q string) (struct{ Name, URL string }, bool) {
+	for _, si := range streamNDISources() {
+		if sourceMatches(q, si.Name, si.URL) {
+			return si, true
+		}
+	}
+	return struct{ Name, URL string }{}, false
+}
+
+// setFailoverRules installs rules. A rule whose mount and candidates are
+// unchanged keeps its state across reloads. A new shared rule adopts the
+// current selection if it is one of its candidates.
+func (s *WhepServer) setFailoverRules(rules []FailoverRule) error {
+	if err := validateFailoverRules(rules); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	name, url := s.ndiName, s.ndiURL
+	s.mu.Unlock()
+	f := &s.failover
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	now := time.Now()
+	next := make([]*failoverState, 0, len(rules))
+	for _, r := range rules {
+		if i := slices.IndexFunc(f.rules, func(st *failoverState) bool {
+			return st.Mount == r.Mount && slices.Equal(st.candidates(), r.candidates())
+		}); i >= 0 {
+			st := f.rules[i]
+			st.FailoverRule = r
+			next = append(next, st)
+			continue
+		}
+		st := &failoverState{FailoverRule: r, source: r.Primary, since: now}
+		if r.Mount == "" {
+			switch i := slices.IndexFunc(r.candidates(), func(q string) bool { return sourceMatches(q, name, url) }); {
+			case i >= 0:
+				st.active, st.source = i, name
+				if st.source == "" {
+					st.source = url
+				}
+			case name == "" && url == "":
+				st.apply = true
+			default:
+				st.manual = true
+			}
+		}
+		next = append(next, st)
+	}
+	f.rules = next
+	return nil
+}
+
+// failoverOperatorSelected records an operator selection of the shared
+// program. Selecting the primary hands control back to automation; any
+// other source suspends it.
+func (s *WhepServer) failoverOperatorSelected(name, url string) {
+	f := &s.failover
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, st := range f.rules {
+		if st.Mount != "" {
+			continue
+		}
+		st.apply, st.lostSince, st.stableSince, st.exhausted, st.onSlate = false, time.Time{}, time.Time{}, false, false
+		st.source = name
+		if st.source == "" {
+			st.source = url
+		}
+		if sourceMatches(st.Primary, name, url) {
+			st.manual, st.active, st.since = false, 0, time.Now()
+		} else {
+			st.manual = true
+		}
+	}
+}
+
+// failoverAlias resolves a failover mount alias to its source on air.
+func (s *WhepServer) failoverAlias(key string) (struct{ Name, URL string }, bool) {
+	s.failover.mu.Lock()
+	var q string
+	slate := false
+	for _, st := range s.failover.rules {
+		if st.Mount != "" && st.Mount == key {
+			q, slate = st.candidates()[st.active], st.onSlate
+		}
+	}
+	s.failover.mu.Unlock()
+	if slate {
+		return slateListing, true
+	}
+	if q == "" {
+		return struct{ Name, URL string }{}, false
+	}
+	if si, ok := s.findFailoverSource(q); ok {
+		return si, true
+	}
+	// Off the air for now; the mount opens it by name and falls back to
+	// synthetic until a check moves it to a backup
+	return struct{ Name, URL string }{Name: q}, true
+}
+
+func (s *WhepServer) failoverStatus() []failoverStatus {
+	f := &s.failover
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	out := make([]failoverStatus, 0, len(f.rules))
+	for _, st := range f.rules {
+		fb := st.Failback
+		if fb == "" {
+			fb = failbackAuto
+		}
+		out = append(out, failoverStatus{Mount: st.Mount, State: st.state(), Source: st.source, Since: st.since, Failback: fb})
+	}
+	return out
+}
+
+func (s *WhepServer) runFailover() {
+	ticker := time.NewTicker(failoverInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case now := <-ticker.C:
+			s.failover.mu.Lock()
+			rules := slices.Clone(s.failover.rules)
+			s.failover.mu.Unlock()
+			for _, st := range rules {
+				s.checkFailover(st, now)
+			}
+			s.checkMountFallbacks(now)
+		}
+	}
+}
+
+// checkFailover moves st's program when its source has been missing for
+// LostAfterSec, or back to the primary when auto failback allows.
+func (s *WhepServer) checkFailover(st *failoverState, now time.Time) {
+	f := &s.failover
+	f.switching.Lock()
+	defer f.switching.Unlock()
+	// Resolve outside f.mu; discovery reads take their own locks
+	cands := st.candidates()
+	present := make([]bool, len(cands))
+	for i, q := range cands {
+		_, present[i] = s.findFailoverSource(q)
+	}
+	f.mu.Lock()
+	if st.manual || !slices.Contains(f.rules, st) {
+		f.mu.Unlock()
+		return
+	}
+	target, reason := -1, ""
+	switch {
+	case st.apply && present[st.active]:
+		target, reason = st.active, "no source selected"
+	case st.onSlate:
+		// Off the slate as soon as any candidate is back
+		if target = slices.IndexFunc(present, func(p bool) bool { return p }); target >= 0 {
+			reason = fmt.Sprintf("%s present again", cands[target])
+		}
+	case present[st.active]:
+		st.lostSince, st.exhausted = time.Time{}, false
+		if st.active == 0 || st.Failback == failbackManual || !present[0] {
+			st.stableSince = time.Time{}
+			break
+		}
+		if st.stableSince.IsZero() {
+			st.stableSince = now
+		}
+		if now.Sub(st.stableSince) >= st.stable() {
+			target, reason = 0, fmt.Sprintf("primary back for %s", st.stable())
+		}
+	default:
+		st.stableSince = time.Time{}
+		if st.lostSince.IsZero() {
+			st.lostSince = now
+		}
+		if now.Sub(st.lostSince) < st.lostAfter() {
+			break
+		}
+		reason = fmt.Sprintf("%s missing for %s", cands[st.active], st.lostAfter())
+		target = slices.IndexFunc(present, func(p bool) bool { return p })
+		if target < 0 && !st.exhausted {
+			st.exhausted = true
+			data := map[string]any{"mount": st.Mount, "state": st.state(), "reason": reason + "; no candidate present"}
+			f.mu.Unlock()
+			s.emitEvent("failover_unavailable", data)
+			if s.cfg.Slate != "" {
+				s.switchFailoverSlate(st, reason+"; no candidate present")
+			}
+			return
+		}
+	}
+	f.mu.Unlock()
+	if target >= 0 {
+		s.switchFailover(st, target, reason)
+	}
+}
+
+// switchFailover puts candidate target of st on air. Callers hold
+// s.failover.switching.
+func (s *WhepServer) switchFailover(st *failoverState, target int, reason string) error {
+	q := st.candidates()[target]
+	si, ok := s.findFailoverSource(q)
+	if !ok {
+		return fmt.Errorf("%w: %s", errSourceNotFound, q)
+	}
+	var mode string
+	var err error
+	if st.Mount == "" {
+		mode, err = s.failoverShared(si.Name, si.URL)
+	} else {
+		mode, err = s.retargetMounts(st.Mount, si.Name, si.URL)
+	}
+	f := &s.failover
+	f.mu.Lock()
+	from := st.source
+	if err == nil {
+		st.active, st.since, st.apply, st.manual, st.onSlate = target, time.Now(), false, false, false
+		st.lostSince, st.stableSince, st.exhausted = time.Time{}, time.Time{}, false
+		st.source = si.Name
+		if st.source == "" {
+			st.source = si.URL
+		}
+	}
+	data := map[string]any{"mount": st.Mount, "from": from, "to": st.source, "state": st.state(), "mode": mode, "reason": reason}
+	f.mu.Unlock()
+	typ := "failover"
+	if target == 0 {
+		typ = "failback"
+	}
+	if err != nil {
+		data["to"], data["error"] = q, err.Error()
+		typ += "_failed"
+	}
+	s.emitEvent(typ, data)
+	return err
+}
+
+// switchFailoverSlate puts the slate on air for st. The active candidate
+// stays as it was, so the rule comes off the slate by the usual order.
+// Callers hold s.failover.switching.
+func (s *WhepServer) switchFailoverSlate(st *failoverState, reason string) {
+	var mode string
+	var err error
+	if st.Mount == "" {
+		mode, err = s.failoverShared(slateListing.Name, slateListing.URL)
+	} else {
+		mode, err = s.retargetMounts(st.Mount, slateListing.Name, slateListing.URL)
+	}
+	f := &s.failover
+	f.mu.Lock()
+	from := st.source
+	if err == nil {
+		st.onSlate, st.since, st.source = true, time.Now(), slateListing.Name
+	}
+	data := map[string]any{"mount": st.Mount, "from": from, "to": slateListing.Name, "state": st.state(), "mode": mode, "reason": reason}
+	f.mu.Unlock()
+	typ := "failover"
+	if err != nil {
+		data["error"] = err.Error()
+		typ += "_failed"
+	}
+	s.emitEvent(typ, data)
+}
+
+// failoverShared switches the shared program to name/url: a hot-swap when
+// a pipeline is running, else it only becomes the selection.
+func (s *WhepServer) failoverShared(name, url string) (string, error) {
+	s.mu.Lock()
+	s.ndiName, s.ndiURL = name, url
+	s.changes.bump()
+	sw, running := s.shareSwitch, s.shareBC != nil
+	s.mu.Unlock()
+	if !running {
+		return "selected", nil
+	}
+	if sw != nil {
+		ow, oh := s.sharedOpenSize()
+		swapped, err := s.hotSwap(sw, name, url, ow, oh, s.fps(), "", func(src stream.Source) {
+			s.mu.Lock()
+			s.hotSwappedLocked(name, url)
+			audio, pipe := s.shareAudio, s.sharePipe
+			s.mu.Unlock()
+			audio.setSource(src)
+			if pipe != nil {
+				pipe.ForceKeyframe()
+			}
+		}, func() { _ = s.restartSharedPipeline() })
+		if err != nil {
+			return "", err
+		}
+		if swapped {
+			return "hot-swap", nil
+		}
+	}
+	return "restart", s.restartSharedPipeline()
+}
+
+// retargetMounts moves every running mount of alias (a failover mount or a
+// channel) to name/url.
+func (s *WhepServer) retargetMounts(alias, name, url string) (string, error) {
+	// Readers of a mount's name/url hold s.mu; the pipeline takes m.mu
+	s.mu.Lock()
+	var mounts []*ndiMount
+	for _, m := range s.mounts {
+		if m.alias == alias {
+			m.mu.Lock()
+			m.name, m.url = name, url
+			m.mu.Unlock()
+			mounts = append(mounts, m)
+		}
+	}
+	s.mu.Unlock()
+	if len(mounts) == 0 {
+		return "selected", nil
+	}
+	var errs []error
+	mode := "hot-swap"
+	for _, m := range mounts {
+		select {
+		case <-m.ready:
+		default:
+			continue // still starting; it opens the new source itself
+		}
+		m.mu.Lock()
+		sw, fps, bandwidth := m.sw, m.fps.Or(s.fps()), m.bandwidth
+		openW, openH := m.openSize()
+		m.mu.Unlock()
+		if sw != nil {
+			swapped, err := s.hotSwap(sw, name, url, openW, openH, fps, bandwidth, func(src stream.Source) {
+				m.mu.Lock()
+				audio, pipe, detailPipe := m.audio, m.pipe, m.detailPipe
+				m.mu.Unlock()
+				audio.setSource(src)
+				if pipe != nil {
+					pipe.ForceKeyframe()
+				}
+				if detailPipe != nil {
+					detailPipe.ForceKeyframe()
+				}
+			}, func() { _ = s.restartMount(m) })
+			if err != nil {
+				errs = append(errs, fmt.Errorf("mount %s: %w", m.key, err))
+				continue
+			}
+			if swapped {
+				continue
+			}
+		}
+		mode = "restart"
+		if err := s.restartMount(m); err != nil {
+			errs = append(errs, fmt.Errorf("mount %s: %w", m.key, err))
+		}
+	}
+	return mode, errors.Join(errs...)
+}
+
+// hotSwap opens name/url (an NDI source at bandwidth, "" for the default)
+// and cuts sw over to it on its first frame, inside the running encoder. It reports false, having opened nothing for long,
+// when the pixel formats or frame delivery (stream.HasFrames) differ and
+// the caller should restart instead. If the cut hasn't happened within
+// scheduleGrace, restart runs.
+func (s *WhepServer) hotSwap(sw *stream.SwitchSource, name, url string, w, h int, fps stream.Rate, bandwidth string, done func(stream.Source), restart func()) (bool, error) {
+	src, err := s.openSourceBandwidth(name, url, w, h, fps, bandwidth)
+	if err != nil {
+		return false, err
+	}
+	if stream.PixFmtOf(src) != stream.PixFmtOf(sw.Current()) || stream.HasFrames(src) != stream.HasFrames(sw.Current()) {
+		src.Stop()
+		return false, nil
+	}
+	var cut atomic.Bool
+	sw.Schedule(src, time.Now(), func(time.Time) {
+		cut.Store(true)
+		done(src)
+	})
+	time.AfterFunc(scheduleGrace, func() {
+		if !cut.Load() && sw.Cancel() {
+			log.Printf("Failover to %s: no frame within %s, restarting pipeline", name, scheduleGrace)
+			restart()
+		}
+	})
+	return true, nil
+}
+
+// GET /ndi/failover lists the rules' states; POST /ndi/failover
+// {"mount": ""} fails a rule back to its primary now (for failback
+// "manual", or to end a manual override of the shared program).
+func (s *WhepServer) handleFailover(w http.ResponseWriter, r *http.Request) {
+	allowCORS(w, r)
+	switch r.Method {
+	case http.MethodOptions:
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{"rules": s.failoverStatus()})
+	case http.MethodPost:
+		var body struct {
+			Mount string `json:"mount"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeError(w, r, http.StatusBadRequest, errCodeBadRequest, "invalid JSON", nil)
+			return
+		}
+		f := &s.failover
+		f.switching.Lock()
+		defer f.switching.Unlock()
+		f.mu.Lock()
+		i := slices.IndexFunc(f.rules, func(st *failoverState) bool { return st.Mount == body.Mount })
+		var st *failoverState
+		if i >= 0 {
+			st = f.rules[i]
+		}
+		f.mu.Unlock()
+		if st == nil {
+			writeError(w, r, http.StatusNotFound, errCodeNotFound, "no failover rule for mount "+strconv.Quote(body.Mount), nil)
+			return
+		}
+		if err := s.switchFailover(st, 0, "operator failback"); err != nil {
+			if errors.Is(err, errSourceNotFound) {
+				writeError(w, r, http.StatusNotFound, errCodeSourceNotFound, err.Error(), map[string]string{"source": st.Primary})
+				return
+			}
+			writeError(w, r, http.StatusServiceUnavailable, errCodeSourceUnavailable, err.Error(), nil)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{"ok": true, "rules": s.failoverStatus()})
+	default:
+		writeError(w, r, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "method not allowed", nil)
+	}
+}
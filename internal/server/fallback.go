@@ -0,0 +1,269 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"whep/internal/stream"
+)
+
+// Mount fallback lists. POST /whep/ndi/{key}?fallback=key2,key3 gives a
+// mount an ordered list of sources to move to when its own stays dead: no
+// frame for fallbackLostAfter. The mount moves to the next listed source
+// after the one on air, and tries its own again every fallbackRetry while
+// that is listed. Each move is a hot swap that cuts on the new source's
+// first frame (with a keyframe), so a candidate that sends nothing within
+// scheduleGrace is skipped and the mount stays where it was. Unlike failover rules, which
+// follow discovery, a fallback mount follows its frames.
+const (
+	fallbackLostAfter = defaultLostAfterSec * time.Second
+	// fallbackRetry spaces attempts to return to the mount's own source
+	fallbackRetry = defaultStableSec * time.Second
+	maxFallbacks  = 8
+)
+
+var errFallbackAlias = errors.New("fallback= can't be combined with a channel or failover mount")
+
+// mountFallback is a fallback mount's state, guarded by its mount's mu.
+type mountFallback struct {
+	sources   []string  // the mount's own source key, then fallback='s in order
+	active    int       // index of the source on air
+	since     time.Time // when active last changed
+	deadSince time.Time // active without frames since
+	pending   bool      // a switch is waiting for its first frame
+	cursor    int       // where the search for the next candidate starts
+	retryAt   time.Time // earliest next return to sources[0]
+}
+
+// fallbackStats counts fallback switches by mount key, for /metrics.
+type fallbackStats struct {
+	mu       sync.Mutex
+	switches map[string]uint64
+	failures map[string]uint64
+}
+
+func (f *fallbackStats) note(key string, ok bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.switches == nil {
+		f.switches, f.failures = map[string]uint64{}, map[string]uint64{}
+	}
+	if ok {
+		f.switches[key]++
+	} else {
+		f.failures[key]++
+	}
+}
+
+func (f *fallbackStats) snapshot() (switches, failures map[string]uint64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return maps.Clone(f.switches), maps.Clone(f.failures)
+}
+
+// parseFallback reads fallback='s comma-separated source keys.
+func parseFallback(v string) ([]string, error) {
+	var out []string
+	for _, k := range strings.Split(v, ",") {
+		k = strings.TrimSpace(k)
+		if k == "" || slices.Contains(out, k) {
+			continue
+		}
+		if strings.ContainsAny(k, "/|") {
+			return nil, fmt.Errorf("fallback %q may not contain '/' or '|'", k)
+		}
+		out = append(out, k)
+	}
+	if len(out) > maxFallbacks {
+		return nil, fmt.Errorf("fallback lists at most %d sources", maxFallbacks)
+	}
+	return out, nil
+}
+
+// mountAlive reports whether src has delivered a frame within
+// fallbackLostAfter, and when its last frame was. A mount without a source
+// (on the synthetic pattern after its source failed to open) is dead;
+// sources that don't track frames are taken as alive.
+func mountAlive(src stream.Source, now time.Time) (bool, time.Time) {
+	if src == nil {
+		return false, time.Time{}
+	}
+	at, ok := stream.LastFrameAt(src)
+	if !ok {
+		return true, now
+	}
+	return !at.IsZero() && now.Sub(at) < fallbackLostAfter, at
+}
+
+// checkMountFallbacks moves fallback mounts off a dead source, or tries
+// their own again while it is listed.
+func (s *WhepServer) checkMountFallbacks(now time.Time) {
+	s.mu.Lock()
+	var mounts []*ndiMount
+	for _, m := range s.mounts {
+		if m.fb != nil {
+			mounts = append(mounts, m)
+		}
+	}
+	s.mu.Unlock()
+	if len(mounts) == 0 {
+		return
+	}
+	idx := s.sourceIndex()
+	for _, m := range mounts {
+		select {
+		case <-m.ready:
+		default:
+			continue
+		}
+		m.mu.Lock()
+		fb := m.fb
+		if m.bc == nil || fb.pending {
+			m.mu.Unlock()
+			continue
+		}
+		listed := func(i int) bool { _, ok := idx[fb.sources[i]]; return ok }
+		alive, last := mountAlive(m.src, now)
+		target, reason := -1, ""
+		switch {
+		case !alive:
+			if fb.deadSince.IsZero() {
+				fb.deadSince = last
+				if last.IsZero() || last.Before(fb.since) {
+					fb.deadSince = fb.since
+				}
+			}
+			if now.Sub(fb.deadSince) < fallbackLostAfter {
+				break
+			}
+			// The next listed candidate after the last one tried, wrapping
+			for i := 1; i <= len(fb.sources); i++ {
+				if j := (fb.cursor + i) % len(fb.sources); j != fb.active && listed(j) {
+					target = j
+					break
+				}
+			}
+			reason = fmt.Sprintf("%s without frames for %s", fb.sources[fb.active], now.Sub(fb.deadSince).Round(time.Second))
+		case fb.active > 0 && listed(0) && !now.Before(fb.retryAt):
+			fb.deadSince = time.Time{}
+			target, reason = 0, "retrying "+fb.sources[0]
+		default:
+			fb.deadSince = time.Time{}
+		}
+		if target >= 0 {
+			fb.pending = true
+			if target == 0 {
+				fb.retryAt = now.Add(fallbackRetry)
+			}
+		}
+		m.mu.Unlock()
+		if target >= 0 {
+			si := idx[fb.sources[target]]
+			s.switchMountFallback(m, target, si.Name, si.URL, reason)
+		}
+	}
+}
+
+// switchMountFallback puts candidate target of m's fallback list on air:
+// a hot swap on its first frame when the encoder can take it, else a
+// restart. A candidate without a frame within scheduleGrace leaves m where
+// it was.
+func (s *WhepServer) switchMountFallback(m *ndiMount, target int, name, url, reason string) {
+	m.mu.Lock()
+	fb, sw, fps, bandwidth := m.fb, m.sw, m.fps.Or(s.fps()), m.bandwidth
+	from, to := fb.sources[fb.active], fb.sources[target]
+	openW, openH := m.openSize()
+	m.mu.Unlock()
+	data := map[string]any{"mount": m.key, "from": from, "to": to, "reason": reason}
+	typ := "mount_fallback"
+	if target == 0 {
+		typ = "mount_failback"
+	}
+	// Runs once the switch is on air
+	done := func(mode string) {
+		s.mu.Lock()
+		m.mu.Lock()
+		m.name, m.url = name, url
+		fb.active, fb.cursor, fb.since, fb.deadSince, fb.pending = target, target, time.Now(), time.Time{}, false
+		if target > 0 {
+			fb.retryAt = fb.since.Add(fallbackRetry)
+		}
+		m.mu.Unlock()
+		s.changes.bump()
+		s.mu.Unlock()
+		s.fallbacks.note(m.key, true)
+		data["mode"] = mode
+		s.emitEvent(typ, data)
+	}
+	failed := func(err error) {
+		m.mu.Lock()
+		fb.cursor, fb.pending = target, false
+		m.mu.Unlock()
+		s.fallbacks.note(m.key, false)
+		data["error"] = err.Error()
+		s.emitEvent(typ+"_failed", data)
+	}
+	if sw != nil {
+		swapped, err := s.hotSwap(sw, name, url, openW, openH, fps, bandwidth, func(src stream.Source) {
+			m.mu.Lock()
+			audio, pipe, detailPipe := m.audio, m.pipe, m.detailPipe
+			m.mu.Unlock()
+			audio.setSource(src)
+			if pipe != nil {
+				pipe.ForceKeyframe()
+			}
+			if detailPipe != nil {
+				detailPipe.ForceKeyframe()
+			}
+			// Called from the encode loop; the rest takes the server lock
+			go done("hot-swap")
+		}, func() { failed(fmt.Errorf("no frame from %s within %s", to, scheduleGrace)) })
+		if err != nil {
+			failed(err)
+			return
+		}
+		if swapped {
+			return
+		}
+	}
+	// No hot swap: the restarted encoder opens the new source itself
+	s.mu.Lock()
+	m.mu.Lock()
+	prevName, prevURL := m.name, m.url
+	m.name, m.url = name, url
+	m.mu.Unlock()
+	s.mu.Unlock()
+	if err := s.restartMount(m); err != nil {
+		log.Printf("Mount %s: fallback to %s: %v", m.key, to, err)
+		s.mu.Lock()
+		m.mu.Lock()
+		m.name, m.url = prevName, prevURL
+		m.mu.Unlock()
+		s.mu.Unlock()
+		failed(err)
+		return
+	}
+	done("restart")
+}
+
+// fallbackHealth is each fallback mount's list and the source on air, for
+// /health.
+func (s *WhepServer) fallbackHealth() map[string]any {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := map[string]any{}
+	for key, m := range s.mounts {
+		m.mu.Lock()
+		if fb := m.fb; fb != nil {
+			out[key] = map[string]any{"sources": fb.sources, "active": fb.sources[fb.active], "since": fb.since.UTC(), "switching": fb.pending}
+		}
+		m.mu.Unlock()
+	}
+	return out
+}
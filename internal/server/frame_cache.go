@@ -0,0 +1,158 @@
+package server
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"whep/internal/stream"
+)
+
+// defaultFrameReceiverTTL is how long an idle /frame receiver is kept when
+// Config.FrameReceiverTTL is unset.
+const defaultFrameReceiverTTL = 10 * time.Second
+
+// frameOpenTimeout bounds how long /frame waits for its turn in the ndi
+// scheduler before giving up.
+const frameOpenTimeout = 5 * time.Second
+
+// frameReceivers caches the receivers /frame opens for sources no mount is
+// running, so a poller reuses one connection instead of reconnecting to the
+// sender on every request; some senders alert on each new client.
+type frameReceivers struct {
+	mu sync.Mutex
+	m  map[string]*frameReceiver
+}
+
+type frameReceiver struct {
+	ready chan struct{} // closed once src/err are set
+	src   stream.Source
+	stop  func() // closes src and returns its scheduler turn
+	err   error
+	// The rest are guarded by frameReceivers.mu
+	users int         // requests holding the receiver
+	timer *time.Timer // idle countdown, running while users is 0
+	gen   int         // bumped on checkout so an expiry already due is void
+	done  bool        // stopped, or being stopped
+}
+
+func (s *WhepServer) frameReceiverTTL() time.Duration {
+	if s.cfg.FrameReceiverTTL != 0 {
+		return s.cfg.FrameReceiverTTL
+	}
+	return defaultFrameReceiverTTL
+}
+
+// frameSource returns a receiver for name/url, opening one if none is
+// cached. The returned release func must be called when done; with caching
+// disabled (negative TTL) it stops the receiver. Otherwise the receiver's
+// idle countdown starts once its last user releases it, so a request slower
+// than the TTL keeps it. Opening waits, bounded by ctx, for a turn in the
+// ndi scheduler.
+func (s *WhepServer) frameSource(ctx context.Context, name, url string) (stream.Source, func(), error) {
+	ttl := s.frameReceiverTTL()
+	if ttl < 0 {
+		return s.openTempSource(ctx, name, url)
+	}
+	key := name + "\x00" + url
+	c := &s.frameRx
+	c.mu.Lock()
+	if c.m == nil {
+		c.m = map[string]*frameReceiver{}
+	}
+	fr, ok := c.m[key]
+	if !ok {
+		fr = &frameReceiver{ready: make(chan struct{})}
+		c.m[key] = fr
+	}
+	c.mu.Unlock()
+
+	if !ok {
+		// Concurrent requests for the same source wait on this open
+		fr.src, fr.stop, fr.err = s.openTempSource(ctx, name, url)
+		if fr.err != nil {
+			c.mu.Lock()
+			delete(c.m, key)
+			c.mu.Unlock()
+		}
+		close(fr.ready)
+	}
+	<-fr.ready
+	if fr.err != nil {
+		return nil, nil, fr.err
+	}
+	c.mu.Lock()
+	if fr.done {
+		// Expired or closed between the lookup and now
+		c.mu.Unlock()
+		return s.frameSource(ctx, name, url)
+	}
+	fr.users++
+	fr.gen++
+	if fr.timer != nil {
+		fr.timer.Stop()
+		fr.timer = nil
+	}
+	c.mu.Unlock()
+	var once sync.Once
+	return fr.src, func() { once.Do(func() { c.release(key, fr, ttl) }) }, nil
+}
+
+// release drops a user of fr. The last one starts the idle countdown, or
+// stops fr if closeAll dropped it meanwhile.
+func (c *frameReceivers) release(key string, fr *frameReceiver, ttl time.Duration) {
+	c.mu.Lock()
+	fr.users--
+	if fr.users > 0 {
+		c.mu.Unlock()
+		return
+	}
+	if c.m[key] != fr {
+		fr.done = true
+		c.mu.Unlock()
+		fr.stop()
+		return
+	}
+	gen := fr.gen
+	fr.timer = time.AfterFunc(ttl, func() { c.expire(key, fr, gen) })
+	c.mu.Unlock()
+}
+
+func (c *frameReceivers) expire(key string, fr *frameReceiver, gen int) {
+	c.mu.Lock()
+	if c.m[key] != fr || fr.users > 0 || fr.gen != gen {
+		c.mu.Unlock()
+		return
+	}
+	delete(c.m, key)
+	fr.done = true
+	c.mu.Unlock()
+	fr.stop()
+}
+
+// closeAll stops every idle cached receiver; used on shutdown and after a
+// resume. One in use is stopped by its last release, and one still opening
+// by its opener's release, once they see the entry gone.
+func (c *frameReceivers) closeAll() {
+	c.mu.Lock()
+	var stops []func()
+	for key, fr := range c.m {
+		delete(c.m, key)
+		select {
+		case <-fr.ready:
+		default:
+			continue
+		}
+		if fr.users == 0 {
+			if fr.timer != nil {
+				fr.timer.Stop()
+			}
+			fr.done = true
+			stops = append(stops, fr.stop)
+		}
+	}
+	c.mu.Unlock()
+	for _, stop := range stops {
+		stop()
+	}
+}
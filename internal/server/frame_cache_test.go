@@ -0,0 +1,57 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// waitLive waits for the open fake receivers to come to live+want.
+func waitLive(t *testing.T, live, want int32) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for fakeLive.Load()-live != want {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d receivers open, want %d", fakeLive.Load()-live, want)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestFrameReceiverKeptWhileInUse(t *testing.T) {
+	const ttl = 20 * time.Millisecond
+	s, _ := newTestServer(t, func(c *Config) { c.FrameReceiverTTL = ttl })
+	live, opens := fakeLive.Load(), fakeOpens.Load()
+	checkout := func() func() {
+		t.Helper()
+		_, release, err := s.frameSource(context.Background(), "Fake", fakeURL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return release
+	}
+
+	// A request slower than the TTL keeps its receiver, and others share it
+	release := checkout()
+	time.Sleep(5 * ttl)
+	waitLive(t, live, 1)
+	release2 := checkout()
+	release()
+	time.Sleep(5 * ttl)
+	waitLive(t, live, 1)
+	if n := fakeOpens.Load() - opens; n != 1 {
+		t.Fatalf("opened %d receivers, want 1", n)
+	}
+	// Once idle it expires after the TTL
+	release2()
+	release2() // a second call is harmless
+	waitLive(t, live, 0)
+
+	// closeAll leaves one in use to its last release
+	release = checkout()
+	s.frameRx.closeAll()
+	time.Sleep(5 * ttl)
+	waitLive(t, live, 1)
+	release()
+	waitLive(t, live, 0)
+}
@@ -0,0 +1,171 @@
+package server
+
+import (
+	"errors"
+	"image"
+	"image/jpeg"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"whep/internal/stream"
+)
+
+const defaultJPEGQuality = 80
+
+// frameOptions are the /frame query parameters.
+type frameOptions struct {
+	jpeg    bool
+	quality int // JPEG only
+	w, h    int // requested size; 0 = source size or keep aspect
+}
+
+func parseFrameOptions(r *http.Request) (frameOptions, string) {
+	q := r.URL.Query()
+	o := frameOptions{quality: defaultJPEGQuality}
+	switch strings.ToLower(q.Get("format")) {
+	case "", "png":
+	case "jpeg", "jpg":
+		o.jpeg = true
+	default:
+		return o, "format must be png or jpeg"
+	}
+	if v := q.Get("quality"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 100 {
+			return o, "quality must be 1..100"
+		}
+		o.quality = n
+	}
+	for _, p := range []struct {
+		name string
+		dst  *int
+	}{{"w", &o.w}, {"h", &o.h}} {
+		if v := q.Get(p.name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 2 {
+				return o, p.name + " must be an integer >= 2"
+			}
+			*p.dst = n
+		}
+	}
+	return o, ""
+}
+
+// outputSize resolves the requested size against the source: a missing side
+// keeps the aspect ratio, frames are never upscaled, and both sides are even
+// as I420 requires.
+func (o frameOptions) outputSize(sw, sh int) (int, int) {
+	dw, dh := o.w, o.h
+	switch {
+	case dw == 0 && dh == 0:
+		return sw, sh
+	case dh == 0:
+		dh = sh * dw / sw
+	case dw == 0:
+		dw = sw * dh / sh
+	}
+	if dw > sw || dh > sh {
+		dw, dh = sw, sh
+	}
+	return max(dw&^1, 2), max(dh&^1, 2)
+}
+
+// writeFrame encodes a w x h frame in pixfmt as PNG or JPEG per o. The
+// native-size PNG is streamed straight from buf; everything else goes
+// through I420 with the stream package's bulk converters and I420Scale.
+func writeFrame(out http.ResponseWriter, buf []byte, w, h int, pixfmt string, o frameOptions) error {
+	dw, dh := o.outputSize(w, h)
+	if !o.jpeg && dw == w && dh == h {
+		out.Header().Set("Content-Type", "image/png")
+		return writeFramePNG(out, buf, w, h, pixfmt)
+	}
+	dw, dh = max(dw&^1, 2), max(dh&^1, 2)
+	// JFIF decoders assume BT.601, and writeFrameJPEG expands limited
+	// range; a PNG round trips through the pipeline's color space for the
+	// frame, limited for UYVY as the sender sent it
+	cs := stream.ColorSpaceFor(w, h)
+	if o.jpeg {
+		cs = stream.ColorSpace{Matrix: stream.BT601, Range: stream.RangeLimited}
+	} else if pixfmt == "uyvy422" {
+		cs.Range = stream.RangeLimited
+	}
+	img, err := frameToI420(buf, w, h, pixfmt, dw, dh, cs)
+	if err != nil {
+		return err
+	}
+	if o.jpeg {
+		out.Header().Set("Content-Type", "image/jpeg")
+		return writeFrameJPEG(out, img, o.quality)
+	}
+	bgra := make([]byte, dw*dh*4)
+	stream.I420ToBGRASpace(img.Y, img.Cb, img.Cr, dw, dh, bgra, cs)
+	out.Header().Set("Content-Type", "image/png")
+	return writeFramePNG(out, bgra, dw, dh, "bgra")
+}
+
+// frameToI420 converts buf to I420, scaled to dw x dh. A BGRA frame is
+// converted into color space cs; UYVY is already YUV and kept as is.
+func frameToI420(buf []byte, w, h int, pixfmt string, dw, dh int, cs stream.ColorSpace) (*image.YCbCr, error) {
+	bpp := 4
+	if pixfmt == "uyvy422" {
+		bpp = 2
+	}
+	if w < 2 || h < 2 || len(buf) < w*h*bpp {
+		return nil, errors.New("frame buffer too small")
+	}
+	buf, w, h = evenFrame(buf, w, h, bpp)
+	src := image.NewYCbCr(image.Rect(0, 0, w, h), image.YCbCrSubsampleRatio420)
+	if bpp == 2 {
+		stream.UYVYtoI420(buf, w, h, src.Y, src.Cb, src.Cr)
+	} else {
+		stream.BGRAtoI420Space(buf, w, h, src.Y, src.Cb, src.Cr, cs)
+	}
+	if dw == w && dh == h {
+		return src, nil
+	}
+	dst := image.NewYCbCr(image.Rect(0, 0, dw, dh), image.YCbCrSubsampleRatio420)
+	stream.I420Scale(src.Y, src.Cb, src.Cr, w, h, dst.Y, dst.Cb, dst.Cr, dw, dh)
+	return dst, nil
+}
+
+// evenFrame crops a frame to even dimensions. An odd height only drops the
+// last row; an odd width needs a repacked copy since the converters assume
+// a stride of w*bpp.
+func evenFrame(buf []byte, w, h, bpp int) ([]byte, int, int) {
+	h &^= 1
+	if w%2 == 0 {
+		return buf, w, h
+	}
+	ew := w &^ 1
+	out := make([]byte, ew*h*bpp)
+	for y := 0; y < h; y++ {
+		copy(out[y*ew*bpp:(y+1)*ew*bpp], buf[y*w*bpp:])
+	}
+	return out, ew, h
+}
+
+// jpegRange maps limited-range (BT.601 studio swing) samples to the full
+// range JFIF expects; without it JPEGs come out washed out.
+var jpegRange = func() (t struct{ y, c [256]byte }) {
+	for i := range t.y {
+		t.y[i] = clamp8(int(math.Round(float64(i-16) * 255 / 219)))
+		t.c[i] = clamp8(int(math.Round(float64(i-128)*255/224)) + 128)
+	}
+	return t
+}()
+
+// writeFrameJPEG expands img to full range in place and encodes it. JPEG
+// stores YCbCr 4:2:0 natively, so there is no RGB pass.
+func writeFrameJPEG(out io.Writer, img *image.YCbCr, quality int) error {
+	for i, v := range img.Y {
+		img.Y[i] = jpegRange.y[v]
+	}
+	for i := range img.Cb {
+		img.Cb[i] = jpegRange.c[img.Cb[i]]
+		img.Cr[i] = jpegRange.c[img.Cr[i]]
+	}
+	return jpeg.Encode(out, img, &jpeg.Options{Quality: quality})
+}
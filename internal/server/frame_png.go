@@ -0,0 +1,177 @@
+package server
+
+import (
+	"bufio"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"sync"
+
+	"whep/internal/stream"
+)
+
+// /frame encodes PNGs straight from the source's BGRA or UYVY buffer one
+// scanline at a time, instead of building an image.RGBA (33 MB at 4K) and
+// letting image/png buffer the whole filtered image. Row buffers, the zlib
+// compressor and the IDAT buffer are pooled, so a request allocates a few
+// hundred KB whatever the resolution.
+
+const pngIDATSize = 64 << 10 // bytes per IDAT chunk
+
+var pngSignature = []byte{0x89, 'P', 'N', 'G', '\r', '\n', 0x1a, '\n'}
+
+var (
+	pngZlibPool = sync.Pool{New: func() any {
+		zw, _ := zlib.NewWriterLevel(io.Discard, zlib.BestSpeed)
+		return zw
+	}}
+	pngBufPool = sync.Pool{New: func() any { return bufio.NewWriterSize(io.Discard, pngIDATSize) }}
+	pngRowPool sync.Pool // *[]byte holding the raw and the filtered row
+)
+
+// pngChunkWriter writes each Write as one PNG chunk of type typ.
+type pngChunkWriter struct {
+	w   io.Writer
+	typ [4]byte
+}
+
+func (c *pngChunkWriter) Write(p []byte) (int, error) {
+	if err := writePNGChunk(c.w, c.typ, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func writePNGChunk(w io.Writer, typ [4]byte, data []byte) error {
+	var hdr [8]byte
+	binary.BigEndian.PutUint32(hdr[:4], uint32(len(data)))
+	copy(hdr[4:], typ[:])
+	crc := crc32.NewIEEE()
+	crc.Write(typ[:])
+	crc.Write(data)
+	var tail [4]byte
+	binary.BigEndian.PutUint32(tail[:], crc.Sum32())
+	for _, b := range [][]byte{hdr[:], data, tail[:]} {
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeFramePNG encodes a w x h frame as an 8-bit RGB PNG. pixfmt is "bgra"
+// (stride w*4) or "uyvy422" (stride w*2, limited range in the matrix the
+// pipeline picks for the frame size).
+func writeFramePNG(out io.Writer, buf []byte, w, h int, pixfmt string) error {
+	bpp := 4
+	if pixfmt == "uyvy422" {
+		bpp = 2
+	}
+	if w <= 0 || h <= 0 || len(buf) < w*h*bpp {
+		return errors.New("frame buffer too small")
+	}
+	if _, err := out.Write(pngSignature); err != nil {
+		return err
+	}
+	var ihdr [13]byte
+	binary.BigEndian.PutUint32(ihdr[0:], uint32(w))
+	binary.BigEndian.PutUint32(ihdr[4:], uint32(h))
+	ihdr[8], ihdr[9] = 8, 2 // 8-bit truecolor
+	if err := writePNGChunk(out, [4]byte{'I', 'H', 'D', 'R'}, ihdr[:]); err != nil {
+		return err
+	}
+
+	bw := pngBufPool.Get().(*bufio.Writer)
+	bw.Reset(&pngChunkWriter{w: out, typ: [4]byte{'I', 'D', 'A', 'T'}})
+	zw := pngZlibPool.Get().(*zlib.Writer)
+	zw.Reset(bw)
+	defer func() {
+		bw.Reset(io.Discard)
+		pngBufPool.Put(bw)
+		zw.Reset(io.Discard)
+		pngZlibPool.Put(zw)
+	}()
+
+	rowLen := 1 + w*3
+	rows, _ := pngRowPool.Get().(*[]byte)
+	if rows == nil || cap(*rows) < 2*rowLen {
+		b := make([]byte, 2*rowLen)
+		rows = &b
+	}
+	defer pngRowPool.Put(rows)
+	cur, filtered := (*rows)[:rowLen], (*rows)[rowLen:2*rowLen]
+	k := &bt601RGB
+	if stream.ColorSpaceFor(w, h).Matrix == stream.BT709 {
+		k = &bt709RGB
+	}
+	for y := 0; y < h; y++ {
+		if bpp == 2 {
+			uyvyRowToRGB(cur[1:], buf[y*w*2:(y+1)*w*2], w, k)
+		} else {
+			bgraRowToRGB(cur[1:], buf[y*w*4:(y+1)*w*4], w)
+		}
+		// Sub filter: cheap and compresses camera content much better than None
+		filtered[0] = 1
+		copy(filtered[1:4], cur[1:4])
+		for i := 4; i < rowLen; i++ {
+			filtered[i] = cur[i] - cur[i-3]
+		}
+		if _, err := zw.Write(filtered); err != nil {
+			return err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	return writePNGChunk(out, [4]byte{'I', 'E', 'N', 'D'}, nil)
+}
+
+func bgraRowToRGB(dst, src []byte, w int) {
+	for x := 0; x < w; x++ {
+		dst[x*3], dst[x*3+1], dst[x*3+2] = src[x*4+2], src[x*4+1], src[x*4]
+	}
+}
+
+// uyvyRowToRGB converts one packed 4:2:2 row; each U Y0 V Y1 group is two pixels.
+func uyvyRowToRGB(dst, src []byte, w int, k *yuvRGB) {
+	for x := 0; x+1 < w; x += 2 {
+		u, y0, v, y1 := int(src[x*2])-128, int(src[x*2+1]), int(src[x*2+2])-128, int(src[x*2+3])
+		yuvToRGB(dst[x*3:], y0, u, v, k)
+		yuvToRGB(dst[x*3+3:], y1, u, v, k)
+	}
+	if w%2 == 1 { // odd width: last pixel shares the final group's chroma
+		x := w - 1
+		yuvToRGB(dst[x*3:], int(src[x*2+1]), int(src[x*2])-128, 0, k)
+	}
+}
+
+// yuvRGB holds a limited-range matrix's chroma terms in 16.16 fixed point.
+type yuvRGB struct{ rv, gu, gv, bu int }
+
+var (
+	bt601RGB = yuvRGB{104597, -25675, -53279, 132201}
+	bt709RGB = yuvRGB{117489, -13975, -34925, 138438}
+)
+
+// yuvToRGB converts one limited-range sample with k.
+func yuvToRGB(dst []byte, y, u, v int, k *yuvRGB) {
+	c := (y - 16) * 76309
+	dst[0] = clamp8((c + k.rv*v + 32768) >> 16)
+	dst[1] = clamp8((c + k.gu*u + k.gv*v + 32768) >> 16)
+	dst[2] = clamp8((c + k.bu*u + 32768) >> 16)
+}
+
+func clamp8(v int) byte {
+	if v < 0 {
+		return 0
+	}
+	if v > 255 {
+		return 255
+	}
+	return byte(v)
+}
@@ -0,0 +1,77 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"image/png"
+	"io"
+	"testing"
+)
+
+// testFrame is a w x h gradient in pixfmt's layout.
+func testFrame(w, h int, pixfmt string) []byte {
+	bpp := 4
+	if pixfmt == "uyvy422" {
+		bpp = 2
+	}
+	buf := make([]byte, w*h*bpp)
+	for i := range buf {
+		buf[i] = byte(i*7 + i/(w*bpp))
+	}
+	return buf
+}
+
+func TestFramePNGDecodes(t *testing.T) {
+	const w, h = 64, 36
+	buf := testFrame(w, h, "bgra")
+	var out bytes.Buffer
+	if err := writeFramePNG(&out, buf, w, h, "bgra"); err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := img.Bounds(); b.Dx() != w || b.Dy() != h {
+		t.Fatalf("decoded %v, want %dx%d", b, w, h)
+	}
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			r, g, b, _ := img.At(x, y).RGBA()
+			p := buf[(y*w+x)*4:]
+			if byte(r>>8) != p[2] || byte(g>>8) != p[1] || byte(b>>8) != p[0] {
+				t.Fatalf("pixel %d,%d = %d,%d,%d, want %d,%d,%d", x, y, r>>8, g>>8, b>>8, p[2], p[1], p[0])
+			}
+		}
+	}
+
+	out.Reset()
+	if err := writeFramePNG(&out, testFrame(w, h, "uyvy422"), w, h, "uyvy422"); err != nil {
+		t.Fatal(err)
+	}
+	if cfg, err := png.DecodeConfig(&out); err != nil || cfg.Width != w || cfg.Height != h {
+		t.Fatalf("uyvy PNG: %+v %v", cfg, err)
+	}
+}
+
+// BenchmarkFramePNG encodes whole frames. Once the pools are warm a frame
+// allocates a few hundred bytes, at 1080p as at 4K.
+func BenchmarkFramePNG(b *testing.B) {
+	for _, size := range []struct {
+		name string
+		w, h int
+	}{{"1080p", 1920, 1080}, {"4K", 3840, 2160}} {
+		for _, pixfmt := range []string{"bgra", "uyvy422"} {
+			buf := testFrame(size.w, size.h, pixfmt)
+			b.Run(fmt.Sprintf("%s/%s", size.name, pixfmt), func(b *testing.B) {
+				b.ReportAllocs()
+				b.SetBytes(int64(len(buf)))
+				for i := 0; i < b.N; i++ {
+					if err := writeFramePNG(io.Discard, buf, size.w, size.h, pixfmt); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
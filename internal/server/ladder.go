@@ -0,0 +1,270 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"whep/internal/stream"
+)
+
+// LadderRung is one step of the bitrate/resolution ladder. A nil FPS or zero
+// BitrateKbps leave those values to the request or server defaults; an empty
+// Codec keeps the server codec.
+type LadderRung struct {
+	Name        string       `json:"name,omitempty"`
+	Width       int          `json:"w"`
+	Height      int          `json:"h"`
+	FPS         *stream.Rate `json:"fps,omitempty"` // number (29.97) or "30000/1001"
+	BitrateKbps int          `json:"bitrateKbps,omitempty"`
+	Codec       string       `json:"codec,omitempty"`
+}
+
+// rate is the rung's frame rate, the zero Rate when it has none.
+func (r LadderRung) rate() stream.Rate {
+	if r.FPS == nil {
+		return stream.Rate{}
+	}
+	return *r.FPS
+}
+
+// optRate is r as a rung's FPS: nil when unset.
+func optRate(r stream.Rate) *stream.Rate {
+	if !r.Valid() {
+		return nil
+	}
+	return &r
+}
+
+// Ladder is the shared set of variants that mounts snap to. It is safe for
+// concurrent use and may be replaced at runtime; new mounts see changes
+// immediately, running mounts when they are next (re)created.
+type Ladder struct {
+	mu    sync.RWMutex
+	rungs []LadderRung // ordered from largest to smallest picture
+}
+
+// Rungs returns a copy of the current ladder, largest first.
+func (l *Ladder) Rungs() []LadderRung {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	out := make([]LadderRung, len(l.rungs))
+	copy(out, l.rungs)
+	return out
+}
+
+// Set validates and replaces the ladder. An empty slice disables snapping.
+func (l *Ladder) Set(rungs []LadderRung) error {
+	out, err := normalizeRungs(rungs)
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	l.rungs = out
+	l.mu.Unlock()
+	return nil
+}
+
+// normalizeRungs validates rungs and returns them as Set would install
+// them: codecs lowercased, sizes evened, names filled in, largest first.
+func normalizeRungs(rungs []LadderRung) ([]LadderRung, error) {
+	out := make([]LadderRung, 0, len(rungs))
+	for i, r := range rungs {
+		if r.Width <= 0 || r.Height <= 0 {
+			return nil, fmt.Errorf("rung %d: w and h must be > 0", i)
+		}
+		if r.BitrateKbps < 0 {
+			return nil, fmt.Errorf("rung %d: bitrateKbps must be >= 0", i)
+		}
+		// An explicit 0 means unset; a copy keeps callers' Rates out of the ladder
+		r.FPS = optRate(r.rate())
+		r.Codec = strings.ToLower(strings.TrimSpace(r.Codec))
+		switch r.Codec {
+		case "", "vp8", "vp9", "av1", "h264":
+		default:
+			return nil, fmt.Errorf("rung %d: unsupported codec %q", i, r.Codec)
+		}
+		// Keep dimensions even like the pipelines do, so snapped keys match encoder sizes
+		r.Width -= r.Width % 2
+		r.Height -= r.Height % 2
+		if r.Name == "" {
+			r.Name = fmt.Sprintf("%dp", r.Height)
+		}
+		out = append(out, r)
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		ai, aj := out[i].Width*out[i].Height, out[j].Width*out[j].Height
+		if ai != aj {
+			return ai > aj
+		}
+		return out[i].BitrateKbps > out[j].BitrateKbps
+	})
+	return out, nil
+}
+
+// Snap maps a requested variant onto the ladder: the smallest rung that covers
+// the requested size (or the top rung if none does). Requested fps/bitrate are
+// kept when lower than the rung's, otherwise the rung's values apply. Requests
+// without a size, or an empty ladder, are returned unchanged with ok=false.
+func (l *Ladder) Snap(req LadderRung) (LadderRung, bool) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if len(l.rungs) == 0 || (req.Width <= 0 && req.Height <= 0) {
+		return req, false
+	}
+	pick := l.rungs[0]
+	for _, r := range l.rungs {
+		if (req.Width <= 0 || r.Width >= req.Width) && (req.Height <= 0 || r.Height >= req.Height) {
+			pick = r
+		}
+	}
+	out := pick
+	if fps := req.rate(); !pick.rate().Valid() || (fps.Valid() && fps.Float() < pick.rate().Float()) {
+		out.FPS = req.FPS
+	}
+	if pick.BitrateKbps <= 0 || (req.BitrateKbps > 0 && req.BitrateKbps < pick.BitrateKbps) {
+		out.BitrateKbps = req.BitrateKbps
+	}
+	if pick.Codec == "" {
+		out.Codec = req.Codec
+	}
+	return out, true
+}
+
+// Below returns the next rung below r, for the downgrade of new mounts
+// under load.
+func (l *Ladder) Below(r LadderRung) (LadderRung, bool) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	for _, c := range l.rungs {
+		if c.Width*c.Height < r.Width*r.Height {
+			return c, true
+		}
+	}
+	return LadderRung{}, false
+}
+
+// downgradeLocked steps v, canonical and about to start a new mount of
+// source, down the ladder: onto the highest lower rung that already has a
+// running mount, which costs no new encoder, or else one rung down. A
+// variant off the ladder, or on its bottom rung, stays as it is. It returns
+// the variant and its mount key. Caller holds s.mu.
+func (s *WhepServer) downgradeLocked(source string, v mountVariant, offered codecSet) (mountVariant, string) {
+	down, downKey := v, s.mountKeyLocked(source, v)
+	cur := LadderRung{Width: v.Width, Height: v.Height}
+	for i := 0; ; i++ {
+		r, ok := s.ladder.Below(cur)
+		if !ok {
+			break
+		}
+		cur = r
+		d := v
+		d.Width, d.Height = r.Width, r.Height
+		if d, ok = s.snapVariant(d, offered); !ok {
+			break
+		}
+		key := s.mountKeyLocked(source, d)
+		if m, ok := s.mounts[key]; ok && m.bc != nil {
+			return d, key
+		}
+		if i == 0 {
+			down, downKey = d, key
+		}
+	}
+	return down, downKey
+}
+
+// variantEndpoint builds the WHEP mount URL that selects rung r of source key.
+func variantEndpoint(key string, r LadderRung) string {
+	q := url.Values{}
+	q.Set("w", strconv.Itoa(r.Width))
+	q.Set("h", strconv.Itoa(r.Height))
+	if r.rate().Valid() {
+		q.Set("fps", r.FPS.String())
+	}
+	if r.BitrateKbps > 0 {
+		q.Set("bitrateKbps", strconv.Itoa(r.BitrateKbps))
+	}
+	return "/whep/ndi/" + key + "?" + q.Encode()
+}
+
+// GET /config/ladder -> { rungs: [...] }
+// PATCH /config/ladder { rungs: [...] } replaces the ladder; with
+// ?dryRun=1 it returns the ladder it would install.
+func (s *WhepServer) handleLadder(w http.ResponseWriter, r *http.Request) {
+	allowCORS(w, r)
+	switch r.Method {
+	case http.MethodOptions:
+		w.WriteHeader(http.StatusNoContent)
+		return
+	case http.MethodGet:
+	case http.MethodPatch:
+		var body struct {
+			Rungs []LadderRung `json:"rungs"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Rungs == nil {
+			writeError(w, r, http.StatusBadRequest, errCodeBadRequest, "invalid JSON or missing 'rungs'", nil)
+			return
+		}
+		if isDryRun(r) {
+			rungs, err := normalizeRungs(body.Rungs)
+			if err != nil {
+				writeError(w, r, http.StatusBadRequest, errCodeBadRequest, err.Error(), nil)
+				return
+			}
+			writeDryRun(w, map[string]any{"rungs": rungs}, s.ladderWarnings(rungs))
+			return
+		}
+		if err := s.ladder.Set(body.Rungs); err != nil {
+			writeError(w, r, http.StatusBadRequest, errCodeBadRequest, err.Error(), nil)
+			return
+		}
+	default:
+		writeError(w, r, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "method not allowed", nil)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{"rungs": s.ladder.Rungs()})
+}
+
+// ladderWarnings flags what installing rungs would leave unexpected: rung
+// codecs this host can't encode, and running mounts that keep a size the
+// ladder no longer has until they are recreated.
+func (s *WhepServer) ladderWarnings(rungs []LadderRung) []string {
+	var out []string
+	if len(rungs) == 0 {
+		out = append(out, "an empty ladder disables snapping")
+	}
+	for i, r := range rungs {
+		if r.Codec == "" {
+			continue
+		}
+		if _, err := stream.ResolveEncoder(r.Codec, ""); err != nil {
+			out = append(out, fmt.Sprintf("rung %d (%s): %v", i, r.Name, err))
+		}
+	}
+	s.mu.Lock()
+	off := 0
+	for _, m := range s.mounts {
+		m.mu.Lock()
+		w, h := m.width, m.height
+		m.mu.Unlock()
+		if w <= 0 && h <= 0 {
+			continue
+		}
+		if !slices.ContainsFunc(rungs, func(r LadderRung) bool { return r.Width == w && r.Height == h }) {
+			off++
+		}
+	}
+	s.mu.Unlock()
+	if off > 0 && len(rungs) > 0 {
+		out = append(out, fmt.Sprintf("%d running mounts have a size not on this ladder and keep it until recreated", off))
+	}
+	return out
+}
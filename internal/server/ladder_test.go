@@ -0,0 +1,102 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// mountSizes returns the w/h parts of the server's mount keys, sorted.
+func mountSizes(s *WhepServer) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []string
+	for key := range s.mounts {
+		parts := strings.Split(key, "|")
+		if len(parts) > 2 {
+			out = append(out, parts[1]+parts[2])
+		}
+	}
+	slices.Sort(out)
+	return out
+}
+
+func TestDowngradeUnderLoad(t *testing.T) {
+	fakePipelines(t)
+	// Any memory in use is past a 1 MB budget, so the score is 100 once
+	// memory has been sampled
+	s, ts := newTestServer(t, func(c *Config) { c.LoadWeights, c.MemoryBudgetMB = LoadWeights{Memory: 1}, 1 })
+	if err := s.ladder.Set([]LadderRung{{Width: 64, Height: 36}, {Width: 32, Height: 18}, {Width: 16, Height: 10}}); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for s.loadScore() < 100 {
+		if time.Now().After(deadline) {
+			t.Fatal("load score never reached 100")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	post := func(q string) {
+		t.Helper()
+		if v := postOffer(t, ts, "/whep/ndi/"+fakeKey+"?"+q); v.code != http.StatusCreated {
+			t.Fatalf("POST ?%s: %d %s", q, v.code, v.body)
+		}
+	}
+	want := func(sizes ...string) {
+		t.Helper()
+		if got := mountSizes(s); !slices.Equal(got, sizes) {
+			t.Fatalf("mounts %v, want %v", got, sizes)
+		}
+	}
+
+	s.cfg.DowngradeLoad = 50
+	// A new mount starts one rung down
+	post("w=64&h=36")
+	want("w32h18")
+	// With a lower rung running, a new mount isn't started at all
+	post("w=64&h=36")
+	want("w32h18")
+	// The bottom rung can't go lower
+	post("w=16&h=10")
+	want("w16h10", "w32h18")
+
+	// Not overloaded, requests get the rung they ask for
+	s.cfg.DowngradeLoad = 0
+	post("w=64&h=36")
+	want("w16h10", "w32h18", "w64h36")
+	// and overloaded, a running mount is joined as asked
+	s.cfg.DowngradeLoad = 50
+	post("w=64&h=36")
+	want("w16h10", "w32h18", "w64h36")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, m := range s.mounts {
+		m.mu.Lock()
+		n := len(m.sessions)
+		m.mu.Unlock()
+		if strings.Contains(key, "|w64|") && n != 2 {
+			t.Errorf("64x36 mount has %d sessions, want 2", n)
+		}
+	}
+}
+
+func TestLadderRungJSONOmitsUnsetFPS(t *testing.T) {
+	var l Ladder
+	if err := json.Unmarshal([]byte(`[{"w":1280,"h":720,"fps":0},{"w":640,"h":360,"fps":"30000/1001"}]`), &l.rungs); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Set(l.rungs); err != nil {
+		t.Fatal(err)
+	}
+	b, err := json.Marshal(l.Rungs())
+	if err != nil {
+		t.Fatal(err)
+	}
+	const want = `[{"name":"720p","w":1280,"h":720},{"name":"360p","w":640,"h":360,"fps":"30000/1001"}]`
+	if string(b) != want {
+		t.Fatalf("got %s, want %s", b, want)
+	}
+}
@@ -0,0 +1,124 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// sessionRetryAfter is the Retry-After, in seconds, sent with a session
+// refused by -max-sessions or -max-sessions-per-mount.
+const sessionRetryAfter = 5
+
+// sessionLimitError is a refused session: scope is "global" or "mount".
+type sessionLimitError struct {
+	scope string
+	mount string
+	open  int
+	limit int
+}
+
+func (e *sessionLimitError) Error() string {
+	if e.scope == "global" {
+		return fmt.Sprintf("session limit reached (%d of %d)", e.open, e.limit)
+	}
+	return fmt.Sprintf("session limit for mount %s reached (%d of %d)", e.mount, e.open, e.limit)
+}
+
+func (e *sessionLimitError) details() map[string]any {
+	d := map[string]any{"scope": e.scope, "open": e.open, "limit": e.limit}
+	if e.mount != "" {
+		d["mount"] = e.mount
+	}
+	return d
+}
+
+// sessionMount is the mount a session counts against for
+// -max-sessions-per-mount: its source key (all variants together), or
+// "shared" for /whep.
+func sessionMount(mountKey string) string {
+	if mountKey == "" {
+		return sharedMetricsLabel
+	}
+	base, _, _ := strings.Cut(mountKey, "|")
+	return base
+}
+
+// sessionUsageLocked counts registered sessions plus reserved slots, in
+// total and per mount. Caller holds s.mu.
+func (s *WhepServer) sessionUsageLocked() (total int, perMount map[string]int) {
+	perMount = map[string]int{}
+	for _, ss := range s.sessions {
+		perMount[sessionMount(ss.mountKey)]++
+	}
+	for m, n := range s.sessionsReserved {
+		perMount[m] += n
+	}
+	for _, n := range perMount {
+		total += n
+	}
+	return total, perMount
+}
+
+// reserveSession claims a session slot on mount before a POST sets up its
+// PeerConnection, so a burst of retries can't all pass the check at once.
+// The returned release must be called once the session is registered or
+// abandoned.
+func (s *WhepServer) reserveSession(mount string) (release func(), refused *sessionLimitError) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.sessionLimitLocked(mount); err != nil {
+		return nil, err
+	}
+	if s.sessionsReserved == nil {
+		s.sessionsReserved = map[string]int{}
+	}
+	s.sessionsReserved[mount]++
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			s.mu.Lock()
+			if s.sessionsReserved[mount]--; s.sessionsReserved[mount] <= 0 {
+				delete(s.sessionsReserved, mount)
+			}
+			s.mu.Unlock()
+		})
+	}, nil
+}
+
+// sessionLimitLocked reports the limit one more session on mount would
+// exceed, or nil. Caller holds s.mu.
+func (s *WhepServer) sessionLimitLocked(mount string) *sessionLimitError {
+	if s.cfg.MaxSessions <= 0 && s.cfg.MaxSessionsPerMount <= 0 {
+		return nil
+	}
+	total, perMount := s.sessionUsageLocked()
+	if s.cfg.MaxSessions > 0 && total >= s.cfg.MaxSessions {
+		return &sessionLimitError{scope: "global", open: total, limit: s.cfg.MaxSessions}
+	}
+	if n := perMount[mount]; s.cfg.MaxSessionsPerMount > 0 && n >= s.cfg.MaxSessionsPerMount {
+		return &sessionLimitError{scope: "mount", mount: mount, open: n, limit: s.cfg.MaxSessionsPerMount}
+	}
+	return nil
+}
+
+// refuseSessionLimit answers 503 limit_exceeded with Retry-After.
+func refuseSessionLimit(w http.ResponseWriter, r *http.Request, err *sessionLimitError) {
+	w.Header().Set("Retry-After", strconv.Itoa(sessionRetryAfter))
+	writeError(w, r, http.StatusServiceUnavailable, errCodeLimitExceeded, err.Error(), err.details())
+}
+
+// sessionLimitStats is the limits and current usage for /health.
+func (s *WhepServer) sessionLimitStats() map[string]any {
+	s.mu.Lock()
+	total, perMount := s.sessionUsageLocked()
+	s.mu.Unlock()
+	return map[string]any{
+		"max_sessions":           s.cfg.MaxSessions,
+		"max_sessions_per_mount": s.cfg.MaxSessionsPerMount,
+		"sessions":               total,
+		"per_mount":              perMount,
+	}
+}
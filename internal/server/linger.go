@@ -0,0 +1,102 @@
+package server
+
+import (
+	"log"
+	"time"
+)
+
+// Shared pipeline linger. When the last session leaves, the shared pipeline
+// keeps its source connected and its encoder running for the linger period,
+// so a viewer refreshing the page rejoins at once instead of paying for an
+// NDI reconnect and encoder start. It is stopped when the linger runs out
+// with nobody back; a new POST cancels the countdown.
+
+// defaultSharedLinger is used when Config.SharedLinger is 0.
+const defaultSharedLinger = 30 * time.Second
+
+// Shared pipeline states reported by /health
+const (
+	shareStateRunning   = "running"
+	shareStateLingering = "lingering"
+	shareStateStopped   = "stopped"
+)
+
+func (s *WhepServer) sharedLinger() time.Duration {
+	if s.cfg.SharedLinger != 0 {
+		return s.cfg.SharedLinger
+	}
+	return defaultSharedLinger
+}
+
+// lingerSharedLocked stops the unused shared pipeline after the linger, or
+// at once without one. Caller holds s.mu.
+func (s *WhepServer) lingerSharedLocked() {
+	d := s.sharedLinger()
+	if d < 0 {
+		s.stopSharedLocked()
+		log.Printf("Shared pipeline stopped (no active sessions)")
+		return
+	}
+	if s.shareLinger != nil {
+		return
+	}
+	var t *time.Timer
+	t = time.AfterFunc(d, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		// Cancelled, or replaced by a later countdown
+		if s.shareLinger != t {
+			return
+		}
+		s.shareLinger = nil
+		s.changes.bump()
+		if s.sharedUnusedLocked() {
+			s.stopSharedLocked()
+			log.Printf("Shared pipeline stopped (no sessions for %s)", d)
+		}
+	})
+	s.shareLinger, s.shareLingerUntil = t, time.Now().Add(d)
+	s.changes.bump()
+	log.Printf("Shared pipeline lingering for %s (no active sessions)", d)
+}
+
+// cancelSharedLingerLocked keeps the shared pipeline running, e.g. for a
+// POST about to use it. Caller holds s.mu.
+func (s *WhepServer) cancelSharedLingerLocked() {
+	if s.shareLinger == nil {
+		return
+	}
+	s.shareLinger.Stop()
+	s.shareLinger = nil
+	s.changes.bump()
+	log.Printf("Shared pipeline linger cancelled (session joining)")
+}
+
+// sharedUnusedLocked reports whether no session uses the shared pipeline
+// and no POST is about to. Caller holds s.mu.
+func (s *WhepServer) sharedUnusedLocked() bool {
+	return len(s.sessions) == 0 && s.sharePending == 0 && s.shareBC != nil
+}
+
+// sharedHealthLocked is /health's shared_pipeline entry. Caller holds s.mu.
+func (s *WhepServer) sharedHealthLocked() map[string]any {
+	out := map[string]any{"state": shareStateStopped, "linger": sharedLingerValue(s.sharedLinger())}
+	switch {
+	case s.shareBC == nil:
+	case s.shareLinger != nil:
+		out["state"] = shareStateLingering
+		out["codec"] = s.shareCodec
+		out["stops_in_ms"] = max(time.Until(s.shareLingerUntil).Milliseconds(), 0)
+	default:
+		out["state"] = shareStateRunning
+		out["codec"] = s.shareCodec
+	}
+	return out
+}
+
+func sharedLingerValue(d time.Duration) string {
+	if d < 0 {
+		return "0s"
+	}
+	return d.String()
+}
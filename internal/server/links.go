@@ -0,0 +1,417 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Guest links. POST /admin/links {"source", "w", "h", "bitrateKbps", "ttl"}
+// creates an opaque /l/{id} that stands for one mount variant until it
+// expires: a WHEP POST to it plays that mount, and GET serves a player
+// already pointed at it, so a reviewer needs nothing but the URL. Links are
+// a convenience, not access control: the mount stays playable at
+// /whep/ndi/{key} by anyone who can reach the server; only managing links
+// needs admin rights. Once the link expires or is revoked (DELETE /admin/links/{id})
+// it answers 410 and the sessions opened through it are ended. Links live
+// in memory and don't survive a restart.
+
+// linkRetention is how long an expired or revoked link keeps answering 410
+// before it is forgotten (and answers 404).
+const linkRetention = 24 * time.Hour
+
+// maxLinkTTL bounds a link's lifetime.
+const maxLinkTTL = 30 * 24 * time.Hour
+
+// Link states
+const (
+	linkActive  = "active"
+	linkExpired = "expired"
+	linkRevoked = "revoked"
+)
+
+// guestLink is one link and its usage.
+type guestLink struct {
+	id          string
+	source      string // mount key
+	width       int
+	height      int
+	bitrateKbps int
+	created     time.Time
+	expires     time.Time
+	revoked     time.Time // zero unless revoked
+	timer       *time.Timer
+	views       int                 // player page loads
+	uses        int                 // sessions opened
+	lastUsed    time.Time           // last session opened
+	sessions    map[string]struct{} // opened through the link and still open
+}
+
+// linkStatus is a link's entry in GET /admin/links.
+type linkStatus struct {
+	ID          string     `json:"id"`
+	Path        string     `json:"path"`
+	URL         string     `json:"url"`
+	Source      string     `json:"source"`
+	Width       int        `json:"w,omitempty"`
+	Height      int        `json:"h,omitempty"`
+	BitrateKbps int        `json:"bitrateKbps,omitempty"`
+	Created     time.Time  `json:"created"`
+	Expires     time.Time  `json:"expires"`
+	State       string     `json:"state"`
+	Views       int        `json:"views"`
+	Uses        int        `json:"uses"`
+	Active      int        `json:"active"` // sessions still open
+	LastUsed    *time.Time `json:"lastUsed,omitempty"`
+}
+
+// linkSet holds the links by id.
+type linkSet struct {
+	mu    sync.Mutex
+	links map[string]*guestLink
+}
+
+// stateLocked is l's state at now. Caller holds the linkSet's mu.
+func (l *guestLink) stateLocked(now time.Time) string {
+	switch {
+	case !l.revoked.IsZero():
+		return linkRevoked
+	case !now.Before(l.expires):
+		return linkExpired
+	}
+	return linkActive
+}
+
+// pruneLocked forgets links past their retention. Caller holds ls.mu.
+func (ls *linkSet) pruneLocked(now time.Time) {
+	for id, l := range ls.links {
+		end := l.expires
+		if !l.revoked.IsZero() && l.revoked.Before(end) {
+			end = l.revoked
+		}
+		if now.Sub(end) > linkRetention {
+			delete(ls.links, id)
+		}
+	}
+}
+
+// parseLinkTTL reads "ttl" as seconds (7200) or a duration ("2h").
+func parseLinkTTL(raw json.RawMessage) (time.Duration, error) {
+	var d time.Duration
+	var secs float64
+	var str string
+	switch {
+	case len(raw) == 0:
+		return 0, errors.New("missing 'ttl'")
+	case json.Unmarshal(raw, &secs) == nil:
+		if secs > maxLinkTTL.Seconds() {
+			secs = maxLinkTTL.Seconds() + 1
+		}
+		d = time.Duration(secs * float64(time.Second))
+	case json.Unmarshal(raw, &str) == nil:
+		var err error
+		if d, err = time.ParseDuration(str); err != nil {
+			return 0, fmt.Errorf("ttl: %v", err)
+		}
+	default:
+		return 0, errors.New("ttl must be seconds or a duration like \"2h\"")
+	}
+	if d <= 0 || d > maxLinkTTL {
+		return 0, fmt.Errorf("ttl must be > 0 and at most %s", maxLinkTTL)
+	}
+	return d, nil
+}
+
+// linkStatusLocked reports l. Caller holds s.links.mu.
+func (s *WhepServer) linkStatusLocked(r *http.Request, l *guestLink, now time.Time) linkStatus {
+	st := linkStatus{ID: l.id, Path: "/l/" + l.id, URL: s.resourceURL(r, "/l/"+l.id), Source: l.source, Width: l.width, Height: l.height, BitrateKbps: l.bitrateKbps, Created: l.created, Expires: l.expires, State: l.stateLocked(now), Views: l.views, Uses: l.uses}
+	if !l.lastUsed.IsZero() {
+		t := l.lastUsed
+		st.LastUsed = &t
+	}
+	st.Active = len(l.sessions)
+	return st
+}
+
+// dropSession forgets sid, closed, from link id's sessions.
+func (ls *linkSet) dropSession(id, sid string) {
+	ls.mu.Lock()
+	if l := ls.links[id]; l != nil {
+		delete(l.sessions, sid)
+	}
+	ls.mu.Unlock()
+}
+
+// endLink ends a link's sessions once it expires or is revoked.
+func (s *WhepServer) endLink(id, state string) {
+	ls := &s.links
+	ls.mu.Lock()
+	l := ls.links[id]
+	var ids []string
+	if l != nil {
+		for sid := range l.sessions {
+			ids = append(ids, sid)
+		}
+		l.sessions = map[string]struct{}{}
+	}
+	ls.mu.Unlock()
+	if l == nil {
+		return
+	}
+	for _, sid := range ids {
+		s.endSession(sid, "link "+state)
+	}
+	log.Printf("Guest link %s %s; ended %d sessions", id, state, len(ids))
+	s.emitEvent("link_"+state, map[string]any{"id": id, "source": l.source, "sessions": len(ids)})
+}
+
+// GET /admin/links lists the links with their usage, GET /admin/links/{id}
+// shows one; POST /admin/links creates one; DELETE /admin/links/{id}
+// revokes it.
+func (s *WhepServer) handleLinks(w http.ResponseWriter, r *http.Request) {
+	allowCORS(w, r)
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/admin/links"), "/")
+	ls := &s.links
+	now := time.Now()
+	switch r.Method {
+	case http.MethodOptions:
+		w.WriteHeader(http.StatusNoContent)
+		return
+	case http.MethodGet:
+		ls.mu.Lock()
+		ls.pruneLocked(now)
+		if id != "" {
+			l := ls.links[id]
+			if l == nil {
+				ls.mu.Unlock()
+				writeError(w, r, http.StatusNotFound, errCodeNotFound, "no link "+id, nil)
+				return
+			}
+			st := s.linkStatusLocked(r, l, now)
+			ls.mu.Unlock()
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(st)
+			return
+		}
+		out := make([]linkStatus, 0, len(ls.links))
+		for _, l := range ls.links {
+			out = append(out, s.linkStatusLocked(r, l, now))
+		}
+		ls.mu.Unlock()
+		sort.Slice(out, func(i, j int) bool { return out[i].Created.Before(out[j].Created) })
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{"links": out})
+		return
+	case http.MethodDelete:
+		ls.mu.Lock()
+		l := ls.links[id]
+		if l == nil {
+			ls.mu.Unlock()
+			writeError(w, r, http.StatusNotFound, errCodeNotFound, "no link "+id, nil)
+			return
+		}
+		if l.stateLocked(now) != linkActive {
+			ls.mu.Unlock()
+			writeError(w, r, http.StatusGone, errCodeGone, "link "+id+" is "+l.stateLocked(now), nil)
+			return
+		}
+		l.revoked = now
+		l.timer.Stop()
+		ls.mu.Unlock()
+		s.endLink(id, linkRevoked)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	case http.MethodPost:
+		if id != "" {
+			writeError(w, r, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "POST /admin/links", nil)
+			return
+		}
+	default:
+		writeError(w, r, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "method not allowed", nil)
+		return
+	}
+
+	var body struct {
+		Source      string          `json:"source"`
+		Width       int             `json:"w"`
+		Height      int             `json:"h"`
+		BitrateKbps int             `json:"bitrateKbps"`
+		TTL         json.RawMessage `json:"ttl"`
+	}
+	if err := json.NewDecoder(io.LimitReader(r.Body, 4096)).Decode(&body); err != nil || strings.TrimSpace(body.Source) == "" {
+		writeError(w, r, http.StatusBadRequest, errCodeBadRequest, "invalid JSON or missing 'source'", nil)
+		return
+	}
+	ttl, err := parseLinkTTL(body.TTL)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, errCodeBadRequest, err.Error(), nil)
+		return
+	}
+	if body.Width < 0 || body.Height < 0 || body.BitrateKbps < 0 {
+		writeError(w, r, http.StatusBadRequest, errCodeBadRequest, "w, h and bitrateKbps must be >= 0", nil)
+		return
+	}
+	key := s.canonicalSourceKey(strings.TrimSpace(body.Source))
+	s.mu.Lock()
+	_, _, found := s.mountSourceLocked(key)
+	s.mu.Unlock()
+	if !found {
+		writeError(w, r, http.StatusNotFound, errCodeSourceNotFound, "source not found: "+body.Source, map[string]string{"source": body.Source})
+		return
+	}
+	l := &guestLink{id: uuid.New().String(), source: key, width: body.Width, height: body.Height, bitrateKbps: body.BitrateKbps, created: now, expires: now.Add(ttl), sessions: map[string]struct{}{}}
+	ls.mu.Lock()
+	if ls.links == nil {
+		ls.links = map[string]*guestLink{}
+	}
+	ls.pruneLocked(now)
+	ls.links[l.id] = l
+	l.timer = time.AfterFunc(ttl, func() { s.endLink(l.id, linkExpired) })
+	st := s.linkStatusLocked(r, l, now)
+	ls.mu.Unlock()
+	log.Printf("Guest link %s created for %s, expires %s", l.id, key, l.expires.Format(time.RFC3339))
+	s.emitEvent("link_created", map[string]any{"id": l.id, "source": key, "expires": l.expires})
+	w.Header().Set("Location", st.URL)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(st)
+}
+
+// handleLink serves /l/{id}: POST an SDP offer to play the link's mount, or
+// GET its player page.
+func (s *WhepServer) handleLink(w http.ResponseWriter, r *http.Request) {
+	allowCORS(w, r)
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/l/"), "/")
+	switch r.Method {
+	case http.MethodOptions:
+		w.WriteHeader(http.StatusNoContent)
+		return
+	case http.MethodGet, http.MethodPost:
+	default:
+		writeError(w, r, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "method not allowed", nil)
+		return
+	}
+	ls := &s.links
+	now := time.Now()
+	ls.mu.Lock()
+	ls.pruneLocked(now)
+	l := ls.links[id]
+	if l == nil {
+		ls.mu.Unlock()
+		writeError(w, r, http.StatusNotFound, errCodeNotFound, "no such link", nil)
+		return
+	}
+	if st := l.stateLocked(now); st != linkActive {
+		ls.mu.Unlock()
+		writeError(w, r, http.StatusGone, errCodeGone, "link "+st, map[string]any{"state": st, "expires": l.expires})
+		return
+	}
+	source, q := l.source, url.Values{}
+	if l.width > 0 {
+		q.Set("w", strconv.Itoa(l.width))
+	}
+	if l.height > 0 {
+		q.Set("h", strconv.Itoa(l.height))
+	}
+	if l.bitrateKbps > 0 {
+		q.Set("bitrateKbps", strconv.Itoa(l.bitrateKbps))
+	}
+	if r.Method == http.MethodGet {
+		l.views++
+		ls.mu.Unlock()
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		_, _ = io.WriteString(w, linkPlayerHTML(source))
+		return
+	}
+	ls.mu.Unlock()
+
+	// Play it as a POST to the mount; only the link's variant applies
+	r2 := r.Clone(r.Context())
+	r2.URL = &url.URL{Path: "/whep/ndi/" + source, RawQuery: q.Encode()}
+	s.handleWHEPNDI(w, r2)
+	loc := w.Header().Get("Location")
+	if loc == "" {
+		return
+	}
+	sid := path.Base(loc)
+	ls.mu.Lock()
+	live := l.stateLocked(time.Now()) == linkActive
+	if live {
+		if _, seen := l.sessions[sid]; !seen {
+			l.uses++
+			l.lastUsed = time.Now()
+			// A session still open drops itself from the link when it
+			// closes; one already gone isn't kept
+			s.mu.Lock()
+			if sess := s.sessions[sid]; sess != nil {
+				sess.link = l.id
+				l.sessions[sid] = struct{}{}
+			}
+			s.mu.Unlock()
+		}
+	}
+	ls.mu.Unlock()
+	if !live {
+		// Expired while the session was set up
+		s.endSession(sid, "link expired")
+	}
+}
+
+// linkPlayerHTML is the player page of a link: it plays the page's own URL.
+func linkPlayerHTML(source string) string {
+	return fmt.Sprintf(linkPlayerPage, html.EscapeString(source))
+}
+
+const linkPlayerPage = `<!doctype html>
+<meta charset="utf-8" />
+<meta name="viewport" content="width=device-width,initial-scale=1" />
+<title>%[1]s</title>
+<style>html,body{margin:0;height:100%%;background:#000;color:#ccc;font-family:system-ui}video{width:100%%;height:100%%;object-fit:contain}#msg{position:fixed;left:1rem;bottom:1rem}</style>
+<video id="v" autoplay playsinline muted controls></video>
+<div id="msg">Connecting to %[1]s…</div>
+<script>
+(async () => {
+  const v = document.getElementById('v'), msg = document.getElementById('msg');
+  const pc = new RTCPeerConnection();
+  let resource = null;
+  pc.addTransceiver('video', { direction: 'recvonly' });
+  pc.addTransceiver('audio', { direction: 'recvonly' });
+  const inbound = new MediaStream();
+  pc.ontrack = (ev) => { inbound.addTrack(ev.track); v.srcObject = inbound; };
+  pc.onconnectionstatechange = () => {
+    if (pc.connectionState === 'connected') msg.textContent = '';
+    if (pc.connectionState === 'failed' || pc.connectionState === 'closed') msg.textContent = 'Disconnected';
+  };
+  try {
+    await pc.setLocalDescription(await pc.createOffer());
+    await new Promise((done) => {
+      if (pc.iceGatheringState === 'complete') return done();
+      pc.onicegatheringstatechange = () => { if (pc.iceGatheringState === 'complete') done(); };
+    });
+    const resp = await fetch(location.pathname, { method: 'POST', headers: { 'Content-Type': 'application/sdp' }, body: pc.localDescription.sdp });
+    if (resp.status === 410) { msg.textContent = 'This link has expired'; pc.close(); return; }
+    if (!resp.ok) throw new Error('HTTP ' + resp.status);
+    resource = resp.headers.get('Location');
+    await pc.setRemoteDescription({ type: 'answer', sdp: await resp.text() });
+  } catch (err) {
+    msg.textContent = 'Could not play: ' + (err && err.message || err);
+    pc.close();
+  }
+  addEventListener('pagehide', () => { if (resource) fetch(resource, { method: 'DELETE', keepalive: true }); });
+})();
+</script>
+`
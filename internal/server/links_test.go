@@ -0,0 +1,51 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// linkSessions returns how many sessions link id holds on to.
+func linkSessions(s *WhepServer, id string) int {
+	s.links.mu.Lock()
+	defer s.links.mu.Unlock()
+	return len(s.links.links[id].sessions)
+}
+
+func TestLinkForgetsClosedSessions(t *testing.T) {
+	fakePipelines(t)
+	s, ts := newTestServer(t)
+	resp, err := http.Post(ts.URL+"/admin/links", "application/json", strings.NewReader(`{"source": "`+fakeKey+`", "ttl": "1h"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var st linkStatus
+	err = json.NewDecoder(resp.Body).Decode(&st)
+	resp.Body.Close()
+	if err != nil || resp.StatusCode != http.StatusCreated {
+		t.Fatalf("POST /admin/links: %d %v", resp.StatusCode, err)
+	}
+	for i := 0; i < 3; i++ {
+		v := postOffer(t, ts, st.Path)
+		if v.code != http.StatusCreated {
+			t.Fatalf("POST %s: %d %s", st.Path, v.code, v.body)
+		}
+	}
+	if n := linkSessions(s, st.ID); n != 3 {
+		t.Fatalf("link holds %d sessions, want 3", n)
+	}
+	for _, id := range sessionIDs(s) {
+		s.closeSession(id)
+	}
+	if n := linkSessions(s, st.ID); n != 0 {
+		t.Fatalf("link holds %d closed sessions", n)
+	}
+	s.links.mu.Lock()
+	uses := s.links.links[st.ID].uses
+	s.links.mu.Unlock()
+	if uses != 3 {
+		t.Fatalf("%d uses, want 3", uses)
+	}
+}
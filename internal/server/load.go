@@ -0,0 +1,263 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"whep/internal/stream"
+)
+
+// Load score. /loadz reports how busy the server is as one number from 0
+// (idle) to 100 (saturated): the weighted mean of its components'
+// utilization, so a load balancer can send new viewers to the least loaded
+// instance. OS counters are sampled in the background, so the endpoint is
+// cheap to poll every second.
+const (
+	loadInterval = time.Second
+	// loadHeader carries the score on WHEP 201s when -load-header is on
+	loadHeader = "X-Server-Load"
+)
+
+// Load score components
+const (
+	loadEncoders = "encoders"
+	loadCPU      = "cpu"
+	loadMemory   = "memory"
+	loadSockets  = "sockets"
+)
+
+// LoadWeights weights the load score's components. A component that can't
+// be measured (CPU or memory on an unsupported platform, sockets without
+// -max-sockets) is left out and the others renormalized.
+type LoadWeights struct {
+	Encoders, CPU, Memory, Sockets float64
+}
+
+var defaultLoadWeights = LoadWeights{Encoders: 0.4, CPU: 0.3, Memory: 0.15, Sockets: 0.15}
+
+// ParseLoadWeights parses "encoders=0.4,cpu=0.3,memory=0.15,sockets=0.15".
+// Components left out weigh 0; an empty string gives the defaults.
+func ParseLoadWeights(v string) (LoadWeights, error) {
+	if strings.TrimSpace(v) == "" {
+		return defaultLoadWeights, nil
+	}
+	var lw LoadWeights
+	for _, part := range strings.Split(v, ",") {
+		name, val, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			return lw, fmt.Errorf("%q: want component=weight", part)
+		}
+		f, err := strconv.ParseFloat(strings.TrimSpace(val), 64)
+		if err != nil || !(f >= 0) || math.IsInf(f, 0) {
+			return lw, fmt.Errorf("%q: weight must be a number >= 0", part)
+		}
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case loadEncoders:
+			lw.Encoders = f
+		case loadCPU:
+			lw.CPU = f
+		case loadMemory:
+			lw.Memory = f
+		case loadSockets:
+			lw.Sockets = f
+		default:
+			return lw, fmt.Errorf("unknown component %q (want encoders, cpu, memory or sockets)", name)
+		}
+	}
+	if lw.Encoders+lw.CPU+lw.Memory+lw.Sockets == 0 {
+		return lw, fmt.Errorf("all weights are 0")
+	}
+	return lw, nil
+}
+
+func (lw LoadWeights) String() string {
+	f := func(x float64) string { return strconv.FormatFloat(x, 'g', -1, 64) }
+	return fmt.Sprintf("encoders=%s,cpu=%s,memory=%s,sockets=%s", f(lw.Encoders), f(lw.CPU), f(lw.Memory), f(lw.Sockets))
+}
+
+// loadSampler keeps the latest OS-level CPU and memory readings.
+type loadSampler struct {
+	mu                  sync.Mutex
+	prevIdle, prevTotal uint64
+	cpu                 float64 // busy share of all CPUs over the last interval
+	cpuOK               bool
+	memUsed, memLimit   uint64
+	memBasis            string // "process" (against -memory-budget-mb) or "system"
+	memOK               bool
+}
+
+// runLoadSampler samples CPU and memory until the server closes.
+func (s *WhepServer) runLoadSampler() {
+	s.sampleLoad()
+	ticker := time.NewTicker(loadInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			s.sampleLoad()
+		}
+	}
+}
+
+func (s *WhepServer) sampleLoad() {
+	ls := &s.load
+	idle, total, cpuOK := cpuTimes()
+	var used, limit uint64
+	var basis string
+	var memOK bool
+	if s.cfg.MemoryBudgetMB > 0 {
+		used, memOK = processRSS()
+		limit, basis = uint64(s.cfg.MemoryBudgetMB)<<20, "process"
+	} else {
+		used, limit, memOK = systemMemory()
+		basis = "system"
+	}
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	if cpuOK && ls.prevTotal > 0 && total > ls.prevTotal {
+		dt := total - ls.prevTotal
+		ls.cpu = 1 - float64(idle-ls.prevIdle)/float64(dt)
+		ls.cpuOK = true
+	}
+	if cpuOK {
+		ls.prevIdle, ls.prevTotal = idle, total
+	}
+	ls.memUsed, ls.memLimit, ls.memBasis, ls.memOK = used, limit, basis, memOK && limit > 0
+}
+
+// encoderCapacity is how many video encoders count as full load: the
+// -encoder-capacity setting, or one per two CPUs.
+func (s *WhepServer) encoderCapacity() int {
+	if s.cfg.EncoderCapacity > 0 {
+		return s.cfg.EncoderCapacity
+	}
+	return max(runtime.NumCPU()/2, 1)
+}
+
+func (s *WhepServer) loadWeights() LoadWeights {
+	if lw := s.cfg.LoadWeights; lw.Encoders+lw.CPU+lw.Memory+lw.Sockets > 0 {
+		return lw
+	}
+	return defaultLoadWeights
+}
+
+// loadReport computes the score and its components. A component's "load"
+// is its utilization (1 = at capacity; it may go past 1, but counts as 1
+// in the score).
+func (s *WhepServer) loadReport() (score int, components map[string]map[string]any) {
+	lw := s.loadWeights()
+	components = map[string]map[string]any{}
+	var sum, weights float64
+	add := func(name string, weight float64, load float64, ok bool, extra map[string]any) {
+		c := map[string]any{"weight": weight, "available": ok}
+		for k, v := range extra {
+			c[k] = v
+		}
+		if ok {
+			c["load"] = math.Round(load*1000) / 1000
+			if weight > 0 {
+				sum += weight * min(max(load, 0), 1)
+				weights += weight
+			}
+		}
+		components[name] = c
+	}
+
+	rs := stream.GetRuntimeStats()
+	encoders := rs["active_pipelines"] - rs["active_opus"]
+	capacity := s.encoderCapacity()
+	add(loadEncoders, lw.Encoders, float64(encoders)/float64(capacity), true, map[string]any{"active": encoders, "capacity": capacity})
+
+	ls := &s.load
+	ls.mu.Lock()
+	cpu, cpuOK := ls.cpu, ls.cpuOK
+	memUsed, memLimit, memBasis, memOK := ls.memUsed, ls.memLimit, ls.memBasis, ls.memOK
+	ls.mu.Unlock()
+	add(loadCPU, lw.CPU, cpu, cpuOK, map[string]any{"cpus": runtime.NumCPU()})
+	var memLoad float64
+	if memOK {
+		memLoad = float64(memUsed) / float64(memLimit)
+	}
+	add(loadMemory, lw.Memory, memLoad, memOK, map[string]any{"basis": memBasis, "used_bytes": memUsed, "limit_bytes": memLimit})
+
+	open, limit := s.openSockets(), s.cfg.MaxSockets
+	var sockLoad float64
+	if limit > 0 {
+		sockLoad = float64(open) / float64(limit)
+	}
+	add(loadSockets, lw.Sockets, sockLoad, limit > 0, map[string]any{"open": open, "limit": limit})
+
+	if weights > 0 {
+		score = int(math.Round(100 * sum / weights))
+	}
+	return score, components
+}
+
+// loadScore is just the score, for the WHEP response header and /metrics.
+func (s *WhepServer) loadScore() int {
+	score, _ := s.loadReport()
+	return score
+}
+
+// overloaded reports whether the load score has reached -downgrade-load,
+// from which new mounts are stepped down the ladder.
+func (s *WhepServer) overloaded() bool {
+	return s.cfg.DowngradeLoad > 0 && s.loadScore() >= s.cfg.DowngradeLoad
+}
+
+// setLoadHeader adds the load score to a WHEP answer when -load-header is on.
+func (s *WhepServer) setLoadHeader(w http.ResponseWriter) {
+	if s.cfg.LoadHeader {
+		w.Header().Set(loadHeader, strconv.Itoa(s.loadScore()))
+	}
+}
+
+// handleLoadz serves GET /loadz: {"score": 0..100, "components": {...}}.
+func (s *WhepServer) handleLoadz(w http.ResponseWriter, r *http.Request) {
+	allowCORS(w, r)
+	switch r.Method {
+	case http.MethodOptions:
+		w.WriteHeader(http.StatusNoContent)
+		return
+	case http.MethodGet, http.MethodHead:
+	default:
+		writeError(w, r, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "method not allowed", nil)
+		return
+	}
+	score, components := s.loadReport()
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set(loadHeader, strconv.Itoa(score))
+	if r.Method == http.MethodHead {
+		return
+	}
+	_ = json.NewEncoder(w).Encode(map[string]any{"score": score, "components": components})
+}
+
+// writeLoadMetrics appends the load score and its components to /metrics.
+func (s *WhepServer) writeLoadMetrics(b *strings.Builder) {
+	score, components := s.loadReport()
+	writeMetricHeader(b, "whep_load_score", "gauge", "Aggregate server load, 0 (idle) to 100 (saturated).")
+	fmt.Fprintf(b, "whep_load_score %d\n", score)
+	names := make([]string, 0, len(components))
+	for name := range components {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	writeMetricHeader(b, "whep_load_component", "gauge", "Utilization of each load score component (1 = at capacity).")
+	for _, name := range names {
+		if load, ok := components[name]["load"].(float64); ok {
+			fmt.Fprintf(b, "whep_load_component{component=%s} %g\n", promLabel(name), load)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+//go:build linux
+
+package server
+
+import (
+	"bufio"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// cpuTimes returns the idle and total jiffies of all CPUs from /proc/stat.
+func cpuTimes() (idle, total uint64, ok bool) {
+	f, err := os.Open("/proc/stat")
+	if err != nil {
+		return 0, 0, false
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	if !sc.Scan() {
+		return 0, 0, false
+	}
+	fields := strings.Fields(sc.Text())
+	if len(fields) < 5 || fields[0] != "cpu" {
+		return 0, 0, false
+	}
+	// user nice system idle iowait irq softirq steal; guest time is already
+	// part of user
+	for i, v := range fields[1:min(len(fields), 9)] {
+		n, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			return 0, 0, false
+		}
+		total += n
+		if i == 3 || i == 4 {
+			idle += n
+		}
+	}
+	return idle, total, true
+}
+
+// systemMemory returns memory in use (total less available) and the total,
+// in bytes, from /proc/meminfo.
+func systemMemory() (used, total uint64, ok bool) {
+	b, err := os.ReadFile("/proc/meminfo")
+	if err != nil {
+		return 0, 0, false
+	}
+	var avail uint64
+	var haveTotal, haveAvail bool
+	for _, line := range strings.Split(string(b), "\n") {
+		name, rest, found := strings.Cut(line, ":")
+		if !found {
+			continue
+		}
+		kb, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimSpace(rest), " kB"), 10, 64)
+		if err != nil {
+			continue
+		}
+		switch name {
+		case "MemTotal":
+			total, haveTotal = kb<<10, true
+		case "MemAvailable":
+			avail, haveAvail = kb<<10, true
+		}
+	}
+	if !haveTotal || !haveAvail || avail > total {
+		return 0, 0, false
+	}
+	return total - avail, total, true
+}
+
+// processRSS returns the process's resident memory in bytes, which unlike
+// the Go heap includes the encoders' and NDI SDK's native allocations.
+func processRSS() (uint64, bool) {
+	b, err := os.ReadFile("/proc/self/statm")
+	if err != nil {
+		return 0, false
+	}
+	fields := strings.Fields(string(b))
+	if len(fields) < 2 {
+		return 0, false
+	}
+	pages, err := strconv.ParseUint(fields[1], 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return pages * uint64(os.Getpagesize()), true
+}
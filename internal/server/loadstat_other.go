@@ -0,0 +1,12 @@
+//go:build !linux && !windows
+
+package server
+
+// CPU and memory counters aren't read on this platform; the load score
+// leaves those components out.
+
+func cpuTimes() (idle, total uint64, ok bool) { return 0, 0, false }
+
+func systemMemory() (used, total uint64, ok bool) { return 0, 0, false }
+
+func processRSS() (uint64, bool) { return 0, false }
@@ -0,0 +1,82 @@
+//go:build windows
+
+package server
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+var (
+	kernel32                    = syscall.NewLazyDLL("kernel32.dll")
+	procGetSystemTimes          = kernel32.NewProc("GetSystemTimes")
+	procGlobalMemoryStatusEx    = kernel32.NewProc("GlobalMemoryStatusEx")
+	procK32GetProcessMemoryInfo = kernel32.NewProc("K32GetProcessMemoryInfo")
+)
+
+func filetime(ft syscall.Filetime) uint64 {
+	return uint64(ft.HighDateTime)<<32 | uint64(ft.LowDateTime)
+}
+
+// cpuTimes returns idle and total CPU time of all processors in 100ns units.
+func cpuTimes() (idle, total uint64, ok bool) {
+	var idleFT, kernelFT, userFT syscall.Filetime
+	r, _, _ := procGetSystemTimes.Call(uintptr(unsafe.Pointer(&idleFT)), uintptr(unsafe.Pointer(&kernelFT)), uintptr(unsafe.Pointer(&userFT)))
+	if r == 0 {
+		return 0, 0, false
+	}
+	// Kernel time includes idle time
+	return filetime(idleFT), filetime(kernelFT) + filetime(userFT), true
+}
+
+// memoryStatusEx is MEMORYSTATUSEX.
+type memoryStatusEx struct {
+	length               uint32
+	memoryLoad           uint32
+	totalPhys            uint64
+	availPhys            uint64
+	totalPageFile        uint64
+	availPageFile        uint64
+	totalVirtual         uint64
+	availVirtual         uint64
+	availExtendedVirtual uint64
+}
+
+// systemMemory returns physical memory in use and the total, in bytes.
+func systemMemory() (used, total uint64, ok bool) {
+	ms := memoryStatusEx{length: uint32(unsafe.Sizeof(memoryStatusEx{}))}
+	r, _, _ := procGlobalMemoryStatusEx.Call(uintptr(unsafe.Pointer(&ms)))
+	if r == 0 || ms.availPhys > ms.totalPhys {
+		return 0, 0, false
+	}
+	return ms.totalPhys - ms.availPhys, ms.totalPhys, true
+}
+
+// processMemoryCounters is PROCESS_MEMORY_COUNTERS.
+type processMemoryCounters struct {
+	cb                         uint32
+	pageFaultCount             uint32
+	peakWorkingSetSize         uintptr
+	workingSetSize             uintptr
+	quotaPeakPagedPoolUsage    uintptr
+	quotaPagedPoolUsage        uintptr
+	quotaPeakNonPagedPoolUsage uintptr
+	quotaNonPagedPoolUsage     uintptr
+	pagefileUsage              uintptr
+	peakPagefileUsage          uintptr
+}
+
+// processRSS returns the process's working set in bytes, which unlike the
+// Go heap includes the encoders' and NDI SDK's native allocations.
+func processRSS() (uint64, bool) {
+	pmc := processMemoryCounters{cb: uint32(unsafe.Sizeof(processMemoryCounters{}))}
+	h, err := syscall.GetCurrentProcess()
+	if err != nil {
+		return 0, false
+	}
+	r, _, _ := procK32GetProcessMemoryInfo.Call(uintptr(h), uintptr(unsafe.Pointer(&pmc)), uintptr(pmc.cb))
+	if r == 0 {
+		return 0, false
+	}
+	return uint64(pmc.workingSetSize), true
+}
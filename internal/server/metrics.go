@@ -0,0 +1,130 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"whep/internal/stream"
+)
+
+// sharedMetricsLabel is the mount label of the legacy /whep pipeline.
+const sharedMetricsLabel = "shared"
+
+// handleMetrics renders counters and gauges in the Prometheus text
+// exposition format (version 0.0.4).
+func (s *WhepServer) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeError(w, r, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "method not allowed", nil)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	var b strings.Builder
+
+	labeled := stream.GetLabeledCounters()
+	counter := func(name, help string, val func(stream.LabeledCounter) uint64) {
+		writeMetricHeader(&b, name, "counter", help)
+		for _, c := range labeled {
+			fmt.Fprintf(&b, "%s{codec=%s,mount=%s} %d\n", name, promLabel(c.Codec), promLabel(c.Label), val(c))
+		}
+	}
+	counter("whep_frames_in_total", "Frames pulled from sources.", func(c stream.LabeledCounter) uint64 { return c.FramesIn })
+	counter("whep_frames_encoded_total", "Frames that produced encoded output.", func(c stream.LabeledCounter) uint64 { return c.FramesEncoded })
+	counter("whep_frames_buffered_total", "Frames held back by encoder lag or look-ahead, output with a later frame.", func(c stream.LabeledCounter) uint64 { return c.FramesBuffered })
+	counter("whep_frames_dropped_rc_total", "Frames discarded by encoder rate control.", func(c stream.LabeledCounter) uint64 { return c.FramesDroppedRC })
+	counter("whep_frames_dropped_input_total", "Source frames not encoded because their size didn't match the encoder or they were short.", func(c stream.LabeledCounter) uint64 { return c.FramesDroppedInput })
+	counter("whep_frames_error_total", "Frames lost to an encoder error.", func(c stream.LabeledCounter) uint64 { return c.FramesError })
+	counter("whep_frames_skipped_unchanged_total", "Frames left out of the encode because the picture hadn't changed.", func(c stream.LabeledCounter) uint64 { return c.FramesSkippedUnchanged })
+	counter("whep_frames_dropped_total", "Frames never sent: dropped by rate control or as input, or lost to an error.", func(c stream.LabeledCounter) uint64 { return c.FramesDroppedRC + c.FramesDroppedInput + c.FramesError })
+	counter("whep_samples_sent_total", "Encoded samples accepted by the track writer.", func(c stream.LabeledCounter) uint64 { return c.SamplesSent })
+
+	rt := stream.GetRuntimeStats()
+	writeMetricHeader(&b, "whep_active_pipelines", "gauge", "Running encoder pipelines by codec.")
+	for _, codec := range []string{"vp8", "vp9", "av1", "h264", "opus"} {
+		fmt.Fprintf(&b, "whep_active_pipelines{codec=%s} %d\n", promLabel(codec), rt["active_"+codec])
+	}
+	writeMetricHeader(&b, "whep_active_sources", "gauge", "Live sources (e.g., NDI receivers).")
+	fmt.Fprintf(&b, "whep_active_sources %d\n", rt["active_sources"])
+	writeMetricHeader(&b, "whep_goroutines", "gauge", "Goroutines in the process.")
+	fmt.Fprintf(&b, "whep_goroutines %d\n", rt["goroutines"])
+	writeMetricHeader(&b, "whep_ndi_reconnects_total", "counter", "NDI receivers replaced after their sender went silent and was found again by name.")
+	fmt.Fprintf(&b, "whep_ndi_reconnects_total %d\n", stream.NDIReconnects())
+	switches, failures := s.fallbacks.snapshot()
+	perMount := func(name, help string, counts map[string]uint64) {
+		writeMetricHeader(&b, name, "counter", help)
+		keys := make([]string, 0, len(counts))
+		for key := range counts {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			fmt.Fprintf(&b, "%s{mount=%s} %d\n", name, promLabel(key), counts[key])
+		}
+	}
+	perMount("whep_mount_fallback_switches_total", "Fallback mounts moved to another source of their fallback= list, or back to their own.", switches)
+	perMount("whep_mount_fallback_failures_total", "Fallback switches abandoned because the new source failed to open or sent no frame in time.", failures)
+
+	s.mu.Lock()
+	sessions := len(s.sessions)
+	mounts := make([]*ndiMount, 0, len(s.mounts))
+	for _, m := range s.mounts {
+		mounts = append(mounts, m)
+	}
+	s.mu.Unlock()
+	sort.Slice(mounts, func(i, j int) bool { return mounts[i].key < mounts[j].key })
+
+	writeMetricHeader(&b, "whep_sessions", "gauge", "Active WHEP sessions.")
+	fmt.Fprintf(&b, "whep_sessions %d\n", sessions)
+	writeMetricHeader(&b, "whep_offers_deduplicated_total", "counter", "Repeated POSTs answered from the session their first offer created.")
+	fmt.Fprintf(&b, "whep_offers_deduplicated_total %d\n", s.offers.deduped.Load())
+
+	type mountRow struct {
+		key, codec                  string
+		sessions, w, h, bitrateKbps int
+		fps                         float64
+	}
+	rows := make([]mountRow, 0, len(mounts))
+	for _, m := range mounts {
+		m.mu.Lock()
+		rows = append(rows, mountRow{m.key, m.codec, len(m.sessions), m.width, m.height, m.bitrateKbps, m.encFPS.Float()})
+		m.mu.Unlock()
+	}
+	gauge := func(name, help string, val func(mountRow) float64) {
+		writeMetricHeader(&b, name, "gauge", help)
+		for _, m := range rows {
+			fmt.Fprintf(&b, "%s{codec=%s,mount=%s} %s\n", name, promLabel(m.codec), promLabel(m.key), strconv.FormatFloat(val(m), 'g', -1, 64))
+		}
+	}
+	gauge("whep_mount_sessions", "Sessions attached to a mount.", func(m mountRow) float64 { return float64(m.sessions) })
+	gauge("whep_mount_width", "Mount output width (0 = source size).", func(m mountRow) float64 { return float64(m.w) })
+	gauge("whep_mount_height", "Mount output height (0 = source size).", func(m mountRow) float64 { return float64(m.h) })
+	gauge("whep_mount_fps", "Mount encoder frame rate.", func(m mountRow) float64 { return m.fps })
+	gauge("whep_mount_bitrate_kbps", "Mount target bitrate in kbps.", func(m mountRow) float64 { return float64(m.bitrateKbps) })
+
+	s.writeSLOMetrics(&b)
+	s.writeLoadMetrics(&b)
+
+	disk := s.disk.stats()
+	writeMetricHeader(&b, "whep_disk_free_bytes", "gauge", "Free space in directories used for recordings and dumps.")
+	for _, d := range disk {
+		fmt.Fprintf(&b, "whep_disk_free_bytes{dir=%s} %d\n", promLabel(d.Dir), d.FreeBytes)
+	}
+	writeMetricHeader(&b, "whep_disk_writers", "gauge", "Active recordings/dumps per directory.")
+	for _, d := range disk {
+		fmt.Fprintf(&b, "whep_disk_writers{dir=%s} %d\n", promLabel(d.Dir), d.Writers)
+	}
+
+	_, _ = io.WriteString(w, b.String())
+}
+
+func writeMetricHeader(b *strings.Builder, name, typ, help string) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+// promLabel quotes v as a label value, escaping backslash, quote and newline.
+func promLabel(v string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v) + `"`
+}
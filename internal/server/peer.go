@@ -0,0 +1,97 @@
+package server
+
+import (
+	"github.com/pion/interceptor"
+	"github.com/pion/interceptor/pkg/cc"
+	"github.com/pion/interceptor/pkg/gcc"
+	"github.com/pion/interceptor/pkg/stats"
+	"github.com/pion/webrtc/v3"
+)
+
+// peerHooks are per-PeerConnection interceptor handles kept on the session.
+type peerHooks struct {
+	bwe   cc.BandwidthEstimator // TWCC send-side estimate; nil when BWE is off
+	stats stats.Getter          // per-SSRC RTP/RTCP counters for /sessions
+}
+
+// newPeerConnection builds a PeerConnection with the default codecs, routing
+// its sockets through the counting net so /health and the budget see them.
+// Sender reports are enabled so viewers' receiver reports carry RTT, and the
+// stats interceptor counts sent packets and feedback. With BWE on, outgoing
+// packets carry transport-wide sequence numbers and the returned estimator
+// turns the viewer's TWCC feedback into a target bitrate.
+func (s *WhepServer) newPeerConnection() (*webrtc.PeerConnection, peerHooks, error) {
+	var hooks peerHooks
+	me := webrtc.MediaEngine{}
+	if err := me.RegisterDefaultCodecs(); err != nil {
+		return nil, hooks, err
+	}
+	ir := &interceptor.Registry{}
+	if s.cfg.BWE {
+		maxKbps := s.encoderCfg().BitrateKbps + audioBitrateKbps
+		ccf, err := cc.NewInterceptor(func() (cc.BandwidthEstimator, error) {
+			// No pacer: the encoder is retargeted instead of queuing packets
+			return gcc.NewSendSideBWE(
+				gcc.SendSideBWEInitialBitrate(maxKbps*1000),
+				gcc.SendSideBWEMaxBitrate(maxKbps*1000),
+				gcc.SendSideBWEMinBitrate(min(s.cfg.MinBitrateKbps, maxKbps)*1000),
+				gcc.SendSideBWEPacer(gcc.NewNoOpPacer()),
+			)
+		})
+		if err != nil {
+			return nil, hooks, err
+		}
+		// Called synchronously while the PeerConnection is built
+		ccf.OnNewPeerConnection(func(_ string, e cc.BandwidthEstimator) { hooks.bwe = e })
+		ir.Add(ccf)
+		if err := webrtc.ConfigureTWCCHeaderExtensionSender(&me, ir); err != nil {
+			return nil, hooks, err
+		}
+	}
+	if err := webrtc.ConfigureRTCPReports(ir); err != nil {
+		return nil, hooks, err
+	}
+	sf, err := stats.NewInterceptor()
+	if err != nil {
+		return nil, hooks, err
+	}
+	sf.OnNewPeerConnection(func(_ string, g stats.Getter) { hooks.stats = g })
+	ir.Add(sf)
+	se := webrtc.SettingEngine{}
+	if s.net != nil {
+		se.SetNet(s.net)
+	}
+	api := webrtc.NewAPI(webrtc.WithMediaEngine(&me), webrtc.WithSettingEngine(se), webrtc.WithInterceptorRegistry(ir))
+	pc, err := api.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		return nil, hooks, err
+	}
+	return pc, hooks, nil
+}
+
+// newIngestPeerConnection builds a PeerConnection for a WHIP publisher,
+// counted like the viewers' ones. It is the receiving side: NACKs ask the
+// publisher to resend lost packets, receiver reports and TWCC feedback let
+// it pace itself.
+func (s *WhepServer) newIngestPeerConnection() (*webrtc.PeerConnection, error) {
+	me := webrtc.MediaEngine{}
+	if err := me.RegisterDefaultCodecs(); err != nil {
+		return nil, err
+	}
+	ir := &interceptor.Registry{}
+	if err := webrtc.ConfigureNack(&me, ir); err != nil {
+		return nil, err
+	}
+	if err := webrtc.ConfigureRTCPReports(ir); err != nil {
+		return nil, err
+	}
+	if err := webrtc.ConfigureTWCCSender(&me, ir); err != nil {
+		return nil, err
+	}
+	se := webrtc.SettingEngine{}
+	if s.net != nil {
+		se.SetNet(s.net)
+	}
+	api := webrtc.NewAPI(webrtc.WithMediaEngine(&me), webrtc.WithSettingEngine(se), webrtc.WithInterceptorRegistry(ir))
+	return api.NewPeerConnection(webrtc.Configuration{})
+}
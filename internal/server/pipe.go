@@ -0,0 +1,78 @@
+package server
+
+import (
+	"errors"
+	"log"
+
+	"whep/internal/stream"
+)
+
+// The pipe source. With -stdin-source the server reads raw frames another
+// process writes (see stream.PipeSource) and lists them as the "pipe"
+// source, mounted at /whep/ndi/pipe like any other. There is one reader
+// for the server's lifetime: stdin can only be read once, and the feed
+// keeps flowing with nobody watching. Mounts, /frame and the shared
+// pipeline each get a handle on it; a mount asking for a size scales its
+// handle's frames.
+
+const pipeScheme = "pipe"
+
+var errPipeOff = errors.New("no pipe source (-stdin-source)")
+
+var pipeListing = struct{ Name, URL string }{Name: "Pipe", URL: "pipe://"}
+
+func init() {
+	registerSourceFactory(&sourceFactory{
+		scheme:  pipeScheme,
+		aliases: []string{"pipe"},
+		open: func(s *WhepServer, name, url string, w, h int, fps stream.Rate) (stream.Source, error) {
+			if s.pipe == nil {
+				return nil, errPipeOff
+			}
+			sh, ok := s.pipe.Share()
+			if !ok {
+				return nil, errPipeOff
+			}
+			if w > 0 && h > 0 {
+				return stream.NewRegionSource(sh, stream.Region{}, w, h, ""), nil
+			}
+			return sh, nil
+		},
+	})
+}
+
+// startPipeSource starts reading the configured pipe, if any. A listener
+// that can't be opened leaves the pipe source off.
+func (s *WhepServer) startPipeSource() {
+	if s.cfg.Pipe == nil {
+		return
+	}
+	input := s.cfg.PipeInput
+	if input == "" {
+		input = "-"
+	}
+	src, err := stream.NewPipeSource(*s.cfg.Pipe, input)
+	if err != nil {
+		log.Printf("Pipe source disabled: %v", err)
+		return
+	}
+	s.pipeSrc, s.pipe = src, stream.NewSharedSource(src)
+	log.Printf("Pipe source: %s frames from %s", s.cfg.Pipe, input)
+}
+
+// pipeFormat is the configured pipe format for /config; "" when off.
+func (s *WhepServer) pipeFormat() string {
+	if s.cfg.Pipe == nil {
+		return ""
+	}
+	return s.cfg.Pipe.String()
+}
+
+// pipeStatus is the pipe source in /health; nil when off.
+func (s *WhepServer) pipeStatus() *stream.PipeStatus {
+	if s.pipeSrc == nil {
+		return nil
+	}
+	st := s.pipeSrc.Status()
+	return &st
+}
@@ -0,0 +1,513 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+
+	"whep/internal/ndi"
+	"whep/internal/stream"
+)
+
+// videoCodec returns the codec new pipelines should use: H.264 while a
+// hardware encoder is active, otherwise the configured software codec.
+func (s *WhepServer) videoCodec() string {
+	if hw := stream.GetHWAccelStatus(); hw.Active && hw.Codec != "" {
+		return hw.Codec
+	}
+	return s.softwareCodec()
+}
+
+// sharedOpenSize is the size the shared pipeline's sources are opened at:
+// the configured size, turned back by -rotate so it comes out right.
+func (s *WhepServer) sharedOpenSize() (w, h int) {
+	return stream.Orientation{Rotate: s.cfg.Rotate}.Size(s.cfg.Width, s.cfg.Height)
+}
+
+// orientShared turns the shared pipeline's source by -rotate, following
+// its size; synthetic sources are left upright.
+func (s *WhepServer) orientShared(src stream.Source) stream.Source {
+	o := stream.Orientation{Rotate: s.cfg.Rotate}
+	if src == nil || o.IsZero() || stream.IsSynthetic(src) {
+		return src
+	}
+	return orientedRegion(src, stream.Region{}, 0, 0, "", o)
+}
+
+// softwareCodec is the configured libvpx/AV1 codec used when no hardware
+// encoder is available.
+func (s *WhepServer) softwareCodec() string {
+	switch c := strings.ToLower(s.cfg.Codec); c {
+	case "vp9", "av1":
+		return c
+	default:
+		return "vp8"
+	}
+}
+
+// videoPipeline is a running encoder pipeline.
+type videoPipeline interface {
+	Stop()
+	// ForceKeyframe makes the next encoded frame a keyframe (PLI/FIR)
+	ForceKeyframe()
+	// SetBitrate retargets the encoder in kbps (congestion control);
+	// Bitrate reports the target in effect
+	SetBitrate(kbps int)
+	Bitrate() int
+}
+
+// pipelineFinishTimeout bounds how long a mount's teardown waits for an
+// encoder to write out its delayed frames.
+const pipelineFinishTimeout = 2 * time.Second
+
+// finishPipeline ends p's stream with the encoder's delayed frames written
+// to its track, so a recording keeps its last frames; a pipeline that can't
+// is just stopped. Stop, by contrast, is for restarts, where a new pipeline
+// takes over the track.
+func finishPipeline(p videoPipeline, label string) {
+	if p == nil {
+		return
+	}
+	f, ok := p.(interface{ Finish(time.Duration) bool })
+	if !ok {
+		p.Stop()
+		return
+	}
+	if !f.Finish(pipelineFinishTimeout) {
+		log.Printf("Pipeline(%s): encoder still flushing after %s", label, pipelineFinishTimeout)
+	}
+}
+
+// pipelineStarters starts each codec's encoder pipeline; any codec not
+// listed gets VP8.
+var pipelineStarters = map[string]func(stream.PipelineConfig) (videoPipeline, error){
+	"h264": func(pc stream.PipelineConfig) (videoPipeline, error) { return started(stream.StartH264Pipeline(pc)) },
+	"av1":  func(pc stream.PipelineConfig) (videoPipeline, error) { return started(stream.StartAV1Pipeline(pc)) },
+	"vp9":  func(pc stream.PipelineConfig) (videoPipeline, error) { return started(stream.StartVP9Pipeline(pc)) },
+	"vp8":  func(pc stream.PipelineConfig) (videoPipeline, error) { return started(stream.StartVP8Pipeline(pc)) },
+}
+
+// started keeps a failed start's typed nil out of the interface.
+func started[P videoPipeline](p P, err error) (videoPipeline, error) {
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// startPipeline starts the encoder pipeline for codec.
+func startPipeline(codec string, pc stream.PipelineConfig) (videoPipeline, error) {
+	start, ok := pipelineStarters[codec]
+	if !ok {
+		start = pipelineStarters["vp8"]
+	}
+	return start(pc)
+}
+
+// startPipelineWithFallback starts codec's pipeline; if the hardware encoder
+// can't be opened it is marked failed (visible in /health) and the software
+// codec is started instead. It returns the codec actually in use.
+func (s *WhepServer) startPipelineWithFallback(codec string, pc stream.PipelineConfig) (videoPipeline, string, error) {
+	p, err := startPipeline(codec, pc)
+	if err == nil || codec != "h264" {
+		return p, codec, err
+	}
+	log.Printf("Hardware encoder unavailable, falling back to software: %v", err)
+	stream.HWAccelFailed(err)
+	codec = s.softwareCodec()
+	p, err = startPipeline(codec, pc)
+	return p, codec, err
+}
+
+// resizer is a pipeline whose encoder can take a new frame size in place.
+type resizer interface {
+	Resize(w, h int) error
+}
+
+// resizePipeline moves p to pc's size after the source changed resolution:
+// in place when the encoder can be reconfigured (one keyframe, no gap),
+// else by stopping it and starting codec's pipeline anew. It returns the
+// pipeline now running; on error p has been stopped.
+func resizePipeline(p videoPipeline, codec string, pc stream.PipelineConfig, label string) (videoPipeline, error) {
+	if rp, ok := p.(resizer); ok {
+		err := rp.Resize(pc.Width, pc.Height)
+		if err == nil {
+			log.Printf("Pipeline(%s): encoder reconfigured to %dx%d", label, pc.Width, pc.Height)
+			return p, nil
+		}
+		log.Printf("Pipeline(%s): %v, restarting encoder", label, err)
+	}
+	if p != nil {
+		p.Stop()
+	}
+	return startPipeline(codec, pc)
+}
+
+// watchSourceSize calls resize whenever src announces a frame size other
+// than w x h, until ctx is done or src stops. A source whose first frames
+// arrive after the pipeline started at the configured size is caught the
+// same way. resize reports whether the new size was applied; if not it is
+// retried every second.
+func watchSourceSize(ctx context.Context, src stream.Source, w, h int, label string, resize func(w, h int) bool) {
+	sizes, ok := stream.SizeChanges(src)
+	if !ok {
+		return
+	}
+	go func() {
+		var want stream.FrameSize
+		var retry <-chan time.Time
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case fs, ok := <-sizes:
+				if !ok {
+					return
+				}
+				want = fs
+			case <-retry:
+			}
+			retry = nil
+			if want.W <= 0 || want.H <= 0 || (want.W == w && want.H == h) {
+				continue
+			}
+			log.Printf("Pipeline(%s): source resolution change %dx%d -> %dx%d", label, w, h, want.W, want.H)
+			if resize(want.W, want.H) {
+				w, h = want.W, want.H
+			} else {
+				retry = time.After(time.Second)
+			}
+		}
+	}()
+}
+
+// trackCapability returns the RTP capability for a video track carrying codec.
+func trackCapability(codec string) webrtc.RTPCodecCapability {
+	switch codec {
+	case "h264":
+		return webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeH264, ClockRate: 90000, SDPFmtpLine: "level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=42e01f"}
+	case "vp9":
+		return webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP9}
+	case "av1":
+		return webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeAV1}
+	default:
+		return webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8}
+	}
+}
+
+// codecSet is the set of video codecs ("vp8", "vp9", "av1", "h264") a
+// client's offer can receive.
+type codecSet map[string]bool
+
+// offerCodecs collects the video codecs listed in the offer's rtpmap lines.
+func offerCodecs(sdp string) codecSet {
+	out := codecSet{}
+	video := false
+	for _, line := range strings.Split(sdp, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "m=") {
+			video = strings.HasPrefix(line, "m=video")
+			continue
+		}
+		if !video || !strings.HasPrefix(line, "a=rtpmap:") {
+			continue
+		}
+		// a=rtpmap:<pt> <encoding>/<clock>[/<channels>]
+		f := strings.Fields(line)
+		if len(f) < 2 {
+			continue
+		}
+		name, _, _ := strings.Cut(f[1], "/")
+		switch c := strings.ToLower(name); c {
+		case "vp8", "vp9", "av1", "h264":
+			out[c] = true
+		}
+	}
+	return out
+}
+
+// codecPreference lists the codecs the server can encode, best first: the
+// active codec (hardware H.264 or -codec), then the software codecs.
+func (s *WhepServer) codecPreference() []string {
+	prefs := []string{s.videoCodec()}
+	for _, c := range []string{s.softwareCodec(), "vp8", "vp9", "av1"} {
+		if !containsString(prefs, c) {
+			prefs = append(prefs, c)
+		}
+	}
+	return prefs
+}
+
+// pick returns the first codec in prefs the offer supports.
+func (c codecSet) pick(prefs []string) (string, bool) {
+	for _, p := range prefs {
+		if c[p] {
+			return p, true
+		}
+	}
+	return "", false
+}
+
+// list returns the offered codecs in a stable order.
+func (c codecSet) list() []string {
+	got := make([]string, 0, len(c))
+	for _, name := range []string{"h264", "vp8", "vp9", "av1"} {
+		if c[name] {
+			got = append(got, name)
+		}
+	}
+	return got
+}
+
+// noCodecError is the 406 body when an offer has none of the server's codecs.
+func noCodecError(offered codecSet, prefs []string) string {
+	got := offered.list()
+	if len(got) == 0 {
+		got = append(got, "none")
+	}
+	return fmt.Sprintf("no supported video codec in offer (offer has: %s; server can send: %s)", strings.Join(got, ", "), strings.Join(prefs, ", "))
+}
+
+// noCodecDetails is the error envelope's details for a 406.
+func noCodecDetails(offered codecSet, prefs []string) map[string][]string {
+	return map[string][]string{"offered": offered.list(), "supported": prefs}
+}
+
+func containsString(list []string, v string) bool {
+	for _, s := range list {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+// encoderSettings are the encoder settings POST /config changes at run
+// time, as one snapshot.
+type encoderSettings struct {
+	FPS          stream.Rate // invalid for the source's rate
+	BitrateKbps  int
+	VP8Speed     int
+	VP8Dropframe int
+}
+
+// encoderCfg snapshots the runtime encoder settings. It may be called
+// holding any other lock.
+func (s *WhepServer) encoderCfg() encoderSettings {
+	s.cfgMu.RLock()
+	defer s.cfgMu.RUnlock()
+	return encoderSettings{FPS: s.cfg.FPS, BitrateKbps: s.cfg.BitrateKbps, VP8Speed: s.cfg.VP8Speed, VP8Dropframe: s.cfg.VP8Dropframe}
+}
+
+// fps is the frame rate pipelines without a rate of their own use.
+func (e encoderSettings) fps() stream.Rate { return e.FPS.Or(stream.IntRate(30)) }
+
+// fps is the configured frame rate, defaulting to 30.
+func (s *WhepServer) fps() stream.Rate { return s.encoderCfg().fps() }
+
+// fpsFromSource reports whether pipelines without an fps of their own
+// encode at their source's rate (-fps unset).
+func (s *WhepServer) fpsFromSource() bool { return !s.encoderCfg().FPS.Valid() }
+
+// fpsValue shows an -fps setting, "source" when unset.
+func fpsValue(r stream.Rate) string {
+	if !r.Valid() {
+		return "source"
+	}
+	return r.String()
+}
+
+// fpsStats reports the rate each running encoder uses, for /health.
+func (s *WhepServer) fpsStats() map[string]any {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	mounts := make(map[string]stream.Rate, len(s.mounts))
+	for key, m := range s.mounts {
+		m.mu.Lock()
+		if m.encFPS.Valid() {
+			mounts[key] = m.encFPS
+		}
+		m.mu.Unlock()
+	}
+	out := map[string]any{"default": fpsValue(s.cfg.FPS), "mounts": mounts}
+	if s.shareBC != nil && s.shareFPS.Valid() {
+		out["shared"] = s.shareFPS
+	}
+	return out
+}
+
+// vp9Tuning is the server's VP9 tuning.
+func (s *WhepServer) vp9Tuning() stream.VP9Tuning {
+	if s.cfg.VP9 == nil {
+		return stream.DefaultVP9Tuning()
+	}
+	return *s.cfg.VP9
+}
+
+// av1Tuning is the server's AV1 tuning.
+func (s *WhepServer) av1Tuning() stream.AV1Tuning {
+	if s.cfg.AV1 == nil {
+		return stream.DefaultAV1Tuning()
+	}
+	return *s.cfg.AV1
+}
+
+// tilesValue is a VP9 or AV1 tile columns setting as /config shows it.
+func tilesValue(n int) string {
+	if n == stream.VP9TilesAuto || n == stream.AV1TilesAuto {
+		return "auto"
+	}
+	return strconv.Itoa(n)
+}
+
+// av1PresetValue is an AV1 preset as /config shows it.
+func av1PresetValue(n int) string {
+	if n == stream.AV1PresetAuto {
+		return "auto"
+	}
+	return strconv.Itoa(n)
+}
+
+// rateControl is the server's rate control mode.
+func (s *WhepServer) rateControl() string {
+	if s.cfg.RateControl == "" {
+		return stream.RateCBR
+	}
+	return s.cfg.RateControl
+}
+
+// crfValue is a CQ quality setting with 0 spelled out as the default.
+func crfValue(n int) int {
+	if n <= 0 {
+		return stream.DefaultCRF
+	}
+	return n
+}
+
+// rateControlMode is how an encoder controls its rate, for /health: the
+// CRF is only there in CQ.
+type rateControlMode struct {
+	Mode string `json:"mode"`
+	CRF  int    `json:"crf,omitempty"`
+}
+
+// effectiveRateControl is the mode codec's encoder runs in for rc and crf;
+// H.264 has no CQ and runs CBR.
+func effectiveRateControl(codec, rc string, crf int) rateControlMode {
+	if mode := stream.EffectiveRateControl(codec, rc); mode == stream.RateCQ {
+		return rateControlMode{Mode: mode, CRF: crfValue(crf)}
+	}
+	return rateControlMode{Mode: stream.RateCBR}
+}
+
+// rateControlStats reports the mode each running encoder uses, for /health.
+func (s *WhepServer) rateControlStats() map[string]any {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	mounts := make(map[string]rateControlMode, len(s.mounts))
+	for key, m := range s.mounts {
+		m.mu.Lock()
+		if m.encFPS.Valid() {
+			mounts[key] = effectiveRateControl(m.codec, m.rc, m.crf)
+		}
+		m.mu.Unlock()
+	}
+	def := rateControlMode{Mode: s.rateControl()}
+	if def.Mode == stream.RateCQ {
+		def.CRF = crfValue(s.cfg.CRF)
+	}
+	out := map[string]any{"default": def, "mounts": mounts}
+	if s.shareBC != nil && s.shareCodec != "" {
+		out["shared"] = effectiveRateControl(s.shareCodec, s.cfg.RateControl, s.cfg.CRF)
+	}
+	return out
+}
+
+// keyintValue is a keyframe interval setting as /config shows it: frames,
+// or "auto" for the codec's default.
+func keyintValue(n int) string {
+	if n <= 0 {
+		return "auto"
+	}
+	return strconv.Itoa(n)
+}
+
+// keyintStats reports the keyframe interval, in frames, each running
+// encoder uses, for /health; 0 where the encoder picks its own.
+func (s *WhepServer) keyintStats() map[string]any {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	mounts := make(map[string]int, len(s.mounts))
+	for key, m := range s.mounts {
+		m.mu.Lock()
+		if m.encFPS.Valid() {
+			mounts[key] = stream.EffectiveKeyint(m.codec, m.keyint, m.encFPS)
+		}
+		m.mu.Unlock()
+	}
+	out := map[string]any{"default": keyintValue(s.cfg.Keyint), "mounts": mounts}
+	if s.shareBC != nil && s.shareFPS.Valid() {
+		out["shared"] = stream.EffectiveKeyint(s.shareCodec, s.cfg.Keyint, s.shareFPS)
+	}
+	return out
+}
+
+// stallStats reports, for /health, which running encoders' sources have
+// stalled and are serving the signal-lost slate.
+func (s *WhepServer) stallStats() map[string]any {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	mounts := make(map[string]bool, len(s.mounts))
+	for key, m := range s.mounts {
+		m.mu.Lock()
+		if m.pipe != nil {
+			mounts[key] = stream.Stalled(m.src)
+		}
+		m.mu.Unlock()
+	}
+	out := map[string]any{"after_ms": stream.SourceStallAfter().Milliseconds(), "mounts": mounts}
+	if s.shareBC != nil {
+		out["shared"] = stream.Stalled(s.shareSrc)
+	}
+	return out
+}
+
+// bandwidthStats reports, for /health, the bandwidth each running
+// encoder's NDI source receives at; encoders on other sources aren't
+// listed.
+func (s *WhepServer) bandwidthStats() map[string]any {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	mounts := make(map[string]string, len(s.mounts))
+	for key, m := range s.mounts {
+		m.mu.Lock()
+		if bw, ok := stream.NDIBandwidth(m.src); ok && m.pipe != nil {
+			mounts[key] = bw
+		}
+		m.mu.Unlock()
+	}
+	out := map[string]any{"default": ndi.DefaultBandwidth(), "mounts": mounts}
+	if bw, ok := stream.NDIBandwidth(s.shareSrc); ok && s.shareBC != nil {
+		out["shared"] = bw
+	}
+	return out
+}
+
+// pipelineFPS is the rate to encode src at: want if set, else src's own
+// rate once its first frame announces it, else the default.
+func (s *WhepServer) pipelineFPS(want stream.Rate, src stream.Source) stream.Rate {
+	if want.Valid() {
+		return want
+	}
+	if src != nil {
+		if r, ok := stream.SourceRate(src, time.Second); ok {
+			return r
+		}
+	}
+	return s.fps()
+}
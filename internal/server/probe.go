@@ -0,0 +1,249 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"whep/internal/ndi"
+	"whep/internal/stream"
+)
+
+// Probe limits. Each probe holds an NDI receiver (and its connections) for
+// up to its timeout; how many run at once is up to the ndi scheduler.
+const (
+	defaultProbeTimeout = 5 * time.Second
+	maxProbeTimeout     = 30 * time.Second
+)
+
+// probeResult is the body of POST /ndi/probe, and the details of its errors.
+type probeResult struct {
+	Name   string      `json:"name"`
+	URL    string      `json:"url"`
+	Width  int         `json:"width,omitempty"`
+	Height int         `json:"height,omitempty"`
+	FPS    stream.Rate `json:"fps"`
+	FourCC string      `json:"fourcc,omitempty"`
+	// TimeToFirstFrameMs is measured from receiver creation
+	TimeToFirstFrameMs int64 `json:"timeToFirstFrameMs"`
+	// Bandwidth is "lowest" (sender's preview stream, so the size is the
+	// preview's) or "highest"
+	Bandwidth string `json:"bandwidth,omitempty"`
+	// Mount is set when the answer came from a live mount instead of a probe
+	Mount string `json:"mount,omitempty"`
+	// Phase names the step that failed: "connect" or "no_frames"
+	Phase     string `json:"phase,omitempty"`
+	Connected bool   `json:"connected"`
+}
+
+// POST /ndi/probe { "source": "name or key", "url": "ndi://...", "timeoutMs": 5000, "bandwidth": "lowest" }
+// checks that a source can actually be received by waiting for one frame.
+func (s *WhepServer) handleNDIProbe(w http.ResponseWriter, r *http.Request) {
+	allowCORS(w, r)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Method != http.MethodPost {
+		writeError(w, r, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "method not allowed", nil)
+		return
+	}
+	if s.refuseIfDraining(w, r) {
+		return
+	}
+	var body struct {
+		Source    string `json:"source"`
+		URL       string `json:"url"`
+		TimeoutMs int    `json:"timeoutMs"`
+		Bandwidth string `json:"bandwidth"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || (body.Source == "" && body.URL == "") {
+		writeError(w, r, http.StatusBadRequest, errCodeBadRequest, "invalid JSON or missing 'source'/'url'", nil)
+		return
+	}
+	timeout := defaultProbeTimeout
+	if body.TimeoutMs > 0 {
+		timeout = time.Duration(body.TimeoutMs) * time.Millisecond
+		if timeout > maxProbeTimeout {
+			timeout = maxProbeTimeout
+		}
+	}
+	var highest bool
+	switch strings.ToLower(body.Bandwidth) {
+	case "", "lowest":
+	case "highest":
+		highest = true
+	default:
+		writeError(w, r, http.StatusBadRequest, errCodeBadRequest, "bandwidth must be lowest or highest", nil)
+		return
+	}
+
+	name, url := body.Source, body.URL
+	if url == "" {
+		si, ok := s.resolveSource(body.Source)
+		if !ok {
+			writeError(w, r, http.StatusNotFound, errCodeSourceNotFound, "source not found: "+body.Source, map[string]string{"source": body.Source})
+			return
+		}
+		name, url = si.Name, si.URL
+	}
+	res := probeResult{Name: name, URL: url}
+
+	// Built-in sources always deliver frames
+	if f := lookupSourceFactory(name, url); f != ndiFactory {
+		res.Width, res.Height = s.syntheticSize(0, 0)
+		res.FPS, res.FourCC, res.Connected = s.fps(), "BGRA", true
+		writeProbeResult(w, res)
+		return
+	}
+	// A live mount already proves the source is receivable
+	if s.probeFromMount(&res) {
+		writeProbeResult(w, res)
+		return
+	}
+
+	if !ndi.Initialize() {
+		writeError(w, r, http.StatusServiceUnavailable, errCodeSourceUnavailable, stream.ErrNDIUnavailable.Error(), ndi.SDK())
+		return
+	}
+	// Wait for a turn, up to the probe's own timeout
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	release, err := ndi.AcquireTemp(ctx, sourceID(name, url), s.sourceWanted(name, url))
+	cancel()
+	if err != nil {
+		writeError(w, r, http.StatusServiceUnavailable, errCodeLimitExceeded, "too many probes and frame grabs in progress", ndi.GetTempStats())
+		return
+	}
+	defer release()
+	res.Bandwidth = ndi.BandwidthLowest
+	if highest {
+		res.Bandwidth = ndi.BandwidthHighest
+	}
+	if err := probeReceive(&res, timeout); err != nil {
+		res.Phase = "connect"
+		writeError(w, r, http.StatusServiceUnavailable, errCodeSourceUnavailable, err.Error(), res)
+		return
+	}
+	if res.Width > 0 {
+		if highest {
+			s.sourceMetas.note(sourceID(name, url), sourceMeta{Width: res.Width, Height: res.Height, FPS: res.FPS, At: time.Now()})
+		}
+		writeProbeResult(w, res)
+		return
+	}
+	if res.Connected {
+		res.Phase = "no_frames"
+		writeError(w, r, http.StatusGatewayTimeout, errCodeSourceUnavailable, "connected but no video frame within timeout", res)
+		return
+	}
+	res.Phase = "connect"
+	writeError(w, r, http.StatusGatewayTimeout, errCodeSourceUnavailable, "could not connect to source within timeout", res)
+}
+
+// probeReceive opens a receiver on res's source at res.Bandwidth and waits
+// up to timeout for a video frame, filling in res. It fails only when the
+// receiver can't be created; without a frame, res.Width stays 0.
+func probeReceive(res *probeResult, timeout time.Duration) error {
+	target := res.URL
+	if target == "" {
+		target = res.Name
+	}
+	start := time.Now()
+	rx, err := ndi.NewReceiverByURL(target, res.Bandwidth)
+	if err != nil || rx == nil {
+		if err == nil {
+			err = errors.New("could not create NDI receiver")
+		}
+		return err
+	}
+	defer rx.Close()
+	deadline := start.Add(timeout)
+	for time.Now().Before(deadline) {
+		vf, _, err := rx.Capture(100)
+		if !res.Connected && rx.Connections() > 0 {
+			res.Connected = true
+		}
+		if err != nil || vf == nil || vf.W <= 0 || vf.H <= 0 {
+			continue
+		}
+		res.Connected = true
+		res.Width, res.Height = vf.W, vf.H
+		res.FPS = stream.Rate{Num: vf.FrameRateN, Den: vf.FrameRateD}
+		res.FourCC = ndi.FourCCString(vf.FourCC)
+		res.TimeToFirstFrameMs = time.Since(start).Milliseconds()
+		return nil
+	}
+	res.TimeToFirstFrameMs = -1
+	return nil
+}
+
+// resolveSource matches a mount key, exact name/URL or name substring
+// against the known sources.
+func (s *WhepServer) resolveSource(q string) (struct{ Name, URL string }, bool) {
+	if si, ok := s.sourceIndex()[sourceKeyAlias(q)]; ok {
+		return si, true
+	}
+	srcs := streamNDISources()
+	for _, si := range srcs {
+		if strings.EqualFold(si.Name, q) || strings.EqualFold(si.URL, q) {
+			return si, true
+		}
+	}
+	low := strings.ToLower(q)
+	for _, si := range srcs {
+		if strings.Contains(strings.ToLower(si.Name), low) {
+			return si, true
+		}
+	}
+	return struct{ Name, URL string }{}, false
+}
+
+// probeFromMount fills res from a running native-size mount of the same
+// source, if any has delivered a frame.
+func (s *WhepServer) probeFromMount(res *probeResult) bool {
+	s.mu.Lock()
+	mounts := make([]*ndiMount, 0, len(s.mounts))
+	for _, m := range s.mounts {
+		if m.width > 0 || m.height > 0 || !m.crop.Empty() || !m.orient.IsZero() {
+			continue // scaled, cropped or turned; not the native size
+		}
+		if (res.URL != "" && m.url == res.URL) || (res.URL == "" && m.name == res.Name) {
+			mounts = append(mounts, m)
+		}
+	}
+	s.mu.Unlock()
+	for _, m := range mounts {
+		m.mu.Lock()
+		src, fps, key := m.src, m.encFPS, m.key
+		m.mu.Unlock()
+		reporter, ok := src.(interface {
+			Last() ([]byte, int, int, bool)
+		})
+		if !ok || stream.IsSynthetic(src) {
+			continue
+		}
+		_, w, h, ok := reporter.Last()
+		if !ok || w <= 0 || h <= 0 {
+			continue
+		}
+		if r, ok := stream.SourceRate(src, 0); ok {
+			fps = r
+		}
+		res.Width, res.Height, res.FPS, res.Mount, res.Connected = w, h, fps.Or(s.fps()), key, true
+		if pf, ok := src.(interface{ PixFmt() string }); ok && pf.PixFmt() == "uyvy422" {
+			res.FourCC = "UYVY"
+		} else {
+			res.FourCC = "BGRA"
+		}
+		return true
+	}
+	return false
+}
+
+func writeProbeResult(w http.ResponseWriter, res probeResult) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(res)
+}
@@ -0,0 +1,335 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"whep/internal/stream"
+)
+
+// Recording. POST /mounts/{key}/record/start writes what a mount's viewers
+// get, the encoded samples of its broadcaster, to IVF files under the
+// recording directory until POST /mounts/{key}/record/stop, the mount
+// closing or the disk running low. The recorder is a blocking sink, so it
+// sees every frame or fails, and holds the mount like a session does, so a
+// mount without viewers keeps running while it records.
+
+// recordRef is the recorder's entry in its mount's sessions.
+const recordRef = "record"
+
+// recordHistory is how many finished recordings /health lists.
+const recordHistory = 10
+
+var (
+	errRecordNoMount = errors.New("mount not found or not running")
+	errRecordBusy    = errors.New("mount is already recording")
+	errRecordPath    = errors.New("path must be relative to the recording directory")
+	errRecordCodec   = errors.New("codec can't be recorded")
+)
+
+// mountRecording is one recording of a mount.
+type mountRecording struct {
+	mount   string
+	codec   string
+	started time.Time
+	ivf     *stream.IVFRecorder
+	remove  func() // detaches the broadcaster sink
+	done    func() // leaves the disk guard
+	once    sync.Once
+	stat    recordingStat       // set by finish
+	kept    func(recordingStat) // given the finished recording
+}
+
+// recordingStat is a recording in /health and the record API.
+type recordingStat struct {
+	Mount   string     `json:"mount"`
+	Codec   string     `json:"codec"`
+	Started time.Time  `json:"started"`
+	Stopped *time.Time `json:"stopped,omitempty"`
+	Reason  string     `json:"reason,omitempty"` // why it stopped
+	stream.IVFStats
+}
+
+func (r *mountRecording) snapshot() recordingStat {
+	return recordingStat{Mount: r.mount, Codec: r.codec, Started: r.started.UTC(), IVFStats: r.ivf.Stats()}
+}
+
+// finish detaches the recorder and closes its file, once. It doesn't touch
+// the mount, so it may run under m.mu.
+func (r *mountRecording) finish(reason string) recordingStat {
+	r.once.Do(func() {
+		r.remove()
+		err := r.ivf.Close()
+		r.done()
+		r.stat = r.snapshot()
+		stopped := time.Now().UTC()
+		r.stat.Stopped, r.stat.Reason = &stopped, reason
+		if err != nil {
+			r.stat.Reason = fmt.Sprintf("%s; closing: %v", reason, err)
+		}
+		log.Printf("Recording of mount %s stopped (%s): %d frames, %d bytes in %d file(s), last %s", r.mount, r.stat.Reason, r.stat.Frames, r.stat.Bytes, r.stat.Files, r.stat.Path)
+		if r.kept != nil {
+			r.kept(r.stat)
+		}
+	})
+	return r.stat
+}
+
+// recordDir is where recordings are written.
+func (s *WhepServer) recordDir() string {
+	if s.cfg.RecordDir != "" {
+		return s.cfg.RecordDir
+	}
+	return filepath.Join(s.stateDir(), "recordings")
+}
+
+// recordPath resolves a requested path, or a name made from the mount key
+// and the time for "", inside the recording directory. IVF is added when
+// there is no extension.
+func (s *WhepServer) recordPath(key, p string) (string, error) {
+	if p == "" {
+		p = fmt.Sprintf("%s-%s", slugKey(key, ""), time.Now().UTC().Format("20060102T150405Z"))
+	}
+	if !filepath.IsLocal(p) {
+		return "", errRecordPath
+	}
+	if filepath.Ext(p) == "" {
+		p += ".ivf"
+	}
+	return filepath.Join(s.recordDir(), p), nil
+}
+
+// startRecording starts recording mount key to path (see recordPath),
+// starting a new file at a keyframe every maxMB (0 for the server's
+// -record-max-mb, < 0 for never).
+func (s *WhepServer) startRecording(key, path string, maxMB int) (recordingStat, error) {
+	s.mu.Lock()
+	m := s.mounts[key]
+	if m != nil {
+		// Claimed under s.mu, as ensureMount does, so an idle teardown
+		// can't close the mount before the recording holds it
+		m.mu.Lock()
+		m.pending++
+		m.mu.Unlock()
+	}
+	s.mu.Unlock()
+	if m == nil {
+		return recordingStat{}, errRecordNoMount
+	}
+	defer m.release(func() { s.teardownMountIfIdle(key) })
+	<-m.ready
+	if m.startErr != nil {
+		return recordingStat{}, errRecordNoMount
+	}
+	m.mu.Lock()
+	bc, codec, w, h, busy := m.bc, m.codec, m.width, m.height, m.rec != nil
+	m.mu.Unlock()
+	switch {
+	case bc == nil:
+		return recordingStat{}, errRecordNoMount
+	case busy:
+		return recordingStat{}, errRecordBusy
+	case !stream.IVFCodec(codec):
+		return recordingStat{}, fmt.Errorf("%w: %s (vp8, vp9 or av1 only)", errRecordCodec, codec)
+	}
+	full, err := s.recordPath(key, path)
+	if err != nil {
+		return recordingStat{}, err
+	}
+	dir := filepath.Dir(full)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return recordingStat{}, err
+	}
+	if maxMB == 0 {
+		maxMB = s.cfg.RecordMaxMB
+	}
+	done, err := s.disk.start(dir, func() { s.stopRecording(m, "disk space low") })
+	if err != nil {
+		return recordingStat{}, err
+	}
+	forceKeyframe := func() {
+		m.mu.Lock()
+		p := m.pipe
+		m.mu.Unlock()
+		if p != nil {
+			p.ForceKeyframe()
+		}
+	}
+	ivf, err := stream.NewIVFRecorder(full, codec, stream.IVFOptions{Width: w, Height: h, MaxBytes: int64(max(maxMB, 0)) << 20, RequestKeyframe: forceKeyframe})
+	if err != nil {
+		done()
+		return recordingStat{}, err
+	}
+	rec := &mountRecording{mount: key, codec: codec, started: time.Now(), ivf: ivf, done: done}
+	rec.remove, err = bc.Add(ivf, append(stream.RecorderSinkOptions(func(err error) { s.stopRecording(m, err.Error()) }), stream.WithLabel(recordRef))...)
+	if err == nil {
+		m.mu.Lock()
+		if m.rec != nil {
+			err = errRecordBusy
+		} else if m.bc != bc {
+			err = errRecordNoMount
+		} else {
+			rec.kept = s.keepRecording
+			m.rec = rec
+		}
+		m.mu.Unlock()
+	}
+	if err != nil {
+		rec.finish("not started")
+		os.Remove(full)
+		if errors.Is(err, stream.ErrBroadcasterClosed) {
+			err = errRecordNoMount
+		}
+		return recordingStat{}, err
+	}
+	m.addSession(recordRef)
+	// Start from a keyframe now rather than the next periodic one
+	forceKeyframe()
+	log.Printf("Recording mount %s to %s", key, full)
+	return rec.snapshot(), nil
+}
+
+// stopRecording ends m's recording, if any, and releases its hold on m.
+func (s *WhepServer) stopRecording(m *ndiMount, reason string) (recordingStat, bool) {
+	m.mu.Lock()
+	rec := m.rec
+	m.rec = nil
+	m.mu.Unlock()
+	if rec == nil {
+		return recordingStat{}, false
+	}
+	st := rec.finish(reason)
+	m.removeSession(recordRef, func() { s.teardownMountIfIdle(m.key) })
+	return st, true
+}
+
+// keepRecording keeps a finished recording for /health.
+func (s *WhepServer) keepRecording(st recordingStat) {
+	s.recordMu.Lock()
+	s.recorded = append(s.recorded, st)
+	if len(s.recorded) > recordHistory {
+		s.recorded = s.recorded[len(s.recorded)-recordHistory:]
+	}
+	s.recordMu.Unlock()
+}
+
+// recordingStats is the /health view of recordings: those running and the
+// last few finished.
+func (s *WhepServer) recordingStats() map[string]any {
+	var recs []*mountRecording
+	s.mu.Lock()
+	for _, m := range s.mounts {
+		m.mu.Lock()
+		if m.rec != nil {
+			recs = append(recs, m.rec)
+		}
+		m.mu.Unlock()
+	}
+	s.mu.Unlock()
+	active := make([]recordingStat, 0, len(recs))
+	for _, r := range recs {
+		active = append(active, r.snapshot())
+	}
+	s.recordMu.Lock()
+	recent := append([]recordingStat{}, s.recorded...)
+	s.recordMu.Unlock()
+	return map[string]any{"dir": s.recordDir(), "active": active, "recent": recent}
+}
+
+// handleMounts serves /mounts/{key}/record: GET for the mount's recording,
+// POST .../start with {"path": "...", "maxMB": N} and POST .../stop. Keys
+// may hold '/' (a 30000/1001 fps), so the action is cut from the end.
+func (s *WhepServer) handleMounts(w http.ResponseWriter, r *http.Request) {
+	allowCORS(w, r)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	rest := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/mounts/"), "/")
+	var key, action string
+	for _, a := range []string{"/record/start", "/record/stop", "/record"} {
+		if k, ok := strings.CutSuffix(rest, a); ok && k != "" {
+			key, action = k, a
+			break
+		}
+	}
+	if key == "" {
+		writeError(w, r, http.StatusNotFound, errCodeNotFound, "not found", nil)
+		return
+	}
+	switch {
+	case action == "/record" && r.Method == http.MethodGet:
+		s.mu.Lock()
+		m := s.mounts[key]
+		s.mu.Unlock()
+		var rec *mountRecording
+		if m != nil {
+			m.mu.Lock()
+			rec = m.rec
+			m.mu.Unlock()
+		}
+		if rec == nil {
+			writeError(w, r, http.StatusNotFound, errCodeNotFound, "mount is not recording", map[string]string{"mount": key})
+			return
+		}
+		writeRecording(w, http.StatusOK, rec.snapshot())
+	case action == "/record/start" && r.Method == http.MethodPost:
+		var body struct {
+			Path  string `json:"path"`
+			MaxMB int    `json:"maxMB"`
+		}
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				writeError(w, r, http.StatusBadRequest, errCodeBadRequest, "invalid JSON", nil)
+				return
+			}
+		}
+		st, err := s.startRecording(key, body.Path, body.MaxMB)
+		switch {
+		case err == nil:
+			writeRecording(w, http.StatusCreated, st)
+		case errors.Is(err, errRecordNoMount):
+			writeError(w, r, http.StatusNotFound, errCodeNotFound, err.Error(), map[string]string{"mount": key})
+		case errors.Is(err, errRecordBusy), errors.Is(err, os.ErrExist):
+			writeError(w, r, http.StatusConflict, errCodeConflict, err.Error(), map[string]string{"mount": key})
+		case errors.Is(err, errRecordPath):
+			writeError(w, r, http.StatusBadRequest, errCodeBadRequest, err.Error(), map[string]string{"path": body.Path})
+		case errors.Is(err, errRecordCodec):
+			writeError(w, r, http.StatusUnprocessableEntity, errCodeCodecUnsupported, err.Error(), nil)
+		case errors.Is(err, errDiskLow):
+			writeError(w, r, http.StatusInsufficientStorage, errCodeLimitExceeded, err.Error(), nil)
+		default:
+			writeError(w, r, http.StatusInternalServerError, errCodeInternal, err.Error(), nil)
+		}
+	case action == "/record/stop" && r.Method == http.MethodPost:
+		s.mu.Lock()
+		m := s.mounts[key]
+		s.mu.Unlock()
+		var st recordingStat
+		ok := false
+		if m != nil {
+			st, ok = s.stopRecording(m, "stopped")
+		}
+		if !ok {
+			writeError(w, r, http.StatusNotFound, errCodeNotFound, "mount is not recording", map[string]string{"mount": key})
+			return
+		}
+		writeRecording(w, http.StatusOK, st)
+	default:
+		writeError(w, r, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "method not allowed", nil)
+	}
+}
+
+func writeRecording(w http.ResponseWriter, status int, st recordingStat) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(st)
+}
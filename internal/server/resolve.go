@@ -0,0 +1,220 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"whep/internal/ndi"
+	"whep/internal/stream"
+)
+
+// Source resolution for the shared pipeline. The selection API, NDI_SOURCE_URL
+// and NDI_SOURCE can each name a source, and may disagree (a stale URL next
+// to a live name). Candidates are tried in -source-order until one opens;
+// the synthetic is used only when none does.
+const (
+	originSelection = "selection" // POST /ndi/select or /ndi/select_url
+	originURL       = "url"       // NDI_SOURCE_URL
+	originName      = "name"      // NDI_SOURCE
+	originFirst     = "first"     // first discovered NDI source
+)
+
+var defaultSourceOrder = []string{originSelection, originURL, originName, originFirst}
+
+// ParseSourceOrder parses a comma-separated -source-order, e.g.
+// "selection,url,name,first". Origins left out are never tried.
+func ParseSourceOrder(v string) ([]string, error) {
+	if strings.TrimSpace(v) == "" {
+		return defaultSourceOrder, nil
+	}
+	var order []string
+	seen := map[string]bool{}
+	for _, o := range strings.Split(v, ",") {
+		o = strings.ToLower(strings.TrimSpace(o))
+		switch o {
+		case originSelection, originURL, originName, originFirst:
+		default:
+			return nil, fmt.Errorf("unknown origin %q (want selection, url, name or first)", o)
+		}
+		if seen[o] {
+			return nil, fmt.Errorf("origin %q listed twice", o)
+		}
+		seen[o] = true
+		order = append(order, o)
+	}
+	return order, nil
+}
+
+func (s *WhepServer) sourceOrder() []string {
+	if len(s.cfg.SourceOrder) > 0 {
+		return s.cfg.SourceOrder
+	}
+	return defaultSourceOrder
+}
+
+// sourceAttempt is one candidate tried by the resolution, for /health.
+type sourceAttempt struct {
+	Origin string `json:"origin"`
+	Name   string `json:"name,omitempty"`
+	URL    string `json:"url,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// sourceResolution records the shared pipeline's last source resolution.
+// Winner is nil when every candidate failed and the synthetic is in use.
+type sourceResolution struct {
+	Order    []string        `json:"order"`
+	Attempts []sourceAttempt `json:"attempts"`
+	Winner   *sourceAttempt  `json:"winner"`
+	At       time.Time       `json:"at"`
+}
+
+// sourceCandidates lists the configured sources in -source-order. "first"
+// is only added after another candidate: with nothing configured the
+// server stays on the synthetic rather than picking up whatever NDI source
+// it sees.
+func (s *WhepServer) sourceCandidates() []sourceAttempt {
+	s.mu.Lock()
+	selName, selURL := s.ndiName, s.ndiURL
+	s.mu.Unlock()
+	var out []sourceAttempt
+	for _, o := range s.sourceOrder() {
+		var c sourceAttempt
+		switch o {
+		case originSelection:
+			c = sourceAttempt{Origin: o, Name: selName, URL: selURL}
+		case originURL:
+			c = sourceAttempt{Origin: o, URL: strings.TrimSpace(os.Getenv("NDI_SOURCE_URL"))}
+		case originName:
+			c = sourceAttempt{Origin: o, Name: strings.TrimSpace(os.Getenv("NDI_SOURCE"))}
+		case originFirst:
+			if len(out) > 0 {
+				out = append(out, sourceAttempt{Origin: o})
+			}
+			continue
+		}
+		if c.Name == "" && c.URL == "" {
+			continue
+		}
+		dup := false
+		for _, prev := range out {
+			if prev.Name == c.Name && prev.URL == c.URL {
+				dup = true
+				break
+			}
+		}
+		if !dup {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// ndiDiscovered is ndi.GetCachedSources; tests replace it with a fake
+// discovery list.
+var ndiDiscovered = ndi.GetCachedSources
+
+// checkCandidate pins an NDI candidate to a source from the discovery
+// cache, or rejects it if discovery has seen sources but not this one.
+// With an empty cache (discovery not run yet, or blind to the sender's
+// subnet) candidates pass unchanged and the open decides.
+func checkCandidate(c sourceAttempt) (sourceAttempt, error) {
+	if lookupSourceFactory(c.Name, c.URL) != ndiFactory {
+		return c, nil
+	}
+	live := ndiDiscovered()
+	if len(live) == 0 {
+		return c, nil
+	}
+	switch {
+	case c.Origin == originFirst:
+		c.Name, c.URL = live[0].Name, live[0].URL
+		return c, nil
+	case c.URL != "":
+		for _, si := range live {
+			if strings.EqualFold(si.URL, c.URL) {
+				return c, nil
+			}
+		}
+		return c, fmt.Errorf("not announced by discovery (%d source(s) seen)", len(live))
+	default:
+		// Exact name first, then the substring match NewNDISource uses
+		for _, si := range live {
+			if strings.EqualFold(si.Name, c.Name) {
+				c.URL = si.URL
+				return c, nil
+			}
+		}
+		low := strings.ToLower(c.Name)
+		for _, si := range live {
+			if strings.Contains(strings.ToLower(si.Name), low) {
+				c.URL = si.URL
+				return c, nil
+			}
+		}
+		return c, fmt.Errorf("no discovered source matches (%d source(s) seen)", len(live))
+	}
+}
+
+// openSharedSource resolves and opens the shared pipeline's source, logging
+// each failed candidate. src is nil if none opened.
+func (s *WhepServer) openSharedSource(w, h int, fps stream.Rate) (stream.Source, *sourceResolution) {
+	res := &sourceResolution{Order: s.sourceOrder(), Attempts: []sourceAttempt{}, At: time.Now()}
+	for _, c := range s.sourceCandidates() {
+		c, err := checkCandidate(c)
+		var src stream.Source
+		if err == nil {
+			src, err = s.openSource(c.Name, c.URL, w, h, fps)
+		}
+		if err != nil {
+			c.Error = err.Error()
+			res.Attempts = append(res.Attempts, c)
+			log.Printf("Source %s (name=%q url=%q) unavailable: %v", c.Origin, c.Name, c.URL, err)
+			continue
+		}
+		res.Attempts = append(res.Attempts, c)
+		res.Winner = &c
+		log.Printf("Using %s source (name=%q url=%q)", c.Origin, c.Name, c.URL)
+		return src, res
+	}
+	if len(res.Attempts) > 0 {
+		log.Printf("No configured source available, falling back to synthetic")
+	}
+	return nil, res
+}
+
+// hotSwappedLocked records a source cut into the running shared pipeline
+// (scheduled switch, failover) as the selection's win. Caller holds s.mu.
+func (s *WhepServer) hotSwappedLocked(name, url string) {
+	c := sourceAttempt{Origin: originSelection, Name: name, URL: url}
+	s.shareResolution = &sourceResolution{Order: s.sourceOrder(), Attempts: []sourceAttempt{c}, Winner: &c, At: time.Now()}
+}
+
+// frameSelection is the source /frame shows without a key: the shared
+// pipeline's winner while it runs, else the first candidate that passes
+// the discovery check.
+func (s *WhepServer) frameSelection() (name, url string) {
+	s.mu.Lock()
+	res := s.shareResolution
+	running := s.shareBC != nil
+	s.mu.Unlock()
+	if running && res != nil && res.Winner != nil {
+		return res.Winner.Name, res.Winner.URL
+	}
+	for _, c := range s.sourceCandidates() {
+		if c, err := checkCandidate(c); err == nil {
+			return c.Name, c.URL
+		}
+	}
+	return "", ""
+}
+
+// sourceResolutionStats is the last resolution for /health.
+func (s *WhepServer) sourceResolutionStats() *sourceResolution {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.shareResolution
+}
@@ -0,0 +1,99 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"whep/internal/ndi"
+	"whep/internal/stream"
+)
+
+// fakeNDI stands in for NDI discovery and receivers for the test: the
+// listed sources are discovered and open, by URL or by name; anything else
+// fails to open.
+func fakeNDI(t *testing.T, listed ...ndi.SourceInfo) {
+	t.Helper()
+	savedDiscovered, savedOpen := ndiDiscovered, ndiFactory.open
+	ndiDiscovered = func() []ndi.SourceInfo { return listed }
+	ndiFactory.open = func(s *WhepServer, name, url string, w, h int, fps stream.Rate) (stream.Source, error) {
+		for _, si := range listed {
+			if (url != "" && strings.EqualFold(si.URL, url)) || (url == "" && strings.EqualFold(si.Name, name)) {
+				return stream.NewSynthetic(64, 36, 30, 1), nil
+			}
+		}
+		return nil, errors.New("no such NDI source")
+	}
+	t.Cleanup(func() { ndiDiscovered, ndiFactory.open = savedDiscovered, savedOpen })
+}
+
+func TestSharedSourceResolution(t *testing.T) {
+	camA := ndi.SourceInfo{Name: "HOST (Cam A)", URL: "10.0.0.1:5961"}
+	camB := ndi.SourceInfo{Name: "HOST (Cam B)", URL: "10.0.0.2:5961"}
+	const dead = "10.0.0.9:5961"
+	tests := []struct {
+		name              string
+		order             []string
+		selName, selURL   string
+		envURL, envName   string
+		winner, winnerURL string // winner's origin and URL; "" for the synthetic
+		failed            []string
+	}{
+		{name: "dead URL, live name", envURL: dead, envName: "Cam B", winner: originName, winnerURL: camB.URL, failed: []string{originURL}},
+		{name: "selection beats URL", selName: camA.Name, selURL: camA.URL, envURL: camB.URL, winner: originSelection, winnerURL: camA.URL},
+		{name: "dead selection, live URL", selName: "Gone", selURL: dead, envURL: camB.URL, winner: originURL, winnerURL: camB.URL, failed: []string{originSelection}},
+		{name: "first discovered", selName: "Gone", selURL: dead, envName: "Cam Z", winner: originFirst, winnerURL: camA.URL, failed: []string{originSelection, originName}},
+		{name: "name before URL", order: []string{originName, originURL}, envURL: camA.URL, envName: "Cam B", winner: originName, winnerURL: camB.URL},
+		{name: "none opens", order: []string{originSelection, originURL}, selName: "Gone", selURL: dead, envURL: dead, failed: []string{originSelection, originURL}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakePipelines(t)
+			fakeNDI(t, camA, camB)
+			t.Setenv("NDI_SOURCE_URL", tt.envURL)
+			t.Setenv("NDI_SOURCE", tt.envName)
+			s, ts := newTestServer(t, func(c *Config) { c.SourceOrder = tt.order })
+			s.mu.Lock()
+			s.ndiName, s.ndiURL = tt.selName, tt.selURL
+			s.mu.Unlock()
+			if v := postOffer(t, ts, "/whep"); v.code != http.StatusCreated {
+				t.Fatalf("POST /whep: %d %s", v.code, v.body)
+			}
+
+			resp, err := http.Get(ts.URL + "/health")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var health struct {
+				NDI struct {
+					Resolution *sourceResolution `json:"resolution"`
+				} `json:"ndi"`
+			}
+			err = json.NewDecoder(resp.Body).Decode(&health)
+			resp.Body.Close()
+			res := health.NDI.Resolution
+			if err != nil || res == nil {
+				t.Fatalf("no resolution in /health (%v)", err)
+			}
+			switch {
+			case tt.winner == "" && res.Winner != nil:
+				t.Errorf("winner %+v, want the synthetic", *res.Winner)
+			case tt.winner != "" && res.Winner == nil:
+				t.Errorf("no winner, want %s %s", tt.winner, tt.winnerURL)
+			case tt.winner != "" && (res.Winner.Origin != tt.winner || res.Winner.URL != tt.winnerURL):
+				t.Errorf("winner %+v, want %s %s", *res.Winner, tt.winner, tt.winnerURL)
+			}
+			var failed []string
+			for _, a := range res.Attempts {
+				if a.Error != "" {
+					failed = append(failed, a.Origin)
+				}
+			}
+			if strings.Join(failed, ",") != strings.Join(tt.failed, ",") {
+				t.Errorf("failed attempts %v, want %v; attempts %+v", failed, tt.failed, res.Attempts)
+			}
+		})
+	}
+}
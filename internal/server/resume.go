@@ -0,0 +1,86 @@
+package server
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"whep/internal/stream"
+)
+
+// resumeInterval is how often the server checks for a sleep/resume or clock
+// jump. Encode loops check on their own ticks; this loop handles what they
+// can't: NDI receivers left dead by the suspend.
+const resumeInterval = time.Second
+
+// resumeStats records detected resumes for /health.
+type resumeStats struct {
+	mu    sync.Mutex
+	count int
+	last  time.Time
+}
+
+func (rs *resumeStats) note(at time.Time) {
+	rs.mu.Lock()
+	rs.count++
+	rs.last = at
+	rs.mu.Unlock()
+}
+
+func (rs *resumeStats) health() map[string]any {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	out := map[string]any{"count": rs.count}
+	if !rs.last.IsZero() {
+		out["last"] = rs.last
+	}
+	return out
+}
+
+// runResumeWatch checks for resumes until the server closes.
+func (s *WhepServer) runResumeWatch() {
+	ticker := time.NewTicker(resumeInterval)
+	defer ticker.Stop()
+	d := stream.NewResumeDetector(resumeInterval, nil)
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			if r, ok := d.Check(); ok {
+				s.handleResume(r)
+			}
+		}
+	}
+}
+
+// handleResume reconnects every NDI receiver after a resume: the shared
+// pipeline and each mount restart with fresh encoders (so viewers get a
+// keyframe and new timestamps), and cached /frame receivers are dropped.
+func (s *WhepServer) handleResume(r stream.Resume) {
+	s.resumes.note(time.Now())
+	s.emitEvent("resume_detected", map[string]any{"gap_ms": r.Gap.Milliseconds(), "skew_ms": r.Skew.Milliseconds()})
+	// Don't race a failover switch on the same mounts
+	s.failover.switching.Lock()
+	defer s.failover.switching.Unlock()
+	if err := s.restartSharedPipeline(); err != nil {
+		log.Printf("Resume: shared pipeline restart failed: %v", err)
+	}
+	s.mu.Lock()
+	mounts := make([]*ndiMount, 0, len(s.mounts))
+	for _, m := range s.mounts {
+		mounts = append(mounts, m)
+	}
+	s.mu.Unlock()
+	for _, m := range mounts {
+		select {
+		case <-m.ready:
+		default:
+			continue // still starting, so its receiver is new
+		}
+		if err := s.restartMount(m); err != nil {
+			log.Printf("Resume: mount %s restart failed: %v", m.key, err)
+		}
+	}
+	s.frameRx.closeAll()
+}
@@ -0,0 +1,189 @@
+package server
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pion/rtcp"
+	"github.com/pion/webrtc/v3"
+)
+
+// videoClockRate converts RTCP jitter (in RTP timestamp units) to time.
+const videoClockRate = 90000
+
+// rtcpStats is the viewer's view of the stream, from its receiver reports.
+type rtcpStats struct {
+	mu           sync.Mutex
+	reports      int
+	fractionLost float64 // 0..1, over the last report interval
+	totalLost    uint32
+	jitterMs     float64
+	rttMs        float64 // 0 until a report echoes one of our sender reports
+	plis, firs   int
+	remb         int // kbps from the last REMB; 0 if the viewer sends none
+	last         time.Time
+}
+
+// snapshot returns the stats for /health.
+func (st *rtcpStats) snapshot() map[string]any {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	out := map[string]any{
+		"receiver_reports": st.reports,
+		"fraction_lost":    st.fractionLost,
+		"packets_lost":     st.totalLost,
+		"jitter_ms":        st.jitterMs,
+		"rtt_ms":           st.rttMs,
+		"pli":              st.plis,
+		"fir":              st.firs,
+	}
+	if !st.last.IsZero() {
+		out["last_report"] = st.last.UTC().Format(time.RFC3339)
+	}
+	return out
+}
+
+// readRTCP drains the video sender's RTCP for the session's lifetime; Pion
+// buffers it otherwise. It returns once closeSession closes the
+// PeerConnection. Receiver reports feed sess.rtcp and PLI/FIR become a
+// keyframe request, so a joining or lossy decoder recovers without waiting
+// for the periodic keyframe.
+func (s *WhepServer) readRTCP(sess *session) {
+	if sess.sender == nil {
+		return
+	}
+	var ssrc webrtc.SSRC
+	if enc := sess.sender.GetParameters().Encodings; len(enc) > 0 {
+		ssrc = enc[0].SSRC
+	}
+	for {
+		pkts, _, err := sess.sender.ReadRTCP()
+		if err != nil {
+			return
+		}
+		for _, p := range pkts {
+			switch p := p.(type) {
+			case *rtcp.PictureLossIndication:
+				sess.rtcp.count(&sess.rtcp.plis)
+				s.requestKeyframe(sess.id)
+			case *rtcp.FullIntraRequest:
+				sess.rtcp.count(&sess.rtcp.firs)
+				s.requestKeyframe(sess.id)
+			case *rtcp.ReceiverReport:
+				for _, rr := range p.Reports {
+					if ssrc == 0 || rr.SSRC == uint32(ssrc) {
+						sess.rtcp.receptionReport(rr)
+					}
+				}
+			case *rtcp.ReceiverEstimatedMaximumBitrate:
+				sess.rtcp.setREMB(p.Bitrate)
+			case *rtcp.ExtendedReport:
+				for _, b := range p.Reports {
+					if dlrr, ok := b.(*rtcp.DLRRReportBlock); ok {
+						for _, r := range dlrr.Reports {
+							if ssrc == 0 || r.SSRC == uint32(ssrc) {
+								sess.rtcp.setRTT(r.LastRR, r.DLRR)
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+func (st *rtcpStats) count(n *int) {
+	st.mu.Lock()
+	*n++
+	st.mu.Unlock()
+}
+
+func (st *rtcpStats) receptionReport(rr rtcp.ReceptionReport) {
+	st.mu.Lock()
+	st.reports++
+	st.fractionLost = float64(rr.FractionLost) / 256
+	st.totalLost = rr.TotalLost
+	st.jitterMs = float64(rr.Jitter) * 1000 / videoClockRate
+	st.last = time.Now()
+	st.mu.Unlock()
+	st.setRTT(rr.LastSenderReport, rr.Delay)
+}
+
+func (st *rtcpStats) setREMB(bps float32) {
+	st.mu.Lock()
+	st.remb = int(bps / 1000)
+	st.mu.Unlock()
+}
+
+func (st *rtcpStats) rembKbps() int {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.remb
+}
+
+// setRTT computes the round trip from an echoed NTP timestamp (middle 32
+// bits) and the peer's hold delay, both in 1/65536 s (RFC 3550 6.4.1).
+func (st *rtcpStats) setRTT(echoed, delay uint32) {
+	if echoed == 0 {
+		return
+	}
+	d := ntpMiddle32(time.Now()) - echoed - delay
+	if d >= 1<<31 {
+		return // clock skew or a stale echo
+	}
+	st.mu.Lock()
+	st.rttMs = float64(d) * 1000 / 65536
+	st.mu.Unlock()
+}
+
+// ntpMiddle32 returns the middle 32 bits of t as an NTP timestamp.
+func ntpMiddle32(t time.Time) uint32 {
+	secs := uint64(t.Unix()) + 2208988800 // 1900 -> 1970
+	frac := (uint64(t.Nanosecond()) << 32) / 1e9
+	return uint32(secs<<16 | frac>>16)
+}
+
+// requestKeyframe routes a keyframe request to the encoder feeding session
+// id: its mount's, or the shared one. Pipelines coalesce requests, so every
+// viewer of a mount may ask independently.
+func (s *WhepServer) requestKeyframe(id string) {
+	s.mu.Lock()
+	ss := s.sessions[id]
+	if ss == nil {
+		s.mu.Unlock()
+		return
+	}
+	var p videoPipeline
+	if ss.mountKey == "" {
+		p = s.sharePipe
+	} else if m := s.mounts[ss.mountKey]; m != nil {
+		m.mu.Lock()
+		p = m.pipeFor(ss.detail)
+		m.mu.Unlock()
+	}
+	s.mu.Unlock()
+	if p != nil {
+		p.ForceKeyframe()
+	}
+}
+
+// endSession closes session id after telling the viewer the stream ended:
+// an RTCP BYE for each of its senders, so players can show "ended" instead
+// of waiting for ICE to time out.
+func (s *WhepServer) endSession(id, reason string) {
+	s.mu.Lock()
+	ss := s.sessions[id]
+	s.mu.Unlock()
+	if ss != nil {
+		var ssrcs []uint32
+		for _, snd := range ss.pc.GetSenders() {
+			for _, enc := range snd.GetParameters().Encodings {
+				ssrcs = append(ssrcs, uint32(enc.SSRC))
+			}
+		}
+		if len(ssrcs) > 0 {
+			_ = ss.pc.WriteRTCP([]rtcp.Packet{&rtcp.Goodbye{Sources: ssrcs, Reason: reason}})
+		}
+	}
+	s.closeSession(id)
+}
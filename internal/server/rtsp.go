@@ -0,0 +1,154 @@
+package server
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+
+	"whep/internal/stream"
+)
+
+// RTSP cameras. A camera mounts like an NDI source, at /whep/ndi/{key}: the
+// config file's "rtsp" section names cameras (name -> rtsp:// URL, keyed
+// by the name), and POST /ndi/select_url with an rtsp:// URL adds one keyed
+// by its host and path. The index, /health and everything else showing a
+// URL get it with the password redacted; the factory looks the full one up
+// when it opens the camera, so credentials stay in the server.
+
+// rtspCamera is a known camera.
+type rtspCamera struct {
+	name       string
+	url        string // with credentials
+	configured bool   // from the config file, else selected by URL
+}
+
+// rtspCameras holds the known cameras by mount key.
+type rtspCameras struct {
+	mu    sync.Mutex
+	byKey map[string]rtspCamera
+}
+
+func init() {
+	for _, scheme := range []string{"rtsp", "rtsps"} {
+		registerSourceFactory(&sourceFactory{
+			scheme: scheme,
+			open: func(s *WhepServer, name, url string, w, h int, fps stream.Rate) (stream.Source, error) {
+				src, err := stream.NewRTSPSource(s.rtsp.resolve(url))
+				if err != nil {
+					return nil, err
+				}
+				if w > 0 && h > 0 {
+					src.SetOutputSize(w, h)
+				}
+				return src, nil
+			},
+		})
+	}
+}
+
+// parseRTSPURL checks u is an rtsp:// or rtsps:// URL with a host.
+func parseRTSPURL(u string) (*url.URL, error) {
+	p, err := url.Parse(strings.TrimSpace(u))
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL %q", stream.RedactURL(u))
+	}
+	if s := strings.ToLower(p.Scheme); (s != "rtsp" && s != "rtsps") || p.Host == "" {
+		return nil, fmt.Errorf("%q is not an rtsp:// URL", p.Redacted())
+	}
+	return p, nil
+}
+
+// isRTSPURL reports whether u names an RTSP camera.
+func isRTSPURL(u string) bool {
+	s, _, ok := strings.Cut(strings.ToLower(strings.TrimSpace(u)), "://")
+	return ok && (s == "rtsp" || s == "rtsps")
+}
+
+func validateRTSPCameras(cams map[string]string) error {
+	for name, u := range cams {
+		if strings.TrimSpace(name) == "" {
+			return fmt.Errorf("camera %q: missing name", stream.RedactURL(u))
+		}
+		if _, err := parseRTSPURL(u); err != nil {
+			return fmt.Errorf("camera %q: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// setRTSPCameras installs the config file's cameras in place of the ones
+// it had before. Cameras selected by URL stay unless a configured one
+// takes their key.
+func (s *WhepServer) setRTSPCameras(cams map[string]string) error {
+	if err := validateRTSPCameras(cams); err != nil {
+		return err
+	}
+	c := &s.rtsp
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	next := make(map[string]rtspCamera, len(cams)+len(c.byKey))
+	for k, cam := range c.byKey {
+		if !cam.configured {
+			next[k] = cam
+		}
+	}
+	for name, u := range cams {
+		p, _ := parseRTSPURL(u)
+		next[slugKey(name, "")] = rtspCamera{name: name, url: p.String(), configured: true}
+	}
+	c.byKey = next
+	return nil
+}
+
+// remember adds a camera selected by URL, unless it is known already, and
+// returns the URL as the index lists it.
+func (c *rtspCameras) remember(u string) (string, error) {
+	p, err := parseRTSPURL(u)
+	if err != nil {
+		return "", err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, cam := range c.byKey {
+		if cam.url == p.String() {
+			return p.Redacted(), nil
+		}
+	}
+	where := p.Host + p.Path
+	if c.byKey == nil {
+		c.byKey = map[string]rtspCamera{}
+	}
+	// Another camera on the same path (a different user) gets -2, -3...
+	key := slugKey("", where)
+	for n := 2; ; n++ {
+		if _, taken := c.byKey[key]; !taken {
+			break
+		}
+		key = fmt.Sprintf("%s-%d", slugKey("", where), n)
+	}
+	c.byKey[key] = rtspCamera{name: "RTSP " + where, url: p.String()}
+	return p.Redacted(), nil
+}
+
+// resolve is the full URL of the camera listed as u; u itself for an
+// unknown camera.
+func (c *rtspCameras) resolve(u string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, cam := range c.byKey {
+		if cam.url == u || stream.RedactURL(cam.url) == u {
+			return cam.url
+		}
+	}
+	return u
+}
+
+// index adds the cameras to a sourceIndex.
+func (c *rtspCameras) index(out map[string]struct{ Name, URL string }) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for k, cam := range c.byKey {
+		out[k] = struct{ Name, URL string }{Name: cam.name, URL: stream.RedactURL(cam.url)}
+	}
+}
@@ -0,0 +1,337 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"whep/internal/stream"
+)
+
+// Scheduled switching. A switch opens its source scheduleLead before the
+// target time and hands it to the shared pipeline's SwitchSource, which cuts
+// over on the first frame at or after it.
+const (
+	scheduleLead     = 3 * time.Second
+	scheduleGrace    = 2 * time.Second // after the target, fall back to a restart
+	maxScheduleAhead = 24 * time.Hour
+	scheduleHistory  = 50
+)
+
+// Switch states
+const (
+	switchPending      = "pending"
+	switchPreconnected = "preconnected"
+	switchDone         = "done"
+	switchCancelled    = "cancelled"
+	switchFailed       = "failed"
+)
+
+// scheduledSwitch is one timed source change of the shared pipeline.
+type scheduledSwitch struct {
+	ID    string    `json:"id"`
+	Name  string    `json:"name"`
+	URL   string    `json:"url"`
+	At    time.Time `json:"scheduleAt"`
+	State string    `json:"state"`
+	// Mode is "hot-swap" (cut inside the running encoder) or "restart"
+	// (pipeline restart, when no compatible pipeline was running)
+	Mode       string     `json:"mode,omitempty"`
+	AchievedAt *time.Time `json:"achievedAt,omitempty"`
+	// AccuracyMs is AchievedAt - At; positive means late
+	AccuracyMs *float64 `json:"accuracyMs,omitempty"`
+	Error      string   `json:"error,omitempty"`
+
+	timers []*time.Timer
+	sw     *stream.SwitchSource // set while preconnected
+}
+
+func (ss *scheduledSwitch) label() string {
+	if ss.Name != "" {
+		return ss.Name
+	}
+	return ss.URL
+}
+
+// switchScheduler holds pending switches and a short history of finished ones.
+type switchScheduler struct {
+	mu      sync.Mutex
+	pending map[string]*scheduledSwitch
+	recent  []*scheduledSwitch
+}
+
+func (sc *switchScheduler) snapshot() (pending, recent []scheduledSwitch) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	for _, ss := range sc.pending {
+		pending = append(pending, *ss)
+	}
+	sort.Slice(pending, func(i, j int) bool { return pending[i].At.Before(pending[j].At) })
+	for _, ss := range sc.recent {
+		recent = append(recent, *ss)
+	}
+	return pending, recent
+}
+
+// finishLocked moves ss from pending to history. Caller holds sc.mu.
+func (sc *switchScheduler) finishLocked(ss *scheduledSwitch, state, errMsg string) {
+	for _, t := range ss.timers {
+		t.Stop()
+	}
+	ss.timers, ss.sw = nil, nil
+	ss.State, ss.Error = state, errMsg
+	delete(sc.pending, ss.ID)
+	sc.recent = append(sc.recent, ss)
+	if len(sc.recent) > scheduleHistory {
+		sc.recent = sc.recent[len(sc.recent)-scheduleHistory:]
+	}
+}
+
+// parseScheduleAt parses an RFC3339 time (fractional seconds allowed) and
+// checks it lies in the schedulable window.
+func parseScheduleAt(v string) (time.Time, string) {
+	at, err := time.Parse(time.RFC3339Nano, strings.TrimSpace(v))
+	if err != nil {
+		return time.Time{}, "scheduleAt must be RFC3339, e.g. 2024-05-01T14:00:00.000Z"
+	}
+	switch d := time.Until(at); {
+	case d <= 0:
+		return time.Time{}, "scheduleAt is in the past"
+	case d > maxScheduleAhead:
+		return time.Time{}, "scheduleAt is more than 24h ahead"
+	}
+	return at, ""
+}
+
+// scheduleSwitch queues a switch of the shared pipeline to name/url at at.
+// It refuses, with a message, a switch too close to another pending one.
+func (s *WhepServer) scheduleSwitch(name, url string, at time.Time) (*scheduledSwitch, string) {
+	sc := &s.sched
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	// The shared pipeline's SwitchSource holds one pending source at a time
+	for _, p := range sc.pending {
+		if d := p.At.Sub(at); d > -2*scheduleLead && d < 2*scheduleLead {
+			return nil, "another switch (" + p.ID + ") is scheduled within " + (2 * scheduleLead).String()
+		}
+	}
+	if sc.pending == nil {
+		sc.pending = map[string]*scheduledSwitch{}
+	}
+	ss := &scheduledSwitch{ID: uuid.New().String(), Name: name, URL: url, At: at, State: switchPending}
+	lead := time.Until(at) - scheduleLead
+	if lead < 0 {
+		lead = 0
+	}
+	ss.timers = append(ss.timers,
+		time.AfterFunc(lead, func() { s.preconnectSwitch(ss) }),
+		time.AfterFunc(time.Until(at)+scheduleGrace, func() { s.restartSwitch(ss, "hot-swap did not happen") }),
+	)
+	sc.pending[ss.ID] = ss
+	log.Printf("Switch %s scheduled: %s at %s", ss.ID, ss.label(), at.Format(time.RFC3339Nano))
+	return ss, ""
+}
+
+// preconnectSwitch opens the switch's source and arms the shared pipeline's
+// SwitchSource. Without a running pipeline, or when the pixel formats differ,
+// the switch becomes a restart at the target time instead.
+func (s *WhepServer) preconnectSwitch(ss *scheduledSwitch) {
+	s.sched.mu.Lock()
+	if s.sched.pending[ss.ID] != ss {
+		s.sched.mu.Unlock()
+		return
+	}
+	name, url, at := ss.Name, ss.URL, ss.At
+	s.sched.mu.Unlock()
+
+	s.mu.Lock()
+	sw := s.shareSwitch
+	s.mu.Unlock()
+	restartAt := func(reason string) {
+		log.Printf("Switch %s: %s; will restart the pipeline at the target time", ss.ID, reason)
+		s.sched.mu.Lock()
+		if s.sched.pending[ss.ID] == ss {
+			ss.timers = append(ss.timers, time.AfterFunc(time.Until(at), func() { s.restartSwitch(ss, "") }))
+		}
+		s.sched.mu.Unlock()
+	}
+	if sw == nil {
+		restartAt("no shared pipeline with a source running")
+		return
+	}
+	ow, oh := s.sharedOpenSize()
+	src, err := s.openSource(name, url, ow, oh, s.fps())
+	if err != nil {
+		s.sched.mu.Lock()
+		if s.sched.pending[ss.ID] == ss {
+			s.sched.finishLocked(ss, switchFailed, "preconnect: "+err.Error())
+		}
+		s.sched.mu.Unlock()
+		log.Printf("Switch %s: preconnect failed: %v", ss.ID, err)
+		return
+	}
+	if stream.PixFmtOf(src) != stream.PixFmtOf(sw.Current()) {
+		src.Stop()
+		restartAt("pixel format differs from the running source")
+		return
+	}
+	if stream.HasFrames(src) != stream.HasFrames(sw.Current()) {
+		src.Stop()
+		restartAt("frame delivery differs from the running source")
+		return
+	}
+	s.sched.mu.Lock()
+	if s.sched.pending[ss.ID] != ss {
+		s.sched.mu.Unlock()
+		src.Stop()
+		return
+	}
+	ss.State, ss.Mode, ss.sw = switchPreconnected, "hot-swap", sw
+	s.sched.mu.Unlock()
+	sw.Schedule(src, at, func(achieved time.Time) { s.completeSwitch(ss, src, achieved) })
+	log.Printf("Switch %s: preconnected %s", ss.ID, ss.label())
+}
+
+// completeSwitch runs on the encoder goroutine right after the cut.
+func (s *WhepServer) completeSwitch(ss *scheduledSwitch, src stream.Source, achieved time.Time) {
+	s.mu.Lock()
+	s.ndiName, s.ndiURL = ss.Name, ss.URL
+	s.changes.bump()
+	s.hotSwappedLocked(ss.Name, ss.URL)
+	audio, pipe := s.shareAudio, s.sharePipe
+	s.mu.Unlock()
+	audio.setSource(src)
+	if pipe != nil {
+		pipe.ForceKeyframe() // a cut is a scene change; let decoders resync
+	}
+	s.recordSwitch(ss, achieved)
+}
+
+// restartSwitch performs the switch as a pipeline restart; used when no
+// hot-swap was armed, or one didn't happen within scheduleGrace.
+func (s *WhepServer) restartSwitch(ss *scheduledSwitch, reason string) {
+	s.sched.mu.Lock()
+	if s.sched.pending[ss.ID] != ss {
+		s.sched.mu.Unlock()
+		return
+	}
+	sw := ss.sw
+	ss.Mode = "restart"
+	s.sched.mu.Unlock()
+	if sw != nil {
+		sw.Cancel()
+	}
+	if reason != "" {
+		log.Printf("Switch %s: %s, restarting pipeline", ss.ID, reason)
+	}
+	s.mu.Lock()
+	s.ndiName, s.ndiURL = ss.Name, ss.URL
+	s.changes.bump()
+	s.mu.Unlock()
+	if err := s.restartSharedPipeline(); err != nil {
+		s.sched.mu.Lock()
+		s.sched.finishLocked(ss, switchFailed, err.Error())
+		s.sched.mu.Unlock()
+		return
+	}
+	s.recordSwitch(ss, time.Now())
+}
+
+func (s *WhepServer) recordSwitch(ss *scheduledSwitch, achieved time.Time) {
+	acc := float64(achieved.Sub(ss.At).Microseconds()) / 1000
+	s.sched.mu.Lock()
+	if s.sched.pending[ss.ID] == ss {
+		ss.AchievedAt, ss.AccuracyMs = &achieved, &acc
+		s.sched.finishLocked(ss, switchDone, "")
+	}
+	s.sched.mu.Unlock()
+	log.Printf("Switch %s: switched to %s (%s), %.1f ms from target", ss.ID, ss.label(), ss.Mode, acc)
+}
+
+// cancelSwitch cancels a pending switch; false if id isn't pending.
+func (s *WhepServer) cancelSwitch(id string) bool {
+	s.sched.mu.Lock()
+	ss := s.sched.pending[id]
+	if ss == nil {
+		s.sched.mu.Unlock()
+		return false
+	}
+	sw := ss.sw
+	s.sched.finishLocked(ss, switchCancelled, "")
+	s.sched.mu.Unlock()
+	if sw != nil {
+		sw.Cancel()
+	}
+	log.Printf("Switch %s cancelled", id)
+	return true
+}
+
+// cancelAllSwitches is called on shutdown.
+func (s *WhepServer) cancelAllSwitches() {
+	s.sched.mu.Lock()
+	ids := make([]string, 0, len(s.sched.pending))
+	for id := range s.sched.pending {
+		ids = append(ids, id)
+	}
+	s.sched.mu.Unlock()
+	for _, id := range ids {
+		s.cancelSwitch(id)
+	}
+}
+
+// GET /ndi/schedule lists pending and recent switches;
+// DELETE /ndi/schedule/{id} cancels a pending one.
+func (s *WhepServer) handleSchedule(w http.ResponseWriter, r *http.Request) {
+	allowCORS(w, r)
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/ndi/schedule"), "/")
+	switch r.Method {
+	case http.MethodOptions:
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodGet:
+		if id != "" {
+			writeError(w, r, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "method not allowed", nil)
+			return
+		}
+		pending, recent := s.sched.snapshot()
+		if pending == nil {
+			pending = []scheduledSwitch{}
+		}
+		if recent == nil {
+			recent = []scheduledSwitch{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{"pending": pending, "recent": recent})
+	case http.MethodDelete:
+		if id == "" || !s.cancelSwitch(id) {
+			writeError(w, r, http.StatusNotFound, errCodeNotFound, "no pending switch "+id, nil)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, r, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "method not allowed", nil)
+	}
+}
+
+// respondScheduled validates scheduleAt and schedules name/url, replying
+// 202 with the switch. The source must already have been resolved.
+func (s *WhepServer) respondScheduled(w http.ResponseWriter, r *http.Request, scheduleAt, name, url string) {
+	at, msg := parseScheduleAt(scheduleAt)
+	if msg != "" {
+		writeError(w, r, http.StatusBadRequest, errCodeBadRequest, msg, nil)
+		return
+	}
+	ss, msg := s.scheduleSwitch(name, url, at)
+	if msg != "" {
+		writeError(w, r, http.StatusConflict, errCodeConflict, msg, nil)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	_ = json.NewEncoder(w).Encode(map[string]any{"ok": true, "scheduled": *ss})
+}
@@ -3,20 +3,25 @@ package server
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"image"
-	"image/png"
 	"io"
 	"log"
 	"net/http"
 	"os"
+	"slices"
+	"sort"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"whep/internal/stream"
 
 	"github.com/google/uuid"
+	"github.com/pion/interceptor/pkg/cc"
+	"github.com/pion/interceptor/pkg/stats"
+	"github.com/pion/transport/v2"
 	"github.com/pion/webrtc/v3"
 
 	// optional on non-windows/no-cgo builds via indirection
@@ -27,10 +32,14 @@ import (
 // Idle teardown for per-source mounts
 const mountIdleTTL = 60 * time.Second
 
+// sessionConnectTimeout is how long a new session may take to connect
+// before it is closed
+const sessionConnectTimeout = 30 * time.Second
+
 type Config struct {
 	Host         string
 	Port         int
-	FPS          int
+	FPS          stream.Rate
 	Width        int
 	Height       int
 	BitrateKbps  int
@@ -38,10 +47,120 @@ type Config struct {
 	HWAccel      string // reserved for HW encoders (not used by AV1 here)
 	VP8Speed     int
 	VP8Dropframe int
+	// SourceOrder is the order the shared pipeline tries the selection,
+	// NDI_SOURCE_URL, NDI_SOURCE and the first discovered source (nil =
+	// defaults; see ParseSourceOrder)
+	SourceOrder []string
+	ConfigFile  string // optional JSON file (ladder, ...); reloaded when it changes
+	Audio       bool   // send the source's NDI audio as an Opus track
+	MaxSockets  int    // soft cap on open sockets; new sessions get 503 past it (0 = unlimited)
+	// MaxSessions caps sessions in total and MaxSessionsPerMount per source
+	// key (or /whep); new sessions past either get 503 (0 = unlimited)
+	MaxSessions         int
+	MaxSessionsPerMount int
+	// EncoderCapacity is how many video encoders count as full load in the
+	// load score (0 = one per two CPUs); MemoryBudgetMB measures memory as
+	// the process's resident size against a budget instead of system-wide
+	// use (0 = system)
+	EncoderCapacity int
+	MemoryBudgetMB  int
+	// LoadWeights weights the load score's components (zero value = defaults);
+	// LoadHeader adds the score to WHEP 201s as X-Server-Load
+	LoadWeights LoadWeights
+	LoadHeader  bool
+	// DowngradeLoad is the load score (1..100) from which a request that
+	// would start a new mount is stepped down the ladder (0 = off)
+	DowngradeLoad int
+	// AnswerMode is when WHEP answers are sent: AnswerComplete (default)
+	// after ICE gathering, AnswerEarly once host candidates are in
+	AnswerMode string
+	MinFreeMB  int // refuse new recordings/dumps below this much free disk (0 = off)
+	StopFreeMB int // stop active recordings/dumps below this much free disk (0 = off)
+	// BWE adapts encoder bitrate to viewers' congestion estimates (TWCC/REMB),
+	// never going below MinBitrateKbps
+	BWE            bool
+	MinBitrateKbps int
+	// SLOWindows are the rolling windows availability is reported over
+	// (default 1h and 24h)
+	SLOWindows []time.Duration
+	StateDir   string // profiles and other server-written files (default "state")
+	// RecordDir is where mount recordings go (default StateDir/recordings);
+	// a recording starts a new file at a keyframe every RecordMaxMB (0 = never)
+	RecordDir   string
+	RecordMaxMB int
+	// BenchOnStart runs the encoder benchmark at startup; a result more
+	// than BenchRegressPct slower than its baseline is flagged (0 = 25%)
+	BenchOnStart    bool
+	BenchRegressPct int
+	// Keyint is the most frames between keyframes for every encoder a
+	// mount doesn't set its own for; 0 is the codec's default
+	Keyint int
+	// RateControl is stream.RateCBR or RateCQ for every encoder a mount
+	// doesn't pick its own for; in CQ, CRF (0 = stream.DefaultCRF) sets
+	// the quality and the bitrate only caps it
+	RateControl string
+	CRF         int
+	// VP9 tunes VP9 encoders a mount doesn't tune itself; nil for
+	// stream.DefaultVP9Tuning
+	VP9 *stream.VP9Tuning
+	// AV1 tunes AV1 encoders a mount doesn't tune itself; nil for
+	// stream.DefaultAV1Tuning
+	AV1 *stream.AV1Tuning
+	// Rotate turns the shared /whep picture clockwise by 0, 90, 180 or 270
+	// degrees; Width and Height are the turned size
+	Rotate int
+	// FrameReceiverTTL keeps a /frame receiver open this long after its last
+	// use (0 = 10s, negative = close after each request)
+	FrameReceiverTTL time.Duration
+	// SharedLinger keeps the shared pipeline running this long after its
+	// last session leaves, for a quick rejoin (0 = 30s, negative = stop at
+	// once)
+	SharedLinger time.Duration
+	// OfferDedupeWindow is how long a repeated offer gets the first POST's
+	// session back instead of a new one (0 = 30s, negative = off)
+	OfferDedupeWindow time.Duration
+	// PublicURL is the externally visible base URL (e.g.
+	// "https://example.com/live") that Location headers are built on; empty
+	// derives it from X-Forwarded-* headers or the request
+	PublicURL string
+	// AdminToken is the bearer token /admin/* requires; "" leaves them to
+	// loopback clients. /config.json serves the Config, so it is never
+	// encoded
+	AdminToken string `json:"-"`
+	// TempReceivers caps concurrent temporary NDI receivers (probes, /frame
+	// grabs) and TempReceiverRate how many open per second; 0 = defaults
+	TempReceivers    int
+	TempReceiverRate float64
+	// Queue sizes each viewer's sample queue and the pipelines' writer
+	// queues, and picks what a full one drops
+	Queue stream.QueueConfig
+	// FFmpeg is the ffmpeg RTSP cameras are read with ("" = from PATH);
+	// ffprobe is expected beside it
+	FFmpeg string
+	// Slate is the default slate image (PNG or JPEG): the "slate" source,
+	// and what failover puts on air when every candidate is missing
+	Slate string
+	// Pipe, when set, reads raw frames of that format from PipeInput ("-"
+	// for stdin, a named pipe, or tcp://host:port) as the "pipe" source
+	Pipe      *stream.PipeFormat
+	PipeInput string
+	// SourceProbe briefly receives each discovered NDI source that nothing
+	// else receives, to list its size and frame rate in /ndi/sources
+	SourceProbe bool
+	// DiscoveryInterval spaces background NDI discovery passes (0 =
+	// ndi.DefaultDiscoveryInterval); DiscoveryIdle pauses them after that
+	// long without sessions or API calls, until the next call (0 = never)
+	DiscoveryInterval time.Duration
+	DiscoveryIdle     time.Duration
 }
 
 type WhepServer struct {
-	cfg      Config
+	cfg Config
+	// cfgMu guards the cfg fields POST /config changes (encoderSettings).
+	// They are written holding both s.mu and cfgMu, so code holding s.mu
+	// may read them directly; other code uses encoderCfg. It is taken
+	// last, after any other lock.
+	cfgMu    sync.RWMutex
 	mu       sync.Mutex
 	sessions map[string]*session
 	// NDI selection shared across sessions
@@ -50,27 +169,123 @@ type WhepServer struct {
 	// Shared encoder pipeline so we encode once and fanout to all sessions
 	shareBC     *stream.SampleBroadcaster
 	shareStop   func()
+	sharePipe   videoPipeline // shared encoder, for keyframe and bitrate requests
 	shareSrc    stream.Source
 	shareCodec  string
-	shareCancel context.CancelFunc // cancels resolution monitor
+	shareFPS    stream.Rate          // what the shared encoder runs at
+	shareCancel context.CancelFunc   // cancels resolution monitor
+	shareAudio  *audioFeed           // Opus fanout for the shared pipeline (nil when audio is off)
+	shareSwitch *stream.SwitchSource // wraps shareSrc for scheduled switches (nil without a source)
+	// shareResolution is how the shared pipeline's source was picked
+	shareResolution *sourceResolution
+	// sharePending counts /whep POSTs between picking a codec and
+	// registering their session; the shared pipeline isn't stopped under them
+	sharePending int
+	// shareLinger counts down to stopping the unused shared pipeline
+	// (nil when it isn't lingering); it runs out at shareLingerUntil
+	shareLinger      *time.Timer
+	shareLingerUntil time.Time
+	// sessionsReserved counts session slots claimed by POSTs still setting
+	// up, per mount, for the session limits
+	sessionsReserved map[string]int
 
 	// Per-source mounts: one shared pipeline per NDI source key
 	mounts map[string]*ndiMount
+
+	// Shared bitrate/resolution ladder that mount variants snap to
+	ladder *Ladder
+
+	// Socket accounting for PeerConnections (net is nil if the wrapper failed)
+	sockets socketCounter
+	net     transport.Net
+
+	// Free-space checks for features that write to disk
+	disk *diskGuard
+
+	// Streams published over WHIP, relayed as sources
+	whip whipPublishers
+	// RTSP cameras, from the config file or selected by URL
+	rtsp rtspCameras
+	// Raw frames piped in (-stdin-source): the server's own handle and the
+	// reader under it; nil when off
+	pipe    *stream.SharedSource
+	pipeSrc *stream.PipeSource
+
+	// Finished recordings for /health, oldest first
+	recordMu sync.Mutex
+	recorded []recordingStat
+
+	// Latest CPU and memory readings for the load score
+	load loadSampler
+
+	// Cached synthetic for /frame when Splash is selected
+	splashMu         sync.Mutex
+	splash           stream.Source
+	splashW, splashH int
+
+	// Timed source switches of the shared pipeline
+	sched switchScheduler
+	// Automatic failover rules from the config file
+	failover failoverSet
+	// Switches made for fallback= mounts, for /metrics
+	fallbacks fallbackStats
+	// Virtual channels from the config file, retargeted via /admin/channels
+	channels channelSet
+	// Recent offers, to answer a retried POST from its first session
+	offers offerCache
+	// Guest links created via /admin/links
+	links linkSet
+	// Webhook URLs events are POSTed to
+	hooks webhooks
+	// Weekly viewing windows per mount from the config file
+	availability availabilitySet
+	// Availability tracking for /admin/slo
+	slo sloSet
+	// Receivers /frame keeps open between polls
+	frameRx frameReceivers
+	// Sleep/resume and clock jumps seen so far
+	resumes resumeStats
+	// Revisions of /admin/snapshot
+	changes changeFeed
+	// Encoder benchmark against its baseline, for /admin/benchmark
+	bench benchState
+	// Native receivers shared by each source's crop mounts
+	crops cropReceivers
+	// Composite layouts, by id (POST /mounts/composite)
+	composites composites
+	// Sources' size and frame rate as last received, for /ndi/sources
+	sourceMetas sourceMetas
+	// lastActivity is the UnixNano of the last API call, for -ndi-discovery-idle
+	lastActivity atomic.Int64
+
+	// draining is set by Close; new sessions are refused from then on
+	draining atomic.Bool
+	// done is closed by Close to stop background loops
+	done chan struct{}
+	// ndiInit is closed once initNDI has returned
+	ndiInit chan struct{}
 }
 
+// session is one viewer. It never owns an encoder: its track is fed by the
+// broadcaster of its mount (mountKey) or of the shared pipeline, and detach
+// unsubscribes it.
 type session struct {
-	id         string
-	pc         *webrtc.PeerConnection
-	sender     *webrtc.RTPSender
-	track      interface{}
-	stop       func()
-	src        stream.Source
-	cancelFunc context.CancelFunc
-	codec      string
-	created    time.Time
-	state      string
-	detach     func() // unsubscribe from broadcaster
-	mountKey   string // for per-source mount sessions
+	id     string
+	pc     *webrtc.PeerConnection
+	sender *webrtc.RTPSender
+	track  interface{}
+	// connectCancel stops the connect timeout once the session connects or closes
+	connectCancel context.CancelFunc
+	codec         string
+	created       time.Time
+	state         string
+	detach        func() // unsubscribe from broadcaster
+	mountKey      string // for per-source mount sessions
+	detail        bool   // plays its split mount's detail crop
+	link          string // guest link it was opened through
+	rtcp          *rtcpStats
+	bwe           cc.BandwidthEstimator // TWCC send-side estimate; nil when BWE is off
+	stats         stats.Getter          // RTP counters from the stats interceptor
 }
 
 // ndiMount represents a per-source shared pipeline that fans out to many sessions.
@@ -81,23 +296,46 @@ type ndiMount struct {
 	codec       string
 	width       int
 	height      int
-	fps         int
+	fps         stream.Rate // requested; unset follows the source
+	encFPS      stream.Rate // what the encoder runs at
 	bitrateKbps int
+	aspect      string        // stream.AspectAdapt, AspectLetterbox or AspectCrop
+	filter      string        // scale filter; "" for the server default
+	detail      stream.Region // split encode's detail crop; empty without one
+	crop        stream.Region // region of interest served; empty for the whole frame
+	orient      stream.Orientation
+	bandwidth   string // NDI receive bandwidth, ndi.BandwidthHighest or BandwidthLowest
+	query       string // encoded variant parameters of the request that created it
+	keyint      int    // frames between keyframes; 0 for the codec's default
+	rc          string // stream.RateCBR or RateCQ
+	crf         int    // CQ quality; 0 for the default
+	vp9         stream.VP9Tuning
+	av1         stream.AV1Tuning
+	rec         *mountRecording // running recording; it holds a session entry
 	bc          *stream.SampleBroadcaster
+	detailBC    *stream.SampleBroadcaster // the detail encoder's fanout (split mounts)
+	detailPipe  videoPipeline
+	audio       *audioFeed
 	stop        func()
+	pipe        videoPipeline
 	src         stream.Source
+	sw          *stream.SwitchSource // wraps the opened source for failover hot-swaps (nil without one)
+	alias       string               // failover mount alias this mount serves, if any
+	fb          *mountFallback       // fallback= list and where it stands (nil without one)
 	cancel      context.CancelFunc
 	mu          sync.Mutex
 	sessions    map[string]struct{}
 	idleTimer   *time.Timer
 	noSessTimer *time.Timer
 	created     time.Time
-}
-
-func (m *ndiMount) refCount() int {
-	m.mu.Lock()
-	defer m.mu.Unlock()
-	return len(m.sessions)
+	// ready is closed once the first pipeline start has finished, with
+	// startErr set if it failed; concurrent requests for the same key wait
+	// on it instead of starting their own
+	ready    chan struct{}
+	startErr error
+	// pending counts requests handed this mount by ensureMount that haven't
+	// registered their session yet; an idle mount isn't torn down under them
+	pending int
 }
 
 func (m *ndiMount) addSession(id string) {
@@ -127,65 +365,251 @@ func (m *ndiMount) removeSession(id string, onIdle func()) {
 	m.mu.Unlock()
 }
 
+// release ends a request's claim from ensureMount, once its session is
+// registered or it gave up. A mount left idle by a request that gave up
+// gets a fresh idle countdown, since a teardown may have skipped it.
+func (m *ndiMount) release(onIdle func()) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.pending > 0 {
+		m.pending--
+	}
+	if m.pending == 0 && len(m.sessions) == 0 && m.bc != nil {
+		if m.idleTimer != nil {
+			m.idleTimer.Stop()
+		}
+		m.idleTimer = time.AfterFunc(mountIdleTTL, onIdle)
+	}
+}
+
 func NewWhepServer(cfg Config) *WhepServer {
-	// Start background NDI discovery so API can serve cached results immediately
-	ndi.StartBackgroundDiscovery()
-	s := &WhepServer{cfg: cfg, sessions: map[string]*session{}, mounts: map[string]*ndiMount{}}
+	ndi.SetTempLimits(cfg.TempReceivers, cfg.TempReceiverRate)
+	stream.SetFFmpegPath(cfg.FFmpeg)
+	stream.SetStallSlate(cfg.Slate)
+	ndi.SetDiscoveryInterval(cfg.DiscoveryInterval)
+	done := make(chan struct{})
+	s := &WhepServer{cfg: cfg, sessions: map[string]*session{}, mounts: map[string]*ndiMount{}, ladder: &Ladder{}, disk: newDiskGuard(cfg.MinFreeMB, cfg.StopFreeMB, done), done: done, ndiInit: make(chan struct{})}
+	// A cold NDI runtime load can take seconds; nothing that doesn't
+	// receive NDI waits for it
+	go s.initNDI()
+	s.loadConfigFile()
+	s.startPipeSource()
+	if n, err := newCountingNet(&s.sockets); err != nil {
+		log.Printf("Socket accounting disabled: %v", err)
+	} else {
+		s.net = n
+	}
 	// Preflight logs
-	log.Printf("Color conversion: %s", stream.ColorConversionImpl())
+	log.Printf("Color conversion: %s, matrix %s, range %s", stream.ColorConversionImpl(), stream.YUVMatrix(), stream.YUVRange())
+	if hw := stream.ProbeHWAccel(cfg.HWAccel); hw.Active {
+		log.Printf("Hardware encoder: %s (%s)", hw.Requested, hw.Codec)
+	} else if hw.Error != "" {
+		log.Printf("Hardware encoder %s unavailable, using software %s: %s", hw.Requested, s.softwareCodec(), hw.Error)
+	}
+	if !slices.ContainsFunc(stream.Capabilities(), func(c stream.Capability) bool { return c.Built }) {
+		log.Printf("No video encoders built in: WHEP sessions will fail, /frame still works (build with -tags vpx, aom, svt or qsv)")
+	}
 	// Reset metrics at startup
 	stream.ResetCounters()
+	if cfg.BWE {
+		go s.runBWE()
+	}
+	s.slo.windows = cfg.SLOWindows
+	if len(s.slo.windows) == 0 {
+		s.slo.windows = defaultSLOWindows
+	}
+	go s.runSLO()
+	go s.runFailover()
+	go s.runResumeWatch()
+	go s.runAvailability()
+	go s.runLoadSampler()
+	go s.watchProfileSignal()
+	if cfg.SourceProbe {
+		go s.runSourceProbe()
+	}
+	s.lastActivity.Store(time.Now().UnixNano())
+	if cfg.DiscoveryIdle > 0 {
+		go s.runDiscoveryIdle(cfg.DiscoveryIdle)
+	}
+	if cfg.BenchOnStart {
+		go func() {
+			if err := s.runBenchmark(); err != nil {
+				log.Printf("Benchmark: %v", err)
+			}
+		}()
+	}
 	return s
 }
 
+// ndiInitialize is ndi.Initialize; tests replace it to slow startup down.
+var ndiInitialize = ndi.Initialize
+
+// initNDI initializes the NDI runtime, logging a missing or broken one, and
+// starts background discovery so the API can serve cached results. Until it
+// returns /health reports ndi_state "initializing"; NDI opens meanwhile
+// wait in ndi.Initialize.
+func (s *WhepServer) initNDI() {
+	defer close(s.ndiInit)
+	start := time.Now()
+	ndiInitialize()
+	if info := ndi.SDK(); info.OK() {
+		log.Printf("NDI runtime: %s (%s), built against the NDI %d SDK, ready in %s", info.Version, info.RuntimePath, info.BuildMajor, time.Since(start).Round(time.Millisecond))
+		if info.Message != "" {
+			log.Printf("NDI: %s", info.Message)
+		}
+	} else {
+		log.Printf("NDI unavailable: %s", info.Message)
+	}
+	select {
+	case <-s.done:
+		return
+	default:
+	}
+	ndi.StartBackgroundDiscovery()
+}
+
+// ndiState is ndi.State as this server reports it: initializing until its
+// initNDI has returned.
+func (s *WhepServer) ndiState() string {
+	select {
+	case <-s.ndiInit:
+		return ndi.State()
+	default:
+		return ndi.StateInitializing
+	}
+}
+
 func (s *WhepServer) RegisterRoutes(mux *http.ServeMux) {
-	mux.HandleFunc("/whep", s.handleWHEPPost)
-	mux.HandleFunc("/whep/", s.handleWHEPResource)
+	// Every route negotiates X-WHEP-Api-Version; all but monitoring count
+	// as activity for -ndi-discovery-idle
+	handle := func(pattern string, h http.HandlerFunc) {
+		mux.HandleFunc(pattern, withAPIVersion(s.noteActivity(pattern, h)))
+	}
+	// Admin endpoints also need the admin token, or a loopback client
+	admin := func(pattern string, h http.HandlerFunc) { handle(pattern, s.requireAdmin(h)) }
+	handle("/whep", s.handleWHEPPost)
+	handle("/whep/", s.handleWHEPResource)
 	// Per-source WHEP mounts
-	mux.HandleFunc("/whep/ndi/", s.handleWHEPNDI)
-	mux.HandleFunc("/ndi/sources", s.handleNDISources)
-	mux.HandleFunc("/ndi/select", s.handleNDISelect)
-	mux.HandleFunc("/ndi/select_url", s.handleNDISelectURL)
-	mux.HandleFunc("/config", s.handleConfig)
-	mux.HandleFunc("/config/", s.handleConfig) // support trailing slash
-	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
-		s.mu.Lock()
-		name, url := s.ndiName, s.ndiURL
-		sessCount := len(s.sessions)
-		// build detailed session info for leak detection
-		details := make([]map[string]any, 0, sessCount)
-		for id, ss := range s.sessions {
-			details = append(details, map[string]any{
-				"id":         id,
-				"codec":      ss.codec,
-				"created":    ss.created.UTC().Format(time.RFC3339),
-				"pc_state":   ss.state,
-				"has_source": ss.src != nil,
-				"has_stop":   ss.stop != nil,
-			})
-		}
-		s.mu.Unlock()
-		metrics := stream.GetCounters()
-		runtimeStats := stream.GetRuntimeStats()
-		out := map[string]any{
-			"status":          "ok",
-			"sessions":        sessCount,
-			"ndi":             map[string]any{"selected": name, "url": url},
-			"metrics":         metrics,
-			"runtime":         runtimeStats,
-			"sessions_detail": details,
-		}
-		if v, ok := metrics["frames_dropped"]; ok {
-			out["dropped_frames"] = v
-		}
-		_ = json.NewEncoder(w).Encode(out)
-	})
-	mux.HandleFunc("/frame", s.handleFramePNG)
-	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+	handle("/whep/ndi/", s.handleWHEPNDI)
+	// WHIP ingest: published streams become sources
+	handle("/whip", s.handleWHIP)
+	handle("/whip/", s.handleWHIP)
+	handle("/ndi/sources", s.handleNDISources)
+	handle("/ndi/select", s.handleNDISelect)
+	handle("/ndi/select_url", s.handleNDISelectURL)
+	handle("/ndi/probe", s.handleNDIProbe)
+	handle("/ndi/discovery/refresh", s.handleDiscoveryRefresh)
+	handle("/ndi/discovery/status", s.handleDiscoveryStatus)
+	handle("/sessions", s.handleSessions)
+	handle("/sessions/", s.handleSessions)
+	handle("/ndi/schedule", s.handleSchedule)
+	handle("/ndi/schedule/", s.handleSchedule)
+	handle("/ndi/failover", s.handleFailover)
+	handle("/config", s.handleConfig)
+	handle("/config/", s.handleConfig) // support trailing slash
+	handle("/config/ladder", s.handleLadder)
+	handle("/config/capabilities", s.handleCapabilities)
+	handle("/config.json", s.handleConfigJSON)
+	handle("/version", s.handleVersion)
+	handle("/health", s.handleHealth)
+	handle("/metrics", s.handleMetrics)
+	handle("/loadz", s.handleLoadz)
+	handle("/frame", s.handleFramePNG)
+	handle("/frame/", s.handleFramePNG)
+	admin("/admin/slo", s.handleSLO)
+	admin("/admin/loglevel", s.handleLogLevel)
+	admin("/admin/profile", s.handleProfile)
+	admin("/admin/support-bundle", s.handleSupportBundle)
+	admin("/admin/channels", s.handleChannels)
+	admin("/admin/channels/", s.handleChannels)
+	admin("/admin/links", s.handleLinks)
+	admin("/admin/links/", s.handleLinks)
+	admin("/admin/snapshot", s.handleSnapshot)
+	admin("/admin/benchmark", s.handleBenchmark)
+	admin("/admin/benchmark/", s.handleBenchmark)
+	handle("/mounts/", s.handleMounts)
+	handle("/mounts/composite", s.handleComposites)
+	handle("/mounts/composite/", s.handleComposites)
+	handle("/l/", s.handleLink)
+	handle("/", func(w http.ResponseWriter, r *http.Request) {
 		io.WriteString(w, indexHTML)
 	})
 }
 
+// GET /health reports sessions, NDI, pipelines and resource use as JSON.
+func (s *WhepServer) handleHealth(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	name, url := s.ndiName, s.ndiURL
+	sessCount := len(s.sessions)
+	// build detailed session info for leak detection
+	details := make([]map[string]any, 0, sessCount)
+	for id, ss := range s.sessions {
+		details = append(details, map[string]any{
+			"id":       id,
+			"codec":    ss.codec,
+			"created":  ss.created.UTC().Format(time.RFC3339),
+			"pc_state": ss.state,
+			"network":  ss.network(),
+		})
+	}
+	// Per-viewer queue losses by broadcaster, keyed like /metrics
+	shared := s.sharedHealthLocked()
+	bcs := map[string]*stream.SampleBroadcaster{}
+	if s.shareBC != nil {
+		bcs[sharedMetricsLabel] = s.shareBC
+	}
+	for key, m := range s.mounts {
+		if m.bc != nil {
+			bcs[key] = m.bc
+		}
+	}
+	s.mu.Unlock()
+	sinks := make(map[string][]stream.SinkStats, len(bcs))
+	for key, bc := range bcs {
+		sinks[key] = bc.Stats()
+	}
+	metrics := stream.GetCounters()
+	runtimeStats := stream.GetRuntimeStats()
+	status := "ok"
+	if s.draining.Load() {
+		status = "draining"
+	}
+	out := map[string]any{
+		"status":          status,
+		"sessions":        sessCount,
+		"ndi_state":       s.ndiState(),
+		"ndi":             map[string]any{"selected": name, "url": url, "sdk": ndi.SDK(), "resolution": s.sourceResolutionStats()},
+		"metrics":         metrics,
+		"runtime":         runtimeStats,
+		"hwaccel":         stream.GetHWAccelStatus(),
+		"sockets":         s.socketStats(),
+		"disk":            s.disk.healthStats(),
+		"recordings":      s.recordingStats(),
+		"whip":            s.whipStats(),
+		"rtsp":            stream.RTSPStats(),
+		"pipe":            s.pipeStatus(),
+		"benchmark":       s.benchStatus(),
+		"bitrate":         s.bitrateStats(),
+		"sessions_detail": details,
+		"sinks":           sinks,
+		"failover":        s.failoverStatus(),
+		"ndi_scheduler":   ndi.GetTempStats(),
+		"ndi_retries":     ndi.GetRetryStats(),
+		"resume":          s.resumes.health(),
+		"session_limits":  s.sessionLimitStats(),
+		"fps":             s.fpsStats(),
+		"keyint":          s.keyintStats(),
+		"stalled":         s.stallStats(),
+		"ndi_bandwidth":   s.bandwidthStats(),
+		"fallback":        s.fallbackHealth(),
+		"rate_control":    s.rateControlStats(),
+		"shared_pipeline": shared,
+	}
+	// Frames an encoder holds back for lag are not lost
+	out["dropped_frames"] = metrics["frames_dropped"]
+	_ = json.NewEncoder(w).Encode(out)
+}
+
 func (s *WhepServer) handleWHEPPost(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodOptions {
 		allowCORS(w, r)
@@ -193,101 +617,148 @@ func (s *WhepServer) handleWHEPPost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	if r.Method != http.MethodPost {
-		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, r, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "method not allowed", nil)
+		return
+	}
+	if s.refuseIfDraining(w, r) {
 		return
 	}
 	offerSDP, err := io.ReadAll(r.Body)
 	if err != nil || len(offerSDP) == 0 {
-		http.Error(w, "empty offer", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, errCodeInvalidSDP, "empty offer", nil)
 		return
 	}
-
-	// Basic Pion configuration; ICE servers optional via env at client side.
-	me := webrtc.MediaEngine{}
-	if err := me.RegisterDefaultCodecs(); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	// A retried POST gets its first session back, before any limit applies
+	replayed, finish := s.dedupeOffer(w, r, "/whep", offerSDP)
+	if replayed {
+		return
+	}
+	var created, location string
+	defer func() { finish(created, location) }()
+	if err := s.checkSocketBudget(); err != nil {
+		writeError(w, r, http.StatusServiceUnavailable, errCodeLimitExceeded, err.Error(), s.socketBudgetDetails())
 		return
 	}
-	api := webrtc.NewAPI(webrtc.WithMediaEngine(&me))
-	pc, err := api.NewPeerConnection(webrtc.Configuration{})
+	release, refused := s.reserveSession(sharedMetricsLabel)
+	if refused != nil {
+		refuseSessionLimit(w, r, refused)
+		return
+	}
+	defer release()
+	// Pick the codec from what the offer can receive. The shared pipeline has
+	// one encoder, so while it serves other sessions only its codec is on offer.
+	offered := offerCodecs(string(offerSDP))
+	prefs := s.codecPreference()
+	s.mu.Lock()
+	if s.shareBC != nil && s.shareCodec != "" {
+		if s.sharePending > 0 {
+			prefs = []string{s.shareCodec}
+		}
+		for _, ss := range s.sessions {
+			if ss.mountKey == "" {
+				prefs = []string{s.shareCodec}
+				break
+			}
+		}
+	}
+	// Keeps closeSession from stopping the pipeline until this session is
+	// registered; a lingering pipeline is this viewer's to rejoin
+	s.sharePending++
+	s.cancelSharedLingerLocked()
+	s.mu.Unlock()
+	defer s.releaseSharedClaim()
+	wantCodec, ok := offered.pick(prefs)
+	if !ok {
+		writeError(w, r, http.StatusNotAcceptable, errCodeCodecUnsupported, noCodecError(offered, prefs), noCodecDetails(offered, prefs))
+		return
+	}
+
+	// Basic Pion configuration; ICE servers optional via env at client side.
+	pc, hooks, err := s.newPeerConnection()
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, http.StatusInternalServerError, errCodeInternal, err.Error(), nil)
 		return
 	}
 
 	id := uuid.New().String()
 	log.Printf("WHEP session %s: created", id)
 
-	// Create a video track matching the selected codec
-	codec := strings.ToLower(s.cfg.Codec)
-	mime := webrtc.MimeTypeVP8
-	switch codec {
-	case "vp9":
-		mime = webrtc.MimeTypeVP9
-	case "av1":
-		mime = webrtc.MimeTypeAV1
-	default:
-		codec = "vp8"
-		mime = webrtc.MimeTypeVP8
+	// Ensure a shared encoder pipeline exists for this codec and current source.
+	// Started before the track so a hardware fallback is reflected in its codec.
+	if err := s.ensureSharedPipeline(wantCodec); err != nil {
+		_ = pc.Close()
+		writeError(w, r, http.StatusInternalServerError, errCodeInternal, err.Error(), nil)
+		return
 	}
-	videoTrack, err := webrtc.NewTrackLocalStaticSample(
-		webrtc.RTPCodecCapability{MimeType: mime}, "video", "pion",
-	)
+	s.mu.Lock()
+	codec := s.shareCodec
+	s.mu.Unlock()
+	if !offered[codec] {
+		// Hardware fallback landed on a codec this client can't decode
+		_ = pc.Close()
+		writeError(w, r, http.StatusNotAcceptable, errCodeCodecUnsupported, noCodecError(offered, []string{codec}), noCodecDetails(offered, []string{codec}))
+		return
+	}
+
+	// Create a video track matching the pipeline codec
+	videoTrack, err := webrtc.NewTrackLocalStaticSample(trackCapability(codec), "video", "pion")
 	if err != nil {
 		_ = pc.Close()
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, http.StatusInternalServerError, errCodeInternal, err.Error(), nil)
 		return
 	}
 	sender, err := pc.AddTrack(videoTrack)
 	if err != nil {
 		_ = pc.Close()
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, http.StatusInternalServerError, errCodeInternal, err.Error(), nil)
 		return
 	}
-
-	// Ensure a shared encoder pipeline exists for this codec and current source
-	if err := s.ensureSharedPipeline(codec); err != nil {
+	audioTrack, err := s.addAudioTrack(pc)
+	if err != nil {
 		_ = pc.Close()
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, http.StatusInternalServerError, errCodeInternal, err.Error(), nil)
 		return
 	}
-	// Attach this session's track to the broadcaster so it receives samples
+
+	// Attach this session's tracks to the broadcasters so they receive samples
 	s.mu.Lock()
-	var detach func()
-	if s.shareBC != nil {
-		detach = s.shareBC.Add(videoTrack)
-	} else {
-		detach = func() {}
-	}
+	detachV, detachA, err := attachTracks(s.shareBC, s.shareAudio, videoTrack, audioTrack, id)
 	s.mu.Unlock()
+	if err != nil {
+		_ = pc.Close()
+		writeError(w, r, http.StatusConflict, errCodeConflict, "shared pipeline closed while joining; retry", nil)
+		return
+	}
+	detach := func() { detachV(); detachA() }
 
-	// WHEP semantics: set remote offer, answer, and wait for ICE gather complete
+	// WHEP semantics: set remote offer, answer, and wait for the candidates
+	// the answer mode sends
 	if err := pc.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: string(offerSDP)}); err != nil {
 		_ = pc.Close()
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, errCodeInvalidSDP, err.Error(), nil)
 		return
 	}
 
 	answer, err := pc.CreateAnswer(nil)
 	if err != nil {
 		_ = pc.Close()
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, http.StatusInternalServerError, errCodeInternal, err.Error(), nil)
 		return
 	}
-	gatherComplete := webrtc.GatheringCompletePromise(pc)
-	if err := pc.SetLocalDescription(answer); err != nil {
+	if err := s.setLocalAnswer(pc, answer); err != nil {
 		_ = pc.Close()
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, http.StatusInternalServerError, errCodeInternal, err.Error(), nil)
 		return
 	}
-	<-gatherComplete
 
 	// Register session (no per-session encoder; we rely on shared pipeline)
-	// For legacy shared pipeline, avoid storing shared src/stop in session to prevent double-stop
-	sess := &session{id: id, pc: pc, sender: sender, track: videoTrack, stop: func() {}, src: nil, cancelFunc: nil, codec: codec, created: time.Now(), detach: detach}
+	sess := &session{id: id, pc: pc, sender: sender, track: videoTrack, codec: codec, created: time.Now(), detach: detach, rtcp: &rtcpStats{}, bwe: hooks.bwe, stats: hooks.stats}
+	s.startConnectTimeout(sess)
 	s.mu.Lock()
 	s.sessions[id] = sess
+	s.changes.bump()
 	s.mu.Unlock()
+	go s.readRTCP(sess)
 
 	pc.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
 		log.Printf("Session %s state: %s", id, state)
@@ -295,37 +766,28 @@ func (s *WhepServer) handleWHEPPost(w http.ResponseWriter, r *http.Request) {
 		s.mu.Lock()
 		if ss, ok := s.sessions[id]; ok {
 			ss.state = state.String()
+			s.changes.bump()
+			if state == webrtc.PeerConnectionStateConnected {
+				ss.connectCancel()
+			}
 		}
 		s.mu.Unlock()
+		if state == webrtc.PeerConnectionStateConnected {
+			// Samples written before DTLS came up were discarded, so a viewer
+			// joining a running encoder would wait for the next periodic
+			// keyframe; ask for one now
+			s.requestKeyframe(id)
+		}
 		if state == webrtc.PeerConnectionStateFailed || state == webrtc.PeerConnectionStateClosed || state == webrtc.PeerConnectionStateDisconnected {
 			s.closeSession(id)
 		}
 	})
 
-	// Add timeout for failed connections - clean up sessions that don't connect within 30 seconds
-	go func() {
-		timer := time.NewTimer(30 * time.Second)
-		defer timer.Stop()
-		<-timer.C
-		// Check if session is still in connecting state and clean it up
-		s.mu.Lock()
-		if sess, exists := s.sessions[id]; exists {
-			currentState := sess.pc.ConnectionState()
-			if currentState == webrtc.PeerConnectionStateNew || currentState == webrtc.PeerConnectionStateConnecting {
-				log.Printf("Session %s: timeout after 30s, cleaning up (state: %s)", id, currentState)
-				s.mu.Unlock()
-				s.closeSession(id)
-				return
-			}
-		}
-		s.mu.Unlock()
-	}()
-
 	allowCORS(w, r)
-	w.Header().Set("Content-Type", "application/sdp")
-	w.Header().Set("Location", fmt.Sprintf("/whep/%s", id))
-	w.WriteHeader(http.StatusCreated)
-	_, _ = io.WriteString(w, pc.LocalDescription().SDP)
+	created, location = id, s.resourceURL(r, "/whep/"+id)
+	w.Header().Set("Location", location)
+	s.setLoadHeader(w)
+	writeSDP(w, http.StatusCreated, pc.LocalDescription().SDP)
 }
 
 // handleWHEPNDI routes both mount creation (POST /whep/ndi/{key}) and session resource
@@ -340,19 +802,21 @@ func (s *WhepServer) handleWHEPNDI(w http.ResponseWriter, r *http.Request) {
 		// key := parts[0] // not needed; session close handles mount lookup
 		id := parts[2]
 		switch r.Method {
+		case http.MethodGet:
+			s.writeSessionAnswer(w, r, id)
+			return
 		case http.MethodPatch:
 			// Trickle-ICE noop for now
 			w.WriteHeader(http.StatusNoContent)
 			return
 		case http.MethodDelete:
-			s.closeSession(id)
-			w.WriteHeader(http.StatusNoContent)
+			s.deleteSession(w, r, id)
 			return
 		case http.MethodOptions:
 			w.WriteHeader(http.StatusNoContent)
 			return
 		default:
-			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			writeError(w, r, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "method not allowed", nil)
 			return
 		}
 	}
@@ -363,210 +827,419 @@ func (s *WhepServer) handleWHEPNDI(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	if r.Method != http.MethodPost {
-		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, r, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "method not allowed", nil)
 		return
 	}
 
 	key := strings.TrimSuffix(path, "/")
 	if key == "" {
-		http.Error(w, "missing source key", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, errCodeBadRequest, "missing source key", nil)
+		return
+	}
+	// A name, URL or differently cased key reaches the same mount
+	key = s.canonicalSourceKey(key)
+	if s.refuseIfDraining(w, r) {
 		return
 	}
-
 	offerSDP, err := io.ReadAll(r.Body)
+	if isDryRun(r) {
+		s.dryRunMount(w, r, key, offerSDP)
+		return
+	}
 	if err != nil || len(offerSDP) == 0 {
-		http.Error(w, "empty offer", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, errCodeInvalidSDP, "empty offer", nil)
+		return
+	}
+	// A retried POST gets its first session back, before any limit applies
+	replayed, finish := s.dedupeOffer(w, r, "/whep/ndi/"+key+"?"+r.URL.RawQuery, offerSDP)
+	if replayed {
+		return
+	}
+	var created, location string
+	defer func() { finish(created, location) }()
+	if open, st := s.mountAvailability(key, time.Now()); !open {
+		details := map[string]any{"key": key}
+		if st.OpensAt != nil {
+			details["nextAvailable"] = st.OpensAt
+		}
+		writeError(w, r, http.StatusForbidden, errCodeOffAir, "mount "+key+" is off air", details)
+		return
+	}
+	if err := s.checkSocketBudget(); err != nil {
+		writeError(w, r, http.StatusServiceUnavailable, errCodeLimitExceeded, err.Error(), s.socketBudgetDetails())
 		return
 	}
+	release, refused := s.reserveSession(key)
+	if refused != nil {
+		refuseSessionLimit(w, r, refused)
+		return
+	}
+	defer release()
 
 	// Parse variant constraints from query params
 	q := r.URL.Query()
-	wantW, wantH, wantFPS, wantBR := 0, 0, 0, 0
-	if v := q.Get("w"); v != "" {
-		if n, e := strconv.Atoi(v); e == nil && n > 0 {
-			wantW = n
-		}
+	variant, err := parseVariantQuery(q)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, errCodeBadRequest, err.Error(), nil)
+		return
 	}
-	if v := q.Get("h"); v != "" {
-		if n, e := strconv.Atoi(v); e == nil && n > 0 {
-			wantH = n
-		}
+	detailView, err := parseView(q, variant)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, errCodeBadRequest, err.Error(), nil)
+		return
 	}
-	if v := q.Get("fps"); v != "" {
-		if n, e := strconv.Atoi(v); e == nil && n > 0 {
-			wantFPS = n
+	// Pick the codec from what the offer can receive; mounts are keyed by it
+	offered := offerCodecs(string(offerSDP))
+	var wantCodec string
+	if p := s.whip.get(key); p != nil {
+		// A published stream goes out as it came in
+		if !offered[p.codec] {
+			writeError(w, r, http.StatusNotAcceptable, errCodeCodecUnsupported, noCodecError(offered, []string{p.codec}), noCodecDetails(offered, []string{p.codec}))
+			return
 		}
-	}
-	if v := q.Get("bitrateKbps"); v != "" {
-		if n, e := strconv.Atoi(v); e == nil && n > 0 {
-			wantBR = n
+		if detailView || !variant.Detail.Empty() {
+			writeError(w, r, http.StatusUnprocessableEntity, errCodeEncoderUnavailable, "a WHIP source is relayed as published; split encode (detail=) needs an encoder", map[string]string{"key": key})
+			return
+		}
+		if len(variant.Fallback) > 0 {
+			writeError(w, r, http.StatusUnprocessableEntity, errCodeEncoderUnavailable, "a WHIP source is relayed as published; fallback= needs an encoder", map[string]string{"key": key})
+			return
+		}
+		wantCodec = p.codec
+	} else {
+		var ok bool
+		if wantCodec, ok = s.mountCodec(w, r, offered); !ok {
+			return
 		}
 	}
+	if !variant.Detail.Empty() && !splitCodec(wantCodec) {
+		writeError(w, r, http.StatusUnprocessableEntity, errCodeEncoderUnavailable, "split encode (detail=) needs VP8 or VP9, not "+wantCodec, map[string]string{"codec": wantCodec})
+		return
+	}
 	// Ensure a mount exists for this source+variant
-	m, err := s.ensureMount(key, wantW, wantH, wantFPS, wantBR)
+	variant.Codec = wantCodec
+	m, err := s.ensureMount(key, variant, offered)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusNotFound)
+		if errors.Is(err, errSourceNotFound) {
+			writeError(w, r, http.StatusNotFound, errCodeSourceNotFound, err.Error(), map[string]string{"key": key})
+		} else if errors.Is(err, errCropOutside) || errors.Is(err, errFallbackAlias) {
+			writeError(w, r, http.StatusBadRequest, errCodeBadRequest, err.Error(), map[string]string{"key": key})
+		} else {
+			writeError(w, r, http.StatusServiceUnavailable, errCodeSourceUnavailable, err.Error(), map[string]string{"key": key})
+		}
 		return
 	}
+	// Keeps the mount from an idle teardown until the session is registered
+	defer m.release(func() { s.teardownMountIfIdle(m.key) })
+
+	m.mu.Lock()
+	ndi.NoteInterest(sourceID(m.name, m.url))
+	m.mu.Unlock()
 
 	// Build PC and attach track to mount broadcaster
-	me := webrtc.MediaEngine{}
-	if err := me.RegisterDefaultCodecs(); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	api := webrtc.NewAPI(webrtc.WithMediaEngine(&me))
-	pc, err := api.NewPeerConnection(webrtc.Configuration{})
+	pc, hooks, err := s.newPeerConnection()
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, http.StatusInternalServerError, errCodeInternal, err.Error(), nil)
 		return
 	}
 
 	id := uuid.New().String()
-	codec := strings.ToLower(s.cfg.Codec)
-	mime := webrtc.MimeTypeVP8
-	switch codec {
-	case "vp9":
-		mime = webrtc.MimeTypeVP9
-	case "av1":
-		mime = webrtc.MimeTypeAV1
-	default:
-		codec = "vp8"
+	// The mount's codec may come from a ladder rung or a hardware fallback
+	m.mu.Lock()
+	codec := m.codec
+	m.mu.Unlock()
+	if !offered[codec] {
+		_ = pc.Close()
+		writeError(w, r, http.StatusNotAcceptable, errCodeCodecUnsupported, noCodecError(offered, []string{codec}), noCodecDetails(offered, []string{codec}))
+		return
 	}
-	videoTrack, err := webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{MimeType: mime}, "video", "pion")
+	videoTrack, err := webrtc.NewTrackLocalStaticSample(trackCapability(codec), "video", "pion")
 	if err != nil {
 		_ = pc.Close()
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, http.StatusInternalServerError, errCodeInternal, err.Error(), nil)
 		return
 	}
 	sender, err := pc.AddTrack(videoTrack)
 	if err != nil {
 		_ = pc.Close()
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, http.StatusInternalServerError, errCodeInternal, err.Error(), nil)
 		return
 	}
 
-	// Attach to broadcaster
-	var detach func()
+	audioTrack, err := s.addAudioTrack(pc)
+	if err != nil {
+		_ = pc.Close()
+		writeError(w, r, http.StatusInternalServerError, errCodeInternal, err.Error(), nil)
+		return
+	}
+
+	// Attach to broadcasters. A mount torn down since it was claimed has
+	// closed them; the client retries and gets a fresh mount.
 	m.mu.Lock()
-	if m.bc != nil {
-		detach = m.bc.Add(videoTrack)
-	} else {
-		detach = func() {}
+	bc := m.bc
+	if detailView {
+		bc = m.detailBC
 	}
+	detachV, detachA, err := attachTracks(bc, m.audio, videoTrack, audioTrack, id)
 	m.mu.Unlock()
+	if err != nil {
+		_ = pc.Close()
+		writeError(w, r, http.StatusConflict, errCodeConflict, "mount "+m.key+" closed while joining; retry", map[string]string{"key": key})
+		return
+	}
+	detach := func() { detachV(); detachA() }
 
 	if err := pc.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: string(offerSDP)}); err != nil {
 		_ = pc.Close()
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, errCodeInvalidSDP, err.Error(), nil)
 		return
 	}
 	answer, err := pc.CreateAnswer(nil)
 	if err != nil {
 		_ = pc.Close()
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, http.StatusInternalServerError, errCodeInternal, err.Error(), nil)
 		return
 	}
-	gatherComplete := webrtc.GatheringCompletePromise(pc)
-	if err := pc.SetLocalDescription(answer); err != nil {
+	if err := s.setLocalAnswer(pc, answer); err != nil {
 		_ = pc.Close()
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, http.StatusInternalServerError, errCodeInternal, err.Error(), nil)
 		return
 	}
-	<-gatherComplete
 
-	// For mount sessions, do not retain shared src/stop on the session to avoid double stops
-	sess := &session{id: id, pc: pc, sender: sender, track: videoTrack, stop: func() {}, src: nil, cancelFunc: nil, codec: codec, created: time.Now(), detach: detach, mountKey: m.key}
+	sess := &session{id: id, pc: pc, sender: sender, track: videoTrack, codec: codec, created: time.Now(), detach: detach, mountKey: m.key, detail: detailView, rtcp: &rtcpStats{}, bwe: hooks.bwe, stats: hooks.stats}
+	s.startConnectTimeout(sess)
 	s.mu.Lock()
 	s.sessions[id] = sess
 	if mm := s.mounts[m.key]; mm != nil {
 		mm.addSession(id)
 	}
+	s.changes.bump()
 	s.mu.Unlock()
+	go s.readRTCP(sess)
 
 	pc.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
 		log.Printf("Session %s state: %s", id, state)
 		s.mu.Lock()
 		if ss, ok := s.sessions[id]; ok {
 			ss.state = state.String()
+			s.changes.bump()
+			if state == webrtc.PeerConnectionStateConnected {
+				ss.connectCancel()
+			}
 		}
 		s.mu.Unlock()
+		if state == webrtc.PeerConnectionStateConnected {
+			// Samples written before DTLS came up were discarded, so a viewer
+			// joining a running encoder would wait for the next periodic
+			// keyframe; ask for one now
+			s.requestKeyframe(id)
+		}
 		if state == webrtc.PeerConnectionStateFailed || state == webrtc.PeerConnectionStateClosed || state == webrtc.PeerConnectionStateDisconnected {
 			s.closeSession(id)
 		}
 	})
 
-	w.Header().Set("Content-Type", "application/sdp")
 	// Reflect actual encoder settings
-	m.mu.Lock()
-	actualW, actualH, actualFPS, actualBR := m.width, m.height, m.fps, m.bitrateKbps
-	m.mu.Unlock()
-	if actualW > 0 && actualH > 0 {
-		w.Header().Set("X-Resolution", fmt.Sprintf("%dx%d@%d", actualW, actualH, actualFPS))
+	writeMountHeaders(w, m)
+	created, location = id, s.resourceURL(r, fmt.Sprintf("/whep/ndi/%s/sessions/%s", key, id))
+	w.Header().Set("Location", location)
+	s.setLoadHeader(w)
+	writeSDP(w, http.StatusCreated, pc.LocalDescription().SDP)
+}
+
+// attachTracks adds a session's tracks to a pipeline's broadcasters. A nil
+// bc is a pipeline already torn down; like a closed one, it fails with
+// stream.ErrBroadcasterClosed and nothing stays attached.
+func attachTracks(bc *stream.SampleBroadcaster, audio *audioFeed, video, audioTrack *webrtc.TrackLocalStaticSample, id string) (detachV, detachA func(), err error) {
+	if bc == nil {
+		return nil, nil, stream.ErrBroadcasterClosed
 	}
-	if actualBR > 0 {
-		w.Header().Set("X-Bitrate-Kbps", fmt.Sprintf("%d", actualBR))
+	if detachV, err = bc.Add(video, stream.WithLabel(id)); err != nil {
+		return nil, nil, err
 	}
-	w.Header().Set("Location", fmt.Sprintf("/whep/ndi/%s/sessions/%s", key, id))
-	w.WriteHeader(http.StatusCreated)
-	_, _ = io.WriteString(w, pc.LocalDescription().SDP)
+	if detachA, err = audio.add(audioTrack); err != nil {
+		detachV()
+		return nil, nil, err
+	}
+	return detachV, detachA, nil
 }
 
-// ensureMount ensures a per-source shared pipeline exists for the given key.
-func (s *WhepServer) ensureMount(key string, wantW, wantH, wantFPS, wantBR int) (*ndiMount, error) {
+// ensureMount ensures a per-source shared pipeline exists for the given key
+// and variant, after canonicalizing the variant. A ladder rung's codec only
+// applies if offered has it. On success the caller holds a claim on the mount and must
+// call m.release once its session is registered or abandoned.
+func (s *WhepServer) ensureMount(key string, v mountVariant, offered codecSet) (*ndiMount, error) {
+	overloaded := s.overloaded()
 	s.mu.Lock()
-	// Compose composite key for variant reuse
-	if wantFPS <= 0 {
-		wantFPS = s.cfg.FPS
-		if wantFPS <= 0 {
-			wantFPS = 30
+	var compKey string
+	if p := s.whip.get(key); p != nil {
+		// Relayed as published: one mount per publisher, in its codec
+		v, compKey = mountVariant{Codec: p.codec}, key
+	} else {
+		v = s.canonicalVariantLocked(v, offered)
+		v.Fallback = slices.DeleteFunc(v.Fallback, func(k string) bool { return k == key })
+		compKey = s.mountKeyLocked(key, v)
+		// Under load a new encoder is started smaller, or not at all when a
+		// smaller one is running; joining a running mount costs nothing
+		if m := s.mounts[compKey]; overloaded && (m == nil || m.bc == nil) {
+			w, h := v.Width, v.Height
+			if v, compKey = s.downgradeLocked(key, v, offered); v.Width != w || v.Height != h {
+				log.Printf("Mount %s: load at -downgrade-load %d, %dx%d stepped down to %dx%d", key, s.cfg.DowngradeLoad, w, h, v.Width, v.Height)
+			}
 		}
 	}
-	if wantBR <= 0 {
-		wantBR = s.cfg.BitrateKbps
-	}
-	compKey := key
-	if wantW > 0 || wantH > 0 || wantFPS > 0 || wantBR > 0 {
-		compKey = fmt.Sprintf("%s|w%d|h%d|f%d|b%d", key, wantW, wantH, wantFPS, wantBR)
-	}
 	if m, ok := s.mounts[compKey]; ok && m.bc != nil {
+		// Claimed under s.mu, so an idle teardown can't take it from here on
+		m.mu.Lock()
+		m.pending++
+		m.mu.Unlock()
 		s.mu.Unlock()
+		<-m.ready
+		if m.startErr != nil {
+			return nil, m.startErr
+		}
 		return m, nil
 	}
-	// Resolve key to source info
-	idx := s.sourceIndex()
-	si, ok := idx[key]
+	si, alias, ok := s.mountSourceLocked(key)
+	var fb *mountFallback
+	if len(v.Fallback) > 0 {
+		fb = &mountFallback{sources: append([]string{key}, v.Fallback...), since: time.Now()}
+		// A mount whose own source isn't listed starts on the first
+		// fallback that is
+		for i := 1; !ok && i < len(fb.sources); i++ {
+			if si, alias, ok = s.mountSourceLocked(fb.sources[i]); ok {
+				fb.active, fb.cursor = i, i
+			}
+		}
+		if alias != "" {
+			s.mu.Unlock()
+			return nil, fmt.Errorf("%w: %s", errFallbackAlias, key)
+		}
+	}
 	if !ok {
 		s.mu.Unlock()
-		return nil, fmt.Errorf("source not found: %s", key)
+		return nil, fmt.Errorf("%w: %s", errSourceNotFound, key)
+	}
+	if err := s.checkCropLocked(si.Name, si.URL, v.Crop); err != nil {
+		s.mu.Unlock()
+		return nil, err
 	}
 	// Create new mount and start pipeline
-	m := &ndiMount{key: compKey, name: si.Name, url: si.URL, codec: strings.ToLower(s.cfg.Codec), bc: stream.NewSampleBroadcaster(), sessions: map[string]struct{}{}, width: wantW, height: wantH, fps: wantFPS, bitrateKbps: wantBR, created: time.Now()}
+	m := &ndiMount{key: compKey, name: si.Name, url: si.URL, codec: v.Codec, bc: stream.NewSampleBroadcaster(s.cfg.Queue.Options()...), audio: s.newAudioFeed(), sessions: map[string]struct{}{}, width: v.Width, height: v.Height, fps: v.FPS, bitrateKbps: v.BitrateKbps, aspect: v.Aspect, filter: v.Filter, keyint: v.Keyint, rc: v.RC, crf: v.CRF, vp9: v.VP9, av1: v.AV1, detail: v.Detail, crop: v.Crop, orient: v.Orient, bandwidth: v.Bandwidth, query: v.Query.Encode(), alias: alias, fb: fb, created: time.Now(), ready: make(chan struct{}), pending: 1}
+	m.bc.SetKeyframeRequester(func() {
+		m.mu.Lock()
+		p := m.pipe
+		m.mu.Unlock()
+		if p != nil {
+			p.ForceKeyframe()
+		}
+	})
+	if !v.Detail.Empty() {
+		m.detailBC = stream.NewSampleBroadcaster(s.cfg.Queue.Options()...)
+		m.detailBC.SetKeyframeRequester(func() {
+			m.mu.Lock()
+			p := m.detailPipe
+			m.mu.Unlock()
+			if p != nil {
+				p.ForceKeyframe()
+			}
+		})
+	}
 	s.mounts[compKey] = m
+	s.changes.bump()
 	s.mu.Unlock()
 
-	// Build NDI Source (nil for Splash synthetic)
-	var src stream.Source
-	if strings.EqualFold(si.Name, "splash") || strings.EqualFold(si.URL, "ndi://Splash") {
-		src = nil
-	} else if nd, err := stream.NewNDISource(si.URL, si.Name); err == nil {
-		// If specific output size requested via mount params, ask source to scale to it
-		if wantW > 0 && wantH > 0 {
-			nd.SetOutputSize(wantW, wantH)
+	if err := s.startMountPipeline(m); err != nil {
+		// Don't leave a mount without an encoder for the next request to reuse
+		s.mu.Lock()
+		if s.mounts[compKey] == m {
+			delete(s.mounts, compKey)
+			s.changes.bump()
 		}
-		src = nd
-	} else {
-		// fall back to synthetic if unavailable
-		src = nil
+		s.mu.Unlock()
+		m.bc.Close()
+		if m.detailBC != nil {
+			m.detailBC.Close()
+		}
+		m.audio.Close()
+		m.startErr = err
+		close(m.ready)
+		return nil, err
 	}
+	close(m.ready)
+	m.mu.Lock()
+	// Schedule provisional teardown if no session attaches shortly
+	if len(m.sessions) == 0 && m.noSessTimer == nil {
+		keyForTimer := m.key
+		m.noSessTimer = time.AfterFunc(10*time.Second, func() { s.teardownMountIfIdle(keyForTimer) })
+	}
+	m.mu.Unlock()
+	return m, nil
+}
 
-	fps := m.fps
-	if fps <= 0 {
-		fps = s.cfg.FPS
-		if fps <= 0 {
-			fps = 30
+// mountSourceLocked resolves a mount's source key. alias is set for a
+// channel or failover alias, whose mounts follow its retargets. Caller
+// holds s.mu.
+func (s *WhepServer) mountSourceLocked(key string) (si struct{ Name, URL string }, alias string, ok bool) {
+	idx := s.sourceIndex()
+	if si, ok = idx[sourceKeyAlias(key)]; ok {
+		if s.isChannel(key) {
+			// Follows the channel across retargets
+			alias = key
 		}
+		return si, alias, true
+	}
+	// A failover alias follows whichever source its rule has on air
+	if si, ok = s.failoverAlias(key); ok {
+		return si, key, true
 	}
+	return si, "", false
+}
+
+// mountCodec picks a mount's codec from what the offer can receive, narrowed
+// by codec= / backend= to a pair this build and host can encode. It answers
+// the request itself when there is none.
+func (s *WhepServer) mountCodec(w http.ResponseWriter, r *http.Request, offered codecSet) (string, bool) {
+	q := r.URL.Query()
+	prefs := s.codecPreference()
+	if q.Get("codec") != "" || q.Get("backend") != "" {
+		c, err := stream.ResolveEncoder(q.Get("codec"), q.Get("backend"))
+		if err != nil {
+			writeError(w, r, http.StatusUnprocessableEntity, errCodeEncoderUnavailable, err.Error(), map[string]any{"encoders": stream.Capabilities()})
+			return "", false
+		}
+		if c != "" {
+			prefs = []string{c}
+		} else {
+			// Software only
+			prefs = slices.DeleteFunc(prefs, func(p string) bool { return p == "h264" })
+		}
+	}
+	codec, ok := offered.pick(prefs)
+	if !ok {
+		writeError(w, r, http.StatusNotAcceptable, errCodeCodecUnsupported, noCodecError(offered, prefs), noCodecDetails(offered, prefs))
+		return "", false
+	}
+	return codec, true
+}
+
+// startMountPipeline opens m's source and starts its encoder into m.bc, using
+// the mount's variant settings and the server's current encoder config.
+func (s *WhepServer) startMountPipeline(m *ndiMount) (err error) {
+	m.mu.Lock()
+	key, codec, wantW, wantH, aspect, filter, split, crop, orient := m.key, m.codec, m.width, m.height, m.aspect, m.filter, !m.detail.Empty(), m.crop, m.orient
+	vp9, av1, bandwidth := m.vp9, m.av1, m.bandwidth
+	name, url := m.name, m.url
+	// A requested output size is applied by the source itself, unless an
+	// aspect policy, a detail crop, a region of interest, a turn or a
+	// chosen filter does the scaling
+	openW, openH := m.openSize()
+	m.mu.Unlock()
+	if p := s.whip.byURL(url); p != nil {
+		return s.startRelay(m, p)
+	}
+	ec := s.encoderCfg()
+	fps := m.fps.Or(ec.fps())
 	width := m.width
 	if width <= 0 {
 		width = s.cfg.Width
@@ -577,134 +1250,222 @@ func (s *WhepServer) ensureMount(key string, wantW, wantH, wantFPS, wantBR int)
 	}
 	br := m.bitrateKbps
 	if br <= 0 {
-		br = s.cfg.BitrateKbps
-	}
-	var stopper interface{ Stop() }
-	var err error
-	switch m.codec {
-	case "av1":
-		stopper, err = stream.StartAV1Pipeline(stream.PipelineConfig{Width: width, Height: height, FPS: fps, BitrateKbps: br, Source: src, Track: m.bc})
-	case "vp9":
-		stopper, err = stream.StartVP9Pipeline(stream.PipelineConfig{Width: width, Height: height, FPS: fps, BitrateKbps: br, Source: src, Track: m.bc})
-	default:
-		df := s.cfg.VP8Dropframe
-		if src == nil {
-			df = 0
-		}
-		stopper, err = stream.StartVP8Pipeline(stream.PipelineConfig{Width: width, Height: height, FPS: fps, BitrateKbps: br, Source: src, Track: m.bc, VP8Speed: s.cfg.VP8Speed, VP8Dropframe: df})
+		br = ec.BitrateKbps
+	}
+	var src stream.Source
+	if crop.Empty() {
+		src, err = s.openSourceBandwidth(name, url, openW, openH, fps, bandwidth)
+	} else {
+		// Crop mounts of one source share its native receiver
+		src, err = s.openCropSource(name, url, fps)
 	}
+	defer stopOnError(&src, &err)
 	if err != nil {
-		return nil, fmt.Errorf("mount start: %w", err)
+		// fall back to synthetic if unavailable
+		log.Printf("Mount %s: source unavailable (%v), using synthetic", key, err)
+		src = nil
+	}
+	// Audio bypasses the switch and aspect wrappers
+	audioSrc, sw := src, (*stream.SwitchSource)(nil)
+	if src != nil {
+		sw = stream.NewSwitchSource(src)
+		src = sw
+	}
+	// The detail encoder crops the native frame the main one scales
+	var native stream.Source
+	if sw != nil {
+		native = sw
+	}
+	if src != nil && !stream.IsSynthetic(src) && !crop.Empty() {
+		// The crop comes first, then the turn; w/h and fit apply to what
+		// they leave
+		if aspect != stream.AspectAdapt {
+			src = stream.NewAspectSource(orientedRegion(src, crop, 0, 0, filter, orient), aspect, wantW, wantH, filter)
+		} else {
+			src = orientedRegion(src, crop, wantW, wantH, filter, orient)
+		}
+	} else if src != nil && !stream.IsSynthetic(src) && aspect != stream.AspectAdapt {
+		as := stream.NewAspectSource(src, aspect, wantW, wantH, filter)
+		as.SetOrientation(orient)
+		src = as
+	} else if src != nil && (split || filter != "" || !orient.IsZero()) && wantW > 0 && wantH > 0 {
+		src = orientedRegion(src, stream.Region{}, wantW, wantH, filter, orient)
+	} else if src != nil && !stream.IsSynthetic(src) && !orient.IsZero() {
+		// Turned at the source's own size, following it
+		src = orientedRegion(src, stream.Region{}, 0, 0, filter, orient)
+	}
+	fps = s.pipelineFPS(m.fps, src)
+	df := ec.VP8Dropframe
+	if stream.IsSynthetic(src) {
+		df = 0
+	}
+	stopper, codec, err := s.startPipelineWithFallback(codec, stream.PipelineConfig{Width: width, Height: height, FPS: fps, BitrateKbps: br, Source: src, Track: m.bc, VP8Speed: ec.VP8Speed, VP8Dropframe: df, Keyint: m.keyint, RateControl: m.rc, CRF: m.crf, VP9: &vp9, AV1: &av1, MetricsLabel: m.key, Queue: s.cfg.Queue})
+	if err != nil {
+		return fmt.Errorf("mount start: %w", err)
 	}
+	var detailPipe videoPipeline
+	if split {
+		if detailPipe, err = s.startDetailPipeline(m, native, codec, fps, br, df); err != nil {
+			stopper.Stop()
+			return fmt.Errorf("mount start: %w", err)
+		}
+	}
+	m.mu.Lock()
+	m.codec, m.encFPS, m.detailPipe = codec, fps, detailPipe
+	m.mu.Unlock()
+	m.audio.setSource(audioSrc)
 
-	// Monitor source resolution for restarts
+	// Follow source resolution changes. Behind an aspect policy this fires
+	// once, when the output size locks to the first frame.
 	ctx, cancel := context.WithCancel(context.Background())
-	// If explicit target width/height provided, we avoid restarting on source resolution change;
-	// the encoder/pipeline handles scaling. Otherwise, monitor and restart.
+	// With an explicit target width/height the source scales, so the
+	// encoder size never changes
 	if src != nil && (m.width == 0 || m.height == 0) {
-		if reporter, ok := src.(interface {
-			Last() ([]byte, int, int, bool)
-		}); ok {
-			currentW, currentH := s.cfg.Width, s.cfg.Height
-			go func() {
-				ticker := time.NewTicker(1 * time.Second)
-				defer ticker.Stop()
-				for {
-					select {
-					case <-ctx.Done():
-						return
-					case <-ticker.C:
-						_, w0, h0, ok := reporter.Last()
-						if !ok || w0 <= 0 || h0 <= 0 {
-							continue
-						}
-						if w0 == currentW && h0 == currentH {
-							continue
-						}
-						log.Printf("Pipeline(mount %s): source resolution change %dx%d -> %dx%d, restarting", key, currentW, currentH, w0, h0)
-						if stopper != nil {
-							stopper.Stop()
-						}
-						var p interface{ Stop() }
-						var e error
-						switch m.codec {
-						case "vp9":
-							p, e = stream.StartVP9Pipeline(stream.PipelineConfig{Width: w0, Height: h0, FPS: fps, BitrateKbps: s.cfg.BitrateKbps, Source: src, Track: m.bc})
-						case "av1":
-							p, e = stream.StartAV1Pipeline(stream.PipelineConfig{Width: w0, Height: h0, FPS: fps, BitrateKbps: s.cfg.BitrateKbps, Source: src, Track: m.bc})
-						default:
-							p, e = stream.StartVP8Pipeline(stream.PipelineConfig{Width: w0, Height: h0, FPS: fps, BitrateKbps: s.cfg.BitrateKbps, Source: src, Track: m.bc, VP8Speed: s.cfg.VP8Speed, VP8Dropframe: s.cfg.VP8Dropframe})
-						}
-						if e != nil {
-							log.Printf("Pipeline(mount %s) restart failed: %v", key, e)
-							continue
-						}
-						stopper = p
-						// Update mount stop handle to point to the new pipeline
-						m.mu.Lock()
-						m.stop = stopper.Stop
-						m.mu.Unlock()
-						currentW, currentH = w0, h0
-					}
-				}
-			}()
-		}
+		watchSourceSize(ctx, src, s.cfg.Width, s.cfg.Height, "mount "+key, func(w, h int) bool {
+			p, e := resizePipeline(stopper, codec, stream.PipelineConfig{Width: w, Height: h, FPS: fps, BitrateKbps: br, Source: src, Track: m.bc, VP8Speed: ec.VP8Speed, VP8Dropframe: ec.VP8Dropframe, Keyint: m.keyint, RateControl: m.rc, CRF: m.crf, VP9: &vp9, AV1: &av1, MetricsLabel: m.key, Queue: s.cfg.Queue}, "mount "+key)
+			if e != nil {
+				log.Printf("Pipeline(mount %s) restart failed: %v", key, e)
+				return false
+			}
+			if p != stopper {
+				stopper = p
+				// Update mount stop handle to point to the new pipeline
+				m.mu.Lock()
+				m.stop, m.pipe = stopper.Stop, stopper
+				m.mu.Unlock()
+			}
+			return true
+		})
 	}
 	m.mu.Lock()
-	m.src = src
-	m.stop = stopper.Stop
+	m.src, m.sw = src, sw
+	m.stop, m.pipe = stopper.Stop, stopper
 	m.cancel = cancel
-	// Schedule provisional teardown if no session attaches shortly
-	if len(m.sessions) == 0 && m.noSessTimer == nil {
-		keyForTimer := m.key
-		m.noSessTimer = time.AfterFunc(10*time.Second, func() { s.teardownMountIfIdle(keyForTimer) })
-	}
 	m.mu.Unlock()
-	return m, nil
+	return nil
 }
 
-// teardownMountIfIdle tears down a mount when it has become idle.
-func (s *WhepServer) teardownMountIfIdle(key string) {
-	s.mu.Lock()
-	m := s.mounts[key]
-	s.mu.Unlock()
-	if m == nil {
-		return
+// restartMount stops m's encoder and source and starts them again with the
+// current config; sessions stay attached to m.bc throughout.
+func (s *WhepServer) restartMount(m *ndiMount) error {
+	m.mu.Lock()
+	if m.bc == nil {
+		m.mu.Unlock()
+		return nil
 	}
-	if m.refCount() > 0 {
-		return
+	if m.cancel != nil {
+		m.cancel()
+	}
+	if m.stop != nil {
+		m.stop()
+	}
+	if m.detailPipe != nil {
+		m.detailPipe.Stop()
 	}
+	if m.src != nil {
+		m.src.Stop()
+	}
+	m.stop, m.pipe, m.detailPipe, m.src, m.sw, m.cancel = nil, nil, nil, nil, nil, nil
+	m.mu.Unlock()
+	return s.startMountPipeline(m)
+}
+
+// teardown stops m's monitor, encoder and source and closes its fanout.
+func (m *ndiMount) teardown() {
+	// The encoders finish first, outside m.mu: their last frames reach a
+	// recording before the fanout closes, and a hot-swap callback on an
+	// encode loop may be waiting for m.mu
 	m.mu.Lock()
 	if m.cancel != nil {
 		m.cancel()
 	}
+	pipe, detailPipe := m.pipe, m.detailPipe
+	m.mu.Unlock()
+	finishPipeline(pipe, "mount "+m.key)
+	finishPipeline(detailPipe, "mount "+m.key+" detail")
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.idleTimer != nil {
+		m.idleTimer.Stop()
+		m.idleTimer = nil
+	}
+	if m.cancel != nil {
+		m.cancel()
+	}
 	if m.stop != nil {
 		m.stop()
 	}
+	if m.detailPipe != nil {
+		m.detailPipe.Stop()
+	}
 	if m.src != nil {
 		m.src.Stop()
 	}
 	if m.bc != nil {
 		m.bc.Close()
 	}
-	m.bc, m.stop, m.src, m.cancel = nil, nil, nil, nil
-	m.mu.Unlock()
-	log.Printf("Mount %s torn down (idle)", key)
-	// Remove mount entry to avoid stale references
+	if m.detailBC != nil {
+		m.detailBC.Close()
+	}
+	if m.rec != nil {
+		m.rec.finish("mount closed")
+		m.rec = nil
+	}
+	m.audio.Close()
+	m.bc, m.audio, m.stop, m.pipe, m.src, m.sw, m.cancel = nil, nil, nil, nil, nil, nil, nil
+	m.detailBC, m.detailPipe = nil, nil
+}
+
+// teardownMountIfIdle tears down a mount when it has become idle. The
+// check and the removal happen together under s.mu, which ensureMount
+// holds while claiming a mount, so a request either claims the mount
+// before this runs and keeps it alive, or misses it and starts a new one.
+func (s *WhepServer) teardownMountIfIdle(key string) {
 	s.mu.Lock()
+	m := s.mounts[key]
+	if m == nil {
+		s.mu.Unlock()
+		return
+	}
+	m.mu.Lock()
+	idle := len(m.sessions) == 0 && m.pending == 0 && m.rec == nil
+	m.mu.Unlock()
+	if !idle {
+		s.mu.Unlock()
+		return
+	}
 	delete(s.mounts, key)
+	s.changes.bump()
 	s.mu.Unlock()
+	m.teardown()
+	log.Printf("Mount %s torn down (idle)", key)
 }
 
-// sourceIndex returns a key->(Name,URL) mapping including synthetic Splash.
+// sourceIndex returns a key->(Name,URL) mapping including built-in sources such as Splash.
 func (s *WhepServer) sourceIndex() map[string]struct{ Name, URL string } {
 	out := map[string]struct{ Name, URL string }{}
-	// Splash synthetic
-	out[slugKey("Splash", "ndi://Splash")] = struct{ Name, URL string }{"Splash", "ndi://Splash"}
+	// Built-in sources (Splash)
+	for _, si := range listedSources() {
+		out[slugKey(si.Name, si.URL)] = si
+	}
 	for _, si := range ndi.GetCachedSources() {
 		key := slugKey(si.Name, si.URL)
 		out[key] = struct{ Name, URL string }{Name: si.Name, URL: si.URL}
 	}
+	for _, p := range s.whip.list() {
+		out[p.key] = struct{ Name, URL string }{Name: "WHIP " + p.id, URL: p.url()}
+	}
+	s.rtsp.index(out)
+	s.composites.index(out)
+	if s.pipe != nil {
+		out[pipeScheme] = pipeListing
+	}
+	if s.cfg.Slate != "" {
+		out[slateScheme] = slateListing
+	}
+	// Channels win over a source that happens to share the key
+	s.channelIndex(out)
 	return out
 }
 
@@ -741,133 +1502,83 @@ func slugKey(name, url string) string {
 
 // ensureSharedPipeline ensures there is a single encoder running that writes to a
 // broadcaster, so multiple sessions can reuse the same encoded frames.
-func (s *WhepServer) ensureSharedPipeline(codec string) error {
+func (s *WhepServer) ensureSharedPipeline(codec string) (err error) {
 	s.mu.Lock()
 	// Tear down if codec mismatch
 	if s.shareBC != nil && s.shareCodec != "" && s.shareCodec != codec {
-		if s.shareCancel != nil {
-			s.shareCancel()
-		}
-		if s.shareStop != nil {
-			s.shareStop()
-		}
-		if s.shareSrc != nil {
-			s.shareSrc.Stop()
-		}
-		s.shareBC.Close()
-		s.shareBC, s.shareStop, s.shareSrc, s.shareCodec, s.shareCancel = nil, nil, nil, "", nil
+		s.stopSharedLocked()
 	}
 	if s.shareBC != nil {
 		s.mu.Unlock()
 		return nil
 	}
-	bc := stream.NewSampleBroadcaster()
-	// Snapshot selection
-	ndiURL, ndiName := s.ndiURL, s.ndiName
-	s.mu.Unlock()
-	if ndiURL == "" {
-		ndiURL = os.Getenv("NDI_SOURCE_URL")
-	}
-	if ndiName == "" {
-		ndiName = os.Getenv("NDI_SOURCE")
-	}
-	var src stream.Source
-	if ndiURL != "" || ndiName != "" {
-		if strings.EqualFold(ndiName, "splash") || strings.EqualFold(ndiURL, "ndi://splash") {
-			log.Printf("Using fake NDI source 'Splash' -> synthetic")
-			src = nil
-		} else if nd, err := stream.NewNDISource(ndiURL, ndiName); err == nil {
-			log.Printf("Using NDI source (url=%v, name=%v)", ndiURL != "", ndiName)
-			// Pre-scale to configured pipeline size if provided
-			if s.cfg.Width > 0 && s.cfg.Height > 0 {
-				nd.SetOutputSize(s.cfg.Width, s.cfg.Height)
-			}
-			src = nd
-		} else {
-			log.Printf("NDI source unavailable (%v), falling back to synthetic", err)
+	bc := stream.NewSampleBroadcaster(s.cfg.Queue.Options()...)
+	bc.SetKeyframeRequester(func() {
+		s.mu.Lock()
+		p := s.sharePipe
+		s.mu.Unlock()
+		if p != nil {
+			p.ForceKeyframe()
 		}
+	})
+	s.mu.Unlock()
+	ec := s.encoderCfg()
+	fps := ec.fps()
+	// Pre-scale to configured pipeline size if provided
+	ow, oh := s.sharedOpenSize()
+	src, res := s.openSharedSource(ow, oh, fps)
+	defer stopOnError(&src, &err)
+	s.mu.Lock()
+	s.shareResolution = res
+	s.mu.Unlock()
+	// Wrap the source so scheduled switches can cut to another without a
+	// restart; audio follows the underlying source
+	inner, sw := src, (*stream.SwitchSource)(nil)
+	if src != nil {
+		sw = stream.NewSwitchSource(src)
+		src = s.orientShared(sw)
 	}
-	fps := s.cfg.FPS
-	if fps <= 0 {
-		fps = 30
-	}
+	fps = s.pipelineFPS(ec.FPS, src)
 	// Start pipeline -> broadcaster
-	var stopper interface{ Stop() }
-	var err error
-	switch codec {
-	case "av1":
-		stopper, err = stream.StartAV1Pipeline(stream.PipelineConfig{Width: s.cfg.Width, Height: s.cfg.Height, FPS: fps, BitrateKbps: s.cfg.BitrateKbps, Source: src, Track: bc})
-	case "vp9":
-		stopper, err = stream.StartVP9Pipeline(stream.PipelineConfig{Width: s.cfg.Width, Height: s.cfg.Height, FPS: fps, BitrateKbps: s.cfg.BitrateKbps, Source: src, Track: bc})
-	default:
-		df := s.cfg.VP8Dropframe
-		if src == nil {
-			df = 0
-		}
-		stopper, err = stream.StartVP8Pipeline(stream.PipelineConfig{Width: s.cfg.Width, Height: s.cfg.Height, FPS: fps, BitrateKbps: s.cfg.BitrateKbps, Source: src, Track: bc, VP8Speed: s.cfg.VP8Speed, VP8Dropframe: df})
+	df := ec.VP8Dropframe
+	if stream.IsSynthetic(src) {
+		df = 0
 	}
+	stopper, codec, err := s.startPipelineWithFallback(codec, stream.PipelineConfig{Width: s.cfg.Width, Height: s.cfg.Height, FPS: fps, BitrateKbps: ec.BitrateKbps, Source: src, Track: bc, VP8Speed: ec.VP8Speed, VP8Dropframe: df, Keyint: s.cfg.Keyint, RateControl: s.cfg.RateControl, CRF: s.cfg.CRF, VP9: s.cfg.VP9, AV1: s.cfg.AV1, MetricsLabel: sharedMetricsLabel, Queue: s.cfg.Queue})
 	if err != nil {
 		return fmt.Errorf("shared pipeline start: %w", err)
 	}
-	// Monitor for source resolution changes
+	audio := s.newAudioFeed()
+	audio.setSource(inner)
+	// Follow source resolution changes
 	ctx, cancel := context.WithCancel(context.Background())
 	if src != nil {
-		if reporter, ok := src.(interface {
-			Last() ([]byte, int, int, bool)
-		}); ok {
-			currentW, currentH := s.cfg.Width, s.cfg.Height
-			go func() {
-				ticker := time.NewTicker(1 * time.Second)
-				defer ticker.Stop()
-				for {
-					select {
-					case <-ctx.Done():
-						return
-					case <-ticker.C:
-						_, w0, h0, ok := reporter.Last()
-						if !ok || w0 <= 0 || h0 <= 0 {
-							continue
-						}
-						if w0 == currentW && h0 == currentH {
-							continue
-						}
-						log.Printf("Pipeline(shared): source resolution change detected %dx%d -> %dx%d, restarting encoder", currentW, currentH, w0, h0)
-						if stopper != nil {
-							stopper.Stop()
-						}
-						var p interface{ Stop() }
-						var e error
-						switch codec {
-						case "vp9":
-							p, e = stream.StartVP9Pipeline(stream.PipelineConfig{Width: w0, Height: h0, FPS: fps, BitrateKbps: s.cfg.BitrateKbps, Source: src, Track: bc})
-						case "av1":
-							p, e = stream.StartAV1Pipeline(stream.PipelineConfig{Width: w0, Height: h0, FPS: fps, BitrateKbps: s.cfg.BitrateKbps, Source: src, Track: bc})
-						default:
-							p, e = stream.StartVP8Pipeline(stream.PipelineConfig{Width: w0, Height: h0, FPS: fps, BitrateKbps: s.cfg.BitrateKbps, Source: src, Track: bc, VP8Speed: s.cfg.VP8Speed, VP8Dropframe: s.cfg.VP8Dropframe})
-						}
-						if e != nil {
-							log.Printf("Pipeline(shared) restart failed: %v", e)
-							continue
-						}
-						stopper = p
-						s.mu.Lock()
-						s.shareStop = stopper.Stop
-						s.mu.Unlock()
-						currentW, currentH = w0, h0
-					}
-				}
-			}()
-		}
+		watchSourceSize(ctx, src, s.cfg.Width, s.cfg.Height, "shared", func(w, h int) bool {
+			p, e := resizePipeline(stopper, codec, stream.PipelineConfig{Width: w, Height: h, FPS: fps, BitrateKbps: ec.BitrateKbps, Source: src, Track: bc, VP8Speed: ec.VP8Speed, VP8Dropframe: ec.VP8Dropframe, Keyint: s.cfg.Keyint, RateControl: s.cfg.RateControl, CRF: s.cfg.CRF, VP9: s.cfg.VP9, AV1: s.cfg.AV1, MetricsLabel: sharedMetricsLabel, Queue: s.cfg.Queue}, "shared")
+			if e != nil {
+				log.Printf("Pipeline(shared) restart failed: %v", e)
+				return false
+			}
+			if p != stopper {
+				stopper = p
+				s.mu.Lock()
+				s.shareStop, s.sharePipe = stopper.Stop, stopper
+				s.mu.Unlock()
+			}
+			return true
+		})
 	}
 	s.mu.Lock()
-	s.shareBC, s.shareStop, s.shareSrc, s.shareCodec, s.shareCancel = bc, stopper.Stop, src, codec, cancel
+	s.shareBC, s.shareStop, s.sharePipe, s.shareSrc, s.shareCodec, s.shareCancel, s.shareAudio = bc, stopper.Stop, stopper, src, codec, cancel, audio
+	s.shareSwitch, s.shareFPS = sw, fps
+	s.changes.bump()
 	s.mu.Unlock()
 	return nil
 }
 
 // restartSharedPipeline applies the current NDI selection to the running shared pipeline.
 // If no pipeline exists, it is a no-op.
-func (s *WhepServer) restartSharedPipeline() error {
+func (s *WhepServer) restartSharedPipeline() (err error) {
 	s.mu.Lock()
 	if s.shareBC == nil {
 		s.mu.Unlock()
@@ -884,99 +1595,52 @@ func (s *WhepServer) restartSharedPipeline() error {
 	if s.shareSrc != nil {
 		s.shareSrc.Stop()
 	}
-	s.shareStop, s.shareSrc, s.shareCancel = nil, nil, nil
-	bc := s.shareBC
-	ndiURL, ndiName := s.ndiURL, s.ndiName
+	s.shareStop, s.sharePipe, s.shareSrc, s.shareCancel, s.shareSwitch = nil, nil, nil, nil, nil
+	bc, audio := s.shareBC, s.shareAudio
 	s.mu.Unlock()
-	if ndiURL == "" {
-		ndiURL = os.Getenv("NDI_SOURCE_URL")
-	}
-	if ndiName == "" {
-		ndiName = os.Getenv("NDI_SOURCE")
-	}
-	var src stream.Source
-	if ndiURL != "" || ndiName != "" {
-		if strings.EqualFold(ndiName, "splash") || strings.EqualFold(ndiURL, "ndi://splash") {
-			src = nil
-		} else if nd, err := stream.NewNDISource(ndiURL, ndiName); err == nil {
-			if s.cfg.Width > 0 && s.cfg.Height > 0 {
-				nd.SetOutputSize(s.cfg.Width, s.cfg.Height)
-			}
-			src = nd
-		}
+	ec := s.encoderCfg()
+	fps := ec.fps()
+	ow, oh := s.sharedOpenSize()
+	src, res := s.openSharedSource(ow, oh, fps)
+	defer stopOnError(&src, &err)
+	s.mu.Lock()
+	s.shareResolution = res
+	s.mu.Unlock()
+	inner, sw := src, (*stream.SwitchSource)(nil)
+	if src != nil {
+		sw = stream.NewSwitchSource(src)
+		src = s.orientShared(sw)
 	}
-	fps := s.cfg.FPS
-	if fps <= 0 {
-		fps = 30
-	}
-	var stopper interface{ Stop() }
-	var err error
-	switch codec {
-	case "av1":
-		stopper, err = stream.StartAV1Pipeline(stream.PipelineConfig{Width: s.cfg.Width, Height: s.cfg.Height, FPS: fps, BitrateKbps: s.cfg.BitrateKbps, Source: src, Track: bc})
-	case "vp9":
-		stopper, err = stream.StartVP9Pipeline(stream.PipelineConfig{Width: s.cfg.Width, Height: s.cfg.Height, FPS: fps, BitrateKbps: s.cfg.BitrateKbps, Source: src, Track: bc})
-	default:
-		df := s.cfg.VP8Dropframe
-		if src == nil {
-			df = 0
-		}
-		stopper, err = stream.StartVP8Pipeline(stream.PipelineConfig{Width: s.cfg.Width, Height: s.cfg.Height, FPS: fps, BitrateKbps: s.cfg.BitrateKbps, Source: src, Track: bc, VP8Speed: s.cfg.VP8Speed, VP8Dropframe: df})
+	fps = s.pipelineFPS(ec.FPS, src)
+	df := ec.VP8Dropframe
+	if stream.IsSynthetic(src) {
+		df = 0
 	}
+	stopper, err := startPipeline(codec, stream.PipelineConfig{Width: s.cfg.Width, Height: s.cfg.Height, FPS: fps, BitrateKbps: ec.BitrateKbps, Source: src, Track: bc, VP8Speed: ec.VP8Speed, VP8Dropframe: df, Keyint: s.cfg.Keyint, RateControl: s.cfg.RateControl, CRF: s.cfg.CRF, VP9: s.cfg.VP9, AV1: s.cfg.AV1, MetricsLabel: sharedMetricsLabel, Queue: s.cfg.Queue})
 	if err != nil {
 		return err
 	}
+	audio.setSource(inner)
+	// Follow source resolution changes
 	ctx, cancel := context.WithCancel(context.Background())
 	if src != nil {
-		if reporter, ok := src.(interface {
-			Last() ([]byte, int, int, bool)
-		}); ok {
-			currentW, currentH := s.cfg.Width, s.cfg.Height
-			go func() {
-				ticker := time.NewTicker(1 * time.Second)
-				defer ticker.Stop()
-				for {
-					select {
-					case <-ctx.Done():
-						return
-					case <-ticker.C:
-						_, w0, h0, ok := reporter.Last()
-						if !ok || w0 <= 0 || h0 <= 0 {
-							continue
-						}
-						if w0 == currentW && h0 == currentH {
-							continue
-						}
-						log.Printf("Pipeline(shared): source resolution change detected %dx%d -> %dx%d, restarting encoder", currentW, currentH, w0, h0)
-						if stopper != nil {
-							stopper.Stop()
-						}
-						var p interface{ Stop() }
-						var e error
-						switch codec {
-						case "vp9":
-							p, e = stream.StartVP9Pipeline(stream.PipelineConfig{Width: w0, Height: h0, FPS: fps, BitrateKbps: s.cfg.BitrateKbps, Source: src, Track: bc})
-						case "av1":
-							p, e = stream.StartAV1Pipeline(stream.PipelineConfig{Width: w0, Height: h0, FPS: fps, BitrateKbps: s.cfg.BitrateKbps, Source: src, Track: bc})
-						default:
-							p, e = stream.StartVP8Pipeline(stream.PipelineConfig{Width: w0, Height: h0, FPS: fps, BitrateKbps: s.cfg.BitrateKbps, Source: src, Track: bc, VP8Speed: s.cfg.VP8Speed, VP8Dropframe: s.cfg.VP8Dropframe})
-						}
-						if e != nil {
-							log.Printf("Pipeline(shared) restart failed: %v", e)
-							continue
-						}
-						stopper = p
-						s.mu.Lock()
-						s.shareStop = stopper.Stop
-						s.mu.Unlock()
-						currentW, currentH = w0, h0
-					}
-				}
-			}()
-		}
+		watchSourceSize(ctx, src, s.cfg.Width, s.cfg.Height, "shared", func(w, h int) bool {
+			p, e := resizePipeline(stopper, codec, stream.PipelineConfig{Width: w, Height: h, FPS: fps, BitrateKbps: ec.BitrateKbps, Source: src, Track: bc, VP8Speed: ec.VP8Speed, VP8Dropframe: ec.VP8Dropframe, Keyint: s.cfg.Keyint, RateControl: s.cfg.RateControl, CRF: s.cfg.CRF, VP9: s.cfg.VP9, AV1: s.cfg.AV1, MetricsLabel: sharedMetricsLabel, Queue: s.cfg.Queue}, "shared")
+			if e != nil {
+				log.Printf("Pipeline(shared) restart failed: %v", e)
+				return false
+			}
+			if p != stopper {
+				stopper = p
+				s.mu.Lock()
+				s.shareStop, s.sharePipe = stopper.Stop, stopper
+				s.mu.Unlock()
+			}
+			return true
+		})
 	}
 	s.mu.Lock()
-	s.shareStop, s.shareSrc, s.shareCancel = stopper.Stop, src, cancel
+	s.shareStop, s.sharePipe, s.shareSrc, s.shareCancel, s.shareSwitch, s.shareFPS = stopper.Stop, stopper, src, cancel, sw, fps
 	s.mu.Unlock()
 	return nil
 }
@@ -988,23 +1652,67 @@ func (s *WhepServer) handleNDISources(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusNoContent)
 		return
 	}
+	type Variant struct {
+		LadderRung
+		WHEP     string `json:"whepEndpoint"`
+		MountKey string `json:"mountKey"` // X-Mount-Key a POST to WHEP gets
+	}
 	type Info struct {
-		ID   string `json:"id"`
-		Name string `json:"name"`
-		URL  string `json:"url"`
-		WHEP string `json:"whepEndpoint"`
+		ID           string              `json:"id"`
+		Name         string              `json:"name"`
+		URL          string              `json:"url"`
+		WHEP         string              `json:"whepEndpoint"`
+		Variants     []Variant           `json:"variants,omitempty"`
+		Availability *availabilityStatus `json:"availability,omitempty"` // only for scheduled mounts
+		Channel      *channelStatus      `json:"channel,omitempty"`      // only for virtual channels
+		// Size and rate as last received; absent until something has
+		Width  int          `json:"width,omitempty"`
+		Height int          `json:"height,omitempty"`
+		FPS    *stream.Rate `json:"fps,omitempty"`
+		// LastSeen is when discovery last listed an NDI source
+		LastSeen *time.Time       `json:"lastSeen,omitempty"`
+		Active   bool             `json:"active"` // a mount of it is running
+		Running  []runningVariant `json:"running,omitempty"`
 	}
 	idx := s.sourceIndex()
+	rungs := s.ladder.Rungs()
 	list := make([]Info, 0, len(idx))
+	now := time.Now()
+	s.learnSourceMetas(now)
+	running := s.runningVariants()
 	for k, si := range idx {
-		list = append(list, Info{ID: k, Name: si.Name, URL: si.URL, WHEP: "/whep/ndi/" + k})
+		it := Info{ID: k, Name: si.Name, URL: si.URL, WHEP: "/whep/ndi/" + k, Running: running[k], Active: len(running[k]) > 0}
+		if m, ok := s.sourceMetas.get(sourceID(si.Name, si.URL)); ok {
+			it.Width, it.Height = m.Width, m.Height
+			if m.FPS.Valid() {
+				fps := m.FPS
+				it.FPS = &fps
+			}
+		}
+		if at, ok := ndi.LastSeen(si.URL); ok && lookupSourceFactory(si.Name, si.URL) == ndiFactory {
+			at = at.UTC()
+			it.LastSeen = &at
+		}
+		_, it.Availability = s.mountAvailability(k, now)
+		if cs, ok := s.channelStatus(k); ok {
+			it.Channel = &cs
+		}
+		for _, r := range rungs {
+			it.Variants = append(it.Variants, Variant{LadderRung: r, WHEP: variantEndpoint(k, r), MountKey: s.variantMountKey(k, r)})
+		}
+		list = append(list, it)
 	}
 	// Keep backward-compatible shape: { sources: [ { name, url } ], mounts: [Info] }
 	compat := make([]map[string]string, 0, len(list))
 	for _, it := range list {
 		compat = append(compat, map[string]string{"name": it.Name, "url": it.URL})
 	}
-	_ = json.NewEncoder(w).Encode(map[string]any{"sources": compat, "mounts": list})
+	resp := map[string]any{"sources": compat, "mounts": list}
+	// Without a usable runtime the list can only hold built-in sources; say why
+	if info := ndi.SDK(); !info.OK() {
+		resp["ndi"] = info
+	}
+	_ = json.NewEncoder(w).Encode(resp)
 }
 
 // POST /ndi/select { "source": "substring or exact name" }
@@ -1015,32 +1723,70 @@ func (s *WhepServer) handleNDISelect(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	if r.Method != http.MethodPost {
-		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, r, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "method not allowed", nil)
 		return
 	}
 	var body struct {
-		Source string `json:"source"`
+		Source     string `json:"source"`
+		ScheduleAt string `json:"scheduleAt"`
 	}
 	dec := json.NewDecoder(r.Body)
 	if err := dec.Decode(&body); err != nil || body.Source == "" {
-		http.Error(w, "invalid JSON or missing 'source'", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, errCodeBadRequest, "invalid JSON or missing 'source'", nil)
 		return
 	}
-	// find best match by substring (case-insensitive)
-	srcs := streamNDISources()
-	selName, selURL := "", ""
-	q := strings.ToLower(body.Source)
-	for _, si := range srcs {
-		if strings.Contains(strings.ToLower(si.Name), q) || strings.EqualFold(si.URL, body.Source) {
+	// A slate ("slate:brb.png") or a mount key (an RTSP camera, the pipe)
+	// is taken as is; WHIP streams can't be decoded for the program
+	selName, selURL, found := "", "", false
+	if isSlate(body.Source) {
+		name, url, err := s.slateSource(body.Source)
+		switch {
+		case errors.Is(err, errSlatePath):
+			writeError(w, r, http.StatusBadRequest, errCodeBadRequest, err.Error(), map[string]string{"source": body.Source})
+			return
+		case err != nil:
+			writeError(w, r, http.StatusNotFound, errCodeSourceNotFound, err.Error(), map[string]string{"source": body.Source})
+			return
+		}
+		selName, selURL, found = name, url, true
+	} else if si, ok := s.sourceIndex()[sourceKeyAlias(body.Source)]; ok && lookupSourceFactory(si.Name, si.URL).scheme != whipScheme {
+		selName, selURL, found = si.Name, si.URL, true
+	}
+	if body.ScheduleAt != "" {
+		// A timed switch must name a source that exists now; no fallback
+		if !found {
+			si, ok := s.resolveSource(body.Source)
+			if !ok {
+				writeError(w, r, http.StatusNotFound, errCodeSourceNotFound, "source not found: "+body.Source, map[string]string{"source": body.Source})
+				return
+			}
 			selName, selURL = si.Name, si.URL
-			break
 		}
+		s.failoverOperatorSelected(selName, selURL)
+		s.respondScheduled(w, r, body.ScheduleAt, selName, selURL)
+		return
 	}
-	if selName == "" && len(srcs) > 0 { // fallback to first
-		selName, selURL = srcs[0].Name, srcs[0].URL
+	if !found {
+		// find best match by substring (case-insensitive)
+		srcs := streamNDISources()
+		q := strings.ToLower(body.Source)
+		for _, si := range srcs {
+			if strings.Contains(strings.ToLower(si.Name), q) || strings.EqualFold(si.URL, body.Source) {
+				selName, selURL = si.Name, si.URL
+				break
+			}
+		}
+		if selName == "" && len(srcs) > 0 { // fallback to first
+			selName, selURL = srcs[0].Name, srcs[0].URL
+		}
 	}
+	// An operator's selection overrides failover automation
+	s.failover.switching.Lock()
+	defer s.failover.switching.Unlock()
+	s.failoverOperatorSelected(selName, selURL)
 	s.mu.Lock()
 	s.ndiName, s.ndiURL = selName, selURL
+	s.changes.bump()
 	s.mu.Unlock()
 	// Restart shared pipeline so all sessions switch source
 	_ = s.restartSharedPipeline()
@@ -1055,16 +1801,41 @@ func (s *WhepServer) handleNDISelectURL(w http.ResponseWriter, r *http.Request)
 		return
 	}
 	if r.Method != http.MethodPost {
-		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, r, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "method not allowed", nil)
 		return
 	}
 	var body struct {
-		URL string `json:"url"`
+		URL        string `json:"url"`
+		ScheduleAt string `json:"scheduleAt"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.URL == "" {
-		http.Error(w, "invalid JSON or missing 'url'", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, errCodeBadRequest, "invalid JSON or missing 'url'", nil)
+		return
+	}
+	// An RTSP camera joins the index; the URL goes on without its password
+	if isRTSPURL(body.URL) {
+		u, err := s.rtsp.remember(body.URL)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, errCodeBadRequest, err.Error(), nil)
+			return
+		}
+		body.URL = u
+	}
+	if body.ScheduleAt != "" {
+		name := ""
+		if si, ok := s.resolveSource(body.URL); ok {
+			name = si.Name
+		} else if lookupSourceFactory("", body.URL) == ndiFactory {
+			writeError(w, r, http.StatusNotFound, errCodeSourceNotFound, "source not found: "+body.URL, map[string]string{"url": body.URL})
+			return
+		}
+		s.failoverOperatorSelected(name, body.URL)
+		s.respondScheduled(w, r, body.ScheduleAt, name, body.URL)
 		return
 	}
+	s.failover.switching.Lock()
+	defer s.failover.switching.Unlock()
+	s.failoverOperatorSelected("", body.URL)
 	s.mu.Lock()
 	s.ndiURL = body.URL
 	s.mu.Unlock()
@@ -1075,7 +1846,7 @@ func (s *WhepServer) handleNDISelectURL(w http.ResponseWriter, r *http.Request)
 
 // helper to get NDI discovery results via cgo wrapper; returns empty list when unavailable
 func streamNDISources() []struct{ Name, URL string } {
-	out := []struct{ Name, URL string }{{Name: "Splash", URL: "ndi://Splash"}}
+	out := listedSources()
 	// Use cached sources from background discovery
 	for _, s := range ndi.GetCachedSources() {
 		out = append(out, struct{ Name, URL string }{Name: s.Name, URL: s.URL})
@@ -1087,120 +1858,62 @@ func (s *WhepServer) handleWHEPResource(w http.ResponseWriter, r *http.Request)
 	allowCORS(w, r)
 	id := r.URL.Path[len("/whep/"):]
 	switch r.Method {
+	case http.MethodGet:
+		s.writeSessionAnswer(w, r, id)
+		return
 	case http.MethodPatch:
 		w.WriteHeader(http.StatusNoContent)
 		return
 	case http.MethodDelete:
-		s.closeSession(id)
-		w.WriteHeader(http.StatusNoContent)
+		s.deleteSession(w, r, id)
 		return
 	case http.MethodOptions:
 		w.WriteHeader(http.StatusNoContent)
 		return
 	default:
-		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, r, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "method not allowed", nil)
 		return
 	}
 }
 
-// restartSessionPipeline stops the current encoder for the session and starts a new one
-// using the server's currently selected NDI source. It reuses the existing track.
-func (s *WhepServer) restartSessionPipeline(ss *session) error {
-	if ss == nil || ss.track == nil {
-		return nil
-	}
-	// Build source from current selection
+// writeSessionAnswer serves GET on a session resource: the session's current
+// SDP answer, so a reloaded player can pick the session back up. It carries
+// every candidate gathered so far, including those an early answer left out.
+func (s *WhepServer) writeSessionAnswer(w http.ResponseWriter, r *http.Request, id string) {
 	s.mu.Lock()
-	ndiURL := s.ndiURL
-	ndiName := s.ndiName
+	sess := s.sessions[id]
 	s.mu.Unlock()
-	if ndiURL == "" {
-		ndiURL = os.Getenv("NDI_SOURCE_URL")
-	}
-	if ndiName == "" {
-		ndiName = os.Getenv("NDI_SOURCE")
-	}
-	var src stream.Source
-	if strings.EqualFold(ndiName, "splash") || strings.EqualFold(ndiURL, "ndi://splash") {
-		src = nil // use synthetic
-	} else if nd, err := stream.NewNDISource(ndiURL, ndiName); err == nil {
-		// Ask source to pre-scale to the configured pipeline size if provided
-		if s.cfg.Width > 0 && s.cfg.Height > 0 {
-			nd.SetOutputSize(s.cfg.Width, s.cfg.Height)
-		}
-		src = nd
-	} else {
-		// fallback to synthetic if NDI unavailable
-		src = nil
-	}
-	// Restart video pipeline only, using current codec
-	fps := s.cfg.FPS
-	if fps <= 0 {
-		fps = 30
-	}
-	// Stop old
-	if ss.stop != nil {
-		ss.stop()
-	}
-	// Stop old source to avoid leaking the underlying receiver
-	if ss.src != nil {
-		ss.src.Stop()
-	}
-	// Start new (auto-detect size inside pipeline)
-	var err error
-	switch strings.ToLower(s.cfg.Codec) {
-	case "av1":
-		if p, e := stream.StartAV1Pipeline(stream.PipelineConfig{Width: s.cfg.Width, Height: s.cfg.Height, FPS: fps, BitrateKbps: s.cfg.BitrateKbps, Source: src, Track: ss.track}); e == nil {
-			ss.stop = p.Stop
-			ss.src = src
-		} else {
-			err = e
-		}
-	case "vp9":
-		if p, e := stream.StartVP9Pipeline(stream.PipelineConfig{Width: s.cfg.Width, Height: s.cfg.Height, FPS: fps, BitrateKbps: s.cfg.BitrateKbps, Source: src, Track: ss.track}); e == nil {
-			ss.stop = p.Stop
-			ss.src = src
-		} else {
-			err = e
-		}
-	default:
-		df := s.cfg.VP8Dropframe
-		if src == nil {
-			df = 0
-		}
-		if p, e := stream.StartVP8Pipeline(stream.PipelineConfig{Width: s.cfg.Width, Height: s.cfg.Height, FPS: fps, BitrateKbps: s.cfg.BitrateKbps, Source: src, Track: ss.track, VP8Speed: s.cfg.VP8Speed, VP8Dropframe: df}); e == nil {
-			ss.stop = p.Stop
-			ss.src = src
-		} else {
-			err = e
-		}
+	if sess == nil {
+		writeError(w, r, http.StatusNotFound, errCodeNotFound, "session not found", nil)
+		return
 	}
-	if err != nil {
-		log.Printf("Pipeline restart error: %v", err)
-		return err
+	answer := sess.pc.LocalDescription()
+	if answer == nil {
+		writeError(w, r, http.StatusNotFound, errCodeNotFound, "session has no answer", nil)
+		return
 	}
-	return nil
+	writeSDP(w, http.StatusOK, answer.SDP)
 }
 
-func (s *WhepServer) closeSession(id string) {
+// closeSession tears down a session and reports whether it existed.
+func (s *WhepServer) closeSession(id string) bool {
 	s.mu.Lock()
 	sess := s.sessions[id]
-	delete(s.sessions, id)
+	var link string
+	if sess != nil {
+		delete(s.sessions, id)
+		s.changes.bump()
+		link = sess.link
+	}
 	s.mu.Unlock()
+	if link != "" {
+		s.links.dropSession(link, id)
+	}
 	if sess != nil {
-		// Cancel the resolution monitoring goroutine first
-		if sess.cancelFunc != nil {
-			sess.cancelFunc()
-		}
+		sess.connectCancel()
 		if sess.detach != nil {
 			sess.detach()
 		}
-		if sess.stop != nil {
-			sess.stop()
-		}
-		if sess.src != nil {
-			sess.src.Stop()
-		}
 		_ = sess.pc.Close()
 		log.Printf("WHEP session %s: closed", id)
 		// Update mount refcounts if applicable
@@ -1212,27 +1925,82 @@ func (s *WhepServer) closeSession(id string) {
 			s.mu.Unlock()
 		}
 	}
-	// If no more sessions, stop shared pipeline to save CPU
+	// If no more sessions, stop shared pipeline to save CPU once the linger
+	// runs out
 	s.mu.Lock()
-	if len(s.sessions) == 0 && s.shareBC != nil {
-		if s.shareCancel != nil {
-			s.shareCancel()
-		}
-		if s.shareStop != nil {
-			s.shareStop()
+	s.stopSharedIfUnusedLocked()
+	s.mu.Unlock()
+	return sess != nil
+}
+
+// startConnectTimeout arms sess's connect timeout: a session still new or
+// connecting after sessionConnectTimeout is closed. Nothing waits on the
+// timer, and reaching Connected or closing cancels it. Call before the
+// session is registered.
+func (s *WhepServer) startConnectTimeout(sess *session) {
+	ctx, cancel := context.WithTimeout(context.Background(), sessionConnectTimeout)
+	sess.connectCancel = cancel
+	context.AfterFunc(ctx, func() {
+		if ctx.Err() != context.DeadlineExceeded {
+			return
 		}
-		if s.shareSrc != nil {
-			s.shareSrc.Stop()
+		s.mu.Lock()
+		_, exists := s.sessions[sess.id]
+		s.mu.Unlock()
+		if st := sess.pc.ConnectionState(); exists && (st == webrtc.PeerConnectionStateNew || st == webrtc.PeerConnectionStateConnecting) {
+			log.Printf("Session %s: timeout after %s, cleaning up (state: %s)", sess.id, sessionConnectTimeout, st)
+			s.closeSession(sess.id)
 		}
-		s.shareBC.Close()
-		s.shareBC, s.shareStop, s.shareSrc, s.shareCodec, s.shareCancel = nil, nil, nil, "", nil
-		log.Printf("Shared pipeline stopped (no active sessions)")
-	}
+	})
+}
+
+// releaseSharedClaim ends a /whep POST's claim on the shared pipeline, once
+// its session is registered or it gave up.
+func (s *WhepServer) releaseSharedClaim() {
+	s.mu.Lock()
+	s.sharePending--
+	s.stopSharedIfUnusedLocked()
 	s.mu.Unlock()
 }
 
-// handleFramePNG returns a single PNG frame from the currently selected NDI source.
-// Query param: timeout=ms (default 2000)
+// stopSharedIfUnusedLocked stops the shared pipeline, after the linger,
+// when no session uses it and no POST is about to. Caller holds s.mu.
+func (s *WhepServer) stopSharedIfUnusedLocked() {
+	if s.sharedUnusedLocked() {
+		s.lingerSharedLocked()
+	}
+}
+
+// stopSharedLocked tears down the shared pipeline now, cancelling any
+// linger. Caller holds s.mu.
+func (s *WhepServer) stopSharedLocked() {
+	if s.shareLinger != nil {
+		s.shareLinger.Stop()
+		s.shareLinger = nil
+	}
+	if s.shareBC == nil {
+		return
+	}
+	if s.shareCancel != nil {
+		s.shareCancel()
+	}
+	if s.shareStop != nil {
+		s.shareStop()
+	}
+	if s.shareSrc != nil {
+		s.shareSrc.Stop()
+	}
+	s.shareBC.Close()
+	s.shareAudio.Close()
+	s.shareBC, s.shareStop, s.sharePipe, s.shareSrc, s.shareCodec, s.shareCancel, s.shareAudio = nil, nil, nil, nil, "", nil, nil
+	s.shareSwitch = nil
+	s.changes.bump()
+}
+
+// handleFramePNG returns a single frame from the currently selected NDI source,
+// or with GET /frame/{key} from the source of that /whep/ndi/{key} mount.
+// Query params: timeout=ms (default 2000), format=png|jpeg (default png),
+// quality=1..100 (JPEG, default 80), w/h to downscale (aspect kept if one is given)
 func (s *WhepServer) handleFramePNG(w http.ResponseWriter, r *http.Request) {
 	allowCORS(w, r)
 	if r.Method == http.MethodOptions {
@@ -1240,7 +2008,7 @@ func (s *WhepServer) handleFramePNG(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	if r.Method != http.MethodGet {
-		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, r, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "method not allowed", nil)
 		return
 	}
 
@@ -1252,106 +2020,171 @@ func (s *WhepServer) handleFramePNG(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 	deadline := time.Now().Add(time.Duration(timeoutMs) * time.Millisecond)
-
-	// Resolve selection
-	s.mu.Lock()
-	ndiURL := s.ndiURL
-	ndiName := s.ndiName
-	s.mu.Unlock()
-	if ndiURL == "" {
-		ndiURL = os.Getenv("NDI_SOURCE_URL")
-	}
-	if ndiName == "" {
-		ndiName = os.Getenv("NDI_SOURCE")
+	opts, msg := parseFrameOptions(r)
+	if msg != "" {
+		writeError(w, r, http.StatusBadRequest, errCodeBadRequest, msg, nil)
+		return
 	}
 
-	// If the special fake NDI "Splash" is selected, render a synthetic frame instead
-	if strings.EqualFold(ndiName, "splash") || strings.EqualFold(ndiURL, "ndi://splash") {
-		wpx, hpx := s.cfg.Width, s.cfg.Height
-		if wpx <= 0 {
-			wpx = 1280
-		}
-		if hpx <= 0 {
-			hpx = 720
-		}
-		src := stream.NewSynthetic(wpx, hpx, 30, 1)
-		buf, _ := src.Next()
-		img := image.NewRGBA(image.Rect(0, 0, wpx, hpx))
-		for y := 0; y < hpx; y++ {
-			for x := 0; x < wpx; x++ {
-				si := (y*wpx + x) * 4
-				di := si
-				b := buf[si+0]
-				g := buf[si+1]
-				r := buf[si+2]
-				a := buf[si+3]
-				img.Pix[di+0] = r
-				img.Pix[di+1] = g
-				img.Pix[di+2] = b
-				img.Pix[di+3] = a
+	var ndiName, ndiURL string
+	if key := strings.Trim(strings.TrimPrefix(r.URL.Path, "/frame"), "/"); key != "" {
+		idx := s.sourceIndex()
+		si, ok := idx[sourceKeyAlias(key)]
+		if !ok {
+			keys := make([]string, 0, len(idx))
+			for k := range idx {
+				keys = append(keys, k)
 			}
+			sort.Strings(keys)
+			writeError(w, r, http.StatusNotFound, errCodeSourceNotFound, "unknown source key "+key+"; valid keys: "+strings.Join(keys, ", "), map[string]any{"key": key, "keys": keys})
+			return
+		}
+		ndiName, ndiURL = si.Name, si.URL
+	} else {
+		ndiName, ndiURL = s.frameSelection()
+	}
+
+	// Sources that can render a still (Splash) skip opening a receiver
+	if f := lookupSourceFactory(ndiName, ndiURL); f.still != nil {
+		buf, wpx, hpx, err := f.still(s, 0, 0)
+		if err != nil {
+			writeError(w, r, http.StatusServiceUnavailable, errCodeSourceUnavailable, err.Error(), nil)
+			return
 		}
-		w.Header().Set("Content-Type", "image/png")
-		_ = png.Encode(w, img)
+		w.Header().Set("X-Frame-Age-Ms", "0")
+		_ = writeFrame(w, buf, wpx, hpx, "bgra", opts)
 		return
 	}
 
-	// Create a temporary NDI source
-	nd, err := stream.NewNDISource(ndiURL, ndiName)
-	if err != nil {
-		http.Error(w, "NDI not available or source not found", http.StatusServiceUnavailable)
+	// A mount or the shared pipeline already receiving the source saves
+	// opening another receiver; otherwise use a cached one at native size.
+	// Both keep running, so the frame is copied.
+	src := s.runningSource(ndiName, ndiURL)
+	if src == nil {
+		var release func()
+		var err error
+		ndi.NoteInterest(sourceID(ndiName, ndiURL))
+		ctx, cancel := context.WithTimeout(r.Context(), frameOpenTimeout)
+		src, release, err = s.frameSource(ctx, ndiName, ndiURL)
+		cancel()
+		if err != nil {
+			writeError(w, r, http.StatusServiceUnavailable, errCodeSourceUnavailable, "NDI not available or source not found", nil)
+			return
+		}
+		defer release()
+	}
+	nd, _ := src.(interface {
+		Last() ([]byte, int, int, bool)
+	})
+	if nd == nil {
+		writeError(w, r, http.StatusServiceUnavailable, errCodeSourceUnavailable, "source cannot provide frames", nil)
 		return
 	}
-	defer nd.Stop()
 
 	var buf []byte
 	var wpx, hpx int
 	var ok bool
+	var pixfmt string
 	for time.Now().Before(deadline) {
-		if b, w0, h0, have := nd.Last(); have && b != nil && len(b) >= w0*h0*4 && w0 > 0 && h0 > 0 {
-			buf, wpx, hpx, ok = b, w0, h0, true
+		// The format is known once the first frame arrived
+		pixfmt = stream.PixFmtOf(src)
+		bpp := 4
+		if pixfmt == "uyvy422" {
+			bpp = 2
+		}
+		if b, w0, h0, have := nd.Last(); have && b != nil && len(b) >= w0*h0*bpp && w0 > 0 && h0 > 0 {
+			buf, wpx, hpx, ok = append([]byte(nil), b[:w0*h0*bpp]...), w0, h0, true
 			break
 		}
 		time.Sleep(50 * time.Millisecond)
 	}
 	if !ok {
-		http.Error(w, "no frame available", http.StatusServiceUnavailable)
-		return
-	}
-
-	// Convert BGRA to RGBA and encode PNG
-	img := image.NewRGBA(image.Rect(0, 0, wpx, hpx))
-	// RGBA stride is 4*wpx by default
-	for y := 0; y < hpx; y++ {
-		for x := 0; x < wpx; x++ {
-			si := (y*wpx + x) * 4
-			di := si
-			b := buf[si+0]
-			g := buf[si+1]
-			r := buf[si+2]
-			a := buf[si+3]
-			img.Pix[di+0] = r
-			img.Pix[di+1] = g
-			img.Pix[di+2] = b
-			img.Pix[di+3] = a
+		writeError(w, r, http.StatusServiceUnavailable, errCodeSourceUnavailable, "no frame available", nil)
+		return
+	}
+
+	if at, ok := stream.LastFrameAt(src); ok && !at.IsZero() {
+		w.Header().Set("X-Frame-Age-Ms", strconv.FormatInt(time.Since(at).Milliseconds(), 10))
+	}
+	// Headers are sent with the first chunk, so a failure past this point
+	// can only cut the response short
+	if err := writeFrame(w, buf, wpx, hpx, pixfmt, opts); err != nil {
+		log.Printf("/frame: encode: %v", err)
+	}
+}
+
+// runningSource returns the live source of a mount, or of the shared
+// pipeline, that is receiving name/url; nil if none is. Mounts at native
+// size are preferred over scaled variants.
+func (s *WhepServer) runningSource(name, url string) stream.Source {
+	s.mu.Lock()
+	mounts := make([]*ndiMount, 0, len(s.mounts))
+	for _, m := range s.mounts {
+		if m.name == name && m.url == url {
+			mounts = append(mounts, m)
+		}
+	}
+	var shared stream.Source
+	if s.ndiName == name && s.ndiURL == url {
+		shared = s.shareSrc
+	}
+	s.mu.Unlock()
+	sort.Slice(mounts, func(i, j int) bool { return mounts[i].width*mounts[i].height < mounts[j].width*mounts[j].height })
+	for _, m := range mounts {
+		m.mu.Lock()
+		src := m.src
+		m.mu.Unlock()
+		if src != nil && !stream.IsSynthetic(src) {
+			return src
 		}
 	}
+	if shared != nil && !stream.IsSynthetic(shared) {
+		return shared
+	}
+	return nil
+}
 
-	w.Header().Set("Content-Type", "image/png")
-	if err := png.Encode(w, img); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+// resourceURL makes path absolute for a Location header. -public-url wins;
+// otherwise scheme, host and prefix come from X-Forwarded-Proto/-Host/-Prefix
+// when a proxy sets them, falling back to the request itself.
+func (s *WhepServer) resourceURL(r *http.Request, path string) string {
+	if s.cfg.PublicURL != "" {
+		return strings.TrimRight(s.cfg.PublicURL, "/") + path
+	}
+	// Proxies chaining these append with commas; the first is the client's
+	first := func(h string) string {
+		v, _, _ := strings.Cut(r.Header.Get(h), ",")
+		return strings.TrimSpace(v)
+	}
+	scheme := first("X-Forwarded-Proto")
+	if scheme == "" {
+		scheme = "http"
+		if r.TLS != nil {
+			scheme = "https"
+		}
+	}
+	host := first("X-Forwarded-Host")
+	if host == "" {
+		host = r.Host
 	}
+	return scheme + "://" + host + strings.TrimRight(first("X-Forwarded-Prefix"), "/") + path
 }
 
+// allowCORS lets any site's pages call the API, except /admin/*.
 func allowCORS(w http.ResponseWriter, r *http.Request) {
+	if isAdminPath(r.URL.Path) {
+		// Not for other sites' pages: without CORS a browser won't let
+		// them call in from inside the network
+		return
+	}
 	origin := r.Header.Get("Origin")
 	if origin == "" {
 		origin = "*"
 	}
 	w.Header().Set("Access-Control-Allow-Origin", origin)
 	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PATCH, DELETE, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, "+apiVersionHeader)
+	w.Header().Set("Access-Control-Expose-Headers", apiVersionHeader+", "+mountKeyHeader+", "+loadHeader+", "+dryRunHeader)
 }
 
 // handleConfig serves a simple HTML page that documents and shows current
@@ -1362,8 +2195,12 @@ func (s *WhepServer) handleConfig(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusNoContent)
 		return
 	}
+	if r.Method == http.MethodPost || r.Method == http.MethodPatch {
+		s.handleConfigUpdate(w, r)
+		return
+	}
 	if r.Method != http.MethodGet {
-		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, r, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "method not allowed", nil)
 		return
 	}
 
@@ -1372,28 +2209,85 @@ func (s *WhepServer) handleConfig(w http.ResponseWriter, r *http.Request) {
 	s.mu.Lock()
 	selNDIName, selNDIURL := s.ndiName, s.ndiURL
 	s.mu.Unlock()
+	ec := s.encoderCfg()
 
 	// Build rows for flags (and their env equivalents)
 	type row struct{ Name, Flag, Env, Value, Default, Desc string }
 	rows := []row{
 		{Name: "Host", Flag: "-host", Env: "HOST", Value: s.cfg.Host, Default: "0.0.0.0", Desc: "HTTP bind host"},
 		{Name: "Port", Flag: "-port", Env: "PORT", Value: fmt.Sprintf("%d", s.cfg.Port), Default: "8000", Desc: "HTTP bind port"},
-		{Name: "FPS", Flag: "-fps", Env: "FPS", Value: fmt.Sprintf("%d", s.cfg.FPS), Default: "30", Desc: "Frames per second (synthetic/when used)"},
+		{Name: "FPS", Flag: "-fps", Env: "FPS", Value: fpsValue(ec.FPS), Default: "source", Desc: "Frames per second: 30, 29.97 or 30000/1001; source encodes NDI sources at their own rate (30 without one)"},
 		{Name: "Width", Flag: "-width", Env: "VIDEO_WIDTH", Value: fmt.Sprintf("%d", s.cfg.Width), Default: "1280", Desc: "Video width (synthetic/initial)"},
 		{Name: "Height", Flag: "-height", Env: "VIDEO_HEIGHT", Value: fmt.Sprintf("%d", s.cfg.Height), Default: "720", Desc: "Video height (synthetic/initial)"},
-		{Name: "Bitrate", Flag: "-bitrate", Env: "VIDEO_BITRATE_KBPS", Value: fmt.Sprintf("%d", s.cfg.BitrateKbps), Default: "6000", Desc: "Target video bitrate (kbps)"},
+		{Name: "Rotate", Flag: "-rotate", Env: "VIDEO_ROTATE", Value: fmt.Sprintf("%d", s.cfg.Rotate), Default: "0", Desc: "Turn the shared /whep picture clockwise: 0, 90, 180 or 270"},
+		{Name: "Bitrate", Flag: "-bitrate", Env: "VIDEO_BITRATE_KBPS", Value: fmt.Sprintf("%d", ec.BitrateKbps), Default: "6000", Desc: "Target video bitrate (kbps)"},
 		{Name: "Codec", Flag: "-codec", Env: "VIDEO_CODEC", Value: s.cfg.Codec, Default: "vp8", Desc: "Video codec: vp8, vp9, av1"},
-		{Name: "HW Accel", Flag: "-hwaccel", Env: "VIDEO_HWACCEL", Value: s.cfg.HWAccel, Default: "none", Desc: "Reserved; hardware encoder selection"},
-		{Name: "VP8 Speed", Flag: "-vp8speed", Env: "VIDEO_VP8_SPEED", Value: fmt.Sprintf("%d", s.cfg.VP8Speed), Default: "8", Desc: "VP8 cpu_used speed (0=best, 8=fastest)"},
-		{Name: "VP8 Dropframe", Flag: "-vp8dropframe", Env: "VIDEO_VP8_DROPFRAME", Value: fmt.Sprintf("%d", s.cfg.VP8Dropframe), Default: "25", Desc: "VP8 drop-frame threshold (0=off)"},
-		{Name: "Scale Filter", Flag: "-scaleFilter", Env: "YUV_SCALE_FILTER", Value: getenv("YUV_SCALE_FILTER"), Default: "BOX", Desc: "libyuv scaler: NONE, LINEAR, BILINEAR, BOX"},
-		{Name: "NDI Color", Flag: "-color", Env: "NDI_RECV_COLOR", Value: getenv("NDI_RECV_COLOR"), Default: "", Desc: "NDI receive color: bgra or uyvy"},
+		{Name: "HW Accel", Flag: "-hwaccel", Env: "VIDEO_HWACCEL", Value: s.cfg.HWAccel, Default: "none", Desc: "Hardware encoder: none or qsv (H.264 via Intel QuickSync, needs 'qsv' build tag)"},
+		{Name: "Keyframe Interval", Flag: "-keyint", Env: "VIDEO_KEYINT", Value: keyintValue(s.cfg.Keyint), Default: "0", Desc: "Most frames between keyframes; 0 (auto) is 4 s for VP8 and H.264 and the encoder's default for VP9 and AV1. Mounts may set their own with keyint="},
+		{Name: "Rate Control", Flag: "-rc", Env: "VIDEO_RC", Value: s.rateControl(), Default: "cbr", Desc: "cbr holds the bitrate; cq holds quality -crf and only caps the bitrate (VP8, VP9, AV1; H.264 stays cbr). Mounts may pick their own with rc="},
+		{Name: "CRF", Flag: "-crf", Env: "VIDEO_CRF", Value: fmt.Sprintf("%d", crfValue(s.cfg.CRF)), Default: fmt.Sprintf("%d", stream.DefaultCRF), Desc: "Quality in cq mode, 1 (best) to 63; mounts may set their own with crf="},
+		{Name: "VP8 Speed", Flag: "-vp8speed", Env: "VIDEO_VP8_SPEED", Value: fmt.Sprintf("%d", ec.VP8Speed), Default: "8", Desc: "VP8 cpu_used speed (0=best, 8=fastest)"},
+		{Name: "VP9 Speed", Flag: "-vp9speed", Env: "VIDEO_VP9_SPEED", Value: fmt.Sprintf("%d", s.vp9Tuning().Speed), Default: "8", Desc: "VP9 cpu-used speed (0=best, 9=fastest); mounts may set vp9speed="},
+		{Name: "VP9 Row MT", Flag: "-vp9rowmt", Env: "VIDEO_VP9_ROW_MT", Value: fmt.Sprintf("%v", s.vp9Tuning().RowMT), Default: "on", Desc: "VP9 row-based multithreading: on or off; mounts may set vp9rowmt="},
+		{Name: "VP9 Tile Columns", Flag: "-vp9tiles", Env: "VIDEO_VP9_TILE_COLUMNS", Value: tilesValue(s.vp9Tuning().TileColumns), Default: "auto", Desc: "VP9 tile columns as log2 (0..6), or auto for as many as keep 256 pixels each; mounts may set vp9tiles="},
+		{Name: "VP9 AQ Mode", Flag: "-vp9aq", Env: "VIDEO_VP9_AQ_MODE", Value: fmt.Sprintf("%d", s.vp9Tuning().AQMode), Default: "3", Desc: "VP9 adaptive quantization: 0 off, 1 variance, 2 complexity, 3 cyclic refresh; mounts may set vp9aq="},
+		{Name: "AV1 Preset", Flag: "-av1preset", Env: "VIDEO_AV1_PRESET", Value: av1PresetValue(s.av1Tuning().Preset), Default: "auto", Desc: "AV1 speed preset: SVT-AV1 enc_mode 0..13 or libaom cpu-used 0..9, higher is faster; auto is 8 and 6. Mounts may set av1preset="},
+		{Name: "AV1 Tile Columns", Flag: "-av1tiles", Env: "VIDEO_AV1_TILE_COLUMNS", Value: tilesValue(s.av1Tuning().TileColumns), Default: "auto", Desc: "AV1 tile columns as log2 (0..6), or auto for as many as keep 256 pixels each; mounts may set av1tiles="},
+		{Name: "AV1 Tile Rows", Flag: "-av1tilerows", Env: "VIDEO_AV1_TILE_ROWS", Value: fmt.Sprintf("%d", s.av1Tuning().TileRows), Default: "0", Desc: "AV1 tile rows as log2 (0..6); mounts may set av1tilerows="},
+		{Name: "AV1 Low Latency", Flag: "-av1lowlatency", Env: "VIDEO_AV1_LOW_LATENCY", Value: fmt.Sprintf("%v", s.av1Tuning().LowLatency), Default: "on", Desc: "AV1 one frame out per frame in: SVT-AV1's low-delay structure (CBR rather than VBR) without lookahead or scene-cut keyframes; mounts may set av1lowlatency="},
+		{Name: "VP8 Dropframe", Flag: "-vp8dropframe", Env: "VIDEO_VP8_DROPFRAME", Value: fmt.Sprintf("%d", ec.VP8Dropframe), Default: "25", Desc: "VP8 drop-frame threshold (0=off)"},
+		{Name: "Scale Filter", Flag: "-scaleFilter", Env: "YUV_SCALE_FILTER", Value: scaleFilter(), Default: "BOX with libyuv, else NONE", Desc: "default scaler: NONE, LINEAR, BILINEAR, BOX; mounts may pick their own with filter="},
+		{Name: "YUV Matrix", Flag: "-yuvMatrix", Env: "YUV_MATRIX", Value: getenv("YUV_MATRIX"), Default: "auto", Desc: "RGB/YUV matrix: bt601, bt709, or auto for bt709 from 720 lines up"},
+		{Name: "YUV Range", Flag: "-yuvRange", Env: "YUV_RANGE", Value: getenv("YUV_RANGE"), Default: "limited", Desc: "Encoded YUV range: limited (what WebRTC decoders assume) or full; both conversion backends follow it. Only VP9 and AV1 flag full range, so VP8/H.264 viewers see crushed blacks with full"},
+		{Name: "NDI Color", Flag: "-color", Env: "NDI_RECV_COLOR", Value: getenv("NDI_RECV_COLOR"), Default: "", Desc: "NDI receive color: bgra, uyvy, or fastest for the sender's own format"},
+		{Name: "NDI Bandwidth", Flag: "-ndi-bandwidth", Env: "NDI_RECV_BANDWIDTH", Value: ndi.DefaultBandwidth(), Default: "highest", Desc: "NDI receive bandwidth: highest for the full stream, or lowest for the sender's proxy (smaller, lower-rate video that costs less network and decoding); mounts may pick their own with bandwidth="},
+		{Name: "Skip Unchanged", Flag: "-skip-unchanged", Env: "VIDEO_SKIP_UNCHANGED", Value: fmt.Sprintf("%v", stream.SkipUnchanged()), Default: "on", Desc: "Encode an unchanged NDI picture once, then again each second; off keeps a constant frame cadence"},
+		{Name: "Source Stall", Flag: "-source-stall-ms", Env: "SOURCE_STALL_MS", Value: fmt.Sprintf("%d", stream.SourceStallAfter().Milliseconds()), Default: "3000", Desc: "ms without an NDI frame before the source shows a signal-lost slate (the -slate image, else the test pattern) until frames return; 0 keeps the last frame"},
+		{Name: "Audio", Flag: "-audio", Env: "WHEP_AUDIO", Value: fmt.Sprintf("%v", s.cfg.Audio && stream.OpusAvailable()), Default: "on", Desc: "NDI audio as Opus: on or off (needs 'opus' build tag)"},
+		{Name: "Adaptive Bitrate", Flag: "-bwe", Env: "WHEP_BWE", Value: fmt.Sprintf("%v", s.cfg.BWE), Default: "on", Desc: "Follow viewers' TWCC/REMB estimates: on or off"},
+		{Name: "Min Bitrate", Flag: "-min-bitrate", Env: "VIDEO_MIN_BITRATE_KBPS", Value: fmt.Sprintf("%d", s.cfg.MinBitrateKbps), Default: "500", Desc: "Adaptive bitrate floor (kbps)"},
+		{Name: "SLO Windows", Flag: "-slo-windows", Env: "WHEP_SLO_WINDOWS", Value: sloWindowsSummary(s.slo.windows), Default: "1h,24h", Desc: "Rolling windows for /admin/slo availability"},
+		{Name: "Log Level", Flag: "-log-level", Env: "WHEP_LOG_LEVEL", Value: logLevelSummary(), Default: "info", Desc: "debug, info, warn or error; PATCH /admin/loglevel changes it at runtime"},
+		{Name: "Admin Token", Flag: "-admin-token", Env: "WHEP_ADMIN_TOKEN", Value: fmt.Sprintf("%v", s.cfg.AdminToken != ""), Default: "", Desc: "Bearer token for /admin/* (shown is whether one is set); without one only loopback clients may call them"},
+		{Name: "Public URL", Flag: "-public-url", Env: "WHEP_PUBLIC_URL", Value: s.cfg.PublicURL, Default: "", Desc: "Base URL for session Location headers; empty derives it from X-Forwarded-* or the request"},
+		{Name: "State Dir", Flag: "-state-dir", Env: "WHEP_STATE_DIR", Value: s.stateDir(), Default: "state", Desc: "Where SIGUSR2 CPU profiles and encoder benchmark baselines are written"},
+		{Name: "Benchmark on Start", Flag: "-bench-on-start", Env: "WHEP_BENCH_ON_START", Value: fmt.Sprintf("%v", s.cfg.BenchOnStart), Default: "off", Desc: "Benchmark the encoder at startup against the baseline POST /admin/benchmark/accept recorded: on or off"},
+		{Name: "Benchmark Regression", Flag: "-bench-regress-pct", Env: "WHEP_BENCH_REGRESS_PCT", Value: fmt.Sprintf("%d%%", s.benchRegressPct()), Default: "25%", Desc: "How much slower than its baseline an encoder benchmark may be before /health flags it"},
+		{Name: "Broadcast Queue", Flag: "-broadcast-queue", Env: "BROADCAST_QUEUE", Value: fmt.Sprintf("%d", s.cfg.Queue.Depth), Default: "4", Desc: "Samples queued per viewer and per encoder writer"},
+		{Name: "Broadcast Drop Policy", Flag: "-broadcast-drop-policy", Env: "BROADCAST_DROP_POLICY", Value: s.cfg.Queue.Policy.String(), Default: "drop-newest", Desc: "What a full queue drops: drop-newest (the incoming sample) or drop-oldest (the queue head, keeping latency lowest)"},
+		{Name: "Source Probe", Flag: "-ndi-source-probe", Env: "NDI_SOURCE_PROBE", Value: fmt.Sprintf("%v", s.cfg.SourceProbe), Default: "off", Desc: "Receive one frame of each discovered NDI source nothing else receives, every 10 minutes and in temporary receiver turns, to list its size and frame rate in /ndi/sources"},
+		{Name: "Discovery Interval", Flag: "-ndi-discovery-interval", Env: "NDI_DISCOVERY_INTERVAL", Value: ndi.DiscoveryInterval().String(), Default: ndi.DefaultDiscoveryInterval.String(), Desc: "Time between background NDI discovery passes, each listing sources for 2s; POST /ndi/discovery/refresh runs one at once"},
+		{Name: "Discovery Idle Pause", Flag: "-ndi-discovery-idle", Env: "NDI_DISCOVERY_IDLE", Value: s.cfg.DiscoveryIdle.String(), Default: "0s", Desc: "Pause background NDI discovery after this long without sessions or API calls (monitoring polls don't count); the next call resumes it. 0 never pauses"},
+		{Name: "Temp Receivers", Flag: "-ndi-temp-receivers", Env: "WHEP_NDI_TEMP_RECEIVERS", Value: fmt.Sprintf("%d", ndi.GetTempStats().MaxActive), Default: fmt.Sprintf("%d", ndi.DefaultTempReceivers), Desc: "Concurrent temporary NDI receivers (probes, /frame grabs); more wait their turn"},
+		{Name: "Temp Receiver Rate", Flag: "-ndi-temp-rate", Env: "WHEP_NDI_TEMP_RATE", Value: fmt.Sprintf("%g", ndi.GetTempStats().Rate), Default: fmt.Sprintf("%g", ndi.DefaultTempRate), Desc: "Temporary receivers and discovery passes started per second, spaced evenly"},
+		{Name: "Offer Dedupe Window", Flag: "-offer-dedupe", Env: "WHEP_OFFER_DEDUPE", Value: offerDedupeValue(s.offerDedupeWindow()), Default: "30s", Desc: "How long a repeated offer (same ICE ufrag and fingerprint) gets the first POST's session back instead of a new one (off = never)"},
+		{Name: "Shared Pipeline Linger", Flag: "-shared-linger", Env: "SHARED_PIPELINE_LINGER", Value: sharedLingerValue(s.sharedLinger()), Default: "30s", Desc: "How long the shared /whep pipeline keeps running after its last session leaves, so a quick rejoin is instant (0 = stop at once)"},
+		{Name: "Frame Receiver TTL", Flag: "-frame-receiver-ttl", Env: "WHEP_FRAME_RECEIVER_TTL", Value: s.frameReceiverTTL().String(), Default: "10s", Desc: "How long /frame keeps an NDI receiver open between polls (negative = close after each request)"},
+		{Name: "Max Sockets", Flag: "-max-sockets", Env: "WHEP_MAX_SOCKETS", Value: fmt.Sprintf("%d", s.cfg.MaxSockets), Default: "0", Desc: "Soft socket cap; new sessions get 503 past it (0=unlimited)"},
+		{Name: "Max Sessions", Flag: "-max-sessions", Env: "WHEP_MAX_SESSIONS", Value: fmt.Sprintf("%d", s.cfg.MaxSessions), Default: "0", Desc: "Sessions in total; new ones get 503 with Retry-After past it (0=unlimited)"},
+		{Name: "Max Sessions per Mount", Flag: "-max-sessions-per-mount", Env: "WHEP_MAX_SESSIONS_PER_MOUNT", Value: fmt.Sprintf("%d", s.cfg.MaxSessionsPerMount), Default: "0", Desc: "Sessions per source key (all variants) or /whep (0=unlimited)"},
+		{Name: "Encoder Capacity", Flag: "-encoder-capacity", Env: "WHEP_ENCODER_CAPACITY", Value: fmt.Sprintf("%d", s.encoderCapacity()), Default: "0", Desc: "Video encoders that count as full load in /loadz (0=one per two CPUs)"},
+		{Name: "Memory Budget", Flag: "-memory-budget-mb", Env: "WHEP_MEMORY_BUDGET_MB", Value: fmt.Sprintf("%d", s.cfg.MemoryBudgetMB), Default: "0", Desc: "Process memory budget (MB) for /loadz; 0 uses system memory in use"},
+		{Name: "Downgrade Load", Flag: "-downgrade-load", Env: "WHEP_DOWNGRADE_LOAD", Value: fmt.Sprintf("%d", s.cfg.DowngradeLoad), Default: "0", Desc: "Load score from which new mounts step down the ladder (0=off)"},
+		{Name: "Load Weights", Flag: "-load-weights", Env: "WHEP_LOAD_WEIGHTS", Value: s.loadWeights().String(), Default: defaultLoadWeights.String(), Desc: "Weights of the /loadz score components"},
+		{Name: "Answer Mode", Flag: "-answer-mode", Env: "WHEP_ANSWER_MODE", Value: s.answerMode(), Default: AnswerComplete, Desc: "When WHEP answers are sent: complete (after ICE gathering, every candidate) or early (once host candidates are in)"},
+		{Name: "Load Header", Flag: "-load-header", Env: "WHEP_LOAD_HEADER", Value: fmt.Sprintf("%v", s.cfg.LoadHeader), Default: "off", Desc: "Add the load score to WHEP 201s as X-Server-Load: on or off"},
+		{Name: "Min Free Disk", Flag: "-min-free-mb", Env: "WHEP_MIN_FREE_MB", Value: fmt.Sprintf("%d", s.cfg.MinFreeMB), Default: "1024", Desc: "Refuse new recordings/dumps below this free space in MB (0=off)"},
+		{Name: "Stop Free Disk", Flag: "-stop-free-mb", Env: "WHEP_STOP_FREE_MB", Value: fmt.Sprintf("%d", s.cfg.StopFreeMB), Default: "256", Desc: "Stop active recordings/dumps below this free space in MB (0=off)"},
+		{Name: "Record Dir", Flag: "-record-dir", Env: "WHEP_RECORD_DIR", Value: s.recordDir(), Default: "state/recordings", Desc: "Where POST /mounts/{key}/record/start writes IVF recordings; requested paths are relative to it"},
+		{Name: "Record Max Size", Flag: "-record-max-mb", Env: "WHEP_RECORD_MAX_MB", Value: fmt.Sprintf("%d", s.cfg.RecordMaxMB), Default: "1024", Desc: "Start a new recording file at the next keyframe past this size in MB (0=never); a start request may set maxMB"},
+		{Name: "FFmpeg", Flag: "-ffmpeg", Env: "WHEP_FFMPEG", Value: stream.FFmpegPath(), Default: "ffmpeg", Desc: "ffmpeg RTSP cameras are read with; ffprobe must be beside it"},
+		{Name: "Stdin Source", Flag: "-stdin-source", Env: "WHEP_STDIN_SOURCE", Value: s.pipeFormat(), Default: "", Desc: "Raw video frames piped in as the \"pipe\" source: WxH@FPS:bgra or WxH@FPS:i420 (empty = off)"},
+		{Name: "Pipe Input", Flag: "-pipe-input", Env: "WHEP_PIPE_INPUT", Value: s.cfg.PipeInput, Default: "-", Desc: "Where -stdin-source frames come from: - (stdin), a named pipe, or tcp://host:port to listen on"},
+		{Name: "Slate", Flag: "-slate", Env: "WHEP_SLATE", Value: s.cfg.Slate, Default: "", Desc: "PNG or JPEG served as the \"slate\" source and put on air by failover when every candidate is missing; slate:<path> selects others beside it"},
+		{Name: "Config File", Flag: "-config", Env: "WHEP_CONFIG", Value: s.cfg.ConfigFile, Default: "", Desc: "JSON config file (ladder); see /config/ladder"},
 	}
 
 	// Additional environment-only controls
 	envOnly := []row{
 		{Name: "NDI Source Name", Flag: "(n/a)", Env: "NDI_SOURCE", Value: getenv("NDI_SOURCE"), Default: "", Desc: "Preferred NDI source display name"},
 		{Name: "NDI Source URL", Flag: "(n/a)", Env: "NDI_SOURCE_URL", Value: getenv("NDI_SOURCE_URL"), Default: "", Desc: "Preferred NDI source URL (ndi://...)"},
+		{Name: "Source Order", Flag: "-source-order", Env: "WHEP_SOURCE_ORDER", Value: strings.Join(s.sourceOrder(), ","), Default: strings.Join(defaultSourceOrder, ","), Desc: "Order the shared pipeline tries the selection API, NDI_SOURCE_URL, NDI_SOURCE and the first discovered source"},
 		{Name: "NDI Groups", Flag: "(n/a)", Env: "NDI_GROUPS", Value: getenv("NDI_GROUPS"), Default: "", Desc: "Comma-separated NDI groups for discovery"},
 		{Name: "NDI Extra IPs", Flag: "(n/a)", Env: "NDI_EXTRA_IPS", Value: getenv("NDI_EXTRA_IPS"), Default: "", Desc: "Comma-separated unicast IPs for discovery"},
 		{Name: "YUV BGRA Order", Flag: "(n/a)", Env: "YUV_BGRA_ORDER", Value: getenv("YUV_BGRA_ORDER"), Default: "", Desc: "Override BGRA byte order for converters"},
@@ -1404,6 +2298,7 @@ func (s *WhepServer) handleConfig(w http.ResponseWriter, r *http.Request) {
 	runtimeInfo := []row{
 		{Name: "Selected NDI Name", Flag: "(runtime)", Env: "(runtime)", Value: selNDIName, Default: "", Desc: "Current selected source name"},
 		{Name: "Selected NDI URL", Flag: "(runtime)", Env: "(runtime)", Value: selNDIURL, Default: "", Desc: "Current selected source URL"},
+		{Name: "NDI Runtime", Flag: "(runtime)", Env: "NDI_RUNTIME_DIR_V6", Value: ndiRuntimeSummary(), Default: "", Desc: "NDI SDK version and load path, or why NDI is unavailable"},
 		{Name: "Color Conversion", Flag: "(build)", Env: "(build)", Value: stream.ColorConversionImpl(), Default: "", Desc: "libyuv or pure-go"},
 	}
 
@@ -1433,6 +2328,19 @@ func (s *WhepServer) handleConfig(w http.ResponseWriter, r *http.Request) {
 	_, _ = io.WriteString(w, b.String())
 }
 
+// ndiRuntimeSummary is the /config page's one-line NDI runtime status.
+func ndiRuntimeSummary() string {
+	info := ndi.SDK()
+	if !info.OK() {
+		return info.Message
+	}
+	v := info.Version + " (" + info.RuntimePath + ")"
+	if info.Message != "" {
+		v += "; " + info.Message
+	}
+	return v
+}
+
 // htmlEscape performs minimal HTML escaping for text nodes
 func htmlEscape(s string) string {
 	s = strings.ReplaceAll(s, "&", "&amp;")
@@ -1451,7 +2359,7 @@ const indexHTML = `<!doctype html>
   <li><a href="/config">/config</a> — configuration and runtime info</li>
   <li><a href="/health">/health</a> — health/metrics (JSON)</li>
   <li><code>POST /whep</code> — WHEP endpoint (send SDP offer)</li>
-  <li><code>GET /frame</code> — latest frame as PNG (when available)</li>
+  <li><code>GET /frame</code> — latest frame as PNG (when available); <code>/frame/{key}</code> for one source</li>
   <li><code>GET /ndi/sources</code> — list NDI sources</li>
   <li><code>POST /ndi/select</code> — select NDI by name substring</li>
   <li><code>POST /ndi/select_url</code> — select NDI by URL</li>
@@ -0,0 +1,700 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+	"github.com/pion/webrtc/v3/pkg/media"
+
+	"whep/internal/ndi"
+	"whep/internal/stream"
+)
+
+// fakeURL is a source that's always listed and opens synthetic, counting
+// its opens and the receivers still open. Opening takes a while, so
+// concurrent requests overlap it.
+const fakeURL = "fake://"
+
+var fakeOpens, fakeLive atomic.Int32
+
+// fakeSource stands in for a connected receiver, counted in fakeLive until
+// stopped.
+type fakeSource struct {
+	stream.Source
+	once sync.Once
+}
+
+func (f *fakeSource) Stop() { f.once.Do(func() { fakeLive.Add(-1); f.Source.Stop() }) }
+
+func init() {
+	registerSourceFactory(&sourceFactory{
+		scheme: "fake",
+		listed: &struct{ Name, URL string }{Name: "Fake", URL: fakeURL},
+		open: func(s *WhepServer, name, url string, w, h int, fps stream.Rate) (stream.Source, error) {
+			fakeOpens.Add(1)
+			time.Sleep(20 * time.Millisecond)
+			fakeLive.Add(1)
+			return &fakeSource{Source: stream.NewSynthetic(64, 36, 30, 1)}, nil
+		},
+	})
+}
+
+var fakeKey = slugKey("Fake", fakeURL)
+
+// fakePipeline stands in for an encoder: it writes a one-byte sample to
+// its track every few milliseconds until stopped. The samples carry no
+// keyframe tag, so a new sink takes them straight away.
+type fakePipeline struct {
+	quit chan struct{}
+	once sync.Once
+}
+
+func (p *fakePipeline) Stop()          { p.once.Do(func() { close(p.quit) }) }
+func (p *fakePipeline) ForceKeyframe() {}
+func (p *fakePipeline) SetBitrate(int) {}
+func (p *fakePipeline) Bitrate() int   { return 0 }
+
+func (p *fakePipeline) run(track interface{}) {
+	w, _ := track.(interface{ WriteSample(media.Sample) error })
+	t := time.NewTicker(5 * time.Millisecond)
+	defer t.Stop()
+	for {
+		select {
+		case <-p.quit:
+			return
+		case <-t.C:
+			if w != nil {
+				_ = w.WriteSample(media.Sample{Data: []byte{1}, Duration: 5 * time.Millisecond})
+			}
+		}
+	}
+}
+
+// fakePipelines replaces every codec's pipeline with a fakePipeline for the
+// test and returns the number of pipelines started and still running.
+func fakePipelines(t *testing.T) (started, running *atomic.Int32) {
+	t.Helper()
+	started, running = &atomic.Int32{}, &atomic.Int32{}
+	saved := pipelineStarters
+	pipelineStarters = map[string]func(stream.PipelineConfig) (videoPipeline, error){}
+	for codec := range saved {
+		pipelineStarters[codec] = func(pc stream.PipelineConfig) (videoPipeline, error) {
+			started.Add(1)
+			running.Add(1)
+			p := &fakePipeline{quit: make(chan struct{})}
+			go func() {
+				p.run(pc.Track)
+				running.Add(-1)
+			}()
+			return p, nil
+		}
+	}
+	t.Cleanup(func() { pipelineStarters = saved })
+	return started, running
+}
+
+// failPipelines makes every codec's pipeline fail to start for the test.
+func failPipelines(t *testing.T) {
+	t.Helper()
+	saved := pipelineStarters
+	pipelineStarters = map[string]func(stream.PipelineConfig) (videoPipeline, error){}
+	for codec := range saved {
+		pipelineStarters[codec] = func(stream.PipelineConfig) (videoPipeline, error) {
+			return nil, errors.New("no encoder")
+		}
+	}
+	t.Cleanup(func() { pipelineStarters = saved })
+}
+
+// newTestServer starts a server behind an httptest server, with opts
+// applied to its config, and closes both when the test ends.
+func newTestServer(t *testing.T, opts ...func(*Config)) (*WhepServer, *httptest.Server) {
+	t.Helper()
+	cfg := Config{Width: 64, Height: 36, FPS: stream.IntRate(30), BitrateKbps: 500}
+	for _, o := range opts {
+		o(&cfg)
+	}
+	s := NewWhepServer(cfg)
+	mux := http.NewServeMux()
+	s.RegisterRoutes(mux)
+	ts := httptest.NewServer(mux)
+	t.Cleanup(func() {
+		ts.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = s.Close(ctx)
+	})
+	return s, ts
+}
+
+// newOffer returns a receive-only video offer and the peer connection that
+// made it, closed when the test ends.
+func newOffer(t *testing.T) (*webrtc.PeerConnection, string) {
+	t.Helper()
+	pc, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = pc.Close() })
+	if _, err := pc.AddTransceiverFromKind(webrtc.RTPCodecTypeVideo, webrtc.RTPTransceiverInit{Direction: webrtc.RTPTransceiverDirectionRecvonly}); err != nil {
+		t.Fatal(err)
+	}
+	offer, err := pc.CreateOffer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gathered := webrtc.GatheringCompletePromise(pc)
+	if err := pc.SetLocalDescription(offer); err != nil {
+		t.Fatal(err)
+	}
+	<-gathered
+	return pc, pc.LocalDescription().SDP
+}
+
+// viewer is a POSTed offer: the offering peer, the server's reply and a
+// channel closed once the first video packet arrives.
+type viewer struct {
+	pc       *webrtc.PeerConnection
+	code     int
+	body     string
+	location string
+	media    chan struct{}
+}
+
+// postOffer POSTs a fresh offer to path. An answer is applied to the
+// offering peer, so both ends connect and close cleanly.
+func postOffer(t *testing.T, ts *httptest.Server, path string) *viewer {
+	t.Helper()
+	pc, sdp := newOffer(t)
+	v := &viewer{pc: pc, media: make(chan struct{})}
+	var once sync.Once
+	pc.OnTrack(func(tr *webrtc.TrackRemote, _ *webrtc.RTPReceiver) {
+		for {
+			if _, _, err := tr.ReadRTP(); err != nil {
+				return
+			}
+			once.Do(func() { close(v.media) })
+		}
+	})
+	resp, err := http.Post(ts.URL+path, "application/sdp", strings.NewReader(sdp))
+	if err != nil {
+		t.Error(err)
+		return v
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	v.code, v.body, v.location = resp.StatusCode, string(body), resp.Header.Get("Location")
+	if v.code == http.StatusCreated {
+		if err := pc.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeAnswer, SDP: v.body}); err != nil {
+			t.Error(err)
+		}
+	}
+	return v
+}
+
+func TestConcurrentPostsShareOneMount(t *testing.T) {
+	started, running := fakePipelines(t)
+	s, ts := newTestServer(t)
+	opens := fakeOpens.Load()
+	const posts = 20
+	var wg sync.WaitGroup
+	for i := 0; i < posts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if v := postOffer(t, ts, "/whep/ndi/"+fakeKey); v.code != http.StatusCreated {
+				t.Errorf("POST: %d %s", v.code, v.body)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := fakeOpens.Load() - opens; n != 1 {
+		t.Errorf("source opened %d times, want 1", n)
+	}
+	if n := started.Load(); n != 1 {
+		t.Errorf("%d pipelines started, want 1", n)
+	}
+	if n := running.Load(); n != 1 {
+		t.Errorf("%d pipelines running, want 1", n)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.mounts) != 1 {
+		t.Fatalf("%d mounts, want 1", len(s.mounts))
+	}
+	for _, m := range s.mounts {
+		if len(m.sessions) != posts {
+			t.Errorf("mount has %d sessions, want %d", len(m.sessions), posts)
+		}
+	}
+	if len(s.sessions) != posts {
+		t.Errorf("%d sessions, want %d", len(s.sessions), posts)
+	}
+}
+
+// sessionIDs returns the ids of the server's sessions.
+func sessionIDs(s *WhepServer) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ids := make([]string, 0, len(s.sessions))
+	for id := range s.sessions {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func TestAttachDuringIdleTeardown(t *testing.T) {
+	fakePipelines(t)
+	s, ts := newTestServer(t)
+	first := postOffer(t, ts, "/whep/ndi/"+fakeKey)
+	if first.code != http.StatusCreated {
+		t.Fatalf("POST: %d %s", first.code, first.body)
+	}
+	for i := 0; i < 10; i++ {
+		// The last viewer leaves while the next one joins, with the idle
+		// teardown firing throughout
+		prev := sessionIDs(s)
+		stop := make(chan struct{})
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for _, id := range prev {
+				s.closeSession(id)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				s.mu.Lock()
+				keys := make([]string, 0, len(s.mounts))
+				for key := range s.mounts {
+					keys = append(keys, key)
+				}
+				s.mu.Unlock()
+				for _, key := range keys {
+					s.teardownMountIfIdle(key)
+				}
+			}
+		}()
+		v := postOffer(t, ts, "/whep/ndi/"+fakeKey)
+		close(stop)
+		wg.Wait()
+		if v.code != http.StatusCreated {
+			t.Fatalf("round %d: POST: %d %s", i, v.code, v.body)
+		}
+		select {
+		case <-v.media:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("round %d: joined a mount that was torn down; no media", i)
+		}
+	}
+}
+
+// checkNoLiveSources fails the test if a fake receiver is still open.
+func checkNoLiveSources(t *testing.T, live int32) {
+	t.Helper()
+	if n := fakeLive.Load() - live; n != 0 {
+		t.Errorf("%d receivers left open", n)
+	}
+}
+
+func TestFailedMountStartStopsSource(t *testing.T) {
+	failPipelines(t)
+	s, ts := newTestServer(t)
+	live := fakeLive.Load()
+	for i := 0; i < 3; i++ {
+		if v := postOffer(t, ts, "/whep/ndi/"+fakeKey); v.code != http.StatusServiceUnavailable {
+			t.Fatalf("POST: %d %s, want 503", v.code, v.body)
+		}
+	}
+	checkNoLiveSources(t, live)
+	s.mu.Lock()
+	n := len(s.mounts)
+	s.mu.Unlock()
+	if n != 0 {
+		t.Fatalf("%d mounts left after failed starts", n)
+	}
+	// The next request gets a mount of its own, not the broken one
+	fakePipelines(t)
+	v := postOffer(t, ts, "/whep/ndi/"+fakeKey)
+	if v.code != http.StatusCreated {
+		t.Fatalf("POST after failures: %d %s", v.code, v.body)
+	}
+	select {
+	case <-v.media:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no media from the new mount")
+	}
+}
+
+// selectFake makes the fake source the shared pipeline's selection.
+func selectFake(s *WhepServer) {
+	s.mu.Lock()
+	s.ndiName, s.ndiURL = "Fake", fakeURL
+	s.mu.Unlock()
+}
+
+func TestFailedSharedStartStopsSource(t *testing.T) {
+	failPipelines(t)
+	s, ts := newTestServer(t)
+	selectFake(s)
+	live := fakeLive.Load()
+	if v := postOffer(t, ts, "/whep"); v.code != http.StatusInternalServerError {
+		t.Fatalf("POST: %d %s, want 500", v.code, v.body)
+	}
+	checkNoLiveSources(t, live)
+	s.mu.Lock()
+	bc := s.shareBC
+	s.mu.Unlock()
+	if bc != nil {
+		t.Fatal("shared pipeline left behind by a failed start")
+	}
+}
+
+func TestFailedSharedRestartStopsSource(t *testing.T) {
+	fakePipelines(t)
+	s, ts := newTestServer(t)
+	selectFake(s)
+	live := fakeLive.Load()
+	if v := postOffer(t, ts, "/whep"); v.code != http.StatusCreated {
+		t.Fatalf("POST: %d %s", v.code, v.body)
+	}
+	if n := fakeLive.Load() - live; n != 1 {
+		t.Fatalf("%d receivers open for the shared pipeline, want 1", n)
+	}
+	failPipelines(t)
+	if err := s.restartSharedPipeline(); err == nil {
+		t.Fatal("restart succeeded with no encoder")
+	}
+	checkNoLiveSources(t, live)
+}
+
+// TestSessionLifecycle checks every lifecycle field of a session is set
+// once it is registered, and that closeSession undoes each: the sink is
+// detached, the peer connection closed and the mount forgets the session.
+func TestSessionLifecycle(t *testing.T) {
+	for _, path := range []string{"/whep/ndi/" + fakeKey, "/whep"} {
+		t.Run(path, func(t *testing.T) {
+			fakePipelines(t)
+			s, ts := newTestServer(t)
+			selectFake(s)
+			v := postOffer(t, ts, path)
+			if v.code != http.StatusCreated {
+				t.Fatalf("POST: %d %s", v.code, v.body)
+			}
+			select {
+			case <-v.media:
+			case <-time.After(5 * time.Second):
+				t.Fatal("no media")
+			}
+			ids := sessionIDs(s)
+			if len(ids) != 1 {
+				t.Fatalf("%d sessions, want 1", len(ids))
+			}
+			id := ids[0]
+			// The server's end reports Connected a little after media flows
+			deadline := time.Now().Add(5 * time.Second)
+			var sess *session
+			for {
+				s.mu.Lock()
+				sess = s.sessions[id]
+				state := sess.state
+				s.mu.Unlock()
+				if state == webrtc.PeerConnectionStateConnected.String() {
+					break
+				}
+				if time.Now().After(deadline) {
+					t.Fatalf("session state %q, want connected", state)
+				}
+				time.Sleep(5 * time.Millisecond)
+			}
+
+			s.mu.Lock()
+			var bc *stream.SampleBroadcaster
+			var m *ndiMount
+			if sess.mountKey != "" {
+				m = s.mounts[sess.mountKey]
+				if m != nil {
+					bc = m.bc
+				}
+			} else {
+				bc = s.shareBC
+			}
+			if sess.id != id || sess.pc == nil || sess.sender == nil || sess.track == nil || sess.codec == "" ||
+				sess.created.IsZero() || sess.connectCancel == nil || sess.detach == nil || sess.rtcp == nil {
+				t.Errorf("session fields unset: %+v", sess)
+			}
+			detach := sess.detach
+			var detached atomic.Int32
+			sess.detach = func() { detached.Add(1); detach() }
+			s.mu.Unlock()
+			if path == "/whep" && sess.mountKey != "" {
+				t.Errorf("shared session has mount %q", sess.mountKey)
+			}
+			if path != "/whep" {
+				if m == nil {
+					t.Fatalf("session's mount %q isn't in the map", sess.mountKey)
+				}
+				m.mu.Lock()
+				_, in := m.sessions[id]
+				m.mu.Unlock()
+				if !in {
+					t.Error("mount doesn't list the session")
+				}
+			}
+			if bc == nil || len(bc.Stats()) != 1 {
+				t.Fatal("session isn't a sink of its broadcaster")
+			}
+
+			if !s.closeSession(id) {
+				t.Fatal("closeSession didn't find the session")
+			}
+			if n := detached.Load(); n != 1 {
+				t.Errorf("detached %d times, want 1", n)
+			}
+			if n := len(bc.Stats()); n != 0 {
+				t.Errorf("broadcaster still has %d sinks", n)
+			}
+			if st := sess.pc.ConnectionState(); st != webrtc.PeerConnectionStateClosed {
+				t.Errorf("peer connection %s, want closed", st)
+			}
+			if len(sessionIDs(s)) != 0 {
+				t.Error("session still registered")
+			}
+			if m != nil {
+				m.mu.Lock()
+				_, in := m.sessions[id]
+				m.mu.Unlock()
+				if in {
+					t.Error("mount still lists the session")
+				}
+			}
+			// A second close, e.g. from the state callback, is a no-op
+			if s.closeSession(id) {
+				t.Error("second closeSession found the session")
+			}
+			if n := detached.Load(); n != 1 {
+				t.Errorf("detached %d times after a second close, want 1", n)
+			}
+		})
+	}
+}
+
+func TestSessionResourceGetReturnsAnswer(t *testing.T) {
+	fakePipelines(t)
+	s, ts := newTestServer(t)
+	selectFake(s)
+	get := func(url string) (int, string, string) {
+		t.Helper()
+		resp, err := http.Get(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, resp.Header.Get("Content-Type"), string(b)
+	}
+	for _, path := range []string{"/whep", "/whep/ndi/" + fakeKey} {
+		t.Run(path, func(t *testing.T) {
+			v := postOffer(t, ts, path)
+			if v.code != http.StatusCreated {
+				t.Fatalf("POST: %d %s", v.code, v.body)
+			}
+			defer v.pc.Close()
+			code, ctype, body := get(v.location)
+			if code != http.StatusOK || ctype != "application/sdp" {
+				t.Fatalf("GET %s: %d %q", v.location, code, ctype)
+			}
+			// The same session: same ICE credentials and DTLS fingerprint
+			for _, attr := range []string{"a=ice-ufrag:", "a=fingerprint:"} {
+				if want := sdpLine(v.body, attr); want == "" || sdpLine(body, attr) != want {
+					t.Errorf("GET answer %s is %q, POST answer's %q", attr, sdpLine(body, attr), want)
+				}
+			}
+			req, _ := http.NewRequest(http.MethodDelete, v.location, nil)
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if code, _, body := get(v.location); code != http.StatusNotFound {
+				t.Fatalf("GET after DELETE: %d %s", code, body)
+			}
+		})
+	}
+}
+
+// sdpLine returns the first line of sdp starting with prefix, or "".
+func sdpLine(sdp, prefix string) string {
+	for _, l := range strings.Split(sdp, "\r\n") {
+		if strings.HasPrefix(l, prefix) {
+			return l
+		}
+	}
+	return ""
+}
+
+// postSDP POSTs sdp as is to path, for tests that resend one offer.
+func postSDP(t *testing.T, ts *httptest.Server, path, sdp string) (code int, body, location string) {
+	t.Helper()
+	resp, err := http.Post(ts.URL+path, "application/sdp", strings.NewReader(sdp))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(b), resp.Header.Get("Location")
+}
+
+func TestDuplicateOfferReplaysSession(t *testing.T) {
+	for _, path := range []string{"/whep", "/whep/ndi/" + fakeKey} {
+		t.Run(path, func(t *testing.T) {
+			fakePipelines(t)
+			s, ts := newTestServer(t)
+			selectFake(s)
+			_, sdp := newOffer(t)
+			code, answer, loc := postSDP(t, ts, path, sdp)
+			if code != http.StatusCreated {
+				t.Fatalf("POST: %d %s", code, answer)
+			}
+			deduped := s.offers.deduped.Load()
+			code2, answer2, loc2 := postSDP(t, ts, path, sdp)
+			if code2 != http.StatusCreated {
+				t.Fatalf("repeated POST: %d %s", code2, answer2)
+			}
+			if loc2 != loc || answer2 != answer {
+				t.Errorf("repeated POST got Location %q and a different answer (%v), want %q and the same", loc2, answer2 != answer, loc)
+			}
+			if n := len(sessionIDs(s)); n != 1 {
+				t.Errorf("%d sessions, want 1", n)
+			}
+			if n := s.offers.deduped.Load() - deduped; n != 1 {
+				t.Errorf("offers.deduped went up by %d, want 1", n)
+			}
+
+			// Once the session is gone the same offer starts a new one
+			req, _ := http.NewRequest(http.MethodDelete, loc, nil)
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			code3, body3, loc3 := postSDP(t, ts, path, sdp)
+			if code3 != http.StatusCreated {
+				t.Fatalf("POST after DELETE: %d %s", code3, body3)
+			}
+			if loc3 == loc {
+				t.Errorf("POST after DELETE got the deleted session's Location %q", loc3)
+			}
+			if ids := sessionIDs(s); len(ids) != 1 || strings.HasSuffix(loc, ids[0]) {
+				t.Errorf("sessions %v after DELETE and a new POST, want one new one", ids)
+			}
+			if n := s.offers.deduped.Load() - deduped; n != 1 {
+				t.Errorf("offers.deduped went up by %d, want still 1", n)
+			}
+		})
+	}
+}
+
+func TestDuplicateOfferDedupeOff(t *testing.T) {
+	fakePipelines(t)
+	s, ts := newTestServer(t, func(c *Config) { c.OfferDedupeWindow = -1 })
+	_, sdp := newOffer(t)
+	path := "/whep/ndi/" + fakeKey
+	code, body, loc := postSDP(t, ts, path, sdp)
+	if code != http.StatusCreated {
+		t.Fatalf("POST: %d %s", code, body)
+	}
+	code2, body2, loc2 := postSDP(t, ts, path, sdp)
+	if code2 != http.StatusCreated {
+		t.Fatalf("repeated POST: %d %s", code2, body2)
+	}
+	if loc2 == loc {
+		t.Errorf("repeated POST replayed Location %q with dedupe off", loc)
+	}
+	if n := len(sessionIDs(s)); n != 2 {
+		t.Errorf("%d sessions, want 2", n)
+	}
+	if n := s.offers.deduped.Load(); n != 0 {
+		t.Errorf("offers.deduped %d, want 0", n)
+	}
+}
+
+// Startup doesn't wait for NDI: with its init stalled, monitoring, the
+// config page and a Splash session answer at once.
+func TestServesWhileNDIInitializes(t *testing.T) {
+	fakePipelines(t)
+	release := make(chan struct{})
+	saved := ndiInitialize
+	ndiInitialize = func() bool {
+		<-release
+		return saved()
+	}
+	t.Cleanup(func() { ndiInitialize = saved })
+	s, ts := newTestServer(t)
+	t.Cleanup(func() { close(release) })
+	s.mu.Lock()
+	s.ndiName, s.ndiURL = "Splash", "splash://"
+	s.mu.Unlock()
+	_, offer := newOffer(t)
+
+	timed := func(what string, do func() int, want int) {
+		t.Helper()
+		start := time.Now()
+		code := do()
+		if d := time.Since(start); d > 100*time.Millisecond {
+			t.Errorf("%s took %v while NDI initializes", what, d)
+		}
+		if code != want {
+			t.Errorf("%s: %d, want %d", what, code, want)
+		}
+	}
+	get := func(path string) func() int {
+		return func() int {
+			resp, err := http.Get(ts.URL + path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			return resp.StatusCode
+		}
+	}
+	timed("GET /health", get("/health"), http.StatusOK)
+	timed("GET /config", get("/config"), http.StatusOK)
+	timed("POST /whep", func() int {
+		code, body, _ := postSDP(t, ts, "/whep", offer)
+		if code != http.StatusCreated {
+			t.Log(body)
+		}
+		return code
+	}, http.StatusCreated)
+
+	resp, err := http.Get(ts.URL + "/health")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var health struct {
+		State string `json:"ndi_state"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&health)
+	resp.Body.Close()
+	if err != nil || health.State != ndi.StateInitializing {
+		t.Errorf("ndi_state %q (%v), want %q", health.State, err, ndi.StateInitializing)
+	}
+}
@@ -0,0 +1,163 @@
+package server
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// sessionTrack is one sender's RTP counters from the stats interceptor.
+type sessionTrack struct {
+	Kind        string `json:"kind"`
+	SSRC        uint32 `json:"ssrc"`
+	PacketsSent uint64 `json:"packets_sent"`
+	BytesSent   uint64 `json:"bytes_sent"`
+	NACK        uint32 `json:"nack"`
+	PLI         uint32 `json:"pli"`
+	FIR         uint32 `json:"fir"`
+}
+
+// sessionCandidate is one end of the selected ICE candidate pair.
+type sessionCandidate struct {
+	Address  string `json:"address"`
+	Protocol string `json:"protocol"`
+	Type     string `json:"type"`
+}
+
+// sessionInfo is an entry of GET /sessions. Totals sum the tracks.
+type sessionInfo struct {
+	ID          string            `json:"id"`
+	Mount       string            `json:"mount"` // mount key, or "shared" for /whep
+	Codec       string            `json:"codec"`
+	Created     time.Time         `json:"created"`
+	State       string            `json:"state"`
+	Local       *sessionCandidate `json:"local,omitempty"`
+	Remote      *sessionCandidate `json:"remote,omitempty"`
+	PacketsSent uint64            `json:"packets_sent"`
+	BytesSent   uint64            `json:"bytes_sent"`
+	NACK        uint32            `json:"nack"`
+	PLI         uint32            `json:"pli"`
+	FIR         uint32            `json:"fir"`
+	Tracks      []sessionTrack    `json:"tracks"`
+}
+
+// GET /sessions lists sessions with transport and RTP stats;
+// DELETE /sessions/{id} disconnects one.
+func (s *WhepServer) handleSessions(w http.ResponseWriter, r *http.Request) {
+	allowCORS(w, r)
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/sessions"), "/")
+	switch r.Method {
+	case http.MethodOptions:
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodGet:
+		if id != "" {
+			info, ok := s.sessionInfo(id)
+			if !ok {
+				writeError(w, r, http.StatusNotFound, errCodeNotFound, "session not found", nil)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(info)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(s.sessionInfos())
+	case http.MethodDelete:
+		if id == "" {
+			writeError(w, r, http.StatusNotFound, errCodeNotFound, "session not found", nil)
+			return
+		}
+		s.deleteSession(w, r, id)
+	default:
+		writeError(w, r, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "method not allowed", nil)
+	}
+}
+
+// sessionInfos snapshots every session, oldest first.
+func (s *WhepServer) sessionInfos() []sessionInfo {
+	s.mu.Lock()
+	list := make([]*session, 0, len(s.sessions))
+	for _, ss := range s.sessions {
+		list = append(list, ss)
+	}
+	s.mu.Unlock()
+	out := make([]sessionInfo, 0, len(list))
+	for _, ss := range list {
+		out = append(out, s.describeSession(ss))
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Created.Before(out[j].Created) })
+	return out
+}
+
+func (s *WhepServer) sessionInfo(id string) (sessionInfo, bool) {
+	s.mu.Lock()
+	ss := s.sessions[id]
+	s.mu.Unlock()
+	if ss == nil {
+		return sessionInfo{}, false
+	}
+	return s.describeSession(ss), true
+}
+
+// describeSession gathers ss's stats. PeerConnection stats are collected
+// without s.mu held.
+func (s *WhepServer) describeSession(ss *session) sessionInfo {
+	s.mu.Lock()
+	info := sessionInfo{ID: ss.id, Mount: ss.mountKey, Codec: ss.codec, Created: ss.created.UTC(), State: ss.state, Tracks: []sessionTrack{}}
+	s.mu.Unlock()
+	if info.Mount == "" {
+		info.Mount = sharedMetricsLabel
+	}
+	if info.State == "" {
+		info.State = ss.pc.ConnectionState().String()
+	}
+	info.Local, info.Remote = selectedCandidates(ss.pc)
+	if ss.stats == nil {
+		return info
+	}
+	for _, sender := range ss.pc.GetSenders() {
+		tr := sender.Track()
+		enc := sender.GetParameters().Encodings
+		if tr == nil || len(enc) == 0 {
+			continue
+		}
+		st := ss.stats.Get(uint32(enc[0].SSRC))
+		if st == nil {
+			continue
+		}
+		o := st.OutboundRTPStreamStats
+		t := sessionTrack{Kind: tr.Kind().String(), SSRC: uint32(enc[0].SSRC), PacketsSent: o.PacketsSent, BytesSent: o.BytesSent, NACK: o.NACKCount, PLI: o.PLICount, FIR: o.FIRCount}
+		info.Tracks = append(info.Tracks, t)
+		info.PacketsSent += t.PacketsSent
+		info.BytesSent += t.BytesSent
+		info.NACK += t.NACK
+		info.PLI += t.PLI
+		info.FIR += t.FIR
+	}
+	return info
+}
+
+// selectedCandidates returns the nominated ICE candidate pair, if any.
+func selectedCandidates(pc *webrtc.PeerConnection) (local, remote *sessionCandidate) {
+	report := pc.GetStats()
+	for _, v := range report {
+		pair, ok := v.(webrtc.ICECandidatePairStats)
+		if !ok || !pair.Nominated || pair.State != webrtc.StatsICECandidatePairStateSucceeded {
+			continue
+		}
+		if c, ok := report[pair.LocalCandidateID].(webrtc.ICECandidateStats); ok {
+			local = &sessionCandidate{Address: net.JoinHostPort(c.IP, strconv.Itoa(int(c.Port))), Protocol: c.Protocol, Type: c.CandidateType.String()}
+		}
+		if c, ok := report[pair.RemoteCandidateID].(webrtc.ICECandidateStats); ok {
+			remote = &sessionCandidate{Address: net.JoinHostPort(c.IP, strconv.Itoa(int(c.Port))), Protocol: c.Protocol, Type: c.CandidateType.String()}
+		}
+		return local, remote
+	}
+	return nil, nil
+}
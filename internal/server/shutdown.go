@@ -0,0 +1,102 @@
+package server
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"whep/internal/ndi"
+	"whep/internal/stream"
+)
+
+// refuseIfDraining answers 503 draining once Close has started, so no new
+// session or receiver is opened while the server shuts down.
+func (s *WhepServer) refuseIfDraining(w http.ResponseWriter, r *http.Request) bool {
+	if !s.draining.Load() {
+		return false
+	}
+	w.Header().Set("Connection", "close")
+	writeError(w, r, http.StatusServiceUnavailable, errCodeDraining, "server is shutting down", nil)
+	return true
+}
+
+// Close shuts the server down: it refuses new sessions, closes every session,
+// tears down all mounts and the shared pipeline, stops NDI discovery and
+// waits, bounded by ctx, for pipeline and source goroutines to exit. The NDI
+// runtime is released only when they all did, since destroying it under a
+// live receiver is what leaves it wedged. Close is safe to call once; it
+// returns ctx.Err() if the wait timed out.
+func (s *WhepServer) Close(ctx context.Context) error {
+	if s.draining.Swap(true) {
+		return nil
+	}
+	close(s.done)
+	s.cancelAllSwitches()
+
+	// A handler past its draining check may still register a session, so
+	// repeat until none are left
+	for {
+		s.mu.Lock()
+		ids := make([]string, 0, len(s.sessions))
+		for id := range s.sessions {
+			ids = append(ids, id)
+		}
+		s.mu.Unlock()
+		if len(ids) == 0 {
+			break
+		}
+		for _, id := range ids {
+			s.closeSession(id)
+		}
+	}
+
+	for _, p := range s.whip.list() {
+		s.endPublisher(p.id, "server shutting down")
+	}
+	s.mu.Lock()
+	mounts := make([]*ndiMount, 0, len(s.mounts))
+	for key, m := range s.mounts {
+		mounts = append(mounts, m)
+		delete(s.mounts, key)
+		s.changes.bump()
+	}
+	s.stopSharedLocked()
+	s.mu.Unlock()
+	for _, m := range mounts {
+		m.teardown()
+	}
+	s.frameRx.closeAll()
+	if s.pipe != nil {
+		s.pipe.Stop()
+	}
+	log.Printf("Shutdown: closed sessions, %d mount(s) and the shared pipeline", len(mounts))
+
+	stopped := make(chan struct{})
+	go func() {
+		// An init still running would start discovery after the stop
+		<-s.ndiInit
+		ndi.StopBackgroundDiscovery()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	if err := stream.WaitIdle(ctx); err != nil {
+		log.Printf("Shutdown: pipelines still running: %v", stream.GetRuntimeStats())
+		return err
+	}
+	// Probes and /frame grabs hold receivers outside any pipeline
+	for ndi.ActiveReceivers() > 0 {
+		select {
+		case <-ctx.Done():
+			log.Printf("Shutdown: %d NDI receiver(s) still open", ndi.ActiveReceivers())
+			return ctx.Err()
+		case <-time.After(20 * time.Millisecond):
+		}
+	}
+	ndi.Destroy()
+	return nil
+}
@@ -0,0 +1,7 @@
+//go:build !linux && !darwin && !freebsd
+
+package server
+
+// watchProfileSignal is a no-op where SIGUSR2 doesn't exist; use
+// POST /admin/profile instead.
+func (s *WhepServer) watchProfileSignal() {}
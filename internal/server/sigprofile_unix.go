@@ -0,0 +1,36 @@
+//go:build linux || darwin || freebsd
+
+package server
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// sigProfileDuration is the length of a SIGUSR2-triggered CPU profile.
+const sigProfileDuration = 30 * time.Second
+
+// watchProfileSignal writes a CPU profile to the state directory on each
+// SIGUSR2 until the server closes.
+func (s *WhepServer) watchProfileSignal() {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGUSR2)
+	defer signal.Stop(ch)
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ch:
+			log.Printf("SIGUSR2: taking a %s CPU profile", sigProfileDuration)
+			path, err := s.profileToFile(sigProfileDuration)
+			if err != nil {
+				log.Printf("SIGUSR2: CPU profile failed: %v", err)
+				continue
+			}
+			log.Printf("SIGUSR2: CPU profile written to %s", path)
+		}
+	}
+}
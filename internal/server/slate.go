@@ -0,0 +1,98 @@
+package server
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"whep/internal/stream"
+)
+
+// Slates. A slate is a still PNG or JPEG served as a source (see
+// stream.SlateSource), letterboxed to the pipeline size. -slate names the
+// default one, listed as the "slate" mount; "slate:<path>" names any image
+// under the slate directory, e.g. POST /ndi/select {"source":
+// "slate:brb.png"} cuts the program to a "be right back" card. A failover
+// rule whose candidates are all missing puts the default slate on air
+// until one returns.
+
+const slateScheme = "slate"
+
+var (
+	errSlateOff  = errors.New("no slate configured (-slate)")
+	errSlatePath = errors.New("slate path must be relative to the slate directory")
+)
+
+// slateListing is the default slate in the source index.
+var slateListing = struct{ Name, URL string }{Name: "Slate", URL: "slate://"}
+
+func init() {
+	registerSourceFactory(&sourceFactory{
+		scheme:  slateScheme,
+		aliases: []string{"slate"},
+		open: func(s *WhepServer, name, url string, w, h int, fps stream.Rate) (stream.Source, error) {
+			path, err := s.slatePath(url)
+			if err != nil {
+				return nil, err
+			}
+			w, h = s.syntheticSize(w, h)
+			return stream.NewSlateSource(path, w, h, fps)
+		},
+	})
+}
+
+// isSlate reports whether a selection names a slate: "slate" or
+// "slate:<path>".
+func isSlate(q string) bool {
+	q = strings.ToLower(strings.TrimSpace(q))
+	return q == slateScheme || strings.HasPrefix(q, slateScheme+":")
+}
+
+// slateDir is where "slate:<path>" paths are looked up: the default
+// slate's directory, else "slates" in the state directory.
+func (s *WhepServer) slateDir() string {
+	if s.cfg.Slate != "" {
+		return filepath.Dir(s.cfg.Slate)
+	}
+	return filepath.Join(s.stateDir(), "slates")
+}
+
+// slatePath is the image file of a slate selection or URL ("slate",
+// "slate:brb.png", "slate://brb.png"); the default slate when no path is
+// given.
+func (s *WhepServer) slatePath(q string) (string, error) {
+	q = strings.TrimSpace(q)
+	p := ""
+	if len(q) > len(slateScheme) {
+		p = strings.TrimPrefix(q[len(slateScheme)+1:], "//")
+	}
+	if p == "" {
+		if s.cfg.Slate == "" {
+			return "", errSlateOff
+		}
+		return s.cfg.Slate, nil
+	}
+	if !filepath.IsLocal(p) {
+		return "", errSlatePath
+	}
+	return filepath.Join(s.slateDir(), p), nil
+}
+
+// slateSource resolves a slate selection to a source name and URL, once
+// its file is there.
+func (s *WhepServer) slateSource(q string) (name, url string, err error) {
+	path, err := s.slatePath(q)
+	if err != nil {
+		return "", "", err
+	}
+	if _, err := os.Stat(path); err != nil {
+		return "", "", err
+	}
+	if path == s.cfg.Slate {
+		return slateListing.Name, slateListing.URL, nil
+	}
+	rel, _ := filepath.Rel(s.slateDir(), path)
+	rel = filepath.ToSlash(rel)
+	return "Slate " + rel, slateListing.URL + rel, nil
+}
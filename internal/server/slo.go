@@ -0,0 +1,335 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"whep/internal/stream"
+)
+
+const (
+	// sloInterval is how often mount states are sampled; transitions are
+	// timestamped to this resolution.
+	sloInterval = time.Second
+	// sloStaleAfter is how long a source may go without a frame before its
+	// mount counts as down.
+	sloStaleAfter = 2 * time.Second
+)
+
+// defaultSLOWindows are the rolling windows reported when none are configured.
+var defaultSLOWindows = []time.Duration{time.Hour, 24 * time.Hour}
+
+// ParseSLOWindows parses a comma-separated list of durations such as "1h,24h".
+func ParseSLOWindows(v string) ([]time.Duration, error) {
+	var out []time.Duration
+	for _, f := range strings.Split(v, ",") {
+		if f = strings.TrimSpace(f); f == "" {
+			continue
+		}
+		d, err := time.ParseDuration(f)
+		if err != nil || d < time.Minute {
+			return nil, fmt.Errorf("bad SLO window %q: want a duration of at least 1m", f)
+		}
+		out = append(out, d)
+	}
+	return out, nil
+}
+
+// Availability states. Idle (no viewers) time counts neither way.
+const (
+	sloIdle = "idle"
+	sloUp   = "up"
+	sloDown = "down"
+)
+
+// sloSegment is a span during which a mount had viewers; end is zero while
+// the span is still open.
+type sloSegment struct {
+	start, end time.Time
+	up         bool
+}
+
+// sloTracker records one mount's state changes. Only transitions append a
+// segment, so window sums cost one pass over the segments still inside the
+// longest window.
+type sloTracker struct {
+	state string
+	since time.Time
+	segs  []sloSegment
+}
+
+// observe moves the tracker to the state seen at now and drops segments
+// that ended more than keep ago.
+func (t *sloTracker) observe(now time.Time, state string, keep time.Duration) {
+	if state != t.state {
+		if n := len(t.segs); n > 0 && t.segs[n-1].end.IsZero() {
+			t.segs[n-1].end = now
+		}
+		if state != sloIdle {
+			t.segs = append(t.segs, sloSegment{start: now, up: state == sloUp})
+		}
+		t.state, t.since = state, now
+	}
+	cut := now.Add(-keep)
+	i := 0
+	for i < len(t.segs) && !t.segs[i].end.IsZero() && t.segs[i].end.Before(cut) {
+		i++
+	}
+	t.segs = t.segs[i:]
+}
+
+// window sums viewed and live time over the last d.
+func (t *sloTracker) window(now time.Time, d time.Duration) (viewed, live time.Duration) {
+	from := now.Add(-d)
+	for _, seg := range t.segs {
+		start, end := seg.start, seg.end
+		if end.IsZero() {
+			end = now
+		}
+		if start.Before(from) {
+			start = from
+		}
+		if !end.After(start) {
+			continue
+		}
+		viewed += end.Sub(start)
+		if seg.up {
+			live += end.Sub(start)
+		}
+	}
+	return viewed, live
+}
+
+// sloSet holds the server-level tracker and one per mount, keyed like
+// /metrics ("shared" for /whep).
+type sloSet struct {
+	mu      sync.Mutex
+	windows []time.Duration
+	server  sloTracker
+	mounts  map[string]*sloTracker
+}
+
+func (set *sloSet) keep() time.Duration {
+	var k time.Duration
+	for _, w := range set.windows {
+		k = max(k, w)
+	}
+	return k
+}
+
+// runSLO samples mount states until the server closes.
+func (s *WhepServer) runSLO() {
+	ticker := time.NewTicker(sloInterval)
+	defer ticker.Stop()
+	s.sampleSLO(time.Now())
+	for {
+		select {
+		case <-s.done:
+			return
+		case now := <-ticker.C:
+			s.sampleSLO(now)
+		}
+	}
+}
+
+// sloLive reports whether src is delivering real frames: not a synthetic
+// or Splash placeholder, and not silent for longer than sloStaleAfter.
+func sloLive(src stream.Source, now time.Time) bool {
+	if src == nil || stream.IsSynthetic(src) {
+		return false
+	}
+	at, ok := stream.LastFrameAt(src)
+	return !ok || (!at.IsZero() && now.Sub(at) < sloStaleAfter)
+}
+
+func sloStateOf(viewers int, live bool) string {
+	switch {
+	case viewers == 0:
+		return sloIdle
+	case live:
+		return sloUp
+	}
+	return sloDown
+}
+
+// sampleSLO observes every mount and the shared pipeline. The server is up
+// while it has viewers and every mount they watch is up.
+func (s *WhepServer) sampleSLO(now time.Time) {
+	states := map[string]string{}
+	s.mu.Lock()
+	shared := 0
+	for _, ss := range s.sessions {
+		if ss.mountKey == "" {
+			shared++
+		}
+	}
+	states[sharedMetricsLabel] = sloStateOf(shared, sloLive(s.shareSrc, now))
+	mounts := make([]*ndiMount, 0, len(s.mounts))
+	for _, m := range s.mounts {
+		mounts = append(mounts, m)
+	}
+	s.mu.Unlock()
+	for _, m := range mounts {
+		m.mu.Lock()
+		states[m.key] = sloStateOf(len(m.sessions), sloLive(m.src, now))
+		m.mu.Unlock()
+	}
+
+	server := sloIdle
+	for _, st := range states {
+		if st == sloDown {
+			server = sloDown
+			break
+		}
+		if st == sloUp {
+			server = sloUp
+		}
+	}
+
+	set := &s.slo
+	set.mu.Lock()
+	defer set.mu.Unlock()
+	keep := set.keep()
+	if set.mounts == nil {
+		set.mounts = map[string]*sloTracker{}
+	}
+	set.server.observe(now, server, keep)
+	for key, st := range states {
+		t := set.mounts[key]
+		if t == nil {
+			t = &sloTracker{state: sloIdle, since: now}
+			set.mounts[key] = t
+		}
+		t.observe(now, st, keep)
+	}
+	// Mounts that are gone go idle, and are forgotten once out of every window
+	for key, t := range set.mounts {
+		if _, ok := states[key]; ok {
+			continue
+		}
+		t.observe(now, sloIdle, keep)
+		if len(t.segs) == 0 {
+			delete(set.mounts, key)
+		}
+	}
+}
+
+// sloWindow is one window of GET /admin/slo. Availability is live/viewed,
+// null when nobody watched during the window.
+type sloWindow struct {
+	Availability  *float64 `json:"availability"`
+	ViewedSeconds float64  `json:"viewed_seconds"`
+	LiveSeconds   float64  `json:"live_seconds"`
+}
+
+type sloReport struct {
+	State   string               `json:"state"`
+	Since   time.Time            `json:"since"`
+	Windows map[string]sloWindow `json:"windows"`
+}
+
+func (t *sloTracker) report(now time.Time, windows []time.Duration) sloReport {
+	r := sloReport{State: t.state, Since: t.since.UTC(), Windows: map[string]sloWindow{}}
+	for _, d := range windows {
+		viewed, live := t.window(now, d)
+		w := sloWindow{ViewedSeconds: viewed.Seconds(), LiveSeconds: live.Seconds()}
+		if viewed > 0 {
+			a := live.Seconds() / viewed.Seconds()
+			w.Availability = &a
+		}
+		r.Windows[windowLabel(d)] = w
+	}
+	return r
+}
+
+// windowLabel renders d the way it is usually configured: "1h", "30m", "24h".
+func windowLabel(d time.Duration) string {
+	switch {
+	case d%time.Hour == 0:
+		return fmt.Sprintf("%dh", d/time.Hour)
+	case d%time.Minute == 0:
+		return fmt.Sprintf("%dm", d/time.Minute)
+	}
+	return d.String()
+}
+
+func sloWindowsSummary(windows []time.Duration) string {
+	labels := make([]string, 0, len(windows))
+	for _, d := range windows {
+		labels = append(labels, windowLabel(d))
+	}
+	return strings.Join(labels, ",")
+}
+
+// sloSnapshot reports the server and every tracked mount.
+func (s *WhepServer) sloSnapshot() (server sloReport, mounts map[string]sloReport, windows []time.Duration) {
+	now := time.Now()
+	set := &s.slo
+	set.mu.Lock()
+	defer set.mu.Unlock()
+	mounts = make(map[string]sloReport, len(set.mounts))
+	for key, t := range set.mounts {
+		mounts[key] = t.report(now, set.windows)
+	}
+	return set.server.report(now, set.windows), mounts, set.windows
+}
+
+// GET /admin/slo reports availability per mount and for the server over the
+// configured rolling windows.
+func (s *WhepServer) handleSLO(w http.ResponseWriter, r *http.Request) {
+	allowCORS(w, r)
+	switch r.Method {
+	case http.MethodOptions:
+		w.WriteHeader(http.StatusNoContent)
+		return
+	case http.MethodGet:
+	default:
+		writeError(w, r, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "method not allowed", nil)
+		return
+	}
+	server, mounts, windows := s.sloSnapshot()
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"windows":         strings.Split(sloWindowsSummary(windows), ","),
+		"stale_after_ms":  sloStaleAfter.Milliseconds(),
+		"sample_interval": sloInterval.String(),
+		"server":          server,
+		"mounts":          mounts,
+	})
+}
+
+// writeSLOMetrics appends the availability gauges to a /metrics body.
+// Windows nobody watched are omitted rather than reported as NaN.
+func (s *WhepServer) writeSLOMetrics(b *strings.Builder) {
+	server, mounts, windows := s.sloSnapshot()
+	keys := make([]string, 0, len(mounts))
+	for key := range mounts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	writeMetricHeader(b, "whep_server_availability", "gauge", "Share of viewed time all watched mounts were live, per rolling window.")
+	for _, d := range windows {
+		if a := server.Windows[windowLabel(d)].Availability; a != nil {
+			fmt.Fprintf(b, "whep_server_availability{window=%s} %g\n", promLabel(windowLabel(d)), *a)
+		}
+	}
+	writeMetricHeader(b, "whep_mount_availability", "gauge", "Share of viewed time a mount delivered live frames, per rolling window.")
+	for _, key := range keys {
+		for _, d := range windows {
+			if a := mounts[key].Windows[windowLabel(d)].Availability; a != nil {
+				fmt.Fprintf(b, "whep_mount_availability{mount=%s,window=%s} %g\n", promLabel(key), promLabel(windowLabel(d)), *a)
+			}
+		}
+	}
+	writeMetricHeader(b, "whep_mount_viewed_seconds", "gauge", "Seconds a mount had viewers, per rolling window.")
+	for _, key := range keys {
+		for _, d := range windows {
+			fmt.Fprintf(b, "whep_mount_viewed_seconds{mount=%s,window=%s} %g\n", promLabel(key), promLabel(windowLabel(d)), mounts[key].Windows[windowLabel(d)].ViewedSeconds)
+		}
+	}
+}
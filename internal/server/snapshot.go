@@ -0,0 +1,220 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"whep/internal/ndi"
+)
+
+// GET /admin/snapshot returns sources, mounts, sessions and health as one
+// document, read under a single hold of s.mu so it never shows a session
+// on a mount the same document doesn't list. Its revision moves with every
+// change to what it reports; ?wait=<revision> long-polls until the revision
+// is past the one given, or snapshotWait runs out.
+
+// snapshotWait bounds a ?wait long-poll; the unchanged snapshot comes back
+// when it runs out.
+const snapshotWait = 30 * time.Second
+
+// changeFeed numbers changes to the state /admin/snapshot reports: sessions
+// and their states, mounts, the shared pipeline, the selection, and the
+// discovered sources. Server state bumps it with s.mu held, so the revision
+// read with the state under s.mu matches it.
+type changeFeed struct {
+	mu      sync.Mutex
+	rev     uint64
+	next    chan struct{} // closed by the next bump
+	sources uint64        // ndi.SourcesChanged generation accounted for
+}
+
+func (c *changeFeed) bump() {
+	c.mu.Lock()
+	c.rev++
+	if c.next != nil {
+		close(c.next)
+		c.next = nil
+	}
+	c.mu.Unlock()
+}
+
+// current returns the revision, counted from 1, and a channel closed when
+// it moves on.
+func (c *changeFeed) current() (uint64, <-chan struct{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.next == nil {
+		c.next = make(chan struct{})
+	}
+	return c.rev + 1, c.next
+}
+
+// syncSources bumps once for a new source list generation, however many
+// waiters see it.
+func (c *changeFeed) syncSources(gen uint64) {
+	c.mu.Lock()
+	stale := gen != c.sources
+	c.sources = gen
+	c.mu.Unlock()
+	if stale {
+		c.bump()
+	}
+}
+
+type snapshotSource struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	URL  string `json:"url"`
+}
+
+type snapshotMount struct {
+	Key       string    `json:"key"`
+	Name      string    `json:"name"`
+	URL       string    `json:"url"`
+	Codec     string    `json:"codec"`
+	Width     int       `json:"width"`
+	Height    int       `json:"height"`
+	Aspect    string    `json:"aspect,omitempty"`
+	Filter    string    `json:"filter,omitempty"`
+	Keyint    int       `json:"keyint,omitempty"`
+	RC        string    `json:"rc,omitempty"`
+	CRF       int       `json:"crf,omitempty"`
+	VP9       string    `json:"vp9,omitempty"`
+	AV1       string    `json:"av1,omitempty"`
+	Alias     string    `json:"alias,omitempty"`
+	Detail    string    `json:"detail,omitempty"`
+	Crop      string    `json:"crop,omitempty"`
+	Rotate    int       `json:"rotate,omitempty"`
+	Flip      string    `json:"flip,omitempty"`
+	Bandwidth string    `json:"bandwidth,omitempty"`
+	Created   time.Time `json:"created"`
+	Sessions  int       `json:"sessions"` // the snapshot's sessions on it
+}
+
+// snapshotSession.Mount is a key from the snapshot's mounts, or "shared".
+// A session whose mount has been torn down, and which is being closed with
+// it, has none.
+type snapshotSession struct {
+	ID      string    `json:"id"`
+	Mount   string    `json:"mount,omitempty"`
+	Codec   string    `json:"codec"`
+	Created time.Time `json:"created"`
+	State   string    `json:"state"`
+}
+
+type snapshot struct {
+	Revision uint64            `json:"revision"`
+	Time     time.Time         `json:"time"`
+	Selected map[string]string `json:"selected"`
+	Sources  []snapshotSource  `json:"sources"`
+	Shared   map[string]any    `json:"shared_pipeline"`
+	Mounts   []snapshotMount   `json:"mounts"`
+	Sessions []snapshotSession `json:"sessions"`
+	Health   map[string]any    `json:"health"`
+}
+
+func (s *WhepServer) handleSnapshot(w http.ResponseWriter, r *http.Request) {
+	allowCORS(w, r)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Method != http.MethodGet {
+		writeError(w, r, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "method not allowed", nil)
+		return
+	}
+	if v := r.URL.Query().Get("wait"); v != "" {
+		rev, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, errCodeBadRequest, "wait must be a revision number", map[string]string{"wait": v})
+			return
+		}
+		s.waitForChange(r, rev)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	_ = json.NewEncoder(w).Encode(s.snapshot())
+}
+
+// waitForChange returns once the revision is past rev, the request is gone,
+// the server closes or snapshotWait runs out.
+func (s *WhepServer) waitForChange(r *http.Request, rev uint64) {
+	timeout := time.NewTimer(snapshotWait)
+	defer timeout.Stop()
+	for {
+		gen, srcs := ndi.SourcesChanged()
+		s.changes.syncSources(gen)
+		cur, next := s.changes.current()
+		if cur > rev {
+			return
+		}
+		select {
+		case <-next:
+		case <-srcs:
+		case <-timeout.C:
+			return
+		case <-r.Context().Done():
+			return
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// snapshot assembles the document. Sources are listed first, outside s.mu
+// (channels take their own lock); everything else is read in one go.
+func (s *WhepServer) snapshot() snapshot {
+	gen, _ := ndi.SourcesChanged()
+	s.changes.syncSources(gen)
+	idx := s.sourceIndex()
+	out := snapshot{Time: time.Now().UTC(), Sources: make([]snapshotSource, 0, len(idx)), Mounts: []snapshotMount{}, Sessions: []snapshotSession{}}
+	for id, si := range idx {
+		out.Sources = append(out.Sources, snapshotSource{ID: id, Name: si.Name, URL: si.URL})
+	}
+	sort.Slice(out.Sources, func(i, j int) bool { return out.Sources[i].ID < out.Sources[j].ID })
+
+	s.mu.Lock()
+	out.Revision, _ = s.changes.current()
+	out.Selected = map[string]string{"name": s.ndiName, "url": s.ndiURL}
+	out.Shared = s.sharedHealthLocked()
+	// Mount session counts come from the sessions listed here rather than
+	// the mounts' refcounts, which a closing session updates later
+	perMount := map[string]int{}
+	for id, ss := range s.sessions {
+		mount := sharedMetricsLabel
+		if ss.mountKey != "" {
+			mount = ""
+			if s.mounts[ss.mountKey] != nil {
+				mount = ss.mountKey
+				perMount[mount]++
+			}
+		}
+		out.Sessions = append(out.Sessions, snapshotSession{ID: id, Mount: mount, Codec: ss.codec, Created: ss.created.UTC(), State: ss.state})
+	}
+	for key, m := range s.mounts {
+		m.mu.Lock()
+		var vp9, av1 string
+		switch m.codec {
+		case "vp9":
+			vp9 = m.vp9.String()
+		case "av1":
+			av1 = m.av1.String()
+		}
+		out.Mounts = append(out.Mounts, snapshotMount{Key: key, Name: m.name, URL: m.url, Codec: m.codec, Width: m.width, Height: m.height, Aspect: m.aspect, Filter: m.filter, Keyint: m.keyint, RC: m.rc, CRF: m.crf, VP9: vp9, AV1: av1, Alias: m.alias, Detail: regionValue(m.detail), Crop: regionValue(m.crop), Rotate: m.orient.Rotate, Flip: m.orient.Flip, Bandwidth: m.bandwidth, Created: m.created.UTC(), Sessions: perMount[key]})
+		m.mu.Unlock()
+	}
+	s.mu.Unlock()
+
+	sort.Slice(out.Mounts, func(i, j int) bool { return out.Mounts[i].Key < out.Mounts[j].Key })
+	sort.Slice(out.Sessions, func(i, j int) bool { return out.Sessions[i].Created.Before(out.Sessions[j].Created) })
+	status := "ok"
+	if s.draining.Load() {
+		status = "draining"
+	}
+	out.Health = map[string]any{"status": status, "ndi_state": s.ndiState(), "sessions": len(out.Sessions), "mounts": len(out.Mounts)}
+	return out
+}
@@ -0,0 +1,212 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func getSnapshot(t *testing.T, ts *httptest.Server, query string) snapshot {
+	t.Helper()
+	resp, err := http.Get(ts.URL + "/admin/snapshot" + query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET /admin/snapshot%s: %d", query, resp.StatusCode)
+	}
+	var snap snapshot
+	if err := json.NewDecoder(resp.Body).Decode(&snap); err != nil {
+		t.Fatal(err)
+	}
+	return snap
+}
+
+// checkSnapshot fails if a session names a mount the snapshot doesn't
+// list, or a mount's session count disagrees with its listed sessions.
+func checkSnapshot(snap snapshot) error {
+	mounts := map[string]int{}
+	for _, m := range snap.Mounts {
+		mounts[m.Key] = 0
+	}
+	for _, ss := range snap.Sessions {
+		if ss.Mount == "" || ss.Mount == sharedMetricsLabel {
+			continue
+		}
+		if _, ok := mounts[ss.Mount]; !ok {
+			return fmt.Errorf("revision %d: session %s is on mount %s, which isn't listed", snap.Revision, ss.ID, ss.Mount)
+		}
+		mounts[ss.Mount]++
+	}
+	for _, m := range snap.Mounts {
+		if m.Sessions != mounts[m.Key] {
+			return fmt.Errorf("revision %d: mount %s counts %d sessions, %d listed", snap.Revision, m.Key, m.Sessions, mounts[m.Key])
+		}
+	}
+	return nil
+}
+
+func TestSnapshotHasNoDanglingMounts(t *testing.T) {
+	fakePipelines(t)
+	s, ts := newTestServer(t)
+	stop := make(chan struct{})
+	var polls int
+	var pollErr error
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			resp, err := http.Get(ts.URL + "/admin/snapshot")
+			if err != nil {
+				pollErr = err
+				return
+			}
+			var snap snapshot
+			err = json.NewDecoder(resp.Body).Decode(&snap)
+			resp.Body.Close()
+			if err == nil {
+				err = checkSnapshot(snap)
+			}
+			if err != nil {
+				pollErr = err
+				return
+			}
+			polls++
+		}
+	}()
+
+	sizes := []string{"w=64&h=36", "w=32&h=18", "w=16&h=10"}
+	for round := 0; round < 4; round++ {
+		var viewers []*viewer
+		var mu sync.Mutex
+		var posts sync.WaitGroup
+		for i := 0; i < 6; i++ {
+			posts.Add(1)
+			go func(q string) {
+				defer posts.Done()
+				v := postOffer(t, ts, "/whep/ndi/"+fakeKey+"?"+q)
+				mu.Lock()
+				viewers = append(viewers, v)
+				mu.Unlock()
+			}(sizes[i%len(sizes)])
+		}
+		posts.Wait()
+		for _, v := range viewers {
+			if v.code != http.StatusCreated {
+				t.Fatalf("round %d: POST %d %s", round, v.code, v.body)
+			}
+		}
+		if round == 0 {
+			// Hold the state closeMounts passes through: mounts gone from
+			// the table, their sessions not yet ended
+			s.mu.Lock()
+			var gone []*ndiMount
+			for key, m := range s.mounts {
+				gone = append(gone, m)
+				delete(s.mounts, key)
+			}
+			s.mu.Unlock()
+			if err := checkSnapshot(getSnapshot(t, ts, "")); err != nil {
+				t.Fatal(err)
+			}
+			for _, id := range sessionIDs(s) {
+				s.closeSession(id)
+			}
+			for _, m := range gone {
+				m.teardown()
+			}
+			continue
+		}
+		if round%2 == 0 {
+			// Mounts go while their sessions are still being closed
+			s.closeMounts(fakeKey, "test")
+			continue
+		}
+		// Sessions go first, then their idle mounts
+		var closes sync.WaitGroup
+		for _, id := range sessionIDs(s) {
+			closes.Add(1)
+			go func(id string) {
+				defer closes.Done()
+				s.closeSession(id)
+			}(id)
+		}
+		closes.Wait()
+		s.mu.Lock()
+		var keys []string
+		for key := range s.mounts {
+			keys = append(keys, key)
+		}
+		s.mu.Unlock()
+		for _, key := range keys {
+			s.teardownMountIfIdle(key)
+		}
+	}
+	close(stop)
+	wg.Wait()
+	if pollErr != nil {
+		t.Fatal(pollErr)
+	}
+	if polls == 0 {
+		t.Fatal("no snapshot was polled")
+	}
+	if err := checkSnapshot(getSnapshot(t, ts, "")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSnapshotWait(t *testing.T) {
+	fakePipelines(t)
+	_, ts := newTestServer(t)
+	rev := getSnapshot(t, ts, "").Revision
+
+	// An older revision answers straight away
+	start := time.Now()
+	if got := getSnapshot(t, ts, fmt.Sprintf("?wait=%d", rev-1)).Revision; got != rev {
+		t.Fatalf("?wait=%d: revision %d, want %d", rev-1, got, rev)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("?wait for an older revision took %v", d)
+	}
+
+	// The current one waits for the next change
+	got := make(chan snapshot, 1)
+	go func() { got <- getSnapshot(t, ts, fmt.Sprintf("?wait=%d", rev)) }()
+	select {
+	case snap := <-got:
+		t.Fatalf("?wait=%d answered revision %d with nothing changed", rev, snap.Revision)
+	case <-time.After(200 * time.Millisecond):
+	}
+	v := postOffer(t, ts, "/whep/ndi/"+fakeKey)
+	if v.code != http.StatusCreated {
+		t.Fatalf("POST: %d %s", v.code, v.body)
+	}
+	select {
+	case snap := <-got:
+		if snap.Revision <= rev || len(snap.Sessions) == 0 && len(snap.Mounts) == 0 {
+			t.Fatalf("?wait=%d answered revision %d with %d sessions, %d mounts", rev, snap.Revision, len(snap.Sessions), len(snap.Mounts))
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("?wait didn't return after a change")
+	}
+
+	resp, err := http.Get(ts.URL + "/admin/snapshot?wait=soon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("?wait=soon: %d, want 400", resp.StatusCode)
+	}
+}
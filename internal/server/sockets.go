@@ -0,0 +1,194 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"os"
+	"runtime"
+	"sync"
+	"sync/atomic"
+
+	"github.com/pion/transport/v2"
+	"github.com/pion/transport/v2/stdnet"
+
+	"whep/internal/ndi"
+)
+
+// ndiSocketsPerReceiver is a rough count of TCP connections an NDI receiver
+// holds open (video + control); the SDK doesn't expose the real number.
+const ndiSocketsPerReceiver = 2
+
+// errSocketBudget is returned (as 503) when creating a session would exceed
+// Config.MaxSockets.
+var errSocketBudget = errors.New("socket budget exceeded")
+
+// socketCounter counts the UDP/TCP sockets Pion opens for PeerConnections.
+type socketCounter struct {
+	udp atomic.Int64
+	tcp atomic.Int64
+}
+
+// countingNet wraps the standard transport.Net handed to Pion via
+// SettingEngine.SetNet so every socket it opens and closes is counted.
+type countingNet struct {
+	transport.Net
+	c *socketCounter
+}
+
+func newCountingNet(c *socketCounter) (transport.Net, error) {
+	n, err := stdnet.NewNet()
+	if err != nil {
+		return nil, err
+	}
+	return &countingNet{Net: n, c: c}, nil
+}
+
+// countSocket bumps ctr and returns a release func safe to call more than once.
+func countSocket(ctr *atomic.Int64) func() {
+	ctr.Add(1)
+	var once sync.Once
+	return func() { once.Do(func() { ctr.Add(-1) }) }
+}
+
+func (n *countingNet) ListenPacket(network, address string) (net.PacketConn, error) {
+	pc, err := n.Net.ListenPacket(network, address)
+	if err != nil {
+		return nil, err
+	}
+	return &countedPacketConn{PacketConn: pc, release: countSocket(&n.c.udp)}, nil
+}
+
+func (n *countingNet) ListenUDP(network string, laddr *net.UDPAddr) (transport.UDPConn, error) {
+	c, err := n.Net.ListenUDP(network, laddr)
+	if err != nil {
+		return nil, err
+	}
+	if laddr != nil && laddr.IP.IsMulticast() {
+		// mDNS reads through x/net/ipv4, which only takes the *net.UDPConn
+		// itself: behind a wrapper every read fails and its read loop spins
+		// and never lets the PeerConnection close. Left uncounted.
+		return c, nil
+	}
+	return &countedUDPConn{UDPConn: c, release: countSocket(&n.c.udp)}, nil
+}
+
+func (n *countingNet) DialUDP(network string, laddr, raddr *net.UDPAddr) (transport.UDPConn, error) {
+	c, err := n.Net.DialUDP(network, laddr, raddr)
+	if err != nil {
+		return nil, err
+	}
+	return &countedUDPConn{UDPConn: c, release: countSocket(&n.c.udp)}, nil
+}
+
+func (n *countingNet) ListenTCP(network string, laddr *net.TCPAddr) (transport.TCPListener, error) {
+	l, err := n.Net.ListenTCP(network, laddr)
+	if err != nil {
+		return nil, err
+	}
+	return &countedTCPListener{TCPListener: l, release: countSocket(&n.c.tcp)}, nil
+}
+
+func (n *countingNet) Dial(network, address string) (net.Conn, error) {
+	c, err := n.Net.Dial(network, address)
+	if err != nil {
+		return nil, err
+	}
+	ctr := &n.c.tcp
+	if _, ok := c.(*net.UDPConn); ok {
+		ctr = &n.c.udp
+	}
+	return &countedConn{Conn: c, release: countSocket(ctr)}, nil
+}
+
+func (n *countingNet) DialTCP(network string, laddr, raddr *net.TCPAddr) (transport.TCPConn, error) {
+	c, err := n.Net.DialTCP(network, laddr, raddr)
+	if err != nil {
+		return nil, err
+	}
+	return &countedTCPConn{TCPConn: c, release: countSocket(&n.c.tcp)}, nil
+}
+
+type countedPacketConn struct {
+	net.PacketConn
+	release func()
+}
+
+func (c *countedPacketConn) Close() error { c.release(); return c.PacketConn.Close() }
+
+type countedUDPConn struct {
+	transport.UDPConn
+	release func()
+}
+
+func (c *countedUDPConn) Close() error { c.release(); return c.UDPConn.Close() }
+
+type countedTCPListener struct {
+	transport.TCPListener
+	release func()
+}
+
+func (c *countedTCPListener) Close() error { c.release(); return c.TCPListener.Close() }
+
+type countedConn struct {
+	net.Conn
+	release func()
+}
+
+func (c *countedConn) Close() error { c.release(); return c.Conn.Close() }
+
+type countedTCPConn struct {
+	transport.TCPConn
+	release func()
+}
+
+func (c *countedTCPConn) Close() error { c.release(); return c.TCPConn.Close() }
+
+// socketStats is the /health view of socket usage.
+func (s *WhepServer) socketStats() map[string]any {
+	udp, tcp := s.sockets.udp.Load(), s.sockets.tcp.Load()
+	rx := ndi.ActiveReceivers()
+	out := map[string]any{
+		"udp":           udp,
+		"tcp":           tcp,
+		"ndi_receivers": rx,
+		"total":         s.openSockets(),
+		"limit":         s.cfg.MaxSockets,
+	}
+	if n := openFDs(); n >= 0 {
+		out["fds"] = n
+	}
+	return out
+}
+
+// openSockets estimates sockets held by the process: Pion's counted sockets
+// plus the NDI receivers' TCP connections.
+func (s *WhepServer) openSockets() int64 {
+	return s.sockets.udp.Load() + s.sockets.tcp.Load() + ndi.ActiveReceivers()*ndiSocketsPerReceiver
+}
+
+// checkSocketBudget fails once the soft cap is reached so new sessions are
+// refused with a clear error before the OS starts failing binds.
+func (s *WhepServer) checkSocketBudget() error {
+	if s.cfg.MaxSockets > 0 && s.openSockets() >= int64(s.cfg.MaxSockets) {
+		return errSocketBudget
+	}
+	return nil
+}
+
+// socketBudgetDetails is the error envelope's details for a refused session.
+func (s *WhepServer) socketBudgetDetails() map[string]int64 {
+	return map[string]int64{"open": s.openSockets(), "limit": int64(s.cfg.MaxSockets)}
+}
+
+// openFDs returns the number of open file descriptors, or -1 where the
+// platform doesn't expose /proc/self/fd.
+func openFDs() int {
+	if runtime.GOOS != "linux" {
+		return -1
+	}
+	ents, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		return -1
+	}
+	return len(ents)
+}
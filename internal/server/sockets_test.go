@@ -0,0 +1,43 @@
+package server
+
+import (
+	"net"
+	"testing"
+)
+
+func TestCountingNetCountsUDP(t *testing.T) {
+	var c socketCounter
+	n, err := newCountingNet(&c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := n.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := c.udp.Load(); got != 1 {
+		t.Fatalf("%d UDP sockets counted, want 1", got)
+	}
+	_ = conn.Close()
+	_ = conn.Close()
+	if got := c.udp.Load(); got != 0 {
+		t.Fatalf("%d UDP sockets counted after close, want 0", got)
+	}
+}
+
+func TestCountingNetLeavesMulticastUnwrapped(t *testing.T) {
+	var c socketCounter
+	n, err := newCountingNet(&c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The group ICE listens on for mDNS
+	conn, err := n.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353})
+	if err != nil {
+		t.Skipf("no multicast here: %v", err)
+	}
+	defer conn.Close()
+	if _, ok := conn.(*net.UDPConn); !ok {
+		t.Fatalf("mDNS socket is a %T; x/net/ipv4 can't read through it", conn)
+	}
+}
@@ -0,0 +1,220 @@
+package server
+
+import (
+	"context"
+	"log"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"whep/internal/ndi"
+	"whep/internal/stream"
+)
+
+// Source metadata. /ndi/sources lists each source's size and frame rate
+// as last received: from the mounts, the shared pipeline and the crop
+// receivers on it, and, with -ndi-source-probe, from a short probe of each
+// discovered NDI source nothing receives. Probing is off by default, as it
+// connects to every sender on the network in turn.
+const (
+	// sourceProbeInterval spaces probe passes; a pass probes one source at
+	// a time, in the ndi scheduler's temporary receiver turns
+	sourceProbeInterval = 30 * time.Second
+	sourceProbeTimeout  = 3 * time.Second
+	// sourceMetaTTL is how long a probe's answer stands before the source
+	// is probed again
+	sourceMetaTTL = 10 * time.Minute
+)
+
+// sourceMeta is what is known of a source's video.
+type sourceMeta struct {
+	Width, Height int
+	FPS           stream.Rate
+	At            time.Time // when it was learned
+}
+
+// sourceMetas holds sourceMeta by sourceID.
+type sourceMetas struct {
+	mu   sync.Mutex
+	byID map[string]sourceMeta
+}
+
+func (sm *sourceMetas) note(id string, m sourceMeta) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	if sm.byID == nil {
+		sm.byID = map[string]sourceMeta{}
+	}
+	// A receiver that hasn't announced its rate yet keeps the last known one
+	if old, ok := sm.byID[id]; ok && !m.FPS.Valid() && old.Width == m.Width && old.Height == m.Height {
+		m.FPS = old.FPS
+	}
+	sm.byID[id] = m
+}
+
+func (sm *sourceMetas) get(id string) (sourceMeta, bool) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	m, ok := sm.byID[id]
+	return m, ok
+}
+
+// learnSourceMetas notes the size and rate of every source a mount, the
+// shared pipeline or a crop receiver has frames from. NDI receivers report
+// their sender's size before scaling; other sources count only from mounts
+// that encode them at their own size.
+func (s *WhepServer) learnSourceMetas(now time.Time) {
+	type seen struct {
+		id  string
+		src stream.Source
+	}
+	var srcs []seen
+	s.mu.Lock()
+	for _, m := range s.mounts {
+		m.mu.Lock()
+		if m.src != nil {
+			native := m.width == 0 && m.height == 0 && m.crop.Empty() && m.orient.IsZero()
+			if _, ok := stream.NDISender(m.src); ok || native {
+				srcs = append(srcs, seen{sourceID(m.name, m.url), m.src})
+			}
+		}
+		m.mu.Unlock()
+	}
+	if s.shareSrc != nil {
+		srcs = append(srcs, seen{"", s.shareSrc})
+	}
+	s.mu.Unlock()
+	s.crops.mu.Lock()
+	for id, sh := range s.crops.m {
+		srcs = append(srcs, seen{id, sh})
+	}
+	s.crops.mu.Unlock()
+	for _, it := range srcs {
+		if f, ok := stream.NDISender(it.src); ok {
+			s.sourceMetas.note(f.URL, sourceMeta{Width: f.W, Height: f.H, FPS: f.FPS, At: now})
+			continue
+		}
+		l, ok := it.src.(interface {
+			Last() ([]byte, int, int, bool)
+		})
+		if it.id == "" || !ok || stream.IsSynthetic(it.src) {
+			continue
+		}
+		if _, w, h, ok := l.Last(); ok && w > 0 && h > 0 {
+			var fps stream.Rate
+			if r, ok := it.src.(interface{ FrameRate() (int, int) }); ok {
+				fps.Num, fps.Den = r.FrameRate()
+			}
+			s.sourceMetas.note(it.id, sourceMeta{Width: w, Height: h, FPS: fps, At: now})
+		}
+	}
+}
+
+// runSourceProbe probes, every sourceProbeInterval, the discovered NDI
+// sources nothing has told the size of within sourceMetaTTL.
+func (s *WhepServer) runSourceProbe() {
+	select {
+	case <-s.ndiInit:
+	case <-s.done:
+		return
+	}
+	if !ndi.SDK().OK() {
+		return
+	}
+	ticker := time.NewTicker(sourceProbeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case now := <-ticker.C:
+			s.learnSourceMetas(now)
+			for _, si := range ndi.GetCachedSources() {
+				if m, ok := s.sourceMetas.get(si.URL); ok && time.Since(m.At) < sourceMetaTTL {
+					continue
+				}
+				if !s.probeSourceMeta(si.Name, si.URL) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// probeSourceMeta probes name/url at full bandwidth for one frame, in its
+// turn with other temporary receivers, and notes what it finds. It reports
+// false once the server is closing.
+func (s *WhepServer) probeSourceMeta(name, url string) bool {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-s.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	release, err := ndi.AcquireTemp(ctx, sourceID(name, url), false)
+	if err != nil {
+		return false
+	}
+	defer release()
+	res := probeResult{Name: name, URL: url, Bandwidth: ndi.BandwidthHighest}
+	if err := probeReceive(&res, sourceProbeTimeout); err != nil {
+		log.Printf("Source probe %q: %v", name, err)
+	}
+	// A source without a frame is tried again next pass
+	if res.Width > 0 {
+		s.sourceMetas.note(sourceID(name, url), sourceMeta{Width: res.Width, Height: res.Height, FPS: res.FPS, At: time.Now()})
+	}
+	return true
+}
+
+// runningVariant is a running mount of a source, for /ndi/sources.
+type runningVariant struct {
+	MountKey string `json:"mountKey"`
+	// WHEP joins this mount: the source's endpoint with the parameters
+	// that created it
+	WHEP  string `json:"whepEndpoint"`
+	Codec string `json:"codec"`
+	// Width and Height are the requested size; absent for the source's own
+	Width       int          `json:"width,omitempty"`
+	Height      int          `json:"height,omitempty"`
+	FPS         *stream.Rate `json:"fps,omitempty"`
+	BitrateKbps int          `json:"bitrateKbps,omitempty"`
+	Sessions    int          `json:"sessions"`
+}
+
+// runningVariants lists the mounts that are up, by the source key they
+// were requested under.
+func (s *WhepServer) runningVariants() map[string][]runningVariant {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := map[string][]runningVariant{}
+	for key, m := range s.mounts {
+		select {
+		case <-m.ready:
+		default:
+			continue
+		}
+		m.mu.Lock()
+		if m.startErr == nil {
+			base, _, _ := strings.Cut(key, "|")
+			q, _ := url.ParseQuery(m.query)
+			q.Set("codec", m.codec)
+			rv := runningVariant{MountKey: key, WHEP: "/whep/ndi/" + base + "?" + q.Encode(), Codec: m.codec, Width: m.width, Height: m.height, BitrateKbps: m.bitrateKbps, Sessions: len(m.sessions)}
+			if m.encFPS.Valid() {
+				fps := m.encFPS
+				rv.FPS = &fps
+			}
+			out[base] = append(out[base], rv)
+		}
+		m.mu.Unlock()
+	}
+	for _, vs := range out {
+		sort.Slice(vs, func(i, j int) bool { return vs[i].MountKey < vs[j].MountKey })
+	}
+	return out
+}
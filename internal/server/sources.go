@@ -0,0 +1,220 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+
+	"whep/internal/ndi"
+	"whep/internal/stream"
+)
+
+// sourceFactory opens a stream.Source for a selected name/URL. Factories are
+// keyed by URL scheme; aliases let bare names (e.g. "Splash" from the UI)
+// and legacy URLs resolve to the same factory.
+type sourceFactory struct {
+	scheme  string
+	aliases []string
+	// listed is advertised in /ndi/sources alongside discovered NDI sources
+	listed *struct{ Name, URL string }
+	// keys are mount keys the listed source went by before, still accepted
+	keys []string
+	// open returns a source scaled to w x h (0 = native size)
+	open func(s *WhepServer, name, url string, w, h int, fps stream.Rate) (stream.Source, error)
+	// still renders a single BGRA frame without opening a source (optional)
+	still func(s *WhepServer, w, h int) ([]byte, int, int, error)
+}
+
+var sourceFactories []*sourceFactory
+
+// errSourceNotFound is returned when a mount key matches no known source.
+var errSourceNotFound = errors.New("source not found")
+
+// registerSourceFactory adds f to the registry; later registrations win.
+func registerSourceFactory(f *sourceFactory) {
+	sourceFactories = append([]*sourceFactory{f}, sourceFactories...)
+}
+
+// ndiFactory is the default for anything no other factory claims.
+var ndiFactory = &sourceFactory{
+	scheme: "ndi",
+	open: func(s *WhepServer, name, url string, w, h int, fps stream.Rate) (stream.Source, error) {
+		return openNDISource(name, url, w, h, "")
+	},
+}
+
+// openNDISource opens an NDI receiver at bandwidth ("" for
+// NDI_RECV_BANDWIDTH), scaled to w x h when both are set.
+func openNDISource(name, url string, w, h int, bandwidth string) (stream.Source, error) {
+	nd, err := stream.NewNDISource(url, name, bandwidth)
+	if err != nil {
+		return nil, err
+	}
+	if w > 0 && h > 0 {
+		nd.SetOutputSize(w, h)
+	}
+	return nd, nil
+}
+
+func init() {
+	registerSourceFactory(&sourceFactory{
+		scheme:  "splash",
+		aliases: []string{"splash", "ndi://splash"},
+		listed:  &struct{ Name, URL string }{Name: "Splash", URL: "splash://"},
+		// As ndi://Splash
+		keys: []string{"ndi-splash"},
+		open: func(s *WhepServer, name, url string, w, h int, fps stream.Rate) (stream.Source, error) {
+			w, h = s.syntheticSize(w, h)
+			return stream.NewSynthetic(w, h, fps.Or(stream.IntRate(30)).Round(), 1), nil
+		},
+		still: func(s *WhepServer, w, h int) ([]byte, int, int, error) {
+			return s.splashStill(w, h)
+		},
+	})
+}
+
+// sourceKeyAlias maps a listed source's former mount key to its current
+// one; any other key is returned as is.
+func sourceKeyAlias(key string) string {
+	k := strings.ToLower(strings.TrimSpace(key))
+	for _, f := range sourceFactories {
+		if f.listed != nil && slices.Contains(f.keys, k) {
+			return slugKey(f.listed.Name, f.listed.URL)
+		}
+	}
+	return key
+}
+
+// lookupSourceFactory picks the factory for a selection. Matching ignores
+// case and surrounding whitespace so "Splash " from a UI still resolves.
+func lookupSourceFactory(name, url string) *sourceFactory {
+	name, url = strings.ToLower(strings.TrimSpace(name)), strings.ToLower(strings.TrimSpace(url))
+	if i := strings.Index(url, "://"); i > 0 {
+		scheme := url[:i]
+		for _, f := range sourceFactories {
+			if f.scheme == scheme {
+				return f
+			}
+		}
+	}
+	for _, f := range sourceFactories {
+		for _, a := range f.aliases {
+			if (url != "" && url == a) || (url == "" && name == a) {
+				return f
+			}
+		}
+	}
+	return ndiFactory
+}
+
+// openSource resolves a selection through the registry.
+func (s *WhepServer) openSource(name, url string, w, h int, fps stream.Rate) (stream.Source, error) {
+	return lookupSourceFactory(name, url).open(s, name, url, w, h, fps)
+}
+
+// openSourceBandwidth is openSource with NDI sources received at bandwidth
+// (ndi.BandwidthHighest or BandwidthLowest; "" for NDI_RECV_BANDWIDTH).
+// Other sources have no bandwidth to pick.
+func (s *WhepServer) openSourceBandwidth(name, url string, w, h int, fps stream.Rate, bandwidth string) (stream.Source, error) {
+	if f := lookupSourceFactory(name, url); f != ndiFactory {
+		return f.open(s, name, url, w, h, fps)
+	}
+	return openNDISource(name, url, w, h, bandwidth)
+}
+
+// openTempSource opens a short-lived native-size receiver, for a /frame
+// grab. NDI sources wait their turn in the ndi scheduler; stop closes the
+// source and hands the turn back.
+func (s *WhepServer) openTempSource(ctx context.Context, name, url string) (src stream.Source, stop func(), err error) {
+	if lookupSourceFactory(name, url) != ndiFactory {
+		if src, err = s.openSource(name, url, 0, 0, stream.Rate{}); err != nil {
+			return nil, nil, err
+		}
+		return src, src.Stop, nil
+	}
+	release, err := ndi.AcquireTemp(ctx, sourceID(name, url), s.sourceWanted(name, url))
+	if err != nil {
+		return nil, nil, err
+	}
+	if src, err = s.openSource(name, url, 0, 0, stream.Rate{}); err != nil {
+		release()
+		return nil, nil, err
+	}
+	return src, func() { src.Stop(); release() }, nil
+}
+
+// sourceID names a source for the ndi scheduler: its URL, else its name.
+func sourceID(name, url string) string {
+	if url != "" {
+		return url
+	}
+	return name
+}
+
+// sourceWanted reports whether a mount or the shared pipeline is on
+// name/url, so its temporary receivers go first.
+func (s *WhepServer) sourceWanted(name, url string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.shareBC != nil && s.ndiName == name && s.ndiURL == url {
+		return true
+	}
+	for _, m := range s.mounts {
+		if m.name == name && m.url == url {
+			return true
+		}
+	}
+	return false
+}
+
+// stopOnError stops *src if the function it is deferred in returns with *err
+// set, so a receiver opened early in a start path doesn't outlive a later
+// failure. Defer it right after opening, with a named error result; src may
+// be rewrapped (switch, aspect) before the return.
+func stopOnError(src *stream.Source, err *error) {
+	if *err != nil && *src != nil {
+		(*src).Stop()
+	}
+}
+
+// listedSources returns the registry's built-in entries for source listings.
+func listedSources() []struct{ Name, URL string } {
+	var out []struct{ Name, URL string }
+	for _, f := range sourceFactories {
+		if f.listed != nil {
+			out = append(out, *f.listed)
+		}
+	}
+	return out
+}
+
+// syntheticSize fills unset dimensions from the configured size (or 720p).
+func (s *WhepServer) syntheticSize(w, h int) (int, int) {
+	if w <= 0 {
+		w = s.cfg.Width
+	}
+	if h <= 0 {
+		h = s.cfg.Height
+	}
+	if w <= 0 || h <= 0 {
+		w, h = 1280, 720
+	}
+	return w, h
+}
+
+// splashStill renders the next frame of a cached synthetic so /frame doesn't
+// build a new one per request. The returned buffer is a copy.
+func (s *WhepServer) splashStill(w, h int) ([]byte, int, int, error) {
+	w, h = s.syntheticSize(w, h)
+	s.splashMu.Lock()
+	defer s.splashMu.Unlock()
+	if s.splash == nil || s.splashW != w || s.splashH != h {
+		s.splash, s.splashW, s.splashH = stream.NewSynthetic(w, h, 30, 1), w, h
+	}
+	buf, ok := s.splash.Next()
+	if !ok || len(buf) < w*h*4 {
+		return nil, 0, 0, errors.New("splash frame unavailable")
+	}
+	return append([]byte(nil), buf[:w*h*4]...), w, h, nil
+}
@@ -0,0 +1,66 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestSplashFactoryLookup(t *testing.T) {
+	splash := lookupSourceFactory("", "splash://")
+	if splash == ndiFactory {
+		t.Fatal("splash:// resolves to NDI")
+	}
+	for _, sel := range []struct{ name, url string }{
+		{"Splash", ""},
+		{" splash ", ""},
+		{"", "ndi://Splash"},
+		{"", "SPLASH://"},
+	} {
+		if f := lookupSourceFactory(sel.name, sel.url); f != splash {
+			t.Errorf("name %q url %q: got the %s factory", sel.name, sel.url, f.scheme)
+		}
+	}
+	if f := lookupSourceFactory("CAM 1", "ndi://CAM 1"); f != ndiFactory {
+		t.Errorf("an NDI source got the %s factory", f.scheme)
+	}
+}
+
+func TestSourceKeyAlias(t *testing.T) {
+	for key, want := range map[string]string{
+		"ndi-splash": "splash",
+		"NDI-Splash": "splash",
+		"splash":     "splash",
+		"cam-1":      "cam-1",
+	} {
+		if got := sourceKeyAlias(key); got != want {
+			t.Errorf("sourceKeyAlias(%q) = %q, want %q", key, got, want)
+		}
+	}
+}
+
+func TestLegacySplashKeySharesMount(t *testing.T) {
+	fakePipelines(t)
+	s, ts := newTestServer(t)
+	if got := s.canonicalSourceKey("ndi-splash"); got != "splash" {
+		t.Fatalf("canonicalSourceKey(ndi-splash) = %q", got)
+	}
+	for _, key := range []string{"ndi-splash", "splash"} {
+		if v := postOffer(t, ts, "/whep/ndi/"+key); v.code != http.StatusCreated {
+			t.Fatalf("POST %s: %d %s", key, v.code, v.body)
+		}
+	}
+	s.mu.Lock()
+	n := len(s.mounts)
+	s.mu.Unlock()
+	if n != 1 {
+		t.Fatalf("%d mounts for one source, want 1", n)
+	}
+	resp, err := http.Get(ts.URL + "/frame/ndi-splash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET /frame/ndi-splash: %d", resp.StatusCode)
+	}
+}
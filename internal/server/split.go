@@ -0,0 +1,94 @@
+package server
+
+import (
+	"fmt"
+	"net/url"
+
+	"whep/internal/stream"
+)
+
+// Split encode. A mount requested with detail=x,y,w,h runs two VP8/VP9
+// encoders off one receiver: the main one encodes the whole frame at the
+// mount's size (w/h, downscaled from the source), the detail one a crop of
+// the native frame at its own size, e.g. a scoreboard at full resolution
+// beside a 540p picture. Both are variants of the one mount: viewers POST
+// with view=detail for the crop, without it for the main picture. The
+// source is opened once at its native size and fanned out to the two, so
+// the crop never passes through the downscale.
+
+// viewDetail is the view= value that plays a split mount's detail crop.
+const viewDetail = "detail"
+
+// splitCodec reports whether codec can run a split mount.
+func splitCodec(codec string) bool { return codec == "vp8" || codec == "vp9" }
+
+// parseView reads view= from a mount request: "" or "main" for the main
+// picture, "detail" for the crop of a mount with a detail region.
+func parseView(q url.Values, v mountVariant) (detail bool, err error) {
+	switch q.Get("view") {
+	case "", "main":
+		return false, nil
+	case viewDetail:
+		if v.Detail.Empty() {
+			return false, fmt.Errorf("view=detail needs a detail region")
+		}
+		return true, nil
+	}
+	return false, fmt.Errorf("view must be main or detail")
+}
+
+// regionValue renders a detail or crop region for listings; "" without
+// one.
+func regionValue(r stream.Region) string {
+	if r.Empty() {
+		return ""
+	}
+	return r.String()
+}
+
+// pipeFor is the pipeline a session plays: the detail one for a detail
+// viewer. Caller holds m.mu.
+func (m *ndiMount) pipeFor(detail bool) videoPipeline {
+	if detail {
+		return m.detailPipe
+	}
+	return m.pipe
+}
+
+// openSize is the size m's source is opened at: the requested size, which
+// the source scales to, or native (0x0) when an aspect policy, a detail
+// crop, a region of interest, a turn or a chosen scale filter needs the
+// full frame. Caller holds m.mu.
+func (m *ndiMount) openSize() (w, h int) {
+	if m.aspect != stream.AspectAdapt || !m.detail.Empty() || !m.crop.Empty() || m.filter != "" || !m.orient.IsZero() {
+		return 0, 0
+	}
+	return m.width, m.height
+}
+
+// orientedRegion is stream.NewRegionSource turned by o.
+func orientedRegion(src stream.Source, r stream.Region, w, h int, filter string, o stream.Orientation) *stream.RegionSource {
+	rs := stream.NewRegionSource(src, r, w, h, filter)
+	rs.SetOrientation(o)
+	return rs
+}
+
+// startDetailPipeline starts m's detail encoder on the crop of src, a
+// native-size source the main encoder reads too; nil src encodes the
+// synthetic source. Its bitrate is the mount's, and it is turned as the
+// main picture is.
+func (s *WhepServer) startDetailPipeline(m *ndiMount, src stream.Source, codec string, fps stream.Rate, br, dropframe int) (videoPipeline, error) {
+	m.mu.Lock()
+	r, key, bc, filter, orient, keyint, rc, crf, vp9 := m.detail, m.key, m.detailBC, m.filter, m.orient, m.keyint, m.rc, m.crf, m.vp9
+	m.mu.Unlock()
+	var dsrc stream.Source
+	if src != nil {
+		dsrc = orientedRegion(src, r, 0, 0, filter, orient)
+	}
+	w, h := orient.Size(r.W, r.H)
+	p, err := startPipeline(codec, stream.PipelineConfig{Width: w, Height: h, FPS: fps, BitrateKbps: br, Source: dsrc, Track: bc, VP8Speed: s.encoderCfg().VP8Speed, VP8Dropframe: dropframe, Keyint: keyint, RateControl: rc, CRF: crf, VP9: &vp9, MetricsLabel: key + "|" + viewDetail, Queue: s.cfg.Queue})
+	if err != nil {
+		return nil, fmt.Errorf("detail: %w", err)
+	}
+	return p, nil
+}
@@ -0,0 +1,359 @@
+package server
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"whep/internal/ndi"
+	"whep/internal/stream"
+)
+
+// mountKeyHeader carries the canonical mount key on POST /whep/ndi/{key}
+// answers, so clients and the admin API name exactly the same mount.
+const mountKeyHeader = "X-Mount-Key"
+
+// mountVariant is the set of encoder settings a mount is keyed by.
+// Requests go through canonicalVariantLocked before keying, so equivalent
+// spellings (fps=30, 30/1 or omitted at the default; an odd width and the
+// even one the encoder would use; the default bitrate spelled out) all land
+// on one mount.
+type mountVariant struct {
+	Width, Height int
+	FPS           stream.Rate
+	BitrateKbps   int
+	Codec         string
+	Aspect        string
+	Filter        string        // scale filter; "" for the server default
+	Detail        stream.Region // split encode's detail crop; empty without one
+	Crop          stream.Region // region of interest served; empty for the whole frame
+	Orient        stream.Orientation
+	Keyint        int              // frames between keyframes; 0 for the server's -keyint
+	RC            string           // stream.RateCBR or RateCQ; "" for the server's -rc
+	CRF           int              // CQ quality; 0 for the server's -crf, and with CBR
+	VP9           stream.VP9Tuning // VP9Params over the server's -vp9* tuning
+	VP9Params     vp9Params
+	AV1           stream.AV1Tuning // AV1Params over the server's -av1* tuning
+	AV1Params     av1Params
+	Fallback      []string // source keys to fall back to, in order (fallback=)
+	Bandwidth     string   // NDI receive bandwidth; "" for NDI_RECV_BANDWIDTH
+	// Query is the request's variant parameters as given, which another
+	// request repeats to join the same mount
+	Query url.Values
+}
+
+// variantParams are the query parameters parseVariantQuery reads.
+var variantParams = []string{"w", "h", "fps", "bitrateKbps", "keyint", "rc", "crf", "vp9speed", "vp9rowmt", "vp9tiles", "vp9aq", "av1preset", "av1tiles", "av1tilerows", "av1lowlatency", "aspect", "fit", "filter", "detail", "cx", "cy", "cw", "ch", "rotate", "flip", "fallback", "bandwidth"}
+
+// vp9Params are a request's vp9speed, vp9rowmt, vp9tiles and vp9aq; ""
+// leaves the server's.
+type vp9Params struct{ Speed, RowMT, Tiles, AQ string }
+
+// over is the tuning p makes of base.
+func (p vp9Params) over(base stream.VP9Tuning) (stream.VP9Tuning, error) {
+	return stream.ParseVP9Tuning(base, p.Speed, p.RowMT, p.Tiles, p.AQ)
+}
+
+// av1Params are a request's av1preset, av1tiles, av1tilerows and
+// av1lowlatency; "" leaves the server's.
+type av1Params struct{ Preset, Tiles, Rows, LowLatency string }
+
+// over is the tuning p makes of base.
+func (p av1Params) over(base stream.AV1Tuning) (stream.AV1Tuning, error) {
+	return stream.ParseAV1Tuning(base, p.Preset, p.Tiles, p.Rows, p.LowLatency)
+}
+
+// parseVariantQuery reads w, h, fps, bitrateKbps, keyint, rc, crf, the
+// vp9* and av1* tuning, aspect (or fit), filter, detail, the cx/cy/cw/ch
+// crop, rotate, flip, fallback and bandwidth from a mount request. A crf
+// alone implies rc=cq. Malformed or non-positive numbers are ignored; an
+// unknown rc, aspect, fit, filter, rotate, flip or bandwidth, a crf, vp9* or
+// av1* value out of range, a crf with rc=cbr, a fit disagreeing with
+// aspect, a malformed detail region or crop, both of those together, or
+// either with bandwidth=lowest is an error.
+func parseVariantQuery(q url.Values) (mountVariant, error) {
+	var v mountVariant
+	if n, err := strconv.Atoi(q.Get("w")); err == nil && n > 0 {
+		v.Width = n
+	}
+	if n, err := strconv.Atoi(q.Get("h")); err == nil && n > 0 {
+		v.Height = n
+	}
+	if s := q.Get("fps"); s != "" {
+		// 30, 29.97 or 30000/1001
+		if r, err := stream.ParseRate(s); err == nil {
+			v.FPS = r
+		}
+	}
+	if n, err := strconv.Atoi(q.Get("bitrateKbps")); err == nil && n > 0 {
+		v.BitrateKbps = n
+	}
+	if n, err := strconv.Atoi(q.Get("keyint")); err == nil && n > 0 {
+		v.Keyint = n
+	}
+	var err error
+	if v.RC, err = stream.ParseRateControl(q.Get("rc")); err != nil {
+		return v, err
+	}
+	if v.CRF, err = stream.ParseCRF(q.Get("crf")); err != nil {
+		return v, err
+	}
+	if v.CRF > 0 {
+		if v.RC == stream.RateCBR {
+			return v, fmt.Errorf("crf needs rc=%s", stream.RateCQ)
+		}
+		v.RC = stream.RateCQ
+	}
+	v.VP9Params = vp9Params{Speed: q.Get("vp9speed"), RowMT: q.Get("vp9rowmt"), Tiles: q.Get("vp9tiles"), AQ: q.Get("vp9aq")}
+	if _, err := v.VP9Params.over(stream.DefaultVP9Tuning()); err != nil {
+		return v, err
+	}
+	v.AV1Params = av1Params{Preset: q.Get("av1preset"), Tiles: q.Get("av1tiles"), Rows: q.Get("av1tilerows"), LowLatency: q.Get("av1lowlatency")}
+	if _, err := v.AV1Params.over(stream.DefaultAV1Tuning()); err != nil {
+		return v, err
+	}
+	aspect, err := stream.ParseAspect(q.Get("aspect"))
+	if err != nil {
+		return v, err
+	}
+	if f := q.Get("fit"); f != "" {
+		fit, err := parseFit(f)
+		if err != nil {
+			return v, err
+		}
+		if q.Get("aspect") != "" && fit != aspect {
+			return v, fmt.Errorf("fit=%s and aspect=%s disagree", f, q.Get("aspect"))
+		}
+		aspect = fit
+	}
+	v.Aspect = aspect
+	if v.Filter, err = stream.ParseScaleFilter(q.Get("filter")); err != nil {
+		return v, err
+	}
+	if d := q.Get("detail"); d != "" {
+		if v.Detail, err = stream.ParseRegion(d); err != nil {
+			return v, fmt.Errorf("detail: %w", err)
+		}
+	}
+	if v.Crop, err = parseCrop(q); err != nil {
+		return v, err
+	}
+	if !v.Crop.Empty() && !v.Detail.Empty() {
+		return v, fmt.Errorf("crop and detail can't be combined")
+	}
+	if v.Orient, err = stream.ParseOrientation(q.Get("rotate"), q.Get("flip")); err != nil {
+		return v, err
+	}
+	if f := q.Get("fallback"); f != "" {
+		if v.Fallback, err = parseFallback(f); err != nil {
+			return v, err
+		}
+	}
+	if v.Bandwidth, err = ndi.ParseBandwidth(q.Get("bandwidth")); err != nil {
+		return v, err
+	}
+	if v.Bandwidth == ndi.BandwidthLowest && (!v.Crop.Empty() || !v.Detail.Empty()) {
+		// The proxy stream is smaller than the source the regions measure
+		return v, fmt.Errorf("crop and detail are in full-resolution source pixels; they can't be combined with bandwidth=%s", ndi.BandwidthLowest)
+	}
+	for _, k := range variantParams {
+		if vals, ok := q[k]; ok {
+			if v.Query == nil {
+				v.Query = url.Values{}
+			}
+			v.Query[k] = vals
+		}
+	}
+	return v, nil
+}
+
+// fitStretch is fit='s name for scaling to the mount's size regardless of
+// shape, which is what aspect=adapt does once w and h are given.
+const fitStretch = "stretch"
+
+// parseFit maps fit=stretch|letterbox|crop onto the aspect policy that
+// implements it.
+func parseFit(v string) (string, error) {
+	switch v {
+	case fitStretch:
+		return stream.AspectAdapt, nil
+	case stream.AspectLetterbox, stream.AspectCrop:
+		return v, nil
+	}
+	return "", fmt.Errorf("fit must be %s, %s or %s", fitStretch, stream.AspectLetterbox, stream.AspectCrop)
+}
+
+// fitName is the fit= word for an aspect policy.
+func fitName(aspect string) string {
+	if aspect == stream.AspectAdapt || aspect == "" {
+		return fitStretch
+	}
+	return aspect
+}
+
+// resolutionValue is the X-Resolution header: the encoded size (after any
+// turn) and rate, then how the picture is fit into it, the filter scaling
+// it and the turn, if any.
+func resolutionValue(w, h int, fps stream.Rate, aspect, filter string, o stream.Orientation) string {
+	if filter == "" {
+		filter = stream.DefaultScaleFilter()
+	}
+	v := fmt.Sprintf("%dx%d@%s; fit=%s; filter=%s", w, h, fps, fitName(aspect), filter)
+	if !o.IsZero() {
+		v += fmt.Sprintf("; rotate=%d", o.Rotate)
+		if o.Flip != "" {
+			v += "; flip=" + o.Flip
+		}
+	}
+	return v
+}
+
+// evenDim rounds a requested dimension down to the even size I420 encoders
+// use; 0 (follow the source) stays 0.
+func evenDim(n int) int {
+	if n <= 0 {
+		return 0
+	}
+	return max(n&^1, 2)
+}
+
+// canonicalVariantLocked fills in the server defaults, evens the size and
+// snaps the request onto the ladder so nearby requests share one encoder.
+// A rung's codec only applies if offered has it; nil offered takes any.
+// Caller holds s.mu.
+func (s *WhepServer) canonicalVariantLocked(v mountVariant, offered codecSet) mountVariant {
+	v.Width, v.Height = evenDim(v.Width), evenDim(v.Height)
+	if !s.fpsFromSource() {
+		v.FPS = v.FPS.Or(s.fps())
+	}
+	if v.BitrateKbps <= 0 {
+		v.BitrateKbps = s.cfg.BitrateKbps
+	}
+	if v.Codec == "" {
+		v.Codec = s.videoCodec()
+	}
+	if v.Keyint <= 0 {
+		v.Keyint = s.cfg.Keyint
+	}
+	if v.RC == "" {
+		v.RC = s.rateControl()
+	}
+	// Checked when parsed
+	v.VP9, _ = v.VP9Params.over(s.vp9Tuning())
+	v.AV1, _ = v.AV1Params.over(s.av1Tuning())
+	switch {
+	case v.RC != stream.RateCQ:
+		v.CRF = 0
+	case v.CRF <= 0:
+		v.CRF = crfValue(s.cfg.CRF)
+	}
+	if v.Aspect == "" {
+		v.Aspect = stream.AspectAdapt
+	}
+	for i, k := range v.Fallback {
+		v.Fallback[i] = s.canonicalSourceKey(k)
+	}
+	if v.Bandwidth == "" {
+		// Crop and detail regions need the full-resolution stream
+		v.Bandwidth = ndi.DefaultBandwidth()
+		if !v.Crop.Empty() || !v.Detail.Empty() {
+			v.Bandwidth = ndi.BandwidthHighest
+		}
+	}
+	v, _ = s.snapVariant(v, offered)
+	return v
+}
+
+// snapVariant moves v onto the ladder rung Ladder.Snap picks for it; ok is
+// false, and v unchanged, when there is none.
+func (s *WhepServer) snapVariant(v mountVariant, offered codecSet) (mountVariant, bool) {
+	r, ok := s.ladder.Snap(LadderRung{Width: v.Width, Height: v.Height, FPS: optRate(v.FPS), BitrateKbps: v.BitrateKbps, Codec: v.Codec})
+	if !ok {
+		return v, false
+	}
+	v.Width, v.Height, v.FPS, v.BitrateKbps = r.Width, r.Height, r.rate(), r.BitrateKbps
+	// A split mount keeps its codec unless the rung's can split too
+	if (offered == nil || offered[r.Codec]) && (v.Detail.Empty() || splitCodec(r.Codec)) {
+		v.Codec = r.Codec
+	}
+	return v, true
+}
+
+// mountKeyLocked is the composite key of source's mount for a canonical
+// variant. Caller holds s.mu.
+func (s *WhepServer) mountKeyLocked(source string, v mountVariant) string {
+	key := source
+	if v.Width > 0 || v.Height > 0 || v.FPS.Valid() || v.BitrateKbps > 0 {
+		key = fmt.Sprintf("%s|w%d|h%d|f%s|b%d", source, v.Width, v.Height, v.FPS, v.BitrateKbps)
+	}
+	if v.Codec != s.videoCodec() {
+		key += "|c" + v.Codec
+	}
+	if v.Aspect != stream.AspectAdapt {
+		key += "|a" + v.Aspect
+	}
+	if v.Keyint != s.cfg.Keyint {
+		key += fmt.Sprintf("|k%d", v.Keyint)
+	}
+	if v.Codec == "vp9" && v.VP9 != s.vp9Tuning() {
+		key += "|v" + v.VP9.String()
+	}
+	if v.Codec == "av1" && v.AV1 != s.av1Tuning() {
+		key += "|p" + v.AV1.String()
+	}
+	if v.RC != s.rateControl() || (v.RC == stream.RateCQ && v.CRF != crfValue(s.cfg.CRF)) {
+		key += "|q" + v.RC
+		if v.CRF > 0 {
+			key += strconv.Itoa(v.CRF)
+		}
+	}
+	if v.Filter != "" {
+		key += "|s" + v.Filter
+	}
+	if !v.Detail.Empty() {
+		key += "|d" + v.Detail.String()
+	}
+	if !v.Crop.Empty() {
+		key += "|r" + v.Crop.String()
+	}
+	if !v.Orient.IsZero() {
+		key += "|t" + v.Orient.String()
+	}
+	if len(v.Fallback) > 0 {
+		key += "|fb" + strings.Join(v.Fallback, ",")
+	}
+	if v.Bandwidth != "" && v.Bandwidth != ndi.DefaultBandwidth() {
+		key += "|n" + v.Bandwidth
+	}
+	return key
+}
+
+// canonicalSourceKey maps the ways a client may name a source (its key in
+// any case, exact name or URL) to the key /ndi/sources lists. Failover
+// aliases and unknown keys come back unchanged.
+func (s *WhepServer) canonicalSourceKey(key string) string {
+	if _, ok := s.failoverAlias(key); ok {
+		return key
+	}
+	key = sourceKeyAlias(key)
+	idx := s.sourceIndex()
+	if _, ok := idx[key]; ok {
+		return key
+	}
+	for k, si := range idx {
+		if strings.EqualFold(k, key) || sourceMatches(key, si.Name, si.URL) {
+			return k
+		}
+	}
+	return key
+}
+
+// variantMountKey is the mount key a POST to variantEndpoint(source, r)
+// resolves to, going through the same canonicalization as the request.
+func (s *WhepServer) variantMountKey(source string, r LadderRung) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v := s.canonicalVariantLocked(mountVariant{Width: r.Width, Height: r.Height, FPS: r.rate(), BitrateKbps: r.BitrateKbps, Codec: r.Codec}, nil)
+	return s.mountKeyLocked(source, v)
+}
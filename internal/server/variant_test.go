@@ -0,0 +1,79 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"whep/internal/ndi"
+	"whep/internal/stream"
+)
+
+// Equivalent spellings of a variant land on one mount; the last query of
+// each case differs in substance and gets its own.
+func TestEquivalentVariantsShareMount(t *testing.T) {
+	crf := crfValue(0)
+	tests := []struct {
+		name    string
+		queries []string
+		differs string
+		codec   string // encoder the case needs, skipped without it
+	}{
+		{"odd size", []string{"w=640&h=360", "w=641&h=361", "w=641&h=360", "w=640&h=361"}, "w=642&h=360", ""},
+		{"default fps", []string{"w=320&h=180", "w=320&h=180&fps=30", "w=320&h=180&fps=30/1", "w=320&h=180&fps=60/2", "w=320&h=180&fps=30.0"}, "w=320&h=180&fps=25", ""},
+		{"default bitrate", []string{"w=320&h=180", "w=320&h=180&bitrateKbps=500", "w=321&h=181&fps=30&bitrateKbps=500"}, "w=320&h=180&bitrateKbps=800", ""},
+		{"default aspect", []string{"w=320&h=180", "w=320&h=180&aspect=adapt", "w=320&h=180&fit=stretch", "w=320&h=180&aspect=adapt&fit=stretch"}, "w=320&h=180&fit=letterbox", ""},
+		{"default rc", []string{"", "rc=cbr", "rc=CBR", "rc=%20cbr%20"}, "rc=cq", ""},
+		{"default crf", []string{"rc=cq", "rc=CQ", fmt.Sprintf("crf=%d", crf), fmt.Sprintf("rc=cq&crf=%d", crf)}, fmt.Sprintf("crf=%d", crf+1), ""},
+		{"filter case", []string{"filter=box", "filter=BOX", "filter=Box"}, "", ""},
+		{"default bandwidth", []string{"", "bandwidth=" + ndi.DefaultBandwidth()}, "bandwidth=" + ndi.BandwidthLowest, ""},
+		{"codec case", []string{"", "codec=vp8", "codec=VP8", "codec=Vp8&backend=NONE"}, "", "vp8"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.codec != "" {
+				if _, err := stream.ResolveEncoder(tt.codec, ""); err != nil {
+					t.Skip(err)
+				}
+			}
+			fakePipelines(t)
+			s, ts := newTestServer(t)
+			post := func(q string) string {
+				t.Helper()
+				_, sdp := newOffer(t)
+				resp, err := http.Post(ts.URL+"/whep/ndi/"+fakeKey+"?"+q, "application/sdp", strings.NewReader(sdp))
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer resp.Body.Close()
+				if resp.StatusCode != http.StatusCreated {
+					b, _ := io.ReadAll(resp.Body)
+					t.Fatalf("?%s: %d %s", q, resp.StatusCode, b)
+				}
+				return resp.Header.Get(mountKeyHeader)
+			}
+			want := post(tt.queries[0])
+			if want == "" {
+				t.Fatalf("?%s: no %s", tt.queries[0], mountKeyHeader)
+			}
+			for _, q := range tt.queries[1:] {
+				if got := post(q); got != want {
+					t.Errorf("?%s: mount %q, ?%s got %q", q, got, tt.queries[0], want)
+				}
+			}
+			s.mu.Lock()
+			n := len(s.mounts)
+			s.mu.Unlock()
+			if n != 1 {
+				t.Errorf("%d mounts, want 1", n)
+			}
+			if tt.differs != "" {
+				if got := post(tt.differs); got == want {
+					t.Errorf("?%s: shares mount %q, want its own", tt.differs, got)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,370 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+
+	"whep/internal/stream"
+)
+
+// WHIP ingest. POST /whip takes a publisher's offer (VP8, VP9 or H.264
+// video, Opus audio optional) and answers recvonly; the stream becomes
+// source whip-{id}, mounted at /whep/ndi/whip-{id} like any other. Its
+// frames go to viewers as published, without a decode and re-encode: the
+// mount's codec is the publisher's, w/h/fps and the other encoder
+// parameters don't apply, and a viewer that can't receive the codec gets
+// 406. DELETE on the Location, or the publisher going away, ends the
+// stream and the sessions watching it.
+
+// whipScheme is the URL scheme of published sources, whip://{id}.
+const whipScheme = "whip"
+
+// whipPLIInterval spaces the keyframe requests sent to a publisher; joins
+// within it share the keyframe already asked for.
+const whipPLIInterval = 500 * time.Millisecond
+
+// errWHIPRelayOnly is what opening a published source as frames gives:
+// there is no decoder, so it can only be relayed to its own mount.
+var errWHIPRelayOnly = errors.New("a WHIP source is relayed to its mount, not decoded")
+
+func init() {
+	registerSourceFactory(&sourceFactory{
+		scheme: whipScheme,
+		open: func(s *WhepServer, name, url string, w, h int, fps stream.Rate) (stream.Source, error) {
+			return nil, errWHIPRelayOnly
+		},
+	})
+}
+
+// whipPublisher is one published stream. Its tracks are depacketized into
+// video and audio, which the mounts of its key subscribe to.
+type whipPublisher struct {
+	id      string
+	key     string // source key, whip-{id}
+	pc      *webrtc.PeerConnection
+	codec   string // video codec the answer settled on
+	created time.Time
+	video   *stream.SampleBroadcaster
+	audio   *stream.SampleBroadcaster // Opus, silent without an audio track
+	ssrc    atomic.Uint32             // relayed video track, for PLIs; 0 until it arrives
+	lastPLI atomic.Int64              // unix nanos of the last PLI sent
+	packets atomic.Uint64
+	bytes   atomic.Uint64
+}
+
+func (p *whipPublisher) url() string { return whipScheme + "://" + p.id }
+
+// requestKeyframe sends the publisher a PLI, unless one went out within
+// whipPLIInterval.
+func (p *whipPublisher) requestKeyframe() {
+	ssrc := p.ssrc.Load()
+	if ssrc == 0 {
+		return
+	}
+	now, last := time.Now().UnixNano(), p.lastPLI.Load()
+	if now-last < int64(whipPLIInterval) || !p.lastPLI.CompareAndSwap(last, now) {
+		return
+	}
+	_ = p.pc.WriteRTCP([]rtcp.Packet{&rtcp.PictureLossIndication{MediaSSRC: ssrc}})
+}
+
+// relayPipe stands in for a relay mount's encoder: keyframe requests go to
+// the publisher, and there is no bitrate to retarget.
+type relayPipe struct {
+	p      *whipPublisher
+	remove func() // detaches the mount from the publisher's video
+}
+
+func (r relayPipe) Stop()          { r.remove() }
+func (r relayPipe) ForceKeyframe() { r.p.requestKeyframe() }
+func (r relayPipe) SetBitrate(int) {}
+func (r relayPipe) Bitrate() int   { return 0 }
+
+// whipPublishers holds the published streams by source key.
+type whipPublishers struct {
+	mu sync.Mutex
+	m  map[string]*whipPublisher
+}
+
+func (w *whipPublishers) add(p *whipPublisher) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.m == nil {
+		w.m = map[string]*whipPublisher{}
+	}
+	w.m[p.key] = p
+}
+
+// get returns the publisher of source key, if it is live.
+func (w *whipPublishers) get(key string) *whipPublisher {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.m[key]
+}
+
+// byURL returns the publisher of a whip:// source URL.
+func (w *whipPublishers) byURL(url string) *whipPublisher {
+	id, ok := strings.CutPrefix(url, whipScheme+"://")
+	if !ok {
+		return nil
+	}
+	return w.get(whipScheme + "-" + id)
+}
+
+// remove takes id's publisher out, returning it, or nil if it was gone.
+func (w *whipPublishers) remove(id string) *whipPublisher {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	key := whipScheme + "-" + id
+	p := w.m[key]
+	delete(w.m, key)
+	return p
+}
+
+func (w *whipPublishers) list() []*whipPublisher {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	out := make([]*whipPublisher, 0, len(w.m))
+	for _, p := range w.m {
+		out = append(out, p)
+	}
+	return out
+}
+
+// handleWHIP serves POST /whip, which publishes a stream, and
+// PATCH/DELETE /whip/{id} on the resource it returns.
+func (s *WhepServer) handleWHIP(w http.ResponseWriter, r *http.Request) {
+	allowCORS(w, r)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/whip"), "/"); id != "" {
+		switch r.Method {
+		case http.MethodPatch:
+			// Trickle-ICE noop, as for WHEP sessions
+			w.WriteHeader(http.StatusNoContent)
+		case http.MethodDelete:
+			if !s.endPublisher(id, "unpublished") {
+				writeError(w, r, http.StatusNotFound, errCodeNotFound, "publisher not found", nil)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			writeError(w, r, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "method not allowed", nil)
+		}
+		return
+	}
+	if r.Method != http.MethodPost {
+		writeError(w, r, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "method not allowed", nil)
+		return
+	}
+	if s.refuseIfDraining(w, r) {
+		return
+	}
+	offerSDP, err := io.ReadAll(r.Body)
+	if err != nil || len(offerSDP) == 0 {
+		writeError(w, r, http.StatusBadRequest, errCodeInvalidSDP, "empty offer", nil)
+		return
+	}
+	relayable := []string{"vp8", "vp9", "h264"}
+	offered := offerCodecs(string(offerSDP))
+	if _, ok := offered.pick(relayable); !ok {
+		writeError(w, r, http.StatusNotAcceptable, errCodeCodecUnsupported, "offer has no video the server can relay (vp8, vp9 or h264)", noCodecDetails(offered, relayable))
+		return
+	}
+	if err := s.checkSocketBudget(); err != nil {
+		writeError(w, r, http.StatusServiceUnavailable, errCodeLimitExceeded, err.Error(), s.socketBudgetDetails())
+		return
+	}
+	pc, err := s.newIngestPeerConnection()
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, errCodeInternal, err.Error(), nil)
+		return
+	}
+	id := uuid.New().String()
+	p := &whipPublisher{id: id, key: whipScheme + "-" + id, pc: pc, created: time.Now(), video: stream.NewSampleBroadcaster(s.cfg.Queue.Options()...), audio: stream.NewSampleBroadcaster(s.cfg.Queue.Options()...)}
+	p.video.SetKeyframeRequester(p.requestKeyframe)
+	pc.OnTrack(func(tr *webrtc.TrackRemote, _ *webrtc.RTPReceiver) { s.relayTrack(p, tr) })
+	pc.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		log.Printf("WHIP publisher %s state: %s", id, state)
+		s.changes.bump()
+		if state == webrtc.PeerConnectionStateFailed || state == webrtc.PeerConnectionStateClosed || state == webrtc.PeerConnectionStateDisconnected {
+			s.endPublisher(id, "publisher "+state.String())
+		}
+	})
+	if err := pc.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: string(offerSDP)}); err != nil {
+		_ = pc.Close()
+		writeError(w, r, http.StatusBadRequest, errCodeInvalidSDP, err.Error(), nil)
+		return
+	}
+	// Receive only what can be relayed, so a publisher offering AV1 first
+	// still settles on a codec viewers can get
+	for _, tr := range pc.GetTransceivers() {
+		var keep []webrtc.RTPCodecParameters
+		for _, c := range tr.Receiver().GetParameters().Codecs {
+			if _, ok := stream.RelayCodec(c.MimeType); ok {
+				keep = append(keep, c)
+			}
+		}
+		if len(keep) > 0 {
+			_ = tr.SetCodecPreferences(keep)
+		}
+	}
+	answer, err := pc.CreateAnswer(nil)
+	if err != nil {
+		_ = pc.Close()
+		writeError(w, r, http.StatusInternalServerError, errCodeInternal, err.Error(), nil)
+		return
+	}
+	p.codec = answerVideoCodec(answer.SDP)
+	if p.codec == "" {
+		_ = pc.Close()
+		writeError(w, r, http.StatusNotAcceptable, errCodeCodecUnsupported, "offer has no video the server can relay (vp8, vp9 or h264)", nil)
+		return
+	}
+	if err := s.setLocalAnswer(pc, answer); err != nil {
+		_ = pc.Close()
+		writeError(w, r, http.StatusInternalServerError, errCodeInternal, err.Error(), nil)
+		return
+	}
+	time.AfterFunc(sessionConnectTimeout, func() {
+		if st := pc.ConnectionState(); st == webrtc.PeerConnectionStateNew || st == webrtc.PeerConnectionStateConnecting {
+			if s.endPublisher(id, "connect timeout") {
+				log.Printf("WHIP publisher %s: timeout after %s (state: %s)", id, sessionConnectTimeout, st)
+			}
+		}
+	})
+	s.whip.add(p)
+	s.changes.bump()
+	log.Printf("WHIP publisher %s: %s, source %s", id, p.codec, p.key)
+
+	w.Header().Set("Location", s.resourceURL(r, "/whip/"+id))
+	w.Header().Set(mountKeyHeader, p.key)
+	writeSDP(w, http.StatusCreated, pc.LocalDescription().SDP)
+}
+
+// relayTrack feeds one of p's tracks into its broadcasters until it ends.
+// Only the first video track is relayed; a simulcast publisher's other
+// layers are ignored.
+func (s *WhepServer) relayTrack(p *whipPublisher, tr *webrtc.TrackRemote) {
+	codec, ok := stream.RelayCodec(tr.Codec().MimeType)
+	if !ok {
+		log.Printf("WHIP publisher %s: ignoring %s track", p.id, tr.Codec().MimeType)
+		return
+	}
+	out, onLoss := p.audio, func() {}
+	if tr.Kind() == webrtc.RTPCodecTypeVideo {
+		if !p.ssrc.CompareAndSwap(0, uint32(tr.SSRC())) {
+			log.Printf("WHIP publisher %s: ignoring extra video track %s (rid %q)", p.id, tr.ID(), tr.RID())
+			return
+		}
+		out, onLoss = p.video, p.requestKeyframe
+		// Viewers may be waiting already
+		p.requestKeyframe()
+	}
+	read := func() (*rtp.Packet, error) {
+		pkt, _, err := tr.ReadRTP()
+		if err == nil {
+			p.packets.Add(1)
+			p.bytes.Add(uint64(len(pkt.Payload)))
+		}
+		return pkt, err
+	}
+	err := stream.RelayRTP(codec, tr.Codec().ClockRate, read, out, onLoss)
+	log.Printf("WHIP publisher %s: %s track ended: %v", p.id, codec, err)
+}
+
+// endPublisher ends publisher id: its connection, its source and every
+// session watching it. It reports whether id was live.
+func (s *WhepServer) endPublisher(id, reason string) bool {
+	p := s.whip.remove(id)
+	if p == nil {
+		return false
+	}
+	p.video.Close()
+	p.audio.Close()
+	_ = p.pc.Close()
+	s.changes.bump()
+	n, m := s.closeMounts(p.key, reason)
+	log.Printf("WHIP publisher %s: ended (%s), closed %d session(s), %d mount(s)", id, reason, n, m)
+	return true
+}
+
+// startRelay feeds m from publisher p in place of a source and encoder.
+// The mount must be in the publisher's codec: nothing is transcoded.
+func (s *WhepServer) startRelay(m *ndiMount, p *whipPublisher) error {
+	m.mu.Lock()
+	codec, bc := m.codec, m.bc
+	m.mu.Unlock()
+	if codec != p.codec {
+		return fmt.Errorf("mount start: %s is published as %s and can't be sent as %s", p.key, p.codec, codec)
+	}
+	remove, err := p.video.Add(bc, stream.WithLabel(m.key))
+	if err != nil {
+		return fmt.Errorf("mount start: %s has ended", p.key)
+	}
+	m.audio.relay(p.audio)
+	pipe := relayPipe{p: p, remove: remove}
+	m.mu.Lock()
+	m.stop, m.pipe = pipe.Stop, pipe
+	m.mu.Unlock()
+	p.requestKeyframe()
+	return nil
+}
+
+// whipStats is the /health view of the publishers.
+func (s *WhepServer) whipStats() []map[string]any {
+	ps := s.whip.list()
+	sort.Slice(ps, func(i, j int) bool { return ps[i].created.Before(ps[j].created) })
+	out := make([]map[string]any, 0, len(ps))
+	for _, p := range ps {
+		out = append(out, map[string]any{
+			"id":      p.id,
+			"mount":   p.key,
+			"codec":   p.codec,
+			"state":   p.pc.ConnectionState().String(),
+			"created": p.created.UTC().Format(time.RFC3339),
+			"packets": p.packets.Load(),
+			"bytes":   p.bytes.Load(),
+		})
+	}
+	return out
+}
+
+// answerVideoCodec is the codec of the first video rtpmap in an answer,
+// the one the publisher sends, if it can be relayed.
+func answerVideoCodec(sdp string) string {
+	video := false
+	for _, line := range strings.Split(sdp, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "m=") {
+			video = strings.HasPrefix(line, "m=video") && !strings.HasPrefix(line, "m=video 0 ")
+			continue
+		}
+		if !video || !strings.HasPrefix(line, "a=rtpmap:") {
+			continue
+		}
+		// a=rtpmap:<pt> <encoding>/<clock>
+		if f := strings.Fields(line); len(f) >= 2 {
+			name, _, _ := strings.Cut(f[1], "/")
+			if c, ok := stream.RelayCodec("video/" + name); ok {
+				return c
+			}
+		}
+	}
+	return ""
+}
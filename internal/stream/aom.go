@@ -16,6 +16,10 @@ static const aom_codec_iface_t* aom_iface_av1() { return aom_codec_av1_cx(); }
 // Wrapper helpers for vararg aom_codec_control macro
 static int set_aom_cpuused(aom_codec_ctx_t *ctx, int v) { return aom_codec_control(ctx, AOME_SET_CPUUSED, v); }
 static int set_aom_enableautoaltref(aom_codec_ctx_t *ctx, int v) { return aom_codec_control(ctx, AOME_SET_ENABLEAUTOALTREF, v); }
+static int set_aom_cq_level(aom_codec_ctx_t *ctx, int v) { return aom_codec_control(ctx, AOME_SET_CQ_LEVEL, v); }
+static int set_aom_color_range(aom_codec_ctx_t *ctx, int v) { return aom_codec_control(ctx, AV1E_SET_COLOR_RANGE, v); }
+static int set_aom_tile_columns(aom_codec_ctx_t *ctx, int v) { return aom_codec_control(ctx, AV1E_SET_TILE_COLUMNS, v); }
+static int set_aom_tile_rows(aom_codec_ctx_t *ctx, int v) { return aom_codec_control(ctx, AV1E_SET_TILE_ROWS, v); }
 
 typedef struct aom_frame_data {
     void *buf;
@@ -29,27 +33,38 @@ import "C"
 
 import (
     "errors"
+    "runtime"
     "unsafe"
 )
 
+// av1Lib names the AV1 encoder in the capability table.
+const av1Lib = "libaom"
+
 type AV1Encoder struct {
     ctx   C.aom_codec_ctx_t
     cfg   C.aom_codec_enc_cfg_t
     img   *C.aom_image_t
     w, h  int
-    fps   int
+    fps   Rate
     pts   C.aom_codec_pts_t
     open  bool
+    force bool // next frame is encoded as a keyframe
+    lag   encodeLag
+    last  encodeResult
 }
 
 type AV1Config struct {
     Width, Height int
-    FPS           int
+    FPS           Rate
     BitrateKbps   int
+    Keyint        int // kf_max_dist; 0 for libaom's default
+    RateControl   string // RateCQ for constrained quality, else CBR
+    CRF           int    // cq_level in CQ mode
+    AV1Tuning            // Preset is cpu-used, up to 9
 }
 
 func NewAV1Encoder(cfg AV1Config) (*AV1Encoder, error) {
-    if cfg.Width <= 0 || cfg.Height <= 0 || cfg.FPS <= 0 {
+    if cfg.Width <= 0 || cfg.Height <= 0 || !cfg.FPS.Valid() {
         return nil, errors.New("invalid AV1 encoder config")
     }
     e := &AV1Encoder{w: cfg.Width, h: cfg.Height, fps: cfg.FPS}
@@ -58,24 +73,35 @@ func NewAV1Encoder(cfg AV1Config) (*AV1Encoder, error) {
     }
     e.cfg.g_w = C.uint(cfg.Width)
     e.cfg.g_h = C.uint(cfg.Height)
-    e.cfg.g_timebase.num = 1
-    e.cfg.g_timebase.den = C.int(cfg.FPS)
+    // Timebase is one frame period, so pts can advance by 1 per frame
+    e.cfg.g_timebase.num = C.int(cfg.FPS.Den)
+    e.cfg.g_timebase.den = C.int(cfg.FPS.Num)
     if cfg.BitrateKbps > 0 {
         e.cfg.rc_target_bitrate = C.uint(cfg.BitrateKbps)
     }
     // realtime tuning
     e.cfg.g_pass = C.AOM_RC_ONE_PASS
-    e.cfg.g_threads = 4
+    e.cfg.g_threads = C.uint(min(max(runtime.NumCPU(), 1), 16))
+    // Realtime usage already has no lag; keep it that way
+    if cfg.LowLatency { e.cfg.g_lag_in_frames = 0 }
     e.cfg.rc_end_usage = C.AOM_CBR
+    // Constrained quality: the bitrate is only a cap
+    if cfg.RateControl == RateCQ { e.cfg.rc_end_usage = C.AOM_CQ }
     e.cfg.kf_mode = C.AOM_KF_AUTO
+    if cfg.Keyint > 0 { e.cfg.kf_max_dist = C.uint(cfg.Keyint) }
     e.cfg.g_usage = C.uint(C.AOM_USAGE_REALTIME)
 
     if C.aom_codec_enc_init_ver(&e.ctx, C.aom_iface_av1(), &e.cfg, 0, C.AOM_ENCODER_ABI_VERSION) != C.AOM_CODEC_OK {
         return nil, errors.New("aom_codec_enc_init_ver failed")
     }
     // speed-up for realtime: set cpu-used
-    _ = C.set_aom_cpuused(&e.ctx, C.int(6))
+    _ = C.set_aom_cpuused(&e.ctx, C.int(cfg.presetOr(6, 9)))
+    _ = C.set_aom_tile_columns(&e.ctx, C.int(cfg.tileColumnsFor(cfg.Width)))
+    _ = C.set_aom_tile_rows(&e.ctx, C.int(cfg.TileRows))
     _ = C.set_aom_enableautoaltref(&e.ctx, C.int(0))
+    if cfg.RateControl == RateCQ { _ = C.set_aom_cq_level(&e.ctx, C.int(cqLevel(cfg.CRF))) }
+    // Tell decoders the frames are full range (encodeFuncFor delivers YUV_RANGE)
+    if YUVRange() == RangeFull { _ = C.set_aom_color_range(&e.ctx, C.int(1)) }
 
     // Allocate I420 image
     e.img = C.aom_img_alloc(nil, C.AOM_IMG_FMT_I420, C.uint(e.w), C.uint(e.h), 1)
@@ -83,6 +109,7 @@ func NewAV1Encoder(cfg AV1Config) (*AV1Encoder, error) {
         e.Close()
         return nil, errors.New("aom_img_alloc failed")
     }
+    e.lag.lag = int(e.cfg.g_lag_in_frames)
     e.open = true
     return e, nil
 }
@@ -118,11 +145,17 @@ func (e *AV1Encoder) EncodeI420(y, u, v []byte) (out [][]byte, keyframe bool, er
     }
 
     flags := C.aom_enc_frame_flags_t(0)
+    if e.force { flags |= C.AOM_EFLAG_FORCE_KF; e.force = false }
     if C.aom_codec_encode(&e.ctx, e.img, e.pts, 1, flags) != C.AOM_CODEC_OK {
         return nil, false, errors.New("aom_codec_encode failed")
     }
     e.pts++
+    out, keyframe = e.drain()
+    e.last = e.lag.account(len(out))
+    return out, keyframe, nil
+}
 
+func (e *AV1Encoder) drain() (out [][]byte, keyframe bool) {
     var iter C.aom_codec_iter_t
     for {
         pkt := C.aom_codec_get_cx_data(&e.ctx, &iter)
@@ -131,11 +164,43 @@ func (e *AV1Encoder) EncodeI420(y, u, v []byte) (out [][]byte, keyframe bool, er
         f := (*C.aom_codec_cx_pkt_t)(unsafe.Pointer(pkt))
         var frameData C.aom_frame_data_t
         C.memcpy(unsafe.Pointer(&frameData), unsafe.Pointer(&f.data), C.size_t(unsafe.Sizeof(frameData)))
-        goBytes := C.GoBytes(frameData.buf, C.int(frameData.sz))
-        out = append(out, goBytes)
+        out = append(out, C.GoBytes(frameData.buf, C.int(frameData.sz)))
         keyframe = keyframe || (frameData.flags&C.AOM_FRAME_IS_KEY) != 0
     }
-    return out, keyframe, nil
+    return out, keyframe
+}
+
+// Flush encodes NULL images until libaom returns no more packets, emitting
+// frames held back by lag-in-frames and alt-refs. No frames may be encoded
+// after it; Close must still be called.
+func (e *AV1Encoder) Flush() ([][]byte, error) {
+    if !e.open { return nil, nil }
+    var out [][]byte
+    for {
+        if C.aom_codec_encode(&e.ctx, nil, 0, 1, 0) != C.AOM_CODEC_OK {
+            return out, errors.New("aom_codec_encode (flush) failed")
+        }
+        pkts, _ := e.drain()
+        if len(pkts) == 0 { return out, nil }
+        out = append(out, pkts...)
+    }
+}
+
+// ForceKeyframe makes the next EncodeI420 produce a keyframe.
+func (e *AV1Encoder) ForceKeyframe() { e.force = true }
+
+// lastResult reports whether the last EncodeI420 with no output was held
+// back or dropped by rate control.
+func (e *AV1Encoder) lastResult() encodeResult { return e.last }
+
+// SetBitrate changes rc_target_bitrate in place, without reinitializing.
+func (e *AV1Encoder) SetBitrate(kbps int) error {
+    if !e.open { return errors.New("encoder closed") }
+    e.cfg.rc_target_bitrate = C.uint(kbps)
+    if C.aom_codec_enc_config_set(&e.ctx, &e.cfg) != C.AOM_CODEC_OK {
+        return errors.New("aom_codec_enc_config_set failed")
+    }
+    return nil
 }
 
 func (e *AV1Encoder) Close() {
@@ -0,0 +1,263 @@
+package stream
+
+import (
+    "fmt"
+    "sync"
+    "time"
+)
+
+// Aspect policies for sources whose shape changes mid-stream.
+const (
+    AspectAdapt     = "adapt"     // follow the source; the output changes shape
+    AspectLetterbox = "letterbox" // fit inside the output size, pad with black
+    AspectCrop      = "crop"      // fill the output size, center-crop the excess
+)
+
+// ParseAspect validates an aspect policy; "" means AspectAdapt.
+func ParseAspect(v string) (string, error) {
+    switch v {
+    case "", AspectAdapt:
+        return AspectAdapt, nil
+    case AspectLetterbox, AspectCrop:
+        return v, nil
+    }
+    return "", fmt.Errorf("aspect must be %s, %s or %s", AspectAdapt, AspectLetterbox, AspectCrop)
+}
+
+// AspectSource holds a source to fixed output dimensions. Every frame is
+// placed from scratch from its own size and the output size, so repeated
+// 16:9 <-> 9:16 flips land exactly where the first one did. Output is BGRA.
+type AspectSource struct {
+    src    Source
+    mode   string
+    filter string // scale filter; "" for the default
+    orient Orientation
+
+    mu   sync.Mutex
+    w, h int // output size; locked to the first frame when not given
+    // I420 scratch: source, turned source, cropped/fitted intermediate,
+    // output
+    sy, su, sv []byte
+    ry, ru, rv []byte
+    ty, tu, tv []byte
+    oy, ou, ov []byte
+    cs   ColorSpace // the planes', picked for the source size
+    out  [2][]byte // BGRA, alternated so a returned frame isn't overwritten by the next
+    cur  int
+    in   []byte // last source buffer transformed, to skip repeats
+    last []byte
+    seq  uint64 // Seq of the frame last came from; 0 when not known
+    // sizes announces the output size once it is known
+    sizes sizeNotifier
+    // frames carries transformed frames when the source pushes its own
+    frames   frameNotifier
+    pumpOnce sync.Once
+}
+
+// NewAspectSource wraps src with mode (AspectLetterbox or AspectCrop),
+// scaling with filter ("" for the default). With w or h <= 0 the output
+// keeps the size of the first frame.
+func NewAspectSource(src Source, mode string, w, h int, filter string) *AspectSource {
+    if w > 0 && h > 0 { w, h = max(w&^1, 2), max(h&^1, 2) } else { w, h = 0, 0 }
+    return &AspectSource{src: src, mode: mode, filter: filter, w: w, h: h}
+}
+
+// SetOrientation turns each frame after it is converted, before it is
+// placed; 90 and 270 swap the size the first frame locks the output to.
+// Call it before the first frame.
+func (a *AspectSource) SetOrientation(o Orientation) {
+    a.mu.Lock()
+    a.orient = o
+    a.mu.Unlock()
+}
+
+// Inner returns the wrapped source.
+func (a *AspectSource) Inner() Source { return a.src }
+
+func (a *AspectSource) Next() ([]byte, bool) {
+    frame, ok := a.src.Next()
+    if !ok || frame == nil { return nil, ok }
+    lw, ok2 := a.src.(sourceWithLast)
+    if !ok2 { return nil, true }
+    _, sw, sh, have := lw.Last()
+    if !have || sw < 2 || sh < 2 { return nil, true }
+    a.mu.Lock()
+    defer a.mu.Unlock()
+    if len(a.in) > 0 && len(frame) > 0 && &a.in[0] == &frame[0] { return a.last, true }
+    out, ok := a.render(frame, sw, sh, PixFmtOf(a.src), a.out[a.cur^1])
+    if !ok { return a.last, true }
+    a.cur ^= 1
+    a.out[a.cur] = out
+    return out, true
+}
+
+// render places a sw x sh source frame at the output size into dst, which
+// is reallocated unless it already has that size. Caller holds a.mu.
+func (a *AspectSource) render(frame []byte, sw, sh int, pixfmt string, dst []byte) ([]byte, bool) {
+    if !a.toI420(frame, sw, sh, pixfmt) { return nil, false }
+    sw, sh = sw&^1, sh&^1
+    if !a.orient.IsZero() {
+        tw, th := a.orient.Size(sw, sh)
+        a.ry, a.ru, a.rv = i420Planes(a.ry, a.ru, a.rv, tw, th)
+        orientI420(a.sy, a.su, a.sv, sw, sh, a.ry, a.ru, a.rv, a.orient)
+        a.sy, a.su, a.sv, a.ry, a.ru, a.rv = a.ry, a.ru, a.rv, a.sy, a.su, a.sv
+        sw, sh = tw, th
+    }
+    if a.w == 0 { a.w, a.h = max(sw, 2), max(sh, 2) }
+    a.place(sw, sh)
+    if n := a.w * a.h * 4; len(dst) != n { dst = make([]byte, n) }
+    I420ToBGRASpace(a.oy, a.ou, a.ov, a.w, a.h, dst, a.cs)
+    a.in, a.last, a.seq = frame, dst, 0
+    a.sizes.publish(a.w, a.h)
+    return dst, true
+}
+
+// Frames delivers each of the source's frames transformed to the output
+// size, when the source pushes frames (HasFrames).
+func (a *AspectSource) Frames() <-chan Frame {
+    a.pumpOnce.Do(func() { go a.pump() })
+    return a.frames.subscribe()
+}
+
+// StopFrames ends a Frames subscription and closes its channel.
+func (a *AspectSource) StopFrames(ch <-chan Frame) { a.frames.unsubscribe(ch) }
+
+// pump transforms the source's frames until it stops. Each gets its own
+// output buffer, since subscribers may hold several.
+func (a *AspectSource) pump() {
+    defer a.frames.close()
+    ch, stop, ok := Frames(a.src)
+    if !ok { return }
+    defer stop()
+    for f := range ch {
+        if f.W < 2 || f.H < 2 { continue }
+        a.mu.Lock()
+        // A repeated picture comes out as it did last time
+        out, ok := a.last, f.Seq != 0 && f.Seq == a.seq
+        w, h := a.w, a.h
+        a.mu.Unlock()
+        if !ok {
+            data, pixfmt := f.Packed()
+            a.mu.Lock()
+            out, ok = a.render(data, f.W, f.H, pixfmt, nil)
+            if ok { a.seq = f.Seq }
+            w, h = a.w, a.h
+            a.mu.Unlock()
+        }
+        if ok { a.frames.publish(Frame{Data: out, W: w, H: h, PixFmt: "bgra", At: f.At, Seq: f.Seq}) }
+    }
+}
+
+// toI420 converts the source frame into the s* planes, dropping an odd last
+// row or column.
+func (a *AspectSource) toI420(frame []byte, w, h int, pixfmt string) bool {
+    var ok bool
+    a.sy, a.su, a.sv, a.cs, ok = packedToI420(frame, w, h, pixfmt, a.sy, a.su, a.sv)
+    return ok
+}
+
+// packedToI420 converts a packed BGRA or UYVY w x h frame into I420 planes,
+// reallocated unless they already fit, dropping an odd last row or column.
+// cs is the color space the planes are in.
+func packedToI420(frame []byte, w, h int, pixfmt string, y, u, v []byte) ([]byte, []byte, []byte, ColorSpace, bool) {
+    bpp := 4
+    if pixfmt == "uyvy422" { bpp = 2 }
+    if len(frame) < w*h*bpp { return y, u, v, ColorSpace{}, false }
+    ew, eh := w&^1, h&^1
+    if ew != w {
+        packed := make([]byte, ew*eh*bpp)
+        for r := 0; r < eh; r++ { copy(packed[r*ew*bpp:(r+1)*ew*bpp], frame[r*w*bpp:]) }
+        frame = packed
+    }
+    y, u, v = i420Planes(y, u, v, ew, eh)
+    cs := ColorSpaceFor(ew, eh)
+    if bpp == 2 { cs.Range = RangeLimited }
+    if bpp == 2 { UYVYtoI420(frame, ew, eh, y, u, v) } else { BGRAtoI420Space(frame, ew, eh, y, u, v, cs) }
+    return y, u, v, cs, true
+}
+
+// place scales the sw x sh source planes into the output planes per mode.
+func (a *AspectSource) place(sw, sh int) {
+    a.oy, a.ou, a.ov = i420Planes(a.oy, a.ou, a.ov, a.w, a.h)
+    if sw == a.w && sh == a.h {
+        copy(a.oy, a.sy); copy(a.ou, a.su); copy(a.ov, a.sv)
+        return
+    }
+    if a.mode == AspectCrop {
+        cw, ch := CropRect(sw, sh, a.w, a.h)
+        cx, cy := ((sw-cw)/2)&^1, ((sh-ch)/2)&^1
+        a.ty, a.tu, a.tv = i420Planes(a.ty, a.tu, a.tv, cw, ch)
+        copyI420Rect(a.sy, a.su, a.sv, sw, cx, cy, a.ty, a.tu, a.tv, cw, 0, 0, cw, ch)
+        I420ScaleFilter(a.ty, a.tu, a.tv, cw, ch, a.oy, a.ou, a.ov, a.w, a.h, a.filter)
+        return
+    }
+    fw, fh := FitRect(sw, sh, a.w, a.h)
+    fillI420Black(a.oy, a.ou, a.ov)
+    a.ty, a.tu, a.tv = i420Planes(a.ty, a.tu, a.tv, fw, fh)
+    I420ScaleFilter(a.sy, a.su, a.sv, sw, sh, a.ty, a.tu, a.tv, fw, fh, a.filter)
+    copyI420Rect(a.ty, a.tu, a.tv, fw, 0, 0, a.oy, a.ou, a.ov, a.w, ((a.w-fw)/2)&^1, ((a.h-fh)/2)&^1, fw, fh)
+}
+
+// FitRect is the largest even size with the aspect of sw x sh inside dw x dh.
+func FitRect(sw, sh, dw, dh int) (int, int) {
+    w, h := dw, sh*dw/sw
+    if h > dh { w, h = sw*dh/sh, dh }
+    return min(max(w&^1, 2), dw), min(max(h&^1, 2), dh)
+}
+
+// CropRect is the largest even region of sw x sh with the aspect of dw x dh.
+func CropRect(sw, sh, dw, dh int) (int, int) {
+    w, h := sw, sw*dh/dw
+    if h > sh { w, h = sh*dw/dh, sh }
+    return min(max(w&^1, 2), sw), min(max(h&^1, 2), sh)
+}
+
+func i420Planes(y, u, v []byte, w, h int) ([]byte, []byte, []byte) {
+    if len(y) != w*h { y = make([]byte, w*h) }
+    if c := (w / 2) * (h / 2); len(u) != c { u, v = make([]byte, c), make([]byte, c) }
+    return y, u, v
+}
+
+// copyI420Rect copies a w x h region between I420 images of strides sw and
+// dw. Offsets and sizes must be even.
+func copyI420Rect(sy, su, sv []byte, sw, sx, sy0 int, dy, du, dv []byte, dw, dx, dy0, w, h int) {
+    for r := 0; r < h; r++ {
+        copy(dy[(dy0+r)*dw+dx:(dy0+r)*dw+dx+w], sy[(sy0+r)*sw+sx:])
+    }
+    for r := 0; r < h/2; r++ {
+        so, do := (sy0/2+r)*(sw/2)+sx/2, (dy0/2+r)*(dw/2)+dx/2
+        copy(du[do:do+w/2], su[so:])
+        copy(dv[do:do+w/2], sv[so:])
+    }
+}
+
+// fillI420Black sets limited-range black: Y 16, U/V 128.
+func fillI420Black(y, u, v []byte) {
+    for i := range y { y[i] = 16 }
+    for i := range u { u[i], v[i] = 128, 128 }
+}
+
+// Last reports the latest transformed frame at the output size.
+func (a *AspectSource) Last() ([]byte, int, int, bool) {
+    a.mu.Lock()
+    defer a.mu.Unlock()
+    if a.last == nil { return nil, 0, 0, false }
+    return a.last, a.w, a.h, true
+}
+
+// PixFmt is always BGRA, whatever the source delivers.
+func (a *AspectSource) PixFmt() string { return "bgra" }
+
+func (a *AspectSource) LastFrameAt() (time.Time, bool) { return LastFrameAt(a.src) }
+
+// FrameRate is the source's; the aspect policy keeps every frame.
+func (a *AspectSource) FrameRate() (num, den int) {
+    r, _ := SourceRate(a.src, 0)
+    return r.Num, r.Den
+}
+
+// SizeChanges announces the output size, which only changes when it locks
+// to the first frame.
+func (a *AspectSource) SizeChanges() <-chan FrameSize { return a.sizes.subscribe() }
+
+func (a *AspectSource) Stop() { a.src.Stop(); a.sizes.close() }
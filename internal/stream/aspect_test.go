@@ -0,0 +1,138 @@
+package stream
+
+import (
+    "bytes"
+    "flag"
+    "image"
+    "image/color"
+    "image/png"
+    "os"
+    "path/filepath"
+    "testing"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite the golden images in testdata")
+
+// flipSource hands out BGRA quadrant frames, alternating between the sizes
+// given, each in a fresh buffer so AspectSource never takes it for a repeat.
+type flipSource struct {
+    sizes [][2]int
+    n     int
+    last  []byte
+    w, h  int
+}
+
+func (s *flipSource) Next() ([]byte, bool) {
+    sz := s.sizes[s.n%len(s.sizes)]
+    s.n++
+    s.w, s.h = sz[0], sz[1]
+    s.last = quadrants(s.w, s.h)
+    return s.last, true
+}
+
+func (s *flipSource) Last() ([]byte, int, int, bool) { return s.last, s.w, s.h, s.last != nil }
+func (s *flipSource) Stop()                           {}
+
+// quadrants is a w x h BGRA frame: red, green, blue and white quarters,
+// clockwise from the top left, so a crop or a misplaced pad shows.
+func quadrants(w, h int) []byte {
+    quads := [4][4]byte{{0, 0, 255, 255}, {0, 255, 0, 255}, {255, 255, 255, 255}, {255, 0, 0, 255}}
+    buf := make([]byte, w*h*4)
+    for y := 0; y < h; y++ {
+        for x := 0; x < w; x++ {
+            q := 0
+            if x >= w/2 { q++ }
+            if y >= h/2 { q = 3 - q }
+            copy(buf[(y*w+x)*4:], quads[q][:])
+        }
+    }
+    return buf
+}
+
+// checkGolden compares a BGRA frame with testdata/aspect/name.png, allowing
+// for rounding in the color conversion. -update rewrites the file instead.
+func checkGolden(t *testing.T, name string, frame []byte, w, h int) {
+    t.Helper()
+    path := filepath.Join("testdata", "aspect", name+".png")
+    if *updateGolden {
+        img := image.NewNRGBA(image.Rect(0, 0, w, h))
+        for i := 0; i < w*h; i++ {
+            img.Pix[i*4], img.Pix[i*4+1], img.Pix[i*4+2], img.Pix[i*4+3] = frame[i*4+2], frame[i*4+1], frame[i*4], 255
+        }
+        var buf bytes.Buffer
+        if err := png.Encode(&buf, img); err != nil { t.Fatal(err) }
+        if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil { t.Fatal(err) }
+        if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil { t.Fatal(err) }
+        return
+    }
+    f, err := os.Open(path)
+    if err != nil { t.Fatalf("%v (run with -update to create it)", err) }
+    defer f.Close()
+    want, err := png.Decode(f)
+    if err != nil { t.Fatal(err) }
+    if b := want.Bounds(); b.Dx() != w || b.Dy() != h { t.Fatalf("%s: got %dx%d, golden is %dx%d", name, w, h, b.Dx(), b.Dy()) }
+    const tolerance = 2
+    bad := 0
+    for y := 0; y < h; y++ {
+        for x := 0; x < w; x++ {
+            c := color.NRGBAModel.Convert(want.At(x, y)).(color.NRGBA)
+            p := frame[(y*w+x)*4:]
+            got := [3]byte{p[2], p[1], p[0]}
+            for i, v := range [3]byte{c.R, c.G, c.B} {
+                if d := int(got[i]) - int(v); d > tolerance || d < -tolerance {
+                    if bad < 5 { t.Errorf("%s: pixel (%d,%d) is %v, want %v", name, x, y, got, [3]byte{c.R, c.G, c.B}) }
+                    bad++
+                    break
+                }
+            }
+        }
+    }
+    if bad > 0 { t.Errorf("%s: %d of %d pixels differ from the golden", name, bad, w*h) }
+}
+
+func TestAspectGolden(t *testing.T) {
+    cases := []struct {
+        name   string
+        mode   string
+        ow, oh int // output
+        sw, sh int // source
+    }{
+        // A vertical feed into a landscape output, and the other way about
+        {"letterbox_portrait_source", AspectLetterbox, 64, 36, 18, 32},
+        {"letterbox_landscape_source", AspectLetterbox, 36, 64, 32, 18},
+        {"crop_portrait_source", AspectCrop, 64, 36, 18, 32},
+        {"crop_landscape_source", AspectCrop, 36, 64, 32, 18},
+        // The same shape at another size: both policies stretch it to fill
+        {"letterbox_same_aspect", AspectLetterbox, 64, 36, 32, 18},
+        {"crop_same_aspect", AspectCrop, 64, 36, 32, 18},
+    }
+    for _, c := range cases {
+        t.Run(c.name, func(t *testing.T) {
+            src := &flipSource{sizes: [][2]int{{c.sw, c.sh}}}
+            a := NewAspectSource(src, c.mode, c.ow, c.oh, ScaleNone)
+            frame, ok := a.Next()
+            if !ok || frame == nil { t.Fatal("no frame") }
+            _, w, h, _ := a.Last()
+            if w != c.ow || h != c.oh { t.Fatalf("output is %dx%d, want %dx%d", w, h, c.ow, c.oh) }
+            checkGolden(t, c.name, frame, w, h)
+        })
+    }
+}
+
+// TestAspectFlipsDontDrift flips the source between 16:9 and 9:16 and
+// checks every flip comes out byte for byte as the first of its shape did.
+func TestAspectFlipsDontDrift(t *testing.T) {
+    for _, mode := range []string{AspectLetterbox, AspectCrop} {
+        t.Run(mode, func(t *testing.T) {
+            src := &flipSource{sizes: [][2]int{{64, 36}, {18, 32}}}
+            a := NewAspectSource(src, mode, 64, 36, ScaleNone)
+            var first [2][]byte
+            for i := 0; i < 20; i++ {
+                frame, ok := a.Next()
+                if !ok || frame == nil { t.Fatalf("flip %d: no frame", i) }
+                if i < 2 { first[i] = append([]byte(nil), frame...); continue }
+                if !bytes.Equal(frame, first[i%2]) { t.Fatalf("flip %d differs from flip %d", i, i%2) }
+            }
+        })
+    }
+}
@@ -0,0 +1,94 @@
+package stream
+
+import (
+    "fmt"
+    "strconv"
+    "strings"
+)
+
+// AV1PresetAuto leaves each AV1 library at its realtime speed: SVT-AV1
+// preset 8, libaom cpu-used 6.
+const AV1PresetAuto = -1
+
+// AV1TilesAuto picks the tile columns from the frame width.
+const AV1TilesAuto = -1
+
+// MaxAV1Preset is SVT-AV1's fastest preset. libaom's realtime cpu-used
+// stops at 9, so higher presets run it at 9.
+const MaxAV1Preset = 13
+
+// AV1Tuning is the speed, tiling and latency controls an AV1 encoder is
+// opened with.
+type AV1Tuning struct {
+    Preset      int  // SVT-AV1 enc_mode or libaom cpu-used, 0..MaxAV1Preset, or AV1PresetAuto
+    TileColumns int  // log2 of the tile columns, 0..6, or AV1TilesAuto
+    TileRows    int  // log2 of the tile rows, 0..6
+    LowLatency  bool // one frame out per frame in: no lookahead, reordering or scene-cut keyframes
+}
+
+// DefaultAV1Tuning is each library's realtime preset, tile columns by
+// width, one tile row and low latency on.
+func DefaultAV1Tuning() AV1Tuning {
+    return AV1Tuning{Preset: AV1PresetAuto, TileColumns: AV1TilesAuto, LowLatency: true}
+}
+
+// ParseAV1Tuning reads preset (0..MaxAV1Preset or auto), tiles (0..6 or
+// auto), rows (0..6) and lowlatency (on or off) over base; "" keeps
+// base's.
+func ParseAV1Tuning(base AV1Tuning, preset, tiles, rows, lowlatency string) (AV1Tuning, error) {
+    t := base
+    if preset != "" {
+        if strings.EqualFold(preset, "auto") {
+            t.Preset = AV1PresetAuto
+        } else if n, err := strconv.Atoi(preset); err == nil && n >= 0 && n <= MaxAV1Preset {
+            t.Preset = n
+        } else {
+            return base, fmt.Errorf("av1 preset must be 0..%d or auto", MaxAV1Preset)
+        }
+    }
+    if tiles != "" {
+        if strings.EqualFold(tiles, "auto") {
+            t.TileColumns = AV1TilesAuto
+        } else if n, err := strconv.Atoi(tiles); err == nil && n >= 0 && n <= 6 {
+            t.TileColumns = n
+        } else {
+            return base, fmt.Errorf("av1 tiles must be 0..6 or auto")
+        }
+    }
+    if rows != "" {
+        n, err := strconv.Atoi(rows)
+        if err != nil || n < 0 || n > 6 { return base, fmt.Errorf("av1 tile rows must be 0..6") }
+        t.TileRows = n
+    }
+    switch strings.ToLower(lowlatency) {
+    case "":
+    case "on", "1", "true":
+        t.LowLatency = true
+    case "off", "0", "false":
+        t.LowLatency = false
+    default:
+        return base, fmt.Errorf("av1 low latency must be on or off")
+    }
+    return t, nil
+}
+
+// String is e.g. "preset=auto,tiles=auto,rows=0,lowlatency=on".
+func (t AV1Tuning) String() string {
+    preset, tiles, ll := "auto", "auto", "off"
+    if t.Preset != AV1PresetAuto { preset = strconv.Itoa(t.Preset) }
+    if t.TileColumns != AV1TilesAuto { tiles = strconv.Itoa(t.TileColumns) }
+    if t.LowLatency { ll = "on" }
+    return fmt.Sprintf("preset=%s,tiles=%s,rows=%d,lowlatency=%s", preset, tiles, t.TileRows, ll)
+}
+
+// presetOr is the preset, or def for AV1PresetAuto, capped at max.
+func (t AV1Tuning) presetOr(def, max int) int {
+    if t.Preset == AV1PresetAuto { return def }
+    return min(t.Preset, max)
+}
+
+// tileColumnsFor is the log2 tile columns for a w wide frame.
+func (t AV1Tuning) tileColumnsFor(w int) int {
+    if t.TileColumns != AV1TilesAuto { return t.TileColumns }
+    return autoTileColumns(w)
+}
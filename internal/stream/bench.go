@@ -0,0 +1,99 @@
+package stream
+
+import (
+    "fmt"
+    "time"
+)
+
+// encoderOpeners opens each built codec's encoder; the pipeline files
+// register theirs.
+var encoderOpeners = map[string]openEncoder{}
+
+// vp9UntunedOpener opens VP9 without VP9Tuning; nil when VP9 isn't built.
+var vp9UntunedOpener openEncoder
+
+// BenchResult is one encoder micro-benchmark: frames synthetic frames of
+// Width x Height encoded with Codec at BitrateKbps.
+type BenchResult struct {
+    Codec       string    `json:"codec"`
+    Width       int       `json:"width"`
+    Height      int       `json:"height"`
+    FPS         string    `json:"fps"`
+    BitrateKbps int       `json:"bitrateKbps"`
+    VP8Speed    int       `json:"vp8Speed,omitempty"`
+    VP9         string    `json:"vp9,omitempty"` // VP9Tuning, or "libvpx defaults"
+    Frames      int       `json:"frames"`
+    MsPerFrame  float64   `json:"msPerFrame"`
+    At          time.Time `json:"at"`
+}
+
+// Key names the settings a result was measured with; results are only
+// comparable under the same key.
+func (r BenchResult) Key() string {
+    key := fmt.Sprintf("%s %dx%d@%s %dkbps", r.Codec, r.Width, r.Height, r.FPS, r.BitrateKbps)
+    if r.Codec == "vp8" { key += fmt.Sprintf(" speed %d", r.VP8Speed) }
+    if r.VP9 != "" { key += " " + r.VP9 }
+    return key
+}
+
+// BenchmarkEncoder encodes frames frames of the synthetic pattern with
+// codec's encoder, opened with cfg's size, rate and encoder settings, and
+// reports the mean time per EncodeI420 call. The frames are rendered and
+// converted before the clock starts, a frame interval apart so each one
+// moves. It runs on the caller's goroutine and takes no pipeline slot.
+func BenchmarkEncoder(codec string, cfg PipelineConfig, frames int) (BenchResult, error) {
+    open, ok := encoderOpeners[codec]
+    if !ok { return BenchResult{}, fmt.Errorf("%s encoder not built", codec) }
+    r, err := benchmarkWith(open, codec, cfg, frames)
+    if err == nil && codec == "vp9" {
+        r.VP9 = DefaultVP9Tuning().String()
+        if cfg.VP9 != nil { r.VP9 = cfg.VP9.String() }
+    }
+    return r, err
+}
+
+// CompareVP9Tuning runs BenchmarkEncoder for VP9 twice on the same
+// frames' settings: once with libvpx's defaults for every control, as VP9
+// encoders were opened before they were tuned, and once with cfg's tuning.
+func CompareVP9Tuning(cfg PipelineConfig, frames int) (untuned, tuned BenchResult, err error) {
+    if vp9UntunedOpener == nil { return untuned, tuned, fmt.Errorf("vp9 encoder not built") }
+    if untuned, err = benchmarkWith(vp9UntunedOpener, "vp9", cfg, frames); err != nil { return untuned, tuned, err }
+    untuned.VP9 = "libvpx defaults"
+    tuned, err = BenchmarkEncoder("vp9", cfg, frames)
+    return untuned, tuned, err
+}
+
+// benchFrames renders n frames of the synthetic pattern at w x h as I420,
+// a frame interval of fps apart so each one moves.
+func benchFrames(w, h int, fps Rate, n int) []Frame {
+    syn := &synthetic{w: w, h: h, fps: fps.Round(), buf: make([]byte, w*h*4)}
+    planes := make([]Frame, n)
+    for i := range planes {
+        syn.t0 = time.Now().Add(-time.Duration(i) * fps.Interval())
+        bgra, _ := syn.Next()
+        planes[i] = newI420Frame(w, h)
+        BGRAtoI420(bgra, w, h, planes[i].Y, planes[i].U, planes[i].V)
+    }
+    return planes
+}
+
+// benchmarkWith is BenchmarkEncoder with the encoder open opens.
+func benchmarkWith(open openEncoder, codec string, cfg PipelineConfig, frames int) (BenchResult, error) {
+    if !cfg.FPS.Valid() { cfg.FPS = IntRate(30) }
+    if cfg.BitrateKbps <= 0 { cfg.BitrateKbps = 6000 }
+    cfg.Width, cfg.Height = max(cfg.Width&^1, 2), max(cfg.Height&^1, 2)
+    if frames <= 0 { frames = 30 }
+    w, h := cfg.Width, cfg.Height
+    planes := benchFrames(w, h, cfg.FPS, frames)
+    enc, err := open(cfg, cfg.BitrateKbps)
+    if err != nil { return BenchResult{}, err }
+    defer enc.Close()
+    start := time.Now()
+    for _, f := range planes {
+        if _, _, err := enc.EncodeI420(f.Y, f.U, f.V); err != nil { return BenchResult{}, err }
+    }
+    per := time.Since(start) / time.Duration(frames)
+    r := BenchResult{Codec: codec, Width: w, Height: h, FPS: cfg.FPS.String(), BitrateKbps: cfg.BitrateKbps, Frames: frames, MsPerFrame: float64(per.Microseconds()) / 1000, At: time.Now().UTC()}
+    if codec == "vp8" { r.VP8Speed = cfg.VP8Speed }
+    return r, nil
+}
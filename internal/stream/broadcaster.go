@@ -1,7 +1,14 @@
 package stream
 
 import (
+    "errors"
+    "fmt"
+    "sort"
+    "strings"
     "sync"
+    "sync/atomic"
+    "time"
+
     "github.com/pion/webrtc/v3/pkg/media"
 )
 
@@ -10,70 +17,394 @@ import (
 type SampleBroadcaster struct {
     mu    sync.RWMutex
     sinks map[*sink]struct{}
+    // onKeyframe asks the encoder for a keyframe when a sink starts waiting
+    // for one (SetKeyframeRequester)
+    onKeyframe atomic.Pointer[func()]
+    // defaults apply to every sink before its own options
+    defaults []SinkOption
+    closed   bool // set by Close; Add refuses sinks from then on
+}
+
+// SinkPolicy selects what happens when a sink's queue is full.
+type SinkPolicy int
+
+const (
+    // SinkDropNewest discards the incoming sample (live viewer default).
+    SinkDropNewest SinkPolicy = iota
+    // SinkDropOldest evicts the head of the queue to make room for the new sample.
+    SinkDropOldest
+    // SinkBlock waits up to the configured timeout for room in the queue, then
+    // reports ErrSinkTimeout to the sink's error callback and removes the sink.
+    // The wait is on the sink's own goroutine, where up to the queue depth of
+    // samples more line up behind it; the fan-out to other sinks never
+    // waits. Intended for recorders where a missing delta frame corrupts the
+    // output.
+    SinkBlock
+)
+
+// String returns the policy's flag spelling.
+func (p SinkPolicy) String() string {
+    switch p {
+    case SinkDropNewest: return "drop-newest"
+    case SinkDropOldest: return "drop-oldest"
+    case SinkBlock: return "block"
+    }
+    return fmt.Sprintf("SinkPolicy(%d)", int(p))
+}
+
+// ParseDropPolicy parses a live-queue policy: "drop-newest" or "drop-oldest".
+// SinkBlock is for recorders only and isn't accepted.
+func ParseDropPolicy(s string) (SinkPolicy, error) {
+    switch strings.ToLower(strings.TrimSpace(s)) {
+    case "", "drop-newest", "newest": return SinkDropNewest, nil
+    case "drop-oldest", "oldest": return SinkDropOldest, nil
+    }
+    return 0, fmt.Errorf("unknown drop policy %q (want drop-newest or drop-oldest)", s)
+}
+
+// QueueConfig sizes the live sample queues (broadcaster sinks and the
+// pipelines' async writers) and picks what a full one discards.
+type QueueConfig struct {
+    Depth  int        // samples; <= 0 means the default of 4
+    Policy SinkPolicy // SinkDropNewest or SinkDropOldest
+}
+
+// Options returns q as sink options, for NewSampleBroadcaster.
+func (q QueueConfig) Options() []SinkOption {
+    return []SinkOption{WithQueueDepth(q.Depth), WithPolicy(q.Policy)}
+}
+
+func (q QueueConfig) depth() int {
+    if q.Depth <= 0 { return defaultSinkQueue }
+    return q.Depth
+}
+
+// ErrBroadcasterClosed is returned by Add once the broadcaster is closed:
+// nothing will ever be written to the sink.
+var ErrBroadcasterClosed = errors.New("broadcaster closed")
+
+// ErrSinkTimeout is reported to a blocking sink's error callback when it
+// couldn't accept a sample within its timeout.
+var ErrSinkTimeout = errors.New("sink queue full: timed out waiting for writer")
+
+// ErrSinkBacklog is reported to a blocking sink's error callback when more
+// samples were waiting for room than its queue holds.
+var ErrSinkBacklog = errors.New("sink queue full: too many samples waiting for writer")
+
+// defaultSinkQueue is the queue depth used when no option overrides it.
+const defaultSinkQueue = 4
+
+type sinkOptions struct {
+    depth   int
+    policy  SinkPolicy
+    timeout time.Duration
+    onError func(error)
+    label   string
+}
+
+// SinkOption customizes a sink registered via Add.
+type SinkOption func(*sinkOptions)
+
+// WithQueueDepth sets the number of samples buffered for the sink.
+func WithQueueDepth(n int) SinkOption {
+    return func(o *sinkOptions) { if n > 0 { o.depth = n } }
+}
+
+// WithPolicy sets the overflow policy for the sink.
+func WithPolicy(p SinkPolicy) SinkOption {
+    return func(o *sinkOptions) { o.policy = p }
+}
+
+// WithBlockTimeout sets how long a SinkBlock sink's sample may wait for room
+// in its queue before the sink is dropped. Defaults to 250ms.
+func WithBlockTimeout(d time.Duration) SinkOption {
+    return func(o *sinkOptions) { if d > 0 { o.timeout = d } }
+}
+
+// WithOnError registers a callback invoked (once, from its own goroutine) when
+// the sink fails: either its writer returned an error or a blocking enqueue
+// timed out. The sink is removed before the callback runs.
+func WithOnError(fn func(error)) SinkOption {
+    return func(o *sinkOptions) { o.onError = fn }
+}
+
+// WithLabel names the sink in Stats, e.g. by session id.
+func WithLabel(label string) SinkOption {
+    return func(o *sinkOptions) { o.label = label }
+}
+
+// RecorderSinkOptions returns the defaults used for recording/dump sinks:
+// a deeper queue that blocks briefly instead of dropping, and stops the
+// recording via onError if the disk can't keep up.
+func RecorderSinkOptions(onError func(error)) []SinkOption {
+    return []SinkOption{WithQueueDepth(64), WithPolicy(SinkBlock), WithBlockTimeout(500 * time.Millisecond), WithOnError(onError)}
 }
 
 type sink struct {
-    ch   chan media.Sample
-    quit chan struct{}
-    w    interface{ WriteSample(media.Sample) error }
+    ch       chan media.Sample
+    quit     chan struct{}
+    w        interface{ WriteSample(media.Sample) error }
+    opts     sinkOptions
+    failOnce sync.Once
+    b        *SampleBroadcaster
+    // After a video sample is lost the sink's decoder is missing a
+    // reference, so delta frames are skipped until the next keyframe
+    waitKey  atomic.Bool
+    dropped  atomic.Uint64 // samples lost to a full queue
+    skipped  atomic.Uint64 // delta frames skipped while waiting for a keyframe
+    // A SinkBlock sink's samples that found the queue full wait in backlog
+    // for its feeder, which signals on more
+    blMu    sync.Mutex
+    backlog []media.Sample
+    more    chan struct{}
+}
+
+// SetKeyframeRequester registers fn to be called, on its own goroutine, when
+// a sink drops a video sample and starts waiting for a keyframe, so it needn't
+// wait for the periodic one.
+func (b *SampleBroadcaster) SetKeyframeRequester(fn func()) { b.onKeyframe.Store(&fn) }
+
+func (b *SampleBroadcaster) requestKeyframe() {
+    if fn := b.onKeyframe.Load(); fn != nil && *fn != nil { go (*fn)() }
 }
 
-// NewSampleBroadcaster creates a broadcaster. Call Close when done.
-func NewSampleBroadcaster() *SampleBroadcaster {
-    return &SampleBroadcaster{ sinks: make(map[*sink]struct{}) }
+// sampleInfo rides in media.Sample.Metadata on video samples. Samples
+// without it (audio) are independent and never wait for a keyframe.
+type sampleInfo struct{ keyframe bool }
+
+// videoSample builds an encoded video sample stamped ts and tagged with
+// whether it is a keyframe.
+func videoSample(data []byte, dur time.Duration, ts time.Time, keyframe bool) media.Sample {
+    return media.Sample{Data: data, Duration: dur, Timestamp: ts, Metadata: sampleInfo{keyframe: keyframe}}
+}
+
+// sampleKeyframe reports whether sm is video and, if so, a keyframe.
+func sampleKeyframe(sm media.Sample) (keyframe, video bool) {
+    info, ok := sm.Metadata.(sampleInfo)
+    return info.keyframe, ok
+}
+
+// NewSampleBroadcaster creates a broadcaster. opts become the defaults of
+// every sink, e.g. QueueConfig.Options; Add's options override them. Call
+// Close when done.
+func NewSampleBroadcaster(opts ...SinkOption) *SampleBroadcaster {
+    return &SampleBroadcaster{ sinks: make(map[*sink]struct{}), defaults: opts }
 }
 
 // Add registers a track-like sink (must implement WriteSample). Returns a
 // function to remove the sink when the session ends. If the provided track
-// doesn't implement WriteSample, the returned remove is a no-op.
-func (b *SampleBroadcaster) Add(track interface{}) (remove func()) {
+// doesn't implement WriteSample, the returned remove is a no-op. After
+// Close, Add starts nothing and returns a no-op remove with
+// ErrBroadcasterClosed.
+// Without options the sink uses the broadcaster's defaults, or else a 4-deep
+// queue that drops new samples when full.
+func (b *SampleBroadcaster) Add(track interface{}, opts ...SinkOption) (remove func(), err error) {
     w, ok := track.(interface{ WriteSample(media.Sample) error })
     if !ok {
-        return func() {}
+        return func() {}, nil
+    }
+    o := sinkOptions{depth: defaultSinkQueue, policy: SinkDropNewest, timeout: 250 * time.Millisecond}
+    for _, fn := range append(b.defaults[:len(b.defaults):len(b.defaults)], opts...) {
+        fn(&o)
+    }
+    s := &sink{ ch: make(chan media.Sample, o.depth), quit: make(chan struct{}), w: w, opts: o, b: b }
+    // Registered before the worker starts, so a racing Close either sees
+    // the sink and stops it or has already refused it
+    b.mu.Lock()
+    if b.closed {
+        b.mu.Unlock()
+        return func() {}, ErrBroadcasterClosed
+    }
+    if b.sinks == nil { b.sinks = make(map[*sink]struct{}) }
+    b.sinks[s] = struct{}{}
+    b.mu.Unlock()
+    if o.policy == SinkBlock {
+        s.more = make(chan struct{}, 1)
+        go s.feed()
     }
-    s := &sink{ ch: make(chan media.Sample, 4), quit: make(chan struct{}), w: w }
     go func() {
         for {
             select {
             case sm := <-s.ch:
-                _ = s.w.WriteSample(sm)
+                if err := s.w.WriteSample(sm); err != nil && s.opts.onError != nil {
+                    b.fail(s, err)
+                    return
+                }
             case <-s.quit:
                 return
             }
         }
     }()
+    return func() { b.remove(s) }, nil
+}
+
+// remove detaches a sink and stops its worker. Safe to call more than once.
+func (b *SampleBroadcaster) remove(s *sink) {
     b.mu.Lock()
-    if b.sinks == nil { b.sinks = make(map[*sink]struct{}) }
-    b.sinks[s] = struct{}{}
+    if _, ok := b.sinks[s]; ok {
+        delete(b.sinks, s)
+        close(s.quit)
+    }
     b.mu.Unlock()
-    return func() {
-        b.mu.Lock()
-        if _, ok := b.sinks[s]; ok {
-            delete(b.sinks, s)
-            close(s.quit)
+}
+
+// feed moves a blocking sink's backlog into its queue as room frees up,
+// failing the sink when a sample waits longer than its timeout.
+func (s *sink) feed() {
+    for {
+        select {
+        case <-s.more:
+        case <-s.quit:
+            return
+        }
+        for {
+            s.blMu.Lock()
+            if len(s.backlog) == 0 { s.blMu.Unlock(); break }
+            sm := s.backlog[0]
+            s.blMu.Unlock()
+            t := time.NewTimer(s.opts.timeout)
+            select {
+            case s.ch <- sm:
+                t.Stop()
+            case <-s.quit:
+                t.Stop()
+                return
+            case <-t.C:
+                s.b.fail(s, ErrSinkTimeout)
+                return
+            }
+            s.blMu.Lock()
+            s.backlog[0] = media.Sample{}
+            s.backlog = s.backlog[1:]
+            s.blMu.Unlock()
         }
-        b.mu.Unlock()
     }
 }
 
+// fail removes the sink and reports err to its callback. Must not be called
+// while holding b.mu.
+func (b *SampleBroadcaster) fail(s *sink, err error) {
+    s.failOnce.Do(func() {
+        b.remove(s)
+        if s.opts.onError != nil {
+            go s.opts.onError(err)
+        }
+    })
+}
+
 // WriteSample implements WriteSample so the broadcaster can be used anywhere a
-// TrackLocalStaticSample would be accepted by our pipelines.
+// TrackLocalStaticSample would be accepted by our pipelines. It never waits
+// for a sink.
 func (b *SampleBroadcaster) WriteSample(sm media.Sample) error {
+    var failed []*sink
     b.mu.RLock()
     for s := range b.sinks {
+        if !s.offer(sm) {
+            failed = append(failed, s)
+        }
+    }
+    b.mu.RUnlock()
+    for _, s := range failed {
+        b.fail(s, ErrSinkBacklog)
+    }
+    return nil
+}
+
+// offer enqueues sm according to the sink's policy. It returns false only when
+// a blocking sink's backlog overflowed.
+func (s *sink) offer(sm media.Sample) bool {
+    key, video := sampleKeyframe(sm)
+    if video && s.waitKey.Load() {
+        if !key { s.skipped.Add(1); return true }
+        s.waitKey.Store(false)
+    }
+    switch s.opts.policy {
+    case SinkDropOldest:
+        for {
+            select {
+            case s.ch <- sm:
+                return true
+            default:
+            }
+            // Evict the head to make room, then retry. Queued delta frames
+            // after the evicted one are still sent; the decoder conceals
+            // until the keyframe this sink now waits for. A keyframe coming
+            // in starts a new GOP itself, so nothing waits for it.
+            select {
+            case old := <-s.ch:
+                s.dropped.Add(1)
+                if _, oldVideo := sampleKeyframe(old); oldVideo && !(video && key) {
+                    s.lostVideo()
+                    if video { s.skipped.Add(1); return true }
+                }
+            default:
+            }
+        }
+    case SinkBlock:
+        s.blMu.Lock()
+        defer s.blMu.Unlock()
+        // Straight into the queue unless samples are already waiting
+        if len(s.backlog) == 0 {
+            select {
+            case s.ch <- sm:
+                return true
+            default:
+            }
+        }
+        if len(s.backlog) >= s.opts.depth { return false }
+        s.backlog = append(s.backlog, sm)
+        select {
+        case s.more <- struct{}{}:
+        default:
+        }
+        return true
+    default:
         select {
         case s.ch <- sm:
         default:
             // Drop if the sink's queue is full
+            s.dropped.Add(1)
+            if video { s.lostVideo() }
         }
+        return true
+    }
+}
+
+// lostVideo marks the sink's GOP broken by a lost video sample: delta frames
+// are skipped until a keyframe, which is asked for.
+func (s *sink) lostVideo() {
+    if s.waitKey.CompareAndSwap(false, true) { s.b.requestKeyframe() }
+}
+
+// SinkStats counts one sink's losses.
+type SinkStats struct {
+    Label   string `json:"label,omitempty"`
+    Queued  int    `json:"queued"`
+    Dropped uint64 `json:"dropped"` // samples lost to a full queue
+    Skipped uint64 `json:"skipped"` // delta frames skipped waiting for a keyframe
+    Waiting bool   `json:"waiting_keyframe"`
+}
+
+// Stats reports every sink, sorted by label.
+func (b *SampleBroadcaster) Stats() []SinkStats {
+    b.mu.RLock()
+    out := make([]SinkStats, 0, len(b.sinks))
+    for s := range b.sinks {
+        s.blMu.Lock()
+        queued := len(s.ch) + len(s.backlog)
+        s.blMu.Unlock()
+        out = append(out, SinkStats{Label: s.opts.label, Queued: queued, Dropped: s.dropped.Load(), Skipped: s.skipped.Load(), Waiting: s.waitKey.Load()})
     }
     b.mu.RUnlock()
-    return nil
+    sort.Slice(out, func(i, j int) bool { return out[i].Label < out[j].Label })
+    return out
 }
 
-// Close stops all sink workers and clears the list.
+// Close stops all sink workers and clears the list. Sinks added after it are
+// refused.
 func (b *SampleBroadcaster) Close() {
     b.mu.Lock()
+    b.closed = true
     for s := range b.sinks {
         select { case <-s.quit: default: close(s.quit) }
         delete(b.sinks, s)
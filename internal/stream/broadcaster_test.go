@@ -0,0 +1,293 @@
+package stream
+
+import (
+    "sync"
+    "sync/atomic"
+    "testing"
+    "time"
+
+    "github.com/pion/webrtc/v3/pkg/media"
+)
+
+// stallSink holds every write until release, so its queue fills up. took
+// gets a value as each write starts.
+type stallSink struct {
+    gate chan struct{}
+    took chan struct{}
+    once sync.Once
+    mu   sync.Mutex
+    got  []byte
+}
+
+func newStallSink() *stallSink {
+    return &stallSink{gate: make(chan struct{}), took: make(chan struct{}, 256)}
+}
+
+func (s *stallSink) WriteSample(sm media.Sample) error {
+    s.took <- struct{}{}
+    <-s.gate
+    s.mu.Lock()
+    s.got = append(s.got, sm.Data[0])
+    s.mu.Unlock()
+    return nil
+}
+
+func (s *stallSink) release() { s.once.Do(func() { close(s.gate) }) }
+
+// wait returns the samples written once there are n, failing after a second.
+func (s *stallSink) wait(t *testing.T, n int) []byte {
+    t.Helper()
+    deadline := time.Now().Add(time.Second)
+    for {
+        s.mu.Lock()
+        got := append([]byte(nil), s.got...)
+        s.mu.Unlock()
+        if len(got) >= n { return got }
+        if time.Now().After(deadline) { t.Fatalf("got %v, want %d samples", got, n); return nil }
+        time.Sleep(time.Millisecond)
+    }
+}
+
+func keySample(n byte) media.Sample   { return videoSample([]byte{n}, time.Millisecond, time.Time{}, true) }
+func deltaSample(n byte) media.Sample { return videoSample([]byte{n}, time.Millisecond, time.Time{}, false) }
+func audioSample(n byte) media.Sample { return media.Sample{Data: []byte{n}, Duration: time.Millisecond} }
+
+// stalledBroadcaster has one sink with a 2-deep queue whose writer is
+// holding sample 1, a keyframe. It counts keyframe requests.
+func stalledBroadcaster(t *testing.T, opts ...SinkOption) (*SampleBroadcaster, *stallSink, *atomic.Int32) {
+    t.Helper()
+    bc := NewSampleBroadcaster()
+    t.Cleanup(bc.Close)
+    asked := &atomic.Int32{}
+    bc.SetKeyframeRequester(func() { asked.Add(1) })
+    ss := newStallSink()
+    t.Cleanup(ss.release)
+    if _, err := bc.Add(ss, append([]SinkOption{WithQueueDepth(2)}, opts...)...); err != nil { t.Fatal(err) }
+    _ = bc.WriteSample(keySample(1))
+    <-ss.took
+    return bc, ss, asked
+}
+
+func checkStats(t *testing.T, bc *SampleBroadcaster, dropped, skipped uint64, waiting bool) {
+    t.Helper()
+    st := bc.Stats()
+    if len(st) != 1 { t.Fatalf("%d sinks", len(st)) }
+    if st[0].Dropped != dropped || st[0].Skipped != skipped || st[0].Waiting != waiting {
+        t.Fatalf("stats %+v, want dropped %d skipped %d waiting %v", st[0], dropped, skipped, waiting)
+    }
+}
+
+func checkAsked(t *testing.T, asked *atomic.Int32, want int32) {
+    t.Helper()
+    // Requests run on their own goroutine
+    time.Sleep(20 * time.Millisecond)
+    if n := asked.Load(); n != want { t.Fatalf("%d keyframe requests, want %d", n, want) }
+}
+
+func equalBytes(a, b []byte) bool { return string(a) == string(b) }
+
+func TestDropNewestWaitsForKeyframe(t *testing.T) {
+    bc, ss, asked := stalledBroadcaster(t)
+    for _, sm := range []media.Sample{deltaSample(2), deltaSample(3), deltaSample(4), deltaSample(5)} { _ = bc.WriteSample(sm) }
+    // 4 didn't fit; 5 would decode against it
+    checkStats(t, bc, 1, 1, true)
+    ss.release()
+    ss.wait(t, 3)
+    _ = bc.WriteSample(deltaSample(6))
+    _ = bc.WriteSample(keySample(7))
+    _ = bc.WriteSample(deltaSample(8))
+    if got := ss.wait(t, 5); !equalBytes(got, []byte{1, 2, 3, 7, 8}) { t.Fatalf("got %v", got) }
+    checkStats(t, bc, 1, 2, false)
+    checkAsked(t, asked, 1)
+}
+
+func TestDropOldestEvictedDeltaWaitsForKeyframe(t *testing.T) {
+    bc, ss, asked := stalledBroadcaster(t, WithPolicy(SinkDropOldest))
+    for _, sm := range []media.Sample{deltaSample(2), deltaSample(3), deltaSample(4), deltaSample(5)} { _ = bc.WriteSample(sm) }
+    // 4 evicted 2, so 4 and 5 are skipped
+    checkStats(t, bc, 1, 2, true)
+    // The eviction left room for the keyframe
+    _ = bc.WriteSample(keySample(6))
+    checkStats(t, bc, 1, 2, false)
+    ss.release()
+    if got := ss.wait(t, 3); !equalBytes(got, []byte{1, 3, 6}) { t.Fatalf("got %v", got) }
+    checkAsked(t, asked, 1)
+}
+
+func TestDropOldestKeyframeEvictionStartsGOP(t *testing.T) {
+    bc, ss, asked := stalledBroadcaster(t, WithPolicy(SinkDropOldest))
+    for _, sm := range []media.Sample{deltaSample(2), deltaSample(3), keySample(4)} { _ = bc.WriteSample(sm) }
+    // The keyframe evicted 2 and needs nothing before it
+    checkStats(t, bc, 1, 0, false)
+    ss.release()
+    ss.wait(t, 3)
+    _ = bc.WriteSample(deltaSample(5))
+    if got := ss.wait(t, 4); !equalBytes(got, []byte{1, 3, 4, 5}) { t.Fatalf("got %v", got) }
+    checkStats(t, bc, 1, 0, false)
+    checkAsked(t, asked, 0)
+}
+
+func TestDropOldestEvictedAudioKeepsGOP(t *testing.T) {
+    bc, ss, asked := stalledBroadcaster(t, WithPolicy(SinkDropOldest))
+    for _, sm := range []media.Sample{audioSample(2), audioSample(3), deltaSample(4)} { _ = bc.WriteSample(sm) }
+    checkStats(t, bc, 1, 0, false)
+    ss.release()
+    if got := ss.wait(t, 3); !equalBytes(got, []byte{1, 3, 4}) { t.Fatalf("got %v", got) }
+    checkAsked(t, asked, 0)
+}
+
+// slowSink takes delay over every write.
+type slowSink struct {
+    delay time.Duration
+    mu    sync.Mutex
+    got   []byte
+}
+
+func (s *slowSink) WriteSample(sm media.Sample) error {
+    time.Sleep(s.delay)
+    s.mu.Lock()
+    s.got = append(s.got, sm.Data[0])
+    s.mu.Unlock()
+    return nil
+}
+
+func (s *slowSink) written() []byte {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    return append([]byte(nil), s.got...)
+}
+
+func sequence(n int) []byte {
+    out := make([]byte, n)
+    for i := range out { out[i] = byte(i + 1) }
+    return out
+}
+
+func TestBlockingSinkDoesNotHoldUpLiveSinks(t *testing.T) {
+    bc := NewSampleBroadcaster()
+    defer bc.Close()
+    rec := newStallSink()
+    defer rec.release()
+    failed := make(chan error, 1)
+    if _, err := bc.Add(rec, WithQueueDepth(4), WithPolicy(SinkBlock), WithBlockTimeout(300*time.Millisecond), WithOnError(func(err error) { failed <- err })); err != nil { t.Fatal(err) }
+    live := &slowSink{}
+    if _, err := bc.Add(live, WithQueueDepth(16)); err != nil { t.Fatal(err) }
+    start := time.Now()
+    for i := 1; i <= 8; i++ { _ = bc.WriteSample(keySample(byte(i))) }
+    if d := time.Since(start); d > 50*time.Millisecond { t.Fatalf("fan-out took %s behind a stalled recorder", d) }
+    deadline := time.Now().Add(time.Second)
+    for len(live.written()) < 8 && time.Now().Before(deadline) { time.Sleep(time.Millisecond) }
+    if got := live.written(); !equalBytes(got, sequence(8)) { t.Fatalf("live sink got %v", got) }
+    select {
+    case err := <-failed:
+        t.Fatalf("recorder failed before its timeout: %v", err)
+    default:
+    }
+}
+
+func TestBlockingSinkLosesNothingWhenSlow(t *testing.T) {
+    bc := NewSampleBroadcaster()
+    defer bc.Close()
+    slow := &slowSink{delay: 2 * time.Millisecond}
+    if _, err := bc.Add(slow, RecorderSinkOptions(func(err error) { t.Errorf("recorder failed: %v", err) })...); err != nil { t.Fatal(err) }
+    // Written faster than the sink takes them, within the queue and backlog
+    for i := 1; i <= 100; i++ { _ = bc.WriteSample(deltaSample(byte(i))) }
+    deadline := time.Now().Add(2 * time.Second)
+    for len(slow.written()) < 100 && time.Now().Before(deadline) { time.Sleep(time.Millisecond) }
+    if got := slow.written(); !equalBytes(got, sequence(100)) { t.Fatalf("got %d samples %v", len(got), got) }
+    if st := bc.Stats(); len(st) != 1 || st[0].Dropped != 0 { t.Fatalf("stats %+v", st) }
+}
+
+func TestBlockingSinkTimesOut(t *testing.T) {
+    bc := NewSampleBroadcaster()
+    defer bc.Close()
+    rec := newStallSink()
+    defer rec.release()
+    failed := make(chan error, 2)
+    if _, err := bc.Add(rec, WithQueueDepth(2), WithPolicy(SinkBlock), WithBlockTimeout(30*time.Millisecond), WithOnError(func(err error) { failed <- err })); err != nil { t.Fatal(err) }
+    // One held by the writer, two queued, one waiting for room
+    for i := 1; i <= 4; i++ { _ = bc.WriteSample(keySample(byte(i))) }
+    select {
+    case err := <-failed:
+        if err != ErrSinkTimeout { t.Fatalf("err %v, want ErrSinkTimeout", err) }
+    case <-time.After(time.Second):
+        t.Fatal("stalled sink didn't time out")
+    }
+    if st := bc.Stats(); len(st) != 0 { t.Fatalf("failed sink still listed: %+v", st) }
+    _ = bc.WriteSample(keySample(5))
+    select {
+    case err := <-failed:
+        t.Fatalf("error reported twice: %v", err)
+    case <-time.After(20 * time.Millisecond):
+    }
+}
+
+func TestBlockingSinkBacklogOverflow(t *testing.T) {
+    bc := NewSampleBroadcaster()
+    defer bc.Close()
+    rec := newStallSink()
+    defer rec.release()
+    failed := make(chan error, 1)
+    if _, err := bc.Add(rec, WithQueueDepth(2), WithPolicy(SinkBlock), WithBlockTimeout(time.Second), WithOnError(func(err error) { failed <- err })); err != nil { t.Fatal(err) }
+    _ = bc.WriteSample(keySample(1))
+    <-rec.took
+    // Two queued, two waiting, then one too many
+    for i := 2; i <= 6; i++ { _ = bc.WriteSample(keySample(byte(i))) }
+    select {
+    case err := <-failed:
+        if err != ErrSinkBacklog { t.Fatalf("err %v, want ErrSinkBacklog", err) }
+    case <-time.After(500 * time.Millisecond):
+        t.Fatal("overflowing sink wasn't failed")
+    }
+}
+
+func TestAddRacingClose(t *testing.T) {
+    policies := []SinkPolicy{SinkDropNewest, SinkDropOldest, SinkBlock}
+    for round := 0; round < 50; round++ {
+        bc := NewSampleBroadcaster()
+        tracks := make([]*countTrack, 12)
+        added := make([]bool, len(tracks))
+        removes := make([]func(), len(tracks))
+        var wg sync.WaitGroup
+        stop := make(chan struct{})
+        go func() {
+            for {
+                select {
+                case <-stop:
+                    return
+                default:
+                    _ = bc.WriteSample(keySample(1))
+                }
+            }
+        }()
+        for i := range tracks {
+            tracks[i] = &countTrack{}
+            wg.Add(1)
+            go func(i int) {
+                defer wg.Done()
+                remove, err := bc.Add(tracks[i], WithPolicy(policies[i%len(policies)]))
+                switch {
+                case err == nil:
+                    added[i], removes[i] = true, remove
+                case err != ErrBroadcasterClosed:
+                    t.Errorf("Add: %v", err)
+                }
+            }(i)
+        }
+        bc.Close()
+        wg.Wait()
+        close(stop)
+        if st := bc.Stats(); len(st) != 0 { t.Fatalf("round %d: %d sinks listed after Close", round, len(st)) }
+        // Workers may finish a write already taken off their queue
+        time.Sleep(5 * time.Millisecond)
+        counts := make([]int, len(tracks))
+        for i, tr := range tracks { counts[i] = tr.count() }
+        _ = bc.WriteSample(keySample(2))
+        time.Sleep(5 * time.Millisecond)
+        for i, tr := range tracks {
+            if n := tr.count(); n != counts[i] { t.Fatalf("round %d: sink %d written after Close", round, i) }
+            if !added[i] && counts[i] != 0 { t.Fatalf("round %d: refused sink %d was written", round, i) }
+            if removes[i] != nil { removes[i]() }
+        }
+    }
+}
@@ -0,0 +1,24 @@
+package stream
+
+import "sync"
+
+// Byte buffers pooled by size, for per-frame scratch space. Only buffers
+// that are done with when the frame is go back: a frame handed to Next,
+// Last or Frames consumers is never pooled, as they may hold it for as long
+// as they like.
+var bufPools sync.Map // int -> *sync.Pool of *[]byte
+
+// getBuf returns a buffer of n bytes with unspecified contents.
+func getBuf(n int) []byte {
+    if p, ok := bufPools.Load(n); ok {
+        if b, ok := p.(*sync.Pool).Get().(*[]byte); ok { return *b }
+    }
+    return make([]byte, n)
+}
+
+// putBuf returns b to the pool for its size; b must not be used after.
+func putBuf(b []byte) {
+    if len(b) == 0 { return }
+    p, _ := bufPools.LoadOrStore(len(b), &sync.Pool{})
+    p.(*sync.Pool).Put(&b)
+}
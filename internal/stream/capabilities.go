@@ -0,0 +1,160 @@
+package stream
+
+import (
+    "fmt"
+    "strings"
+    "sync"
+)
+
+// Encoder backends as named by -hwaccel; "none" is software.
+const (
+    BackendSoftware = "none"
+    BackendQSV      = "qsv"
+)
+
+// Capability is one codec x backend pair and whether this binary, as built,
+// on this host can encode it.
+type Capability struct {
+    Codec     string `json:"codec"`
+    Backend   string `json:"backend"`
+    Encoder   string `json:"encoder,omitempty"` // library, when built
+    Built     bool   `json:"built"`             // compiled in (build tags)
+    Available bool   `json:"available"`         // built and, for hardware, detected
+    Reason    string `json:"reason,omitempty"`  // why it isn't available
+}
+
+var capabilities = sync.OnceValue(func() []Capability {
+    row := func(codec, backend, lib, tag string) Capability {
+        c := Capability{Codec: codec, Backend: backend, Encoder: lib, Built: lib != "", Available: lib != ""}
+        if !c.Built { c.Reason = "built without the " + tag + " tag" }
+        return c
+    }
+    caps := []Capability{
+        row("vp8", BackendSoftware, vpxLib, "'vpx'"),
+        row("vp9", BackendSoftware, vpxLib, "'vpx'"),
+        row("av1", BackendSoftware, av1Lib, "'aom' or 'svt'"),
+        row("h264", BackendQSV, qsvLib, "'qsv'"),
+    }
+    // Hardware also has to be present
+    if q := &caps[3]; q.Built {
+        if err := probeQSV(); err != nil { q.Available, q.Reason = false, err.Error() }
+    }
+    return caps
+})
+
+// Capabilities is the codec x backend table, probed once per process.
+func Capabilities() []Capability {
+    return append([]Capability(nil), capabilities()...)
+}
+
+func capabilityFor(codec, backend string) (Capability, bool) {
+    for _, c := range capabilities() {
+        if c.Codec == codec && c.Backend == backend { return c, true }
+    }
+    return Capability{}, false
+}
+
+// validEncoders lists the pairs usable accepts, for error messages, e.g.
+// "vp8, vp9 (none); h264 (qsv)".
+func validEncoders(usable func(Capability) bool) string {
+    var order []string
+    by := map[string][]string{}
+    for _, c := range capabilities() {
+        if !usable(c) { continue }
+        if by[c.Backend] == nil { order = append(order, c.Backend) }
+        by[c.Backend] = append(by[c.Backend], c.Codec)
+    }
+    if len(order) == 0 { return "none in this build" }
+    parts := make([]string, 0, len(order))
+    for _, b := range order { parts = append(parts, fmt.Sprintf("%s (%s)", strings.Join(by[b], ", "), b)) }
+    return strings.Join(parts, "; ")
+}
+
+func knownBackend(b string) bool { return b == BackendSoftware || b == BackendQSV }
+
+// CheckEncoder validates the -codec/-hwaccel pair at startup. With a
+// hardware backend, codec is the software fallback. Hardware that is built
+// in but not detected is not an error: pipelines fall back to software and
+// HWAccelStatus reports it. A build with no encoders at all is accepted, as
+// it still serves /frame and the API.
+func CheckEncoder(codec, hwaccel string) error {
+    codec, hwaccel = strings.ToLower(strings.TrimSpace(codec)), strings.ToLower(strings.TrimSpace(hwaccel))
+    if hwaccel == "" { hwaccel = BackendSoftware }
+    if codec == "" { codec = "vp8" }
+    built := func(c Capability) bool { return c.Built }
+    if !knownBackend(hwaccel) {
+        return fmt.Errorf("unknown hwaccel %q (want %s or %s); built: %s", hwaccel, BackendSoftware, BackendQSV, validEncoders(built))
+    }
+    f, ok := findCodec(codec)
+    if !ok {
+        return fmt.Errorf("unknown codec %q; built: %s", codec, validEncoders(built))
+    }
+    anyBuilt := false
+    for _, c := range capabilities() { anyBuilt = anyBuilt || c.Built }
+    if !anyBuilt { return nil }
+    if hwaccel != BackendSoftware {
+        hw, _ := capabilityFor(hwCodec(hwaccel), hwaccel)
+        if !hw.Built {
+            return fmt.Errorf("hwaccel %s: %s; built: %s", hwaccel, hw.Reason, validEncoders(built))
+        }
+        // -codec h264 with a H.264 backend just restates it
+        if codec == hw.Codec { return nil }
+    }
+    if f.Backend != BackendSoftware {
+        return fmt.Errorf("codec %s needs -hwaccel %s; built: %s", codec, f.Backend, validEncoders(built))
+    }
+    if !f.Built {
+        return fmt.Errorf("codec %s: %s; built: %s", codec, f.Reason, validEncoders(built))
+    }
+    return nil
+}
+
+// ResolveEncoder checks a mount's codec/backend request against what is
+// available now and returns the codec to encode with. It returns "" when
+// the request names no codec and no hardware, leaving the pick to the offer.
+func ResolveEncoder(codec, backend string) (string, error) {
+    codec, backend = strings.ToLower(strings.TrimSpace(codec)), strings.ToLower(strings.TrimSpace(backend))
+    avail := func(c Capability) bool { return c.Available }
+    if backend != "" && !knownBackend(backend) {
+        return "", fmt.Errorf("unknown backend %q; available: %s", backend, validEncoders(avail))
+    }
+    if codec == "" {
+        if backend == "" || backend == BackendSoftware { return "", nil }
+        codec = hwCodec(backend)
+    }
+    if backend == "" {
+        b, ok := findCodec(codec)
+        if !ok { return "", fmt.Errorf("unknown codec %q; available: %s", codec, validEncoders(avail)) }
+        backend = b.Backend
+    }
+    c, ok := capabilityFor(codec, backend)
+    if !ok {
+        return "", fmt.Errorf("codec %s is not encoded by backend %s; available: %s", codec, backend, validEncoders(avail))
+    }
+    if !c.Available {
+        return "", fmt.Errorf("codec %s (%s): %s; available: %s", codec, backend, c.Reason, validEncoders(avail))
+    }
+    // Hardware that failed after startup is no longer usable
+    if backend != BackendSoftware {
+        if st := GetHWAccelStatus(); st.Requested == backend && !st.Active && st.Error != "" {
+            return "", fmt.Errorf("codec %s (%s): %s; available: %s", codec, backend, st.Error, validEncoders(func(c Capability) bool { return c.Available && c.Backend == BackendSoftware }))
+        }
+    }
+    return codec, nil
+}
+
+// hwCodec is the codec a hardware backend produces.
+func hwCodec(backend string) string {
+    for _, c := range capabilities() {
+        if c.Backend == backend { return c.Codec }
+    }
+    return ""
+}
+
+// findCodec returns the first row encoding codec.
+func findCodec(codec string) (Capability, bool) {
+    for _, c := range capabilities() {
+        if c.Codec == codec { return c, true }
+    }
+    return Capability{}, false
+}
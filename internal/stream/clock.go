@@ -0,0 +1,127 @@
+package stream
+
+import (
+    "log"
+    "time"
+)
+
+// A host that sleeps or hibernates stops every ticker; on resume the wall
+// clock has moved on by hours while the monotonic clock (which excludes
+// suspend on Windows and Linux) has barely advanced, or, where it doesn't
+// exclude suspend, the next tick simply arrives very late. ResumeDetector
+// spots either case so callers can resync instead of streaming from stale
+// state.
+const (
+    resumeStarveFactor = 5               // a tick this many intervals late counts as starvation
+    resumeMaxSkew      = 2 * time.Second // wall/monotonic divergence that counts as a jump
+)
+
+// Clock supplies the two readings ResumeDetector compares. Tests inject a
+// fake; SystemClock is the real one.
+type Clock interface {
+    Wall() time.Time      // wall clock, no monotonic reading
+    Mono() time.Duration  // monotonic time since an arbitrary origin
+}
+
+type systemClock struct{ t0 time.Time }
+
+func (c systemClock) Wall() time.Time     { return time.Now().Round(0) }
+func (c systemClock) Mono() time.Duration { return time.Since(c.t0) }
+
+// SystemClock returns a Clock backed by time.Now.
+func SystemClock() Clock { return systemClock{t0: time.Now()} }
+
+// Resume describes a detected suspend/resume or clock jump.
+type Resume struct {
+    Gap  time.Duration // monotonic time since the previous check
+    Skew time.Duration // wall minus monotonic advance; hours after a sleep
+}
+
+func (r Resume) String() string {
+    return "tick gap " + r.Gap.Round(time.Millisecond).String() + ", wall clock skew " + r.Skew.Round(time.Millisecond).String()
+}
+
+// ResumeDetector is checked once per tick of a loop with a fixed interval.
+// Not safe for concurrent use.
+type ResumeDetector struct {
+    interval time.Duration
+    clock    Clock
+    wall     time.Time
+    mono     time.Duration
+    started  bool
+}
+
+// NewResumeDetector returns a detector for a loop ticking every interval.
+// A nil clock means SystemClock.
+func NewResumeDetector(interval time.Duration, clock Clock) *ResumeDetector {
+    if clock == nil { clock = SystemClock() }
+    return &ResumeDetector{interval: interval, clock: clock}
+}
+
+// Check records a tick and reports whether the time since the previous one
+// points at a resume: the tick was starved for more than 5 intervals, or
+// the wall clock moved more than 2s further than the monotonic clock (in
+// either direction, so manual clock changes count too).
+func (d *ResumeDetector) Check() (Resume, bool) {
+    wall, mono := d.clock.Wall(), d.clock.Mono()
+    prevWall, prevMono, started := d.wall, d.mono, d.started
+    d.wall, d.mono, d.started = wall, mono, true
+    if !started { return Resume{}, false }
+    r := Resume{Gap: mono - prevMono}
+    r.Skew = wall.Sub(prevWall) - r.Gap
+    starved := d.interval > 0 && r.Gap > resumeStarveFactor*d.interval
+    jumped := r.Skew > resumeMaxSkew || r.Skew < -resumeMaxSkew
+    return r, starved || jumped
+}
+
+// resyncAfterResume is run by encode loops on each tick or frame: after a
+// resume it restarts the ticker's phase so missed ticks aren't made up, and
+// asks for a keyframe so viewers' decoders recover at once. ticker is nil
+// for loops paced by frame arrival.
+func resyncAfterResume(d *ResumeDetector, ticker *time.Ticker, kf *keyframeRequest, label string) {
+    r, ok := d.Check()
+    if !ok { return }
+    log.Printf("%s: resume detected (%s); resyncing", label, r)
+    if ticker != nil { ticker.Reset(d.interval) }
+    kf.request()
+}
+
+// sampleClock stamps a pipeline's samples. The wall clock is read once, at
+// pipeline start; every stamp after that is that base plus monotonic time,
+// so an NTP step or a manual clock change mid-session can't send sample
+// timestamps backwards. Stamps are strictly increasing. Not safe for
+// concurrent use.
+type sampleClock struct {
+    clock  Clock
+    base   time.Time
+    mono0  time.Duration
+    origin time.Time // first capture time seen by at
+    last   time.Time
+}
+
+// newSampleClock starts a sample clock. A nil clock means SystemClock.
+func newSampleClock(clock Clock) *sampleClock {
+    if clock == nil { clock = SystemClock() }
+    return &sampleClock{clock: clock, base: clock.Wall(), mono0: clock.Mono()}
+}
+
+// now stamps a sample by the monotonic time since the pipeline started.
+func (c *sampleClock) now() time.Time { return c.offset(c.clock.Mono() - c.mono0) }
+
+// at stamps a sample by its capture time relative to the first one seen,
+// which keeps the source's own spacing; a zero capture time falls back to
+// now. Capture times come from time.Now, so the difference between them is
+// monotonic too.
+func (c *sampleClock) at(captured time.Time) time.Time {
+    if captured.IsZero() { return c.now() }
+    if c.origin.IsZero() { c.origin = captured }
+    return c.offset(captured.Sub(c.origin))
+}
+
+// offset stamps a sample d past the base, kept after the previous stamp.
+func (c *sampleClock) offset(d time.Duration) time.Time {
+    ts := c.base.Add(d)
+    if !c.last.IsZero() && !ts.After(c.last) { ts = c.last.Add(time.Nanosecond) }
+    c.last = ts
+    return ts
+}
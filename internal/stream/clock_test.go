@@ -0,0 +1,90 @@
+package stream
+
+import (
+    "testing"
+    "time"
+)
+
+// fakeClock is a Clock the test moves by hand.
+type fakeClock struct {
+    wall time.Time
+    mono time.Duration
+}
+
+func (c *fakeClock) Wall() time.Time     { return c.wall }
+func (c *fakeClock) Mono() time.Duration { return c.mono }
+
+// advance moves both clocks on by d, as a running host does.
+func (c *fakeClock) advance(d time.Duration) { c.wall = c.wall.Add(d); c.mono += d }
+
+func TestSampleClockWallStepBack(t *testing.T) {
+    fc := &fakeClock{wall: time.Date(2026, 3, 29, 1, 30, 0, 0, time.UTC), mono: time.Minute}
+    c := newSampleClock(fc)
+    base := c.base
+    var prev time.Time
+    check := func(what string, ts time.Time) {
+        t.Helper()
+        if !prev.IsZero() && !ts.After(prev) { t.Fatalf("%s stamp %v is not after %v", what, ts, prev) }
+        prev = ts
+    }
+
+    captured := fc.wall
+    for i := 0; i < 5; i++ {
+        fc.advance(40 * time.Millisecond)
+        check("now", c.now())
+        captured = captured.Add(40 * time.Millisecond)
+        check("at", c.at(captured))
+    }
+    // An NTP step or a manual change: the wall clock goes back an hour
+    // while the monotonic clock carries on
+    fc.wall = fc.wall.Add(-time.Hour)
+    var last time.Time
+    for i := 0; i < 5; i++ {
+        fc.advance(40 * time.Millisecond)
+        last = c.now()
+        check("now", last)
+        check("at", c.at(time.Time{}))
+    }
+    if want := base.Add(fc.mono - time.Minute); !last.Equal(want) { t.Errorf("stamp %v, want %v (base plus monotonic time)", last, want) }
+
+    // Capture times that repeat or go back are still stamped after the last
+    check("at", c.at(captured))
+    check("at", c.at(captured))
+    check("at", c.at(captured.Add(-time.Hour)))
+    // A stalled monotonic clock too
+    check("now", c.now())
+    check("now", c.now())
+}
+
+func TestResumeDetector(t *testing.T) {
+    const interval = 40 * time.Millisecond
+    tests := []struct {
+        name       string
+        wall, mono time.Duration // advance of each clock since the previous tick
+        want       bool
+    }{
+        {"normal tick", interval, interval, false},
+        {"late tick", 3 * interval, 3 * interval, false},
+        {"starved tick", 5*interval + time.Millisecond, 5*interval + time.Millisecond, true},
+        {"exactly 5 intervals", 5 * interval, 5 * interval, false},
+        // Suspend the monotonic clock doesn't count: the wall clock runs away
+        {"sleep", 2 * time.Hour, interval, true},
+        {"wall stepped back", interval - time.Hour, interval, true},
+        {"small skew", interval + time.Second, interval, false},
+        {"skew past 2s", interval + 2*time.Second + time.Millisecond, interval, true},
+    }
+    for _, tt := range tests {
+        fc := &fakeClock{wall: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)}
+        d := NewResumeDetector(interval, fc)
+        if _, ok := d.Check(); ok { t.Fatalf("%s: first check reported a resume", tt.name) }
+        fc.advance(interval)
+        if _, ok := d.Check(); ok { t.Fatalf("%s: steady tick reported a resume", tt.name) }
+        fc.wall, fc.mono = fc.wall.Add(tt.wall), fc.mono+tt.mono
+        r, ok := d.Check()
+        if ok != tt.want { t.Errorf("%s: Check() = %v (%s), want %v", tt.name, ok, r, tt.want) }
+        if r.Gap != tt.mono || r.Skew != tt.wall-tt.mono { t.Errorf("%s: gap %v skew %v, want %v %v", tt.name, r.Gap, r.Skew, tt.mono, tt.wall-tt.mono) }
+        // The detector moves on: the next normal tick is quiet
+        fc.advance(interval)
+        if _, ok := d.Check(); ok { t.Errorf("%s: tick after the resume reported one too", tt.name) }
+    }
+}
@@ -0,0 +1,252 @@
+package stream
+
+import (
+    "math"
+    "os"
+    "strings"
+)
+
+// ColorMatrix selects the coefficients of RGB <-> YUV conversions. BT.601
+// is the SD matrix and BT.709 the HD one; a 1080p source converted with
+// the 601 matrix comes out with shifted hues, greens most visibly.
+type ColorMatrix uint8
+
+const (
+    MatrixAuto ColorMatrix = iota // by frame size: BT.709 from 720 lines up
+    BT601
+    BT709
+)
+
+func (m ColorMatrix) String() string {
+    switch m {
+    case BT601: return "bt601"
+    case BT709: return "bt709"
+    }
+    return "auto"
+}
+
+// ParseColorMatrix reads a YUV_MATRIX value: bt601, bt709 or auto (also
+// "601", "709"; empty is auto).
+func ParseColorMatrix(s string) (ColorMatrix, bool) {
+    switch strings.ToLower(strings.TrimSpace(s)) {
+    case "", "auto": return MatrixAuto, true
+    case "bt601", "bt.601", "601": return BT601, true
+    case "bt709", "bt.709", "709": return BT709, true
+    }
+    return MatrixAuto, false
+}
+
+// YUVMatrix is the matrix set by YUV_MATRIX; unknown values count as auto.
+// Read per call, like the scale filter, so -yuvMatrix and later changes
+// to the environment apply.
+func YUVMatrix() ColorMatrix {
+    m, _ := ParseColorMatrix(os.Getenv("YUV_MATRIX"))
+    return m
+}
+
+// For resolves auto for a w x h frame by size alone.
+func (m ColorMatrix) For(w, h int) ColorMatrix {
+    if m != MatrixAuto { return m }
+    if h >= 720 { return BT709 }
+    return BT601
+}
+
+// ColorRange is the span of YUV samples: limited (studio swing, luma 16-235
+// and chroma 16-240) or full (0-255). WebRTC decoders assume limited unless
+// the stream says otherwise, and only VP9 and AV1 can say so.
+type ColorRange uint8
+
+const (
+    RangeDefault ColorRange = iota // YUV_RANGE, limited when unset
+    RangeLimited
+    RangeFull
+)
+
+func (r ColorRange) String() string {
+    switch r {
+    case RangeLimited: return "limited"
+    case RangeFull: return "full"
+    }
+    return "default"
+}
+
+// ParseColorRange reads a YUV_RANGE value: limited or full (also "tv",
+// "pc"; empty is limited).
+func ParseColorRange(s string) (ColorRange, bool) {
+    switch strings.ToLower(strings.TrimSpace(s)) {
+    case "", "limited", "tv", "studio": return RangeLimited, true
+    case "full", "pc", "jpeg": return RangeFull, true
+    }
+    return RangeLimited, false
+}
+
+// YUVRange is the range set by YUV_RANGE; unknown values count as limited.
+// Read per call, like YUVMatrix.
+func YUVRange() ColorRange {
+    r, _ := ParseColorRange(os.Getenv("YUV_RANGE"))
+    return r
+}
+
+func (r ColorRange) resolve() ColorRange {
+    if r == RangeDefault { return YUVRange() }
+    return r
+}
+
+// ColorSpace is the matrix and range YUV samples are in. The zero value
+// takes both from the environment, the matrix by frame size.
+type ColorSpace struct {
+    Matrix ColorMatrix
+    Range  ColorRange
+}
+
+func (cs ColorSpace) String() string { return cs.Matrix.String() + "/" + cs.Range.String() }
+
+// For resolves the defaults for a w x h frame.
+func (cs ColorSpace) For(w, h int) ColorSpace {
+    if cs.Matrix == MatrixAuto { cs.Matrix = YUVMatrix() }
+    cs.Matrix = cs.Matrix.For(w, h)
+    cs.Range = cs.Range.resolve()
+    return cs
+}
+
+// ColorSpaceFor is the color space conversions use for a w x h frame.
+func ColorSpaceFor(w, h int) ColorSpace { return ColorSpace{}.For(w, h) }
+
+// yuvCoeffs are a color space's integer coefficients, scaled by 256:
+//   Y = (yr*r + yg*g + yb*b + 128) >> 8 + yo, U and V likewise around 128
+//   r = (ys*(Y-yo) + rv*(V-128) + 128) >> 8, g and b likewise
+type yuvCoeffs struct {
+    yr, yg, yb int
+    ur, ug, ub int
+    vr, vg, vb int
+    ys, yo     int
+    rv, gu, gv, bu int
+}
+
+var (
+    bt601Coeffs = yuvCoeffs{
+        66, 129, 25,
+        -38, -74, 112,
+        112, -94, -18,
+        298, 16,
+        409, -100, -208, 516,
+    }
+    bt709Coeffs = yuvCoeffs{
+        47, 157, 16,
+        -26, -86, 112,
+        112, -102, -10,
+        298, 16,
+        459, -55, -136, 541,
+    }
+    bt601FullCoeffs = yuvCoeffs{
+        77, 150, 29,
+        -43, -85, 128,
+        128, -107, -21,
+        256, 0,
+        359, -88, -183, 454,
+    }
+    bt709FullCoeffs = yuvCoeffs{
+        54, 183, 19,
+        -29, -99, 128,
+        128, -116, -12,
+        256, 0,
+        403, -48, -120, 475,
+    }
+)
+
+func coeffsFor(cs ColorSpace, w, h int) *yuvCoeffs {
+    cs = cs.For(w, h)
+    switch {
+    case cs.Matrix == BT709 && cs.Range == RangeFull: return &bt709FullCoeffs
+    case cs.Matrix == BT709: return &bt709Coeffs
+    case cs.Range == RangeFull: return &bt601FullCoeffs
+    }
+    return &bt601Coeffs
+}
+
+// rangeMap maps YUV samples into one range from the other; YUV from the
+// sender (UYVY, NV12, ...) is limited and is expanded for a full-range
+// stream.
+type rangeMap struct{ y, c [256]byte }
+
+var toFullRange, toLimitedRange = func() (f, l rangeMap) {
+    for i := 0; i < 256; i++ {
+        f.y[i] = clamp8(int(math.Round(float64(i-16) * 255 / 219)))
+        f.c[i] = clamp8(int(math.Round(float64(i-128)*255/224)) + 128)
+        l.y[i] = clamp8(int(math.Round(float64(i)*219/255)) + 16)
+        l.c[i] = clamp8(int(math.Round(float64(i-128)*224/255)) + 128)
+    }
+    return f, l
+}()
+
+// rangeMapTo maps samples into range r from the other one.
+func rangeMapTo(r ColorRange) *rangeMap {
+    if r.resolve() == RangeFull { return &toFullRange }
+    return &toLimitedRange
+}
+
+// luma maps src's luma samples into dst, which may be src.
+func (m *rangeMap) luma(dst, src []byte) { for i, v := range src { dst[i] = m.y[v] } }
+
+// chroma maps src's chroma samples (planar or interleaved) into dst.
+func (m *rangeMap) chroma(dst, src []byte) { for i, v := range src { dst[i] = m.c[v] } }
+
+// rgbToI420 converts packed 32-bit pixels to I420 with k, reading red,
+// green and blue at byte offsets ri, gi and bi of each pixel. Chroma is the
+// average of each 2x2 block. Rows are converted in parallel.
+func rgbToI420(src []byte, w, h int, y, u, v []byte, ri, gi, bi int, k *yuvCoeffs) {
+    parallelRows(h, 2, func(r0, r1 int) { rgbToI420Rows(src, w, h, y, u, v, ri, gi, bi, k, r0, r1) })
+}
+
+// rgbToI420Rows is rgbToI420 for rows [r0, r1); r0 is even.
+func rgbToI420Rows(src []byte, w, h int, y, u, v []byte, ri, gi, bi int, k *yuvCoeffs, r0, r1 int) {
+    for yrow := r0; yrow < r1; yrow++ {
+        for x := 0; x < w; x++ {
+            off := (yrow*w + x) * 4
+            r, g, b := int(src[off+ri]), int(src[off+gi]), int(src[off+bi])
+            y[yrow*w+x] = clamp8(((k.yr*r + k.yg*g + k.yb*b + 128) >> 8) + k.yo)
+        }
+    }
+    for yrow := r0; yrow < r1 && yrow+1 < h; yrow += 2 {
+        for x := 0; x+1 < w; x += 2 {
+            var rSum, gSum, bSum int
+            for dy := 0; dy < 2; dy++ {
+                for dx := 0; dx < 2; dx++ {
+                    off := ((yrow+dy)*w + (x+dx)) * 4
+                    rSum += int(src[off+ri])
+                    gSum += int(src[off+gi])
+                    bSum += int(src[off+bi])
+                }
+            }
+            r := rSum >> 2; g := gSum >> 2; b := bSum >> 2
+            ci := (yrow/2)*(w/2) + x/2
+            u[ci] = clamp8(((k.ur*r + k.ug*g + k.ub*b + 128) >> 8) + 128)
+            v[ci] = clamp8(((k.vr*r + k.vg*g + k.vb*b + 128) >> 8) + 128)
+        }
+    }
+}
+
+// i420ToRGB converts I420 to packed 32-bit pixels with k, writing red,
+// green, blue and an opaque alpha at byte offsets ri, gi, bi and ai. Rows
+// are converted in parallel.
+func i420ToRGB(y, u, v []byte, w, h int, out []byte, ri, gi, bi, ai int, k *yuvCoeffs) {
+    parallelRows(h, 1, func(r0, r1 int) { i420ToRGBRows(y, u, v, w, out, ri, gi, bi, ai, k, r0, r1) })
+}
+
+// i420ToRGBRows is i420ToRGB for rows [r0, r1).
+func i420ToRGBRows(y, u, v []byte, w int, out []byte, ri, gi, bi, ai int, k *yuvCoeffs, r0, r1 int) {
+    for yy := r0; yy < r1; yy++ {
+        for xx := 0; xx < w; xx++ {
+            c := int(y[yy*w+xx]) - k.yo
+            d := int(u[(yy/2)*(w/2)+(xx/2)]) - 128
+            e := int(v[(yy/2)*(w/2)+(xx/2)]) - 128
+            if c < 0 { c = 0 }
+            c *= k.ys
+            off := (yy*w + xx) * 4
+            out[off+ri] = clamp8((c + k.rv*e + 128) >> 8)
+            out[off+gi] = clamp8((c + k.gu*d + k.gv*e + 128) >> 8)
+            out[off+bi] = clamp8((c + k.bu*d + 128) >> 8)
+            out[off+ai] = 255
+        }
+    }
+}
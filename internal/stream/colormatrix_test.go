@@ -0,0 +1,142 @@
+package stream
+
+import (
+    "fmt"
+    "testing"
+)
+
+// colorPatches are the test card's colors and a few mid tones, and what each comes to in each
+// color space by the BT.601 and BT.709 definitions, rounded.
+var colorPatches = []struct {
+    name    string
+    rgb     [3]byte
+    yuv601  [3]byte
+    yuv601F [3]byte
+    yuv709  [3]byte
+    yuv709F [3]byte
+}{
+    {"black", [3]byte{0, 0, 0}, [3]byte{16, 128, 128}, [3]byte{0, 128, 128}, [3]byte{16, 128, 128}, [3]byte{0, 128, 128}},
+    {"white", [3]byte{255, 255, 255}, [3]byte{235, 128, 128}, [3]byte{255, 128, 128}, [3]byte{235, 128, 128}, [3]byte{255, 128, 128}},
+    {"grey", [3]byte{128, 128, 128}, [3]byte{126, 128, 128}, [3]byte{128, 128, 128}, [3]byte{126, 128, 128}, [3]byte{128, 128, 128}},
+    {"red", [3]byte{255, 0, 0}, [3]byte{81, 90, 240}, [3]byte{76, 85, 255}, [3]byte{63, 102, 240}, [3]byte{54, 99, 255}},
+    {"green", [3]byte{0, 255, 0}, [3]byte{145, 54, 34}, [3]byte{150, 44, 21}, [3]byte{173, 42, 26}, [3]byte{182, 30, 12}},
+    {"blue", [3]byte{0, 0, 255}, [3]byte{41, 240, 110}, [3]byte{29, 255, 107}, [3]byte{32, 240, 118}, [3]byte{18, 255, 116}},
+    {"yellow", [3]byte{255, 255, 0}, [3]byte{210, 16, 146}, [3]byte{226, 0, 149}, [3]byte{219, 16, 138}, [3]byte{237, 0, 140}},
+    {"cyan", [3]byte{0, 255, 255}, [3]byte{170, 166, 16}, [3]byte{179, 171, 0}, [3]byte{188, 154, 16}, [3]byte{201, 157, 0}},
+    {"magenta", [3]byte{255, 0, 255}, [3]byte{106, 202, 222}, [3]byte{105, 212, 235}, [3]byte{78, 214, 230}, [3]byte{73, 226, 244}},
+    // Mid tones, which no channel clamps on the way back
+    {"orange", [3]byte{200, 100, 50}, [3]byte{123, 91, 175}, [3]byte{124, 86, 182}, [3]byte{117, 96, 174}, [3]byte{118, 92, 180}},
+    {"teal", [3]byte{40, 120, 160}, [3]byte{102, 157, 90}, [3]byte{101, 161, 85}, [3]byte{107, 154, 91}, [3]byte{106, 157, 86}},
+    {"violet", [3]byte{90, 60, 140}, [3]byte{83, 159, 135}, [3]byte{78, 163, 136}, [3]byte{78, 160, 138}, [3]byte{72, 165, 139}},
+}
+
+func near(a, b byte, tol int) bool {
+    d := int(a) - int(b)
+    return d >= -tol && d <= tol
+}
+
+// Each patch, 2x2 so its chroma is its own, converts to the reference YUV
+// within 1 and back to its RGB within 2, in every color space.
+func TestColorMatrixGolden(t *testing.T) {
+    w, h := 2*len(colorPatches), 2
+    src := make([]byte, w*h*4)
+    for i, p := range colorPatches {
+        for dy := 0; dy < 2; dy++ {
+            for dx := 0; dx < 2; dx++ {
+                off := (dy*w + 2*i + dx) * 4
+                src[off], src[off+1], src[off+2], src[off+3] = p.rgb[2], p.rgb[1], p.rgb[0], 255
+            }
+        }
+    }
+    for _, cs := range []struct {
+        name string
+        k    *yuvCoeffs
+        want func(i int) [3]byte
+    }{
+        {"bt601/limited", &bt601Coeffs, func(i int) [3]byte { return colorPatches[i].yuv601 }},
+        {"bt601/full", &bt601FullCoeffs, func(i int) [3]byte { return colorPatches[i].yuv601F }},
+        {"bt709/limited", &bt709Coeffs, func(i int) [3]byte { return colorPatches[i].yuv709 }},
+        {"bt709/full", &bt709FullCoeffs, func(i int) [3]byte { return colorPatches[i].yuv709F }},
+    } {
+        y, u, v := make([]byte, w*h), make([]byte, w*h/4), make([]byte, w*h/4)
+        rgbToI420(src, w, h, y, u, v, 2, 1, 0, cs.k)
+        out := make([]byte, w*h*4)
+        i420ToRGB(y, u, v, w, h, out, 2, 1, 0, 3, cs.k)
+        for i, p := range colorPatches {
+            want := cs.want(i)
+            for _, yi := range []int{2 * i, 2*i + 1, w + 2*i, w + 2*i + 1} {
+                if !near(y[yi], want[0], 1) { t.Errorf("%s %s: Y %d, want %d", cs.name, p.name, y[yi], want[0]) }
+            }
+            if !near(u[i], want[1], 1) || !near(v[i], want[2], 1) { t.Errorf("%s %s: UV %d,%d, want %d,%d", cs.name, p.name, u[i], v[i], want[1], want[2]) }
+            off := 2 * i * 4
+            got := [3]byte{out[off+2], out[off+1], out[off]}
+            for c := range got {
+                if !near(got[c], p.rgb[c], 2) { t.Errorf("%s %s: round trip %v, want %v", cs.name, p.name, got, p.rgb); break }
+            }
+            if out[off+3] != 255 { t.Errorf("%s %s: alpha %d", cs.name, p.name, out[off+3]) }
+        }
+    }
+}
+
+// BenchmarkBGRAtoI420Space converts a 1080p frame in each color space.
+// With -tags yuv, bt601/limited (and bt601/full from ARGB) runs in libyuv
+// and the rest in Go, so the gap is what auto's BT.709 costs HD frames.
+func BenchmarkBGRAtoI420Space(b *testing.B) {
+    const w, h = 1920, 1080
+    src := make([]byte, w*h*4)
+    for i := range src { src[i] = byte(i * 13) }
+    y, u, v := make([]byte, w*h), make([]byte, w*h/4), make([]byte, w*h/4)
+    for _, m := range []ColorMatrix{BT601, BT709} {
+        for _, r := range []ColorRange{RangeLimited, RangeFull} {
+            cs := ColorSpace{Matrix: m, Range: r}
+            b.Run(fmt.Sprint(cs), func(b *testing.B) {
+                b.SetBytes(int64(len(src)))
+                for i := 0; i < b.N; i++ { BGRAtoI420Space(src, w, h, y, u, v, cs) }
+            })
+        }
+    }
+}
+
+func TestParseColorRange(t *testing.T) {
+    for in, want := range map[string]ColorRange{"": RangeLimited, "limited": RangeLimited, " TV ": RangeLimited, "studio": RangeLimited, "full": RangeFull, "PC": RangeFull, "jpeg": RangeFull} {
+        if got, ok := ParseColorRange(in); !ok || got != want { t.Errorf("ParseColorRange(%q) = %v, %v; want %v", in, got, ok, want) }
+    }
+    if got, ok := ParseColorRange("wide"); ok || got != RangeLimited { t.Errorf("ParseColorRange(wide) = %v, %v; want limited, false", got, ok) }
+}
+
+// A limited-range grey ramp, luma and chroma, survives expanding to full
+// range and back within 1, and limited maps nothing out of its range.
+func TestRangeMapRoundTrip(t *testing.T) {
+    full, limited := rangeMapTo(RangeFull), rangeMapTo(RangeLimited)
+    if full != &toFullRange || limited != &toLimitedRange { t.Fatal("rangeMapTo picked the wrong map") }
+    ramp := make([]byte, 0, 0xEB-0x10+1)
+    for v := 0x10; v <= 0xEB; v++ { ramp = append(ramp, byte(v)) }
+    y, c := make([]byte, len(ramp)), make([]byte, len(ramp))
+    full.luma(y, ramp)
+    full.chroma(c, ramp)
+    if y[0] != 0 || y[len(y)-1] != 255 { t.Errorf("luma 0x10..0xEB expands to %d..%d, want 0..255", y[0], y[len(y)-1]) }
+    for i := 1; i < len(y); i++ {
+        if y[i] <= y[i-1] { t.Fatalf("expanded luma not increasing at %#x: %d after %d", ramp[i], y[i], y[i-1]) }
+    }
+    limited.luma(y, y)
+    limited.chroma(c, c)
+    for i, v := range ramp {
+        if !near(y[i], v, 1) { t.Errorf("luma %#x round trips to %#x", v, y[i]) }
+        if !near(c[i], v, 1) { t.Errorf("chroma %#x round trips to %#x", v, c[i]) }
+    }
+    // The range ends map exactly
+    ends := []byte{0, 128, 255}
+    limited.luma(y[:3], ends)
+    limited.chroma(c[:3], ends)
+    if y[0] != 16 || y[2] != 235 || c[0] != 16 || c[1] != 128 || c[2] != 240 { t.Errorf("full 0, 128, 255 map to limited luma %v, chroma %v", y[:3], c[:3]) }
+    // Full-range samples land inside limited range
+    for v := 0; v < 256; v++ {
+        var l, ch [1]byte
+        limited.luma(l[:], []byte{byte(v)})
+        limited.chroma(ch[:], []byte{byte(v)})
+        if l[0] < 16 || l[0] > 235 || ch[0] < 16 || ch[0] > 240 { t.Fatalf("full %d maps to limited luma %d, chroma %d", v, l[0], ch[0]) }
+    }
+    if c := rangeMapTo(RangeDefault); c != limited { t.Error("default range (YUV_RANGE unset) isn't limited") }
+    t.Setenv("YUV_RANGE", "full")
+    if c := rangeMapTo(RangeDefault); c != full { t.Error("default range with YUV_RANGE=full isn't full") }
+}
@@ -0,0 +1,277 @@
+package stream
+
+import (
+    "log"
+    "sync"
+    "sync/atomic"
+    "time"
+)
+
+// compositeRetry spaces attempts to open a cell whose source failed to.
+const compositeRetry = 5 * time.Second
+
+// CompositeCell is one picture of a composite: the source Open returns,
+// fitted into Rect of the canvas.
+type CompositeCell struct {
+    Rect Region
+    Open func() (Source, error)
+}
+
+// compositeCell is a cell's state. src, opening and retryAt are guarded by
+// mu; the rest belongs to the composite's loop.
+type compositeCell struct {
+    CompositeCell
+    mu      sync.Mutex
+    src     Source
+    opening bool
+    retryAt time.Time
+    logged  bool // the last failure to open was logged
+    // The last picture placed: its first byte and Seq, to skip repeats
+    in   *byte
+    seq  uint64
+    down bool
+    // The cell as rendered, Rect-sized; scratch for packed frames and the
+    // fitted picture
+    y, u, v    []byte
+    sy, su, sv []byte
+    ty, tu, tv []byte
+}
+
+// CompositeSource tiles several sources onto one canvas, e.g. a 2x2
+// monitoring wall served as one stream. Each cell's latest frame is scaled
+// to fit its rectangle, letterboxed on black, at the composite's rate; a
+// cell whose source is down (not open, stalled or without a frame for the
+// stall time) shows "NO SIGNAL" on grey instead, so one missing camera
+// doesn't hold up the rest. A cell that fails to open is tried again every
+// compositeRetry. Only changed cells are scaled again, and a tick where
+// none changed repeats the last frame with its Seq, so the encoder skips
+// it. Cells are placed as their sources deliver them: the canvas is tagged
+// limited range, without converting between sources' color spaces.
+type CompositeSource struct {
+    w, h    int
+    rate    Rate
+    cells   []*compositeCell
+    last    atomic.Value // *ndiFrame
+    lastAt  atomic.Int64
+    sizes   sizeNotifier
+    frames  frameNotifier
+    quit    chan struct{}
+    stopped atomic.Bool
+}
+
+// NewCompositeSource starts a w x h (rounded down to even) composite of
+// cells at fps. Cell rectangles are rounded down to even and must lie
+// inside the canvas; each cell's source is opened in the background.
+func NewCompositeSource(w, h int, fps Rate, cells []CompositeCell) *CompositeSource {
+    s := &CompositeSource{w: max(w&^1, 2), h: max(h&^1, 2), rate: fps.Or(IntRate(30)), quit: make(chan struct{})}
+    for _, c := range cells {
+        r := Region{X: c.Rect.X &^ 1, Y: c.Rect.Y &^ 1, W: c.Rect.W &^ 1, H: c.Rect.H &^ 1}.clip(s.w, s.h)
+        if r.W < 2 || r.H < 2 { continue }
+        c.Rect = r
+        s.cells = append(s.cells, &compositeCell{CompositeCell: c})
+    }
+    s.compose(time.Now())
+    registerSource()
+    go s.loop()
+    return s
+}
+
+func (s *CompositeSource) loop() {
+    defer unregisterSource()
+    defer s.sizes.close()
+    defer s.frames.close()
+    tick := time.NewTicker(s.rate.Interval())
+    defer tick.Stop()
+    for {
+        select {
+        case <-s.quit: return
+        case now := <-tick.C:
+            f := s.compose(now)
+            f.At = now
+            s.lastAt.Store(now.UnixNano())
+            s.sizes.publish(f.W, f.H)
+            s.frames.publish(f)
+        }
+    }
+}
+
+// compose brings every cell up to date and returns the canvas: a new frame
+// if a cell changed, else the last one.
+func (s *CompositeSource) compose(now time.Time) Frame {
+    after := SourceStallAfter()
+    if after <= 0 { after = defaultStallAfter }
+    changed := s.latest() == nil
+    for _, c := range s.cells {
+        c.mu.Lock()
+        src := c.src
+        if src == nil && !c.opening && !now.Before(c.retryAt) {
+            c.opening = true
+            go s.open(c)
+        }
+        c.mu.Unlock()
+        if s.refresh(c, src, now, after) { changed = true }
+    }
+    if !changed { return s.latest().Frame }
+    out := newI420Frame(s.w, s.h)
+    fillI420Black(out.Y, out.U, out.V)
+    for _, c := range s.cells {
+        r := c.Rect
+        copyI420Rect(c.y, c.u, c.v, r.W, 0, 0, out.Y, out.U, out.V, s.w, r.X, r.Y, r.W, r.H)
+    }
+    out.Color = ColorSpaceFor(s.w, s.h)
+    out.Color.Range = RangeLimited
+    out.Seq = frameSeq.Add(1)
+    s.last.Store(&ndiFrame{Frame: out})
+    return out
+}
+
+// open opens c's source, off the loop: receivers can take a while.
+func (s *CompositeSource) open(c *compositeCell) {
+    src, err := c.Open()
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    c.opening = false
+    if err != nil {
+        c.retryAt = time.Now().Add(compositeRetry)
+        if !c.logged {
+            c.logged = true
+            log.Printf("Composite cell %s: %v (retrying every %s)", c.Rect, err, compositeRetry)
+        }
+        return
+    }
+    c.logged = false
+    if s.stopped.Load() {
+        src.Stop()
+        return
+    }
+    c.src = src
+}
+
+// refresh renders c from src's latest frame, or the placeholder when src
+// is down. It reports whether the cell changed.
+func (s *CompositeSource) refresh(c *compositeCell, src Source, now time.Time, after time.Duration) bool {
+    down := src == nil || Stalled(src)
+    if !down {
+        if at, ok := LastFrameAt(src); ok && (at.IsZero() || now.Sub(at) >= after) { down = true }
+    }
+    var f Frame
+    syn := syntheticOf(src)
+    if !down {
+        var ok bool
+        if f, ok = pullFrame(src, PixFmtOf(src)); syn != nil {
+            // The pattern doesn't report its size, and moves in one buffer
+            f.W, f.H, f.PixFmt = syn.w, syn.h, "bgra"
+        }
+        if !ok {
+            // Ended: drop it and open it again
+            c.mu.Lock()
+            if c.src == src { c.src, c.retryAt = nil, now.Add(compositeRetry) }
+            c.mu.Unlock()
+            src.Stop()
+            down = true
+        } else if f.W < 2 || f.H < 2 {
+            down = true
+        }
+    }
+    if down {
+        if c.down && c.y != nil { return false }
+        c.y, c.u, c.v = i420Planes(c.y, c.u, c.v, c.Rect.W, c.Rect.H)
+        for i := range c.y { c.y[i] = 48 }
+        for i := range c.u { c.u[i], c.v[i] = 128, 128 }
+        drawBanner(Frame{Y: c.y, U: c.u, V: c.v, W: c.Rect.W, H: c.Rect.H}, "NO SIGNAL")
+        c.down, c.in, c.seq = true, nil, 0
+        return true
+    }
+    in := firstByte(f)
+    if syn != nil { in = nil }
+    if !c.down && in != nil && ((f.Seq != 0 && f.Seq == c.seq) || (f.Seq == 0 && in == c.in)) { return false }
+    if !s.place(c, f) { return false }
+    c.down, c.in, c.seq = false, in, f.Seq
+    return true
+}
+
+// place scales f to fit c's rectangle, letterboxed on black.
+func (s *CompositeSource) place(c *compositeCell, f Frame) bool {
+    var y, u, v []byte
+    sw, sh := f.W&^1, f.H&^1
+    if f.PixFmt == "i420" {
+        // Planes are read in place; an odd width has no even stride
+        if f.W != sw { return false }
+        y, u, v = f.Y, f.U, f.V
+    } else {
+        var ok bool
+        if c.sy, c.su, c.sv, _, ok = packedToI420(f.Data, f.W, f.H, f.PixFmt, c.sy, c.su, c.sv); !ok { return false }
+        y, u, v = c.sy, c.su, c.sv
+    }
+    cw, ch := c.Rect.W, c.Rect.H
+    c.y, c.u, c.v = i420Planes(c.y, c.u, c.v, cw, ch)
+    fw, fh := FitRect(sw, sh, cw, ch)
+    if fw != cw || fh != ch { fillI420Black(c.y, c.u, c.v) }
+    if fw != sw || fh != sh {
+        c.ty, c.tu, c.tv = i420Planes(c.ty, c.tu, c.tv, fw, fh)
+        I420Scale(y, u, v, sw, sh, c.ty, c.tu, c.tv, fw, fh)
+        y, u, v = c.ty, c.tu, c.tv
+    }
+    copyI420Rect(y, u, v, fw, 0, 0, c.y, c.u, c.v, cw, ((cw-fw)/2)&^1, ((ch-fh)/2)&^1, fw, fh)
+    return true
+}
+
+// syntheticOf is the synthetic pattern behind src's handles, if that is
+// what it is.
+func syntheticOf(src Source) *synthetic {
+    if sh, ok := src.(*SharedSource); ok { src = sh.Inner() }
+    syn, _ := src.(*synthetic)
+    return syn
+}
+
+func (s *CompositeSource) latest() *ndiFrame {
+    f, _ := s.last.Load().(*ndiFrame)
+    return f
+}
+
+// Next returns the canvas as BGRA.
+func (s *CompositeSource) Next() ([]byte, bool) { return s.latest().pixels(), !s.stopped.Load() }
+
+// NextI420 returns the canvas's planes.
+func (s *CompositeSource) NextI420() (Frame, bool) { return s.latest().Frame, !s.stopped.Load() }
+
+// Last returns the canvas as BGRA, with its size.
+func (s *CompositeSource) Last() ([]byte, int, int, bool) {
+    f := s.latest()
+    return f.pixels(), f.W, f.H, true
+}
+
+// PixFmt is "bgra", the format of Next and Last.
+func (s *CompositeSource) PixFmt() string { return "bgra" }
+
+// SizeChanges announces the canvas size with its first frame.
+func (s *CompositeSource) SizeChanges() <-chan FrameSize { return s.sizes.subscribe() }
+
+// Frames delivers the canvas at the composite's rate; see NDISource.Frames.
+func (s *CompositeSource) Frames() <-chan Frame { return s.frames.subscribe() }
+
+// StopFrames ends a Frames subscription and closes its channel.
+func (s *CompositeSource) StopFrames(ch <-chan Frame) { s.frames.unsubscribe(ch) }
+
+// FrameRate is the rate the canvas is served at.
+func (s *CompositeSource) FrameRate() (num, den int) { return s.rate.Num, s.rate.Den }
+
+// LastFrameAt reports when the canvas last went out; the composite runs
+// whether or not its cells have frames.
+func (s *CompositeSource) LastFrameAt() (time.Time, bool) {
+    if n := s.lastAt.Load(); n != 0 { return time.Unix(0, n), true }
+    return time.Time{}, true
+}
+
+// Stop ends the composite and closes every cell's source.
+func (s *CompositeSource) Stop() {
+    if !s.stopped.CompareAndSwap(false, true) { return }
+    close(s.quit)
+    for _, c := range s.cells {
+        c.mu.Lock()
+        src := c.src
+        c.src = nil
+        c.mu.Unlock()
+        if src != nil { src.Stop() }
+    }
+}
@@ -0,0 +1,405 @@
+package stream
+
+import (
+    "errors"
+    "fmt"
+    "sync/atomic"
+    "time"
+
+    "whep/internal/logging"
+)
+
+// Encoder is a video encoder a pipeline drives: I420 planes in, encoded
+// access units out. VP8Encoder, VP9Encoder, AV1Encoder and QSVEncoder
+// satisfy it. A pipeline also uses these when the encoder has them:
+// ForceKeyframe, SetBitrate(kbps) error, Reconfigure(w, h, kbps) error
+// (a new size on the live encoder), Flush, EncodeNV12, which is fed
+// straight from the packed source instead of via I420, and lastPackets,
+// naming the input frame each packet holds when output trails input.
+type Encoder interface {
+    EncodeI420(y, u, v []byte) ([][]byte, bool, error)
+    Close()
+}
+
+// Optional Encoder capabilities
+type (
+    keyframer     interface{ ForceKeyframe() }
+    bitrateSetter interface{ SetBitrate(kbps int) error }
+    flusher       interface{ Flush() ([][]byte, error) }
+    resultReporter interface{ lastResult() encodeResult }
+    packetReporter interface{ lastPackets() []packetInfo }
+    reconfigurer  interface{ Reconfigure(w, h, kbps int) error }
+    nv12Encoder   interface{ EncodeNV12(y, uv []byte) ([][]byte, bool, error) }
+)
+
+// openEncoder creates a pipeline's encoder once its size is settled.
+type openEncoder func(cfg PipelineConfig, bitrateKbps int) (Encoder, error)
+
+// pipeline is the encoder loop behind every video codec's PipelineXxx.
+type pipeline struct {
+    codec   string
+    cfg     PipelineConfig
+    enc     Encoder
+    quit    chan struct{}
+    stopped int32 // 0 active, 1 stopped
+    flush   atomic.Bool // set by Finish: write the tail out on the way down
+    kf      keyframeRequest
+    br      bitrateRequest
+    resize  chan resizeRequest
+    exited  chan struct{} // closed when loop returns
+}
+
+// resizeRequest asks the encode loop to reconfigure its encoder to w x h.
+type resizeRequest struct {
+    w, h int
+    done chan error
+}
+
+// startVideoPipeline fills in cfg's defaults (30 fps, 1280x720, a synthetic
+// source), settles the encode size, opens the encoder and starts the loop.
+// With sizeFromSource, a source that reports its frame size within a
+// second overrides cfg's size. The size is then rounded down to even for
+// 4:2:0.
+func startVideoPipeline(codec string, cfg PipelineConfig, sizeFromSource bool, open openEncoder) (*pipeline, error) {
+    if !cfg.FPS.Valid() { cfg.FPS = IntRate(30) }
+    if cfg.Width <= 0 { cfg.Width = 1280 }
+    if cfg.Height <= 0 { cfg.Height = 720 }
+    if cfg.Source == nil {
+        cfg.Source = NewSynthetic(cfg.Width, cfg.Height, cfg.FPS.Round(), 1)
+    }
+    if s, ok := cfg.Source.(sourceWithLast); ok && sizeFromSource {
+        deadline := time.Now().Add(1 * time.Second)
+        for time.Now().Before(deadline) {
+            if _, w, h, ok2 := s.Last(); ok2 && w > 0 && h > 0 {
+                cfg.Width, cfg.Height = w, h
+                break
+            }
+            time.Sleep(50 * time.Millisecond)
+        }
+    }
+    cfg.Width, cfg.Height = max(cfg.Width&^1, 2), max(cfg.Height&^1, 2)
+    bk := cfg.BitrateKbps
+    if bk <= 0 { bk = 6000 }
+    enc, err := open(cfg, bk)
+    if err != nil { return nil, err }
+    p := &pipeline{codec: codec, cfg: cfg, enc: enc, quit: make(chan struct{}), resize: make(chan resizeRequest), exited: make(chan struct{})}
+    p.br.set(bk)
+    // Register pipeline as active
+    registerPipeline(codec)
+    go p.loop()
+    return p, nil
+}
+
+func (p *pipeline) loop() {
+    defer close(p.exited)
+    // Track active encoder lifecycle
+    defer unregisterPipeline(p.codec)
+    // Runs after encodeLoop has flushed the encoder
+    defer p.enc.Close()
+    encodeLoop(p.codec, p.cfg, p.quit, &p.flush, p.enc, &p.kf, &p.br, p.resize)
+}
+
+// ForceKeyframe asks the encoder to make the next frame a keyframe, e.g. on
+// PLI/FIR from a viewer. Safe to call from any goroutine.
+func (p *pipeline) ForceKeyframe() {
+    if p == nil { return }
+    p.kf.request()
+}
+
+// SetBitrate retargets the running encoder (kbps), e.g. from congestion
+// control. Safe to call from any goroutine; encoders that can't change
+// bitrate in place keep their current target.
+func (p *pipeline) SetBitrate(kbps int) {
+    if p == nil { return }
+    p.br.request(kbps)
+}
+
+// Bitrate returns the target currently applied to the encoder, in kbps.
+func (p *pipeline) Bitrate() int {
+    if p == nil { return 0 }
+    return p.br.current()
+}
+
+// Resize switches the running encoder to a new frame size, e.g. when the
+// source changes resolution, without a new encoder or a gap in the stream;
+// the first frame at the new size is a keyframe. It fails when the encoder
+// can't be reconfigured (no Reconfigure, or larger than its initial size),
+// and the caller should then start a new pipeline. It waits up to a frame
+// for the encode loop to apply it.
+func (p *pipeline) Resize(w, h int) error {
+    if p == nil { return errors.New("no pipeline") }
+    if _, ok := p.enc.(reconfigurer); !ok {
+        return fmt.Errorf("%s encoder can't be resized in place", p.codec)
+    }
+    req := resizeRequest{w: w &^ 1, h: h &^ 1, done: make(chan error, 1)}
+    select {
+    case p.resize <- req:
+    case <-p.exited:
+        return errors.New("pipeline stopped")
+    }
+    return <-req.done
+}
+
+// Stop ends the loop without writing anything more to the track: samples
+// still queued and the encoder's delayed frames are dropped, since a
+// pipeline restarted or resized onto the same track may already be writing
+// to it, at another size. It doesn't wait for the loop to exit.
+func (p *pipeline) Stop() {
+    if p == nil { return }
+    p.stop(false)
+}
+
+// Finish ends the stream: samples still queued and the encoder's delayed
+// frames are written to the track, e.g. the tail of a recording, before the
+// encoder closes. It waits up to timeout for that and reports whether the
+// loop exited. The caller must not hold a lock the encode loop may wait
+// for: a SwitchSource runs its cut callback on the loop.
+func (p *pipeline) Finish(timeout time.Duration) bool {
+    if p == nil { return true }
+    p.stop(true)
+    t := time.NewTimer(timeout)
+    defer t.Stop()
+    select {
+    case <-p.exited:
+        return true
+    case <-t.C:
+        return false
+    }
+}
+
+func (p *pipeline) stop(flush bool) {
+    if atomic.CompareAndSwapInt32(&p.stopped, 0, 1) {
+        p.flush.Store(flush)
+        close(p.quit)
+    }
+}
+
+// encodeFunc converts one source frame (PixFmt "bgra", "uyvy422" or
+// "i420", at the pipeline's size) into the encoder's input format and
+// encodes it.
+type encodeFunc func(f Frame) (packets [][]byte, key bool, err error)
+
+// frameBytes is the size of a packed source frame.
+func frameBytes(pixfmt string, w, h int) int {
+    if pixfmt == "uyvy422" { return w * h * 2 }
+    return w * h * 4
+}
+
+// frameFits reports whether f holds a whole w x h frame.
+func frameFits(f Frame, w, h int) bool {
+    if f.PixFmt == "i420" {
+        c := (w / 2) * (h / 2)
+        return len(f.Y) >= w*h && len(f.U) >= c && len(f.V) >= c
+    }
+    return len(f.Data) >= frameBytes(f.PixFmt, w, h)
+}
+
+// pullFrame takes the source's latest frame for a tick: its I420 planes
+// when it keeps them, else the packed buffer. Sources with Last give the
+// buffer with its size from one call, where Next may already be a frame
+// behind.
+func pullFrame(src Source, pixfmt string) (Frame, bool) {
+    if s, ok := src.(sourceWithI420); ok {
+        if f, ok := s.NextI420(); ok { return f, true }
+    }
+    data, ok := src.Next()
+    if !ok { return Frame{}, false }
+    f := Frame{Data: data, PixFmt: pixfmt}
+    if sized, ok := src.(sourceWithLast); ok {
+        if b, w, h, ok := sized.Last(); ok { f.Data, f.W, f.H = b, w, h }
+    }
+    return f, true
+}
+
+// encodeLoop is the loop every video pipeline runs until quit closes or the
+// source ends: take a frame, drop it if it isn't the encoder's size, apply
+// pending resize, bitrate and keyframe requests, encode, and queue the
+// packets on the async writer so a slow viewer never blocks the loop.
+// Sources that push frames (Frames) are encoded as each frame arrives, once
+// per frame and stamped with its capture interval; others are pulled with
+// Next on a cfg.FPS ticker. Either way a frame repeating the picture last
+// encoded is left out unless a keyframe or the keepalive is due
+// (SkipUnchanged), and the next sample's duration covers it. A keyframe is
+// forced when the source comes back from a stall (Stalled), and when the
+// writer drops a video sample.
+// Every frame is counted under codec and cfg.MetricsLabel. If flush is set when quit closes, the writer is drained
+// and the encoder flushed to the track before it returns; otherwise
+// whatever is left is dropped. Closing the encoder is left to the caller.
+func encodeLoop(codec string, cfg PipelineConfig, quit <-chan struct{}, flush *atomic.Bool, enc Encoder, kf *keyframeRequest, br *bitrateRequest, resize <-chan resizeRequest) {
+    mc := countersFor(codec, cfg.MetricsLabel)
+    label := codec + "(" + cfg.MetricsLabel + ")"
+    dstW, dstH := cfg.Width, cfg.Height
+    pixfmt := PixFmtOf(cfg.Source)
+    dur := cfg.FPS.Interval()
+    kfr, _ := enc.(keyframer)
+    brs, _ := enc.(bitrateSetter)
+    rr, _ := enc.(resultReporter)
+    rc, _ := enc.(reconfigurer)
+    pr, _ := enc.(packetReporter)
+    encode := encodeFuncFor(enc, dstW, dstH)
+
+    frames, stopFrames, push := Frames(cfg.Source)
+    var ticker *time.Ticker
+    var tick <-chan time.Time
+    var resume *ResumeDetector
+    if push {
+        defer stopFrames()
+        // A source pausing isn't a host resume; only clock jumps count
+        resume = NewResumeDetector(0, nil)
+    } else {
+        ticker = time.NewTicker(dur)
+        defer ticker.Stop()
+        tick = ticker.C
+        resume = NewResumeDetector(dur, nil)
+    }
+    stamps := newSampleClock(nil)
+    var inputs inputTimings
+    // Once stopped without flush, nothing more goes to the track
+    discard := func() bool {
+        select {
+        case <-quit: return !flush.Load()
+        default: return false
+        }
+    }
+    enqueue, stopWriter := newAsyncSampleWriter(cfg.Track, cfg.Queue, discard, kf.request)
+    // Drain the queue, then the encoder's delayed frames
+    defer func() {
+        stopWriter()
+        if f, ok := enc.(flusher); ok && !discard() { flushEncoder(f, cfg.Track, dur, stamps, &inputs, mc) }
+    }()
+    var prevAt time.Time
+    skip := unchangedSkipper{on: SkipUnchanged()}
+    stalled := false
+    for {
+        var frame Frame
+        var ts time.Time
+        sampleDur := dur
+        select {
+        case <-quit:
+            return
+        case req := <-resize:
+            // Answered between frames, so the encoder is never in use
+            var err error
+            if req.w != dstW || req.h != dstH { err = rc.Reconfigure(req.w, req.h, br.current()) }
+            if err == nil {
+                dstW, dstH = req.w, req.h
+                encode = encodeFuncFor(enc, dstW, dstH)
+            }
+            req.done <- err
+            continue
+        case <-tick:
+            // A tick and quit both ready: stop rather than pull another
+            // frame from a source a new pipeline may be reading
+            if stopping(quit) { return }
+            resyncAfterResume(resume, ticker, kf, label)
+            var ok bool
+            if frame, ok = pullFrame(cfg.Source, pixfmt); !ok { return }
+            // A pulled frame may be the same capture again; stamp the tick.
+            // Ticks left out as unchanged are held by this sample
+            ts = stamps.now()
+            sampleDur = dur * time.Duration(skip.held+1)
+        case f, ok := <-frames:
+            if !ok || stopping(quit) { return }
+            resyncAfterResume(resume, ticker, kf, label)
+            frame = f
+            // A gap made by leaving out unchanged frames is time the
+            // picture was held, not a stall
+            maxGap := time.Second
+            if skip.held > 0 { maxGap += unchangedKeepalive }
+            if d := f.At.Sub(prevAt); !prevAt.IsZero() && d > 0 && d < maxGap { sampleDur = d }
+            ts = stamps.at(f.At)
+        }
+        mc.incFramesIn()
+        // Enforce pre-scaled source frames. If mismatch, drop until source adjusts.
+        if frame.W > 0 && frame.H > 0 && (frame.W != dstW || frame.H != dstH) {
+            mc.incFramesDroppedInput()
+            if logging.DebugEnabled() { logging.Debugf("%s: dropped %dx%d frame, encoder is %dx%d", label, frame.W, frame.H, dstW, dstH) }
+            continue
+        }
+        if !frameFits(frame, dstW, dstH) {
+            mc.incFramesDroppedInput()
+            if logging.DebugEnabled() { logging.Debugf("%s: dropped short %s frame", label, frame.PixFmt) }
+            continue
+        }
+        // The first picture after a stall starts afresh, whatever the
+        // slate left in the reference frames
+        if st := Stalled(cfg.Source); st != stalled {
+            if stalled = st; !st { kf.request() }
+        }
+        now := time.Now()
+        if skip.skip(frame, now, kf) { mc.incFramesSkipped(); continue }
+        skip.encoded(frame, now)
+        if push { prevAt = frame.At }
+        if kbps, ok := br.take(); ok {
+            if brs == nil || brs.SetBitrate(kbps) != nil { br.reject() } else { br.set(kbps) }
+        }
+        if kf.take() && kfr != nil { kfr.ForceKeyframe() }
+        inputs.add(ts, sampleDur)
+        packets, key, err := encode(frame)
+        if err != nil { mc.incFramesError(); return }
+        if rr != nil { mc.countEncode(rr.lastResult()) } else { mc.countEncode(encodeOutput) }
+        var infos []packetInfo
+        if pr != nil { infos = pr.lastPackets() }
+        accepted := 0
+        for i, au := range packets {
+            // Packets of frames sent calls ago keep those frames' stamps
+            pts, pdur, pkey := ts, sampleDur, key
+            if i < len(infos) { pts, pdur, pkey = inputs.stamp(infos[i], ts, sampleDur) }
+            if enqueue(videoSample(au, pdur, pts, pkey)) {
+                accepted++
+            }
+        }
+        mc.incSamplesSent(accepted)
+    }
+}
+
+// stopping reports whether quit is closed.
+func stopping(quit <-chan struct{}) bool {
+    select {
+    case <-quit: return true
+    default: return false
+    }
+}
+
+// encodeFuncFor converts into planes allocated once for a w x h pipeline:
+// NV12 for encoders that take it, else I420. I420 frames go to an I420
+// encoder as they are.
+func encodeFuncFor(enc Encoder, w, h int) encodeFunc {
+    // Everything the encoder gets is in YUV_RANGE; the sender's own YUV is
+    // limited, and so are I420 frames made from it
+    r := YUVRange()
+    remap := rangeMapTo(r)
+    if nv, ok := enc.(nv12Encoder); ok {
+        y := make([]byte, w*h)
+        uv := make([]byte, w*(h/2))
+        return func(f Frame) ([][]byte, bool, error) {
+            switch f.PixFmt {
+            case "i420":
+                I420toNV12(f.Y, f.U, f.V, w, h, y, uv)
+                if f.Color.Range.resolve() != r { remap.luma(y, y); remap.chroma(uv, uv) }
+            case "uyvy422":
+                UYVYtoNV12(f.Data, w, h, y, uv)
+                if r != RangeLimited { remap.luma(y, y); remap.chroma(uv, uv) }
+            default:
+                BGRAtoNV12(f.Data, w, h, y, uv)
+            }
+            return nv.EncodeNV12(y, uv)
+        }
+    }
+    y := make([]byte, w*h)
+    u := make([]byte, (w/2)*(h/2))
+    v := make([]byte, (w/2)*(h/2))
+    return func(f Frame) ([][]byte, bool, error) {
+        switch f.PixFmt {
+        case "i420":
+            if f.Color.Range.resolve() == r { return enc.EncodeI420(f.Y, f.U, f.V) }
+            remap.luma(y, f.Y[:w*h]); remap.chroma(u, f.U[:len(u)]); remap.chroma(v, f.V[:len(v)])
+        case "uyvy422":
+            UYVYtoI420(f.Data, w, h, y, u, v)
+            if r != RangeLimited { remap.luma(y, y); remap.chroma(u, u); remap.chroma(v, v) }
+        default:
+            BGRAtoI420Space(f.Data, w, h, y, u, v, ColorSpace{Range: r})
+        }
+        return enc.EncodeI420(y, u, v)
+    }
+}
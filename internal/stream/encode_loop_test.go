@@ -0,0 +1,281 @@
+package stream
+
+import (
+    "errors"
+    "path/filepath"
+    "slices"
+    "sync"
+    "sync/atomic"
+    "testing"
+    "time"
+
+    "github.com/pion/webrtc/v3/pkg/media"
+)
+
+// lagEncoder holds back its first lag frames, like VP9/AV1 with
+// lag-in-frames, and gives them up on Flush. Packets are numbered from 1
+// and the first is a keyframe.
+type lagEncoder struct {
+    lag     int
+    in, out int
+}
+
+func (e *lagEncoder) EncodeI420(y, u, v []byte) ([][]byte, bool, error) {
+    e.in++
+    if e.in <= e.lag { return nil, false, nil }
+    e.out++
+    return [][]byte{{byte(e.out)}}, e.out == 1, nil
+}
+
+func (e *lagEncoder) Flush() ([][]byte, error) {
+    var out [][]byte
+    for e.out < e.in {
+        e.out++
+        out = append(out, []byte{byte(e.out)})
+    }
+    return out, nil
+}
+
+func (e *lagEncoder) Close() {}
+
+// countTrack counts the samples written to it.
+type countTrack struct {
+    mu sync.Mutex
+    n  int
+}
+
+func (c *countTrack) WriteSample(media.Sample) error {
+    c.mu.Lock()
+    c.n++
+    c.mu.Unlock()
+    return nil
+}
+
+func (c *countTrack) count() int {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    return c.n
+}
+
+// durTrack records the duration of each sample written to it.
+type durTrack struct {
+    mu   sync.Mutex
+    durs []time.Duration
+}
+
+func (d *durTrack) WriteSample(s media.Sample) error {
+    d.mu.Lock()
+    d.durs = append(d.durs, s.Duration)
+    d.mu.Unlock()
+    return nil
+}
+
+// scriptSource pushes its frames, then ends as a stopped source does.
+type scriptSource struct{ frames []Frame }
+
+func (s *scriptSource) Next() ([]byte, bool) { return nil, false }
+func (s *scriptSource) Stop()                {}
+func (s *scriptSource) Frames() <-chan Frame {
+    ch := make(chan Frame, len(s.frames))
+    for _, f := range s.frames { ch <- f }
+    close(ch)
+    return ch
+}
+func (s *scriptSource) StopFrames(<-chan Frame) {}
+
+// scriptEncoder answers each encode call as its script says: 'o' one
+// packet, 'b' none, held back until Flush, 'd' none, dropped by rate
+// control, 'e' an error.
+type scriptEncoder struct {
+    script string
+    calls  int
+    held   int
+    last   encodeResult
+}
+
+func (e *scriptEncoder) EncodeI420(y, u, v []byte) ([][]byte, bool, error) {
+    c := e.script[e.calls]
+    e.calls++
+    switch c {
+    case 'b':
+        e.held++
+        e.last = encodeBuffered
+    case 'd':
+        e.last = encodeDroppedRC
+    case 'e':
+        return nil, false, errors.New("encode failed")
+    default:
+        e.last = encodeOutput
+        return [][]byte{{c}}, e.calls == 1, nil
+    }
+    return nil, false, nil
+}
+
+func (e *scriptEncoder) lastResult() encodeResult { return e.last }
+
+func (e *scriptEncoder) Flush() ([][]byte, error) {
+    out := make([][]byte, e.held)
+    for i := range out { out[i] = []byte{'f'} }
+    e.held = 0
+    return out, nil
+}
+
+func (e *scriptEncoder) Close() {}
+
+// runScript runs the encode loop over frames with enc until the source ends.
+func runScript(t *testing.T, codec, label string, frames []Frame, enc Encoder) *durTrack {
+    t.Helper()
+    track := &durTrack{}
+    cfg := PipelineConfig{Width: 16, Height: 16, FPS: IntRate(25), Source: &scriptSource{frames}, Track: track, MetricsLabel: label, Queue: QueueConfig{Depth: 64}}
+    var flush atomic.Bool
+    var kf keyframeRequest
+    var br bitrateRequest
+    done := make(chan struct{})
+    go func() {
+        defer close(done)
+        encodeLoop(codec, cfg, make(chan struct{}), &flush, enc, &kf, &br, nil)
+    }()
+    select {
+    case <-done:
+    case <-time.After(5 * time.Second):
+        t.Fatal("encode loop didn't end with its source")
+    }
+    return track
+}
+
+// scriptFrames is n 16x16 BGRA frames captured 40ms apart, each a new
+// picture. edit may change frame i.
+func scriptFrames(n int, edit func(i int, f *Frame)) []Frame {
+    t0 := time.Now()
+    out := make([]Frame, n)
+    for i := range out {
+        out[i] = Frame{Data: make([]byte, 16*16*4), W: 16, H: 16, PixFmt: "bgra", At: t0.Add(time.Duration(i) * 40 * time.Millisecond), Seq: uint64(i + 1)}
+        if edit != nil { edit(i, &out[i]) }
+    }
+    return out
+}
+
+// labelCounts reads the counters of codec and label; they live as long as
+// the process, so tests compare before and after.
+func labelCounts(codec, label string) LabeledCounter {
+    c := countersFor(codec, label)
+    return LabeledCounter{FramesIn: c.in.Load(), FramesEncoded: c.encoded.Load(), FramesBuffered: c.buffered.Load(), FramesDroppedRC: c.droppedRC.Load(), FramesDroppedInput: c.droppedIn.Load(), FramesError: c.errored.Load(), FramesSkippedUnchanged: c.skipped.Load(), SamplesSent: c.sent.Load()}
+}
+
+func (c LabeledCounter) minus(b LabeledCounter) LabeledCounter {
+    return LabeledCounter{FramesIn: c.FramesIn - b.FramesIn, FramesEncoded: c.FramesEncoded - b.FramesEncoded, FramesBuffered: c.FramesBuffered - b.FramesBuffered, FramesDroppedRC: c.FramesDroppedRC - b.FramesDroppedRC, FramesDroppedInput: c.FramesDroppedInput - b.FramesDroppedInput, FramesError: c.FramesError - b.FramesError, FramesSkippedUnchanged: c.FramesSkippedUnchanged - b.FramesSkippedUnchanged, SamplesSent: c.SamplesSent - b.SamplesSent}
+}
+
+// TestEncodeLoopCadence runs the shared encode loop over known frame and
+// encoder sequences, checking each sample's duration and the counters.
+func TestEncodeLoopCadence(t *testing.T) {
+    const ms = time.Millisecond
+    tests := []struct {
+        name   string
+        skip   bool // VIDEO_SKIP_UNCHANGED
+        frames []Frame
+        script string
+        durs   []time.Duration
+        want   LabeledCounter
+    }{
+        {"every frame", false, scriptFrames(5, nil), "ooooo",
+            []time.Duration{40 * ms, 40 * ms, 40 * ms, 40 * ms, 40 * ms},
+            LabeledCounter{FramesIn: 5, FramesEncoded: 5, SamplesSent: 5}},
+        // The next frame encoded covers the time of those dropped
+        {"wrong size", false, scriptFrames(5, func(i int, f *Frame) {
+            if i == 1 || i == 2 { f.W, f.H, f.Data = 32, 32, make([]byte, 32*32*4) }
+        }), "ooo",
+            []time.Duration{40 * ms, 120 * ms, 40 * ms},
+            LabeledCounter{FramesIn: 5, FramesEncoded: 3, FramesDroppedInput: 2, SamplesSent: 3}},
+        {"short frame", false, scriptFrames(4, func(i int, f *Frame) {
+            if i == 2 { f.W, f.H, f.Data = 0, 0, f.Data[:100] }
+        }), "ooo",
+            []time.Duration{40 * ms, 40 * ms, 80 * ms},
+            LabeledCounter{FramesIn: 4, FramesEncoded: 3, FramesDroppedInput: 1, SamplesSent: 3}},
+        // A frame rate control drops leaves a gap in the samples
+        {"rate control drop", false, scriptFrames(5, nil), "oodoo",
+            []time.Duration{40 * ms, 40 * ms, 40 * ms, 40 * ms},
+            LabeledCounter{FramesIn: 5, FramesEncoded: 4, FramesDroppedRC: 1, SamplesSent: 4}},
+        // Held-back frames come out of the flush when the source ends
+        {"buffered then flushed", false, scriptFrames(5, nil), "bbooo",
+            []time.Duration{40 * ms, 40 * ms, 40 * ms, 40 * ms, 40 * ms},
+            LabeledCounter{FramesIn: 5, FramesEncoded: 5, FramesBuffered: 2, SamplesSent: 5}},
+        {"error stops", false, scriptFrames(5, nil), "ooe",
+            []time.Duration{40 * ms, 40 * ms},
+            LabeledCounter{FramesIn: 3, FramesEncoded: 2, FramesError: 1, SamplesSent: 2}},
+        // Repeats are left out and the next picture's sample holds them
+        {"unchanged skipped", true, scriptFrames(6, func(i int, f *Frame) { f.Seq = []uint64{1, 1, 1, 2, 2, 3}[i] }), "ooo",
+            []time.Duration{40 * ms, 120 * ms, 80 * ms},
+            LabeledCounter{FramesIn: 6, FramesEncoded: 3, FramesSkippedUnchanged: 3, SamplesSent: 3}},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            if !tt.skip { t.Setenv("VIDEO_SKIP_UNCHANGED", "0") }
+            enc := &scriptEncoder{script: tt.script}
+            before := labelCounts("test", t.Name())
+            track := runScript(t, "test", t.Name(), tt.frames, enc)
+            if enc.calls != len(tt.script) { t.Errorf("%d encode calls, want %d", enc.calls, len(tt.script)) }
+            if !slices.Equal(track.durs, tt.durs) { t.Errorf("sample durations %v, want %v", track.durs, tt.durs) }
+            if got := labelCounts("test", t.Name()).minus(before); got != tt.want { t.Errorf("counters %+v, want %+v", got, tt.want) }
+        })
+    }
+}
+
+// TestEncodeLoopPulledCadence checks a pulled source gets a sample per
+// tick, each one frame interval long.
+func TestEncodeLoopPulledCadence(t *testing.T) {
+    d := &durTrack{}
+    p := startLagPipeline(t, &lagEncoder{}, d)
+    time.Sleep(50 * time.Millisecond)
+    p.Stop()
+    <-p.exited
+    d.mu.Lock()
+    defer d.mu.Unlock()
+    if len(d.durs) < 3 { t.Fatalf("only %d samples in 50ms at 200 fps", len(d.durs)) }
+    for i, dur := range d.durs {
+        if dur != 5*time.Millisecond { t.Fatalf("sample %d lasts %v, want the 5ms interval", i, dur) }
+    }
+}
+
+func startLagPipeline(t *testing.T, enc *lagEncoder, track interface{}) *pipeline {
+    t.Helper()
+    t.Setenv("VIDEO_SKIP_UNCHANGED", "0")
+    cfg := PipelineConfig{Width: 16, Height: 16, FPS: IntRate(200), Source: NewSynthetic(16, 16, 200, 1), Track: track, MetricsLabel: t.Name()}
+    p, err := startVideoPipeline("test", cfg, false, func(PipelineConfig, int) (Encoder, error) { return enc, nil })
+    if err != nil { t.Fatal(err) }
+    return p
+}
+
+func TestFinishRecordsEveryEncodedFrame(t *testing.T) {
+    rec, err := NewIVFRecorder(filepath.Join(t.TempDir(), "out.ivf"), "vp8", IVFOptions{Width: 16, Height: 16})
+    if err != nil { t.Fatal(err) }
+    bc := NewSampleBroadcaster()
+    if _, err := bc.Add(rec, RecorderSinkOptions(func(err error) { t.Errorf("recorder sink: %v", err) })...); err != nil { t.Fatal(err) }
+    enc := &lagEncoder{lag: 3}
+    p := startLagPipeline(t, enc, bc)
+    time.Sleep(100 * time.Millisecond)
+    if !p.Finish(time.Second) { t.Fatal("pipeline didn't exit") }
+    if enc.in <= enc.lag { t.Fatalf("only %d frames encoded", enc.in) }
+    // The sink writes on its own goroutine
+    deadline := time.Now().Add(time.Second)
+    for rec.Stats().Frames < uint64(enc.in) && time.Now().Before(deadline) { time.Sleep(5 * time.Millisecond) }
+    bc.Close()
+    if err := rec.Close(); err != nil { t.Fatal(err) }
+    if got := rec.Stats().Frames; got != uint64(enc.in) {
+        t.Fatalf("recorded %d frames, encoded %d", got, enc.in)
+    }
+}
+
+func TestStopWritesNothingMore(t *testing.T) {
+    track := &countTrack{}
+    enc := &lagEncoder{lag: 3}
+    p := startLagPipeline(t, enc, track)
+    time.Sleep(50 * time.Millisecond)
+    p.Stop()
+    <-p.exited
+    n := track.count()
+    // The held-back frames belong to a stream a new pipeline takes over
+    if n > enc.in-enc.lag { t.Fatalf("%d samples written for %d frames with %d held back", n, enc.in, enc.lag) }
+    time.Sleep(20 * time.Millisecond)
+    if track.count() != n { t.Fatal("samples written after the loop exited") }
+}
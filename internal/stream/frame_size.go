@@ -0,0 +1,63 @@
+package stream
+
+import "sync"
+
+// FrameSize is the size of the frames a source delivers.
+type FrameSize struct{ W, H int }
+
+// optional capability: sources that announce frame size changes
+type sourceWithSizes interface {
+    SizeChanges() <-chan FrameSize
+}
+
+// SizeChanges subscribes to src's frame size changes. The channel holds
+// only the latest size, starting with the current one if a frame has
+// arrived, and is closed when src stops. ok is false for sources that
+// don't announce sizes (synthetic ones never change).
+func SizeChanges(src Source) (ch <-chan FrameSize, ok bool) {
+    if s, ok := src.(sourceWithSizes); ok { return s.SizeChanges(), true }
+    return nil, false
+}
+
+// sizeNotifier fans a source's frame size out to subscribers. A slow
+// subscriber misses intermediate sizes, never the latest.
+type sizeNotifier struct {
+    mu     sync.Mutex
+    cur    FrameSize
+    subs   []chan FrameSize
+    closed bool
+}
+
+func (n *sizeNotifier) subscribe() <-chan FrameSize {
+    n.mu.Lock()
+    defer n.mu.Unlock()
+    ch := make(chan FrameSize, 1)
+    if n.closed { close(ch); return ch }
+    if n.cur.W > 0 && n.cur.H > 0 { ch <- n.cur }
+    n.subs = append(n.subs, ch)
+    return ch
+}
+
+// publish records the size of a new frame and notifies subscribers if it
+// differs from the previous one.
+func (n *sizeNotifier) publish(w, h int) {
+    n.mu.Lock()
+    defer n.mu.Unlock()
+    fs := FrameSize{w, h}
+    if n.closed || fs == n.cur { return }
+    n.cur = fs
+    for _, ch := range n.subs {
+        // Replace an unread size with the newer one
+        select { case <-ch: default: }
+        ch <- fs
+    }
+}
+
+func (n *sizeNotifier) close() {
+    n.mu.Lock()
+    defer n.mu.Unlock()
+    if n.closed { return }
+    n.closed = true
+    for _, ch := range n.subs { close(ch) }
+    n.subs = nil
+}
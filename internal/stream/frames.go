@@ -0,0 +1,134 @@
+package stream
+
+import (
+    "sync"
+    "time"
+)
+
+// Frame is one video frame as a source delivers it.
+type Frame struct {
+    Data   []byte // packed pixels, stride = W * bytes per pixel
+    W, H   int
+    PixFmt string // "bgra", "uyvy422" or "i420"
+    At     time.Time // when it was captured
+    // Seq numbers the picture: a frame repeating the one before it has its
+    // Seq. 0 when the source doesn't tell
+    Seq uint64
+    // With PixFmt "i420" the frame is in these planes instead of Data: a
+    // source that scales keeps its output in I420 for the encoder
+    Y, U, V []byte
+    Color   ColorSpace // the planes'; defaults resolve for W x H
+}
+
+// Packed returns f's pixels packed, converting an I420 frame to BGRA.
+func (f Frame) Packed() ([]byte, string) {
+    if f.PixFmt != "i420" { return f.Data, f.PixFmt }
+    out := make([]byte, f.W*f.H*4)
+    I420ToBGRASpace(f.Y, f.U, f.V, f.W, f.H, out, f.Color)
+    return out, "bgra"
+}
+
+// optional capability: sources that can hand over their latest frame in
+// I420 (a Frame with PixFmt "i420"), sparing the pipeline a conversion from
+// the packed form. ok is false when the frame isn't kept in I420; the
+// pipeline then falls back to Next.
+type sourceWithI420 interface {
+    NextI420() (Frame, bool)
+}
+
+// frameRing is how many undelivered frames a subscriber holds; when it is
+// full the oldest is dropped.
+const frameRing = 3
+
+// optional capability: sources that push each new frame
+type sourceWithFrames interface {
+    Frames() <-chan Frame
+    StopFrames(ch <-chan Frame)
+}
+
+// Frames subscribes to src's frames. Every new frame is delivered once, in
+// order, and the channel is closed when src stops; stop ends the
+// subscription early. ok is false for sources that only serve Next
+// (synthetic ones, which render on demand).
+func Frames(src Source) (ch <-chan Frame, stop func(), ok bool) {
+    s, ok := src.(sourceWithFrames)
+    if !ok || !HasFrames(src) { return nil, nil, false }
+    ch = s.Frames()
+    return ch, func() { s.StopFrames(ch) }, true
+}
+
+// HasFrames reports whether src pushes frames. A switch, aspect, region or
+// shared source does when its current source does; hot swaps must keep that
+// unchanged.
+func HasFrames(src Source) bool {
+    switch s := src.(type) {
+    case *SwitchSource:
+        return HasFrames(s.Current())
+    case *AspectSource:
+        return HasFrames(s.Inner())
+    case *RegionSource:
+        return HasFrames(s.Inner())
+    case *SharedSource:
+        return HasFrames(s.Inner())
+    case sourceWithFrames:
+        return true
+    }
+    return false
+}
+
+// frameNotifier fans a source's frames out to subscribers, each with its
+// own frameRing so a slow one only loses its own oldest frames.
+type frameNotifier struct {
+    mu     sync.Mutex
+    subs   []chan Frame
+    closed bool
+}
+
+func (n *frameNotifier) subscribe() <-chan Frame {
+    n.mu.Lock()
+    defer n.mu.Unlock()
+    ch := make(chan Frame, frameRing)
+    if n.closed { close(ch); return ch }
+    n.subs = append(n.subs, ch)
+    return ch
+}
+
+// unsubscribe closes ch and stops delivering to it.
+func (n *frameNotifier) unsubscribe(ch <-chan Frame) {
+    n.mu.Lock()
+    defer n.mu.Unlock()
+    for i, c := range n.subs {
+        if c == ch {
+            n.subs = append(n.subs[:i], n.subs[i+1:]...)
+            close(c)
+            return
+        }
+    }
+}
+
+func (n *frameNotifier) publish(f Frame) {
+    n.mu.Lock()
+    defer n.mu.Unlock()
+    if n.closed { return }
+    for _, ch := range n.subs {
+        for {
+            select {
+            case ch <- f:
+            default:
+                // Full: drop the oldest and retry
+                select { case <-ch: default: }
+                continue
+            }
+            break
+        }
+    }
+}
+
+func (n *frameNotifier) close() {
+    n.mu.Lock()
+    defer n.mu.Unlock()
+    if n.closed { return }
+    n.closed = true
+    for _, ch := range n.subs { close(ch) }
+    n.subs = nil
+}
@@ -0,0 +1,53 @@
+package stream
+
+import (
+    "strings"
+    "sync/atomic"
+)
+
+// HWAccelStatus reports whether the requested hardware encoder is usable.
+// When it isn't, callers encode in software with the configured codec.
+type HWAccelStatus struct {
+    Requested string `json:"requested"`
+    Active    bool   `json:"active"`
+    Codec     string `json:"codec,omitempty"` // codec produced by the HW encoder when active
+    Error     string `json:"error,omitempty"`
+}
+
+var hwStatus atomic.Value // HWAccelStatus
+
+// ProbeHWAccel checks that the named hardware encoder ("none", "qsv") can be
+// opened and records the result for GetHWAccelStatus.
+func ProbeHWAccel(name string) HWAccelStatus {
+    name = strings.ToLower(strings.TrimSpace(name))
+    st := HWAccelStatus{Requested: name}
+    switch name {
+    case "", "none":
+        st.Requested = "none"
+    case "qsv":
+        if err := probeQSV(); err != nil {
+            st.Error = err.Error()
+        } else {
+            st.Active, st.Codec = true, "h264"
+        }
+    default:
+        st.Error = "unsupported hwaccel: " + name
+    }
+    hwStatus.Store(st)
+    return st
+}
+
+// HWAccelFailed marks the hardware encoder unusable after a runtime open
+// failure, so new pipelines fall back to software.
+func HWAccelFailed(err error) {
+    st := GetHWAccelStatus()
+    st.Active = false
+    if err != nil { st.Error = err.Error() }
+    hwStatus.Store(st)
+}
+
+// GetHWAccelStatus returns the last probe/failure result.
+func GetHWAccelStatus() HWAccelStatus {
+    if v, ok := hwStatus.Load().(HWAccelStatus); ok { return v }
+    return HWAccelStatus{Requested: "none"}
+}
@@ -0,0 +1,39 @@
+//go:build !yuv
+
+package stream
+
+// I420Orient mirrors a w x h I420 frame left to right if mirror is set,
+// then rotates it clockwise by rotate degrees (0, 90, 180 or 270) into the
+// d* planes, h x w for 90 and 270. This is a pure-Go fallback used when
+// libyuv is not enabled.
+func I420Orient(y, u, v []byte, w, h int, dy, du, dv []byte, rotate int, mirror bool) {
+    if w <= 0 || h <= 0 { return }
+    orientPlane(y, w, h, dy, rotate, mirror)
+    orientPlane(u, w/2, h/2, du, rotate, mirror)
+    orientPlane(v, w/2, h/2, dv, rotate, mirror)
+}
+
+// orientPlane turns one plane. Each source row lands on its own output
+// column or row, so bands of rows are turned in parallel.
+func orientPlane(src []byte, w, h int, dst []byte, rotate int, mirror bool) {
+    if w <= 0 || h <= 0 { return }
+    parallelRows(h, 1, func(r0, r1 int) {
+        for sy := r0; sy < r1; sy++ {
+            row := src[sy*w : (sy+1)*w]
+            for x := 0; x < w; x++ {
+                p := row[x]
+                if mirror { p = row[w-1-x] }
+                switch rotate {
+                case 90:
+                    dst[x*h+h-1-sy] = p
+                case 180:
+                    dst[(h-1-sy)*w+w-1-x] = p
+                case 270:
+                    dst[(w-1-x)*h+sy] = p
+                default:
+                    dst[sy*w+x] = p
+                }
+            }
+        }
+    })
+}
@@ -2,28 +2,118 @@
 
 package stream
 
-// I420Scale scales an I420 frame from (sw,sh) to (dw,dh) using a simple nearest-neighbor algorithm.
+// defaultScaleFilter keeps the pure-Go scaler at its cheapest unless asked.
+const defaultScaleFilter = ScaleNone
+
+// I420Scale scales an I420 frame from (sw,sh) to (dw,dh) with the default
+// filter, nearest-neighbor unless YUV_SCALE_FILTER says otherwise.
 // This is a pure-Go fallback used when libyuv is not enabled.
 func I420Scale(ySrc, uSrc, vSrc []byte, sw, sh int, yDst, uDst, vDst []byte, dw, dh int) {
+    I420ScaleFilter(ySrc, uSrc, vSrc, sw, sh, yDst, uDst, vDst, dw, dh, "")
+}
+
+// I420ScaleFilter is I420Scale with filter, a Scale* constant or "" for
+// DefaultScaleFilter.
+func I420ScaleFilter(ySrc, uSrc, vSrc []byte, sw, sh int, yDst, uDst, vDst []byte, dw, dh int, filter string) {
     if sw <= 0 || sh <= 0 || dw <= 0 || dh <= 0 { return }
-    // Luma
-    for y := 0; y < dh; y++ {
-        sy := y * sh / dh
-        for x := 0; x < dw; x++ {
-            sx := x * sw / dw
-            yDst[y*dw+x] = ySrc[sy*sw+sx]
-        }
-    }
+    if filter == "" { filter = DefaultScaleFilter() }
+    scalePlane(ySrc, sw, sh, yDst, dw, dh, filter)
     // Chroma (subsampled 2:1): scale at half resolution
     sw2, sh2 := sw/2, sh/2
     dw2, dh2 := dw/2, dh/2
-    for y := 0; y < dh2; y++ {
-        sy := y * sh2 / dh2
-        for x := 0; x < dw2; x++ {
-            sx := x * sw2 / dw2
-            uDst[y*dw2+x] = uSrc[sy*sw2+sx]
-            vDst[y*dw2+x] = vSrc[sy*sw2+sx]
+    scalePlane(uSrc, sw2, sh2, uDst, dw2, dh2, filter)
+    scalePlane(vSrc, sw2, sh2, vDst, dw2, dh2, filter)
+}
+
+// scalePlane scales one 8-bit plane, rows in bands across the CPUs.
+func scalePlane(src []byte, sw, sh int, dst []byte, dw, dh int, filter string) {
+    if sw <= 0 || sh <= 0 || dw <= 0 || dh <= 0 { return }
+    // Box only averages when shrinking, as libyuv's does
+    if filter == ScaleBox && (dw > sw || dh > sh) { filter = ScaleBilinear }
+    switch filter {
+    case ScaleBox:
+        xs := boxSpans(sw, dw)
+        parallelRows(dh, 1, func(r0, r1 int) { boxRows(src, sw, sh, dst, dw, dh, xs, r0, r1) })
+    case ScaleLinear, ScaleBilinear:
+        xs := linearTaps(sw, dw)
+        parallelRows(dh, 1, func(r0, r1 int) { linearRows(src, sw, sh, dst, dw, dh, xs, filter == ScaleBilinear, r0, r1) })
+    default:
+        parallelRows(dh, 1, func(r0, r1 int) { nearestRows(src, sw, sh, dst, dw, dh, r0, r1) })
+    }
+}
+
+func nearestRows(src []byte, sw, sh int, dst []byte, dw, dh, r0, r1 int) {
+    for y := r0; y < r1; y++ {
+        row := src[(y*sh/dh)*sw:]
+        out := dst[y*dw : (y+1)*dw]
+        for x := range out { out[x] = row[x*sw/dw] }
+    }
+}
+
+// tap is a source position for one output pixel: index i and the weight
+// of i+1, in 256ths.
+type tap struct{ i, f int }
+
+// linearTaps maps each of dw output positions onto sw inputs, pixel centres
+// aligned.
+func linearTaps(sw, dw int) []tap {
+    taps := make([]tap, dw)
+    for x := range taps {
+        pos := (2*x+1)*sw*256/(2*dw) - 128
+        if pos < 0 { pos = 0 }
+        i, f := pos>>8, pos&255
+        if i >= sw-1 { i, f = sw-1, 0 }
+        taps[x] = tap{i, f}
+    }
+    return taps
+}
+
+func lerp(a, b byte, f int) int { return (int(a)*(256-f) + int(b)*f + 128) >> 8 }
+
+// linearRows interpolates along rows, and down columns too when bilinear.
+func linearRows(src []byte, sw, sh int, dst []byte, dw, dh int, xs []tap, bilinear bool, r0, r1 int) {
+    var ys []tap
+    if bilinear { ys = linearTaps(sh, dh) }
+    for y := r0; y < r1; y++ {
+        ty := tap{i: y * sh / dh}
+        if bilinear { ty = ys[y] }
+        a := src[ty.i*sw : (ty.i+1)*sw]
+        b := a
+        if ty.f > 0 { b = src[(ty.i+1)*sw : (ty.i+2)*sw] }
+        out := dst[y*dw : (y+1)*dw]
+        for x, t := range xs {
+            j := min(t.i+1, sw-1)
+            top := lerp(a[t.i], a[j], t.f)
+            if ty.f == 0 { out[x] = byte(top); continue }
+            bot := lerp(b[t.i], b[j], t.f)
+            out[x] = byte((top*(256-ty.f) + bot*ty.f + 128) >> 8)
         }
     }
 }
 
+// boxSpans is the run of inputs [i, f) each of dw outputs covers, at least
+// one wide.
+func boxSpans(sw, dw int) []tap {
+    spans := make([]tap, dw)
+    for x := range spans {
+        i := x * sw / dw
+        spans[x] = tap{i, max((x+1)*sw/dw, i+1)}
+    }
+    return spans
+}
+
+// boxRows averages the inputs each output covers.
+func boxRows(src []byte, sw, sh int, dst []byte, dw, dh int, xs []tap, r0, r1 int) {
+    for y := r0; y < r1; y++ {
+        y0 := y * sh / dh
+        y1 := max((y+1)*sh/dh, y0+1)
+        out := dst[y*dw : (y+1)*dw]
+        for x, s := range xs {
+            sum, n := 0, (y1-y0)*(s.f-s.i)
+            for r := y0; r < y1; r++ {
+                for _, p := range src[r*sw+s.i : r*sw+s.f] { sum += int(p) }
+            }
+            out[x] = byte((sum + n/2) / n)
+        }
+    }
+}
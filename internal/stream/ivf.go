@@ -0,0 +1,206 @@
+package stream
+
+import (
+    "bufio"
+    "encoding/binary"
+    "errors"
+    "fmt"
+    "os"
+    "path/filepath"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/pion/webrtc/v3/pkg/media"
+)
+
+// IVF is the container libvpx and libaom write themselves: a 32-byte file
+// header, then every frame as a 12-byte header (size, pts) and its data.
+// It holds VP8, VP9 and AV1, which is all a recording of a WHEP mount
+// needs, without a muxer.
+
+// ivfTimebase is the pts units per second, the RTP video clock.
+const ivfTimebase = 90000
+
+// DefaultIVFSyncInterval is how often a recording is flushed to disk.
+const DefaultIVFSyncInterval = 2 * time.Second
+
+// ivfFourCC is the IVF codec tag for codec.
+func ivfFourCC(codec string) (string, bool) {
+    switch codec {
+    case "vp8": return "VP80", true
+    case "vp9": return "VP90", true
+    case "av1": return "AV01", true
+    }
+    return "", false
+}
+
+// IVFCodec reports whether codec can be recorded to IVF.
+func IVFCodec(codec string) bool { _, ok := ivfFourCC(codec); return ok }
+
+// IVFOptions configure an IVFRecorder.
+type IVFOptions struct {
+    Width, Height int           // for the header; decoders go by the bitstream
+    MaxBytes      int64         // start a new file at the next keyframe past this; 0 never
+    SyncEvery     time.Duration // fsync interval; 0 for DefaultIVFSyncInterval
+    // RequestKeyframe is called when a file is full, so the next one
+    // needn't wait for the periodic keyframe
+    RequestKeyframe func()
+}
+
+// IVFStats describe a recording so far.
+type IVFStats struct {
+    Path   string `json:"path"`  // file being written
+    Files  int    `json:"files"` // including the current one
+    Bytes  int64  `json:"bytes"` // across every file
+    Frames uint64 `json:"frames"`
+}
+
+// IVFRecorder writes encoded video samples to IVF files, as a sink of a
+// SampleBroadcaster. Every file starts at a keyframe with pts 0, so each
+// one plays on its own; a file is finished and the next one started at the
+// first keyframe after it reaches MaxBytes. Later files are named after the
+// first with -001, -002... before the extension. Audio samples are ignored.
+type IVFRecorder struct {
+    path   string
+    fourcc string
+    opts   IVFOptions
+
+    mu         sync.Mutex
+    f          *os.File
+    bw         *bufio.Writer
+    cur        string
+    part       int
+    fileBytes  int64
+    fileFrames uint32
+    started    bool      // the current file has its first keyframe
+    origin     time.Time // first frame's timestamp in this file
+    lastPTS    int64
+    synced     time.Time
+    keyAsked   bool
+    closed     bool
+    files      int
+    bytes      int64
+    frames     uint64
+}
+
+// NewIVFRecorder creates path for a codec recording. It fails for codecs
+// IVF can't hold and when path already exists.
+func NewIVFRecorder(path, codec string, opts IVFOptions) (*IVFRecorder, error) {
+    fourcc, ok := ivfFourCC(codec)
+    if !ok { return nil, fmt.Errorf("can't record %s to IVF (vp8, vp9 or av1)", codec) }
+    if opts.SyncEvery <= 0 { opts.SyncEvery = DefaultIVFSyncInterval }
+    r := &IVFRecorder{path: path, fourcc: fourcc, opts: opts}
+    if err := r.open(path); err != nil { return nil, err }
+    return r, nil
+}
+
+// open starts a new file at name. Caller holds r.mu, or r is new.
+func (r *IVFRecorder) open(name string) error {
+    f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+    if err != nil { return err }
+    r.f, r.bw, r.cur = f, bufio.NewWriterSize(f, 256<<10), name
+    r.fileBytes, r.fileFrames, r.started, r.keyAsked = 0, 0, false, false
+    r.synced = time.Now()
+    r.files++
+    var hdr [32]byte
+    copy(hdr[0:4], "DKIF")
+    binary.LittleEndian.PutUint16(hdr[4:], 0)  // version
+    binary.LittleEndian.PutUint16(hdr[6:], 32) // header size
+    copy(hdr[8:12], r.fourcc)
+    binary.LittleEndian.PutUint16(hdr[12:], uint16(r.opts.Width))
+    binary.LittleEndian.PutUint16(hdr[14:], uint16(r.opts.Height))
+    binary.LittleEndian.PutUint32(hdr[16:], ivfTimebase) // rate
+    binary.LittleEndian.PutUint32(hdr[20:], 1)           // scale
+    // hdr[24:28], the frame count, is filled in when the file is finished
+    if _, err := r.bw.Write(hdr[:]); err != nil { return err }
+    r.add(32)
+    return nil
+}
+
+func (r *IVFRecorder) add(n int64) { r.fileBytes += n; r.bytes += n }
+
+// finish writes the frame count into the header and closes the file.
+// Caller holds r.mu.
+func (r *IVFRecorder) finish() error {
+    if r.f == nil { return nil }
+    err := r.bw.Flush()
+    var n [4]byte
+    binary.LittleEndian.PutUint32(n[:], r.fileFrames)
+    if _, e := r.f.WriteAt(n[:], 24); err == nil { err = e }
+    if e := r.f.Sync(); err == nil { err = e }
+    if e := r.f.Close(); err == nil { err = e }
+    r.f, r.bw = nil, nil
+    return err
+}
+
+// partName is the name of the n-th file after the first.
+func (r *IVFRecorder) partName(n int) string {
+    ext := filepath.Ext(r.path)
+    return fmt.Sprintf("%s-%03d%s", strings.TrimSuffix(r.path, ext), n, ext)
+}
+
+// WriteSample appends a video sample. Delta frames before a file's first
+// keyframe are left out, since nothing could decode them.
+func (r *IVFRecorder) WriteSample(sm media.Sample) error {
+    key, video := sampleKeyframe(sm)
+    if !video || len(sm.Data) == 0 { return nil }
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    if r.closed { return errors.New("recording closed") }
+    if r.started && key && r.opts.MaxBytes > 0 && r.fileBytes >= r.opts.MaxBytes {
+        if err := r.finish(); err != nil { return err }
+        for {
+            r.part++
+            err := r.open(r.partName(r.part))
+            if err == nil { break }
+            if !errors.Is(err, os.ErrExist) { return err }
+        }
+    }
+    if !r.started {
+        if !key { return nil }
+        r.started, r.origin, r.lastPTS = true, sm.Timestamp, -1
+    }
+    // pts from the capture stamp, kept increasing; unstamped samples
+    // follow the previous one by their duration
+    pts := r.lastPTS + max(int64(sm.Duration*ivfTimebase/time.Second), 1)
+    if !sm.Timestamp.IsZero() && !r.origin.IsZero() {
+        if p := int64(sm.Timestamp.Sub(r.origin) * ivfTimebase / time.Second); p > r.lastPTS { pts = p }
+    }
+    if r.lastPTS < 0 { pts = 0 }
+    var hdr [12]byte
+    binary.LittleEndian.PutUint32(hdr[0:], uint32(len(sm.Data)))
+    binary.LittleEndian.PutUint64(hdr[4:], uint64(pts))
+    if _, err := r.bw.Write(hdr[:]); err != nil { return err }
+    if _, err := r.bw.Write(sm.Data); err != nil { return err }
+    r.add(int64(12 + len(sm.Data)))
+    r.lastPTS = pts
+    r.fileFrames++
+    r.frames++
+    if r.opts.MaxBytes > 0 && r.fileBytes >= r.opts.MaxBytes && !r.keyAsked && r.opts.RequestKeyframe != nil {
+        r.keyAsked = true
+        go r.opts.RequestKeyframe()
+    }
+    if now := time.Now(); now.Sub(r.synced) >= r.opts.SyncEvery {
+        r.synced = now
+        if err := r.bw.Flush(); err != nil { return err }
+        if err := r.f.Sync(); err != nil { return err }
+    }
+    return nil
+}
+
+// Close finishes the current file. Samples written after it fail.
+func (r *IVFRecorder) Close() error {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    if r.closed { return nil }
+    r.closed = true
+    return r.finish()
+}
+
+// Stats reports the recording so far.
+func (r *IVFRecorder) Stats() IVFStats {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    return IVFStats{Path: r.cur, Files: r.files, Bytes: r.bytes, Frames: r.frames}
+}
@@ -1,24 +1,34 @@
 package stream
 
 import (
+    "context"
     "runtime"
+    "sort"
+    "sync"
     "sync/atomic"
+    "time"
 )
 
 // Global counters for simple health metrics and runtime tracking.
 // Intended to observe backpressure (e.g., dropped frames) and detect leaks.
 var (
     // Frame/packet counters
-    framesIn      atomic.Uint64 // frames pulled from Source
-    framesEncoded atomic.Uint64 // frames that produced encoded output
-    framesDropped atomic.Uint64 // frames that produced no output (encoder dropped)
-    samplesSent   atomic.Uint64 // samples written to RTP track
+    framesIn        atomic.Uint64 // frames pulled from Source
+    framesEncoded   atomic.Uint64 // frames whose encode call produced output
+    framesBuffered  atomic.Uint64 // frames the encoder held back (lag, look-ahead); output later
+    framesDroppedRC atomic.Uint64 // frames rate control discarded
+    framesDroppedIn atomic.Uint64 // source frames not encoded: wrong size or short
+    framesError     atomic.Uint64 // frames lost to an encoder error
+    framesSkipped   atomic.Uint64 // frames left out as repeats of the last one encoded
+    samplesSent     atomic.Uint64 // samples written to RTP track
 
     // Runtime resource counters
     activePipelines atomic.Uint64 // total pipelines (any codec)
     activeVP8       atomic.Uint64
     activeVP9       atomic.Uint64
     activeAV1       atomic.Uint64
+    activeH264      atomic.Uint64
+    activeOpus      atomic.Uint64
     activeSources   atomic.Uint64 // total live Sources (e.g., NDI receivers)
 )
 
@@ -26,7 +36,11 @@ var (
 func ResetCounters() {
     framesIn.Store(0)
     framesEncoded.Store(0)
-    framesDropped.Store(0)
+    framesBuffered.Store(0)
+    framesDroppedRC.Store(0)
+    framesDroppedIn.Store(0)
+    framesError.Store(0)
+    framesSkipped.Store(0)
     samplesSent.Store(0)
     // Keep runtime counters as-is; they represent live objects.
 }
@@ -34,10 +48,16 @@ func ResetCounters() {
 // GetCounters returns a snapshot of current frame/packet metrics.
 func GetCounters() map[string]uint64 {
     return map[string]uint64{
-        "frames_in":       framesIn.Load(),
-        "frames_encoded":  framesEncoded.Load(),
-        "frames_dropped":  framesDropped.Load(),
-        "samples_sent":    samplesSent.Load(),
+        "frames_in":         framesIn.Load(),
+        "frames_encoded":    framesEncoded.Load(),
+        "frames_buffered":   framesBuffered.Load(),
+        "frames_dropped_rc": framesDroppedRC.Load(),
+        "frames_dropped_input": framesDroppedIn.Load(),
+        "frames_error":      framesError.Load(),
+        "frames_skipped_unchanged": framesSkipped.Load(),
+        // Frames that will never be sent; buffered frames are not lost
+        "frames_dropped":    framesDroppedRC.Load() + framesDroppedIn.Load() + framesError.Load(),
+        "samples_sent":      samplesSent.Load(),
     }
 }
 
@@ -48,16 +68,103 @@ func GetRuntimeStats() map[string]uint64 {
         "active_vp8":       activeVP8.Load(),
         "active_vp9":       activeVP9.Load(),
         "active_av1":       activeAV1.Load(),
+        "active_h264":      activeH264.Load(),
+        "active_opus":      activeOpus.Load(),
         "active_sources":   activeSources.Load(),
         "goroutines":       uint64(runtime.NumGoroutine()),
     }
 }
 
-// Internal helpers used by pipelines/sources
-func incFramesIn()      { framesIn.Add(1) }
-func incFramesEncoded() { framesEncoded.Add(1) }
-func incFramesDropped() { framesDropped.Add(1) }
-func incSamplesSent(n int) { if n > 0 { samplesSent.Add(uint64(n)) } }
+// pipelineCounters are the frame counters of one (codec, label) pair. They
+// outlive the pipeline so scraped counters never go backwards on restart.
+type pipelineCounters struct {
+    codec, label string
+    in, encoded, buffered, droppedRC, droppedIn, errored, skipped, sent atomic.Uint64
+}
+
+var labeled sync.Map // codec+"\x00"+label -> *pipelineCounters
+
+// countersFor returns the shared counters for codec and label.
+func countersFor(codec, label string) *pipelineCounters {
+    k := codec + "\x00" + label
+    if c, ok := labeled.Load(k); ok { return c.(*pipelineCounters) }
+    c, _ := labeled.LoadOrStore(k, &pipelineCounters{codec: codec, label: label})
+    return c.(*pipelineCounters)
+}
+
+// Helpers used by pipelines: bump both the global and the labelled counter
+func (c *pipelineCounters) incFramesIn()      { framesIn.Add(1); c.in.Add(1) }
+func (c *pipelineCounters) incFramesEncoded() { framesEncoded.Add(1); c.encoded.Add(1) }
+func (c *pipelineCounters) incFramesError()   { framesError.Add(1); c.errored.Add(1) }
+func (c *pipelineCounters) incFramesDroppedInput() { framesDroppedIn.Add(1); c.droppedIn.Add(1) }
+func (c *pipelineCounters) incFramesSkipped() { framesSkipped.Add(1); c.skipped.Add(1) }
+func (c *pipelineCounters) incSamplesSent(n int) {
+    if n > 0 { samplesSent.Add(uint64(n)); c.sent.Add(uint64(n)) }
+}
+
+// countEncode counts one frame submitted to an encoder by how it came out.
+func (c *pipelineCounters) countEncode(r encodeResult) {
+    switch r {
+    case encodeBuffered: framesBuffered.Add(1); c.buffered.Add(1)
+    case encodeDroppedRC: framesDroppedRC.Add(1); c.droppedRC.Add(1)
+    default: c.incFramesEncoded()
+    }
+}
+
+// encodeResult is what became of one frame submitted to an encoder.
+type encodeResult int
+
+const (
+    encodeOutput    encodeResult = iota // the call returned packets
+    encodeBuffered                      // held back by lag or look-ahead; comes out with a later frame
+    encodeDroppedRC                     // discarded by rate control (e.g. VP8 dropframe)
+)
+
+// encodeLag tells a frame the encoder is still holding apart from one rate
+// control discarded. An encoder holds at most lag frames, so once that many
+// are outstanding, another call with no output means a frame was dropped.
+// Counting is all there is to go on: libvpx and libaom have no per-frame
+// drop flag, a dropped frame is just a call that yields no packet
+// (VPX_FRAME_IS_DROPPABLE marks a packet a decoder may skip, not one the
+// encoder dropped).
+type encodeLag struct {
+    lag  int // frames the encoder may hold; < 0 if it never drops frames
+    held int // frames submitted and not yet output
+}
+
+// account records a submitted frame whose encode call returned n output
+// frames, and reports how to count it.
+func (l *encodeLag) account(n int) encodeResult {
+    l.held++
+    if n > 0 {
+        l.held = max(l.held-n, 0)
+        return encodeOutput
+    }
+    if l.lag < 0 || l.held <= l.lag { return encodeBuffered }
+    l.held--
+    return encodeDroppedRC
+}
+
+// LabeledCounter is a snapshot of one pipeline label's frame counters.
+type LabeledCounter struct {
+    Codec, Label                                                                     string
+    FramesIn, FramesEncoded, FramesBuffered, FramesDroppedRC, FramesDroppedInput, FramesError, FramesSkippedUnchanged, SamplesSent uint64
+}
+
+// GetLabeledCounters returns per-(codec, label) counters sorted by label.
+func GetLabeledCounters() []LabeledCounter {
+    var out []LabeledCounter
+    labeled.Range(func(_, v any) bool {
+        c := v.(*pipelineCounters)
+        out = append(out, LabeledCounter{Codec: c.codec, Label: c.label, FramesIn: c.in.Load(), FramesEncoded: c.encoded.Load(), FramesBuffered: c.buffered.Load(), FramesDroppedRC: c.droppedRC.Load(), FramesDroppedInput: c.droppedIn.Load(), FramesError: c.errored.Load(), FramesSkippedUnchanged: c.skipped.Load(), SamplesSent: c.sent.Load()})
+        return true
+    })
+    sort.Slice(out, func(i, j int) bool {
+        if out[i].Label != out[j].Label { return out[i].Label < out[j].Label }
+        return out[i].Codec < out[j].Codec
+    })
+    return out
+}
 
 func registerPipeline(codec string) {
     activePipelines.Add(1)
@@ -65,6 +172,8 @@ func registerPipeline(codec string) {
     case "vp8": activeVP8.Add(1)
     case "vp9": activeVP9.Add(1)
     case "av1": activeAV1.Add(1)
+    case "h264": activeH264.Add(1)
+    case "opus": activeOpus.Add(1)
     }
 }
 func unregisterPipeline(codec string) {
@@ -74,8 +183,25 @@ func unregisterPipeline(codec string) {
     case "vp8": activeVP8.Add(^uint64(0))
     case "vp9": activeVP9.Add(^uint64(0))
     case "av1": activeAV1.Add(^uint64(0))
+    case "h264": activeH264.Add(^uint64(0))
+    case "opus": activeOpus.Add(^uint64(0))
     }
 }
 func registerSource()   { activeSources.Add(1) }
 func unregisterSource() { activeSources.Add(^uint64(0)) }
 
+
+// WaitIdle blocks until every pipeline and source goroutine has exited, or
+// ctx is done. Used on shutdown after everything has been asked to stop.
+func WaitIdle(ctx context.Context) error {
+    ticker := time.NewTicker(20 * time.Millisecond)
+    defer ticker.Stop()
+    for activePipelines.Load() > 0 || activeSources.Load() > 0 {
+        select {
+        case <-ctx.Done():
+            return ctx.Err()
+        case <-ticker.C:
+        }
+    }
+    return nil
+}
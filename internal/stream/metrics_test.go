@@ -0,0 +1,103 @@
+package stream
+
+import (
+    "errors"
+    "testing"
+)
+
+func TestEncodeLagAccount(t *testing.T) {
+    const (
+        out  = encodeOutput
+        buf  = encodeBuffered
+        drop = encodeDroppedRC
+    )
+    tests := []struct {
+        name    string
+        lag     int
+        outputs []int // packets each encode call returned
+        want    []encodeResult
+    }{
+        {"no lag", 0, []int{1, 1}, []encodeResult{out, out}},
+        {"no lag drops", 0, []int{1, 0, 0, 1}, []encodeResult{out, drop, drop, out}},
+        {"never drops", -1, []int{0, 0, 0, 1}, []encodeResult{buf, buf, buf, out}},
+        {"fills lag", 2, []int{0, 0, 1, 1}, []encodeResult{buf, buf, out, out}},
+        {"drop past lag", 2, []int{0, 0, 1, 0, 1}, []encodeResult{buf, buf, out, drop, out}},
+        {"drop while filling", 2, []int{0, 0, 0, 1}, []encodeResult{buf, buf, drop, out}},
+        {"catch up", 2, []int{0, 0, 2, 0, 0}, []encodeResult{buf, buf, out, buf, drop}},
+        {"more out than held", 1, []int{0, 3, 0, 0}, []encodeResult{buf, out, buf, drop}},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            l := encodeLag{lag: tt.lag}
+            for i, n := range tt.outputs {
+                if got := l.account(n); got != tt.want[i] { t.Fatalf("call %d (%d packets): got %d, want %d", i, n, got, tt.want[i]) }
+            }
+        })
+    }
+}
+
+// codecEncoder stands in for a codec's encoder wrapper: each call returns
+// the packets its script says, or an error for -1, and is counted by
+// encodeLag with the codec's lag as vpx.go and aom.go count theirs.
+type codecEncoder struct {
+    outputs []int
+    calls   int
+    lag     encodeLag
+    last    encodeResult
+}
+
+func (e *codecEncoder) EncodeI420(y, u, v []byte) ([][]byte, bool, error) {
+    n := e.outputs[e.calls]
+    e.calls++
+    if n < 0 { return nil, false, errors.New("encode failed") }
+    out := make([][]byte, n)
+    for i := range out { out[i] = []byte{byte(e.calls)} }
+    e.last = e.lag.account(n)
+    return out, e.calls == 1, nil
+}
+
+func (e *codecEncoder) lastResult() encodeResult { return e.last }
+
+func (e *codecEncoder) Flush() ([][]byte, error) {
+    out := make([][]byte, e.lag.held)
+    for i := range out { out[i] = []byte{'f'} }
+    e.lag.held = 0
+    return out, nil
+}
+
+func (e *codecEncoder) Close() {}
+
+// TestEncodeCountersPerCodec runs the encode loop with each codec's lag
+// over known output sequences and checks the buffered, rate-control and
+// error counters under that codec's label.
+func TestEncodeCountersPerCodec(t *testing.T) {
+    tests := []struct {
+        codec   string
+        lag     int   // g_lag_in_frames, or -1 for encoders that never drop
+        outputs []int // packets each encode call returns; -1 fails
+        want    LabeledCounter
+    }{
+        // VP8 runs without lag: every empty call is a dropframe
+        {"vp8", 0, []int{1, 0, 1, 1, 0, 0, 1, -1},
+            LabeledCounter{FramesIn: 8, FramesEncoded: 4, FramesDroppedRC: 3, FramesError: 1, SamplesSent: 4}},
+        // VP9 with lag holds its first frames, then drops past the lag;
+        // the 3 it holds when the error ends the loop are flushed
+        {"vp9", 3, []int{0, 0, 0, 1, 1, 1, 0, 0, 2, 0, -1},
+            LabeledCounter{FramesIn: 11, FramesEncoded: 7, FramesBuffered: 4, FramesDroppedRC: 2, FramesError: 1, SamplesSent: 8}},
+        // libaom with lag; the frames it still holds come out of the flush
+        {"av1", 2, []int{0, 0, 1, 0, 1, 0, 0, 0},
+            LabeledCounter{FramesIn: 8, FramesEncoded: 4, FramesBuffered: 2, FramesDroppedRC: 4, SamplesSent: 4}},
+        // QSV never drops: an empty call is always a buffered frame
+        {"h264", -1, []int{0, 0, 1, 2, 0, 1, -1},
+            LabeledCounter{FramesIn: 7, FramesEncoded: 5, FramesBuffered: 3, FramesError: 1, SamplesSent: 6}},
+    }
+    for _, tt := range tests {
+        t.Run(tt.codec, func(t *testing.T) {
+            enc := &codecEncoder{outputs: tt.outputs, lag: encodeLag{lag: tt.lag}}
+            before := labelCounts(tt.codec, t.Name())
+            runScript(t, tt.codec, t.Name(), scriptFrames(len(tt.outputs), nil), enc)
+            if enc.calls != len(tt.outputs) { t.Errorf("%d encode calls, want %d", enc.calls, len(tt.outputs)) }
+            if got := labelCounts(tt.codec, t.Name()).minus(before); got != tt.want { t.Errorf("counters %+v, want %+v", got, tt.want) }
+        })
+    }
+}
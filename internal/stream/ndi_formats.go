@@ -0,0 +1,94 @@
+package stream
+
+import "whep/internal/ndi"
+
+// ndiFormat is how NDISource reads one sender FourCC: packed formats become
+// rows of pixfmt, planar ones I420 planes.
+type ndiFormat struct {
+    pixfmt string // "bgra" or "uyvy422", or "i420" for planar formats
+    swapRB bool   // RGBA/RGBX: repacked to BGRA
+    // toI420 converts a planar frame whose first plane has lines stride
+    // bytes apart
+    toI420 func(src []byte, stride, w, h int, y, u, v []byte)
+}
+
+// ndiFormatOf returns the reading of fourcc; ok is false for a FourCC
+// this server can't convert.
+func ndiFormatOf(fourcc int) (f ndiFormat, ok bool) {
+    switch fourcc {
+    case ndi.FourCCUYVY, ndi.FourCCUYVA:
+        // UYVA's alpha plane follows the UYVY one and is left unread
+        return ndiFormat{pixfmt: "uyvy422"}, true
+    case ndi.FourCCBGRA, ndi.FourCCBGRX:
+        return ndiFormat{pixfmt: "bgra"}, true
+    case ndi.FourCCRGBA, ndi.FourCCRGBX:
+        return ndiFormat{pixfmt: "bgra", swapRB: true}, true
+    case ndi.FourCCNV12:
+        return ndiFormat{pixfmt: "i420", toI420: NV12toI420}, true
+    case ndi.FourCCI420:
+        return ndiFormat{pixfmt: "i420", toI420: func(src []byte, stride, w, h int, y, u, v []byte) { planarToI420(src, stride, w, h, y, u, v) }}, true
+    case ndi.FourCCYV12:
+        return ndiFormat{pixfmt: "i420", toI420: func(src []byte, stride, w, h int, y, u, v []byte) { planarToI420(src, stride, w, h, y, v, u) }}, true
+    case ndi.FourCCP216, ndi.FourCCPA16:
+        // PA16's alpha plane follows the P216 ones and is left unread
+        return ndiFormat{pixfmt: "i420", toI420: P216toI420}, true
+    }
+    return ndiFormat{}, false
+}
+
+// pack copies h rows of row bytes, stride bytes apart in src, to dst back to
+// back, swapping R and B for RGBA senders.
+func (f ndiFormat) pack(dst, src []byte, stride, row, h int) {
+    if !f.swapRB { packRows(dst, src, stride, row, h); return }
+    for y := 0; y < h; y++ {
+        s, d := src[y*stride:y*stride+row], dst[y*row:(y+1)*row]
+        for i := 0; i+3 < row; i += 4 { d[i], d[i+1], d[i+2], d[i+3] = s[i+2], s[i+1], s[i], s[i+3] }
+    }
+}
+
+// NV12toI420 converts NV12 (a Y plane, then interleaved UV at half height,
+// both with lines stride bytes apart) to I420.
+func NV12toI420(src []byte, stride, w, h int, y, u, v []byte) {
+    for r := 0; r < h; r++ { copy(y[r*w:(r+1)*w], src[r*stride:]) }
+    uv, cw := src[stride*h:], w/2
+    for r := 0; r < h/2; r++ {
+        line := uv[r*stride:]
+        for x := 0; x < cw; x++ {
+            u[r*cw+x] = line[2*x]
+            v[r*cw+x] = line[2*x+1]
+        }
+    }
+}
+
+// planarToI420 copies a planar 4:2:0 frame (Y with lines stride bytes
+// apart, then two chroma planes at stride/2) into tight planes; the chroma
+// planes land in c1 and c2 in the order they are stored.
+func planarToI420(src []byte, stride, w, h int, y, c1, c2 []byte) {
+    for r := 0; r < h; r++ { copy(y[r*w:(r+1)*w], src[r*stride:]) }
+    cs, cw, ch := stride/2, w/2, h/2
+    p1 := src[stride*h:]
+    p2 := p1[cs*ch:]
+    for r := 0; r < ch; r++ {
+        copy(c1[r*cw:(r+1)*cw], p1[r*cs:])
+        copy(c2[r*cw:(r+1)*cw], p2[r*cs:])
+    }
+}
+
+// P216toI420 converts P216 (16-bit little-endian 4:2:2: a Y plane, then
+// interleaved UV at full height, both with lines stride bytes apart) to
+// 8-bit I420, keeping each sample's high byte and averaging chroma over
+// pairs of lines.
+func P216toI420(src []byte, stride, w, h int, y, u, v []byte) {
+    for r := 0; r < h; r++ {
+        line, out := src[r*stride:], y[r*w:(r+1)*w]
+        for x := range out { out[x] = line[2*x+1] }
+    }
+    uv, cw := src[stride*h:], w/2
+    for r := 0; r < h/2; r++ {
+        l0, l1 := uv[2*r*stride:], uv[(2*r+1)*stride:]
+        for x := 0; x < cw; x++ {
+            u[r*cw+x] = byte((int(l0[4*x+1]) + int(l1[4*x+1]) + 1) >> 1)
+            v[r*cw+x] = byte((int(l0[4*x+3]) + int(l1[4*x+3]) + 1) >> 1)
+        }
+    }
+}
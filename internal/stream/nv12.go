@@ -0,0 +1,73 @@
+package stream
+
+// NV12 conversions for hardware encoders (QSV) that take a Y plane followed by
+// an interleaved UV plane. Same integer math as the pure-Go BGRAtoI420.
+
+// BGRAtoNV12 converts a BGRA frame (w*h*4) to NV12, in the color space
+// YUV_MATRIX and YUV_RANGE select for the frame size. y size: w*h; uv
+// size: w*(h/2).
+func BGRAtoNV12(bgra []byte, w, h int, y, uv []byte) {
+    k := coeffsFor(ColorSpace{}, w, h)
+    for yrow := 0; yrow < h; yrow++ {
+        for x := 0; x < w; x++ {
+            off := (yrow*w + x) * 4
+            b := int(bgra[off+0])
+            g := int(bgra[off+1])
+            r := int(bgra[off+2])
+            y[yrow*w+x] = clamp8(((k.yr*r + k.yg*g + k.yb*b + 128) >> 8) + k.yo)
+        }
+    }
+    for yrow := 0; yrow+1 < h; yrow += 2 {
+        for x := 0; x+1 < w; x += 2 {
+            var rSum, gSum, bSum int
+            for dy := 0; dy < 2; dy++ {
+                for dx := 0; dx < 2; dx++ {
+                    off := ((yrow+dy)*w + (x+dx)) * 4
+                    bSum += int(bgra[off+0])
+                    gSum += int(bgra[off+1])
+                    rSum += int(bgra[off+2])
+                }
+            }
+            r := rSum >> 2; g := gSum >> 2; b := bSum >> 2
+            ci := (yrow/2)*w + x
+            uv[ci+0] = clamp8(((k.ur*r + k.ug*g + k.ub*b + 128) >> 8) + 128)
+            uv[ci+1] = clamp8(((k.vr*r + k.vg*g + k.vb*b + 128) >> 8) + 128)
+        }
+    }
+}
+
+// UYVYtoNV12 converts packed UYVY 4:2:2 (w*h*2) to NV12, averaging chroma
+// vertically over each pair of rows. Assumes even width and height.
+func UYVYtoNV12(src []byte, w, h int, y, uv []byte) {
+    for row := 0; row < h; row++ {
+        srcOff := row * w * 2
+        yi := row * w
+        for x := 0; x < w; x += 2 {
+            i := srcOff + x*2
+            y[yi+x+0] = src[i+1]
+            y[yi+x+1] = src[i+3]
+        }
+        if row&1 != 0 { continue }
+        next := srcOff
+        if row+1 < h { next = srcOff + w*2 }
+        ci := (row / 2) * w
+        for cx := 0; cx < w; cx += 2 {
+            i0 := srcOff + cx*2
+            i1 := next + cx*2
+            uv[ci+cx+0] = byte((int(src[i0+0]) + int(src[i1+0])) >> 1)
+            uv[ci+cx+1] = byte((int(src[i0+2]) + int(src[i1+2])) >> 1)
+        }
+    }
+}
+
+func clamp8(x int) byte { if x < 0 { return 0 }; if x > 255 { return 255 }; return byte(x) }
+
+// I420toNV12 copies I420 planes into NV12, interleaving U and V.
+func I420toNV12(yIn, uIn, vIn []byte, w, h int, y, uv []byte) {
+    copy(y[:w*h], yIn)
+    n := (w / 2) * (h / 2)
+    for i := 0; i < n; i++ {
+        uv[2*i] = uIn[i]
+        uv[2*i+1] = vIn[i]
+    }
+}
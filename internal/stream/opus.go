@@ -0,0 +1,60 @@
+//go:build cgo && opus
+
+package stream
+
+/*
+#cgo LDFLAGS: -lopus
+
+#include <opus/opus.h>
+
+// Wrappers for vararg opus_encoder_ctl
+static int set_opus_bitrate(OpusEncoder *e, int v) { return opus_encoder_ctl(e, OPUS_SET_BITRATE(v)); }
+static int set_opus_complexity(OpusEncoder *e, int v) { return opus_encoder_ctl(e, OPUS_SET_COMPLEXITY(v)); }
+static int set_opus_inband_fec(OpusEncoder *e, int v) { return opus_encoder_ctl(e, OPUS_SET_INBAND_FEC(v)); }
+*/
+import "C"
+
+import (
+    "errors"
+    "fmt"
+    "unsafe"
+)
+
+// OpusEncoder encodes 48 kHz stereo float PCM in 20 ms frames with libopus.
+type OpusEncoder struct {
+    enc *C.OpusEncoder
+    out []byte
+}
+
+func NewOpusEncoder(bitrateKbps int) (*OpusEncoder, error) {
+    var st C.int
+    enc := C.opus_encoder_create(C.opus_int32(opusSampleRate), C.int(opusChannels), C.OPUS_APPLICATION_AUDIO, &st)
+    if st != C.OPUS_OK || enc == nil {
+        return nil, fmt.Errorf("opus_encoder_create failed: %s", C.GoString(C.opus_strerror(st)))
+    }
+    if bitrateKbps <= 0 { bitrateKbps = 128 }
+    _ = C.set_opus_bitrate(enc, C.int(bitrateKbps*1000))
+    _ = C.set_opus_complexity(enc, 5)
+    _ = C.set_opus_inband_fec(enc, 1)
+    return &OpusEncoder{enc: enc, out: make([]byte, 4000)}, nil
+}
+
+// EncodeFloat encodes one frame of interleaved stereo samples (opusFrameSamples per channel).
+func (e *OpusEncoder) EncodeFloat(pcm []float32) ([]byte, error) {
+    if e.enc == nil { return nil, errors.New("encoder closed") }
+    if len(pcm) < opusFrameSamples*opusChannels { return nil, errors.New("short opus frame") }
+    n := C.opus_encode_float(e.enc, (*C.float)(unsafe.Pointer(&pcm[0])), C.int(opusFrameSamples), (*C.uchar)(unsafe.Pointer(&e.out[0])), C.opus_int32(len(e.out)))
+    if n < 0 {
+        return nil, fmt.Errorf("opus_encode_float failed: %s", C.GoString(C.opus_strerror(n)))
+    }
+    pkt := make([]byte, int(n))
+    copy(pkt, e.out[:int(n)])
+    return pkt, nil
+}
+
+func (e *OpusEncoder) Close() {
+    if e.enc != nil { C.opus_encoder_destroy(e.enc); e.enc = nil }
+}
+
+// OpusAvailable reports whether the binary was built with libopus.
+func OpusAvailable() bool { return true }
@@ -0,0 +1,19 @@
+//go:build !opus
+
+package stream
+
+import "errors"
+
+var errOpusUnavailable = errors.New("opus encoder not available (build with 'opus' tag)")
+
+// OpusEncoder is unavailable without cgo+opus build tags.
+type OpusEncoder struct{}
+
+func NewOpusEncoder(bitrateKbps int) (*OpusEncoder, error) { return nil, errOpusUnavailable }
+
+func (e *OpusEncoder) EncodeFloat(pcm []float32) ([]byte, error) { return nil, errOpusUnavailable }
+
+func (e *OpusEncoder) Close() {}
+
+// OpusAvailable reports whether the binary was built with libopus.
+func OpusAvailable() bool { return false }
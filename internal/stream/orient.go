@@ -0,0 +1,60 @@
+package stream
+
+import (
+    "fmt"
+    "strconv"
+)
+
+// FlipH mirrors a picture left to right.
+const FlipH = "h"
+
+// Orientation turns a picture: mirrored left to right when Flip is FlipH,
+// then rotated clockwise by Rotate degrees. The zero Orientation leaves it
+// as it is. A vertical flip is a mirror and a half turn, so ParseOrientation
+// stores it that way and equal pictures compare equal.
+type Orientation struct {
+    Rotate int    // 0, 90, 180 or 270
+    Flip   string // "" or FlipH
+}
+
+// ParseOrientation reads rotate=0|90|180|270 and flip=h|v; "" is 0 and no
+// flip.
+func ParseOrientation(rotate, flip string) (Orientation, error) {
+    var o Orientation
+    if rotate != "" {
+        n, err := strconv.Atoi(rotate)
+        if err != nil || n%90 != 0 || n < 0 || n >= 360 { return o, fmt.Errorf("rotate must be 0, 90, 180 or 270") }
+        o.Rotate = n
+    }
+    switch flip {
+    case "":
+    case FlipH:
+        o.Flip = FlipH
+    case "v":
+        o.Flip, o.Rotate = FlipH, (o.Rotate+180)%360
+    default:
+        return o, fmt.Errorf("flip must be h or v")
+    }
+    return o, nil
+}
+
+// IsZero reports whether o leaves pictures as they are.
+func (o Orientation) IsZero() bool { return o.Rotate == 0 && o.Flip == "" }
+
+// Swaps reports whether o turns a w x h picture into an h x w one.
+func (o Orientation) Swaps() bool { return o.Rotate == 90 || o.Rotate == 270 }
+
+// Size is the size a w x h picture comes out at.
+func (o Orientation) Size(w, h int) (int, int) {
+    if o.Swaps() { return h, w }
+    return w, h
+}
+
+// String is e.g. "90", or "180h" with a flip.
+func (o Orientation) String() string { return strconv.Itoa(o.Rotate) + o.Flip }
+
+// orientI420 turns w x h planes into d*, which must hold o.Size(w, h).
+// Offsets stay even, so chroma stays aligned with its luma.
+func orientI420(y, u, v []byte, w, h int, dy, du, dv []byte, o Orientation) {
+    I420Orient(y, u, v, w, h, dy, du, dv, o.Rotate, o.Flip == FlipH)
+}
@@ -0,0 +1,70 @@
+package stream
+
+import (
+    "runtime"
+    "sync"
+)
+
+// Pure-Go pixel conversions split a frame into bands of rows and convert
+// them on a pool of runtime.NumCPU() workers started once, so a 1080p BGRA
+// frame isn't held to one core and no goroutines are spawned per frame.
+
+// minBandRows keeps bands big enough that handing one to a worker costs
+// far less than converting it.
+const minBandRows = 32
+
+type rowBand struct {
+    fn     func(r0, r1 int)
+    r0, r1 int
+    wg     *sync.WaitGroup
+}
+
+var (
+    bandOnce    sync.Once
+    bandWorkers int
+    bandQueue   chan rowBand
+)
+
+func startBandWorkers() { startBandPool(runtime.NumCPU()) }
+
+// startBandPool sets up n workers; with fewer than 2, conversions run on
+// the caller alone.
+func startBandPool(n int) {
+    bandWorkers, bandQueue = n, nil
+    if n < 2 { return }
+    q := make(chan rowBand, n)
+    bandQueue = q
+    // The caller converts a band itself, so one worker fewer
+    for i := 1; i < n; i++ {
+        go func() {
+            for b := range q {
+                b.fn(b.r0, b.r1)
+                b.wg.Done()
+            }
+        }()
+    }
+}
+
+// parallelRows calls fn over rows [0, rows) in bands, each starting on a
+// multiple of align, and returns once all are done. fn must only write
+// outputs of its own rows. Small frames, and hosts with one CPU, run fn
+// once over the lot.
+func parallelRows(rows, align int, fn func(r0, r1 int)) {
+    bandOnce.Do(startBandWorkers)
+    n := min(bandWorkers, rows/minBandRows)
+    if n < 2 {
+        fn(0, rows)
+        return
+    }
+    step := (rows/n + align - 1) / align * align
+    var wg sync.WaitGroup
+    r0 := step
+    for ; r0+step < rows; r0 += step {
+        wg.Add(1)
+        bandQueue <- rowBand{fn: fn, r0: r0, r1: r0 + step, wg: &wg}
+    }
+    wg.Add(1)
+    bandQueue <- rowBand{fn: fn, r0: r0, r1: rows, wg: &wg}
+    fn(0, step)
+    wg.Wait()
+}
@@ -0,0 +1,126 @@
+package stream
+
+import (
+    "bytes"
+    "fmt"
+    "math/rand"
+    "runtime"
+    "slices"
+    "sync"
+    "testing"
+)
+
+// withBandWorkers runs conversions on a pool of n workers until the test
+// ends; n below 2 converts serially.
+func withBandWorkers(tb testing.TB, n int) {
+    bandOnce.Do(startBandWorkers)
+    savedN, savedQ := bandWorkers, bandQueue
+    startBandPool(n)
+    q := bandQueue
+    tb.Cleanup(func() {
+        if q != nil { close(q) }
+        bandWorkers, bandQueue = savedN, savedQ
+    })
+}
+
+// Heights that aren't a multiple of the bands the pool cuts, odd ones
+// included, so the last band is short.
+var bandHeights = []int{97, 250, 719, 1081}
+
+func TestParallelRowsBands(t *testing.T) {
+    withBandWorkers(t, 4)
+    for _, rows := range bandHeights {
+        for _, align := range []int{1, 2} {
+            var mu sync.Mutex
+            var bands [][2]int
+            parallelRows(rows, align, func(r0, r1 int) {
+                mu.Lock()
+                bands = append(bands, [2]int{r0, r1})
+                mu.Unlock()
+            })
+            slices.SortFunc(bands, func(a, b [2]int) int { return a[0] - b[0] })
+            if len(bands) < 2 { t.Errorf("%d rows: %d band, want several", rows, len(bands)) }
+            next := 0
+            for _, b := range bands {
+                if b[0] != next || b[1] <= b[0] || b[0]%align != 0 { t.Fatalf("%d rows, align %d: bands %v", rows, align, bands) }
+                next = b[1]
+            }
+            if next != rows { t.Errorf("%d rows, align %d: bands %v stop at %d", rows, align, bands, next) }
+        }
+    }
+}
+
+// convertCase is one parallel conversion: fill makes the input for a
+// w x h frame, conv converts it and returns the output.
+type convertCase struct {
+    name string
+    fill func(w, h int) []byte
+    conv func(in []byte, w, h int) []byte
+}
+
+func randomBytes(n int) []byte {
+    b := make([]byte, n)
+    rand.New(rand.NewSource(int64(n))).Read(b)
+    return b
+}
+
+// i420Out allocates the three planes of a w x h frame in one buffer,
+// with a chroma row for an odd last row.
+func i420Out(w, h int) (buf, y, u, v []byte) {
+    cw, ch := w/2, (h+1)/2
+    buf = make([]byte, w*h+2*cw*ch)
+    return buf, buf[:w*h], buf[w*h : w*h+cw*ch], buf[w*h+cw*ch:]
+}
+
+var convertCases = []convertCase{
+    {"BGRAtoI420", func(w, h int) []byte { return randomBytes(w * h * 4) }, func(in []byte, w, h int) []byte {
+        buf, y, u, v := i420Out(w, h)
+        BGRAtoI420(in, w, h, y, u, v)
+        return buf
+    }},
+    {"UYVYtoI420", func(w, h int) []byte { return randomBytes(w * h * 2) }, func(in []byte, w, h int) []byte {
+        buf, y, u, v := i420Out(w, h)
+        UYVYtoI420(in, w, h, y, u, v)
+        return buf
+    }},
+    {"I420ToBGRA", func(w, h int) []byte { return randomBytes(w*h + 2*(w/2)*((h+1)/2)) }, func(in []byte, w, h int) []byte {
+        cw, ch := w/2, (h+1)/2
+        out := make([]byte, w*h*4)
+        I420ToBGRA(in[:w*h], in[w*h:w*h+cw*ch], in[w*h+cw*ch:], w, h, out)
+        return out
+    }},
+}
+
+func TestParallelConversionsMatchSerial(t *testing.T) {
+    const w = 96
+    for _, c := range convertCases {
+        for _, h := range bandHeights {
+            in := c.fill(w, h)
+            withBandWorkers(t, 1)
+            serial := c.conv(in, w, h)
+            withBandWorkers(t, 4)
+            if parallel := c.conv(in, w, h); !bytes.Equal(parallel, serial) {
+                i := 0
+                for parallel[i] == serial[i] { i++ }
+                t.Errorf("%s %dx%d: parallel output differs from serial at byte %d: %d, want %d", c.name, w, h, i, parallel[i], serial[i])
+            }
+        }
+    }
+}
+
+// BenchmarkParallelConversions compares a 1080p conversion on one core with
+// one split across the pool; 1080 rows don't fall evenly into its bands.
+func BenchmarkParallelConversions(b *testing.B) {
+    const w, h = 1920, 1080
+    workers := max(runtime.NumCPU(), 2)
+    for _, c := range convertCases {
+        in := c.fill(w, h)
+        for _, n := range []int{1, workers} {
+            b.Run(fmt.Sprintf("%s/workers=%d", c.name, n), func(b *testing.B) {
+                withBandWorkers(b, n)
+                b.SetBytes(int64(len(in)))
+                for i := 0; i < b.N; i++ { c.conv(in, w, h) }
+            })
+        }
+    }
+}
@@ -1,17 +1,16 @@
 package stream
 
 import (
-    "image"
-    "image/png"
+    "errors"
     "math"
-    "os"
+    "sync/atomic"
     "time"
 )
 
 // PipelineConfig defines how to produce encoded video and feed a Pion Track.
 type PipelineConfig struct {
 	Width, Height int
-	FPS           int
+	FPS           Rate
 	BitrateKbps   int // used by VP8/VP9/AV1 pipelines
 	Source        Source
 	// Track expects a Pion track with WriteSample(media.Sample) (e.g., *webrtc.TrackLocalStaticSample).
@@ -19,8 +18,96 @@ type PipelineConfig struct {
 	// Optional VP8 tuning (ignored by other codecs)
 	VP8Speed     int // maps to libvpx VP8E_SET_CPUUSED
 	VP8Dropframe int // maps to rc_dropframe_thresh
+	// Keyint is the most frames between keyframes; 0 for the codec's
+	// default (see EffectiveKeyint)
+	Keyint int
+	// RateControl is RateCBR ("" too) or RateCQ, holding quality CRF with
+	// BitrateKbps as a cap
+	RateControl string
+	CRF         int
+	// VP9 tunes VP9 encoders; nil for DefaultVP9Tuning
+	VP9 *VP9Tuning
+	// AV1 tunes AV1 encoders; nil for DefaultAV1Tuning
+	AV1 *AV1Tuning
+	// MetricsLabel tags this pipeline's frame counters (e.g., the mount key)
+	MetricsLabel string
+	// Queue sizes the async writer between encoder and Track
+	Queue QueueConfig
 }
 
+// autoKeyintSeconds spaces VP8 and H.264 keyframes when no Keyint is set.
+const autoKeyintSeconds = 4
+
+// EffectiveKeyint is the keyframe interval, in frames, codec's encoder runs
+// with for keyint at fps: keyint itself, or for 0 (auto) four seconds'
+// worth on VP8 and H.264. It is 0 where auto leaves the interval to the
+// encoder, as VP9 and AV1 do.
+func EffectiveKeyint(codec string, keyint int, fps Rate) int {
+    if keyint > 0 { return keyint }
+    switch codec {
+    case "vp8", "h264":
+        return max(fps.Round()*autoKeyintSeconds, 1)
+    }
+    return 0
+}
+
+// minForcedKeyframeInterval rate-limits ForceKeyframe so a burst of PLI/FIR
+// from many viewers of one encoder costs a single keyframe.
+const minForcedKeyframeInterval = 500 * time.Millisecond
+
+// keyframeRequest is a pending ForceKeyframe: set from any goroutine and
+// consumed by the pipeline's encode loop.
+type keyframeRequest struct {
+    pending int32
+    last    time.Time // encode loop only
+}
+
+func (k *keyframeRequest) request() { atomic.StoreInt32(&k.pending, 1) }
+
+// due reports whether a request is pending and allowed at now, without
+// taking it.
+func (k *keyframeRequest) due(now time.Time) bool {
+    return atomic.LoadInt32(&k.pending) != 0 && now.Sub(k.last) >= minForcedKeyframeInterval
+}
+
+// take reports whether the next frame should be forced to a keyframe. A
+// request arriving too soon after the last one stays pending until allowed.
+func (k *keyframeRequest) take() bool {
+    now := time.Now()
+    if !k.due(now) { return false }
+    atomic.StoreInt32(&k.pending, 0)
+    k.last = now
+    return true
+}
+
+// ErrBitrateUnsupported is returned by encoders that can't change bitrate
+// while running.
+var ErrBitrateUnsupported = errors.New("encoder can't change bitrate at runtime")
+
+// bitrateRequest carries a target bitrate from SetBitrate to the encode loop.
+type bitrateRequest struct {
+    want    int32 // kbps requested; 0 = none
+    applied int32 // kbps in effect
+}
+
+func (b *bitrateRequest) request(kbps int) { if kbps > 0 { atomic.StoreInt32(&b.want, int32(kbps)) } }
+
+// take returns a new target for the encoder, if one differs from the
+// applied value.
+func (b *bitrateRequest) take() (int, bool) {
+    w := atomic.LoadInt32(&b.want)
+    if w == 0 || w == atomic.LoadInt32(&b.applied) { return 0, false }
+    return int(w), true
+}
+
+func (b *bitrateRequest) set(kbps int) { atomic.StoreInt32(&b.applied, int32(kbps)) }
+
+// reject drops the pending target after the encoder refused it.
+func (b *bitrateRequest) reject() { atomic.StoreInt32(&b.want, atomic.LoadInt32(&b.applied)) }
+
+// current is the bitrate in effect.
+func (b *bitrateRequest) current() int { return int(atomic.LoadInt32(&b.applied)) }
+
 // optional capability: source can advertise its pixel format (e.g., "bgra", "uyvy422")
 type sourcePixFmt interface{ PixFmt() string }
 
@@ -268,44 +355,8 @@ func (s *synthetic) Next() ([]byte, bool) {
     // Optional NDI logo: if assets/NDI.png exists, center it and alpha-blend
     if !s.logoTried && s.logoBuf == nil {
         s.logoTried = true
-        if f, err := os.Open("assets/NDI.png"); err == nil {
-            if img, err2 := png.Decode(f); err2 == nil {
-                b := img.Bounds()
-                lw, lh := b.Dx(), b.Dy()
-                buf := make([]byte, lw*lh*4)
-                switch src := img.(type) {
-                case *image.NRGBA:
-                    stride := src.Stride
-                    for yy := 0; yy < lh; yy++ {
-                        for xx := 0; xx < lw; xx++ {
-                            si := yy*stride + xx*4
-                            r := src.Pix[si+0]
-                            g := src.Pix[si+1]
-                            b0 := src.Pix[si+2]
-                            a := src.Pix[si+3]
-                            di := (yy*lw + xx) * 4
-                            buf[di+0] = b0
-                            buf[di+1] = g
-                            buf[di+2] = r
-                            buf[di+3] = a
-                        }
-                    }
-                default:
-                    for yy := 0; yy < lh; yy++ {
-                        for xx := 0; xx < lw; xx++ {
-                            r, g, b0, a := img.At(xx, yy).RGBA()
-                            di := (yy*lw + xx) * 4
-                            buf[di+0] = byte(b0 >> 8)
-                            buf[di+1] = byte(g >> 8)
-                            buf[di+2] = byte(r >> 8)
-                            buf[di+3] = byte(a >> 8)
-                        }
-                    }
-                }
-                s.logoBuf = buf
-                s.logoW, s.logoH = lw, lh
-            }
-            _ = f.Close()
+        if buf, lw, lh, err := loadImageBGRA("assets/NDI.png"); err == nil {
+            s.logoBuf, s.logoW, s.logoH = buf, lw, lh
         }
     }
     if s.logoBuf != nil && s.logoW > 0 && s.logoH > 0 {
@@ -342,3 +393,25 @@ func (s *synthetic) Next() ([]byte, bool) {
 }
 
 func (s *synthetic) Stop() { s.stop = true }
+
+// IsSynthetic reports whether src renders the built-in pattern (nil counts, as
+// pipelines substitute the synthetic for a missing source).
+func IsSynthetic(src Source) bool {
+	if src == nil {
+		return true
+	}
+	if sw, ok := src.(*SwitchSource); ok {
+		return IsSynthetic(sw.Current())
+	}
+	if as, ok := src.(*AspectSource); ok {
+		return IsSynthetic(as.Inner())
+	}
+	if rs, ok := src.(*RegionSource); ok {
+		return IsSynthetic(rs.Inner())
+	}
+	if ss, ok := src.(*SharedSource); ok {
+		return IsSynthetic(ss.Inner())
+	}
+	_, ok := src.(*synthetic)
+	return ok
+}
@@ -4,6 +4,8 @@ package stream
 
 import "errors"
 
+const av1Lib = "" // not built
+
 // StartAV1Pipeline is unavailable without cgo+aom build tags.
 func StartAV1Pipeline(cfg PipelineConfig) (*PipelineAV1, error) {
     return nil, errors.New("av1 pipeline not available (build without 'aom' tag)")
@@ -12,3 +14,9 @@ func StartAV1Pipeline(cfg PipelineConfig) (*PipelineAV1, error) {
 type PipelineAV1 struct{}
 
 func (p *PipelineAV1) Stop() {}
+
+func (p *PipelineAV1) ForceKeyframe() {}
+
+func (p *PipelineAV1) SetBitrate(kbps int) {}
+
+func (p *PipelineAV1) Bitrate() int { return 0 }
@@ -0,0 +1,17 @@
+package stream
+
+// StartH264Pipeline encodes frames from Source with the QuickSync H.264
+// encoder and feeds a Pion H264 track. It fails if no QSV session can be opened.
+func StartH264Pipeline(cfg PipelineConfig) (*PipelineH264, error) {
+    p, err := startVideoPipeline("h264", cfg, false, openH264)
+    if err != nil { return nil, err }
+    return &PipelineH264{p}, nil
+}
+
+func openH264(c PipelineConfig, kbps int) (Encoder, error) {
+    return NewQSVEncoder(QSVConfig{Width: c.Width, Height: c.Height, FPS: c.FPS, BitrateKbps: kbps, Keyint: c.Keyint})
+}
+
+func init() { encoderOpeners["h264"] = openH264 }
+
+type PipelineH264 struct{ *pipeline }
@@ -0,0 +1,145 @@
+package stream
+
+import (
+    "sync/atomic"
+    "time"
+
+    "github.com/pion/webrtc/v3/pkg/media"
+)
+
+// WebRTC Opus framing: 48 kHz stereo, 20 ms per packet.
+const (
+    opusSampleRate    = 48000
+    opusChannels      = 2
+    opusFrameSamples  = opusSampleRate / 50
+    opusFrameDuration = 20 * time.Millisecond
+)
+
+// AudioFrame is a chunk of interleaved float32 PCM at its native rate.
+type AudioFrame struct {
+    SampleRate int
+    Channels   int
+    Data       []float32
+}
+
+// AudioSource is implemented by sources that also carry audio (e.g., NDISource).
+type AudioSource interface {
+    AudioFrames() <-chan AudioFrame
+}
+
+// AudioPipelineConfig defines how to produce Opus audio and feed a Pion track.
+type AudioPipelineConfig struct {
+    Source      AudioSource
+    BitrateKbps int // default 128
+    // Track expects a Pion track with WriteSample(media.Sample).
+    Track interface{}
+    // Queue sizes the async writer between encoder and Track
+    Queue QueueConfig
+}
+
+// StartOpusPipeline resamples the source's audio to 48 kHz stereo and writes
+// 20 ms Opus samples to the track.
+func StartOpusPipeline(cfg AudioPipelineConfig) (*PipelineOpus, error) {
+    if cfg.Source == nil { return nil, fmtErr("opus pipeline: no audio source") }
+    enc, err := NewOpusEncoder(cfg.BitrateKbps)
+    if err != nil { return nil, err }
+    p := &PipelineOpus{cfg: cfg, enc: enc, quit: make(chan struct{})}
+    registerPipeline("opus")
+    go p.loop()
+    return p, nil
+}
+
+type PipelineOpus struct {
+    cfg AudioPipelineConfig
+    enc *OpusEncoder
+    quit chan struct{}
+    stopped int32 // 0 active, 1 stopped
+}
+
+func (p *PipelineOpus) loop() {
+    defer unregisterPipeline("opus")
+    defer p.enc.Close()
+    enqueue, stopWriter := newAsyncSampleWriter(p.cfg.Track, p.cfg.Queue, nil, nil)
+    defer stopWriter()
+    frames := p.cfg.Source.AudioFrames()
+    var rs stereoResampler
+    // Stamped by the audio sent so far, which no clock change can rewind
+    stamps := newSampleClock(nil)
+    var sent time.Duration
+    pending := make([]float32, 0, opusFrameSamples*opusChannels*4)
+    for {
+        var af AudioFrame
+        select {
+        case <-p.quit:
+            return
+        case af = <-frames:
+        }
+        pending = rs.appendStereo48k(pending, af)
+        for len(pending) >= opusFrameSamples*opusChannels {
+            pkt, err := p.enc.EncodeFloat(pending[:opusFrameSamples*opusChannels])
+            n := copy(pending, pending[opusFrameSamples*opusChannels:])
+            pending = pending[:n]
+            if err != nil { continue }
+            enqueue(media.Sample{Data: pkt, Duration: opusFrameDuration, Timestamp: stamps.offset(sent)})
+            sent += opusFrameDuration
+        }
+    }
+}
+
+func (p *PipelineOpus) Stop() {
+    if p == nil { return }
+    if atomic.CompareAndSwapInt32(&p.stopped, 0, 1) {
+        close(p.quit)
+    }
+}
+
+// stereoResampler converts arbitrary-rate interleaved PCM to 48 kHz stereo
+// with linear interpolation, carrying phase across chunks.
+type stereoResampler struct {
+    rate int
+    pos  float64    // read position relative to the start of the next chunk
+    prev [2]float32 // last input frame of the previous chunk
+    have bool
+}
+
+func (r *stereoResampler) appendStereo48k(dst []float32, af AudioFrame) []float32 {
+    ch := af.Channels
+    if ch <= 0 || af.SampleRate <= 0 || len(af.Data) < ch { return dst }
+    n := len(af.Data) / ch
+    // frame returns stereo sample i (-1 = carried-over previous frame); mono is duplicated, extra channels ignored
+    frame := func(i int) (float32, float32) {
+        if i < 0 { return r.prev[0], r.prev[1] }
+        l := af.Data[i*ch]
+        if ch == 1 { return l, l }
+        return l, af.Data[i*ch+1]
+    }
+    if r.rate != af.SampleRate || !r.have {
+        // (Re)start on the first chunk or a rate change
+        r.rate, r.pos = af.SampleRate, 0
+        r.prev[0], r.prev[1] = frame(0)
+        r.have = true
+    }
+    if af.SampleRate == opusSampleRate {
+        for i := 0; i < n; i++ {
+            l, rr := frame(i)
+            dst = append(dst, l, rr)
+        }
+        r.prev[0], r.prev[1] = frame(n - 1)
+        return dst
+    }
+    step := float64(af.SampleRate) / float64(opusSampleRate)
+    pos := r.pos
+    for pos < float64(n-1) {
+        i := int(pos)
+        if pos < 0 { i = -1 }
+        frac := float32(pos - float64(i))
+        l0, r0 := frame(i)
+        l1, r1 := frame(i + 1)
+        dst = append(dst, l0+(l1-l0)*frac, r0+(r1-r0)*frac)
+        pos += step
+    }
+    // Next chunk's index 0 follows this chunk's last frame, which becomes index -1
+    r.pos = pos - float64(n)
+    r.prev[0], r.prev[1] = frame(n - 1)
+    return dst
+}
@@ -4,6 +4,8 @@ package stream
 
 import "errors"
 
+const vpxLib = "" // not built
+
 // StartVP8Pipeline is unavailable without vpx/cgo build tags.
 func StartVP8Pipeline(cfg PipelineConfig) (*PipelineVP8, error) {
     return nil, errors.New("vp8 pipeline not available (cgo off)")
@@ -12,3 +14,9 @@ func StartVP8Pipeline(cfg PipelineConfig) (*PipelineVP8, error) {
 type PipelineVP8 struct{}
 
 func (p *PipelineVP8) Stop() {}
+
+func (p *PipelineVP8) ForceKeyframe() {}
+
+func (p *PipelineVP8) SetBitrate(kbps int) {}
+
+func (p *PipelineVP8) Bitrate() int { return 0 }
@@ -12,3 +12,9 @@ func StartVP9Pipeline(cfg PipelineConfig) (*PipelineVP9, error) {
 type PipelineVP9 struct{}
 
 func (p *PipelineVP9) Stop() {}
+
+func (p *PipelineVP9) ForceKeyframe() {}
+
+func (p *PipelineVP9) SetBitrate(kbps int) {}
+
+func (p *PipelineVP9) Bitrate() int { return 0 }
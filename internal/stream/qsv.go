@@ -0,0 +1,267 @@
+//go:build cgo && qsv
+
+package stream
+
+/*
+#cgo LDFLAGS: -lvpl
+
+#include <stdlib.h>
+#include <string.h>
+#include <unistd.h>
+#include <vpl/mfx.h>
+
+#define QSV_ALIGN16(x) ((((x) + 15) >> 4) << 4)
+
+typedef struct qsv_enc {
+    mfxLoader loader;
+    mfxSession session;
+    mfxVideoParam par;
+    mfxFrameSurface1 surf;
+    mfxBitstream bs;
+    mfxU8 *surfBuf;
+    int w, h;
+} qsv_enc_t;
+
+static void qsv_close(qsv_enc_t *e) {
+    if (e->session) { MFXVideoENCODE_Close(e->session); MFXClose(e->session); e->session = NULL; }
+    if (e->loader) { MFXUnload(e->loader); e->loader = NULL; }
+    if (e->bs.Data) { free(e->bs.Data); e->bs.Data = NULL; }
+    if (e->surfBuf) { free(e->surfBuf); e->surfBuf = NULL; }
+}
+
+// qsv_open creates a hardware-only oneVPL session with an H.264 encoder
+// configured for low-latency CBR from system-memory NV12 surfaces.
+static int qsv_open(qsv_enc_t *e, int w, int h, int fps_n, int fps_d, int kbps, int gop) {
+    mfxStatus st;
+    memset(e, 0, sizeof(*e));
+    e->w = w; e->h = h;
+    e->loader = MFXLoad();
+    if (!e->loader) return MFX_ERR_NOT_FOUND;
+    mfxVariant v;
+    mfxConfig c1 = MFXCreateConfig(e->loader);
+    v.Type = MFX_VARIANT_TYPE_U32; v.Data.U32 = MFX_IMPL_TYPE_HARDWARE;
+    MFXSetConfigFilterProperty(c1, (const mfxU8 *)"mfxImplDescription.Impl", v);
+    mfxConfig c2 = MFXCreateConfig(e->loader);
+    v.Type = MFX_VARIANT_TYPE_U32; v.Data.U32 = MFX_CODEC_AVC;
+    MFXSetConfigFilterProperty(c2, (const mfxU8 *)"mfxImplDescription.mfxEncoderDescription.encoder.CodecID", v);
+    st = MFXCreateSession(e->loader, 0, &e->session);
+    if (st != MFX_ERR_NONE) { e->session = NULL; qsv_close(e); return st; }
+
+    mfxVideoParam *p = &e->par;
+    p->mfx.CodecId = MFX_CODEC_AVC;
+    p->mfx.CodecProfile = MFX_PROFILE_AVC_CONSTRAINED_BASELINE;
+    p->mfx.TargetUsage = MFX_TARGETUSAGE_BEST_SPEED;
+    p->mfx.RateControlMethod = MFX_RATECONTROL_CBR;
+    p->mfx.TargetKbps = (mfxU16)kbps;
+    p->mfx.GopRefDist = 1; // no B-frames: zero reorder latency
+    p->mfx.GopPicSize = (mfxU16)gop;
+    p->mfx.IdrInterval = 0;
+    p->mfx.NumRefFrame = 1;
+    p->mfx.FrameInfo.FrameRateExtN = fps_n;
+    p->mfx.FrameInfo.FrameRateExtD = fps_d;
+    p->mfx.FrameInfo.FourCC = MFX_FOURCC_NV12;
+    p->mfx.FrameInfo.ChromaFormat = MFX_CHROMAFORMAT_YUV420;
+    p->mfx.FrameInfo.PicStruct = MFX_PICSTRUCT_PROGRESSIVE;
+    p->mfx.FrameInfo.CropW = w;
+    p->mfx.FrameInfo.CropH = h;
+    p->mfx.FrameInfo.Width = QSV_ALIGN16(w);
+    p->mfx.FrameInfo.Height = QSV_ALIGN16(h);
+    p->IOPattern = MFX_IOPATTERN_IN_SYSTEM_MEMORY;
+    p->AsyncDepth = 1;
+    st = MFXVideoENCODE_Init(e->session, p);
+    if (st < MFX_ERR_NONE) { qsv_close(e); return st; }
+
+    mfxVideoParam cur;
+    memset(&cur, 0, sizeof(cur));
+    MFXVideoENCODE_GetVideoParam(e->session, &cur);
+    mfxU32 bsz = (mfxU32)cur.mfx.BufferSizeInKB * 1000 * (cur.mfx.BRCParamMultiplier ? cur.mfx.BRCParamMultiplier : 1);
+    if (bsz == 0) bsz = (mfxU32)(w * h * 3 / 2);
+    e->bs.Data = (mfxU8 *)malloc(bsz);
+    e->bs.MaxLength = bsz;
+
+    int pitch = QSV_ALIGN16(w), ah = QSV_ALIGN16(h);
+    e->surfBuf = (mfxU8 *)calloc(1, (size_t)pitch * ah * 3 / 2);
+    if (!e->bs.Data || !e->surfBuf) { qsv_close(e); return MFX_ERR_MEMORY_ALLOC; }
+    e->surf.Info = p->mfx.FrameInfo;
+    e->surf.Data.Y = e->surfBuf;
+    e->surf.Data.UV = e->surfBuf + (size_t)pitch * ah;
+    e->surf.Data.Pitch = (mfxU16)pitch;
+    return MFX_ERR_NONE;
+}
+
+// qsv_encode submits one NV12 frame and waits for its bitstream. Returns 0 with
+// e->bs.DataLength == 0 when the encoder buffered the frame without output.
+static int qsv_encode(qsv_enc_t *e, const mfxU8 *y, const mfxU8 *uv, int forceKey) {
+    int pitch = e->surf.Data.Pitch;
+    for (int r = 0; r < e->h; r++) memcpy(e->surf.Data.Y + (size_t)r * pitch, y + (size_t)r * e->w, e->w);
+    for (int r = 0; r < e->h / 2; r++) memcpy(e->surf.Data.UV + (size_t)r * pitch, uv + (size_t)r * e->w, e->w);
+    mfxEncodeCtrl ctrl;
+    memset(&ctrl, 0, sizeof(ctrl));
+    ctrl.FrameType = MFX_FRAMETYPE_I | MFX_FRAMETYPE_IDR | MFX_FRAMETYPE_REF;
+    e->bs.DataOffset = 0;
+    e->bs.DataLength = 0;
+    mfxSyncPoint sp = NULL;
+    mfxStatus st;
+    for (;;) {
+        st = MFXVideoENCODE_EncodeFrameAsync(e->session, forceKey ? &ctrl : NULL, &e->surf, &e->bs, &sp);
+        if (st == MFX_WRN_DEVICE_BUSY) { usleep(1000); continue; }
+        break;
+    }
+    if (st == MFX_ERR_MORE_DATA) return MFX_ERR_NONE;
+    if (st < MFX_ERR_NONE) return st;
+    if (sp) {
+        st = MFXVideoCORE_SyncOperation(e->session, sp, 1000);
+        if (st < MFX_ERR_NONE) return st;
+    }
+    return MFX_ERR_NONE;
+}
+
+// qsv_drain pulls one buffered frame by encoding a NULL surface. Returns
+// MFX_ERR_MORE_DATA once the encoder is empty.
+static int qsv_drain(qsv_enc_t *e) {
+    e->bs.DataOffset = 0;
+    e->bs.DataLength = 0;
+    mfxSyncPoint sp = NULL;
+    mfxStatus st;
+    for (;;) {
+        st = MFXVideoENCODE_EncodeFrameAsync(e->session, NULL, NULL, &e->bs, &sp);
+        if (st == MFX_WRN_DEVICE_BUSY) { usleep(1000); continue; }
+        break;
+    }
+    if (st < MFX_ERR_NONE) return st;
+    if (sp) {
+        st = MFXVideoCORE_SyncOperation(e->session, sp, 1000);
+        if (st < MFX_ERR_NONE) return st;
+    }
+    return MFX_ERR_NONE;
+}
+
+// qsv_set_bitrate changes the CBR target in place via Reset.
+static int qsv_set_bitrate(qsv_enc_t *e, int kbps) {
+    e->par.mfx.TargetKbps = (mfxU16)kbps;
+    mfxStatus st = MFXVideoENCODE_Reset(e->session, &e->par);
+    return st < MFX_ERR_NONE ? st : MFX_ERR_NONE;
+}
+
+static int qsv_is_idr(qsv_enc_t *e) { return (e->bs.FrameType & MFX_FRAMETYPE_IDR) != 0; }
+*/
+import "C"
+
+import (
+    "errors"
+    "fmt"
+    "unsafe"
+)
+
+// qsvLib names the QuickSync H.264 encoder in the capability table.
+const qsvLib = "oneVPL"
+
+// QSVEncoder is an Intel QuickSync H.264 encoder (oneVPL) fed with NV12 frames.
+type QSVEncoder struct {
+    e     C.qsv_enc_t
+    w, h  int
+    open  bool
+    force bool
+    last  encodeResult
+    uv    []byte // EncodeI420's interleaved chroma
+}
+
+type QSVConfig struct {
+    Width, Height int
+    FPS           Rate
+    BitrateKbps   int
+    Keyint        int // GopPicSize; 0 for four seconds' worth
+}
+
+func NewQSVEncoder(cfg QSVConfig) (*QSVEncoder, error) {
+    if cfg.Width <= 0 || cfg.Height <= 0 || !cfg.FPS.Valid() {
+        return nil, errors.New("invalid QSV encoder config")
+    }
+    if cfg.BitrateKbps <= 0 { cfg.BitrateKbps = 6000 }
+    if cfg.BitrateKbps > 65535 { cfg.BitrateKbps = 65535 }
+    e := &QSVEncoder{w: cfg.Width, h: cfg.Height}
+    if st := C.qsv_open(&e.e, C.int(cfg.Width), C.int(cfg.Height), C.int(cfg.FPS.Num), C.int(cfg.FPS.Den), C.int(cfg.BitrateKbps), C.int(min(EffectiveKeyint("h264", cfg.Keyint, cfg.FPS), 65535))); st != 0 {
+        return nil, fmt.Errorf("qsv: MFX session/encoder init failed (%dx%d@%sfps, %dkbps): mfxStatus %d", cfg.Width, cfg.Height, cfg.FPS, cfg.BitrateKbps, int(st))
+    }
+    e.open = true
+    // Start the stream on an IDR so late joiners can decode immediately
+    e.force = true
+    return e, nil
+}
+
+// EncodeNV12 encodes a single frame. y should be size w*h, uv (interleaved) w*h/2.
+// Output is Annex-B H.264.
+func (e *QSVEncoder) EncodeNV12(y, uv []byte) (out [][]byte, keyframe bool, err error) {
+    if !e.open { return nil, false, errors.New("encoder closed") }
+    if len(y) < e.w*e.h || len(uv) < e.w*(e.h/2) {
+        return nil, false, errors.New("bad plane sizes")
+    }
+    force := 0
+    if e.force { force = 1; e.force = false }
+    if st := C.qsv_encode(&e.e, (*C.mfxU8)(unsafe.Pointer(&y[0])), (*C.mfxU8)(unsafe.Pointer(&uv[0])), C.int(force)); st != 0 {
+        return nil, false, fmt.Errorf("qsv encode failed: mfxStatus %d", int(st))
+    }
+    if n := int(e.e.bs.DataLength); n > 0 {
+        data := unsafe.Add(unsafe.Pointer(e.e.bs.Data), int(e.e.bs.DataOffset))
+        out = append(out, C.GoBytes(data, C.int(n)))
+        keyframe = C.qsv_is_idr(&e.e) != 0
+    }
+    e.last = encodeOutput
+    if len(out) == 0 { e.last = encodeBuffered }
+    return out, keyframe, nil
+}
+
+// EncodeI420 interleaves u and v into NV12 and encodes the frame. Pipelines
+// call EncodeNV12 directly; this lets QSVEncoder serve as a generic Encoder.
+func (e *QSVEncoder) EncodeI420(y, u, v []byte) ([][]byte, bool, error) {
+    n := (e.w / 2) * (e.h / 2)
+    if len(u) < n || len(v) < n { return nil, false, errors.New("bad plane sizes") }
+    if len(e.uv) != 2*n { e.uv = make([]byte, 2*n) }
+    for i := 0; i < n; i++ { e.uv[2*i], e.uv[2*i+1] = u[i], v[i] }
+    return e.EncodeNV12(y, e.uv)
+}
+
+// Flush drains frames still queued in the session. With GopRefDist 1 and
+// AsyncDepth 1 there is normally nothing left. No frames may be encoded
+// after it; Close must still be called.
+func (e *QSVEncoder) Flush() (out [][]byte, err error) {
+    if !e.open { return nil, nil }
+    for {
+        st := C.qsv_drain(&e.e)
+        if st == C.MFX_ERR_MORE_DATA { return out, nil }
+        if st != 0 { return out, fmt.Errorf("qsv flush failed: mfxStatus %d", int(st)) }
+        n := int(e.e.bs.DataLength)
+        if n == 0 { return out, nil }
+        data := unsafe.Add(unsafe.Pointer(e.e.bs.Data), int(e.e.bs.DataOffset))
+        out = append(out, C.GoBytes(data, C.int(n)))
+    }
+}
+
+// ForceKeyframe makes the next EncodeNV12 produce an IDR frame.
+func (e *QSVEncoder) ForceKeyframe() { e.force = true }
+
+// lastResult reports how the last EncodeNV12 came out. Bitrate control here
+// never skips frames, so no output means MFX_ERR_MORE_DATA: still queued.
+func (e *QSVEncoder) lastResult() encodeResult { return e.last }
+
+// SetBitrate changes the CBR target without reopening the session.
+func (e *QSVEncoder) SetBitrate(kbps int) error {
+    if !e.open { return errors.New("encoder closed") }
+    if kbps > 65535 { kbps = 65535 }
+    if st := C.qsv_set_bitrate(&e.e, C.int(kbps)); st != 0 {
+        return fmt.Errorf("qsv bitrate change failed: mfxStatus %d", int(st))
+    }
+    return nil
+}
+
+func (e *QSVEncoder) Close() {
+    if e.open { C.qsv_close(&e.e); e.open = false }
+}
+
+// probeQSV opens and closes a small session to check that QuickSync is usable.
+func probeQSV() error {
+    e, err := NewQSVEncoder(QSVConfig{Width: 320, Height: 240, FPS: IntRate(30), BitrateKbps: 500})
+    if err != nil { return err }
+    e.Close()
+    return nil
+}
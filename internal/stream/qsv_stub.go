@@ -0,0 +1,37 @@
+//go:build !qsv
+
+package stream
+
+import "errors"
+
+const qsvLib = "" // not built
+
+var errQSVUnavailable = errors.New("qsv encoder not available (build with 'qsv' tag and oneVPL)")
+
+// QSVEncoder is unavailable without cgo+qsv build tags.
+type QSVEncoder struct{}
+
+type QSVConfig struct {
+    Width, Height int
+    FPS           Rate
+    BitrateKbps   int
+    Keyint        int // GopPicSize; 0 for four seconds' worth
+}
+
+func NewQSVEncoder(cfg QSVConfig) (*QSVEncoder, error) { return nil, errQSVUnavailable }
+
+func (e *QSVEncoder) EncodeNV12(y, uv []byte) ([][]byte, bool, error) { return nil, false, errQSVUnavailable }
+
+func (e *QSVEncoder) EncodeI420(y, u, v []byte) ([][]byte, bool, error) { return nil, false, errQSVUnavailable }
+
+func (e *QSVEncoder) Flush() ([][]byte, error) { return nil, nil }
+
+func (e *QSVEncoder) ForceKeyframe() {}
+
+func (e *QSVEncoder) lastResult() encodeResult { return encodeOutput }
+
+func (e *QSVEncoder) SetBitrate(kbps int) error { return errQSVUnavailable }
+
+func (e *QSVEncoder) Close() {}
+
+func probeQSV() error { return errQSVUnavailable }
@@ -0,0 +1,119 @@
+package stream
+
+import (
+    "encoding/json"
+    "fmt"
+    "math"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// Rate is a frame rate as a rational, e.g. 30000/1001 for 29.97 fps, so
+// NTSC-family rates don't drift against the source. The zero value means
+// "unset" and callers substitute their default.
+type Rate struct {
+    Num, Den int
+}
+
+// MinFPS and MaxFPS bound the rates ParseRate returns.
+const (
+    MinFPS = 1
+    MaxFPS = 240
+)
+
+// IntRate returns an integer frame rate n/1.
+func IntRate(n int) Rate { return Rate{Num: n, Den: 1} }
+
+// Valid reports whether r is a positive rate.
+func (r Rate) Valid() bool { return r.Num > 0 && r.Den > 0 }
+
+// Or returns r, or def when r is unset.
+func (r Rate) Or(def Rate) Rate {
+    if r.Valid() { return r }
+    return def
+}
+
+// Float returns the rate in frames per second.
+func (r Rate) Float() float64 {
+    if !r.Valid() { return 0 }
+    return float64(r.Num) / float64(r.Den)
+}
+
+// Round returns the nearest whole fps (at least 1 for a valid rate), for
+// settings that only take integers such as keyframe distance.
+func (r Rate) Round() int {
+    if !r.Valid() { return 0 }
+    if n := int(math.Round(r.Float())); n > 0 { return n }
+    return 1
+}
+
+// Interval is the duration of one frame.
+func (r Rate) Interval() time.Duration {
+    if !r.Valid() { return 0 }
+    return time.Duration(int64(time.Second) * int64(r.Den) / int64(r.Num))
+}
+
+// String renders integer rates as "30" and others as "30000/1001".
+func (r Rate) String() string {
+    if r.Den == 1 || !r.Valid() { return strconv.Itoa(r.Num) }
+    return fmt.Sprintf("%d/%d", r.Num, r.Den)
+}
+
+// ntscRates maps the usual decimal spellings to their exact rationals.
+var ntscRates = map[string]Rate{
+    "23.976": {24000, 1001}, "23.98": {24000, 1001},
+    "29.97": {30000, 1001},
+    "47.952": {48000, 1001}, "47.95": {48000, 1001},
+    "59.94": {60000, 1001},
+    "119.88": {120000, 1001},
+}
+
+// ParseRate accepts "30", "29.97" or "30000/1001". Rates outside
+// MinFPS..MaxFPS are clamped to the nearest bound.
+func ParseRate(s string) (Rate, error) {
+    s = strings.TrimSpace(s)
+    if r, ok := ntscRates[s]; ok { return r, nil }
+    if n, d, ok := strings.Cut(s, "/"); ok {
+        num, err1 := strconv.Atoi(strings.TrimSpace(n))
+        den, err2 := strconv.Atoi(strings.TrimSpace(d))
+        r := Rate{num, den}
+        if err1 != nil || err2 != nil || !r.Valid() { return Rate{}, fmt.Errorf("invalid frame rate %q", s) }
+        if f := r.Float(); f < MinFPS || f > MaxFPS { return IntRate(int(math.Max(MinFPS, math.Min(f, MaxFPS)))), nil }
+        return r.reduce(), nil
+    }
+    f, err := strconv.ParseFloat(s, 64)
+    if err != nil || !(f > 0) { return Rate{}, fmt.Errorf("invalid frame rate %q", s) }
+    f = math.Max(MinFPS, math.Min(f, MaxFPS))
+    if f == math.Trunc(f) { return IntRate(int(f)), nil }
+    return Rate{int(math.Round(f * 1000)), 1000}.reduce(), nil
+}
+
+func (r Rate) reduce() Rate {
+    a, b := r.Num, r.Den
+    for b != 0 { a, b = b, a%b }
+    if a <= 1 { return r }
+    return Rate{r.Num / a, r.Den / a}
+}
+
+// MarshalJSON writes integer rates as numbers and others as "num/den".
+func (r Rate) MarshalJSON() ([]byte, error) {
+    if r.Den == 1 || !r.Valid() { return []byte(strconv.Itoa(r.Num)), nil }
+    return json.Marshal(r.String())
+}
+
+// UnmarshalJSON accepts a number (30, 29.97) or a string ("30000/1001").
+func (r *Rate) UnmarshalJSON(b []byte) error {
+    var s string
+    if err := json.Unmarshal(b, &s); err != nil {
+        s = string(b)
+    }
+    if s == "" || s == "0" || s == "null" {
+        *r = Rate{}
+        return nil
+    }
+    v, err := ParseRate(s)
+    if err != nil { return err }
+    *r = v
+    return nil
+}
@@ -0,0 +1,29 @@
+package stream
+
+import "testing"
+
+func TestParseRate(t *testing.T) {
+    tests := []struct {
+        in   string
+        want Rate
+    }{
+        {"30", IntRate(30)},
+        {"29.97", Rate{30000, 1001}},
+        {"30000/1001", Rate{30000, 1001}},
+        {"60/2", IntRate(30)},
+        {"12.5", Rate{25, 2}},
+        // Out of range either way is clamped, in every form
+        {"1000000/1", IntRate(MaxFPS)},
+        {"1/1000000", IntRate(MinFPS)},
+        {"500", IntRate(MaxFPS)},
+        {"0.001", IntRate(MinFPS)},
+        {"1e300", IntRate(MaxFPS)},
+    }
+    for _, tt := range tests {
+        got, err := ParseRate(tt.in)
+        if err != nil || got != tt.want { t.Errorf("ParseRate(%q) = %v, %v; want %v", tt.in, got, err, tt.want) }
+    }
+    for _, in := range []string{"", "0", "-30", "0/1", "30/0", "30/-1", "x", "NaN", "-Inf"} {
+        if r, err := ParseRate(in); err == nil { t.Errorf("ParseRate(%q) = %v, want an error", in, r) }
+    }
+}
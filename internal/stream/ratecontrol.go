@@ -0,0 +1,54 @@
+package stream
+
+import (
+    "fmt"
+    "strconv"
+    "strings"
+)
+
+// Rate control modes. CBR holds the bitrate; CQ holds a quality level, the
+// CRF, and only caps the bitrate, so still pictures cost little and busy
+// ones may take up to the cap.
+const (
+    RateCBR = "cbr"
+    RateCQ  = "cq"
+)
+
+// DefaultCRF is the CQ quality level when none is set. CRFs are on the
+// 1..MaxCRF quantizer scale libvpx, libaom and SVT-AV1 share; lower is
+// better.
+const (
+    DefaultCRF = 32
+    MaxCRF     = 63
+)
+
+// ParseRateControl reads cbr or cq in any case; "" stays "" for the
+// default.
+func ParseRateControl(v string) (string, error) {
+    switch m := strings.ToLower(strings.TrimSpace(v)); m {
+    case "", RateCBR, RateCQ:
+        return m, nil
+    }
+    return "", fmt.Errorf("rc must be %s or %s", RateCBR, RateCQ)
+}
+
+// ParseCRF reads a CQ quality level, 1..MaxCRF; "" is 0 for the default.
+func ParseCRF(v string) (int, error) {
+    if v == "" { return 0, nil }
+    n, err := strconv.Atoi(strings.TrimSpace(v))
+    if err != nil || n < 1 || n > MaxCRF { return 0, fmt.Errorf("crf must be 1..%d", MaxCRF) }
+    return n, nil
+}
+
+// cqLevel is crf, or DefaultCRF for 0, within 1..MaxCRF.
+func cqLevel(crf int) int {
+    if crf <= 0 { return DefaultCRF }
+    return min(crf, MaxCRF)
+}
+
+// EffectiveRateControl is the mode codec's encoder runs in for rc: CQ on
+// VP8, VP9 and AV1, which have it, and CBR otherwise.
+func EffectiveRateControl(codec, rc string) string {
+    if rc == RateCQ && codec != "h264" { return RateCQ }
+    return RateCBR
+}
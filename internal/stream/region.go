@@ -0,0 +1,247 @@
+package stream
+
+import (
+    "fmt"
+    "log"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+)
+
+// Region is a rectangle of a source frame, in source pixels. The zero
+// Region is the whole frame.
+type Region struct{ X, Y, W, H int }
+
+// ParseRegion reads "x,y,w,h". Offsets and sizes are rounded down to even,
+// as I420 needs, and the size must come to at least 2x2.
+func ParseRegion(s string) (Region, error) {
+    parts := strings.Split(s, ",")
+    if len(parts) != 4 { return Region{}, fmt.Errorf("region must be x,y,w,h") }
+    var n [4]int
+    for i, p := range parts {
+        v, err := strconv.Atoi(strings.TrimSpace(p))
+        if err != nil || v < 0 { return Region{}, fmt.Errorf("region must be x,y,w,h in pixels") }
+        n[i] = v &^ 1
+    }
+    r := Region{X: n[0], Y: n[1], W: n[2], H: n[3]}
+    if r.W < 2 || r.H < 2 { return Region{}, fmt.Errorf("region must be at least 2x2") }
+    return r, nil
+}
+
+func (r Region) String() string { return fmt.Sprintf("%d,%d,%d,%d", r.X, r.Y, r.W, r.H) }
+
+// Empty reports whether r is the zero Region.
+func (r Region) Empty() bool { return r.W == 0 || r.H == 0 }
+
+// clip is the part of r inside an even w x h frame; the zero Region clips
+// to the whole frame. The result is empty when r lies outside it.
+func (r Region) clip(w, h int) Region {
+    if r.Empty() { return Region{W: w, H: h} }
+    x1, y1 := min(r.X+r.W, w), min(r.Y+r.H, h)
+    if r.X >= x1 || r.Y >= y1 { return Region{} }
+    return Region{X: r.X, Y: r.Y, W: x1 - r.X, H: y1 - r.Y}
+}
+
+// RegionSource crops a region out of each of a source's frames and scales
+// it to a fixed output size, delivering I420. Several can read one source,
+// which fans its frames out to each: split encode runs a downscaled full
+// frame and a detail crop off one receiver. A region running past the
+// frame's edge, e.g. after the source shrinks, is clipped and the rest
+// stretched to the output size, logged once per source size.
+type RegionSource struct {
+    src    Source
+    region Region
+    filter string // scale filter; "" for the default
+    orient Orientation
+
+    mu     sync.Mutex
+    w, h   int    // output size; 0 to derive it from the region or frame
+    in     *byte  // first byte of the last source frame rendered, to skip repeats
+    last   Frame
+    packed []byte // last as BGRA, made when Next or Last asks for it
+    // I420 scratch: packed source converted, cropped region, turned region
+    sy, su, sv []byte
+    ty, tu, tv []byte
+    ry, ru, rv []byte
+    logged   FrameSize // source size a clipped region was last logged for
+    sizes    sizeNotifier
+    frames   frameNotifier
+    pumpOnce sync.Once
+}
+
+// NewRegionSource wraps src to deliver region r (the zero Region for the
+// whole frame) at w x h, scaled with filter ("" for the default). With w or
+// h <= 0 the output is the region's size, or for the whole frame each
+// frame's own, turned as SetOrientation says.
+func NewRegionSource(src Source, r Region, w, h int, filter string) *RegionSource {
+    if w > 0 && h > 0 { w, h = max(w&^1, 2), max(h&^1, 2) } else { w, h = 0, 0 }
+    return &RegionSource{src: src, region: r, filter: filter, w: w, h: h}
+}
+
+// SetOrientation turns the region after it is cropped, before it is
+// scaled to the output size. Call it before the first frame.
+func (s *RegionSource) SetOrientation(o Orientation) {
+    s.mu.Lock()
+    s.orient = o
+    s.mu.Unlock()
+}
+
+// Inner returns the wrapped source.
+func (s *RegionSource) Inner() Source { return s.src }
+
+// Region reports the region s crops.
+func (s *RegionSource) Region() Region { return s.region }
+
+// NextI420 renders the source's latest frame, if new, and returns the
+// latest output.
+func (s *RegionSource) NextI420() (Frame, bool) {
+    if !s.update() { return Frame{}, false }
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    return s.last, s.last.W > 0
+}
+
+// Next is NextI420's frame as BGRA, for callers without I420 support.
+func (s *RegionSource) Next() ([]byte, bool) {
+    if !s.update() { return nil, false }
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    return s.packedLocked(), true
+}
+
+// update renders the source's latest frame unless it was the last one
+// rendered. It is false once the source has stopped.
+func (s *RegionSource) update() bool {
+    f, ok := pullFrame(s.src, PixFmtOf(s.src))
+    if !ok { return false }
+    in := firstByte(f)
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    if in == nil || in == s.in { return true }
+    if out, ok := s.render(f); ok { s.in, s.last, s.packed = in, out, nil }
+    return true
+}
+
+func firstByte(f Frame) *byte {
+    if f.PixFmt == "i420" && len(f.Y) > 0 { return &f.Y[0] }
+    if len(f.Data) > 0 { return &f.Data[0] }
+    return nil
+}
+
+func (s *RegionSource) packedLocked() []byte {
+    if s.packed == nil && s.last.W > 0 { s.packed, _ = s.last.Packed() }
+    return s.packed
+}
+
+// render crops and scales f into a new output frame. Caller holds s.mu.
+func (s *RegionSource) render(f Frame) (Frame, bool) {
+    var y, u, v []byte
+    var cs ColorSpace
+    sw, sh := f.W&^1, f.H&^1
+    if f.PixFmt == "i420" {
+        // Planes are read in place; an odd width has no even stride to
+        // crop by
+        if f.W != sw || f.H < 2 { return Frame{}, false }
+        y, u, v, cs = f.Y, f.U, f.V, f.Color.For(f.W, f.H)
+    } else {
+        var ok bool
+        s.sy, s.su, s.sv, cs, ok = packedToI420(f.Data, f.W, f.H, f.PixFmt, s.sy, s.su, s.sv)
+        if !ok { return Frame{}, false }
+        y, u, v, cs = s.sy, s.su, s.sv, cs.For(sw, sh)
+    }
+    c := s.region.clip(sw, sh)
+    if !s.region.Empty() && c != s.region && s.logged != (FrameSize{sw, sh}) {
+        s.logged = FrameSize{sw, sh}
+        if c.W < 2 || c.H < 2 {
+            log.Printf("Region %s lies outside the %dx%d source; holding the last frame", s.region, sw, sh)
+        } else {
+            log.Printf("Region %s clamped to %s for the %dx%d source", s.region, c, sw, sh)
+        }
+    }
+    if c.W < 2 || c.H < 2 { return Frame{}, false }
+    ow, oh := s.w, s.h
+    if ow == 0 {
+        ow, oh = c.W, c.H
+        if !s.region.Empty() { ow, oh = s.region.W, s.region.H }
+        ow, oh = s.orient.Size(ow, oh)
+    }
+    out := newI420Frame(ow, oh)
+    out.At, out.Color, out.Seq = f.At, cs, f.Seq
+    if c.W != sw || c.H != sh {
+        s.ty, s.tu, s.tv = i420Planes(s.ty, s.tu, s.tv, c.W, c.H)
+        copyI420Rect(y, u, v, sw, c.X, c.Y, s.ty, s.tu, s.tv, c.W, 0, 0, c.W, c.H)
+        y, u, v = s.ty, s.tu, s.tv
+    }
+    tw, th := c.W, c.H
+    if !s.orient.IsZero() {
+        tw, th = s.orient.Size(c.W, c.H)
+        s.ry, s.ru, s.rv = i420Planes(s.ry, s.ru, s.rv, tw, th)
+        orientI420(y, u, v, c.W, c.H, s.ry, s.ru, s.rv, s.orient)
+        y, u, v = s.ry, s.ru, s.rv
+    }
+    if tw == ow && th == oh {
+        copy(out.Y, y); copy(out.U, u); copy(out.V, v)
+    } else {
+        I420ScaleFilter(y, u, v, tw, th, out.Y, out.U, out.V, ow, oh, s.filter)
+    }
+    s.sizes.publish(ow, oh)
+    return out, true
+}
+
+// Frames delivers each of the source's frames cropped and scaled, when the
+// source pushes frames (HasFrames).
+func (s *RegionSource) Frames() <-chan Frame {
+    s.pumpOnce.Do(func() { go s.pump() })
+    return s.frames.subscribe()
+}
+
+// StopFrames ends a Frames subscription and closes its channel.
+func (s *RegionSource) StopFrames(ch <-chan Frame) { s.frames.unsubscribe(ch) }
+
+// pump renders the source's frames until it stops.
+func (s *RegionSource) pump() {
+    defer s.frames.close()
+    ch, stop, ok := Frames(s.src)
+    if !ok { return }
+    defer stop()
+    for f := range ch {
+        s.mu.Lock()
+        var out Frame
+        var ok bool
+        if f.Seq != 0 && f.Seq == s.last.Seq {
+            // A repeated picture comes out as it did last time
+            out, ok = s.last, true
+            out.At = f.At
+        } else if out, ok = s.render(f); ok {
+            s.in, s.last, s.packed = firstByte(f), out, nil
+        }
+        s.mu.Unlock()
+        if ok { s.frames.publish(out) }
+    }
+}
+
+// Last reports the latest output frame as BGRA.
+func (s *RegionSource) Last() ([]byte, int, int, bool) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    if s.last.W == 0 { return nil, 0, 0, false }
+    return s.packedLocked(), s.last.W, s.last.H, true
+}
+
+// PixFmt is Next's, BGRA; NextI420 and Frames deliver I420.
+func (s *RegionSource) PixFmt() string { return "bgra" }
+
+func (s *RegionSource) LastFrameAt() (time.Time, bool) { return LastFrameAt(s.src) }
+
+// FrameRate is the source's; every frame is kept.
+func (s *RegionSource) FrameRate() (num, den int) {
+    r, _ := SourceRate(s.src, 0)
+    return r.Num, r.Den
+}
+
+// SizeChanges announces the output size, which only changes with the
+// frame's when the whole frame is delivered at its own size.
+func (s *RegionSource) SizeChanges() <-chan FrameSize { return s.sizes.subscribe() }
+
+func (s *RegionSource) Stop() { s.src.Stop(); s.sizes.close() }
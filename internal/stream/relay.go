@@ -0,0 +1,115 @@
+package stream
+
+import (
+    "fmt"
+    "strings"
+    "time"
+
+    "github.com/pion/rtp"
+    "github.com/pion/rtp/codecs"
+    "github.com/pion/webrtc/v3/pkg/media"
+    "github.com/pion/webrtc/v3/pkg/media/samplebuilder"
+)
+
+// Relaying. A stream published to the server (WHIP) arrives as RTP that is
+// already encoded. Its frames are rebuilt from the packets and go to
+// viewers as they are, tagged keyframe or not like the encoders' samples,
+// so broadcaster sinks recover from a lost frame the same way.
+
+// How many packets a frame may wait for a missing one before it is given
+// up: enough for a 1080p keyframe, and a few Opus packets.
+const (
+    relayVideoMaxLate = 512
+    relayAudioMaxLate = 50
+)
+
+// RelayCodec is the codec name for a WebRTC MIME type, e.g. "vp8" for
+// video/VP8, and whether RelayRTP can rebuild it: VP8, VP9, H.264 or Opus.
+func RelayCodec(mime string) (string, bool) {
+    switch strings.ToLower(mime) {
+    case "video/vp8": return "vp8", true
+    case "video/vp9": return "vp9", true
+    case "video/h264": return "h264", true
+    case "audio/opus": return "opus", true
+    }
+    return "", false
+}
+
+// RelayRTP rebuilds codec frames from the packets read returns and writes
+// them to out, stamped with when they were complete, until read or out
+// fails; it returns that error. clockRate is the RTP clock, for the
+// samples' durations. Video starts at a keyframe; after packets are lost,
+// delta frames are left out until the next one, which onLoss asks for.
+func RelayRTP(codec string, clockRate uint32, read func() (*rtp.Packet, error), out interface{ WriteSample(media.Sample) error }, onLoss func()) error {
+    var dep rtp.Depacketizer
+    maxLate := uint16(relayVideoMaxLate)
+    switch codec {
+    case "vp8": dep = &codecs.VP8Packet{}
+    case "vp9": dep = &codecs.VP9Packet{}
+    case "h264": dep = &codecs.H264Packet{}
+    case "opus": dep, maxLate = &codecs.OpusPacket{}, relayAudioMaxLate
+    default: return fmt.Errorf("can't relay %s", codec)
+    }
+    sb := samplebuilder.New(maxLate, dep, clockRate)
+    waitKey := codec != "opus"
+    for {
+        pkt, err := read()
+        if err != nil { return err }
+        sb.Push(pkt)
+        for sm := sb.Pop(); sm != nil; sm = sb.Pop() {
+            frame := media.Sample{Data: sm.Data, Duration: sm.Duration, Timestamp: time.Now()}
+            if codec != "opus" {
+                key := relayKeyframe(codec, sm.Data)
+                if sm.PrevDroppedPackets > 0 && !key && !waitKey {
+                    waitKey = true
+                    if onLoss != nil { onLoss() }
+                }
+                if waitKey && !key { continue }
+                waitKey = false
+                frame = videoSample(sm.Data, sm.Duration, frame.Timestamp, key)
+            }
+            if err := out.WriteSample(frame); err != nil { return err }
+        }
+    }
+}
+
+// relayKeyframe reports whether frame, a whole encoded frame, decodes on
+// its own.
+func relayKeyframe(codec string, frame []byte) bool {
+    if len(frame) == 0 { return false }
+    switch codec {
+    case "vp8":
+        // The frame tag's low bit is the frame type, 0 for a key frame
+        return frame[0]&0x01 == 0
+    case "vp9":
+        return vp9Keyframe(frame[0])
+    case "h264":
+        return h264Keyframe(frame)
+    }
+    return false
+}
+
+// vp9Keyframe reads the frame type from the first byte of a VP9
+// uncompressed header: frame_marker (2 bits, 0b10), profile_low_bit,
+// profile_high_bit, a reserved zero bit in profile 3, show_existing_frame
+// and frame_type, 0 for a key frame. Superframes start with the header of
+// their first frame, which is the one that counts.
+func vp9Keyframe(b byte) bool {
+    if b>>6 != 2 { return false }
+    bit := 4 // from the top, past the marker and profile
+    if (b>>5)&1 == 1 && (b>>4)&1 == 1 { bit++ }
+    if (b>>(7-bit))&1 == 1 { return false } // shows an earlier frame
+    bit++
+    return (b>>(7-bit))&1 == 0
+}
+
+// h264Keyframe reports whether an Annex B access unit holds an IDR slice.
+func h264Keyframe(au []byte) bool {
+    zeros := 0
+    for i, b := range au {
+        if b == 0 { zeros++; continue }
+        if b == 1 && zeros >= 2 && i+1 < len(au) && au[i+1]&0x1f == 5 { return true }
+        zeros = 0
+    }
+    return false
+}
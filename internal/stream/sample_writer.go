@@ -1,12 +1,16 @@
 package stream
 
 import (
+    "log"
+    "time"
+
     "github.com/pion/webrtc/v3/pkg/media"
 )
 
 // asyncSampleWriter provides a small buffered, asynchronous wrapper around
 // TrackLocalStaticSample.WriteSample so encoder loops don't block on network
-// backpressure. Writes are best-effort; if the queue is full, the sample is dropped.
+// backpressure. Writes are best-effort; if the queue is full, q.Policy picks
+// whether the new sample or the oldest queued one is dropped.
 type asyncSampleWriter struct {
     ch   chan media.Sample
     quit chan struct{}
@@ -15,31 +19,119 @@ type asyncSampleWriter struct {
 // newAsyncSampleWriter starts a writer goroutine if the provided track supports
 // WriteSample(media.Sample) and returns a non-blocking enqueue function along
 // with a stop function. If the track doesn't implement WriteSample, enqueues
-// will be treated as no-ops and return false.
-func newAsyncSampleWriter(track interface{}) (enqueue func(media.Sample) bool, stop func()) {
+// will be treated as no-ops and return false; otherwise enqueue returns false
+// when it dropped the sample it was given. stop writes out what is still
+// queued and returns once the goroutine has exited, so the caller may write
+// to the track directly afterwards. Once discard (if not nil) reports true,
+// queued samples are dropped instead of written, and stop drops the rest.
+// onLost (if not nil) is called when a video sample is dropped, breaking
+// every viewer's GOP, e.g. to ask the encoder for a keyframe.
+func newAsyncSampleWriter(track interface{}, q QueueConfig, discard func() bool, onLost func()) (enqueue func(media.Sample) bool, stop func()) {
     w, ok := track.(interface{ WriteSample(media.Sample) error })
     if !ok {
         // No-op implementation
         return func(media.Sample) bool { return false }, func() {}
     }
-    aw := &asyncSampleWriter{ ch: make(chan media.Sample, 4), quit: make(chan struct{}) }
+    if discard == nil { discard = func() bool { return false } }
+    if onLost == nil { onLost = func() {} }
+    aw := &asyncSampleWriter{ ch: make(chan media.Sample, q.depth()), quit: make(chan struct{}) }
+    done := make(chan struct{})
     go func() {
+        defer close(done)
         for {
             select {
             case s := <-aw.ch:
+                if discard() { return }
                 _ = w.WriteSample(s)
             case <-aw.quit:
+                for !discard() {
+                    select {
+                    case s := <-aw.ch:
+                        _ = w.WriteSample(s)
+                    default:
+                        return
+                    }
+                }
                 return
             }
         }
     }()
     return func(s media.Sample) bool {
-        select {
-        case aw.ch <- s:
-            return true
-        default:
-            return false
+        key, video := sampleKeyframe(s)
+        for {
+            select {
+            case aw.ch <- s:
+                return true
+            default:
+            }
+            if q.Policy != SinkDropOldest {
+                if video { onLost() }
+                return false
+            }
+            // Evict the head to make room, then retry. A keyframe coming
+            // in starts a new GOP itself
+            select {
+            case old := <-aw.ch:
+                if _, oldVideo := sampleKeyframe(old); oldVideo && !(video && key) { onLost() }
+            default:
+            }
         }
-    }, func() { close(aw.quit) }
+    }, func() { close(aw.quit); <-done }
+}
+
+// flushEncoder drains an encoder's delayed frames (lag-in-frames, alt-refs,
+// SVT's lookahead) on pipeline stop and writes them to the track. It must run
+// after the async writer has stopped and before the encoder is closed.
+func flushEncoder(enc interface{ Flush() ([][]byte, error) }, track interface{}, dur time.Duration, stamps *sampleClock, inputs *inputTimings, mc *pipelineCounters) {
+    packets, err := enc.Flush()
+    if err != nil { log.Printf("encoder flush: %v", err) }
+    var infos []packetInfo
+    if pr, ok := enc.(packetReporter); ok { infos = pr.lastPackets() }
+    w, ok := track.(interface{ WriteSample(media.Sample) error })
+    sent := 0
+    for i, au := range packets {
+        mc.incFramesEncoded()
+        ts, d, key := stamps.now(), dur, false
+        if i < len(infos) { ts, d, key = inputs.stamp(infos[i], ts, d) }
+        if ok && w.WriteSample(videoSample(au, d, ts, key)) == nil { sent++ }
+    }
+    mc.incSamplesSent(sent)
 }
 
+// packetInfo is what an encoder whose output trails its input says about
+// one packet: the input frame it holds, numbered by encode call from 1,
+// and whether it is a keyframe.
+type packetInfo struct {
+    frame int64
+    key   bool
+}
+
+// inputTimings remembers the stamp and duration of the frames most
+// recently sent to an encoder, so a packet that comes out calls later,
+// after SVT-AV1's lookahead say, is stamped as its own frame rather than
+// the one that happened to push it out.
+type inputTimings struct {
+    n    int64
+    ring [256]inputTiming
+}
+
+type inputTiming struct {
+    frame int64
+    ts    time.Time
+    dur   time.Duration
+}
+
+// add records the next frame sent.
+func (t *inputTimings) add(ts time.Time, dur time.Duration) {
+    t.n++
+    t.ring[t.n%int64(len(t.ring))] = inputTiming{frame: t.n, ts: ts, dur: dur}
+}
+
+// stamp is the timestamp, duration and keyframe flag for a packet
+// holding p's frame, or ts and dur when that frame is too old to recall.
+func (t *inputTimings) stamp(p packetInfo, ts time.Time, dur time.Duration) (time.Time, time.Duration, bool) {
+    if p.frame > 0 {
+        if in := t.ring[p.frame%int64(len(t.ring))]; in.frame == p.frame { return in.ts, in.dur, p.key }
+    }
+    return ts, dur, p.key
+}
@@ -0,0 +1,44 @@
+package stream
+
+import (
+    "sync/atomic"
+    "testing"
+
+    "github.com/pion/webrtc/v3/pkg/media"
+)
+
+// stalledWriter starts an async writer with a 2-deep queue on a sink whose
+// write of sample 1, a keyframe, hangs until release. It counts onLost calls.
+func stalledWriter(t *testing.T, policy SinkPolicy) (func(media.Sample) bool, *stallSink, *atomic.Int32) {
+    t.Helper()
+    ss := newStallSink()
+    lost := &atomic.Int32{}
+    enqueue, stop := newAsyncSampleWriter(ss, QueueConfig{Depth: 2, Policy: policy}, nil, func() { lost.Add(1) })
+    t.Cleanup(func() { ss.release(); stop() })
+    if !enqueue(keySample(1)) { t.Fatal("first sample dropped") }
+    <-ss.took
+    return enqueue, ss, lost
+}
+
+func TestWriterDropNewest(t *testing.T) {
+    enqueue, ss, lost := stalledWriter(t, SinkDropNewest)
+    if !enqueue(deltaSample(2)) || !enqueue(deltaSample(3)) { t.Fatal("queued sample dropped") }
+    if enqueue(deltaSample(4)) { t.Fatal("sample beyond the queue accepted") }
+    if enqueue(audioSample(5)) { t.Fatal("sample beyond the queue accepted") }
+    if n := lost.Load(); n != 1 { t.Fatalf("%d losses reported, want 1 (audio isn't)", n) }
+    ss.release()
+    if got := ss.wait(t, 3); !equalBytes(got, []byte{1, 2, 3}) { t.Fatalf("got %v", got) }
+}
+
+func TestWriterDropOldest(t *testing.T) {
+    enqueue, ss, lost := stalledWriter(t, SinkDropOldest)
+    for i := byte(2); i <= 4; i++ {
+        if !enqueue(deltaSample(i)) { t.Fatalf("sample %d dropped", i) }
+    }
+    if n := lost.Load(); n != 1 { t.Fatalf("%d losses reported, want 1", n) }
+    // A keyframe evicting a delta frame needs no other
+    if !enqueue(keySample(5)) { t.Fatal("keyframe dropped") }
+    if n := lost.Load(); n != 1 { t.Fatalf("%d losses reported, want 1", n) }
+    ss.release()
+    if got := ss.wait(t, 3); !equalBytes(got, []byte{1, 4, 5}) { t.Fatalf("got %v", got) }
+}
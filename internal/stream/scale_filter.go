@@ -0,0 +1,51 @@
+package stream
+
+import (
+    "fmt"
+    "os"
+    "strings"
+    "sync"
+    "sync/atomic"
+)
+
+// Scale filters, named as libyuv names them. "" is the server default:
+// YUV_SCALE_FILTER's, else box with libyuv and none in pure-Go builds.
+const (
+    ScaleNone     = "none"     // nearest neighbour
+    ScaleLinear   = "linear"   // interpolate along rows only
+    ScaleBilinear = "bilinear" // interpolate both ways
+    ScaleBox      = "box"      // average the covered pixels downscaling, else bilinear
+)
+
+// ParseScaleFilter validates a scale filter, in any case; "" stays "" for
+// the server default.
+func ParseScaleFilter(v string) (string, error) {
+    switch f := strings.ToLower(strings.TrimSpace(v)); f {
+    case "", ScaleNone, ScaleLinear, ScaleBilinear, ScaleBox:
+        return f, nil
+    }
+    return "", fmt.Errorf("filter must be %s, %s, %s or %s", ScaleNone, ScaleLinear, ScaleBilinear, ScaleBox)
+}
+
+// scaleFilterSet is SetDefaultScaleFilter's filter; nil until it is called.
+var scaleFilterSet atomic.Pointer[string]
+
+// envScaleFilter is YUV_SCALE_FILTER's filter, read once.
+var envScaleFilter = sync.OnceValue(func() string {
+    f, _ := ParseScaleFilter(os.Getenv("YUV_SCALE_FILTER"))
+    return f
+})
+
+// SetDefaultScaleFilter makes f (as ParseScaleFilter returns it; "" for the
+// build's default) the filter "" scales with, from the next frame on, e.g.
+// on a runtime config change.
+func SetDefaultScaleFilter(f string) { scaleFilterSet.Store(&f) }
+
+// DefaultScaleFilter is the filter "" scales with: SetDefaultScaleFilter's,
+// else YUV_SCALE_FILTER's, else the build's default. It is read per frame.
+func DefaultScaleFilter() string {
+    f := envScaleFilter()
+    if p := scaleFilterSet.Load(); p != nil { f = *p }
+    if f != "" { return f }
+    return defaultScaleFilter
+}
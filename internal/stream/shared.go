@@ -0,0 +1,146 @@
+package stream
+
+import (
+    "sync"
+    "time"
+)
+
+// SharedSource is one of several handles on one source, e.g. crop mounts
+// each serving a quadrant of a single multiview receiver. Every handle
+// reads the same frames and has its own Stop; the source stops with the
+// last handle. Audio is fanned out so each handle gets every frame.
+type SharedSource struct {
+    sh   *sharedSource
+    once sync.Once
+
+    mu    sync.Mutex
+    audio chan AudioFrame
+}
+
+type sharedSource struct {
+    src  Source
+    quit chan struct{}
+
+    mu        sync.Mutex
+    refs      int
+    audioSubs map[*SharedSource]chan AudioFrame
+    audioOn   bool
+}
+
+// NewSharedSource returns the first handle on src.
+func NewSharedSource(src Source) *SharedSource {
+    sh := &sharedSource{src: src, quit: make(chan struct{}), refs: 1, audioSubs: map[*SharedSource]chan AudioFrame{}}
+    return &SharedSource{sh: sh}
+}
+
+// Share returns another handle on the same source; ok is false once every
+// handle has stopped, and with it the source.
+func (h *SharedSource) Share() (*SharedSource, bool) {
+    h.sh.mu.Lock()
+    defer h.sh.mu.Unlock()
+    if h.sh.refs == 0 { return nil, false }
+    h.sh.refs++
+    return &SharedSource{sh: h.sh}, true
+}
+
+// Live reports whether any handle still holds the source.
+func (h *SharedSource) Live() bool {
+    h.sh.mu.Lock()
+    defer h.sh.mu.Unlock()
+    return h.sh.refs > 0
+}
+
+// Inner returns the shared source.
+func (h *SharedSource) Inner() Source { return h.sh.src }
+
+// Stop releases this handle, once; the last one stops the source.
+func (h *SharedSource) Stop() {
+    h.once.Do(func() {
+        sh := h.sh
+        sh.mu.Lock()
+        delete(sh.audioSubs, h)
+        sh.refs--
+        last := sh.refs == 0
+        sh.mu.Unlock()
+        if last {
+            close(sh.quit)
+            sh.src.Stop()
+        }
+    })
+}
+
+func (h *SharedSource) Next() ([]byte, bool) { return h.sh.src.Next() }
+
+func (h *SharedSource) NextI420() (Frame, bool) {
+    if s, ok := h.sh.src.(sourceWithI420); ok { return s.NextI420() }
+    return Frame{}, false
+}
+
+func (h *SharedSource) Last() ([]byte, int, int, bool) {
+    if l, ok := h.sh.src.(sourceWithLast); ok { return l.Last() }
+    return nil, 0, 0, false
+}
+
+func (h *SharedSource) PixFmt() string { return PixFmtOf(h.sh.src) }
+
+func (h *SharedSource) LastFrameAt() (time.Time, bool) { return LastFrameAt(h.sh.src) }
+
+func (h *SharedSource) FrameRate() (num, den int) {
+    r, _ := SourceRate(h.sh.src, 0)
+    return r.Num, r.Den
+}
+
+// SizeChanges is the source's; nil, never firing, for one that doesn't
+// announce sizes.
+func (h *SharedSource) SizeChanges() <-chan FrameSize {
+    ch, _ := SizeChanges(h.sh.src)
+    return ch
+}
+
+// Frames subscribes to the source's frames; each handle may hold its own
+// subscriptions. Only valid when HasFrames.
+func (h *SharedSource) Frames() <-chan Frame { return h.sh.src.(sourceWithFrames).Frames() }
+
+func (h *SharedSource) StopFrames(ch <-chan Frame) { h.sh.src.(sourceWithFrames).StopFrames(ch) }
+
+// AudioFrames is this handle's copy of the source's audio; nil, never
+// delivering, for a source without audio.
+func (h *SharedSource) AudioFrames() <-chan AudioFrame {
+    as, ok := h.sh.src.(AudioSource)
+    if !ok { return nil }
+    h.mu.Lock()
+    defer h.mu.Unlock()
+    if h.audio != nil { return h.audio }
+    h.audio = make(chan AudioFrame, 32)
+    sh := h.sh
+    sh.mu.Lock()
+    sh.audioSubs[h] = h.audio
+    start := !sh.audioOn
+    sh.audioOn = true
+    sh.mu.Unlock()
+    if start { go sh.fanAudio(as.AudioFrames()) }
+    return h.audio
+}
+
+// fanAudio copies the source's audio to every handle that asked for it,
+// dropping frames for one that falls behind, as the source itself does.
+func (sh *sharedSource) fanAudio(in <-chan AudioFrame) {
+    for {
+        var af AudioFrame
+        var ok bool
+        select {
+        case <-sh.quit:
+            return
+        case af, ok = <-in:
+            if !ok { return }
+        }
+        sh.mu.Lock()
+        for _, ch := range sh.audioSubs {
+            select {
+            case ch <- af:
+            default:
+            }
+        }
+        sh.mu.Unlock()
+    }
+}
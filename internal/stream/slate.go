@@ -0,0 +1,220 @@
+package stream
+
+import (
+    "errors"
+    "fmt"
+    "image"
+    _ "image/jpeg" // slates may be JPEG
+    _ "image/png"
+    "log"
+    "os"
+    "sync"
+    "sync/atomic"
+    "time"
+)
+
+// slateCheck is how often a slate looks for a new version of its file.
+const slateCheck = time.Second
+
+// loadImageBGRA decodes the PNG or JPEG at path to straight-alpha BGRA.
+func loadImageBGRA(path string) (buf []byte, w, h int, err error) {
+    f, err := os.Open(path)
+    if err != nil { return nil, 0, 0, err }
+    defer f.Close()
+    img, _, err := image.Decode(f)
+    if err != nil { return nil, 0, 0, fmt.Errorf("%s: %w", path, err) }
+    b := img.Bounds()
+    w, h = b.Dx(), b.Dy()
+    buf = make([]byte, w*h*4)
+    switch src := img.(type) {
+    case *image.NRGBA:
+        for y := 0; y < h; y++ {
+            row := src.Pix[y*src.Stride:]
+            for x := 0; x < w; x++ {
+                si, di := x*4, (y*w+x)*4
+                buf[di+0], buf[di+1], buf[di+2], buf[di+3] = row[si+2], row[si+1], row[si+0], row[si+3]
+            }
+        }
+    default:
+        for y := 0; y < h; y++ {
+            for x := 0; x < w; x++ {
+                r, g, bl, a := img.At(b.Min.X+x, b.Min.Y+y).RGBA()
+                di := (y*w + x) * 4
+                // RGBA is premultiplied; keep the alpha straight
+                if a > 0 && a < 0xffff { r, g, bl = r*0xffff/a, g*0xffff/a, bl*0xffff/a }
+                buf[di+0], buf[di+1], buf[di+2], buf[di+3] = byte(bl>>8), byte(g>>8), byte(r>>8), byte(a>>8)
+            }
+        }
+    }
+    return buf, w, h, nil
+}
+
+// SlateSource serves a still image at a fixed rate: a "be right back" card,
+// or what a program shows while its sources are gone. The image is decoded
+// once and letterboxed to the output size on black; every frame is the
+// same I420 buffer, with the same Seq, so the encoder skips repeats like
+// an unchanged NDI picture. The file is read again when its modification
+// time changes; a version that doesn't decode keeps the last good one.
+type SlateSource struct {
+    path string
+    w, h int
+    rate Rate
+    last   atomic.Value // *ndiFrame
+    lastAt atomic.Int64
+    sizes  sizeNotifier
+    frames frameNotifier
+    quit   chan struct{}
+    stopped atomic.Bool
+    mu     sync.Mutex
+    mod    time.Time // of the file last rendered
+    seq    uint64
+}
+
+// NewSlateSource renders the PNG or JPEG at path at w x h (rounded down to
+// even; <= 0 for the image's own size) and starts serving it at fps. It
+// fails when the image can't be read.
+func NewSlateSource(path string, w, h int, fps Rate) (*SlateSource, error) {
+    s := &SlateSource{path: path, w: w, h: h, rate: fps.Or(IntRate(30)), quit: make(chan struct{})}
+    st, err := os.Stat(path)
+    if err != nil { return nil, err }
+    if err := s.render(st.ModTime()); err != nil { return nil, err }
+    registerSource()
+    go s.loop()
+    return s, nil
+}
+
+// render decodes the file and makes it the current frame.
+func (s *SlateSource) render(mod time.Time) error {
+    img, iw, ih, err := loadImageBGRA(s.path)
+    if err != nil { return err }
+    out, err := slateFrame(img, iw, ih, s.w, s.h)
+    if err != nil { return fmt.Errorf("%s: %w", s.path, err) }
+    s.mu.Lock()
+    s.seq++
+    out.Seq = s.seq
+    s.mod = mod
+    s.mu.Unlock()
+    s.last.Store(&ndiFrame{Frame: out})
+    return nil
+}
+
+// slateFrame letterboxes a straight-alpha BGRA image (its alpha over
+// black) on black at w x h, rounded down to even; <= 0 for the image's own
+// size. img is premultiplied in place.
+func slateFrame(img []byte, ow, oh, w, h int) (Frame, error) {
+    iw, ih := ow&^1, oh&^1
+    if iw < 2 || ih < 2 { return Frame{}, errors.New("image too small") }
+    if w <= 0 || h <= 0 { w, h = iw, ih }
+    w, h = max(w&^1, 2), max(h&^1, 2)
+    // Transparent parts show the black around the picture
+    for i := 0; i+3 < len(img); i += 4 {
+        if a := uint32(img[i+3]); a < 255 {
+            img[i+0], img[i+1], img[i+2] = byte(uint32(img[i+0])*a/255), byte(uint32(img[i+1])*a/255), byte(uint32(img[i+2])*a/255)
+        }
+    }
+    // I420 needs an even size: an odd last column or row is left out
+    packed := img[:iw*ih*4]
+    if iw != ow {
+        packed = make([]byte, iw*ih*4)
+        packRows(packed, img, ow*4, iw*4, ih)
+    }
+    cs := ColorSpaceFor(w, h)
+    cs.Range = RangeLimited
+    sy, su, sv := i420Planes(nil, nil, nil, iw, ih)
+    BGRAtoI420Space(packed, iw, ih, sy, su, sv, cs)
+    out := newI420Frame(w, h)
+    fillI420Black(out.Y, out.U, out.V)
+    fw, fh := FitRect(iw, ih, w, h)
+    ty, tu, tv := i420Planes(nil, nil, nil, fw, fh)
+    I420Scale(sy, su, sv, iw, ih, ty, tu, tv, fw, fh)
+    copyI420Rect(ty, tu, tv, fw, 0, 0, out.Y, out.U, out.V, w, ((w-fw)/2)&^1, ((h-fh)/2)&^1, fw, fh)
+    out.Color = cs
+    return out, nil
+}
+
+func (s *SlateSource) loop() {
+    defer unregisterSource()
+    defer s.sizes.close()
+    defer s.frames.close()
+    tick := time.NewTicker(s.rate.Interval())
+    defer tick.Stop()
+    var checked time.Time
+    for {
+        select {
+        case <-s.quit: return
+        case now := <-tick.C:
+            if now.Sub(checked) >= slateCheck {
+                checked = now
+                s.reload()
+            }
+            f := s.latest().Frame
+            f.At = now
+            s.lastAt.Store(now.UnixNano())
+            s.sizes.publish(f.W, f.H)
+            s.frames.publish(f)
+        }
+    }
+}
+
+// reload renders the file again if it changed since the last render.
+func (s *SlateSource) reload() {
+    st, err := os.Stat(s.path)
+    s.mu.Lock()
+    same := err == nil && st.ModTime().Equal(s.mod)
+    s.mu.Unlock()
+    if err != nil || same { return }
+    if err := s.render(st.ModTime()); err != nil {
+        // Not again until the file changes once more
+        s.mu.Lock()
+        s.mod = st.ModTime()
+        s.mu.Unlock()
+        log.Printf("Slate: %v (keeping the previous image)", err)
+        return
+    }
+    log.Printf("Slate %s: reloaded", s.path)
+}
+
+// Path is the image file the slate shows.
+func (s *SlateSource) Path() string { return s.path }
+
+func (s *SlateSource) latest() *ndiFrame {
+    f, _ := s.last.Load().(*ndiFrame)
+    return f
+}
+
+// Next returns the slate as BGRA.
+func (s *SlateSource) Next() ([]byte, bool) { return s.latest().pixels(), true }
+
+// NextI420 returns the slate's planes.
+func (s *SlateSource) NextI420() (Frame, bool) { return s.latest().Frame, true }
+
+// Last returns the slate as BGRA, with its size.
+func (s *SlateSource) Last() ([]byte, int, int, bool) {
+    f := s.latest()
+    return f.pixels(), f.W, f.H, true
+}
+
+// PixFmt is "bgra", the format of Next and Last.
+func (s *SlateSource) PixFmt() string { return "bgra" }
+
+// SizeChanges announces the slate's size with its first frame.
+func (s *SlateSource) SizeChanges() <-chan FrameSize { return s.sizes.subscribe() }
+
+// Frames delivers the slate at its rate; see NDISource.Frames.
+func (s *SlateSource) Frames() <-chan Frame { return s.frames.subscribe() }
+
+// StopFrames ends a Frames subscription and closes its channel.
+func (s *SlateSource) StopFrames(ch <-chan Frame) { s.frames.unsubscribe(ch) }
+
+// FrameRate is the rate the slate is served at.
+func (s *SlateSource) FrameRate() (num, den int) { return s.rate.Num, s.rate.Den }
+
+// LastFrameAt reports when the slate last delivered a frame.
+func (s *SlateSource) LastFrameAt() (time.Time, bool) {
+    if n := s.lastAt.Load(); n != 0 { return time.Unix(0, n), true }
+    return time.Time{}, true
+}
+
+func (s *SlateSource) Stop() {
+    if s.stopped.CompareAndSwap(false, true) { close(s.quit) }
+}
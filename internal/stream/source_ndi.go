@@ -3,33 +3,68 @@ package stream
 import (
     "log"
     "strings"
+    "sync"
     "sync/atomic"
     "time"
 
     "whep/internal/ndi"
 )
 
+// NDI senders that restart may come back on another port, where the
+// receiver connected to the old URL never finds them. Once a source has
+// been silent for the stall time it looks its sender's name up in the
+// discovery cache and, if the sender is listed and not connected, opens a
+// new receiver at the URL listed, trying again with backoff from
+// ndiReconnectMin up to ndiReconnectMax until frames return.
+const (
+    ndiReconnectMin = time.Second
+    ndiReconnectMax = 30 * time.Second
+)
+
+// ndiReconnects counts receivers replaced after a stall.
+var ndiReconnects atomic.Uint64
+
+// NDIReconnects is how many times a stalled NDI source has opened a new
+// receiver for its sender.
+func NDIReconnects() uint64 { return ndiReconnects.Load() }
+
 // NDISource wraps an NDI receiver and provides BGRA frames.
 type NDISource struct {
-    w, h int
     rx   *ndi.Receiver
-    last atomic.Value // []byte (packed pixel data)
+    rxMu sync.Mutex // held to replace rx, and by Stop to close it
+    name string     // the sender's, to find it again; "" when unknown
+    url  string     // rx's
+    bandwidth string // ndi.BandwidthHighest or BandwidthLowest
+    started time.Time
+    last atomic.Value // *ndiFrame: latest pixels together with their size and format
+    lastAt atomic.Int64 // UnixNano of the latest video frame
+    rate   atomic.Value // Rate the sender announces
+    senderSize atomic.Value // FrameSize of the sender's frames, before scaling
+    sizes  sizeNotifier
+    frames frameNotifier
     quit chan struct{}
+    stalled atomic.Bool // serving the signal-lost slate
     firstLogged bool
-    pixfmt string // "bgra" or "uyvy422"
     stopped int32 // atomic flag to make Stop idempotent
     // Optional output scaling requested by server (applied inside source loop when libyuv available)
     outW int
     outH int
+    // Audio is only forwarded once a consumer asked for it via AudioFrames
+    audio   chan AudioFrame
+    audioOn int32
 }
 
 // NewNDISource selects a source by URL if provided, else by name substring, else first available.
-func NewNDISource(url, name string) (*NDISource, error) {
+// It receives at bandwidth (ndi.BandwidthHighest, or BandwidthLowest for the
+// sender's low-resolution proxy stream); "" is ndi.DefaultBandwidth.
+func NewNDISource(url, name, bandwidth string) (*NDISource, error) {
     if !ndi.Initialize() { return nil, ErrNDIUnavailable }
+    if bandwidth == "" { bandwidth = ndi.DefaultBandwidth() }
     var rx *ndi.Receiver
     var err error
+    sender := name
     if url != "" {
-        rx, err = ndi.NewReceiverByURL(url)
+        rx, err = ndi.NewReceiverByURL(url, bandwidth)
         if err != nil { return nil, err }
     } else {
         // Do a thorough discovery attempt
@@ -37,23 +72,25 @@ func NewNDISource(url, name string) (*NDISource, error) {
         srcs := ndi.ListSources(2000) // single 2-second discovery
         if name == "" {
             if len(srcs) > 0 {
-                chosen = srcs[0].URL
+                chosen, sender = srcs[0].URL, srcs[0].Name
             }
         } else {
             // Try to match by name substring
             low := strings.ToLower(name)
             for _, s := range srcs {
                 if strings.Contains(strings.ToLower(s.Name), low) || s.URL == name {
-                    chosen = s.URL
+                    chosen, sender = s.URL, s.Name
                     break
                 }
             }
         }
         if chosen == "" { return nil, ErrNDINoSource }
-        rx, err = ndi.NewReceiverByURL(chosen)
+        rx, err = ndi.NewReceiverByURL(chosen, bandwidth)
         if err != nil { return nil, err }
+        url = chosen
     }
-    s := &NDISource{rx: rx, quit: make(chan struct{})}
+    log.Printf("NDI receiver for %s: %s bandwidth", url, bandwidth)
+    s := &NDISource{rx: rx, name: sender, url: url, bandwidth: bandwidth, started: time.Now(), quit: make(chan struct{}), audio: make(chan AudioFrame, 16)}
     // Register a live source for health tracking
     registerSource()
     go s.loop()
@@ -67,175 +104,332 @@ var (
 
 func (s *NDISource) loop() {
     defer unregisterSource()
+    defer s.sizes.close()
+    defer s.frames.close()
+    var sc scaleScratch
+    // A frame that is converted only passes through its capture buffer,
+    // which is pooled; otherwise the SDK copies into the buffer consumers
+    // keep. Whether the next one will be is guessed from the last.
+    pooled := false
+    alloc := func(n int) []byte {
+        if pooled { return getBuf(n) }
+        return make([]byte, n)
+    }
+    fourcc, unknown := 0, map[int]bool{}
+    // Senders of still pictures repeat them at full rate; a repeat is
+    // delivered again, with its Seq, without being converted
+    var hasher *frameHasher
+    if SkipUnchanged() { hasher = &frameHasher{} }
+    stall := stallGuard{after: SourceStallAfter()}
+    rc := reconnectGuard{backoff: ndiReconnectMin}
     for {
         select { case <-s.quit: return; default: }
-        vf, ok, err := s.rx.CaptureVideo(50)
+        s.checkStall(&stall)
+        s.checkReconnect(&rc, stall.after)
+        scaling := s.outW > 0 && s.outH > 0
+        vf, af, err := s.rx.CaptureInto(50, alloc)
         if err != nil { time.Sleep(50 * time.Millisecond); continue }
-        if !ok { continue }
-        if vf == nil || len(vf.Data) == 0 { continue }
-        // Determine pixel format by FourCC and repack to contiguous buffer
-        // Assume UYVY when FourCC corresponds to uyvy (most common); otherwise treat as BGRA
-        isUYVY := (vf.FourCC == 0x59565955) // 'UYVY'
-        if isUYVY {
-            bytesPerPixel := 2
-            if vf.Stride == vf.W*bytesPerPixel {
-                frame := make([]byte, len(vf.Data))
-                copy(frame, vf.Data)
-                srcW, srcH := vf.W, vf.H
-                // Apply optional scaling to BGRA output if target set
-                if s.outW > 0 && s.outH > 0 && (s.outW != srcW || s.outH != srcH) {
-                    // Convert -> scale -> convert back to BGRA for pipeline consumption
-                    srcY := make([]byte, srcW*srcH)
-                    srcU := make([]byte, (srcW/2)*(srcH/2))
-                    srcV := make([]byte, (srcW/2)*(srcH/2))
-                    UYVYtoI420(frame, srcW, srcH, srcY, srcU, srcV)
-                    // Ensure even dims
-                    dw, dh := s.outW, s.outH
-                    if dw%2 != 0 { dw-- }
-                    if dh%2 != 0 { dh-- }
-                    if dw < 2 { dw = 2 }; if dh < 2 { dh = 2 }
-                    dstY := make([]byte, dw*dh)
-                    dstU := make([]byte, (dw/2)*(dh/2))
-                    dstV := make([]byte, (dw/2)*(dh/2))
-                    I420Scale(srcY, srcU, srcV, srcW, srcH, dstY, dstU, dstV, dw, dh)
-                    out := make([]byte, dw*dh*4)
-                    I420ToBGRA(dstY, dstU, dstV, dw, dh, out)
-                    s.w, s.h = dw, dh
-                    s.pixfmt = "bgra"
-                    s.last.Store(out)
-                } else {
-                    s.w, s.h = srcW, srcH
-                    s.pixfmt = "uyvy422"
-                    s.last.Store(frame)
-                }
-            } else {
-                w, h := vf.W, vf.H
-                dst := make([]byte, w*h*bytesPerPixel)
-                for y := 0; y < h; y++ {
-                    srcOff := y*vf.Stride
-                    dstOff := y*w*bytesPerPixel
-                    copy(dst[dstOff:dstOff+w*bytesPerPixel], vf.Data[srcOff:srcOff+vf.Stride])
-                }
-                srcW, srcH := w, h
-                if s.outW > 0 && s.outH > 0 && (s.outW != srcW || s.outH != srcH) {
-                    srcY := make([]byte, srcW*srcH)
-                    srcU := make([]byte, (srcW/2)*(srcH/2))
-                    srcV := make([]byte, (srcW/2)*(srcH/2))
-                    UYVYtoI420(dst, srcW, srcH, srcY, srcU, srcV)
-                    dw, dh := s.outW, s.outH
-                    if dw%2 != 0 { dw-- }
-                    if dh%2 != 0 { dh-- }
-                    if dw < 2 { dw = 2 }; if dh < 2 { dh = 2 }
-                    dstY := make([]byte, dw*dh)
-                    dstU := make([]byte, (dw/2)*(dh/2))
-                    dstV := make([]byte, (dw/2)*(dh/2))
-                    I420Scale(srcY, srcU, srcV, srcW, srcH, dstY, dstU, dstV, dw, dh)
-                    out := make([]byte, dw*dh*4)
-                    I420ToBGRA(dstY, dstU, dstV, dw, dh, out)
-                    s.w, s.h = dw, dh
-                    s.pixfmt = "bgra"
-                    s.last.Store(out)
-                } else {
-                    s.w, s.h = srcW, srcH
-                    s.pixfmt = "uyvy422"
-                    s.last.Store(dst)
-                }
+        if af != nil && atomic.LoadInt32(&s.audioOn) == 1 {
+            select {
+            case s.audio <- AudioFrame{SampleRate: af.SampleRate, Channels: af.Channels, Data: af.Data}:
+            default: // consumer is behind; drop rather than stall video capture
             }
-        } else {
-            // BGRA path
-            if vf.Stride == vf.W*4 {
-                frame := make([]byte, len(vf.Data))
-                copy(frame, vf.Data)
-                srcW, srcH := vf.W, vf.H
-                if s.outW > 0 && s.outH > 0 && (s.outW != srcW || s.outH != srcH) {
-                    // Convert -> scale -> back to BGRA
-                    srcY := make([]byte, srcW*srcH)
-                    srcU := make([]byte, (srcW/2)*(srcH/2))
-                    srcV := make([]byte, (srcW/2)*(srcH/2))
-                    BGRAtoI420(frame, srcW, srcH, srcY, srcU, srcV)
-                    dw, dh := s.outW, s.outH
-                    if dw%2 != 0 { dw-- }
-                    if dh%2 != 0 { dh-- }
-                    if dw < 2 { dw = 2 }; if dh < 2 { dh = 2 }
-                    dstY := make([]byte, dw*dh)
-                    dstU := make([]byte, (dw/2)*(dh/2))
-                    dstV := make([]byte, (dw/2)*(dh/2))
-                    I420Scale(srcY, srcU, srcV, srcW, srcH, dstY, dstU, dstV, dw, dh)
-                    out := make([]byte, dw*dh*4)
-                    I420ToBGRA(dstY, dstU, dstV, dw, dh, out)
-                    s.w, s.h = dw, dh
-                    s.pixfmt = "bgra"
-                    s.last.Store(out)
-                } else {
-                    s.w, s.h = srcW, srcH
-                    s.pixfmt = "bgra"
-                    s.last.Store(frame)
-                }
-            } else {
-                w, h := vf.W, vf.H
-                dst := make([]byte, w*h*4)
-                for y := 0; y < h; y++ {
-                    srcOff := y*vf.Stride
-                    dstOff := y*w*4
-                    copy(dst[dstOff:dstOff+w*4], vf.Data[srcOff:srcOff+vf.Stride])
-                }
-                srcW, srcH := w, h
-                if s.outW > 0 && s.outH > 0 && (s.outW != srcW || s.outH != srcH) {
-                    srcY := make([]byte, srcW*srcH)
-                    srcU := make([]byte, (srcW/2)*(srcH/2))
-                    srcV := make([]byte, (srcW/2)*(srcH/2))
-                    BGRAtoI420(dst, srcW, srcH, srcY, srcU, srcV)
-                    dw, dh := s.outW, s.outH
-                    if dw%2 != 0 { dw-- }
-                    if dh%2 != 0 { dh-- }
-                    if dw < 2 { dw = 2 }; if dh < 2 { dh = 2 }
-                    dstY := make([]byte, dw*dh)
-                    dstU := make([]byte, (dw/2)*(dh/2))
-                    dstV := make([]byte, (dw/2)*(dh/2))
-                    I420Scale(srcY, srcU, srcV, srcW, srcH, dstY, dstU, dstV, dw, dh)
-                    out := make([]byte, dw*dh*4)
-                    I420ToBGRA(dstY, dstU, dstV, dw, dh, out)
-                    s.w, s.h = dw, dh
-                    s.pixfmt = "bgra"
-                    s.last.Store(out)
-                } else {
-                    s.w, s.h = srcW, srcH
-                    s.pixfmt = "bgra"
-                    s.last.Store(dst)
-                }
+        }
+        if vf == nil { continue }
+        f, known := ndiFormatOf(vf.FourCC)
+        if !known {
+            // Skipped rather than misread as another layout
+            if !unknown[vf.FourCC] {
+                unknown[vf.FourCC] = true
+                log.Printf("NDI: skipping %dx%d frames in unsupported format %q (FourCC %#x)", vf.W, vf.H, ndi.FourCCString(vf.FourCC), vf.FourCC)
+            }
+            continue
+        }
+        if len(vf.Data) == 0 { continue }
+        rc.next, rc.backoff = time.Time{}, ndiReconnectMin
+        if stall.on {
+            stall.on = false
+            s.stalled.Store(false)
+            // The picture before the stall was replaced by the slate
+            if hasher != nil { hasher.seq = 0 }
+            log.Printf("NDI: frames resumed after %s", time.Since(stall.since).Round(100*time.Millisecond))
+        }
+        if r := (Rate{vf.FrameRateN, vf.FrameRateD}); r.Valid() { s.rate.Store(r.reduce()) }
+        if sz, _ := s.senderSize.Load().(FrameSize); sz.W != vf.W || sz.H != vf.H { s.senderSize.Store(FrameSize{vf.W, vf.H}) }
+        if vf.FourCC != fourcc {
+            fourcc = vf.FourCC
+            log.Printf("NDI: sender format %s %dx%d, read as %s", ndi.FourCCString(fourcc), vf.W, vf.H, f.pixfmt)
+        }
+        // Senders may pad lines (e.g. to 64 bytes): rows are Stride apart
+        row := ndi.LineBytes(vf.FourCC, vf.W)
+        if !ndiFrameFits(vf) {
+            putBuf(vf.Data)
+            continue
+        }
+        var seq uint64
+        if hasher != nil {
+            var changed bool
+            n := ndi.FrameBytes(vf.FourCC, vf.Stride, vf.W, vf.H)
+            seq, changed = hasher.next(vf.Data[:n], vf.FourCC, vf.W, vf.H, vf.Stride, s.outW, s.outH)
+            if prev := s.latest(); !changed && prev != nil {
+                if pooled { putBuf(vf.Data) }
+                rep := prev.Frame
+                rep.At = time.Now()
+                s.lastAt.Store(rep.At.UnixNano())
+                s.frames.publish(rep)
+                continue
             }
         }
+        var cur Frame
+        kept := false // cur holds the capture buffer
+        switch {
+        case scaling && (s.outW != vf.W || s.outH != vf.H):
+            // Apply optional scaling, keeping the output in I420 for the
+            // encoder; it reads padded rows in place
+            cur = sc.toI420(vf, f, s.outW, s.outH)
+        case f.toI420 != nil:
+            cur = newI420Frame(vf.W, vf.H)
+            f.toI420(vf.Data, vf.Stride, vf.W, vf.H, cur.Y, cur.U, cur.V)
+            cur.Color.Range = RangeLimited
+        case vf.Stride != row || f.swapRB:
+            cur = Frame{Data: make([]byte, row*vf.H), W: vf.W, H: vf.H, PixFmt: f.pixfmt}
+            f.pack(cur.Data, vf.Data, vf.Stride, row, vf.H)
+        default:
+            cur, kept = Frame{Data: vf.Data, W: vf.W, H: vf.H, PixFmt: f.pixfmt}, true
+        }
+        if pooled = !kept; pooled { putBuf(vf.Data) }
+        cur.At, cur.Seq = time.Now(), seq
+        s.last.Store(&ndiFrame{Frame: cur})
+        s.lastAt.Store(cur.At.UnixNano())
+        s.sizes.publish(cur.W, cur.H)
+        s.frames.publish(cur)
         if !s.firstLogged {
             s.firstLogged = true
-            log.Printf("NDI: first frame received %dx%d FourCC=%d", vf.W, vf.H, vf.FourCC)
+            log.Printf("NDI: first frame received %dx%d FourCC=%s", vf.W, vf.H, ndi.FourCCString(vf.FourCC))
         }
     }
 }
 
+// checkStall puts the signal-lost slate in place of the latest frame once
+// the sender has been silent for the stall time, and delivers it at the
+// sender's rate while the silence lasts. LastFrameAt keeps the last real
+// frame's time.
+func (s *NDISource) checkStall(g *stallGuard) {
+    if g.after <= 0 { return }
+    n := s.lastAt.Load()
+    if n == 0 { return }
+    last := time.Unix(0, n)
+    now := time.Now()
+    if now.Sub(last) < g.after { return }
+    if !g.on {
+        prev := s.latest()
+        g.on, g.since, g.due = true, last, now
+        g.frame = stallFrame(prev.W, prev.H)
+        s.last.Store(&ndiFrame{Frame: g.frame})
+        s.stalled.Store(true)
+        log.Printf("NDI: no frame for %s; showing the signal-lost slate", g.after)
+    }
+    if now.Before(g.due) { return }
+    f := g.frame
+    f.At = now
+    s.frames.publish(f)
+    r, _ := s.rate.Load().(Rate)
+    g.due = now.Add(r.Or(IntRate(30)).Interval())
+}
+
+// reconnectGuard is a capture loop's reconnect backoff.
+type reconnectGuard struct {
+    next    time.Time // earliest next attempt
+    backoff time.Duration
+}
+
+// checkReconnect replaces the receiver once the sender has been silent for
+// after (the default stall time when stall detection is off), if
+// discovery lists the sender and the receiver isn't connected to it;
+// attempts back off while the silence lasts.
+func (s *NDISource) checkReconnect(rc *reconnectGuard, after time.Duration) {
+    if s.name == "" { return }
+    if after <= 0 { after = defaultStallAfter }
+    now := time.Now()
+    last := s.started
+    if n := s.lastAt.Load(); n != 0 { last = time.Unix(0, n) }
+    if now.Sub(last) < after || now.Before(rc.next) { return }
+    rc.next = now.Add(rc.backoff)
+    rc.backoff = min(rc.backoff*2, ndiReconnectMax)
+    url := ""
+    for _, si := range ndi.GetCachedSources() {
+        if si.Name == s.name { url = si.URL; break }
+    }
+    if url == "" || (url == s.url && s.rx.Connections() > 0) { return }
+    rx, err := ndi.NewReceiverByURL(url, s.bandwidth)
+    if err != nil || rx == nil {
+        log.Printf("NDI %q: reconnect at %s failed: %v", s.name, url, err)
+        return
+    }
+    s.rxMu.Lock()
+    if atomic.LoadInt32(&s.stopped) == 1 {
+        s.rxMu.Unlock()
+        rx.Close()
+        return
+    }
+    old := s.rx
+    s.rx, s.url = rx, url
+    s.rxMu.Unlock()
+    old.Close()
+    ndiReconnects.Add(1)
+    log.Printf("NDI %q: no frame for %s; reconnected at %s", s.name, now.Sub(last).Round(100*time.Millisecond), url)
+}
+
+// Stalled reports whether the sender has gone silent for the stall time
+// and the source is serving the signal-lost slate.
+func (s *NDISource) Stalled() bool { return s.stalled.Load() }
+
+// Bandwidth is the bandwidth the source receives at: ndi.BandwidthHighest,
+// or BandwidthLowest for the sender's proxy stream.
+func (s *NDISource) Bandwidth() string { return s.bandwidth }
+
+// NDIBandwidth is the receive bandwidth of the NDI source behind src's
+// wrappers; false if there is none.
+func NDIBandwidth(src Source) (string, bool) {
+    switch s := src.(type) {
+    case *SwitchSource:
+        return NDIBandwidth(s.Current())
+    case *AspectSource:
+        return NDIBandwidth(s.Inner())
+    case *RegionSource:
+        return NDIBandwidth(s.Inner())
+    case *SharedSource:
+        return NDIBandwidth(s.Inner())
+    case *NDISource:
+        return s.bandwidth, true
+    }
+    return "", false
+}
+
+// NDISenderFormat is what a receiver has seen of its sender's stream: the
+// sender's URL, and the size and rate of its frames before any scaling.
+type NDISenderFormat struct {
+    URL  string
+    W, H int
+    FPS  Rate
+}
+
+// NDISender is the sender format of the NDI source behind src's wrappers;
+// false if there is none, it has had no frame yet, or it receives the
+// sender's proxy stream, whose size isn't the sender's.
+func NDISender(src Source) (NDISenderFormat, bool) {
+    switch s := src.(type) {
+    case *SwitchSource:
+        return NDISender(s.Current())
+    case *AspectSource:
+        return NDISender(s.Inner())
+    case *RegionSource:
+        return NDISender(s.Inner())
+    case *SharedSource:
+        return NDISender(s.Inner())
+    case *NDISource:
+        sz, _ := s.senderSize.Load().(FrameSize)
+        if sz.W <= 0 || sz.H <= 0 || s.bandwidth == ndi.BandwidthLowest { return NDISenderFormat{}, false }
+        r, _ := s.rate.Load().(Rate)
+        s.rxMu.Lock()
+        defer s.rxMu.Unlock()
+        return NDISenderFormat{URL: s.url, W: sz.W, H: sz.H, FPS: r}, true
+    }
+    return NDISenderFormat{}, false
+}
+
+// ndiFrame is a frame as the source keeps it. A scaled frame, or one from
+// a planar sender, stays in I420 and is packed to BGRA only if Next or Last
+// asks for it, once.
+type ndiFrame struct {
+    Frame
+    packOnce sync.Once
+    packed   []byte
+}
+
+// pixels returns the frame packed, in PixFmt's format.
+func (f *ndiFrame) pixels() []byte {
+    if f.PixFmt != "i420" { return f.Data }
+    f.packOnce.Do(func() { f.packed, _ = f.Frame.Packed() })
+    return f.packed
+}
+
+// latest is the most recent frame; nil before the first one.
+func (s *NDISource) latest() *ndiFrame {
+    f, _ := s.last.Load().(*ndiFrame)
+    return f
+}
+
 func (s *NDISource) Next() ([]byte, bool) {
-    v := s.last.Load()
-    if v == nil { return nil, true }
-    buf := v.([]byte)
+    f := s.latest()
+    if f == nil { return nil, true }
     // return the buffer directly; pipeline will read it before next update
-    return buf, true
+    return f.pixels(), true
+}
+
+// NextI420 returns the latest frame's planes when it is kept in I420
+// (scaled, or from a planar sender); ok is false for packed frames.
+func (s *NDISource) NextI420() (Frame, bool) {
+    f := s.latest()
+    if f == nil || f.PixFmt != "i420" { return Frame{}, false }
+    return f.Frame, true
 }
 
-// Last returns the most recent frame buffer along with its width and height.
-// The buffer is BGRA format, with stride assumed to be w*4.
+// Last returns the most recent frame buffer along with its width and height,
+// all from the same frame. Its format is PixFmt's, packed at w * bytes per
+// pixel.
 func (s *NDISource) Last() ([]byte, int, int, bool) {
-    v := s.last.Load()
-    if v == nil { return nil, 0, 0, false }
-    buf := v.([]byte)
-    return buf, s.w, s.h, true
+    f := s.latest()
+    if f == nil { return nil, 0, 0, false }
+    return f.pixels(), f.W, f.H, true
+}
+
+// SizeChanges announces the size of delivered frames (after any output
+// scaling) from the capture loop, as soon as a frame differs from the one
+// before it.
+func (s *NDISource) SizeChanges() <-chan FrameSize { return s.sizes.subscribe() }
+
+// Frames delivers each frame as the receiver captures it (after any output
+// scaling), so a pipeline can encode at the sender's pace instead of
+// polling Next on a ticker. Each call is a new subscription holding up to
+// frameRing frames, oldest dropped first; StopFrames ends it.
+func (s *NDISource) Frames() <-chan Frame { return s.frames.subscribe() }
+
+// StopFrames ends a Frames subscription and closes its channel.
+func (s *NDISource) StopFrames(ch <-chan Frame) { s.frames.unsubscribe(ch) }
+
+// FrameRate is the sender's frame rate, e.g. 30000/1001, as announced with
+// its latest frame; 0/0 before the first one.
+func (s *NDISource) FrameRate() (num, den int) {
+    r, _ := s.rate.Load().(Rate)
+    return r.Num, r.Den
+}
+
+// LastFrameAt reports when the receiver last delivered a video frame; the
+// time is zero before the first one.
+func (s *NDISource) LastFrameAt() (time.Time, bool) {
+    if n := s.lastAt.Load(); n != 0 { return time.Unix(0, n), true }
+    return time.Time{}, true
+}
+
+// AudioFrames returns the source's audio as interleaved float32 PCM and starts
+// forwarding it. Frames are dropped if the consumer falls behind.
+func (s *NDISource) AudioFrames() <-chan AudioFrame {
+    atomic.StoreInt32(&s.audioOn, 1)
+    return s.audio
 }
 
-// PixFmt returns the current pixel format string suitable for ffmpeg rawvideo (e.g., "bgra" or "uyvy422").
+// PixFmt returns the current pixel format string suitable for ffmpeg rawvideo (e.g., "bgra" or "uyvy422"),
+// as Next and Last deliver it; scaled frames come packed as BGRA.
 func (s *NDISource) PixFmt() string {
-    if s.pixfmt == "" { return "bgra" }
-    return s.pixfmt
+    f := s.latest()
+    if f == nil || f.PixFmt == "i420" { return "bgra" }
+    return f.PixFmt
 }
 
 func (s *NDISource) Stop() {
+    s.rxMu.Lock()
+    defer s.rxMu.Unlock()
     if atomic.CompareAndSwapInt32(&s.stopped, 0, 1) {
         close(s.quit)
         s.rx.Close()
@@ -256,3 +450,60 @@ func (s *NDISource) SetOutputSize(w, h int) {
 type tinyErr string
 func (e tinyErr) Error() string { return string(e) }
 func fmtErr(s string) error { return tinyErr(s) }
+
+// ndiFrameFits reports whether vf's lines, Stride bytes apart, are wide
+// enough for its width and its buffer holds all of them. Frames that
+// aren't are dropped.
+func ndiFrameFits(vf *ndi.VideoFrame) bool {
+    return vf.Stride >= ndi.LineBytes(vf.FourCC, vf.W) && len(vf.Data) >= ndi.FrameBytes(vf.FourCC, vf.Stride, vf.W, vf.H)
+}
+
+// packRows copies h rows of row bytes, stride bytes apart in src, to dst
+// back to back.
+func packRows(dst, src []byte, stride, row, h int) {
+    for y := 0; y < h; y++ { copy(dst[y*row:(y+1)*row], src[y*stride:y*stride+row]) }
+}
+
+// scaleScratch holds the I420 planes output scaling converts the source
+// frame into, and the packed copy of a padded or RGBA frame. They are the
+// same size frame after frame, so they live as long as the loop.
+type scaleScratch struct {
+    sy, su, sv []byte
+    rows       []byte
+}
+
+// toI420 converts vf, read as f, and scales it to dw x dh (rounded down to
+// even), returning a new I420 frame; only the returned planes are
+// allocated.
+func (sc *scaleScratch) toI420(vf *ndi.VideoFrame, f ndiFormat, dw, dh int) Frame {
+    dw, dh = max(dw&^1, 2), max(dh&^1, 2)
+    sc.sy, sc.su, sc.sv = i420Planes(sc.sy, sc.su, sc.sv, vf.W, vf.H)
+    switch {
+    case f.toI420 != nil:
+        f.toI420(vf.Data, vf.Stride, vf.W, vf.H, sc.sy, sc.su, sc.sv)
+    case f.pixfmt == "uyvy422":
+        UYVYtoI420Stride(vf.Data, vf.Stride, vf.W, vf.H, sc.sy, sc.su, sc.sv)
+    default:
+        in := vf.Data
+        if row := vf.W * 4; vf.Stride != row || f.swapRB {
+            if len(sc.rows) != row*vf.H { sc.rows = make([]byte, row*vf.H) }
+            f.pack(sc.rows, vf.Data, vf.Stride, row, vf.H)
+            in = sc.rows
+        }
+        BGRAtoI420(in, vf.W, vf.H, sc.sy, sc.su, sc.sv)
+    }
+    out := newI420Frame(dw, dh)
+    I420Scale(sc.sy, sc.su, sc.sv, vf.W, vf.H, out.Y, out.U, out.V, dw, dh)
+    // Scaling keeps the color space picked for the source size; the
+    // sender's own YUV is limited range
+    out.Color = ColorSpaceFor(vf.W, vf.H)
+    if f.toI420 != nil || f.pixfmt == "uyvy422" { out.Color.Range = RangeLimited }
+    return out
+}
+
+// newI420Frame allocates a w x h I420 frame, its planes in one buffer.
+func newI420Frame(w, h int) Frame {
+    c := (w / 2) * (h / 2)
+    buf := make([]byte, w*h+2*c)
+    return Frame{W: w, H: h, PixFmt: "i420", Y: buf[:w*h], U: buf[w*h : w*h+c], V: buf[w*h+c:]}
+}
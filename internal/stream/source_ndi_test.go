@@ -0,0 +1,176 @@
+package stream
+
+import (
+    "bytes"
+    "testing"
+
+    "whep/internal/ndi"
+)
+
+// BenchmarkNDIFrame runs the capture loop's per-frame work on a 1080p UYVY
+// frame, scaled to 720p and unscaled, without an SDK: copying the frame
+// into its capture buffer stands in for CaptureInto. "fresh" allocates the
+// capture buffer and scratch planes for every frame, as the loop used to;
+// "reused" pools and keeps them as it does now.
+func BenchmarkNDIFrame(b *testing.B) {
+    const w, h = 1920, 1080
+    f, _ := ndiFormatOf(ndi.FourCCUYVY)
+    sdk := make([]byte, ndi.FrameBytes(ndi.FourCCUYVY, w*2, w, h))
+    for i := range sdk { sdk[i] = byte(i) }
+    capture := func(alloc func(int) []byte) *ndi.VideoFrame {
+        buf := alloc(len(sdk))
+        copy(buf, sdk)
+        return &ndi.VideoFrame{W: w, H: h, Stride: w * 2, FourCC: ndi.FourCCUYVY, Data: buf}
+    }
+    fresh := func(n int) []byte { return make([]byte, n) }
+    var keep Frame // what consumers are handed, so it can't be optimized away
+    b.Run("scaled/fresh", func(b *testing.B) {
+        b.ReportAllocs()
+        b.SetBytes(int64(len(sdk)))
+        for i := 0; i < b.N; i++ {
+            var sc scaleScratch
+            vf := capture(fresh)
+            keep = sc.toI420(vf, f, 1280, 720)
+        }
+    })
+    b.Run("scaled/reused", func(b *testing.B) {
+        b.ReportAllocs()
+        b.SetBytes(int64(len(sdk)))
+        var sc scaleScratch
+        for i := 0; i < b.N; i++ {
+            vf := capture(getBuf)
+            keep = sc.toI420(vf, f, 1280, 720)
+            putBuf(vf.Data)
+        }
+    })
+    // Unscaled UYVY is handed on as captured, so the one capture buffer
+    // per frame is all there is
+    b.Run("unscaled", func(b *testing.B) {
+        b.ReportAllocs()
+        b.SetBytes(int64(len(sdk)))
+        for i := 0; i < b.N; i++ {
+            vf := capture(fresh)
+            keep = Frame{Data: vf.Data, W: vf.W, H: vf.H, PixFmt: f.pixfmt}
+        }
+    })
+    _ = keep
+}
+
+// BenchmarkNDIScaledPath compares a 1080p UYVY frame's way to a 720p
+// mount's encoder. "bgra-roundtrip" is the old path: the source scaled in
+// I420, converted back to BGRA, and the pipeline converted that to I420
+// again. "i420" hands the scaled planes over as NextI420 does.
+func BenchmarkNDIScaledPath(b *testing.B) {
+    const w, h, dw, dh = 1920, 1080, 1280, 720
+    f, _ := ndiFormatOf(ndi.FourCCUYVY)
+    sdk := make([]byte, ndi.FrameBytes(ndi.FourCCUYVY, w*2, w, h))
+    for i := range sdk { sdk[i] = byte(i) }
+    vf := &ndi.VideoFrame{W: w, H: h, Stride: w * 2, FourCC: ndi.FourCCUYVY, Data: sdk}
+    var keep Frame
+    b.Run("bgra-roundtrip", func(b *testing.B) {
+        b.ReportAllocs()
+        b.SetBytes(int64(len(sdk)))
+        var sc scaleScratch
+        enc := newI420Frame(dw, dh)
+        for i := 0; i < b.N; i++ {
+            s := sc.toI420(vf, f, dw, dh)
+            bgra := make([]byte, dw*dh*4)
+            I420ToBGRA(s.Y, s.U, s.V, dw, dh, bgra)
+            BGRAtoI420(bgra, dw, dh, enc.Y, enc.U, enc.V)
+            keep = enc
+        }
+    })
+    b.Run("i420", func(b *testing.B) {
+        b.ReportAllocs()
+        b.SetBytes(int64(len(sdk)))
+        var sc scaleScratch
+        for i := 0; i < b.N; i++ { keep = sc.toI420(vf, f, dw, dh) }
+    })
+    _ = keep
+}
+
+// padFrame copies a tight frame's rows pad bytes further apart, filling
+// the padding with bytes no conversion should read.
+func padFrame(tight []byte, row, h, pad int) []byte {
+    stride := row + pad
+    out := bytes.Repeat([]byte{0xEE}, stride*h)
+    for y := 0; y < h; y++ { copy(out[y*stride:], tight[y*row:(y+1)*row]) }
+    return out
+}
+
+// Senders that pad their lines must come out byte for byte as if they
+// hadn't, scaled or not.
+func TestNDIPaddedStride(t *testing.T) {
+    const w, h = 34, 18 // lines not a multiple of any alignment
+    for _, tt := range []struct {
+        name   string
+        fourcc int
+        pad    int
+    }{
+        {"UYVY", ndi.FourCCUYVY, 60},
+        {"UYVY odd pad", ndi.FourCCUYVY, 2},
+        {"BGRA", ndi.FourCCBGRA, 64},
+        {"BGRA odd pad", ndi.FourCCBGRA, 4},
+        {"RGBA", ndi.FourCCRGBA, 32},
+    } {
+        f, _ := ndiFormatOf(tt.fourcc)
+        row := ndi.LineBytes(tt.fourcc, w)
+        tight := make([]byte, row*h)
+        for i := range tight { tight[i] = byte(i*7 + i/row) }
+        padded := padFrame(tight, row, h, tt.pad)
+        tv := &ndi.VideoFrame{W: w, H: h, Stride: row, FourCC: tt.fourcc, Data: tight}
+        pv := &ndi.VideoFrame{W: w, H: h, Stride: row + tt.pad, FourCC: tt.fourcc, Data: padded}
+        if !ndiFrameFits(tv) || !ndiFrameFits(pv) { t.Fatalf("%s: frame doesn't fit", tt.name) }
+
+        // Unscaled: repacked to the tight layout the encoder reads
+        got := make([]byte, row*h)
+        f.pack(got, padded, row+tt.pad, row, h)
+        want := tight
+        if f.swapRB {
+            want = make([]byte, row*h)
+            f.pack(want, tight, row, row, h)
+        }
+        if !bytes.Equal(got, want) { t.Errorf("%s: packed rows differ from the tight frame", tt.name) }
+        if tt.fourcc == ndi.FourCCUYVY {
+            ty, tu, tv2 := make([]byte, w*h), make([]byte, w*h/4), make([]byte, w*h/4)
+            py, pu, pv2 := make([]byte, w*h), make([]byte, w*h/4), make([]byte, w*h/4)
+            UYVYtoI420(tight, w, h, ty, tu, tv2)
+            UYVYtoI420Stride(padded, row+tt.pad, w, h, py, pu, pv2)
+            if !bytes.Equal(ty, py) || !bytes.Equal(tu, pu) || !bytes.Equal(tv2, pv2) { t.Errorf("%s: UYVYtoI420Stride differs from the tight conversion", tt.name) }
+        }
+
+        // Scaled: converted in place from the padded rows
+        for _, size := range [][2]int{{16, 10}, {w, h}} {
+            var st, sp scaleScratch
+            a, b := st.toI420(tv, f, size[0], size[1]), sp.toI420(pv, f, size[0], size[1])
+            if !bytes.Equal(a.Y, b.Y) || !bytes.Equal(a.U, b.U) || !bytes.Equal(a.V, b.V) || a.Color != b.Color {
+                t.Errorf("%s: scaled to %dx%d, padded and tight frames differ", tt.name, size[0], size[1])
+            }
+        }
+    }
+}
+
+// Frames whose lines are narrower than their width, or whose buffer is
+// short, are skipped rather than read out of bounds.
+func TestNDIFrameFits(t *testing.T) {
+    const w, h = 32, 8
+    for _, tt := range []struct {
+        name   string
+        fourcc int
+        stride int
+        size   int
+        want   bool
+    }{
+        {"UYVY tight", ndi.FourCCUYVY, w * 2, w * 2 * h, true},
+        {"UYVY padded", ndi.FourCCUYVY, w*2 + 64, (w*2 + 64) * h, true},
+        {"UYVY stride < row", ndi.FourCCUYVY, w*2 - 2, w * 2 * h, false},
+        {"UYVY short buffer", ndi.FourCCUYVY, w*2 + 64, (w*2+64)*h - 1, false},
+        {"BGRA tight", ndi.FourCCBGRA, w * 4, w * 4 * h, true},
+        {"BGRA stride < row", ndi.FourCCBGRA, w*4 - 4, w * 4 * h, false},
+        {"BGRA zero stride", ndi.FourCCBGRA, 0, w * 4 * h, false},
+        {"NV12 short chroma", ndi.FourCCNV12, w, w * h, false},
+    } {
+        vf := &ndi.VideoFrame{W: w, H: h, Stride: tt.stride, FourCC: tt.fourcc, Data: make([]byte, tt.size)}
+        if got := ndiFrameFits(vf); got != tt.want { t.Errorf("%s: ndiFrameFits = %v, want %v", tt.name, got, tt.want) }
+    }
+}
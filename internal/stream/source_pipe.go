@@ -0,0 +1,312 @@
+package stream
+
+import (
+    "bufio"
+    "errors"
+    "fmt"
+    "io"
+    "log"
+    "net"
+    "os"
+    "strconv"
+    "strings"
+    "sync"
+    "sync/atomic"
+    "time"
+)
+
+// Raw video piped in. A PipeSource reads back-to-back frames of one fixed
+// size and format, BGRA or I420, from whatever another process writes: the
+// server's stdin, a named pipe, or a TCP connection, e.g.
+//
+//   ffmpeg -re -i input -f rawvideo -pix_fmt bgra -s 1280x720 -r 30 pipe:1
+//
+// The feeder sets the pace. When it stops, the last frame is held; a named
+// pipe is opened again and a listener takes the next connection, so a
+// restarted feeder carries on. stdin can't be reopened: it ends the feed
+// for good. A partial frame at the end of a feed is dropped.
+
+const (
+    pipeStallAfter = 2 * time.Second        // no frame for this long: stalled
+    pipeRetry      = 500 * time.Millisecond // before reopening an input that failed or gave nothing
+)
+
+// Pipe source states, as PipeStatus reports them.
+const (
+    PipeWaiting = "waiting" // for a feeder
+    PipePlaying = "playing"
+    PipeEnded   = "ended" // stdin closed
+)
+
+// PipeFormat is the frames a pipe carries.
+type PipeFormat struct {
+    W, H   int
+    Rate   Rate
+    PixFmt string // "bgra" or "i420"
+}
+
+// ParsePipeFormat reads "WxH@FPS:pixfmt", e.g. "1280x720@30:bgra" or
+// "1920x1080@30000/1001:i420". FPS may be left out ("1280x720:bgra") for
+// 30, and pixfmt takes ffmpeg's names too (yuv420p for i420).
+func ParsePipeFormat(s string) (PipeFormat, error) {
+    bad := fmt.Errorf("pipe format must be WxH@FPS:bgra or WxH@FPS:i420, not %q", s)
+    size, pixfmt, ok := strings.Cut(strings.TrimSpace(s), ":")
+    if !ok { return PipeFormat{}, bad }
+    f := PipeFormat{Rate: IntRate(30)}
+    switch strings.ToLower(strings.TrimSpace(pixfmt)) {
+    case "bgra": f.PixFmt = "bgra"
+    case "i420", "yuv420p": f.PixFmt = "i420"
+    default: return PipeFormat{}, bad
+    }
+    if dims, fps, ok := strings.Cut(size, "@"); ok {
+        r, err := ParseRate(fps)
+        if err != nil { return PipeFormat{}, err }
+        f.Rate, size = r, dims
+    }
+    ws, hs, ok := strings.Cut(strings.ToLower(size), "x")
+    if !ok { return PipeFormat{}, bad }
+    var err1, err2 error
+    f.W, err1 = strconv.Atoi(strings.TrimSpace(ws))
+    f.H, err2 = strconv.Atoi(strings.TrimSpace(hs))
+    if err1 != nil || err2 != nil || f.W < 2 || f.H < 2 || f.W > 8192 || f.H > 8192 { return PipeFormat{}, bad }
+    if f.PixFmt == "i420" && (f.W%2 != 0 || f.H%2 != 0) { return PipeFormat{}, fmt.Errorf("i420 pipe frames need an even size, not %dx%d", f.W, f.H) }
+    return f, nil
+}
+
+// String is e.g. "1280x720@30:bgra".
+func (f PipeFormat) String() string { return fmt.Sprintf("%dx%d@%s:%s", f.W, f.H, f.Rate, f.PixFmt) }
+
+// frameBytes is the size of one frame on the pipe.
+func (f PipeFormat) frameBytes() int {
+    if f.PixFmt == "i420" { return f.W*f.H + 2*(f.W/2)*(f.H/2) }
+    return f.W * f.H * 4
+}
+
+// PipeStatus is a pipe source in /health.
+type PipeStatus struct {
+    Input     string     `json:"input"`
+    Format    string     `json:"format"`
+    State     string     `json:"state"`
+    Stalled   bool       `json:"stalled"` // no frame for pipeStallAfter
+    Feeds     uint64     `json:"feeds"`   // inputs opened or connections taken
+    Frames    uint64     `json:"frames"`
+    Partial   uint64     `json:"partial"` // frames cut off at the end of a feed
+    LastError string     `json:"lastError,omitempty"`
+    LastFrame *time.Time `json:"lastFrame,omitempty"`
+}
+
+// PipeSource reads raw frames from a pipe; see ParsePipeFormat.
+type PipeSource struct {
+    format  PipeFormat
+    input   string
+    ln      net.Listener // for tcp:// inputs
+    last    atomic.Value // *ndiFrame
+    lastAt  atomic.Int64 // UnixNano of the latest frame
+    sizes   sizeNotifier
+    frames  frameNotifier
+    quit    chan struct{}
+    stopped atomic.Bool
+    started time.Time
+    feeds   atomic.Uint64
+    count   atomic.Uint64
+    partial atomic.Uint64
+
+    mu      sync.Mutex
+    state   string
+    lastErr string
+    cur     io.Closer // the feed being read, closed by Stop
+}
+
+// NewPipeSource starts reading frames of format f from input: "-" for
+// stdin, "tcp://host:port" to listen for a feeder, or the path of a named
+// pipe (or file). Only listening can fail here; a missing path is retried.
+// Reads can't be interrupted, so the reading goroutine outlives Stop until
+// its input delivers or ends, and isn't counted by WaitIdle.
+func NewPipeSource(f PipeFormat, input string) (*PipeSource, error) {
+    s := &PipeSource{format: f, input: input, quit: make(chan struct{}), started: time.Now(), state: PipeWaiting}
+    if addr, ok := strings.CutPrefix(input, "tcp://"); ok {
+        ln, err := net.Listen("tcp", addr)
+        if err != nil { return nil, fmt.Errorf("pipe: %w", err) }
+        s.ln = ln
+    }
+    go s.loop()
+    return s, nil
+}
+
+func (s *PipeSource) loop() {
+    defer s.sizes.close()
+    defer s.frames.close()
+    for {
+        r, err := s.open()
+        if s.stopped.Load() {
+            if r != nil { r.Close() }
+            return
+        }
+        n := 0
+        if err == nil {
+            s.feeds.Add(1)
+            n, err = s.read(r)
+            r.Close()
+            if s.stopped.Load() { return }
+        }
+        s.mu.Lock()
+        s.state, s.cur = PipeWaiting, nil
+        if err != nil { s.lastErr = err.Error() }
+        if s.input == "-" { s.state = PipeEnded }
+        s.mu.Unlock()
+        if s.input == "-" {
+            log.Printf("Pipe: stdin closed after %d frame(s); holding the last frame", n)
+            <-s.quit
+            return
+        }
+        if err != nil { log.Printf("Pipe %s: %v", s.input, err) } else { log.Printf("Pipe %s: feed ended after %d frame(s); waiting for the next one", s.input, n) }
+        if err != nil || n == 0 {
+            select {
+            case <-s.quit: return
+            case <-time.After(pipeRetry):
+            }
+        }
+    }
+}
+
+// open waits for the next feed.
+func (s *PipeSource) open() (io.ReadCloser, error) {
+    var r io.ReadCloser
+    var err error
+    switch {
+    case s.input == "-":
+        r = io.NopCloser(os.Stdin)
+    case s.ln != nil:
+        var c net.Conn
+        if c, err = s.ln.Accept(); err == nil {
+            log.Printf("Pipe %s: feeder connected from %s", s.input, c.RemoteAddr())
+            r = c
+        }
+    default:
+        // A named pipe blocks here until a writer opens it
+        r, err = os.Open(s.input)
+    }
+    if err != nil { return nil, err }
+    s.mu.Lock()
+    s.cur = r
+    s.mu.Unlock()
+    return r, nil
+}
+
+// read delivers frames from one feed until it ends; a clean end is no
+// error.
+func (s *PipeSource) read(r io.Reader) (n int, err error) {
+    f := s.format
+    br := bufio.NewReaderSize(r, min(f.frameBytes(), 4<<20))
+    // ffmpeg writes yuv420p in limited range
+    color := ColorSpaceFor(f.W, f.H)
+    color.Range = RangeLimited
+    for {
+        var cur Frame
+        var buf []byte
+        if f.PixFmt == "i420" {
+            cur = newI420Frame(f.W, f.H)
+            cur.Color = color
+            buf = cur.Y[:f.frameBytes()]
+        } else {
+            buf = make([]byte, f.frameBytes())
+            cur = Frame{Data: buf, W: f.W, H: f.H, PixFmt: "bgra"}
+        }
+        got, err := io.ReadFull(br, buf)
+        switch {
+        case err == io.EOF:
+            return n, nil
+        case errors.Is(err, io.ErrUnexpectedEOF):
+            s.partial.Add(1)
+            log.Printf("Pipe %s: dropped a partial frame (%d of %d bytes)", s.input, got, len(buf))
+            return n, nil
+        case err != nil:
+            return n, err
+        }
+        cur.At = time.Now()
+        s.last.Store(&ndiFrame{Frame: cur})
+        s.lastAt.Store(cur.At.UnixNano())
+        s.sizes.publish(f.W, f.H)
+        s.frames.publish(cur)
+        s.count.Add(1)
+        if n++; n == 1 {
+            s.mu.Lock()
+            s.state, s.lastErr = PipePlaying, ""
+            s.mu.Unlock()
+            log.Printf("Pipe %s: receiving %s", s.input, f)
+        }
+    }
+}
+
+// Status reports the source for /health.
+func (s *PipeSource) Status() PipeStatus {
+    s.mu.Lock()
+    st := PipeStatus{Input: s.input, Format: s.format.String(), State: s.state, LastError: s.lastErr}
+    s.mu.Unlock()
+    st.Feeds, st.Frames, st.Partial = s.feeds.Load(), s.count.Load(), s.partial.Load()
+    since := s.started
+    if n := s.lastAt.Load(); n != 0 {
+        t := time.Unix(0, n).UTC()
+        st.LastFrame, since = &t, t
+    }
+    st.Stalled = time.Since(since) > pipeStallAfter
+    return st
+}
+
+func (s *PipeSource) latest() *ndiFrame {
+    f, _ := s.last.Load().(*ndiFrame)
+    return f
+}
+
+// Next returns the latest frame as BGRA.
+func (s *PipeSource) Next() ([]byte, bool) {
+    f := s.latest()
+    if f == nil { return nil, true }
+    return f.pixels(), true
+}
+
+// NextI420 returns the latest frame's planes for an I420 pipe.
+func (s *PipeSource) NextI420() (Frame, bool) {
+    f := s.latest()
+    if f == nil || f.PixFmt != "i420" { return Frame{}, false }
+    return f.Frame, true
+}
+
+// Last returns the latest frame as BGRA, with its size.
+func (s *PipeSource) Last() ([]byte, int, int, bool) {
+    f := s.latest()
+    if f == nil { return nil, 0, 0, false }
+    return f.pixels(), f.W, f.H, true
+}
+
+// PixFmt is "bgra", the format of Next and Last.
+func (s *PipeSource) PixFmt() string { return "bgra" }
+
+// SizeChanges announces the pipe's frame size with its first frame.
+func (s *PipeSource) SizeChanges() <-chan FrameSize { return s.sizes.subscribe() }
+
+// Frames delivers each frame as it is read; see NDISource.Frames.
+func (s *PipeSource) Frames() <-chan Frame { return s.frames.subscribe() }
+
+// StopFrames ends a Frames subscription and closes its channel.
+func (s *PipeSource) StopFrames(ch <-chan Frame) { s.frames.unsubscribe(ch) }
+
+// FrameRate is the rate the pipe format names.
+func (s *PipeSource) FrameRate() (num, den int) { return s.format.Rate.Num, s.format.Rate.Den }
+
+// LastFrameAt reports when the latest frame was read; zero before the
+// first one.
+func (s *PipeSource) LastFrameAt() (time.Time, bool) {
+    if n := s.lastAt.Load(); n != 0 { return time.Unix(0, n), true }
+    return time.Time{}, true
+}
+
+// Stop ends the source: it closes the listener and the feed being read.
+func (s *PipeSource) Stop() {
+    if !s.stopped.CompareAndSwap(false, true) { return }
+    close(s.quit)
+    if s.ln != nil { s.ln.Close() }
+    s.mu.Lock()
+    if s.cur != nil { s.cur.Close() }
+    s.mu.Unlock()
+}
@@ -0,0 +1,382 @@
+package stream
+
+import (
+    "bufio"
+    "bytes"
+    "context"
+    "errors"
+    "fmt"
+    "io"
+    "log"
+    "net/url"
+    "os/exec"
+    "path/filepath"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+    "sync/atomic"
+    "time"
+)
+
+// RTSP cameras are read through ffmpeg: it pulls the stream (H.264, H.265,
+// MJPEG, whatever it decodes) over TCP and writes raw I420 to a pipe, which
+// the source cuts into frames. ffprobe, next to it, tells the camera's size
+// and rate first. A session that fails, or stops delivering, is started
+// again after a backoff that doubles up to rtspBackoffMax and starts over
+// once frames flow again. Audio isn't read.
+
+const (
+    rtspStallAfter   = 5 * time.Second  // no frame for this long: stalled
+    rtspRestartAfter = 15 * time.Second // ...and ffmpeg is restarted
+    rtspBackoffMin   = 500 * time.Millisecond
+    rtspBackoffMax   = 30 * time.Second
+    rtspProbeTimeout = 20 * time.Second
+)
+
+// RTSP source states, as RTSPStats reports them.
+const (
+    RTSPConnecting   = "connecting"
+    RTSPPlaying      = "playing"
+    RTSPReconnecting = "reconnecting"
+)
+
+var ffmpegPath atomic.Value // string
+
+// SetFFmpegPath sets the ffmpeg RTSP sources run, "ffmpeg" from PATH by
+// default. ffprobe is looked for in the same directory.
+func SetFFmpegPath(p string) {
+    if p == "" { p = "ffmpeg" }
+    ffmpegPath.Store(p)
+}
+
+// FFmpegPath is the ffmpeg RTSP sources run.
+func FFmpegPath() string {
+    if p, _ := ffmpegPath.Load().(string); p != "" { return p }
+    return "ffmpeg"
+}
+
+// ffprobePath is ffprobe beside ffmpeg, with its extension (.exe).
+func ffprobePath(ffmpeg string) string {
+    dir, base := filepath.Split(ffmpeg)
+    ext := filepath.Ext(base)
+    if !strings.EqualFold(strings.TrimSuffix(base, ext), "ffmpeg") { ext = "" }
+    return dir + "ffprobe" + ext
+}
+
+// RedactURL is u with its password hidden, for logs and status; u itself
+// when it doesn't parse.
+func RedactURL(u string) string {
+    p, err := url.Parse(u)
+    if err != nil { return u }
+    return p.Redacted()
+}
+
+// RTSPStatus is an RTSP source in /health.
+type RTSPStatus struct {
+    URL        string     `json:"url"` // password redacted
+    State      string     `json:"state"`
+    Stalled    bool       `json:"stalled"` // no frame for rtspStallAfter
+    Width      int        `json:"width,omitempty"`
+    Height     int        `json:"height,omitempty"`
+    FPS        Rate       `json:"fps"`
+    Reconnects uint64     `json:"reconnects"`
+    LastError  string     `json:"lastError,omitempty"`
+    LastFrame  *time.Time `json:"lastFrame,omitempty"`
+}
+
+// rtspLive is every running RTSP source, for RTSPStats.
+var rtspLive struct {
+    mu sync.Mutex
+    m  map[*RTSPSource]struct{}
+}
+
+// RTSPStats reports the running RTSP sources, by URL.
+func RTSPStats() []RTSPStatus {
+    rtspLive.mu.Lock()
+    out := make([]RTSPStatus, 0, len(rtspLive.m))
+    for s := range rtspLive.m { out = append(out, s.Status()) }
+    rtspLive.mu.Unlock()
+    sort.Slice(out, func(i, j int) bool { return out[i].URL < out[j].URL })
+    return out
+}
+
+// RTSPSource reads an RTSP camera through ffmpeg and provides I420 frames,
+// packed to BGRA for Next and Last.
+type RTSPSource struct {
+    url      string
+    redacted string
+    ffmpeg   string
+    last     atomic.Value // *ndiFrame
+    lastAt   atomic.Int64 // UnixNano of the latest frame
+    rate     atomic.Value // Rate ffprobe reported
+    sizes    sizeNotifier
+    frames   frameNotifier
+    quit     chan struct{}
+    stopped  atomic.Bool
+    started  time.Time
+
+    mu         sync.Mutex
+    outW, outH int
+    state      string
+    w, h       int
+    reconnects uint64
+    lastErr    string
+}
+
+// NewRTSPSource starts reading the camera at u (rtsp:// or rtsps://). It
+// fails only when ffmpeg can't be found; the camera being unreachable is
+// retried in the background, like a lost connection.
+func NewRTSPSource(u string) (*RTSPSource, error) {
+    ffmpeg, err := exec.LookPath(FFmpegPath())
+    if err != nil { return nil, fmt.Errorf("rtsp: %w", err) }
+    s := &RTSPSource{url: u, redacted: RedactURL(u), ffmpeg: ffmpeg, quit: make(chan struct{}), started: time.Now(), state: RTSPConnecting}
+    rtspLive.mu.Lock()
+    if rtspLive.m == nil { rtspLive.m = map[*RTSPSource]struct{}{} }
+    rtspLive.m[s] = struct{}{}
+    rtspLive.mu.Unlock()
+    registerSource()
+    go s.loop()
+    return s, nil
+}
+
+func (s *RTSPSource) loop() {
+    defer unregisterSource()
+    defer s.sizes.close()
+    defer s.frames.close()
+    defer func() {
+        rtspLive.mu.Lock()
+        delete(rtspLive.m, s)
+        rtspLive.mu.Unlock()
+    }()
+    backoff := rtspBackoffMin
+    for {
+        delivered, err := s.session()
+        if s.stopped.Load() { return }
+        if delivered { backoff = rtspBackoffMin }
+        s.mu.Lock()
+        s.state, s.lastErr = RTSPReconnecting, err.Error()
+        s.reconnects++
+        s.mu.Unlock()
+        log.Printf("RTSP %s: %v; reconnecting in %s", s.redacted, err, backoff)
+        select {
+        case <-s.quit: return
+        case <-time.After(backoff):
+        }
+        backoff = min(backoff*2, rtspBackoffMax)
+    }
+}
+
+// session probes the camera and reads frames from one ffmpeg until it
+// fails, stalls for rtspRestartAfter or the source stops. delivered tells
+// whether any frame came through.
+func (s *RTSPSource) session() (delivered bool, err error) {
+    ctx, cancel := context.WithCancel(context.Background())
+    defer cancel()
+    go func() {
+        select {
+        case <-s.quit: cancel()
+        case <-ctx.Done():
+        }
+    }()
+    pctx, pcancel := context.WithTimeout(ctx, rtspProbeTimeout)
+    w, h, rate, err := probeRTSP(pctx, ffprobePath(s.ffmpeg), s.url)
+    pcancel()
+    if err != nil { return false, err }
+    if rate.Valid() { s.rate.Store(rate) }
+    color := ColorSpaceFor(w, h)
+    color.Range = RangeLimited
+    s.mu.Lock()
+    if s.outW > 0 && s.outH > 0 { w, h = s.outW, s.outH }
+    s.mu.Unlock()
+    // Scaling to a fixed size also keeps the frames that size if the
+    // camera changes resolution mid-stream
+    cmd := exec.CommandContext(ctx, s.ffmpeg, "-nostdin", "-hide_banner", "-loglevel", "error",
+        "-rtsp_transport", "tcp", "-fflags", "nobuffer", "-flags", "low_delay", "-i", s.url,
+        "-map", "0:v:0", "-an", "-sn", "-dn", "-vf", fmt.Sprintf("scale=%d:%d", w, h),
+        "-pix_fmt", "yuv420p", "-f", "rawvideo", "pipe:1")
+    stdout, err := cmd.StdoutPipe()
+    if err != nil { return false, err }
+    var stderr tailWriter
+    cmd.Stderr = &stderr
+    if err := cmd.Start(); err != nil { return false, err }
+    // Restart a session that stopped delivering: ffmpeg may wait on a dead
+    // TCP connection for a long time
+    var lastFrame atomic.Int64
+    lastFrame.Store(time.Now().UnixNano())
+    go func() {
+        t := time.NewTicker(time.Second)
+        defer t.Stop()
+        for {
+            select {
+            case <-ctx.Done(): return
+            case <-t.C:
+                if time.Since(time.Unix(0, lastFrame.Load())) > rtspRestartAfter { cancel(); return }
+            }
+        }
+    }()
+    rd := bufio.NewReaderSize(stdout, 1<<20)
+    for {
+        cur := newI420Frame(w, h)
+        planes := cur.Y[:len(cur.Y)+len(cur.U)+len(cur.V)]
+        if _, err = io.ReadFull(rd, planes); err != nil { break }
+        cur.At, cur.Color = time.Now(), color
+        lastFrame.Store(cur.At.UnixNano())
+        s.last.Store(&ndiFrame{Frame: cur})
+        s.lastAt.Store(cur.At.UnixNano())
+        s.sizes.publish(w, h)
+        s.frames.publish(cur)
+        if !delivered {
+            delivered = true
+            s.mu.Lock()
+            s.state, s.w, s.h, s.lastErr = RTSPPlaying, w, h, ""
+            s.mu.Unlock()
+            log.Printf("RTSP %s: playing %dx%d at %s fps", s.redacted, w, h, rate)
+        }
+    }
+    stalled := ctx.Err() != nil && !s.stopped.Load()
+    cancel()
+    werr := cmd.Wait()
+    switch {
+    case stalled:
+        err = fmt.Errorf("no frames for %s", rtspRestartAfter)
+    case stderr.String() != "":
+        err = fmt.Errorf("ffmpeg: %s", stderr.String())
+    case werr != nil:
+        err = fmt.Errorf("ffmpeg: %w", werr)
+    case errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF):
+        err = errors.New("stream ended")
+    }
+    return delivered, err
+}
+
+// probeRTSP asks ffprobe for the first video stream's size and frame rate.
+func probeRTSP(ctx context.Context, ffprobe, u string) (w, h int, rate Rate, err error) {
+    cmd := exec.CommandContext(ctx, ffprobe, "-v", "error", "-rtsp_transport", "tcp", "-select_streams", "v:0",
+        "-show_entries", "stream=width,height,avg_frame_rate,r_frame_rate", "-of", "default=noprint_wrappers=1", u)
+    var stderr tailWriter
+    cmd.Stderr = &stderr
+    out, err := cmd.Output()
+    if err != nil {
+        if msg := stderr.String(); msg != "" { return 0, 0, Rate{}, fmt.Errorf("ffprobe: %s", msg) }
+        if ctx.Err() != nil { return 0, 0, Rate{}, fmt.Errorf("ffprobe: no answer in %s", rtspProbeTimeout) }
+        return 0, 0, Rate{}, fmt.Errorf("ffprobe: %w", err)
+    }
+    for _, line := range strings.Split(string(out), "\n") {
+        k, v, _ := strings.Cut(strings.TrimSpace(line), "=")
+        switch k {
+        case "width": w, _ = strconv.Atoi(v)
+        case "height": h, _ = strconv.Atoi(v)
+        case "avg_frame_rate":
+            // The average, when known, over the nominal rate
+            if r, err := ParseRate(v); err == nil { rate = r }
+        case "r_frame_rate":
+            if r, err := ParseRate(v); err == nil && !rate.Valid() { rate = r }
+        }
+    }
+    if w <= 0 || h <= 0 { return 0, 0, Rate{}, errors.New("ffprobe: no video stream") }
+    return w &^ 1, h &^ 1, rate, nil
+}
+
+// tailWriter keeps the last line written to it, e.g. ffmpeg's last error.
+type tailWriter struct {
+    mu  sync.Mutex
+    buf []byte
+}
+
+func (t *tailWriter) Write(p []byte) (int, error) {
+    t.mu.Lock()
+    defer t.mu.Unlock()
+    t.buf = append(t.buf, p...)
+    if len(t.buf) > 4096 { t.buf = t.buf[len(t.buf)-4096:] }
+    return len(p), nil
+}
+
+func (t *tailWriter) String() string {
+    t.mu.Lock()
+    defer t.mu.Unlock()
+    b := bytes.TrimSpace(t.buf)
+    if i := bytes.LastIndexByte(b, '\n'); i >= 0 { b = b[i+1:] }
+    return string(bytes.TrimSpace(b))
+}
+
+// Status reports the source for RTSPStats.
+func (s *RTSPSource) Status() RTSPStatus {
+    s.mu.Lock()
+    st := RTSPStatus{URL: s.redacted, State: s.state, Width: s.w, Height: s.h, Reconnects: s.reconnects, LastError: s.lastErr}
+    s.mu.Unlock()
+    st.FPS, _ = s.rate.Load().(Rate)
+    since := s.started
+    if n := s.lastAt.Load(); n != 0 {
+        t := time.Unix(0, n).UTC()
+        st.LastFrame, since = &t, t
+    }
+    st.Stalled = time.Since(since) > rtspStallAfter
+    return st
+}
+
+func (s *RTSPSource) latest() *ndiFrame {
+    f, _ := s.last.Load().(*ndiFrame)
+    return f
+}
+
+// Next returns the latest frame packed to BGRA.
+func (s *RTSPSource) Next() ([]byte, bool) {
+    f := s.latest()
+    if f == nil { return nil, true }
+    return f.pixels(), true
+}
+
+// NextI420 returns the latest frame's planes.
+func (s *RTSPSource) NextI420() (Frame, bool) {
+    f := s.latest()
+    if f == nil { return Frame{}, false }
+    return f.Frame, true
+}
+
+// Last returns the latest frame packed to BGRA, with its size.
+func (s *RTSPSource) Last() ([]byte, int, int, bool) {
+    f := s.latest()
+    if f == nil { return nil, 0, 0, false }
+    return f.pixels(), f.W, f.H, true
+}
+
+// PixFmt is "bgra", the format of Next and Last.
+func (s *RTSPSource) PixFmt() string { return "bgra" }
+
+// SizeChanges announces the size of delivered frames; it changes only
+// when a session reconnects to a camera that changed resolution.
+func (s *RTSPSource) SizeChanges() <-chan FrameSize { return s.sizes.subscribe() }
+
+// Frames delivers each frame as ffmpeg decodes it; see NDISource.Frames.
+func (s *RTSPSource) Frames() <-chan Frame { return s.frames.subscribe() }
+
+// StopFrames ends a Frames subscription and closes its channel.
+func (s *RTSPSource) StopFrames(ch <-chan Frame) { s.frames.unsubscribe(ch) }
+
+// FrameRate is the camera's frame rate as ffprobe reports it; 0/0 before
+// the first probe, or when the camera doesn't tell.
+func (s *RTSPSource) FrameRate() (num, den int) {
+    r, _ := s.rate.Load().(Rate)
+    return r.Num, r.Den
+}
+
+// LastFrameAt reports when the latest frame was decoded; zero before the
+// first one.
+func (s *RTSPSource) LastFrameAt() (time.Time, bool) {
+    if n := s.lastAt.Load(); n != 0 { return time.Unix(0, n), true }
+    return time.Time{}, true
+}
+
+// SetOutputSize has ffmpeg scale frames to w x h (rounded down to even)
+// from the next session on; call it before the first frame.
+func (s *RTSPSource) SetOutputSize(w, h int) {
+    s.mu.Lock()
+    s.outW, s.outH = max(w&^1, 2), max(h&^1, 2)
+    s.mu.Unlock()
+}
+
+// Stop ends the source and its ffmpeg.
+func (s *RTSPSource) Stop() {
+    if s.stopped.CompareAndSwap(false, true) { close(s.quit) }
+}
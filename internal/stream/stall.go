@@ -0,0 +1,142 @@
+package stream
+
+import (
+    "os"
+    "strconv"
+    "strings"
+    "sync/atomic"
+    "time"
+)
+
+// Stalled sources. An NDI sender that closes leaves its receiver waiting
+// on captures that never come; rather than a frozen picture, a source
+// that has had no frame for SourceStallAfter serves a "signal lost" slate
+// at its last frame's size, so the encoder keeps running, until frames
+// come back. The encode loop forces a keyframe on the first frame after a
+// stall.
+
+// defaultStallAfter is SOURCE_STALL_MS's default.
+const defaultStallAfter = 3 * time.Second
+
+// SourceStallAfter is how long a source may go without a frame before it
+// counts as stalled, from SOURCE_STALL_MS (3000 when unset; 0 turns stall
+// detection off).
+func SourceStallAfter() time.Duration {
+    v := strings.TrimSpace(os.Getenv("SOURCE_STALL_MS"))
+    if v == "" { return defaultStallAfter }
+    ms, err := strconv.Atoi(v)
+    if err != nil || ms < 0 { return defaultStallAfter }
+    return time.Duration(ms) * time.Millisecond
+}
+
+// stallSlatePath is the image stalled sources show under the overlay; ""
+// for the synthetic pattern.
+var stallSlatePath atomic.Value // string
+
+// SetStallSlate sets the image (PNG or JPEG) a stalled source shows under
+// "SIGNAL LOST"; "" for the synthetic pattern.
+func SetStallSlate(path string) { stallSlatePath.Store(path) }
+
+// Stalled reports whether src, or the source behind its wrappers, is
+// serving the signal-lost slate.
+func Stalled(src Source) bool {
+    switch s := src.(type) {
+    case *SwitchSource:
+        return Stalled(s.Current())
+    case *AspectSource:
+        return Stalled(s.Inner())
+    case *RegionSource:
+        return Stalled(s.Inner())
+    case *SharedSource:
+        return Stalled(s.Inner())
+    case interface{ Stalled() bool }:
+        return s.Stalled()
+    }
+    return false
+}
+
+// stallGuard is a capture loop's stall state.
+type stallGuard struct {
+    after time.Duration
+    on    bool
+    since time.Time // last real frame, when on
+    frame Frame     // the slate, when on
+    due   time.Time // of the next slate frame
+}
+
+// stallFrame renders the signal-lost slate at w x h (rounded down to
+// even): the stall slate image, else the synthetic pattern, with the
+// overlay on top. Its Seq is fixed, so the encoder skips repeats.
+func stallFrame(w, h int) Frame {
+    w, h = max(w&^1, 2), max(h&^1, 2)
+    var out Frame
+    if path, _ := stallSlatePath.Load().(string); path != "" {
+        if img, iw, ih, err := loadImageBGRA(path); err == nil {
+            out, err = slateFrame(img, iw, ih, w, h)
+            if err != nil { out = Frame{} }
+        }
+    }
+    if out.W == 0 {
+        buf, _ := NewSynthetic(w, h, 30, 1).Next()
+        out = newI420Frame(w, h)
+        out.Color = ColorSpaceFor(w, h)
+        out.Color.Range = RangeLimited
+        BGRAtoI420Space(buf, w, h, out.Y, out.U, out.V, out.Color)
+    }
+    drawSignalLost(out)
+    out.Seq = frameSeq.Add(1)
+    return out
+}
+
+// signalLostGlyphs are 5x7 glyphs for the overlay's letters.
+var signalLostGlyphs = map[rune][7]string{
+    'S': {".####", "#....", "#....", ".###.", "....#", "....#", "####."},
+    'I': {"#####", "..#..", "..#..", "..#..", "..#..", "..#..", "#####"},
+    'G': {".####", "#....", "#....", "#.###", "#...#", "#...#", ".###."},
+    'N': {"#...#", "##..#", "#.#.#", "#.#.#", "#..##", "#...#", "#...#"},
+    'A': {".###.", "#...#", "#...#", "#####", "#...#", "#...#", "#...#"},
+    'L': {"#....", "#....", "#....", "#....", "#....", "#....", "#####"},
+    'O': {".###.", "#...#", "#...#", "#...#", "#...#", "#...#", ".###."},
+    'T': {"#####", "..#..", "..#..", "..#..", "..#..", "..#..", "..#.."},
+}
+
+// drawSignalLost writes "SIGNAL LOST" in white on a dimmed, grey band
+// across the middle of an I420 frame.
+func drawSignalLost(f Frame) { drawBanner(f, "SIGNAL LOST") }
+
+// drawBanner writes text, in signalLostGlyphs' letters, in white on a
+// dimmed, grey band across the middle of an I420 frame.
+func drawBanner(f Frame, text string) {
+    // Glyphs are 5 wide with a column between, 7 tall
+    scale := max(f.H/120, 1)
+    for scale > 1 && len(text)*6*scale+4*scale > f.W { scale-- }
+    tw, th := (len(text)*6-1)*scale, 7*scale
+    pad := 3 * scale
+    bx, by := max((f.W-tw)/2-pad, 0)&^1, max((f.H-th)/2-pad, 0)&^1
+    bw, bh := min(tw+2*pad, f.W-bx)&^1, min(th+2*pad, f.H-by)&^1
+    for y := by; y < by+bh; y++ {
+        row := f.Y[y*f.W:]
+        for x := bx; x < bx+bw; x++ { row[x] = byte(16 + max(int(row[x])-16, 0)/4) }
+    }
+    cw := f.W / 2
+    for y := by / 2; y < (by+bh)/2; y++ {
+        for x := bx / 2; x < (bx+bw)/2; x++ { f.U[y*cw+x], f.V[y*cw+x] = 128, 128 }
+    }
+    ox, oy := bx+pad, by+pad
+    for i, c := range text {
+        g, ok := signalLostGlyphs[c]
+        if !ok { continue }
+        for gy, line := range g {
+            for gx := range line {
+                if line[gx] != '#' { continue }
+                for dy := 0; dy < scale; dy++ {
+                    y := oy + gy*scale + dy
+                    if y >= f.H { continue }
+                    for dx := 0; dx < scale; dx++ {
+                        if x := ox + (i*6+gx)*scale + dx; x < f.W { f.Y[y*f.W+x] = 235 }
+                    }
+                }
+            }
+        }
+    }
+}
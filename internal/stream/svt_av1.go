@@ -30,14 +30,26 @@ static EbBufferHeaderType* go_svt_alloc_bufhdr() {
     return bh;
 }
 
+// End of stream: an empty buffer header carrying only the EOS flag
+static EbErrorType go_svt_send_eos(EbComponentType *handle) {
+    EbBufferHeaderType eos;
+    memset(&eos, 0, sizeof(eos));
+    eos.flags = EB_BUFFERFLAG_EOS;
+    return svt_av1_enc_send_picture(handle, &eos);
+}
+
 */
 import "C"
 
 import (
     "errors"
+    "log"
     "unsafe"
 )
 
+// av1Lib names the AV1 encoder in the capability table.
+const av1Lib = "SVT-AV1"
+
 // AV1Encoder backed by SVT-AV1
 type AV1Encoder struct {
     handle *C.EbComponentType
@@ -45,19 +57,28 @@ type AV1Encoder struct {
     io     *C.EbSvtIOFormat
     hdr    *C.EbBufferHeaderType
     w, h   int
-    fps    int
+    fps    Rate
     ybuf, ubuf, vbuf unsafe.Pointer
     open   bool
+    force  bool  // next frame is encoded as a keyframe
+    pts    int64 // frames sent; each picture's pts is its number from 1
+    eos    bool  // end of stream sent
+    last   encodeResult
+    pkts   []packetInfo // for the packets of the last call
 }
 
 type AV1Config struct {
     Width, Height int
-    FPS           int
+    FPS           Rate
     BitrateKbps   int
+    Keyint        int // intra_period_length + 1; 0 for SVT's default
+    RateControl   string // RateCQ for CRF capped at BitrateKbps, else VBR
+    CRF           int    // qp in CQ mode
+    AV1Tuning            // Preset is enc_mode
 }
 
 func NewAV1Encoder(cfg AV1Config) (*AV1Encoder, error) {
-    if cfg.Width <= 0 || cfg.Height <= 0 || cfg.FPS <= 0 { return nil, errors.New("invalid AV1 encoder config") }
+    if cfg.Width <= 0 || cfg.Height <= 0 || !cfg.FPS.Valid() { return nil, errors.New("invalid AV1 encoder config") }
     e := &AV1Encoder{w: cfg.Width, h: cfg.Height, fps: cfg.FPS}
     if C.go_svt_default_cfg(&e.cfg) != C.EB_ErrorNone {
         return nil, errors.New("svt default config failed")
@@ -65,14 +86,34 @@ func NewAV1Encoder(cfg AV1Config) (*AV1Encoder, error) {
     e.cfg.source_width = C.uint32_t(cfg.Width)
     e.cfg.source_height = C.uint32_t(cfg.Height)
     // Frame rate as numerator/denominator
-    e.cfg.frame_rate_numerator = C.uint32_t(cfg.FPS)
-    e.cfg.frame_rate_denominator = 1
-    if cfg.BitrateKbps > 0 {
+    e.cfg.frame_rate_numerator = C.uint32_t(cfg.FPS.Num)
+    e.cfg.frame_rate_denominator = C.uint32_t(cfg.FPS.Den)
+    switch {
+    case cfg.RateControl == RateCQ:
+        e.cfg.rate_control_mode = 0 // CRF
+        e.cfg.qp = C.uint32_t(cqLevel(cfg.CRF))
+        if cfg.BitrateKbps > 0 { e.cfg.max_bit_rate = C.uint32_t(cfg.BitrateKbps * 1000) }
+    case cfg.BitrateKbps > 0:
         e.cfg.rate_control_mode = 1 // VBR
+        // The low-delay structure takes CBR, not VBR
+        if cfg.LowLatency { e.cfg.rate_control_mode = 2 }
         e.cfg.target_bit_rate = C.uint32_t(cfg.BitrateKbps * 1000)
     }
     // realtime speed preset (higher is faster, lower latency)
-    e.cfg.enc_mode = 8
+    e.cfg.enc_mode = C.int8_t(cfg.presetOr(8, MaxAV1Preset))
+    e.cfg.tile_columns = C.int32_t(cfg.tileColumnsFor(cfg.Width))
+    e.cfg.tile_rows = C.int32_t(cfg.TileRows)
+    if cfg.LowLatency {
+        // Low-delay prediction: no frames held back to reorder, so each
+        // picture sent comes out as the next packet
+        e.cfg.pred_structure = 1 // SVT_AV1_PRED_LOW_DELAY_B
+        e.cfg.look_ahead_distance = 0
+        e.cfg.scene_change_detection = 0
+    }
+    // Honour pic_type on input buffers so ForceKeyframe works
+    e.cfg.force_key_frames = true
+    // SVT counts the frames after a keyframe, up to the next
+    if cfg.Keyint > 0 { e.cfg.intra_period_length = C.int32_t(cfg.Keyint - 1) }
 
     // Create handle with cfg loaded
     if C.svt_av1_enc_init_handle(&e.handle, nil, &e.cfg) != C.EB_ErrorNone {
@@ -127,26 +168,84 @@ func (e *AV1Encoder) EncodeI420(y, u, v []byte) (out [][]byte, keyframe bool, er
     C.memcpy(e.ubuf, unsafe.Pointer(&u[0]), C.size_t((e.w/2)*(e.h/2)))
     C.memcpy(e.vbuf, unsafe.Pointer(&v[0]), C.size_t((e.w/2)*(e.h/2)))
 
-    e.hdr.n_pts++
+    e.pts++
+    e.hdr.pts = C.int64_t(e.pts)
+    e.hdr.pic_type = C.EB_AV1_INVALID_PICTURE // encoder decides
+    if e.force { e.hdr.pic_type = C.EB_AV1_KEY_PICTURE; e.force = false }
     if C.svt_av1_enc_send_picture(e.handle, e.hdr) != C.EB_ErrorNone {
         return nil, false, errors.New("svt send picture failed")
     }
-    // Drain available packets (non-blocking)
+    // Drain available packets (non-blocking). With lookahead or a
+    // random-access structure these are pictures sent calls ago
+    e.pkts = e.pkts[:0]
     for {
-        var pkt *C.EbBufferHeaderType
-        if C.svt_av1_enc_get_packet(e.handle, &pkt, 0) != C.EB_ErrorNone || pkt == nil {
-            break
-        }
-        if pkt.n_filled_len > 0 && pkt.p_buffer != nil {
-            goBytes := C.GoBytes(unsafe.Pointer(pkt.p_buffer), C.int(pkt.n_filled_len))
-            out = append(out, goBytes)
-        }
-        C.svt_av1_enc_release_out_buffer(&pkt)
+        au, info, ok, _ := e.getPacket(false)
+        if !ok { break }
+        if au == nil { continue }
+        out = append(out, au)
+        e.pkts = append(e.pkts, info)
+        keyframe = keyframe || info.key
     }
+    e.last = encodeOutput
+    if len(out) == 0 { e.last = encodeBuffered }
     return out, keyframe, nil
 }
 
+// getPacket takes the next packet, waiting for one with block: its data
+// (nil when empty), the picture it holds and whether it ends the stream.
+// ok is false when there was none.
+func (e *AV1Encoder) getPacket(block bool) (au []byte, info packetInfo, ok, eos bool) {
+    var pkt *C.EbBufferHeaderType
+    wait := C.uint8_t(0)
+    if block { wait = 1 }
+    if C.svt_av1_enc_get_packet(e.handle, &pkt, wait) != C.EB_ErrorNone || pkt == nil { return nil, info, false, false }
+    eos = pkt.flags&C.EB_BUFFERFLAG_EOS != 0
+    if pkt.n_filled_len > 0 && pkt.p_buffer != nil {
+        au = C.GoBytes(unsafe.Pointer(pkt.p_buffer), C.int(pkt.n_filled_len))
+        info = packetInfo{frame: int64(pkt.pts), key: pkt.pic_type == C.EB_AV1_KEY_PICTURE}
+    }
+    C.svt_av1_enc_release_out_buffer(&pkt)
+    return au, info, true, eos
+}
+
+// Flush sends end of stream and collects the remaining packets, blocking
+// until SVT-AV1 returns the EOS packet; lastPackets then describes them.
+// No frames may be encoded after it; Close must still be called.
+func (e *AV1Encoder) Flush() (out [][]byte, err error) {
+    e.pkts = e.pkts[:0]
+    if !e.open || e.eos { return nil, nil }
+    e.eos = true
+    if C.go_svt_send_eos(e.handle) != C.EB_ErrorNone {
+        return nil, errors.New("svt send eos failed")
+    }
+    for {
+        au, info, ok, eos := e.getPacket(true)
+        if !ok { return out, nil }
+        if au != nil { out = append(out, au); e.pkts = append(e.pkts, info) }
+        if eos { return out, nil }
+    }
+}
+
+// lastPackets describes each packet the last EncodeI420 or Flush returned.
+func (e *AV1Encoder) lastPackets() []packetInfo { return e.pkts }
+
+// ForceKeyframe makes the next EncodeI420 produce a keyframe.
+func (e *AV1Encoder) ForceKeyframe() { e.force = true }
+
+// lastResult reports how the last EncodeI420 came out. SVT-AV1 has no frame
+// dropping, so a call with no output is its pipeline delay.
+func (e *AV1Encoder) lastResult() encodeResult { return e.last }
+
+// SetBitrate is unsupported: SVT-AV1 only takes a new target on reinit.
+func (e *AV1Encoder) SetBitrate(kbps int) error { return ErrBitrateUnsupported }
+
+// Close ends the stream if Flush hasn't, so SVT-AV1's threads wind down
+// with nothing in flight before it is deinitialized; packets still held
+// are discarded. Pipelines call Flush first and send them.
 func (e *AV1Encoder) Close() {
+    if e.open && !e.eos {
+        if _, err := e.Flush(); err != nil { log.Printf("svt close: %v", err) }
+    }
     if e.handle != nil {
         _ = C.svt_av1_enc_deinit(e.handle)
         _ = C.svt_av1_enc_deinit_handle(e.handle)
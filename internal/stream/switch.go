@@ -0,0 +1,254 @@
+package stream
+
+import (
+    "sync"
+    "time"
+)
+
+// SwitchSource forwards frames from one source at a time and can hand over
+// to another at a scheduled instant: the first Next at or after it returns
+// the new source's frame. The encoder reading it keeps running, so the cut
+// lands on a frame boundary without a pipeline restart.
+//
+// Both sources should deliver the same size and pixel format; the caller
+// falls back to a restart when they don't.
+type SwitchSource struct {
+    mu   sync.Mutex
+    cur  Source
+    next Source
+    at   time.Time
+    // done is called (outside the lock) with the instant of the swap
+    done func(achieved time.Time)
+    // sizes carries the current source's size changes
+    sizes sizeNotifier
+    // frames carries the current source's frames once someone subscribes
+    frames   frameNotifier
+    pumpOnce sync.Once
+    changed  chan struct{} // wakes the pump after Schedule or Cancel
+    quit     chan struct{}
+    stopOnce sync.Once
+}
+
+// NewSwitchSource wraps src, which must not be nil.
+func NewSwitchSource(src Source) *SwitchSource {
+    s := &SwitchSource{cur: src, changed: make(chan struct{}, 1), quit: make(chan struct{})}
+    s.follow(src)
+    return s
+}
+
+// follow forwards src's size changes for as long as it is current.
+func (s *SwitchSource) follow(src Source) {
+    ch, ok := SizeChanges(src)
+    if !ok { return }
+    go func() {
+        for fs := range ch {
+            s.mu.Lock()
+            cur := s.cur == src
+            if cur { s.sizes.publish(fs.W, fs.H) }
+            s.mu.Unlock()
+            if !cur { return }
+        }
+    }()
+}
+
+// SizeChanges announces the current source's frame sizes, including the
+// new source's size at a swap.
+func (s *SwitchSource) SizeChanges() <-chan FrameSize { return s.sizes.subscribe() }
+
+// Frames delivers the current source's frames and, at a scheduled swap,
+// the new source's from its first frame at or after the switch time. Only
+// useful while the current source pushes frames (HasFrames).
+func (s *SwitchSource) Frames() <-chan Frame {
+    s.pumpOnce.Do(func() { go s.pump() })
+    return s.frames.subscribe()
+}
+
+// StopFrames ends a Frames subscription and closes its channel.
+func (s *SwitchSource) StopFrames(ch <-chan Frame) { s.frames.unsubscribe(ch) }
+
+// pump forwards frames until the switch stops or its current source ends.
+// It also listens to a scheduled source, so a cut away from a source that
+// stalled happens on the new source's first frame.
+func (s *SwitchSource) pump() {
+    defer s.frames.close()
+    var cur, next Source
+    var curCh, nextCh <-chan Frame
+    stopCur, stopNext := func() {}, func() {}
+    defer func() { stopCur(); stopNext() }()
+    for {
+        s.mu.Lock()
+        wantCur, wantNext := s.cur, s.next
+        s.mu.Unlock()
+        if wantCur != cur {
+            stopCur()
+            cur, curCh, stopCur = wantCur, nil, func() {}
+            if ch, stop, ok := Frames(cur); ok { curCh, stopCur = ch, stop }
+        }
+        if wantNext != next {
+            stopNext()
+            next, nextCh, stopNext = wantNext, nil, func() {}
+            if ch, stop, ok := Frames(next); ok { nextCh, stopNext = ch, stop }
+        }
+        select {
+        case <-s.quit:
+            return
+        case <-s.changed:
+        case f, ok := <-curCh:
+            if !ok {
+                if s.Current() == cur { return }
+                curCh = nil // swapped by Next meanwhile
+                continue
+            }
+            // A frame from a source just cut away from is dropped
+            if s.swapDue() == cur { s.frames.publish(f) }
+        case f, ok := <-nextCh:
+            if !ok { nextCh = nil; continue }
+            if s.swapDue() == next { s.frames.publish(f) }
+        }
+    }
+}
+
+// poke wakes the pump to pick up a changed schedule.
+func (s *SwitchSource) poke() {
+    select { case s.changed <- struct{}{}: default: }
+}
+
+// Current returns the source frames are taken from.
+func (s *SwitchSource) Current() Source {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    return s.cur
+}
+
+// Schedule makes next current at the first frame pulled at or after at,
+// once next has a frame of its own. The replaced source is stopped. A
+// previously scheduled source is stopped and replaced.
+func (s *SwitchSource) Schedule(next Source, at time.Time, done func(achieved time.Time)) {
+    s.mu.Lock()
+    old := s.next
+    s.next, s.at, s.done = next, at, done
+    s.mu.Unlock()
+    s.poke()
+    if old != nil && old != next { old.Stop() }
+}
+
+// Cancel drops a scheduled swap and stops its source. It reports whether
+// one was pending.
+func (s *SwitchSource) Cancel() bool {
+    s.mu.Lock()
+    old := s.next
+    s.next, s.done = nil, nil
+    s.mu.Unlock()
+    s.poke()
+    if old != nil { old.Stop() }
+    return old != nil
+}
+
+func (s *SwitchSource) Next() ([]byte, bool) { return s.swapDue().Next() }
+
+// NextI420 hands over the current source's frame in I420 when it keeps one.
+func (s *SwitchSource) NextI420() (Frame, bool) {
+    if p, ok := s.swapDue().(sourceWithI420); ok { return p.NextI420() }
+    return Frame{}, false
+}
+
+// swapDue makes the scheduled source current if its time has come and it
+// has a frame, and returns the current source.
+func (s *SwitchSource) swapDue() Source {
+    s.mu.Lock()
+    var replaced Source
+    var done func(time.Time)
+    var now time.Time
+    if s.next != nil {
+        now = time.Now()
+        if !now.Before(s.at) && hasFrame(s.next) {
+            replaced, s.cur, s.next = s.cur, s.next, nil
+            done, s.done = s.done, nil
+        }
+    }
+    cur := s.cur
+    s.mu.Unlock()
+    if replaced != nil {
+        s.follow(cur)
+        replaced.Stop()
+        if done != nil { done(now) }
+    }
+    return cur
+}
+
+// Stop stops the current source and any scheduled one.
+func (s *SwitchSource) Stop() {
+    s.mu.Lock()
+    cur, next := s.cur, s.next
+    s.next = nil
+    s.mu.Unlock()
+    if next != nil { next.Stop() }
+    cur.Stop()
+    s.sizes.close()
+    s.stopOnce.Do(func() { close(s.quit) })
+}
+
+// Last reports the current source's latest frame and its size.
+func (s *SwitchSource) Last() ([]byte, int, int, bool) {
+    if l, ok := s.Current().(sourceWithLast); ok { return l.Last() }
+    return nil, 0, 0, false
+}
+
+// LastFrameAt reports the current source's latest frame time.
+func (s *SwitchSource) LastFrameAt() (time.Time, bool) { return LastFrameAt(s.Current()) }
+
+// FrameRate reports the current source's frame rate (0/0 if unknown).
+func (s *SwitchSource) FrameRate() (num, den int) {
+    r, _ := SourceRate(s.Current(), 0)
+    return r.Num, r.Den
+}
+
+// PixFmt reports the current source's pixel format ("" = BGRA).
+func (s *SwitchSource) PixFmt() string { return PixFmtOf(s.Current()) }
+
+// PixFmtOf returns src's pixel format, "bgra" unless it reports another.
+func PixFmtOf(src Source) string {
+    if pf, ok := src.(interface{ PixFmt() string }); ok && pf.PixFmt() != "" { return pf.PixFmt() }
+    return "bgra"
+}
+
+// SourceRate returns src's own frame rate, waiting up to wait for a source
+// that reports one to receive its first frame. ok is false for sources
+// without a rate (synthetic ones render at whatever rate they are pulled).
+func SourceRate(src Source, wait time.Duration) (r Rate, ok bool) {
+    switch s := src.(type) {
+    case *SwitchSource:
+        return SourceRate(s.Current(), wait)
+    case *AspectSource:
+        return SourceRate(s.Inner(), wait)
+    case *RegionSource:
+        return SourceRate(s.Inner(), wait)
+    case *SharedSource:
+        return SourceRate(s.Inner(), wait)
+    }
+    fr, ok := src.(interface{ FrameRate() (num, den int) })
+    if !ok { return Rate{}, false }
+    deadline := time.Now().Add(wait)
+    for {
+        num, den := fr.FrameRate()
+        if r = (Rate{num, den}); r.Valid() || !time.Now().Before(deadline) { return r, r.Valid() }
+        time.Sleep(50 * time.Millisecond)
+    }
+}
+
+// hasFrame reports whether src has delivered a frame; sources that can't
+// tell are assumed ready.
+func hasFrame(src Source) bool {
+    if l, ok := src.(sourceWithLast); ok {
+        _, w, h, ok := l.Last()
+        return ok && w > 0 && h > 0
+    }
+    return true
+}
+
+// LastFrameAt returns when src last received a frame. ok is false for
+// sources that don't track it (synthetic ones produce frames on demand).
+func LastFrameAt(src Source) (t time.Time, ok bool) {
+    if v, ok := src.(interface{ LastFrameAt() (time.Time, bool) }); ok { return v.LastFrameAt() }
+    return time.Time{}, false
+}
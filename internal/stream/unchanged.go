@@ -0,0 +1,79 @@
+package stream
+
+import (
+    "encoding/binary"
+    "hash/maphash"
+    "os"
+    "strings"
+    "sync/atomic"
+    "time"
+)
+
+// Still pictures (slides, scoreboards) are encoded once. A source tells a
+// new picture from a repeat by its Frame.Seq; the encode loop leaves out
+// frames whose Seq it has already encoded, sending the picture again only
+// every unchangedKeepalive so late joiners and lossy viewers recover.
+
+// unchangedKeepalive is how often an unchanged picture is encoded again.
+const unchangedKeepalive = time.Second
+
+// frameSeq numbers pictures across every source, so a Seq never repeats
+// after a hot swap to another source.
+var frameSeq atomic.Uint64
+
+// SkipUnchanged reports whether unchanged frames are left out of the
+// encode, from VIDEO_SKIP_UNCHANGED (on unless 0, false or off). Turn it
+// off for viewers that rely on a constant frame cadence.
+func SkipUnchanged() bool {
+    switch strings.ToLower(strings.TrimSpace(os.Getenv("VIDEO_SKIP_UNCHANGED"))) {
+    case "0", "false", "off", "no":
+        return false
+    }
+    return true
+}
+
+// frameHasher tells a capture from the one before it by hashing its bytes,
+// so a sender repeating a still picture costs a hash rather than a
+// conversion and an encode.
+type frameHasher struct {
+    h   maphash.Hash
+    sum uint64
+    seq uint64
+}
+
+// next returns the Seq for a capture of data laid out as params say: the
+// last one's when nothing differs, else a new one, and whether it changed.
+func (fh *frameHasher) next(data []byte, params ...int) (uint64, bool) {
+    fh.h.Reset()
+    var b [8]byte
+    for _, p := range params {
+        binary.LittleEndian.PutUint64(b[:], uint64(p))
+        fh.h.Write(b[:])
+    }
+    fh.h.Write(data)
+    sum := fh.h.Sum64()
+    if fh.seq != 0 && sum == fh.sum { return fh.seq, false }
+    fh.sum, fh.seq = sum, frameSeq.Add(1)
+    return fh.seq, true
+}
+
+// unchangedSkipper decides, in the encode loop, which frames repeat the
+// last one encoded and can be left out.
+type unchangedSkipper struct {
+    on   bool
+    seq  uint64    // Seq of the last frame encoded
+    sent time.Time // when it was
+    held int       // frames left out since
+}
+
+// skip reports whether f can be left out at now: it repeats the last frame
+// encoded, no keyframe is due and the keepalive hasn't come round. Frames
+// without a Seq are always encoded.
+func (u *unchangedSkipper) skip(f Frame, now time.Time, kf *keyframeRequest) bool {
+    if !u.on || f.Seq == 0 || f.Seq != u.seq || kf.due(now) || now.Sub(u.sent) >= unchangedKeepalive { return false }
+    u.held++
+    return true
+}
+
+// encoded records f as encoded at now.
+func (u *unchangedSkipper) encoded(f Frame, now time.Time) { u.seq, u.sent, u.held = f.Seq, now, 0 }
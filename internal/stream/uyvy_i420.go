@@ -5,59 +5,41 @@ package stream
 // UYVYtoI420 converts packed UYVY 4:2:2 (2 bytes per pixel) to planar I420 4:2:0.
 // Assumes width and height are even.
 func UYVYtoI420(src []byte, w, h int, yPlane, uPlane, vPlane []byte) {
-    // For each line, process pairs of pixels: U0 Y0 V0 Y1
-    // Chroma is subsampled vertically by averaging two lines.
-    // Temporary buffers for half-resolution chroma per row
+    UYVYtoI420Stride(src, w*2, w, h, yPlane, uPlane, vPlane)
+}
+
+// UYVYtoI420Stride is UYVYtoI420 for rows stride bytes apart, for senders
+// that pad their lines. Rows are converted in parallel.
+func UYVYtoI420Stride(src []byte, stride, w, h int, yPlane, uPlane, vPlane []byte) {
+    parallelRows(h, 2, func(r0, r1 int) { uyvyToI420Rows(src, stride, w, h, yPlane, uPlane, vPlane, r0, r1) })
+}
+
+// uyvyToI420Rows converts rows [r0, r1); r0 is even. Each pixel pair is
+// U0 Y0 V0 Y1; chroma is the average of a row pair, or an odd last row's
+// own.
+func uyvyToI420Rows(src []byte, stride, w, h int, yPlane, uPlane, vPlane []byte, r0, r1 int) {
     halfW := w / 2
-    // Accumulate U and V for two lines, then store to output each 2 rows
-    for row := 0; row < h; row++ {
-        srcOff := row * w * 2
-        // Write luma for this row directly
-        yi := row * w
-        for x := 0; x < w; x += 2 {
+    for row := r0; row < r1; row++ {
+        srcOff, yi := row*stride, row*w
+        for x := 0; x+1 < w; x += 2 {
             i := srcOff + x*2
-            u := int(src[i+0])
-            y0 := src[i+1]
-            v := int(src[i+2])
-            y1 := src[i+3]
-            yPlane[yi+x+0] = y0
-            yPlane[yi+x+1] = y1
-            // For chroma, accumulate on even rows only; we'll average with the next row
-            if (row & 1) == 0 {
-                // Store interim sums in uPlane/vPlane areas beyond valid region to avoid extra alloc
-                // Use the actual chroma destinations as accumulation buffers by writing sums as uint16 in place is complex.
-                // Simpler: write to temporary stack arrays per row.
-            }
-            _ = u; _ = v // handled below
+            yPlane[yi+x] = src[i+1]
+            yPlane[yi+x+1] = src[i+3]
         }
-        // Now compute chroma on even rows by averaging this row and the next row
-        if (row & 1) == 0 {
-            // Average U and V across a 2x2 block
-            nextSrcOff := srcOff + w*2
-            if row+1 < h {
-                for cx := 0; cx < halfW; cx++ {
-                    // current row samples
-                    i0 := srcOff + cx*4
-                    u0 := int(src[i0+0])
-                    v0 := int(src[i0+2])
-                    // next row samples
-                    i1 := nextSrcOff + cx*4
-                    u1 := int(src[i1+0])
-                    v1 := int(src[i1+2])
-                    uAvg := byte((u0 + u1) >> 1)
-                    vAvg := byte((v0 + v1) >> 1)
-                    uPlane[(row/2)*halfW+cx] = uAvg
-                    vPlane[(row/2)*halfW+cx] = vAvg
-                }
-            } else {
-                // Last row (odd height shouldn't happen); just copy chroma from this row
-                for cx := 0; cx < halfW; cx++ {
-                    i0 := srcOff + cx*4
-                    u0 := src[i0+0]
-                    v0 := src[i0+2]
-                    uPlane[(row/2)*halfW+cx] = u0
-                    vPlane[(row/2)*halfW+cx] = v0
-                }
+        if row&1 != 0 { continue }
+        ci := (row / 2) * halfW
+        if row+1 < h {
+            next := srcOff + stride
+            for cx := 0; cx < halfW; cx++ {
+                i0, i1 := srcOff+cx*4, next+cx*4
+                uPlane[ci+cx] = byte((int(src[i0]) + int(src[i1])) >> 1)
+                vPlane[ci+cx] = byte((int(src[i0+2]) + int(src[i1+2])) >> 1)
+            }
+        } else {
+            for cx := 0; cx < halfW; cx++ {
+                i0 := srcOff + cx*4
+                uPlane[ci+cx] = src[i0]
+                vPlane[ci+cx] = src[i0+2]
             }
         }
     }
@@ -0,0 +1,83 @@
+package stream
+
+import (
+    "fmt"
+    "math/bits"
+    "strconv"
+    "strings"
+)
+
+// VP9TilesAuto picks the tile columns from the frame width.
+const VP9TilesAuto = -1
+
+// VP9Tuning is the speed and threading controls a VP9 encoder is opened
+// with. libvpx's own defaults (cpu-used 0, one thread per frame) encode
+// 1080p far below realtime; DefaultVP9Tuning is set for live encoding.
+type VP9Tuning struct {
+    Speed       int  // cpu-used, 0..9; higher is faster and coarser
+    RowMT       bool // encode rows of a tile on several threads
+    TileColumns int  // log2 of the tile columns, 0..6, or VP9TilesAuto
+    AQMode      int  // adaptive quantization: 0 off, 1 variance, 2 complexity, 3 cyclic refresh
+}
+
+// DefaultVP9Tuning is cpu-used 8, row-mt on, tile columns by width and
+// cyclic refresh AQ, the usual realtime settings.
+func DefaultVP9Tuning() VP9Tuning {
+    return VP9Tuning{Speed: 8, RowMT: true, TileColumns: VP9TilesAuto, AQMode: 3}
+}
+
+// ParseVP9Tuning reads speed (0..9), rowmt (on or off), tiles (0..6 or
+// auto) and aq (0..3) over base; "" keeps base's.
+func ParseVP9Tuning(base VP9Tuning, speed, rowmt, tiles, aq string) (VP9Tuning, error) {
+    t := base
+    if speed != "" {
+        n, err := strconv.Atoi(speed)
+        if err != nil || n < 0 || n > 9 { return base, fmt.Errorf("vp9 speed must be 0..9") }
+        t.Speed = n
+    }
+    switch strings.ToLower(rowmt) {
+    case "":
+    case "on", "1", "true":
+        t.RowMT = true
+    case "off", "0", "false":
+        t.RowMT = false
+    default:
+        return base, fmt.Errorf("vp9 row-mt must be on or off")
+    }
+    if tiles != "" {
+        if strings.EqualFold(tiles, "auto") {
+            t.TileColumns = VP9TilesAuto
+        } else if n, err := strconv.Atoi(tiles); err == nil && n >= 0 && n <= 6 {
+            t.TileColumns = n
+        } else {
+            return base, fmt.Errorf("vp9 tiles must be 0..6 or auto")
+        }
+    }
+    if aq != "" {
+        n, err := strconv.Atoi(aq)
+        if err != nil || n < 0 || n > 3 { return base, fmt.Errorf("vp9 aq must be 0..3") }
+        t.AQMode = n
+    }
+    return t, nil
+}
+
+// String is e.g. "speed=8,rowmt=on,tiles=auto,aq=3".
+func (t VP9Tuning) String() string {
+    rowmt, tiles := "off", "auto"
+    if t.RowMT { rowmt = "on" }
+    if t.TileColumns != VP9TilesAuto { tiles = strconv.Itoa(t.TileColumns) }
+    return fmt.Sprintf("speed=%d,rowmt=%s,tiles=%s,aq=%d", t.Speed, rowmt, tiles, t.AQMode)
+}
+
+// tileColumnsFor is the log2 tile columns for a w wide frame.
+func (t VP9Tuning) tileColumnsFor(w int) int {
+    if t.TileColumns != VP9TilesAuto { return t.TileColumns }
+    return autoTileColumns(w)
+}
+
+// autoTileColumns is the log2 tile columns for a w wide frame: as many as
+// keep each column at least 256 pixels wide, VP9's minimum, up to 64.
+func autoTileColumns(w int) int {
+    if w < 512 { return 0 }
+    return min(bits.Len(uint(w/256))-1, 6)
+}
@@ -0,0 +1,35 @@
+//go:build cgo && vpx
+
+package stream
+
+import "testing"
+
+// BenchmarkVP9Tuning encodes 1080p30 with libvpx's defaults for every
+// control, as VP9 encoders were opened before they were tuned, and with
+// DefaultVP9Tuning. -bench-vp9 makes the same comparison without the test
+// binary, on the settings the server would use.
+func BenchmarkVP9Tuning(b *testing.B) {
+    const w, h = 1920, 1080
+    cfg := PipelineConfig{Width: w, Height: h, FPS: IntRate(30), BitrateKbps: 6000}
+    frames := benchFrames(w, h, cfg.FPS, 60)
+    for _, bm := range []struct {
+        name string
+        open openEncoder
+    }{
+        {"default", openVP9Untuned},
+        {"tuned", openVP9},
+    } {
+        b.Run(bm.name, func(b *testing.B) {
+            enc, err := bm.open(cfg, cfg.BitrateKbps)
+            if err != nil { b.Fatal(err) }
+            defer enc.Close()
+            b.SetBytes(int64(w * h * 3 / 2))
+            b.ResetTimer()
+            for i := 0; i < b.N; i++ {
+                f := frames[i%len(frames)]
+                if _, _, err := enc.EncodeI420(f.Y, f.U, f.V); err != nil { b.Fatal(err) }
+            }
+            b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "frames/s")
+        })
+    }
+}
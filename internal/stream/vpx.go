@@ -16,6 +16,12 @@ static int set_vp8_static_threshold(vpx_codec_ctx_t *ctx, int v) { return vpx_co
 static int set_vp8_token_partitions(vpx_codec_ctx_t *ctx, int v) { return vpx_codec_control(ctx, VP8E_SET_TOKEN_PARTITIONS, v); }
 static int set_vp8_noise_sensitivity(vpx_codec_ctx_t *ctx, int v) { return vpx_codec_control(ctx, VP8E_SET_NOISE_SENSITIVITY, v); }
 static int set_vp8_sharpness(vpx_codec_ctx_t *ctx, int v) { return vpx_codec_control(ctx, VP8E_SET_SHARPNESS, v); }
+static int set_vpx_cq_level(vpx_codec_ctx_t *ctx, int v) { return vpx_codec_control(ctx, VP8E_SET_CQ_LEVEL, v); }
+static int set_vp9_cpuused(vpx_codec_ctx_t *ctx, int v) { return vpx_codec_control(ctx, VP8E_SET_CPUUSED, v); }
+static int set_vp9_row_mt(vpx_codec_ctx_t *ctx, int v) { return vpx_codec_control(ctx, VP9E_SET_ROW_MT, v); }
+static int set_vp9_tile_columns(vpx_codec_ctx_t *ctx, int v) { return vpx_codec_control(ctx, VP9E_SET_TILE_COLUMNS, v); }
+static int set_vp9_aq_mode(vpx_codec_ctx_t *ctx, int v) { return vpx_codec_control(ctx, VP9E_SET_AQ_MODE, v); }
+static int set_vp9_color_range(vpx_codec_ctx_t *ctx, int v) { return vpx_codec_control(ctx, VP9E_SET_COLOR_RANGE, v); }
 
 static vpx_codec_iface_t* vpx_iface_vp8() { return vpx_codec_vp8_cx(); }
 static vpx_codec_iface_t* vpx_iface_vp9() { return vpx_codec_vp9_cx(); }
@@ -42,31 +48,39 @@ type VP8Encoder struct {
     cfg   C.vpx_codec_enc_cfg_t
     img   *C.vpx_image_t
     w, h  int
-    fps   int
+    maxW, maxH int // size at init; Reconfigure can't go past it
+    fps   Rate
     pts   C.vpx_codec_pts_t
     open  bool
+    force bool // next frame is encoded as a keyframe
+    lag   encodeLag
+    last  encodeResult
 }
 
 type VP8Config struct {
     Width, Height int
-    FPS           int
+    FPS           Rate
     BitrateKbps   int // target bitrate
     Speed         int // cpu_used (0..8)
     Dropframe     int // rc_dropframe_thresh
+    Keyint        int // kf_max_dist; 0 for four seconds' worth
+    RateControl   string // RateCQ for constrained quality, else CBR
+    CRF           int    // cq_level in CQ mode
 }
 
 func NewVP8Encoder(cfg VP8Config) (*VP8Encoder, error) {
-    if cfg.Width <= 0 || cfg.Height <= 0 || cfg.FPS <= 0 {
+    if cfg.Width <= 0 || cfg.Height <= 0 || !cfg.FPS.Valid() {
         return nil, errors.New("invalid VP8 encoder config")
     }
-    e := &VP8Encoder{w: cfg.Width, h: cfg.Height, fps: cfg.FPS}
+    e := &VP8Encoder{w: cfg.Width, h: cfg.Height, maxW: cfg.Width, maxH: cfg.Height, fps: cfg.FPS}
     if C.vpx_codec_enc_config_default(C.vpx_iface_vp8(), &e.cfg, 0) != C.VPX_CODEC_OK {
         return nil, errors.New("vpx_codec_enc_config_default failed")
     }
     e.cfg.g_w = C.uint(cfg.Width)
     e.cfg.g_h = C.uint(cfg.Height)
-    e.cfg.g_timebase.num = 1
-    e.cfg.g_timebase.den = C.int(cfg.FPS)
+    // Timebase is one frame period, so pts can advance by 1 per frame
+    e.cfg.g_timebase.num = C.int(cfg.FPS.Den)
+    e.cfg.g_timebase.den = C.int(cfg.FPS.Num)
     if cfg.BitrateKbps > 0 {
         e.cfg.rc_target_bitrate = C.uint(cfg.BitrateKbps)
     }
@@ -76,6 +90,8 @@ func NewVP8Encoder(cfg VP8Config) (*VP8Encoder, error) {
     threads := runtime.NumCPU(); if threads < 1 { threads = 1 }; if threads > 16 { threads = 16 }
     e.cfg.g_threads = C.uint(threads)
     e.cfg.rc_end_usage = C.VPX_CBR
+    // Constrained quality: the bitrate is only a cap
+    if cfg.RateControl == RateCQ { e.cfg.rc_end_usage = C.VPX_CQ }
     // Allow dropping frames under sustained overload
     if cfg.Dropframe > 0 { e.cfg.rc_dropframe_thresh = C.uint(cfg.Dropframe) } else { e.cfg.rc_dropframe_thresh = C.uint(0) }
     // Zero-latency pipeline
@@ -83,7 +99,7 @@ func NewVP8Encoder(cfg VP8Config) (*VP8Encoder, error) {
     // Space keyframes to reduce spikes
     e.cfg.kf_mode = C.VPX_KF_AUTO
     e.cfg.kf_min_dist = 0
-    e.cfg.kf_max_dist = C.uint(cfg.FPS * 4)
+    e.cfg.kf_max_dist = C.uint(EffectiveKeyint("vp8", cfg.Keyint, cfg.FPS))
 
     if st := C.vpx_codec_enc_init_ver(&e.ctx, C.vpx_iface_vp8(), &e.cfg, 0, C.VPX_ENCODER_ABI_VERSION); st != C.VPX_CODEC_OK {
         // Try to extract detailed error message from context
@@ -91,13 +107,14 @@ func NewVP8Encoder(cfg VP8Config) (*VP8Encoder, error) {
         // Some detail may be available on the context even on init failure
         more := C.GoString(C.vpx_codec_error_detail(&e.ctx))
         if more != "" { errStr = fmt.Sprintf("%s: %s", errStr, more) }
-        return nil, fmt.Errorf("vpx_codec_enc_init_ver failed (%dx%d@%dfps, %dkbps): %s", cfg.Width, cfg.Height, cfg.FPS, cfg.BitrateKbps, errStr)
+        return nil, fmt.Errorf("vpx_codec_enc_init_ver failed (%dx%d@%sfps, %dkbps): %s", cfg.Width, cfg.Height, cfg.FPS, cfg.BitrateKbps, errStr)
     }
     // Apply speed-focused controls
     spd := cfg.Speed
     if spd < 0 { spd = 0 }
     if spd > 8 { spd = 8 }
     _ = C.set_vp8_cpuused(&e.ctx, C.int(spd))
+    if cfg.RateControl == RateCQ { _ = C.set_vpx_cq_level(&e.ctx, C.int(cqLevel(cfg.CRF))) }
     // Use maximum token partitions when supported (3 == VP8_EIGHT_TOKENPARTITIONS)
     _ = C.set_vp8_token_partitions(&e.ctx, C.int(3))
     _ = C.set_vp8_static_threshold(&e.ctx, 100)
@@ -109,6 +126,7 @@ func NewVP8Encoder(cfg VP8Config) (*VP8Encoder, error) {
         e.Close()
         return nil, errors.New("vpx_img_alloc failed")
     }
+    e.lag.lag = int(e.cfg.g_lag_in_frames)
     e.open = true
     return e, nil
 }
@@ -148,27 +166,54 @@ func (e *VP8Encoder) EncodeI420(y, u, v []byte) (out [][]byte, keyframe bool, er
     }
 
     flags := C.vpx_enc_frame_flags_t(0)
+    if e.force { flags |= C.VPX_EFLAG_FORCE_KF; e.force = false }
     // Real-time deadline
     if C.vpx_codec_encode(&e.ctx, e.img, e.pts, 1, flags, C.VPX_DL_REALTIME) != C.VPX_CODEC_OK {
         return nil, false, errors.New("vpx_codec_encode failed")
     }
     e.pts++
+    out, keyframe, shown := vpxDrain(&e.ctx)
+    e.last = e.lag.account(shown)
+    return out, keyframe, nil
+}
 
-    var iter C.vpx_codec_iter_t
-    for {
-        pkt := C.vpx_codec_get_cx_data(&e.ctx, &iter)
-        if pkt == nil { break }
-        if pkt.kind != C.VPX_CODEC_CX_FRAME_PKT { continue }
-        f := (*C.vpx_codec_cx_pkt_t)(unsafe.Pointer(pkt))
-        var frameData C.frame_data_t
-        // Copy the frame data struct to avoid direct union access
-        C.memcpy(unsafe.Pointer(&frameData), unsafe.Pointer(&f.data), C.size_t(unsafe.Sizeof(frameData)))
-        // Now we can safely access the frame data
-        goBytes := C.GoBytes(frameData.buf, C.int(frameData.sz))
-        out = append(out, goBytes)
-        keyframe = keyframe || (frameData.flags&C.VPX_FRAME_IS_KEY) != 0
+// Flush signals end of stream and returns the packets the encoder still
+// holds. Realtime VP8 has no lag so this is usually empty. No frames may be
+// encoded after it; Close must still be called.
+func (e *VP8Encoder) Flush() ([][]byte, error) {
+    if !e.open { return nil, nil }
+    return vpxFlush(&e.ctx)
+}
+
+// ForceKeyframe makes the next EncodeI420 produce a keyframe. Not safe for
+// concurrent use with EncodeI420; pipelines call it from their encode loop.
+func (e *VP8Encoder) ForceKeyframe() { e.force = true }
+
+// lastResult reports whether the last EncodeI420 with no output was held
+// back or dropped by rate control.
+func (e *VP8Encoder) lastResult() encodeResult { return e.last }
+
+// SetBitrate changes rc_target_bitrate in place, without reinitializing.
+func (e *VP8Encoder) SetBitrate(kbps int) error {
+    if !e.open { return errors.New("encoder closed") }
+    e.cfg.rc_target_bitrate = C.uint(kbps)
+    if C.vpx_codec_enc_config_set(&e.ctx, &e.cfg) != C.VPX_CODEC_OK {
+        return errors.New("vpx_codec_enc_config_set failed")
     }
-    return out, keyframe, nil
+    return nil
+}
+
+// Reconfigure switches the live encoder to a new frame size and, if kbps > 0,
+// bitrate, keeping its state and the stream going. The next frame is a
+// keyframe. Sizes larger than the one the encoder was created with fail;
+// the caller then needs a new encoder.
+func (e *VP8Encoder) Reconfigure(w, h, kbps int) error {
+    if !e.open { return errors.New("encoder closed") }
+    img, err := vpxReconfigure(&e.ctx, &e.cfg, e.maxW, e.maxH, w, h, kbps)
+    if err != nil { return err }
+    C.vpx_img_free(e.img)
+    e.img, e.w, e.h, e.force = img, w, h, true
+    return nil
 }
 
 func (e *VP8Encoder) Close() {
@@ -183,48 +228,73 @@ type VP9Encoder struct {
     cfg   C.vpx_codec_enc_cfg_t
     img   *C.vpx_image_t
     w, h  int
-    fps   int
+    maxW, maxH int // size at init; Reconfigure can't go past it
+    fps   Rate
     pts   C.vpx_codec_pts_t
     open  bool
+    force bool // next frame is encoded as a keyframe
+    lag   encodeLag
+    last  encodeResult
 }
 
 type VP9Config struct {
     Width, Height int
-    FPS           int
+    FPS           Rate
     BitrateKbps   int
+    Keyint        int // kf_max_dist; 0 for libvpx's default
+    RateControl   string // RateCQ for constrained quality, else CBR
+    CRF           int    // cq_level in CQ mode
+    Tuning        *VP9Tuning // speed and threading; nil leaves libvpx's defaults
 }
 
 func NewVP9Encoder(cfg VP9Config) (*VP9Encoder, error) {
-    if cfg.Width <= 0 || cfg.Height <= 0 || cfg.FPS <= 0 {
+    if cfg.Width <= 0 || cfg.Height <= 0 || !cfg.FPS.Valid() {
         return nil, errors.New("invalid VP9 encoder config")
     }
-    e := &VP9Encoder{w: cfg.Width, h: cfg.Height, fps: cfg.FPS}
+    e := &VP9Encoder{w: cfg.Width, h: cfg.Height, maxW: cfg.Width, maxH: cfg.Height, fps: cfg.FPS}
     if C.vpx_codec_enc_config_default(C.vpx_iface_vp9(), &e.cfg, 0) != C.VPX_CODEC_OK {
         return nil, errors.New("vpx_codec_enc_config_default failed")
     }
     e.cfg.g_w = C.uint(cfg.Width)
     e.cfg.g_h = C.uint(cfg.Height)
-    e.cfg.g_timebase.num = 1
-    e.cfg.g_timebase.den = C.int(cfg.FPS)
+    // Timebase is one frame period, so pts can advance by 1 per frame
+    e.cfg.g_timebase.num = C.int(cfg.FPS.Den)
+    e.cfg.g_timebase.den = C.int(cfg.FPS.Num)
     if cfg.BitrateKbps > 0 {
         e.cfg.rc_target_bitrate = C.uint(cfg.BitrateKbps)
     }
     e.cfg.g_pass = C.VPX_RC_ONE_PASS
     e.cfg.g_threads = 4
+    // Tiles and row-mt only pay off with a thread for each
+    if cfg.Tuning != nil { e.cfg.g_threads = C.uint(min(max(runtime.NumCPU(), 1), 16)) }
     e.cfg.rc_end_usage = C.VPX_CBR
+    if cfg.RateControl == RateCQ { e.cfg.rc_end_usage = C.VPX_CQ }
     e.cfg.kf_mode = C.VPX_KF_AUTO
+    if cfg.Keyint > 0 { e.cfg.kf_max_dist = C.uint(cfg.Keyint) }
 
     if st := C.vpx_codec_enc_init_ver(&e.ctx, C.vpx_iface_vp9(), &e.cfg, 0, C.VPX_ENCODER_ABI_VERSION); st != C.VPX_CODEC_OK {
         errStr := C.GoString(C.vpx_codec_err_to_string(st))
         more := C.GoString(C.vpx_codec_error_detail(&e.ctx))
         if more != "" { errStr = fmt.Sprintf("%s: %s", errStr, more) }
-        return nil, fmt.Errorf("vpx_codec_enc_init_ver failed (%dx%d@%dfps, %dkbps): %s", cfg.Width, cfg.Height, cfg.FPS, cfg.BitrateKbps, errStr)
+        return nil, fmt.Errorf("vpx_codec_enc_init_ver failed (%dx%d@%sfps, %dkbps): %s", cfg.Width, cfg.Height, cfg.FPS, cfg.BitrateKbps, errStr)
     }
+    if cfg.RateControl == RateCQ { _ = C.set_vpx_cq_level(&e.ctx, C.int(cqLevel(cfg.CRF))) }
+    if t := cfg.Tuning; t != nil {
+        rowmt := 0
+        if t.RowMT { rowmt = 1 }
+        _ = C.set_vp9_cpuused(&e.ctx, C.int(t.Speed))
+        _ = C.set_vp9_row_mt(&e.ctx, C.int(rowmt))
+        _ = C.set_vp9_tile_columns(&e.ctx, C.int(t.tileColumnsFor(cfg.Width)))
+        _ = C.set_vp9_aq_mode(&e.ctx, C.int(t.AQMode))
+    }
+    // Tell decoders the frames are full range (encodeFuncFor delivers YUV_RANGE)
+    if YUVRange() == RangeFull { _ = C.set_vp9_color_range(&e.ctx, C.int(1)) }
     e.img = C.vpx_img_alloc(nil, C.VPX_IMG_FMT_I420, C.uint(e.w), C.uint(e.h), 1)
     if e.img == nil {
         e.Close()
         return nil, errors.New("vpx_img_alloc failed")
     }
+    e.lag.lag = int(e.cfg.g_lag_in_frames)
     e.open = true
     return e, nil
 }
@@ -258,26 +328,108 @@ func (e *VP9Encoder) EncodeI420(y, u, v []byte) (out [][]byte, keyframe bool, er
     }
 
     flags := C.vpx_enc_frame_flags_t(0)
+    if e.force { flags |= C.VPX_EFLAG_FORCE_KF; e.force = false }
     if C.vpx_codec_encode(&e.ctx, e.img, e.pts, 1, flags, C.VPX_DL_REALTIME) != C.VPX_CODEC_OK {
         return nil, false, errors.New("vpx_codec_encode failed")
     }
     e.pts++
+    out, keyframe, shown := vpxDrain(&e.ctx)
+    e.last = e.lag.account(shown)
+    return out, keyframe, nil
+}
+
+// Flush signals end of stream and returns the packets still held back by
+// lag-in-frames or alt-ref encoding. No frames may be encoded after it.
+func (e *VP9Encoder) Flush() ([][]byte, error) {
+    if !e.open { return nil, nil }
+    return vpxFlush(&e.ctx)
+}
+
+// ForceKeyframe makes the next EncodeI420 produce a keyframe.
+func (e *VP9Encoder) ForceKeyframe() { e.force = true }
+
+// lastResult reports whether the last EncodeI420 with no output was held
+// back or dropped by rate control.
+func (e *VP9Encoder) lastResult() encodeResult { return e.last }
+
+// SetBitrate changes rc_target_bitrate in place, without reinitializing.
+func (e *VP9Encoder) SetBitrate(kbps int) error {
+    if !e.open { return errors.New("encoder closed") }
+    e.cfg.rc_target_bitrate = C.uint(kbps)
+    if C.vpx_codec_enc_config_set(&e.ctx, &e.cfg) != C.VPX_CODEC_OK {
+        return errors.New("vpx_codec_enc_config_set failed")
+    }
+    return nil
+}
+
+// Reconfigure switches the live encoder to a new frame size and, if kbps > 0,
+// bitrate, keeping its state and the stream going. The next frame is a
+// keyframe. Sizes larger than the one the encoder was created with fail;
+// the caller then needs a new encoder.
+func (e *VP9Encoder) Reconfigure(w, h, kbps int) error {
+    if !e.open { return errors.New("encoder closed") }
+    img, err := vpxReconfigure(&e.ctx, &e.cfg, e.maxW, e.maxH, w, h, kbps)
+    if err != nil { return err }
+    C.vpx_img_free(e.img)
+    e.img, e.w, e.h, e.force = img, w, h, true
+    return nil
+}
+
+func (e *VP9Encoder) Close() {
+    if e.img != nil { C.vpx_img_free(e.img); e.img = nil }
+    if e.open { C.vpx_codec_destroy(&e.ctx); e.open = false }
+}
+
+// vpxReconfigure applies a new size and bitrate with vpx_codec_enc_config_set
+// and allocates an image of the new size. cfg is left unchanged on failure.
+func vpxReconfigure(ctx *C.vpx_codec_ctx_t, cfg *C.vpx_codec_enc_cfg_t, maxW, maxH, w, h, kbps int) (*C.vpx_image_t, error) {
+    if w <= 0 || h <= 0 { return nil, fmt.Errorf("invalid size %dx%d", w, h) }
+    if w > maxW || h > maxH {
+        return nil, fmt.Errorf("%dx%d exceeds the initial %dx%d", w, h, maxW, maxH)
+    }
+    img := C.vpx_img_alloc(nil, C.VPX_IMG_FMT_I420, C.uint(w), C.uint(h), 1)
+    if img == nil { return nil, errors.New("vpx_img_alloc failed") }
+    next := *cfg
+    next.g_w, next.g_h = C.uint(w), C.uint(h)
+    if kbps > 0 { next.rc_target_bitrate = C.uint(kbps) }
+    if C.vpx_codec_enc_config_set(ctx, &next) != C.VPX_CODEC_OK {
+        C.vpx_img_free(img)
+        return nil, fmt.Errorf("vpx_codec_enc_config_set failed: %s", C.GoString(C.vpx_codec_error_detail(ctx)))
+    }
+    *cfg = next
+    return img, nil
+}
+
+// vpxDrain copies out the compressed frames of the last vpx_codec_encode
+// call. shown counts the packets holding a displayed frame; a VP9 alt-ref
+// is output early but invisible.
+func vpxDrain(ctx *C.vpx_codec_ctx_t) (out [][]byte, keyframe bool, shown int) {
     var iter C.vpx_codec_iter_t
     for {
-        pkt := C.vpx_codec_get_cx_data(&e.ctx, &iter)
+        pkt := C.vpx_codec_get_cx_data(ctx, &iter)
         if pkt == nil { break }
         if pkt.kind != C.VPX_CODEC_CX_FRAME_PKT { continue }
         f := (*C.vpx_codec_cx_pkt_t)(unsafe.Pointer(pkt))
         var frameData C.frame_data_t
+        // Copy the frame data struct to avoid direct union access
         C.memcpy(unsafe.Pointer(&frameData), unsafe.Pointer(&f.data), C.size_t(unsafe.Sizeof(frameData)))
-        goBytes := C.GoBytes(frameData.buf, C.int(frameData.sz))
-        out = append(out, goBytes)
+        out = append(out, C.GoBytes(frameData.buf, C.int(frameData.sz)))
         keyframe = keyframe || (frameData.flags&C.VPX_FRAME_IS_KEY) != 0
+        if frameData.flags&C.VPX_FRAME_IS_INVISIBLE == 0 { shown++ }
     }
-    return out, keyframe, nil
+    return out, keyframe, shown
 }
 
-func (e *VP9Encoder) Close() {
-    if e.img != nil { C.vpx_img_free(e.img); e.img = nil }
-    if e.open { C.vpx_codec_destroy(&e.ctx); e.open = false }
+// vpxFlush encodes NULL images, the documented end-of-stream signal, until
+// the encoder stops returning packets.
+func vpxFlush(ctx *C.vpx_codec_ctx_t) ([][]byte, error) {
+    var out [][]byte
+    for {
+        if C.vpx_codec_encode(ctx, nil, 0, 1, 0, C.VPX_DL_REALTIME) != C.VPX_CODEC_OK {
+            return out, errors.New("vpx_codec_encode (flush) failed")
+        }
+        pkts, _, _ := vpxDrain(ctx)
+        if len(pkts) == 0 { return out, nil }
+        out = append(out, pkts...)
+    }
 }
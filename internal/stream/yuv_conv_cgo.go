@@ -15,12 +15,38 @@ import (
     "strings"
 )
 
-// BGRAtoI420 converts BGRA to I420 using libyuv (SIMD-accelerated).
+// BGRAtoI420 converts BGRA to I420 using libyuv (SIMD-accelerated), in the
+// color space YUV_MATRIX and YUV_RANGE select for the frame size.
 func BGRAtoI420(bgra []byte, w, h int, y, u, v []byte) {
+    BGRAtoI420Space(bgra, w, h, y, u, v, ColorSpace{})
+}
+
+// BGRAtoI420Space is BGRAtoI420 into color space cs, its defaults resolved
+// for the frame size. libyuv only converts RGB to BT.601: limited range in
+// every byte order, and full range (J420) from ARGB. Other color spaces
+// take the Go path, rows in parallel. That includes BT.709, which auto
+// picks from 720 lines up, so HD frames from RGB senders convert at Go
+// speed; BenchmarkBGRAtoI420Space measures the gap.
+func BGRAtoI420Space(bgra []byte, w, h int, y, u, v []byte, cs ColorSpace) {
     if w <= 0 || h <= 0 { return }
     if len(bgra) < w*h*4 || len(y) < w*h || len(u) < (w/2)*(h/2) || len(v) < (w/2)*(h/2) {
         return
     }
+    du, dv := u, v
+    if swapUV { du, dv = v, u }
+    switch k := coeffsFor(cs, w, h); {
+    case k == &bt601FullCoeffs && bgraOrder == "ARGB":
+        C.ARGBToJ420((*C.uint8_t)(&bgra[0]), C.int(w*4),
+            (*C.uint8_t)(&y[0]), C.int(w),
+            (*C.uint8_t)(&du[0]), C.int(w/2),
+            (*C.uint8_t)(&dv[0]), C.int(w/2),
+            C.int(w), C.int(h))
+        return
+    case k != &bt601Coeffs:
+        ri, gi, bi, _ := orderOffsets()
+        rgbToI420(bgra, w, h, y, du, dv, ri, gi, bi, k)
+        return
+    }
     switch bgraOrder {
     case "RGBA":
         if swapUV {
@@ -83,12 +109,18 @@ func BGRAtoI420(bgra []byte, w, h int, y, u, v []byte) {
 
 // UYVYtoI420 converts UYVY 4:2:2 to I420 using libyuv.
 func UYVYtoI420(src []byte, w, h int, yPlane, uPlane, vPlane []byte) {
-    if w <= 0 || h <= 0 { return }
-    if len(src) < w*h*2 || len(yPlane) < w*h || len(uPlane) < (w/2)*(h/2) || len(vPlane) < (w/2)*(h/2) {
+    UYVYtoI420Stride(src, w*2, w, h, yPlane, uPlane, vPlane)
+}
+
+// UYVYtoI420Stride is UYVYtoI420 for rows stride bytes apart, for senders
+// that pad their lines.
+func UYVYtoI420Stride(src []byte, stride, w, h int, yPlane, uPlane, vPlane []byte) {
+    if w <= 0 || h <= 0 || stride < w*2 { return }
+    if len(src) < stride*(h-1)+w*2 || len(yPlane) < w*h || len(uPlane) < (w/2)*(h/2) || len(vPlane) < (w/2)*(h/2) {
         return
     }
     C.UYVYToI420(
-        (*C.uint8_t)(&src[0]), C.int(w*2),
+        (*C.uint8_t)(&src[0]), C.int(stride),
         (*C.uint8_t)(&yPlane[0]), C.int(w),
         (*C.uint8_t)(&uPlane[0]), C.int(w/2),
         (*C.uint8_t)(&vPlane[0]), C.int(w/2),
@@ -96,22 +128,29 @@ func UYVYtoI420(src []byte, w, h int, yPlane, uPlane, vPlane []byte) {
     )
 }
 
-// I420Scale scales an I420 frame from (sw,sh) to (dw,dh) using libyuv.
+// defaultScaleFilter is libyuv's usual choice, for decent downscales.
+const defaultScaleFilter = ScaleBox
+
+// I420Scale scales an I420 frame from (sw,sh) to (dw,dh) using libyuv, with
+// the default filter.
 // If libyuv is not available, a pure-Go fallback will be used (see i420_scale_go.go).
 func I420Scale(ySrc, uSrc, vSrc []byte, sw, sh int, yDst, uDst, vDst []byte, dw, dh int) {
+    I420ScaleFilter(ySrc, uSrc, vSrc, sw, sh, yDst, uDst, vDst, dw, dh, "")
+}
+
+// I420ScaleFilter is I420Scale with filter, a Scale* constant or "" for
+// DefaultScaleFilter.
+func I420ScaleFilter(ySrc, uSrc, vSrc []byte, sw, sh int, yDst, uDst, vDst []byte, dw, dh int, filter string) {
     if sw <= 0 || sh <= 0 || dw <= 0 || dh <= 0 { return }
-    // Choose libyuv filter mode via env (default BOX for decent quality).
-    // Set YUV_SCALE_FILTER to one of: NONE, LINEAR, BILINEAR, BOX
+    if filter == "" { filter = DefaultScaleFilter() }
     var fm uint32
-    switch getYUVScaleFilter() {
-    case "NONE":
+    switch filter {
+    case ScaleNone:
         fm = uint32(C.kFilterNone)
-    case "LINEAR":
+    case ScaleLinear:
         fm = uint32(C.kFilterLinear)
-    case "BILINEAR":
+    case ScaleBilinear:
         fm = uint32(C.kFilterBilinear)
-    case "BOX", "":
-        fm = uint32(C.kFilterBox)
     default:
         fm = uint32(C.kFilterBox)
     }
@@ -143,27 +182,80 @@ var bgraOrder = func() string {
     }
 }()
 
+// orderOffsets returns the byte offsets of red, green, blue and alpha in a
+// pixel of bgraOrder (libyuv names the 32-bit word, so ARGB is B,G,R,A in
+// memory).
+func orderOffsets() (ri, gi, bi, ai int) {
+    switch bgraOrder {
+    case "RGBA": return 3, 2, 1, 0
+    case "ABGR": return 0, 1, 2, 3
+    case "BGRA": return 1, 2, 3, 0
+    }
+    return 2, 1, 0, 3
+}
+
 var swapUV = func() bool {
     v := strings.TrimSpace(os.Getenv("YUV_SWAP_UV"))
     return v == "1" || strings.EqualFold(v, "true") || strings.EqualFold(v, "yes")
 }()
 
-// yuvScaleFilter controls libyuv scaling filter; empty or unknown -> BOX (default).
-func getYUVScaleFilter() string {
-    v := strings.ToUpper(strings.TrimSpace(os.Getenv("YUV_SCALE_FILTER")))
-    switch v {
-    case "NONE", "LINEAR", "BILINEAR", "BOX":
-        return v
-    case "":
-        return "BOX"
-    default:
-        return "BOX"
+// I420Orient mirrors a w x h I420 frame left to right if mirror is set,
+// then rotates it clockwise by rotate degrees (0, 90, 180 or 270) into the
+// d* planes, h x w for 90 and 270, using libyuv's I420Mirror and
+// I420Rotate. A mirrored rotation goes through a pooled scratch frame.
+func I420Orient(y, u, v []byte, w, h int, dy, du, dv []byte, rotate int, mirror bool) {
+    if w <= 0 || h <= 0 { return }
+    if mirror {
+        if rotate == 0 { i420Mirror(y, u, v, w, h, dy, du, dv); return }
+        c := (w / 2) * (h / 2)
+        buf := getBuf(w*h + 2*c)
+        defer putBuf(buf)
+        ty, tu, tv := buf[:w*h], buf[w*h:w*h+c], buf[w*h+c:]
+        i420Mirror(y, u, v, w, h, ty, tu, tv)
+        y, u, v = ty, tu, tv
+    }
+    mode, dw := C.enum_RotationMode(C.kRotate0), w
+    switch rotate {
+    case 90:
+        mode, dw = C.enum_RotationMode(C.kRotate90), h
+    case 180:
+        mode = C.enum_RotationMode(C.kRotate180)
+    case 270:
+        mode, dw = C.enum_RotationMode(C.kRotate270), h
     }
+    C.I420Rotate(
+        (*C.uint8_t)(&y[0]), C.int(w),
+        (*C.uint8_t)(&u[0]), C.int(w/2),
+        (*C.uint8_t)(&v[0]), C.int(w/2),
+        (*C.uint8_t)(&dy[0]), C.int(dw),
+        (*C.uint8_t)(&du[0]), C.int(dw/2),
+        (*C.uint8_t)(&dv[0]), C.int(dw/2),
+        C.int(w), C.int(h),
+        mode,
+    )
+}
+
+func i420Mirror(y, u, v []byte, w, h int, dy, du, dv []byte) {
+    C.I420Mirror(
+        (*C.uint8_t)(&y[0]), C.int(w),
+        (*C.uint8_t)(&u[0]), C.int(w/2),
+        (*C.uint8_t)(&v[0]), C.int(w/2),
+        (*C.uint8_t)(&dy[0]), C.int(w),
+        (*C.uint8_t)(&du[0]), C.int(w/2),
+        (*C.uint8_t)(&dv[0]), C.int(w/2),
+        C.int(w), C.int(h),
+    )
 }
 
 // I420ToBGRA converts I420 planes to packed 32-bit BGRA-like buffers according to YUV_BGRA_ORDER.
 // Uses libyuv for speed. Respects YUV_SWAP_UV when converting.
 func I420ToBGRA(y, u, v []byte, w, h int, out []byte) {
+    I420ToBGRASpace(y, u, v, w, h, out, ColorSpace{})
+}
+
+// I420ToBGRASpace is I420ToBGRA from color space cs, its defaults resolved
+// for the frame size.
+func I420ToBGRASpace(y, u, v []byte, w, h int, out []byte, cs ColorSpace) {
     if w <= 0 || h <= 0 { return }
     if len(y) < w*h || len(u) < (w/2)*(h/2) || len(v) < (w/2)*(h/2) || len(out) < w*h*4 { return }
     // Select appropriate converter by desired output order
@@ -173,14 +265,23 @@ func I420ToBGRA(y, u, v []byte, w, h int, out []byte) {
     if swapUV {
         uptr, vptr = vptr, uptr
     }
+    // The Yvu constants are for U and V passed swapped, which turns the
+    // ARGB and RGBA writers into ABGR and BGRA ones
+    yuv, yvu := &C.kYuvI601Constants, &C.kYvuI601Constants
+    switch k := coeffsFor(cs, w, h); k {
+    case &bt709Coeffs: yuv, yvu = &C.kYuvH709Constants, &C.kYvuH709Constants
+    case &bt601FullCoeffs: yuv, yvu = &C.kYuvJPEGConstants, &C.kYvuJPEGConstants
+    case &bt709FullCoeffs: yuv, yvu = &C.kYuvF709Constants, &C.kYvuF709Constants
+    }
+    dst := (*C.uint8_t)(&out[0])
     switch bgraOrder {
     case "RGBA":
-        C.I420ToRGBA(yptr, C.int(w), uptr, C.int(w/2), vptr, C.int(w/2), (*C.uint8_t)(&out[0]), C.int(w*4), C.int(w), C.int(h))
+        C.I420ToRGBAMatrix(yptr, C.int(w), uptr, C.int(w/2), vptr, C.int(w/2), dst, C.int(w*4), yuv, C.int(w), C.int(h))
     case "ARGB":
-        C.I420ToARGB(yptr, C.int(w), uptr, C.int(w/2), vptr, C.int(w/2), (*C.uint8_t)(&out[0]), C.int(w*4), C.int(w), C.int(h))
+        C.I420ToARGBMatrix(yptr, C.int(w), uptr, C.int(w/2), vptr, C.int(w/2), dst, C.int(w*4), yuv, C.int(w), C.int(h))
     case "ABGR":
-        C.I420ToABGR(yptr, C.int(w), uptr, C.int(w/2), vptr, C.int(w/2), (*C.uint8_t)(&out[0]), C.int(w*4), C.int(w), C.int(h))
+        C.I420ToARGBMatrix(yptr, C.int(w), vptr, C.int(w/2), uptr, C.int(w/2), dst, C.int(w*4), yvu, C.int(w), C.int(h))
     default: // BGRA
-        C.I420ToBGRA(yptr, C.int(w), uptr, C.int(w/2), vptr, C.int(w/2), (*C.uint8_t)(&out[0]), C.int(w*4), C.int(w), C.int(h))
+        C.I420ToRGBAMatrix(yptr, C.int(w), vptr, C.int(w/2), uptr, C.int(w/2), dst, C.int(w*4), yvu, C.int(w), C.int(h))
     }
 }